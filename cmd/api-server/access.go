@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+const (
+	// defaultAuditPage is how many access log entries /audit returns when
+	// no limit is given
+	defaultAuditPage = 100
+	// maxAuditPage caps the limit a caller may ask /audit for
+	maxAuditPage = 1000
+)
+
+// AuditResponse is a page of the access log. NextBefore is the before
+// parameter that fetches the next page, and is omitted on the last one.
+type AuditResponse struct {
+	Entries    []metadata.AccessRecord `json:"entries"`
+	NextBefore int64                   `json:"next_before,omitempty"`
+}
+
+// recordAccess adds a request's action on a file to the access log, along
+// with the API client and address it came from
+func recordAccess(r *http.Request, fileID, action string, bytes int64) {
+	fileService.RecordAccess(metadata.AccessRecord{
+		FileID:     fileID,
+		Client:     clientName(r),
+		RemoteAddr: remoteHost(r),
+		Action:     action,
+		Bytes:      bytes,
+	})
+}
+
+// remoteHost returns the address a request came from, without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditHandler pages through the access log, newest first, optionally for
+// one file_id. limit sets the page size and before continues from an
+// earlier page's next_before.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	fileID := query.Get("file_id")
+	if fileID != "" {
+		if _, err := uuid.Parse(fileID); err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid file_id")
+			return
+		}
+	}
+
+	limit := defaultAuditPage
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxAuditPage {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditPage))
+			return
+		}
+		limit = parsed
+	}
+
+	var before int64
+	if value := query.Get("before"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid before")
+			return
+		}
+		before = parsed
+	}
+
+	entries, err := fileService.AccessLog(fileID, before, limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to read access log")
+		log.Printf("Database error reading access log: %v", err)
+		return
+	}
+
+	response := AuditResponse{Entries: entries}
+	if len(entries) == limit {
+		response.NextBefore = entries[len(entries)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
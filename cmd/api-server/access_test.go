@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// asClient attributes a request to the API client name, as authMiddleware
+// does once its token checks out
+func asClient(r *http.Request, name string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientKey, name))
+}
+
+// audit calls GET /audit with query and decodes the page it returns
+func audit(t *testing.T, query url.Values) AuditResponse {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	auditHandler(rec, httptest.NewRequest(http.MethodGet, "/audit?"+query.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /audit?%s: want 200, got %d: %s", query.Encode(), rec.Code, rec.Body)
+	}
+	var page AuditResponse
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+// TestAccessLog uploads, downloads and deletes a file as different clients
+// and checks /audit lists each action, newest first, with who made it and
+// the bytes it moved
+func TestAccessLog(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	data := randomBytes(t, 1000)
+
+	upload := func(client string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		uploadHandler(rec, asClient(multipartRequest(t, "/upload", nil, testFile{"report.pdf", data}), client))
+		return decodeUploadResult(t, rec).FileID
+	}
+	fileID := upload("alice")
+	other := upload("bob")
+
+	download := func(client, rangeHeader string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/download/"+fileID, nil)
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		downloadHandler(rec, asClient(mux.SetURLVars(r, map[string]string{"fileID": fileID}), client))
+		if rec.Code != http.StatusOK && rec.Code != http.StatusPartialContent {
+			t.Fatalf("download: want it served, got %d: %s", rec.Code, rec.Body)
+		}
+	}
+	download("bob", "")
+	download("carol", "bytes=0-99")
+
+	rec := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/files/"+fileID, nil), map[string]string{"fileID": fileID})
+	deleteFileHandler(rec, asClient(r, "alice"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	type entry struct {
+		client string
+		action string
+		bytes  int64
+	}
+	want := []entry{
+		{"alice", metadata.AccessDelete, 0},
+		{"carol", metadata.AccessDownload, 100},
+		{"bob", metadata.AccessDownload, int64(len(data))},
+		{"alice", metadata.AccessUpload, int64(len(data))},
+	}
+	page := audit(t, url.Values{"file_id": {fileID}})
+	if len(page.Entries) != len(want) || page.NextBefore != 0 {
+		t.Fatalf("want %d entries on one page, got %+v", len(want), page)
+	}
+	for i, record := range page.Entries {
+		got := entry{record.Client, record.Action, record.Bytes}
+		if got != want[i] {
+			t.Errorf("entry %d: want %+v, got %+v", i, want[i], got)
+		}
+		if record.FileID != fileID || record.RemoteAddr != "192.0.2.1" || record.At.IsZero() {
+			t.Errorf("entry %d: want the file, the client's address and a time, got %+v", i, record)
+		}
+	}
+
+	// Every file's entries, a page at a time
+	var pages [][]metadata.AccessRecord
+	query := url.Values{"limit": {"2"}}
+	for {
+		page := audit(t, query)
+		pages = append(pages, page.Entries)
+		if page.NextBefore == 0 {
+			break
+		}
+		query.Set("before", strconv.FormatInt(page.NextBefore, 10))
+	}
+	if len(pages) != 3 || len(pages[0]) != 2 || len(pages[1]) != 2 || len(pages[2]) != 1 {
+		t.Fatalf("want 5 entries in pages of 2, got %v", pages)
+	}
+	if pages[1][1].FileID != other || pages[2][0].FileID != fileID {
+		t.Errorf("want the pages to end with bob's upload then alice's, got %+v and %+v", pages[1][1], pages[2][0])
+	}
+	if pages[0][0].ID <= pages[0][1].ID || pages[0][1].ID <= pages[1][0].ID {
+		t.Error("want entries newest first")
+	}
+
+	for _, query := range []string{"file_id=report.pdf", "limit=0", "limit=1001", "limit=ten", "before=0", "before=-1"} {
+		rec := httptest.NewRecorder()
+		auditHandler(rec, httptest.NewRequest(http.MethodGet, "/audit?"+query, nil))
+		checkErrorResponse(t, rec, http.StatusBadRequest, codeBadRequest)
+	}
+}
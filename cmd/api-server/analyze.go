@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// analyzeHandler reports the dedup benefit of a file against what is
+// already stored, without storing it
+func analyzeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Failed to parse form")
+		return
+	}
+	defer removeMultipartFiles(r)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Failed to get file from form")
+		return
+	}
+	defer file.Close()
+
+	result, err := fileService.AnalyzeFile(file, service.UploadMetadata{
+		FileName: header.Filename,
+		Size:     header.Size,
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to analyze file")
+		log.Printf("Analysis of %s failed: %v", header.Filename, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
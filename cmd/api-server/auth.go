@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+)
+
+type contextKey string
+
+// clientKey holds the name of the API token a request authenticated with
+const clientKey contextKey = "client"
+
+// internalRoutes are called by storage nodes, which authenticate with the
+// cluster secret rather than an API token
+var internalRoutes = map[string]bool{
+	"/register":            true,
+	"/heartbeat":           true,
+	"/deregister":          true,
+	"/chunks/corrupt":      true,
+	"/chunks/unreferenced": true,
+}
+
+// adminRoutes act on the whole cluster and need the admin token. They are
+// matched on their route template, since some paths hold a chunk hash or
+// file ID.
+var adminRoutes = map[string]bool{
+	"/chunks/{hash}/release":     true,
+	"/chunks/{hash}/consistency": true,
+	"/chunks/verify":             true,
+	"/files/{fileID}/replicate":  true,
+	"/export":                    true,
+	"/import":                    true,
+	"/audit":                     true,
+	"/bandwidth":                 true,
+	"/maintenance":               true,
+}
+
+// isAdminRoute reports whether a request matched an admin route
+func isAdminRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	return err == nil && adminRoutes[template]
+}
+
+// parseAPITokens parses API_TOKENS, a comma-separated list of name:token
+// pairs, into a token -> client name map. A bare token is named by position.
+func parseAPITokens(value string) (map[string]string, error) {
+	tokens := make(map[string]string)
+
+	for i, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, token, found := strings.Cut(entry, ":")
+		if !found {
+			name, token = fmt.Sprintf("client%d", i+1), entry
+		}
+		if token == "" {
+			return nil, fmt.Errorf("empty token for client %q", name)
+		}
+		tokens[token] = name
+	}
+
+	return tokens, nil
+}
+
+// isPublic reports whether a route needs no authentication: /health,
+// /version, and share-link downloads, which their token authorizes
+func isPublic(path string) bool {
+	return path == "/health" || path == "/version" || strings.HasPrefix(path, "/s/")
+}
+
+// authMiddleware requires a bearer token on every route except public ones.
+// User routes accept any configured API token; internal routes accept the
+// cluster secret, plus a verified client certificate when requirePeerCert is
+// set. Either check is skipped when it has nothing configured, so auth stays
+// opt-in. Admin routes accept only the admin token, and stay open only while
+// neither it nor any API token is configured.
+func authMiddleware(apiTokens map[string]string, clusterSecret, adminToken string, requirePeerCert bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isPublic(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := auth.BearerToken(r)
+
+			if internalRoutes[r.URL.Path] {
+				if requirePeerCert && !auth.VerifiedPeer(r) {
+					writeJSONError(w, http.StatusUnauthorized, codeUnauthorized, "Client certificate required")
+					return
+				}
+				if clusterSecret != "" && !auth.Equal(token, clusterSecret) {
+					unauthorized(w)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if isAdminRoute(r) {
+				switch {
+				case adminToken != "":
+					if !auth.Equal(token, adminToken) {
+						unauthorized(w)
+						return
+					}
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey, "admin")))
+				case len(apiTokens) > 0:
+					writeJSONError(w, http.StatusForbidden, codeForbidden, "Admin routes are disabled; set ADMIN_TOKEN to enable them")
+				default:
+					next.ServeHTTP(w, r)
+				}
+				return
+			}
+
+			if len(apiTokens) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			name, ok := lookupToken(apiTokens, token)
+			if !ok {
+				unauthorized(w)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey, name)))
+		})
+	}
+}
+
+// lookupToken finds the client a token belongs to, comparing against every
+// configured token in constant time
+func lookupToken(apiTokens map[string]string, token string) (string, bool) {
+	var name string
+	found := false
+
+	for candidate, client := range apiTokens {
+		if auth.Equal(token, candidate) {
+			name, found = client, true
+		}
+	}
+
+	return name, found && token != ""
+}
+
+// clientName returns the API client that made the request, or "" when auth
+// is disabled
+func clientName(r *http.Request) string {
+	name, _ := r.Context().Value(clientKey).(string)
+	return name
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeJSONError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+}
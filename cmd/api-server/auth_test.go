@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// authRouter serves every given route with a 200 behind authMiddleware
+func authRouter(apiTokens map[string]string, adminToken string, routes ...string) *mux.Router {
+	router := mux.NewRouter()
+	for _, route := range routes {
+		router.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	router.Use(authMiddleware(apiTokens, "", adminToken, false))
+	return router
+}
+
+func TestChunkVerifyNeedsAdminToken(t *testing.T) {
+	router := authRouter(map[string]string{"user-token": "alice"}, "admin-token", "/chunks/verify")
+
+	for _, tc := range []struct {
+		target string
+		token  string
+		want   int
+	}{
+		{"/chunks/verify", "user-token", http.StatusUnauthorized},
+		{"/chunks/verify?repair=true", "user-token", http.StatusUnauthorized},
+		{"/chunks/verify?repair=true", "", http.StatusUnauthorized},
+		{"/chunks/verify?repair=true", "admin-token", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s with token %q: got %d, want %d", tc.target, tc.token, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestParseAPITokens(t *testing.T) {
+	tokens, err := parseAPITokens(" alice:token-a , token-b,,bob:token-c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"token-a": "alice", "token-b": "client2", "token-c": "bob"}
+	if len(tokens) != len(want) {
+		t.Fatalf("want %v, got %v", want, tokens)
+	}
+	for token, name := range want {
+		if tokens[token] != name {
+			t.Errorf("token %s: want client %q, got %q", token, name, tokens[token])
+		}
+	}
+
+	if _, err := parseAPITokens("alice:"); err == nil {
+		t.Error("want an empty token rejected")
+	}
+}
+
+// TestAuthMiddleware sends requests with and without valid tokens to user,
+// public, internal and admin routes
+func TestAuthMiddleware(t *testing.T) {
+	apiTokens := map[string]string{"token-a": "alice"}
+
+	for _, tc := range []struct {
+		name          string
+		apiTokens     map[string]string
+		clusterSecret string
+		path          string
+		token         string
+		want          int
+		wantClient    string
+	}{
+		{"auth off", nil, "", "/files", "", http.StatusOK, ""},
+		{"auth off admin", nil, "", "/export", "", http.StatusOK, ""},
+		{"no token", apiTokens, "", "/files", "", http.StatusUnauthorized, ""},
+		{"wrong token", apiTokens, "", "/files", "token-b", http.StatusUnauthorized, ""},
+		{"valid token", apiTokens, "", "/files", "token-a", http.StatusOK, "alice"},
+		{"health is public", apiTokens, "", "/health", "", http.StatusOK, ""},
+		{"version is public", apiTokens, "", "/version", "", http.StatusOK, ""},
+		{"share links are public", apiTokens, "", "/s/abc", "", http.StatusOK, ""},
+		{"internal without secret configured", apiTokens, "", "/heartbeat", "", http.StatusOK, ""},
+		{"internal with API token", apiTokens, "secret", "/heartbeat", "token-a", http.StatusUnauthorized, ""},
+		{"internal with cluster secret", apiTokens, "secret", "/heartbeat", "secret", http.StatusOK, ""},
+		{"cluster secret on user route", apiTokens, "secret", "/files", "secret", http.StatusUnauthorized, ""},
+		{"admin without admin token", apiTokens, "", "/export", "token-a", http.StatusForbidden, ""},
+		{"audit without admin token", apiTokens, "", "/audit", "token-a", http.StatusForbidden, ""},
+		{"bandwidth without admin token", apiTokens, "", "/bandwidth", "token-a", http.StatusForbidden, ""},
+		{"maintenance without admin token", apiTokens, "", "/maintenance", "token-a", http.StatusForbidden, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var client string
+			router := mux.NewRouter()
+			for _, route := range []string{"/files", "/export", "/audit", "/bandwidth", "/maintenance", "/health", "/version", "/s/{token}", "/heartbeat"} {
+				router.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) { client = clientName(r) })
+			}
+			router.Use(authMiddleware(tc.apiTokens, tc.clusterSecret, "", false))
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.want {
+				t.Fatalf("want %d, got %d", tc.want, rec.Code)
+			}
+			if tc.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Error("want a Bearer challenge with the 401")
+			}
+			if client != tc.wantClient {
+				t.Errorf("want the request attributed to %q, got %q", tc.wantClient, client)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// bandwidthLimit is the body of /bandwidth: the cap on background transfers
+// between nodes, 0 for none
+type bandwidthLimit struct {
+	BytesPerSec int64 `json:"bytes_per_sec"`
+}
+
+// bandwidthHandler reports the background transfer cap. It is an admin route.
+func bandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bandwidthLimit{BytesPerSec: fileService.BackgroundBandwidth()})
+}
+
+// setBandwidthHandler changes the background transfer cap without a
+// restart; transfers under way switch to it within about a second. It
+// lasts until the coordinator restarts or another takes over as leader. It
+// is an admin route.
+func setBandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	var limit bandwidthLimit
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&limit); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid request body")
+		return
+	}
+	if err := fileService.SetBackgroundBandwidth(limit.BytesPerSec); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "bytes_per_sec must be 0 or more")
+		return
+	}
+
+	log.Printf("Client %q set the background bandwidth to %d bytes/sec", clientName(r), limit.BytesPerSec)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(limit)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// getBandwidth calls GET /bandwidth and returns the cap it reports
+func getBandwidth(t *testing.T) int64 {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	bandwidthHandler(rec, httptest.NewRequest(http.MethodGet, "/bandwidth", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /bandwidth: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var limit bandwidthLimit
+	if err := json.NewDecoder(rec.Body).Decode(&limit); err != nil {
+		t.Fatal(err)
+	}
+	return limit.BytesPerSec
+}
+
+// TestBandwidthHandlers changes the background transfer cap through
+// PUT /bandwidth and checks GET reports it, and invalid limits leave it be
+func TestBandwidthHandlers(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	if got := getBandwidth(t); got != 0 {
+		t.Errorf("want no cap to start with, got %d", got)
+	}
+
+	put := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		setBandwidthHandler(rec, httptest.NewRequest(http.MethodPut, "/bandwidth", strings.NewReader(body)))
+		return rec
+	}
+	rec := put(`{"bytes_per_sec": 1048576}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT /bandwidth: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if got := getBandwidth(t); got != 1<<20 || fileService.BackgroundBandwidth() != 1<<20 {
+		t.Errorf("want a cap of %d, got %d", 1<<20, got)
+	}
+
+	for _, body := range []string{`{"bytes_per_sec": -1}`, `{"bytes_per_sec": "fast"}`, `not json`, `{"bytes_per_sec": 1` + strings.Repeat("0", 2000) + `}`} {
+		checkErrorResponse(t, put(body), http.StatusBadRequest, codeBadRequest)
+	}
+	if got := getBandwidth(t); got != 1<<20 {
+		t.Errorf("want invalid limits ignored, got a cap of %d", got)
+	}
+
+	if rec := put(`{"bytes_per_sec": 0}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT /bandwidth: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if got := getBandwidth(t); got != 0 {
+		t.Errorf("want the cap lifted, got %d", got)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// exportCatalogHandler streams the metadata of every file as JSON Lines,
+// one file and its chunk manifest per line. Once the first line is sent
+// the status can't change, so a failure part way ends the stream early.
+func exportCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="catalog.jsonl"`)
+
+	exported, err := fileService.ExportCatalog(r.Context(), w)
+	if err != nil {
+		log.Printf("Catalog export failed after %d files: %v", exported, err)
+	}
+}
+
+// importCatalogHandler recreates file metadata from an export streamed in
+// the request body. The chunk data must already be on the storage nodes.
+func importCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := fileService.ImportCatalog(r.Context(), r.Body)
+	if errors.Is(err, service.ErrInvalidCatalog) {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest,
+			fmt.Sprintf("%v (%d files imported before it)", err, result.Imported))
+		return
+	}
+	if err != nil {
+		log.Printf("Catalog import failed after %d files: %v", result.Imported, err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Import failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// fileChunksHandler lists a file's chunks with the nodes the ring expects
+// them on and the nodes holding them. With probe=true the holders come from
+// asking every healthy node what it has instead of the recorded locations.
+func fileChunksHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	probe := false
+	if value := r.URL.Query().Get("probe"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid probe flag")
+			return
+		}
+		probe = parsed
+	}
+
+	report, err := fileService.FileChunkMap(r.Context(), fileID, probe)
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found")
+		return
+	}
+	var missing *metadata.MissingChunksError
+	if errors.As(err, &missing) {
+		writeMissingChunks(w, missing)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to map file chunks")
+		log.Printf("Chunk map of %s failed: %v", fileID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// chunkInfoHandler returns a chunk's metadata record, for debugging
+// deduplication and reference counts
+func chunkInfoHandler(w http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+
+	chunk, err := fileService.ChunkInfo(hash)
+	if errors.Is(err, metadata.ErrChunkNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeChunkNotFound, "Chunk not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to look up chunk")
+		log.Printf("Chunk lookup of %s failed: %v", hash, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chunk)
+}
+
+// releaseChunkHandler drops one reference to a chunk by hand, deleting it
+// once none are left. It is an admin route.
+func releaseChunkHandler(w http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+
+	release, err := fileService.ReleaseChunk(hash)
+	if errors.Is(err, metadata.ErrChunkNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeChunkNotFound, "Chunk not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to release chunk")
+		log.Printf("Chunk release of %s failed: %v", hash, err)
+		return
+	}
+
+	log.Printf("Client %q released a reference to chunk %s", clientName(r), hash)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(release)
+}
+
+// chunkConsistencyHandler reads a chunk from every node holding a replica
+// and reports any that differ or are gone. With repair=true, those are
+// overwritten from a consistent replica. It is an admin route.
+func chunkConsistencyHandler(w http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+
+	repair := false
+	if value := r.URL.Query().Get("repair"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid repair flag")
+			return
+		}
+		repair = parsed
+	}
+
+	check, err := fileService.CheckReplicas(r.Context(), hash, repair)
+	if errors.Is(err, metadata.ErrChunkNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeChunkNotFound, "Chunk not found")
+		return
+	}
+	if errors.Is(err, service.ErrNotReplicated) {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Chunk is erasure-coded or stored on the coordinator, not replicated on nodes")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to check replicas")
+		log.Printf("Replica check of chunk %s failed: %v", hash, err)
+		return
+	}
+
+	log.Printf("Client %q checked replicas of chunk %s: %d divergent, %d missing, %d unreadable, %d repaired",
+		clientName(r), hash, check.Divergent, check.Missing, check.Unreadable, check.Repaired)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(check)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// chunkRouter routes the chunk reference endpoints as main does, behind
+// authMiddleware with a user and an admin token
+func chunkRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/chunks/{hash}", chunkInfoHandler).Methods("GET")
+	router.HandleFunc("/chunks/{hash}/release", releaseChunkHandler).Methods("POST")
+	router.Use(authMiddleware(map[string]string{"user-token": "alice"}, "", "admin-token", false))
+	return router
+}
+
+// callChunkRoute sends a request to the chunk router with token
+func callChunkRoute(router *mux.Router, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// releaseChunk releases a reference to hash as the admin
+func releaseChunk(t *testing.T, router *mux.Router, hash string) service.ChunkRelease {
+	t.Helper()
+
+	rec := callChunkRoute(router, http.MethodPost, "/chunks/"+hash+"/release", "admin-token")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("release: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var release service.ChunkRelease
+	if err := json.NewDecoder(rec.Body).Decode(&release); err != nil {
+		t.Fatal(err)
+	}
+	return release
+}
+
+// TestChunkInfo checks GET /chunks/{hash} returns a chunk's record,
+// counting a reference for each file sharing it
+func TestChunkInfo(t *testing.T) {
+	s := useTestService(t, metadata.NewMemoryStore())
+	router := chunkRouter()
+
+	data := randomBytes(t, 1000)
+	var result *service.UploadResult
+	for _, name := range []string{"a.bin", "b.bin"} {
+		var err error
+		result, err = s.UploadFile(context.Background(), bytes.NewReader(data), service.UploadMetadata{FileName: name, Size: int64(len(data))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := result.ChunkHashes[0]
+
+	rec := callChunkRoute(router, http.MethodGet, "/chunks/"+hash, "user-token")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var chunk metadata.ChunkRecord
+	if err := json.NewDecoder(rec.Body).Decode(&chunk); err != nil {
+		t.Fatal(err)
+	}
+	if chunk.ChunkHash != hash || chunk.ChunkSize != len(data) || chunk.RefCount != 2 || chunk.StoragePath == "" {
+		t.Errorf("want %s of %d bytes with 2 references, got %+v", hash[:8], len(data), chunk)
+	}
+
+	checkErrorResponse(t, callChunkRoute(router, http.MethodGet, "/chunks/missing", "user-token"), http.StatusNotFound, codeChunkNotFound)
+}
+
+// TestReleaseChunk corrects a chunk's drifted reference count, checking only
+// the admin may, and that releasing the last reference deletes the chunk's
+// record and data
+func TestReleaseChunk(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	chunks := dedup.NewMemoryChunkStore()
+	fileService = service.NewFileService(db, chunks, nodeRegistry, consistentHash)
+	router := chunkRouter()
+
+	data := randomBytes(t, 1000)
+	result, err := fileService.UploadFile(context.Background(), bytes.NewReader(data), service.UploadMetadata{FileName: "test.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := result.ChunkHashes[0]
+
+	// A reference no file holds
+	if _, err := db.CreateChunk(hash, len(data), "local", 1, "sha256"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := callChunkRoute(router, http.MethodPost, "/chunks/"+hash+"/release", "user-token"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("user token: want 401, got %d", rec.Code)
+	}
+	if chunk, err := db.GetChunk(hash); err != nil || chunk.RefCount != 2 {
+		t.Fatalf("a refused release changed the chunk: %+v (%v)", chunk, err)
+	}
+
+	if release := releaseChunk(t, router, hash); release.Deleted || release.Chunk.RefCount != 1 {
+		t.Errorf("want 1 reference left, got %+v", release)
+	}
+	if !chunks.HasChunk(hash) {
+		t.Fatal("chunk deleted with a reference left")
+	}
+	if rec := callFileHandler(downloadHandler, http.MethodGet, "/download/"+result.FileID, result.FileID); rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Errorf("download after the release: want the file, got %d", rec.Code)
+	}
+
+	if release := releaseChunk(t, router, hash); !release.Deleted || release.Chunk.RefCount != 0 {
+		t.Errorf("want the chunk deleted, got %+v", release)
+	}
+	if chunks.HasChunk(hash) {
+		t.Error("chunk data still stored after its last reference was released")
+	}
+	checkErrorResponse(t, callChunkRoute(router, http.MethodGet, "/chunks/"+hash, "user-token"), http.StatusNotFound, codeChunkNotFound)
+	checkErrorResponse(t, callChunkRoute(router, http.MethodPost, "/chunks/"+hash+"/release", "admin-token"), http.StatusNotFound, codeChunkNotFound)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// TestDeregisterRemovesNode registers two nodes, deregisters one, and checks
+// it leaves both the registry and the ring while unknown or missing node IDs
+// are turned away
+func TestDeregisterRemovesNode(t *testing.T) {
+	defer func(saved *node.Registry) { nodeRegistry = saved }(nodeRegistry)
+	defer func(saved *node.ConsistentHash) { consistentHash = saved }(consistentHash)
+
+	nodeRegistry = node.NewRegistry(time.Minute)
+	ring, err := node.NewConsistentHash(node.DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consistentHash = ring
+
+	for _, nodeID := range []string{"node-1", "node-2"} {
+		if err := nodeRegistry.RegisterNode(&node.NodeInfo{NodeID: nodeID, Address: "localhost:9001"}); err != nil {
+			t.Fatal(err)
+		}
+		consistentHash.AddNode(nodeID)
+	}
+
+	deregister := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		deregisterNodeHandler(rec, httptest.NewRequest(http.MethodPost, "/deregister", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := deregister(`{"node_id":"node-1"}`); rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := nodeRegistry.GetNode("node-1"); err == nil {
+		t.Error("deregistered node still in the registry")
+	}
+	if n := consistentHash.GetNodeCount(); n != 1 {
+		t.Errorf("want 1 node left on the ring, got %d", n)
+	}
+	for i := 0; i < 100; i++ {
+		if nodeID, _ := consistentHash.GetNode(fmt.Sprintf("chunk-%d", i)); nodeID != "node-2" {
+			t.Fatalf("chunk placed on %s after it deregistered", nodeID)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"again", `{"node_id":"node-1"}`, http.StatusNotFound, codeNodeNotFound},
+		{"unknown", `{"node_id":"node-9"}`, http.StatusNotFound, codeNodeNotFound},
+		{"no node ID", `{}`, http.StatusBadRequest, codeBadRequest},
+		{"invalid JSON", `{`, http.StatusBadRequest, codeBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := deregister(tc.body)
+			if rec.Code != tc.status {
+				t.Fatalf("want %d, got %d", tc.status, rec.Code)
+			}
+			var resp errorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error.Code != tc.code {
+				t.Errorf("want error code %s, got %s", tc.code, resp.Error.Code)
+			}
+		})
+	}
+
+	if _, err := nodeRegistry.GetNode("node-2"); err != nil {
+		t.Error("the other node should stay registered")
+	}
+}
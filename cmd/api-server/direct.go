@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// maxDirectPlanSize caps the body of a direct upload request, which lists
+// every chunk of the file: enough for files of well over a terabyte
+const maxDirectPlanSize = 32 << 20
+
+// planDirectUploadHandler plans an upload whose chunks the client sends
+// straight to the storage nodes. The body lists the file's chunks; the
+// response says which to send and where, with a grant for each.
+func planDirectUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var req service.DirectUploadRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDirectPlanSize)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.ClientEncryption) > maxClientEncryptionSize {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Client encryption parameters too large")
+		return
+	}
+
+	var size int64
+	for _, chunk := range req.Chunks {
+		size += int64(chunk.Size)
+	}
+	if maxUploadSize > 0 && size > maxUploadSize {
+		fileTooLarge(w)
+		return
+	}
+
+	// Only the client that planned an upload may complete it
+	req.Owner = clientKeyFor(r)
+
+	plan, err := fileService.PlanDirectUpload(req)
+	switch {
+	case errors.Is(err, service.ErrInvalidDirectUpload):
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	case errors.Is(err, service.ErrInvalidTags):
+		writeJSONError(w, http.StatusBadRequest, codeInvalidTags, err.Error())
+		return
+	case errors.Is(err, service.ErrInvalidReplication):
+		writeJSONError(w, http.StatusBadRequest, codeInvalidReplication, "Replication must be between 1 and the number of healthy nodes")
+		return
+	case errors.Is(err, service.ErrNoDirectNodes):
+		writeJSONError(w, http.StatusServiceUnavailable, codeNodeUnavailable, err.Error())
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to plan upload")
+		log.Printf("Planning direct upload of %s failed: %v", req.FileName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(plan)
+}
+
+// completeDirectUploadHandler records a direct upload once the client has
+// sent its chunks, answering like /upload
+func completeDirectUploadHandler(w http.ResponseWriter, r *http.Request) {
+	uploadID := mux.Vars(r)["uploadID"]
+
+	response, err := fileService.CompleteDirectUpload(r.Context(), uploadID, clientKeyFor(r))
+	switch {
+	case errors.Is(err, service.ErrDirectUploadNotFound):
+		writeJSONError(w, http.StatusNotFound, codeUploadNotFound, "Unknown or expired upload ID")
+		return
+	case errors.Is(err, service.ErrDirectUploadBusy):
+		writeJSONError(w, http.StatusConflict, codeUploadInProgress, err.Error())
+		return
+	case errors.Is(err, service.ErrChunksNotUploaded):
+		writeJSONError(w, http.StatusConflict, codeChunksNotUploaded, err.Error())
+		return
+	case errors.Is(err, service.ErrWriteQuorum):
+		writeJSONError(w, http.StatusServiceUnavailable, codeWriteQuorum, err.Error())
+		return
+	case err != nil && r.Context().Err() != nil:
+		log.Printf("Direct upload %s cancelled: client went away", uploadID)
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		log.Printf("Direct upload %s failed: %v", uploadID, err)
+		return
+	}
+	recordAccess(r, response.FileID, metadata.AccessUpload, response.Size)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
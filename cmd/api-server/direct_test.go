@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+	"github.com/noorimat/distributed-file-storage/pkg/client"
+)
+
+// TestDirectUploadFromClient uploads a file with the client library's
+// UploadDirect against a coordinator and storage nodes sharing a cluster
+// secret, and checks its chunks reach the nodes without passing through the
+// coordinator, each on as many nodes as asked, and the file downloads
+func TestDirectUploadFromClient(t *testing.T) {
+	const secret = "cluster-secret"
+	coordinator := newNodeCoordinator(t, time.Minute)
+	chunks := dedup.NewMemoryChunkStore()
+	fileService = service.NewFileService(db, chunks, nodeRegistry, consistentHash)
+	fileService.UseClusterSecret(secret)
+	for i := 1; i <= 3; i++ {
+		startHeartbeatingNode(t, coordinator, time.Second, func(sn *node.StorageNode) {
+			sn.NodeID = fmt.Sprintf("node-%d", i)
+			sn.ClusterSecret = secret
+		})
+	}
+	waitForNodes(t, 3)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/upload/direct", planDirectUploadHandler).Methods("POST")
+	router.HandleFunc("/upload/direct/{uploadID}/complete", completeDirectUploadHandler).Methods("POST")
+	router.HandleFunc("/download/{fileID}", downloadHandler).Methods("GET")
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	c := client.New(server.URL)
+
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result, err := c.UploadDirect("direct.bin", bytes.NewReader(data), client.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != int64(len(data)) || len(result.ChunkHashes) == 0 || result.ChunksStored != len(result.ChunkHashes) {
+		t.Errorf("want every chunk of %d bytes stored, got %+v", len(data), result)
+	}
+	if result.MinReplicas != service.ReplicationCount {
+		t.Errorf("want every chunk on %d nodes, got %d at least", service.ReplicationCount, result.MinReplicas)
+	}
+
+	locations, err := db.GetChunkLocations(result.ChunkHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range result.ChunkHashes {
+		if len(locations[hash]) != service.ReplicationCount {
+			t.Errorf("chunk %s: want it recorded on %d nodes, got %v", hash[:8], service.ReplicationCount, locations[hash])
+		}
+		if chunks.HasChunk(hash) {
+			t.Errorf("chunk %s passed through the coordinator's store", hash[:8])
+		}
+	}
+
+	var downloaded bytes.Buffer
+	if err := c.Download(result.FileID, &downloaded, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded.Bytes(), data) {
+		t.Error("download differs from upload")
+	}
+
+	// Uploading it again finds every chunk stored already
+	again, err := c.UploadDirect("copy.bin", bytes.NewReader(data), client.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.FileID == result.FileID || again.ChunksStored != 0 || len(again.ChunkHashes) != len(result.ChunkHashes) {
+		t.Errorf("want a new file sharing every chunk, got %+v", again)
+	}
+
+	// Options that need the data on the coordinator are refused before
+	// anything is sent
+	if _, err := c.UploadDirect("secret.bin", bytes.NewReader(data), client.UploadOptions{Password: "s3cret"}); err == nil {
+		t.Error("server-side encryption: want an error")
+	}
+	var apiErr *client.Error
+	_, err = c.UploadDirect("", bytes.NewReader(data), client.UploadOptions{})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("no file name: want 400, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// TestDownloadChecksum checks a download carries the file's SHA-256, and
+// that a download whose reassembled bytes don't match it is cut off rather
+// than completed
+func TestDownloadChecksum(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	chunks := dedup.NewMemoryChunkStore()
+	fileService = service.NewFileService(db, chunks, nodeRegistry, consistentHash)
+
+	data := randomBytes(t, 1000)
+	result, err := fileService.UploadFile(context.Background(), bytes.NewReader(data), service.UploadMetadata{FileName: "test.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/download/{fileID}", downloadHandler).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// A response cut off before its headers were flushed fails the request
+	// itself, so both errors count
+	get := func() (*http.Response, []byte, error) {
+		resp, err := http.Get(server.URL + "/download/" + result.FileID)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp, body, err
+	}
+
+	sum := sha256.Sum256(data)
+	resp, body, err := get()
+	if err != nil || !bytes.Equal(body, data) {
+		t.Fatalf("download differs: %v", err)
+	}
+	if got := resp.Header.Get("X-Content-SHA256"); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("want X-Content-SHA256 %x, got %q", sum, got)
+	}
+
+	// Corrupt the chunk's bytes without changing its hash
+	hash := result.ChunkHashes[0]
+	corrupted, err := chunks.GetChunk(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted[len(corrupted)/2] ^= 0xff
+	if err := chunks.DeleteChunk(hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := chunks.StoreChunk(hash, corrupted); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := get(); err == nil {
+		t.Error("want the corrupt download cut off, got it complete")
+	}
+}
+
+// TestDownloadIfRange resumes a download with Range and If-Range, and
+// checks the rest of the file is served while the ETag still matches, and
+// 412 once it doesn't
+func TestDownloadIfRange(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	ctx := context.Background()
+	data := randomBytes(t, 1000)
+	result, err := fileService.UploadFile(ctx, bytes.NewReader(data), service.UploadMetadata{FileName: "test.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := fileService.UploadFile(ctx, bytes.NewReader(data), service.UploadMetadata{FileName: "secret.bin", Size: int64(len(data)), Password: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(fileID, password string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/download/"+fileID+"?password="+password, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		downloadHandler(rec, mux.SetURLVars(r, map[string]string{"fileID": fileID}))
+		return rec
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	first := get(result.FileID, "", nil)
+	if first.Code != http.StatusOK || first.Header().Get("ETag") != etag {
+		t.Fatalf("want 200 with ETag %s, got %d with %q", etag, first.Code, first.Header().Get("ETag"))
+	}
+	if got := get(encrypted.FileID, "s3cret", nil).Header().Get("ETag"); got != `"`+encrypted.FileID+`"` {
+		t.Errorf("encrypted file: want its ID as the ETag, got %q", got)
+	}
+
+	// Resumed after the first 400 bytes
+	rest := get(result.FileID, "", http.Header{"Range": {"bytes=400-"}, "If-Range": {etag}})
+	if rest.Code != http.StatusPartialContent || rest.Header().Get("ETag") != etag {
+		t.Fatalf("matching If-Range: want 206 with the ETag, got %d: %s", rest.Code, rest.Body)
+	}
+	if resumed := append(bytes.Clone(data[:400]), rest.Body.Bytes()...); !bytes.Equal(resumed, data) {
+		t.Error("resumed download differs from the file")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		ifRange string
+	}{
+		{"another file's ETag", `"` + strings.Repeat("0", 64) + `"`},
+		{"weak ETag", "W/" + etag},
+		{"unquoted ETag", strings.Trim(etag, `"`)},
+		{"date", "Wed, 21 Oct 2026 07:28:00 GMT"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := get(result.FileID, "", http.Header{"Range": {"bytes=400-"}, "If-Range": {tc.ifRange}})
+			checkErrorResponse(t, rec, http.StatusPreconditionFailed, codePreconditionFailed)
+		})
+	}
+
+	// If-Range only applies to a Range request
+	if rec := get(result.FileID, "", http.Header{"If-Range": {`"stale"`}}); rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Errorf("If-Range without Range: want the whole file, got %d", rec.Code)
+	}
+	rec := get(encrypted.FileID, "s3cret", http.Header{"Range": {"bytes=0-99"}, "If-Range": {`"` + encrypted.FileID + `"`}})
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), data[:100]) {
+		t.Errorf("encrypted file, matching If-Range: want the first 100 bytes, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes returned in JSON error bodies. Clients match on them, so they
+// must stay stable even when messages change.
+const (
+	codeBadRequest          = "BAD_REQUEST"
+	codeUnauthorized        = "UNAUTHORIZED"
+	codeForbidden           = "FORBIDDEN"
+	codeFileNotFound        = "FILE_NOT_FOUND"
+	codeFileExists          = "FILE_EXISTS"
+	codeFileExpired         = "FILE_EXPIRED"
+	codeFileTooLarge        = "FILE_TOO_LARGE"
+	codePasswordRequired    = "PASSWORD_REQUIRED"
+	codeBadPassword         = "BAD_PASSWORD"
+	codeNotEncrypted        = "NOT_ENCRYPTED"
+	codeInvalidReplication  = "INVALID_REPLICATION"
+	codeInvalidTags         = "INVALID_TAGS"
+	codeInvalidAlgorithm    = "INVALID_ALGORITHM"
+	codeRangeNotSatisfiable = "RANGE_NOT_SATISFIABLE"
+	codePreconditionFailed  = "PRECONDITION_FAILED"
+	codeRateLimited         = "RATE_LIMITED"
+	codeUploadNotFound      = "UPLOAD_NOT_FOUND"
+	codeUploadInProgress    = "UPLOAD_IN_PROGRESS"
+	codeChunksNotUploaded   = "CHUNKS_NOT_UPLOADED"
+	codeIdempotencyKeyReuse = "IDEMPOTENCY_KEY_REUSED"
+	codeInvalidManifest     = "INVALID_MANIFEST"
+	codeShareNotFound       = "SHARE_NOT_FOUND"
+	codeShareExpired        = "SHARE_EXPIRED"
+	codeNotConfigured       = "NOT_CONFIGURED"
+	codeNodeNotFound        = "NODE_NOT_FOUND"
+	codeNodeUnavailable     = "NODE_UNAVAILABLE"
+	codeProtocolMismatch    = "PROTOCOL_MISMATCH"
+	codeNoChunkMetadata     = "NO_CHUNK_METADATA"
+	codeChunkNotFound       = "CHUNK_NOT_FOUND"
+	codeMissingChunks       = "MISSING_CHUNKS"
+	codeThumbnailNotFound   = "THUMBNAIL_NOT_FOUND"
+	codeWriteQuorum         = "WRITE_QUORUM_NOT_MET"
+	codeHashCollision       = "HASH_COLLISION"
+	codeNotLeader           = "NOT_LEADER"
+	codeMaintenance         = "MAINTENANCE"
+	codeInternal            = "INTERNAL_ERROR"
+)
+
+// errorResponse is the body of every error the coordinator returns
+type errorResponse struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeJSONError replies with status and a body of the form
+// {"error": {"code": "...", "message": "..."}}
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: errorDetail{Code: code, Message: message}})
+}
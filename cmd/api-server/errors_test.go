@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// checkErrorResponse checks rec is a JSON error with the given status and
+// code, and nothing but the error object in its body
+func checkErrorResponse(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+
+	if rec.Code != status {
+		t.Fatalf("want %d, got %d: %s", status, rec.Code, rec.Body)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("want Content-Type application/json, got %q", contentType)
+	}
+
+	var body map[string]map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body is not a JSON error object: %v: %s", err, rec.Body)
+	}
+	detail, ok := body["error"]
+	if len(body) != 1 || !ok || len(detail) != 2 {
+		t.Fatalf(`want only {"error": {"code", "message"}}, got %s`, rec.Body)
+	}
+	if detail["code"] != code {
+		t.Errorf("want error code %s, got %s", code, detail["code"])
+	}
+	if detail["message"] == "" {
+		t.Error("error message is empty")
+	}
+}
+
+// TestDownloadErrorResponses checks the codes a download fails with for a
+// missing file, a missing or wrong password, a chunk that can't be read, and
+// one whose record is gone
+func TestDownloadErrorResponses(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	chunks := dedup.NewMemoryChunkStore()
+	fileService = service.NewFileService(db, chunks, nodeRegistry, consistentHash)
+	ctx := context.Background()
+
+	data := randomBytes(t, 1000)
+	encrypted, err := fileService.UploadFile(ctx, bytes.NewReader(data), service.UploadMetadata{FileName: "secret.bin", Size: int64(len(data)), Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = randomBytes(t, 1000)
+	damaged, err := fileService.UploadFile(ctx, bytes.NewReader(data), service.UploadMetadata{FileName: "damaged.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chunks.DeleteChunk(damaged.ChunkHashes[0]); err != nil {
+		t.Fatal(err)
+	}
+	data = randomBytes(t, 1000)
+	unrecorded, err := fileService.UploadFile(ctx, bytes.NewReader(data), service.UploadMetadata{FileName: "unrecorded.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ReleaseChunks(unrecorded.ChunkHashes); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		fileID string
+		status int
+		code   string
+	}{
+		{"unknown file", "/download/missing", "missing", http.StatusNotFound, codeFileNotFound},
+		{"no password", "/download/" + encrypted.FileID, encrypted.FileID, http.StatusUnauthorized, codePasswordRequired},
+		{"wrong password", "/download/" + encrypted.FileID + "?password=wrong", encrypted.FileID, http.StatusUnauthorized, codeBadPassword},
+		{"unreadable chunk", "/download/" + damaged.FileID, damaged.FileID, http.StatusInternalServerError, codeNodeUnavailable},
+		{"chunk without a record", "/download/" + unrecorded.FileID, unrecorded.FileID, http.StatusInternalServerError, codeMissingChunks},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := callFileHandler(downloadHandler, http.MethodGet, tc.path, tc.fileID)
+			checkErrorResponse(t, rec, tc.status, tc.code)
+		})
+	}
+}
+
+// TestUploadErrorResponses checks the codes an upload is turned away with
+// before anything is stored
+func TestUploadErrorResponses(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	file := testFile{"test.bin", randomBytes(t, 1000)}
+
+	for _, tc := range []struct {
+		name   string
+		fields map[string]string
+		files  []testFile
+		status int
+		code   string
+	}{
+		{"no file", nil, nil, http.StatusBadRequest, codeBadRequest},
+		{"invalid replication", map[string]string{"replication": "many"}, []testFile{file}, http.StatusBadRequest, codeInvalidReplication},
+		{"invalid tag", map[string]string{"tag": "no-separator"}, []testFile{file}, http.StatusBadRequest, codeInvalidTags},
+		{"algorithm without password", map[string]string{"encryption_algorithm": "aes-256-gcm"}, []testFile{file}, http.StatusBadRequest, codeInvalidAlgorithm},
+		{"invalid dedup", map[string]string{"dedup": "maybe"}, []testFile{file}, http.StatusBadRequest, codeBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			uploadHandler(rec, multipartRequest(t, "/upload", tc.fields, tc.files...))
+			checkErrorResponse(t, rec, tc.status, tc.code)
+		})
+	}
+
+	files, err := db.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("rejected uploads stored %d files", len(files))
+	}
+}
+
+// TestUploadHashCollision checks an upload whose chunk is stored under the
+// same hash with different bytes is refused with 409 when dedup is verified
+func TestUploadHashCollision(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	chunks := dedup.NewMemoryChunkStore()
+	fileService = service.NewFileService(db, chunks, nodeRegistry, consistentHash)
+	fileService.UseVerifyDedup(true)
+
+	data := randomBytes(t, 1000)
+	stored, err := fileService.UploadFile(context.Background(), bytes.NewReader(data), service.UploadMetadata{FileName: "test.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := stored.ChunkHashes[0]
+	if err := chunks.DeleteChunk(hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := chunks.StoreChunk(hash, randomBytes(t, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	// Trashed, so the upload isn't matched as a whole file
+	if err := fileService.DeleteFile(stored.FileID); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	uploadHandler(rec, multipartRequest(t, "/upload", nil, testFile{"test.bin", data}))
+	checkErrorResponse(t, rec, http.StatusConflict, codeHashCollision)
+}
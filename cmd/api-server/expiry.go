@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// parseExpiry parses the optional expires_at (RFC 3339) and ttl (a Go
+// duration such as "24h") upload fields. At most one may be given, and the
+// expiry must be in the future; nil means the file never expires.
+func parseExpiry(expiresAt, ttl string) (*time.Time, error) {
+	if expiresAt != "" && ttl != "" {
+		return nil, errors.New("give expires_at or ttl, not both")
+	}
+
+	var expiry time.Time
+	switch {
+	case expiresAt != "":
+		parsed, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expires_at %q: want an RFC 3339 time", expiresAt)
+		}
+		expiry = parsed
+	case ttl != "":
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl %q: want a duration such as 24h", ttl)
+		}
+		expiry = time.Now().Add(duration)
+	default:
+		return nil, nil
+	}
+
+	if !expiry.After(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+	expiry = expiry.UTC()
+	return &expiry, nil
+}
+
+// reapExpiredFiles periodically purges files past their expiry, along with
+// chunks nothing else references
+func reapExpiredFiles(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := fileService.PurgeExpiredFiles()
+		if err != nil {
+			log.Printf("Expired file purge failed: %v", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Purged %d expired files", purged)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// TestUploadExpiry uploads files with an expires_at and a ttl, checks the
+// expiry is returned, and that a download once it has passed answers 410
+func TestUploadExpiry(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	data := randomBytes(t, 1000)
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	rec := httptest.NewRecorder()
+	uploadHandler(rec, multipartRequest(t, "/upload", map[string]string{"expires_at": expiresAt.Format(time.RFC3339)}, testFile{"later.bin", data}))
+	if result := decodeUploadResult(t, rec); result.ExpiresAt == nil || !result.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("want the file to expire at %s, got %v", expiresAt, result.ExpiresAt)
+	}
+
+	rec = httptest.NewRecorder()
+	uploadHandler(rec, multipartRequest(t, "/upload", map[string]string{"ttl": "100ms"}, testFile{"brief.bin", data}))
+	result := decodeUploadResult(t, rec)
+	if result.ExpiresAt == nil || time.Until(*result.ExpiresAt) > 100*time.Millisecond {
+		t.Fatalf("want the file to expire within 100ms, got %v", result.ExpiresAt)
+	}
+
+	download := func() *httptest.ResponseRecorder {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/download/"+result.FileID, nil), map[string]string{"fileID": result.FileID})
+		rec := httptest.NewRecorder()
+		downloadHandler(rec, r)
+		return rec
+	}
+	if rec := download(); rec.Code != http.StatusOK {
+		t.Fatalf("before expiry: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	time.Sleep(time.Until(*result.ExpiresAt) + 10*time.Millisecond)
+	checkErrorResponse(t, download(), http.StatusGone, codeFileExpired)
+
+	for _, tc := range []struct {
+		name   string
+		fields map[string]string
+	}{
+		{"both", map[string]string{"expires_at": expiresAt.Format(time.RFC3339), "ttl": "1h"}},
+		{"invalid expires_at", map[string]string{"expires_at": "tomorrow"}},
+		{"invalid ttl", map[string]string{"ttl": "a day"}},
+		{"past expires_at", map[string]string{"expires_at": "2001-01-01T00:00:00Z"}},
+		{"negative ttl", map[string]string{"ttl": "-1h"}},
+		{"zero ttl", map[string]string{"ttl": "0s"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			uploadHandler(rec, multipartRequest(t, "/upload", tc.fields, testFile{"report.pdf", data}))
+			checkErrorResponse(t, rec, http.StatusBadRequest, codeBadRequest)
+		})
+	}
+}
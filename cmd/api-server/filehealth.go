@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// fileHealthHandler reports how safely a file's chunks are replicated
+// across the nodes that are healthy right now
+func fileHealthHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	report, err := fileService.FileHealth(fileID)
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found")
+		return
+	}
+	var missing *metadata.MissingChunksError
+	if errors.As(err, &missing) {
+		writeMissingChunks(w, missing)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to check file health")
+		log.Printf("Health check of %s failed: %v", fileID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
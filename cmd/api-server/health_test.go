@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+	"github.com/noorimat/distributed-file-storage/internal/shard"
+)
+
+// closedStore is a metadata store whose database connection has been closed
+type closedStore struct {
+	*metadata.MemoryStore
+}
+
+func (closedStore) Ping(ctx context.Context) error {
+	return sql.ErrConnDone
+}
+
+// checkHealth calls /health and checks its status code and the reported
+// state of the database and chunk storage
+func checkHealth(t *testing.T, status int, database, storage string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != status {
+		t.Errorf("want %d, got %d: %s", status, rec.Code, rec.Body)
+	}
+
+	var health map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if health["database"] != database || health["storage"] != storage {
+		t.Errorf("want database %q and storage %q, got %v", database, storage, health)
+	}
+	wantStatus := "healthy"
+	if status != http.StatusOK {
+		wantStatus = "unhealthy"
+	}
+	if health["status"] != wantStatus {
+		t.Errorf("want status %q, got %v", wantStatus, health["status"])
+	}
+}
+
+// useChunkStore points the health check at store until the test ends
+func useChunkStore(t *testing.T, store service.ChunkStorer) {
+	saved := chunkStore
+	t.Cleanup(func() { chunkStore = saved })
+	chunkStore = store
+}
+
+// TestHealthDependencies checks /health answers 503 when the database
+// connection is closed or chunks can't be written, and 200 otherwise
+func TestHealthDependencies(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "chunks")
+	store, err := dedup.NewChunkStore(dir, shard.DefaultDepth, dedup.IndexJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useChunkStore(t, store)
+
+	useTestService(t, metadata.NewMemoryStore())
+	checkHealth(t, http.StatusOK, "connected", "writable")
+
+	useTestService(t, closedStore{metadata.NewMemoryStore()})
+	checkHealth(t, http.StatusServiceUnavailable, "unreachable", "writable")
+
+	if url := os.Getenv("TEST_DATABASE_URL"); url != "" {
+		database, err := metadata.NewDatabase(url, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		database.Close()
+		useTestService(t, database)
+		checkHealth(t, http.StatusServiceUnavailable, "unreachable", "writable")
+	}
+
+	useTestService(t, metadata.NewMemoryStore())
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkHealth(t, http.StatusServiceUnavailable, "connected", "not writable")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+	"github.com/noorimat/distributed-file-storage/internal/version"
+)
+
+// newNodeCoordinator serves the endpoints storage nodes call over a
+// registry with the given heartbeat timeout
+func newNodeCoordinator(t *testing.T, timeout time.Duration) *httptest.Server {
+	t.Helper()
+
+	useTestService(t, metadata.NewMemoryStore())
+	nodeRegistry = node.NewRegistry(timeout)
+	fileService = service.NewFileService(db, dedup.NewMemoryChunkStore(), nodeRegistry, consistentHash)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/register", registerNodeHandler).Methods("POST")
+	router.HandleFunc("/heartbeat", heartbeatHandler).Methods("POST")
+	router.HandleFunc("/deregister", deregisterNodeHandler).Methods("POST")
+	router.HandleFunc("/chunks/unreferenced", unreferencedChunksHandler).Methods("POST")
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// startHeartbeatingNode runs a storage node that heartbeats to coordinator
+// every interval until the test ends; configure, if set, adjusts it first
+func startHeartbeatingNode(t *testing.T, coordinator *httptest.Server, interval time.Duration, configure func(*node.StorageNode)) *node.StorageNode {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	sn := node.NewStorageNode("node-1", address, t.TempDir(), strings.TrimPrefix(coordinator.URL, "http://"))
+	sn.HeartbeatInterval = interval
+	sn.ScrubInterval, sn.SweepInterval = 0, 0
+	if configure != nil {
+		configure(sn)
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- sn.Start() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sn.Shutdown(ctx)
+		if err := <-stopped; err != nil {
+			t.Errorf("node stopped with error: %v", err)
+		}
+	})
+	return sn
+}
+
+// healthyNodes returns the IDs of the nodes the registry counts as healthy
+func healthyNodes() []string {
+	var nodeIDs []string
+	for _, nodeInfo := range nodeRegistry.GetHealthyNodes() {
+		nodeIDs = append(nodeIDs, nodeInfo.NodeID)
+	}
+	return nodeIDs
+}
+
+// waitForNodes waits until n nodes have registered and are healthy
+func waitForNodes(t *testing.T, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); len(healthyNodes()) < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d nodes registered, got %v", n, healthyNodes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestShortHeartbeatStaysHealthy runs a node heartbeating every 100ms
+// against a coordinator timing nodes out after 300ms, and checks the node
+// never drops out of the healthy nodes over many timeouts
+func TestShortHeartbeatStaysHealthy(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	coordinator := newNodeCoordinator(t, node.MinHeartbeatsPerTimeout*interval)
+	startHeartbeatingNode(t, coordinator, interval, nil)
+
+	waitForNodes(t, 1)
+
+	for end := time.Now().Add(20 * interval); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		if nodes := healthyNodes(); len(nodes) != 1 {
+			t.Fatalf("node dropped out of the healthy nodes: %v", nodeRegistry.GetAllNodes())
+		}
+	}
+}
+
+// TestRegisterChecksHeartbeatInterval checks the coordinator turns away a
+// node whose heartbeats are too far apart for its timeout
+func TestRegisterChecksHeartbeatInterval(t *testing.T) {
+	newNodeCoordinator(t, 30*time.Second)
+
+	for _, tc := range []struct {
+		interval time.Duration
+		status   int
+	}{
+		{10 * time.Second, http.StatusOK},
+		{0, http.StatusOK}, // Nodes that don't report an interval
+		{11 * time.Second, http.StatusBadRequest},
+		{time.Minute, http.StatusBadRequest},
+	} {
+		body, err := json.Marshal(node.NodeInfo{
+			NodeID:            "node-1",
+			Address:           "127.0.0.1:9001",
+			HeartbeatInterval: tc.interval,
+			ProtocolVersion:   version.Protocol,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		registerNodeHandler(rec, httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(body)))
+		if rec.Code != tc.status {
+			t.Errorf("interval %s: want %d, got %d: %s", tc.interval, tc.status, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// defaultIdempotencyTTL is how long a completed upload's result is replayed
+// for retries carrying the same Idempotency-Key
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength caps the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// idempotentUpload is the state of one Idempotency-Key: in flight until
+// result is set
+type idempotentUpload struct {
+	fingerprint string // What the key was first used for; see uploadFingerprint
+	result      *service.UploadResult
+	expires     time.Time // Zero while in flight
+}
+
+// idempotencyCache remembers uploads by Idempotency-Key so a client retrying
+// after a timeout gets the original result instead of a second copy of the
+// file. Keys are scoped per client and kept in memory, so a coordinator
+// restart forgets them.
+type idempotencyCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	uploads   map[string]*idempotentUpload
+	lastSweep time.Time
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:       ttl,
+		uploads:   make(map[string]*idempotentUpload),
+		lastSweep: time.Now(),
+	}
+}
+
+// idempotencyState is what begin found for a key
+type idempotencyState int
+
+const (
+	idempotencyNew        idempotencyState = iota // Caller owns the key and must finish or abandon it
+	idempotencyInFlight                           // Another request with the key is still running
+	idempotencyDone                               // The result is returned for replay
+	idempotencyMismatched                         // The key was used for a different upload
+)
+
+// begin claims key for an upload described by fingerprint, or reports why
+// it can't. Expired keys are dropped first.
+func (c *idempotencyCache) begin(key, fingerprint string) (*service.UploadResult, idempotencyState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, upload := range c.uploads {
+			if upload.expired(now) {
+				delete(c.uploads, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	upload, exists := c.uploads[key]
+	switch {
+	case !exists || upload.expired(now):
+		c.uploads[key] = &idempotentUpload{fingerprint: fingerprint}
+		return nil, idempotencyNew
+	case upload.fingerprint != fingerprint:
+		return nil, idempotencyMismatched
+	case upload.result == nil:
+		return nil, idempotencyInFlight
+	default:
+		return upload.result, idempotencyDone
+	}
+}
+
+// finish records the result of a claimed key for replay
+func (c *idempotencyCache) finish(key string, result *service.UploadResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if upload, exists := c.uploads[key]; exists {
+		upload.result = result
+		upload.expires = time.Now().Add(c.ttl)
+	}
+}
+
+// abandon releases a claimed key after a failed upload, so a retry runs again
+func (c *idempotencyCache) abandon(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.uploads, key)
+}
+
+func (u *idempotentUpload) expired(now time.Time) bool {
+	return !u.expires.IsZero() && now.After(u.expires)
+}
+
+// uploadFingerprint identifies what an upload request stores, so a key
+// reused for a different file is rejected rather than replayed
+func uploadFingerprint(meta service.UploadMetadata) string {
+	return fmt.Sprintf("%s\x00%d\x00%d\x00%t\x00%t", meta.FileName, meta.Size, meta.Replication, meta.ClientEncrypted, meta.DisableDedup)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// useIdempotencyCache replaces the upload idempotency cache with one
+// keeping results for ttl until the test ends
+func useIdempotencyCache(t *testing.T, ttl time.Duration) {
+	saved := uploadIdempotency
+	t.Cleanup(func() { uploadIdempotency = saved })
+	uploadIdempotency = newIdempotencyCache(ttl)
+}
+
+// postIdempotent posts file to uploadHandler with an Idempotency-Key
+func postIdempotent(t *testing.T, key string, file testFile) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := multipartRequest(t, "/upload", nil, file)
+	r.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	uploadHandler(rec, r)
+	return rec
+}
+
+// decodeUploadResult decodes a successful upload response
+func decodeUploadResult(t *testing.T, rec *httptest.ResponseRecorder) *service.UploadResult {
+	t.Helper()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var result service.UploadResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return &result
+}
+
+// storedFiles returns how many file records the metadata store holds
+func storedFiles(t *testing.T) int {
+	t.Helper()
+
+	files, err := db.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+// TestIdempotentUploadRetry checks a retry with the same key gets the
+// original result without storing the file again, while a new key or a
+// different file under the same key does not
+func TestIdempotentUploadRetry(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	useIdempotencyCache(t, time.Hour)
+	file := testFile{"report.pdf", randomBytes(t, 1000)}
+
+	first := postIdempotent(t, "key-1", file)
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("first upload marked as replayed")
+	}
+	original := decodeUploadResult(t, first)
+
+	retry := postIdempotent(t, "key-1", file)
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry not marked as replayed")
+	}
+	if replayed := decodeUploadResult(t, retry); !reflect.DeepEqual(replayed, original) {
+		t.Errorf("retry got %+v, want the original %+v", replayed, original)
+	}
+	if n := storedFiles(t); n != 1 {
+		t.Errorf("want 1 file stored after a retry, got %d", n)
+	}
+
+	other := postIdempotent(t, "key-1", testFile{"other.pdf", randomBytes(t, 2000)})
+	checkErrorResponse(t, other, http.StatusUnprocessableEntity, codeIdempotencyKeyReuse)
+
+	if fresh := decodeUploadResult(t, postIdempotent(t, "key-2", file)); fresh.FileID == original.FileID {
+		t.Error("a new key replayed another key's upload")
+	}
+	if n := storedFiles(t); n != 2 {
+		t.Errorf("want 2 files stored, got %d", n)
+	}
+}
+
+// TestIdempotentUploadFailureRetried checks a key is released when its
+// upload fails, so the retry runs again
+func TestIdempotentUploadFailureRetried(t *testing.T) {
+	store := &rejectingStore{MemoryStore: metadata.NewMemoryStore(), reject: "report.pdf"}
+	useTestService(t, store)
+	useIdempotencyCache(t, time.Hour)
+	file := testFile{"report.pdf", randomBytes(t, 1000)}
+
+	if rec := postIdempotent(t, "key-1", file); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("want 500, got %d: %s", rec.Code, rec.Body)
+	}
+
+	store.reject = ""
+	retry := postIdempotent(t, "key-1", file)
+	if retry.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("retry of a failed upload replayed")
+	}
+	decodeUploadResult(t, retry)
+	if n := storedFiles(t); n != 1 {
+		t.Errorf("want 1 file stored, got %d", n)
+	}
+}
+
+// disconnectingStore cancels a request, as its client going away would,
+// once it has stored the file
+type disconnectingStore struct {
+	*metadata.MemoryStore
+	cancel context.CancelFunc
+}
+
+func (s *disconnectingStore) CreateFile(file *metadata.FileRecord) error {
+	err := s.MemoryStore.CreateFile(file)
+	s.cancel()
+	return err
+}
+
+// TestIdempotentUploadClientGone has the client go away once its upload
+// is stored, and checks a retry with the same key gets that upload's result
+// rather than storing the file again
+func TestIdempotentUploadClientGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	useTestService(t, &disconnectingStore{MemoryStore: metadata.NewMemoryStore(), cancel: cancel})
+	useIdempotencyCache(t, time.Hour)
+	file := testFile{"report.pdf", randomBytes(t, 1000)}
+
+	r := multipartRequest(t, "/upload", nil, file).WithContext(ctx)
+	r.Header.Set("Idempotency-Key", "key-1")
+	uploadHandler(httptest.NewRecorder(), r)
+	if ctx.Err() == nil {
+		t.Fatal("want the request cancelled during the upload")
+	}
+	if n := storedFiles(t); n != 1 {
+		t.Fatalf("want the file stored, got %d files", n)
+	}
+
+	retry := postIdempotent(t, "key-1", file)
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry after the client went away not replayed")
+	}
+	decodeUploadResult(t, retry)
+	if n := storedFiles(t); n != 1 {
+		t.Errorf("want 1 file stored after a retry, got %d", n)
+	}
+}
+
+// blockingStore holds file creation until release is closed, announcing
+// each attempt on entered
+type blockingStore struct {
+	*metadata.MemoryStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) CreateFile(file *metadata.FileRecord) error {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.MemoryStore.CreateFile(file)
+}
+
+// TestIdempotentUploadConcurrent sends a second request with a key whose
+// upload is still running, and checks it gets a conflict and the first
+// upload is the only one stored
+func TestIdempotentUploadConcurrent(t *testing.T) {
+	store := &blockingStore{MemoryStore: metadata.NewMemoryStore(), entered: make(chan struct{}, 1), release: make(chan struct{})}
+	useTestService(t, store)
+	useIdempotencyCache(t, time.Hour)
+	file := testFile{"report.pdf", randomBytes(t, 1000)}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postIdempotent(t, "key-1", file) }()
+	select {
+	case <-store.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first upload never reached the metadata store")
+	}
+
+	concurrent := postIdempotent(t, "key-1", file)
+	checkErrorResponse(t, concurrent, http.StatusConflict, codeUploadInProgress)
+
+	close(store.release)
+	original := decodeUploadResult(t, <-done)
+
+	if replayed := decodeUploadResult(t, postIdempotent(t, "key-1", file)); replayed.FileID != original.FileID {
+		t.Errorf("want the finished upload %s replayed, got %s", original.FileID, replayed.FileID)
+	}
+	if n := storedFiles(t); n != 1 {
+		t.Errorf("want 1 file stored, got %d", n)
+	}
+}
+
+// TestIdempotencyExpiry checks a key's result is forgotten after the TTL,
+// so the key can be used again
+func TestIdempotencyExpiry(t *testing.T) {
+	cache := newIdempotencyCache(50 * time.Millisecond)
+	result := &service.UploadResult{FileID: "file-1"}
+
+	if _, state := cache.begin("key", "a"); state != idempotencyNew {
+		t.Fatalf("want a new key, got state %d", state)
+	}
+	cache.finish("key", result)
+	if replayed, state := cache.begin("key", "a"); state != idempotencyDone || replayed != result {
+		t.Fatalf("want the result replayed, got state %d", state)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, state := cache.begin("key", "b"); state != idempotencyNew {
+		t.Errorf("want the expired key claimable for another upload, got state %d", state)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// fileLayoutHandler returns a file's ordered chunk list with offsets, sizes
+// and node locations, so clients can fetch chunks from nodes in parallel.
+// It goes through the same lookup and password checks as a download.
+func fileLayoutHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	download, err := fileService.DownloadFile(r.Context(), fileID, r.URL.Query().Get("password"))
+	if err != nil {
+		writeDownloadError(w, fileID, err)
+		return
+	}
+	defer download.Close()
+
+	layout, err := download.Layout()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to get file layout")
+		log.Printf("Layout of %s failed: %v", fileID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(layout)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// leaderLock is a lock at most one of the coordinators sharing a database
+// holds at a time, like metadata.LeaderLock
+type leaderLock interface {
+	TryAcquire(ctx context.Context) (bool, error)
+	Check(ctx context.Context) error
+	Release() error
+}
+
+// leaderElection runs a coordinator as one of several sharing a database,
+// only one of which leads at a time. The leader owns the node registry and
+// hash ring and runs the background jobs; the others stand by, answering
+// nothing but /health and /version, and keep no state of their own to fall
+// behind on, since everything else they need is in the database.
+type leaderElection struct {
+	lock     leaderLock
+	interval time.Duration
+	leading  atomic.Bool
+}
+
+// election is set when LEADER_ELECTION is on; nil means this coordinator
+// always leads
+var election *leaderElection
+
+func newLeaderElection(lock leaderLock, interval time.Duration) *leaderElection {
+	return &leaderElection{lock: lock, interval: interval}
+}
+
+// isLeader reports whether this coordinator should serve requests
+func (e *leaderElection) isLeader() bool {
+	return e == nil || e.leading.Load()
+}
+
+// role names this coordinator's part in the election for /health
+func (e *leaderElection) role() string {
+	if e.isLeader() {
+		return "leader"
+	}
+	return "standby"
+}
+
+// run tries to take the lock every interval until ctx is done, and once it
+// has it checks every interval that it still does. On taking it, lead is
+// called with a context that is cancelled if the lock is lost, so the jobs
+// it starts stop as soon as another coordinator could take over.
+func (e *leaderElection) run(ctx context.Context, lead func(ctx context.Context)) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var stopLeading context.CancelFunc
+	defer func() {
+		if stopLeading != nil {
+			stopLeading()
+		}
+	}()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, e.interval)
+		if e.leading.Load() {
+			if err := e.lock.Check(checkCtx); err != nil {
+				log.Printf("Lost leadership, standing by: %v", err)
+				e.leading.Store(false)
+				stopLeading()
+				stopLeading = nil
+			}
+		} else {
+			acquired, err := e.lock.TryAcquire(checkCtx)
+			if err != nil {
+				log.Printf("Leader election failed: %v", err)
+			} else if acquired {
+				log.Printf("Elected leader")
+				leaderCtx, cancelLeader := context.WithCancel(ctx)
+				stopLeading = cancelLeader
+				forgetNodes()
+				lead(leaderCtx)
+				e.leading.Store(true)
+			}
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resign gives up leadership on shutdown so a standby takes over without
+// waiting for this coordinator's session to time out
+func (e *leaderElection) resign() {
+	if e == nil {
+		return
+	}
+	e.leading.Store(false)
+	if err := e.lock.Release(); err != nil {
+		log.Printf("Failed to release leader lock: %v", err)
+	}
+}
+
+// forgetNodes empties the registry and ring. A coordinator taking over may
+// hold nodes from an earlier term that have since moved on; they register
+// again once their heartbeats reach it.
+func forgetNodes() {
+	for _, nodeInfo := range nodeRegistry.GetAllNodes() {
+		nodeRegistry.RemoveNode(nodeInfo.NodeID)
+		consistentHash.RemoveNode(nodeInfo.NodeID)
+	}
+}
+
+// leaderMiddleware answers 503 to every request a standby receives, other
+// than /health and /version, so clients and nodes move on to the leader
+func leaderMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if election.isLeader() || r.URL.Path == "/health" || r.URL.Path == "/version" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(election.interval.Seconds())+1))
+		writeJSONError(w, http.StatusServiceUnavailable, codeNotLeader, "This coordinator is a standby; send requests to the leader")
+	})
+}
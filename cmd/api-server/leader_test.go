@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/shard"
+)
+
+// sharedLock stands in for the advisory lock coordinators sharing a
+// database contend for
+type sharedLock struct {
+	mu     sync.Mutex
+	holder *testLock
+}
+
+// testLock is one coordinator's database session on a sharedLock
+type testLock struct {
+	shared *sharedLock
+	down   atomic.Bool // Set while the coordinator can't reach the database
+}
+
+func (l *testLock) TryAcquire(ctx context.Context) (bool, error) {
+	if l.down.Load() {
+		return false, errors.New("database unreachable")
+	}
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	if l.shared.holder == nil {
+		l.shared.holder = l
+	}
+	return l.shared.holder == l, nil
+}
+
+func (l *testLock) Check(ctx context.Context) error {
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	if l.down.Load() || l.shared.holder != l {
+		return errors.New("lost leader lock session")
+	}
+	return nil
+}
+
+func (l *testLock) Release() error {
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	if l.shared.holder == l {
+		l.shared.holder = nil
+	}
+	return nil
+}
+
+// fail cuts the coordinator off from the database, which ends its session
+// and with it any lock the session held
+func (l *testLock) fail() {
+	l.down.Store(true)
+	l.Release()
+}
+
+// testCoordinator is one coordinator taking part in an election
+type testCoordinator struct {
+	election *leaderElection
+	lock     *testLock
+	terms    chan context.Context // The context of each term it is elected for
+	stop     context.CancelFunc
+}
+
+// startElection runs a coordinator's election on shared until the test
+// ends or it is stopped
+func startElection(t *testing.T, shared *sharedLock, interval time.Duration) *testCoordinator {
+	t.Helper()
+
+	c := &testCoordinator{lock: &testLock{shared: shared}, terms: make(chan context.Context, 10)}
+	c.election = newLeaderElection(c.lock, interval)
+	ctx, stop := context.WithCancel(context.Background())
+	c.stop = stop
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.election.run(ctx, func(leaderCtx context.Context) { c.terms <- leaderCtx })
+	}()
+	t.Cleanup(func() {
+		stop()
+		<-done
+	})
+	return c
+}
+
+// elected waits for the coordinator's next term to start and returns its
+// context
+func (c *testCoordinator) elected(t *testing.T) context.Context {
+	t.Helper()
+
+	select {
+	case term := <-c.terms:
+		for deadline := time.Now().Add(5 * time.Second); !c.election.isLeader(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("elected but never reported leading")
+			}
+		}
+		return term
+	case <-time.After(5 * time.Second):
+		t.Fatal("not elected")
+		return nil
+	}
+}
+
+// standsBy checks the coordinator starts no term over several rounds of
+// the election
+func (c *testCoordinator) standsBy(t *testing.T, interval time.Duration) {
+	t.Helper()
+
+	select {
+	case <-c.terms:
+		t.Fatal("elected while another coordinator leads")
+	case <-time.After(5 * interval):
+	}
+	if c.election.isLeader() || c.election.role() != "standby" {
+		t.Fatal("reports leading while another coordinator leads")
+	}
+}
+
+// TestLeaderFailover runs two coordinators' elections and checks only one
+// leads, the other takes over when the leader loses the database or shuts
+// down, and a coordinator taking over starts with no nodes
+func TestLeaderFailover(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	const interval = 10 * time.Millisecond
+	shared := &sharedLock{}
+
+	first := startElection(t, shared, interval)
+	firstTerm := first.elected(t)
+	if first.election.role() != "leader" {
+		t.Errorf("want the elected coordinator to report leading, got %s", first.election.role())
+	}
+	second := startElection(t, shared, interval)
+	second.standsBy(t, interval)
+
+	// The leader loses the database: it steps down, stopping its jobs, and
+	// the standby takes over
+	first.lock.fail()
+	secondTerm := second.elected(t)
+	select {
+	case <-firstTerm.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("jobs of a coordinator that lost the lock kept running")
+	}
+	if first.election.isLeader() {
+		t.Error("coordinator that lost the lock still reports leading")
+	}
+
+	// Once back it stands by rather than competing with the new leader
+	first.lock.down.Store(false)
+	first.standsBy(t, interval)
+
+	// The leader shutting down hands over straight away, to a coordinator
+	// that forgets the nodes it knew from its last term
+	if err := nodeRegistry.RegisterNode(&node.NodeInfo{NodeID: "node-1", Address: "127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	consistentHash.AddNode("node-1")
+	second.stop()
+	second.election.resign()
+	select {
+	case <-secondTerm.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("jobs of a coordinator that shut down kept running")
+	}
+	first.elected(t)
+	if n := len(nodeRegistry.GetAllNodes()); n != 0 || consistentHash.GetNodeCount() != 0 {
+		t.Errorf("want the new leader to start with no nodes, got %d registered and %d on the ring", n, consistentHash.GetNodeCount())
+	}
+}
+
+// TestStandbyRefusesRequests checks a standby answers 503 to everything
+// but /health and /version, and its health check reports it standing by
+func TestStandbyRefusesRequests(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	store, err := dedup.NewChunkStore(filepath.Join(t.TempDir(), "chunks"), shard.DefaultDepth, dedup.IndexJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useChunkStore(t, store)
+
+	saved := election
+	t.Cleanup(func() { election = saved })
+	election = newLeaderElection(&testLock{shared: &sharedLock{}}, 2*time.Second)
+
+	handler := leaderMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := serve("/files")
+	checkErrorResponse(t, rec, http.StatusServiceUnavailable, codeNotLeader)
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("want Retry-After 3, got %q", got)
+	}
+	for _, path := range []string{"/health", "/version"} {
+		if rec := serve(path); rec.Code != http.StatusOK {
+			t.Errorf("%s: want it answered on a standby, got %d", path, rec.Code)
+		}
+	}
+
+	health := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var body map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, body
+	}
+	if status, body := health(); status != http.StatusServiceUnavailable || body["status"] != "standby" || body["role"] != "standby" {
+		t.Errorf("standby health: want 503 reporting standby, got %d %v", status, body)
+	}
+
+	election.leading.Store(true)
+	if rec := serve("/files"); rec.Code != http.StatusOK {
+		t.Errorf("leader: want requests served, got %d", rec.Code)
+	}
+	if status, body := health(); status != http.StatusOK || body["status"] != "healthy" || body["role"] != "leader" {
+		t.Errorf("leader health: want 200 reporting leader, got %d %v", status, body)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultMaintenanceRetryAfter is how long clients turned away during
+// maintenance are told to wait when the admin doesn't say
+const defaultMaintenanceRetryAfter = 60
+
+// maintenanceState is the body of /maintenance
+type maintenanceState struct {
+	Enabled           bool       `json:"enabled"`
+	RetryAfterSeconds int        `json:"retry_after_seconds,omitempty"`
+	Since             *time.Time `json:"since,omitempty"`
+}
+
+// maintenanceMode turns away requests that would change stored files, so a
+// coordinator can be upgraded without uploads in flight. Downloads and node
+// traffic carry on.
+type maintenanceMode struct {
+	mu    sync.RWMutex
+	state maintenanceState
+}
+
+var maintenance maintenanceMode
+
+// get returns the current maintenance state
+func (m *maintenanceMode) get() maintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// set turns maintenance on, telling clients to retry after retryAfter
+// seconds, or off
+func (m *maintenanceMode) set(enabled bool, retryAfter int) maintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !enabled {
+		m.state = maintenanceState{}
+		return m.state
+	}
+	if !m.state.Enabled {
+		now := time.Now()
+		m.state.Since = &now
+	}
+	m.state.Enabled = true
+	m.state.RetryAfterSeconds = retryAfter
+	return m.state
+}
+
+// readOnlyRoutes are POST routes that change nothing, so they stay open
+// during maintenance
+var readOnlyRoutes = map[string]bool{
+	"/analyze":                        true,
+	"/files/{fileID}/verify-password": true,
+}
+
+// checkRoutes only change anything when asked to repair what they find
+var checkRoutes = map[string]bool{
+	"/chunks/verify":             true,
+	"/chunks/{hash}/consistency": true,
+}
+
+// changesFiles reports whether a request may change stored files or
+// metadata. Reads, node traffic and /maintenance itself never do.
+func changesFiles(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return false
+	}
+	if internalRoutes[r.URL.Path] || r.URL.Path == "/maintenance" {
+		return false
+	}
+
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return true
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return true
+	}
+	if readOnlyRoutes[template] {
+		return false
+	}
+	if checkRoutes[template] {
+		repair, err := strconv.ParseBool(r.URL.Query().Get("repair"))
+		return err == nil && repair
+	}
+	return true
+}
+
+// maintenanceMiddleware answers 503 with a Retry-After to requests that
+// would change stored files while maintenance is on
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := maintenance.get()
+		if !state.Enabled || !changesFiles(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfterSeconds))
+		writeJSONError(w, http.StatusServiceUnavailable, codeMaintenance, "The coordinator is in maintenance; uploads and changes are paused, downloads still work")
+	})
+}
+
+// maintenanceHandler reports whether maintenance mode is on. It is an
+// admin route.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maintenance.get())
+}
+
+// setMaintenanceHandler turns maintenance mode on or off. It lasts until the
+// coordinator restarts. It is an admin route.
+func setMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var req maintenanceState
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid request body")
+		return
+	}
+	if req.RetryAfterSeconds < 0 {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "retry_after_seconds must be 0 or more")
+		return
+	}
+	if req.RetryAfterSeconds == 0 {
+		req.RetryAfterSeconds = defaultMaintenanceRetryAfter
+	}
+
+	state := maintenance.set(req.Enabled, req.RetryAfterSeconds)
+	if state.Enabled {
+		log.Printf("Client %q turned maintenance mode on", clientName(r))
+	} else {
+		log.Printf("Client %q turned maintenance mode off", clientName(r))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
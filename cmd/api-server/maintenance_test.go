@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/shard"
+)
+
+// setMaintenance calls PUT /maintenance with body and decodes the state it
+// returns
+func setMaintenance(t *testing.T, router http.Handler, body string) maintenanceState {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/maintenance", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT /maintenance %s: want 200, got %d: %s", body, rec.Code, rec.Body)
+	}
+	var state maintenanceState
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// TestMaintenanceMode turns maintenance on and checks uploads and other
+// changes are turned away with a Retry-After, while downloads, health
+// checks and node traffic still go through, until it is turned off
+func TestMaintenanceMode(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	store, err := dedup.NewChunkStore(filepath.Join(t.TempDir(), "chunks"), shard.DefaultDepth, dedup.IndexJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useChunkStore(t, store)
+	t.Cleanup(func() { maintenance.set(false, 0) })
+
+	// Node traffic and checks are only routed, not exercised
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router := mux.NewRouter()
+	router.HandleFunc("/upload", uploadHandler).Methods("POST")
+	router.HandleFunc("/download/{fileID}", downloadHandler).Methods("GET")
+	router.HandleFunc("/files/{fileID}", deleteFileHandler).Methods("DELETE")
+	router.HandleFunc("/health", healthHandler).Methods("GET")
+	router.HandleFunc("/heartbeat", ok).Methods("POST")
+	router.HandleFunc("/register", ok).Methods("POST")
+	router.HandleFunc("/analyze", ok).Methods("POST")
+	router.HandleFunc("/chunks/verify", ok).Methods("POST")
+	router.HandleFunc("/maintenance", maintenanceHandler).Methods("GET")
+	router.HandleFunc("/maintenance", setMaintenanceHandler).Methods("PUT")
+	router.Use(maintenanceMiddleware)
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+	data := randomBytes(t, 1000)
+	upload := func() *httptest.ResponseRecorder {
+		return serve(multipartRequest(t, "/upload", nil, testFile{"test.bin", data}))
+	}
+	fileID := decodeUploadResult(t, upload()).FileID
+
+	state := setMaintenance(t, router, `{"enabled": true, "retry_after_seconds": 120}`)
+	if !state.Enabled || state.RetryAfterSeconds != 120 || state.Since == nil {
+		t.Errorf("want maintenance on since now, retrying after 120s, got %+v", state)
+	}
+
+	rec := upload()
+	checkErrorResponse(t, rec, http.StatusServiceUnavailable, codeMaintenance)
+	if got := rec.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("want Retry-After 120, got %q", got)
+	}
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodDelete, "/files/"+fileID, nil),
+		httptest.NewRequest(http.MethodPost, "/chunks/verify?repair=true", nil),
+	} {
+		checkErrorResponse(t, serve(r), http.StatusServiceUnavailable, codeMaintenance)
+	}
+
+	rec = serve(httptest.NewRequest(http.MethodGet, "/download/"+fileID, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != string(data) {
+		t.Errorf("download: want the file, got %d", rec.Code)
+	}
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/heartbeat", nil),
+		httptest.NewRequest(http.MethodPost, "/register", nil),
+		httptest.NewRequest(http.MethodPost, "/analyze", nil),
+		httptest.NewRequest(http.MethodPost, "/chunks/verify", nil),
+		httptest.NewRequest(http.MethodGet, "/maintenance", nil),
+	} {
+		if rec := serve(r); rec.Code != http.StatusOK {
+			t.Errorf("%s %s: want it served in maintenance, got %d: %s", r.Method, r.URL, rec.Code, rec.Body)
+		}
+	}
+	rec = serve(httptest.NewRequest(http.MethodGet, "/health", nil))
+	var health map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || health["maintenance"] != true {
+		t.Errorf("health: want 200 reporting maintenance, got %d %v", rec.Code, health)
+	}
+
+	// Turning it on again keeps when it started; no retry means the default
+	again := setMaintenance(t, router, `{"enabled": true}`)
+	if again.RetryAfterSeconds != defaultMaintenanceRetryAfter || again.Since == nil || !again.Since.Equal(*state.Since) {
+		t.Errorf("want the default retry and the first start kept, got %+v", again)
+	}
+	for _, body := range []string{`{"enabled": true, "retry_after_seconds": -1}`, `not json`} {
+		rec := serve(httptest.NewRequest(http.MethodPut, "/maintenance", strings.NewReader(body)))
+		checkErrorResponse(t, rec, http.StatusBadRequest, codeBadRequest)
+	}
+
+	if state := setMaintenance(t, router, `{"enabled": false}`); state.Enabled || state.Since != nil {
+		t.Errorf("want maintenance off, got %+v", state)
+	}
+	if rec := upload(); rec.Code != http.StatusOK {
+		t.Errorf("upload after maintenance: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(httptest.NewRequest(http.MethodDelete, "/files/"+fileID, nil)); rec.Code != http.StatusOK {
+		t.Errorf("delete after maintenance: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// fileManifestHandler exports a file as a signed manifest for archival. It
+// goes through the same lookup and password checks as a download.
+func fileManifestHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	download, err := fileService.DownloadFile(r.Context(), fileID, r.URL.Query().Get("password"))
+	if err != nil {
+		writeDownloadError(w, fileID, err)
+		return
+	}
+	defer download.Close()
+
+	manifest, err := download.Manifest()
+	if errors.Is(err, service.ErrManifestKeyMissing) {
+		writeJSONError(w, http.StatusServiceUnavailable, codeNotConfigured, "Manifest signing not configured")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to build manifest")
+		log.Printf("Manifest of %s failed: %v", fileID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// restoreManifestHandler recreates a file from a manifest. The multipart
+// form carries the manifest JSON in a "manifest" field and any chunk data in
+// "chunk" files named by their hash; chunks left out must still be stored in
+// this cluster.
+func restoreManifestHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Failed to parse form")
+		return
+	}
+	defer removeMultipartFiles(r)
+
+	var manifest service.Manifest
+	if err := json.Unmarshal([]byte(r.FormValue("manifest")), &manifest); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidManifest, "Invalid manifest")
+		return
+	}
+
+	chunkData := make(map[string][]byte)
+	for _, header := range r.MultipartForm.File["chunk"] {
+		file, err := header.Open()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Failed to read chunk")
+			return
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Failed to read chunk")
+			return
+		}
+		chunkData[header.Filename] = data
+	}
+
+	file, err := fileService.RestoreManifest(r.Context(), &manifest, chunkData)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrManifestKeyMissing):
+			writeJSONError(w, http.StatusServiceUnavailable, codeNotConfigured, "Manifest signing not configured")
+		case errors.Is(err, service.ErrInvalidManifest):
+			writeJSONError(w, http.StatusBadRequest, codeInvalidManifest, err.Error())
+		case errors.Is(err, service.ErrFileExists):
+			writeJSONError(w, http.StatusConflict, codeFileExists, "File already exists")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to restore file")
+		}
+		log.Printf("Restore of %s failed: %v", manifest.FileID, err)
+		return
+	}
+
+	log.Printf("Restored file %s from manifest", file.FileID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(file)
+}
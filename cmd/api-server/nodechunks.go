@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// nodeChunksHandler returns the chunk listing of a storage node, fetched
+// from the node, so operators can inspect nodes they can't reach directly
+func nodeChunksHandler(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["nodeID"]
+
+	nodeInfo, err := nodeRegistry.GetNode(nodeID)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, codeNodeNotFound, "Unknown node")
+		return
+	}
+
+	// GetHealthyNodes also brings the node's status up to date
+	healthy := false
+	for _, candidate := range nodeRegistry.GetHealthyNodes() {
+		if candidate.NodeID == nodeID {
+			healthy = true
+			break
+		}
+	}
+	if !healthy {
+		writeJSONError(w, http.StatusServiceUnavailable, codeNodeUnavailable,
+			fmt.Sprintf("Node %s is %s", nodeID, nodeInfo.Status))
+		return
+	}
+
+	chunks, err := fileService.NodeChunks(r.Context(), nodeInfo)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, codeNodeUnavailable,
+			fmt.Sprintf("Node %s did not list its chunks", nodeID))
+		log.Printf("Listing chunks of node %s failed: %v", nodeID, err)
+		return
+	}
+	sort.Strings(chunks)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.ListChunksResponse{
+		NodeID: nodeID,
+		Count:  len(chunks),
+		Chunks: chunks,
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// callNodeChunks calls GET /nodes/{nodeID}/chunks
+func callNodeChunks(nodeID string) *httptest.ResponseRecorder {
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/nodes/"+nodeID+"/chunks", nil), map[string]string{"nodeID": nodeID})
+	rec := httptest.NewRecorder()
+	nodeChunksHandler(rec, r)
+	return rec
+}
+
+// TestNodeChunksProxy registers a fake node and checks the coordinator
+// returns the chunk listing it serves, and a clear error for a node that is
+// unknown, offline, or fails to answer
+func TestNodeChunksProxy(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+
+	var failing atomic.Bool
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/chunks" {
+			http.NotFound(w, r)
+			return
+		}
+		if failing.Load() {
+			http.Error(w, "disk error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(node.ListChunksResponse{NodeID: "fake", Count: 3, Chunks: []string{"cc", "aa", "bb"}})
+	}))
+	defer fake.Close()
+	if err := nodeRegistry.RegisterNode(&node.NodeInfo{NodeID: "fake", Address: strings.TrimPrefix(fake.URL, "http://")}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := callNodeChunks("fake")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var listing node.ListChunksResponse
+	if err := json.NewDecoder(rec.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+	if listing.NodeID != "fake" || listing.Count != 3 || strings.Join(listing.Chunks, ",") != "aa,bb,cc" {
+		t.Errorf("want the node's 3 chunks, sorted, got %+v", listing)
+	}
+
+	failing.Store(true)
+	checkErrorResponse(t, callNodeChunks("fake"), http.StatusBadGateway, codeNodeUnavailable)
+
+	fake.Close()
+	checkErrorResponse(t, callNodeChunks("fake"), http.StatusBadGateway, codeNodeUnavailable)
+
+	// A node that stopped sending heartbeats and can't be reached is
+	// reported offline, without trying it
+	nodeRegistry = node.NewRegistry(50 * time.Millisecond)
+	if err := nodeRegistry.RegisterNode(&node.NodeInfo{NodeID: "fake", Address: strings.TrimPrefix(fake.URL, "http://")}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	rec = callNodeChunks("fake")
+	checkErrorResponse(t, rec, http.StatusServiceUnavailable, codeNodeUnavailable)
+	if !strings.Contains(rec.Body.String(), "is "+node.StatusOffline) {
+		t.Errorf("want the error to say the node is offline, got %s", rec.Body)
+	}
+
+	checkErrorResponse(t, callNodeChunks("missing"), http.StatusNotFound, codeNodeNotFound)
+}
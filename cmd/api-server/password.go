@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// VerifyPasswordRequest carries a password to check against an encrypted file
+type VerifyPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// verifyPasswordHandler reports whether a password opens an encrypted file,
+// so clients can check it before starting a download
+func verifyPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	var req VerifyPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid request")
+		return
+	}
+	if req.Password == "" {
+		writeJSONError(w, http.StatusBadRequest, codePasswordRequired, "Password required")
+		return
+	}
+
+	valid, err := fileService.VerifyFilePassword(r.Context(), fileID, req.Password)
+	if err != nil {
+		var missing *metadata.MissingChunksError
+		switch {
+		case errors.Is(err, service.ErrNotEncrypted):
+			writeJSONError(w, http.StatusBadRequest, codeNotEncrypted, "File is not encrypted")
+		case errors.Is(err, metadata.ErrFileNotFound) || errors.As(err, &missing):
+			writeDownloadError(w, fileID, err)
+		default:
+			log.Printf("Password check of %s failed: %v", fileID, err)
+			writeJSONError(w, http.StatusInternalServerError, codeNodeUnavailable, "Failed to retrieve chunk")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"file_id": fileID,
+		"valid":   valid,
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// verifyPassword sends POST /files/{fileID}/verify-password with body
+func verifyPassword(fileID, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/files/"+fileID+"/verify-password", strings.NewReader(body))
+	verifyPasswordHandler(rec, mux.SetURLVars(r, map[string]string{"fileID": fileID}))
+	return rec
+}
+
+// TestVerifyPassword checks the correct password is reported valid and a
+// wrong one invalid, and the errors for requests that can't be checked
+func TestVerifyPassword(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+	ctx := context.Background()
+
+	data := randomBytes(t, 1000)
+	encrypted, err := fileService.UploadFile(ctx, bytes.NewReader(data), service.UploadMetadata{FileName: "secret.bin", Size: int64(len(data)), Password: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := fileService.UploadFile(ctx, bytes.NewReader(data), service.UploadMetadata{FileName: "plain.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for password, want := range map[string]bool{"s3cret": true, "wrong": false} {
+		rec := verifyPassword(encrypted.FileID, `{"password": "`+password+`"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: want 200, got %d: %s", password, rec.Code, rec.Body)
+		}
+		var result struct {
+			FileID string `json:"file_id"`
+			Valid  bool   `json:"valid"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.FileID != encrypted.FileID || result.Valid != want {
+			t.Errorf("%q: want valid %v for %s, got %+v", password, want, encrypted.FileID, result)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		fileID string
+		body   string
+		status int
+		code   string
+	}{
+		{"invalid body", encrypted.FileID, "s3cret", http.StatusBadRequest, codeBadRequest},
+		{"no password", encrypted.FileID, `{}`, http.StatusBadRequest, codePasswordRequired},
+		{"unencrypted file", plain.FileID, `{"password": "s3cret"}`, http.StatusBadRequest, codeNotEncrypted},
+		{"unknown file", "missing", `{"password": "s3cret"}`, http.StatusNotFound, codeFileNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			checkErrorResponse(t, verifyPassword(tc.fileID, tc.body), tc.status, tc.code)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// failedChunksHandler lists replicated chunks queued because they were
+// stored on fewer nodes than they should be
+func failedChunksHandler(w http.ResponseWriter, r *http.Request) {
+	placements, err := fileService.FailedPlacements()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to list failed chunks")
+		log.Printf("Database error listing failed placements: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":  len(placements),
+		"chunks": placements,
+	})
+}
+
+// retryFailedPlacements periodically copies queued chunks to more nodes
+// until they reach their replication
+func retryFailedPlacements(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		placed, remaining, err := fileService.RetryFailedPlacements(ctx)
+		if err != nil {
+			log.Printf("Placement retry failed: %v", err)
+			continue
+		}
+		if placed > 0 || remaining > 0 {
+			log.Printf("Placement retry: %d chunks placed, %d still queued", placed, remaining)
+		}
+	}
+}
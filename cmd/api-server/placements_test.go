@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// TestFailedChunks checks GET /failed-chunks lists the queued chunks
+func TestFailedChunks(t *testing.T) {
+	s := useTestService(t, metadata.NewMemoryStore())
+
+	list := func() (int, []metadata.FailedPlacement) {
+		rec := httptest.NewRecorder()
+		failedChunksHandler(rec, httptest.NewRequest(http.MethodGet, "/failed-chunks", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var listing struct {
+			Count  int                        `json:"count"`
+			Chunks []metadata.FailedPlacement `json:"chunks"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&listing); err != nil {
+			t.Fatal(err)
+		}
+		return listing.Count, listing.Chunks
+	}
+
+	if count, chunks := list(); count != 0 || chunks == nil || len(chunks) != 0 {
+		t.Errorf("empty queue: want an empty list, got %d: %v", count, chunks)
+	}
+
+	data := randomBytes(t, 1000)
+	result, err := s.UploadFile(context.Background(), bytes.NewReader(data), service.UploadMetadata{FileName: "test.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := result.ChunkHashes[0]
+	if err := db.RecordFailedPlacement(hash, []string{"node-2"}, "stored on 1 of 2 nodes"); err != nil {
+		t.Fatal(err)
+	}
+
+	count, chunks := list()
+	if count != 1 || len(chunks) != 1 || chunks[0].ChunkHash != hash || chunks[0].Reason != "stored on 1 of 2 nodes" {
+		t.Errorf("want %s listed, got %d: %+v", hash[:8], count, chunks)
+	}
+}
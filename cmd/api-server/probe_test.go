@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// TestProbeMarksSilentNodeDegraded registers a fake node that answers
+// /health but sends no heartbeats, and checks the probe loop keeps it
+// listed as degraded rather than offline
+func TestProbeMarksSilentNodeDegraded(t *testing.T) {
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status":"healthy"}`))
+	}))
+	defer fake.Close()
+
+	defer func(saved *node.Registry) { nodeRegistry = saved }(nodeRegistry)
+	nodeRegistry = node.NewRegistry(100 * time.Millisecond)
+	if err := nodeRegistry.RegisterNode(&node.NodeInfo{NodeID: "silent", Address: strings.TrimPrefix(fake.URL, "http://")}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go probeNodes(ctx, 20*time.Millisecond, nil)
+
+	// Past the heartbeat timeout, and probed since
+	time.Sleep(300 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	listNodesHandler(rec, httptest.NewRequest(http.MethodGet, "/nodes", nil))
+
+	var listed struct {
+		Nodes []node.NodeInfo `json:"nodes"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Nodes) != 1 || listed.Nodes[0].Status != node.StatusDegraded {
+		t.Fatalf("want the node listed as degraded, got %+v", listed.Nodes)
+	}
+	if len(nodeRegistry.GetHealthyNodes()) != 1 {
+		t.Error("a node the coordinator can reach should still serve requests")
+	}
+
+	// Once the node stops answering too, it goes offline
+	fake.Close()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	if nodes := nodeRegistry.GetAllNodes(); nodes[0].Status != node.StatusOffline {
+		t.Errorf("want the unreachable node offline, got %s", nodes[0].Status)
+	}
+}
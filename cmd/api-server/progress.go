@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// pendingUploadTimeout is how long an upload ID is kept before its upload starts
+	pendingUploadTimeout = 10 * time.Minute
+
+	// finishedUploadRetention is how long the final event stays available
+	// to subscribers that connect after the upload finished
+	finishedUploadRetention = time.Minute
+)
+
+// progressEvent is one Server-Sent Event: "progress" carries a
+// service.UploadProgress, "complete" the upload result, "error" a message
+type progressEvent struct {
+	name string
+	data interface{}
+}
+
+// trackedUpload holds the latest event of an upload. Subscribers are only
+// notified that it changed and then read it, so a slow subscriber skips
+// intermediate snapshots but never misses the final event.
+type trackedUpload struct {
+	created  time.Time
+	started  bool
+	finished time.Time // Zero while the upload is pending or running
+	latest   *progressEvent
+
+	subscribers map[chan struct{}]struct{}
+}
+
+// progressHub tracks uploads registered through POST /upload/init
+type progressHub struct {
+	mu        sync.Mutex
+	uploads   map[string]*trackedUpload
+	lastSweep time.Time
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{
+		uploads:   make(map[string]*trackedUpload),
+		lastSweep: time.Now(),
+	}
+}
+
+// register creates a new upload ID, dropping uploads that expired
+func (h *progressHub) register() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.lastSweep) > time.Minute {
+		for id, upload := range h.uploads {
+			if upload.expired(now) {
+				h.remove(id, upload)
+			}
+		}
+		h.lastSweep = now
+	}
+
+	id := uuid.New().String()
+	h.uploads[id] = &trackedUpload{
+		created:     now,
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+	return id
+}
+
+func (u *trackedUpload) expired(now time.Time) bool {
+	if !u.finished.IsZero() {
+		return now.Sub(u.finished) > finishedUploadRetention
+	}
+	return !u.started && now.Sub(u.created) > pendingUploadTimeout
+}
+
+// remove forgets an upload and closes its subscribers' channels. Callers
+// hold h.mu.
+func (h *progressHub) remove(id string, upload *trackedUpload) {
+	for ch := range upload.subscribers {
+		close(ch)
+	}
+	delete(h.uploads, id)
+}
+
+// start claims an upload ID for an upload. An ID can only be used once.
+func (h *progressHub) start(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	upload, ok := h.uploads[id]
+	if !ok || upload.started {
+		return false
+	}
+	upload.started = true
+	return true
+}
+
+// publish records an upload's latest event and wakes its subscribers. A
+// "complete" or "error" event finishes the upload.
+func (h *progressHub) publish(id string, event progressEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	upload, ok := h.uploads[id]
+	if !ok {
+		return
+	}
+
+	upload.latest = &event
+	if event.name != "progress" {
+		upload.finished = time.Now()
+	}
+
+	for ch := range upload.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default: // Already notified; the subscriber will read the latest event
+		}
+	}
+}
+
+// subscribe returns a channel that is signalled whenever the upload's
+// latest event changes, and a function to stop listening
+func (h *progressHub) subscribe(id string) (<-chan struct{}, func(), bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	upload, ok := h.uploads[id]
+	if !ok {
+		return nil, nil, false
+	}
+
+	ch := make(chan struct{}, 1)
+	upload.subscribers[ch] = struct{}{}
+	if upload.latest != nil {
+		ch <- struct{}{}
+	}
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := upload.subscribers[ch]; ok {
+			delete(upload.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe, true
+}
+
+// latest returns the upload's latest event, and whether it is the last one
+func (h *progressHub) latest(id string) (*progressEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	upload, ok := h.uploads[id]
+	if !ok {
+		return nil, true
+	}
+	return upload.latest, !upload.finished.IsZero()
+}
+
+// initUploadHandler hands out an upload ID. Passing it as upload_id to
+// POST /upload publishes that upload's progress on /upload/{id}/progress.
+func initUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id := uploadProgress.register()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"upload_id":    id,
+		"progress_url": "/upload/" + id + "/progress",
+	})
+}
+
+// uploadProgressHandler streams an upload's progress as Server-Sent Events
+// until the upload completes or fails
+func uploadProgressHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["uploadID"]
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Streaming not supported")
+		return
+	}
+
+	updates, unsubscribe, ok := uploadProgress.subscribe(id)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, codeUploadNotFound, "Upload not found")
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case _, open := <-updates:
+			if !open {
+				return
+			}
+		}
+
+		event, final := uploadProgress.latest(id)
+		if event != nil {
+			if err := writeEvent(w, event); err != nil {
+				log.Printf("Progress stream for upload %s closed: %v", id, err)
+				return
+			}
+			flusher.Flush()
+		}
+		if final {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *progressEvent) error {
+	data, err := json.Marshal(event.data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, data)
+	return err
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// sseEvent is one Server-Sent Event read off a progress stream
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents reads a progress stream until the server closes it
+func readEvents(t *testing.T, body io.Reader) []sseEvent {
+	t.Helper()
+
+	var events []sseEvent
+	var event sseEvent
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events = append(events, event)
+			event = sseEvent{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// newProgressServer serves the upload and progress endpoints over HTTP, so
+// progress streams are read as a client would
+func newProgressServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	useTestService(t, metadata.NewMemoryStore())
+	router := mux.NewRouter()
+	router.HandleFunc("/upload", uploadHandler).Methods("POST")
+	router.HandleFunc("/upload/init", initUploadHandler).Methods("POST")
+	router.HandleFunc("/upload/{uploadID}/progress", uploadProgressHandler).Methods("GET")
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// initUpload gets an upload ID from the server
+func initUpload(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+
+	resp, err := http.Post(server.URL+"/upload/init", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var init struct {
+		UploadID    string `json:"upload_id"`
+		ProgressURL string `json:"progress_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&init); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated || init.ProgressURL != "/upload/"+init.UploadID+"/progress" {
+		t.Fatalf("want 201 with the progress URL, got %d: %+v", resp.StatusCode, init)
+	}
+	return init.UploadID
+}
+
+// postUpload sends a multipart upload to the server
+func postUpload(t *testing.T, server *httptest.Server, fields map[string]string, file testFile) *http.Response {
+	t.Helper()
+
+	r := multipartRequest(t, "/upload", fields, file)
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/upload", r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// TestUploadProgressEvents subscribes to an upload's progress, drives the
+// upload, and checks the events end with its result; a subscriber that
+// arrives late still gets the final event
+func TestUploadProgressEvents(t *testing.T) {
+	server := newProgressServer(t)
+	uploadID := initUpload(t, server)
+
+	// Headers arrive once the subscription is in place
+	stream, err := http.Get(server.URL + "/upload/" + uploadID + "/progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	if ct := stream.Header.Get("Content-Type"); stream.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("want a 200 event stream, got %d %s", stream.StatusCode, ct)
+	}
+
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	resp := postUpload(t, server, map[string]string{"upload_id": uploadID}, testFile{"big.bin", data})
+	defer resp.Body.Close()
+	var result service.UploadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload failed with %d: %v", resp.StatusCode, err)
+	}
+
+	events := readEvents(t, stream.Body)
+	if len(events) < 2 {
+		t.Fatalf("want progress events and a final one, got %v", events)
+	}
+	var lastChunked int64
+	for _, event := range events[:len(events)-1] {
+		var progress service.UploadProgress
+		if event.name != "progress" || json.Unmarshal([]byte(event.data), &progress) != nil {
+			t.Fatalf("want a progress event, got %+v", event)
+		}
+		if progress.TotalBytes != int64(len(data)) || progress.BytesChunked < lastChunked {
+			t.Errorf("progress out of order or for the wrong upload: %+v", progress)
+		}
+		lastChunked = progress.BytesChunked
+	}
+
+	final := events[len(events)-1]
+	var completed service.UploadResult
+	if final.name != "complete" || json.Unmarshal([]byte(final.data), &completed) != nil || completed.FileID != result.FileID {
+		t.Errorf("want a complete event for file %s, got %+v", result.FileID, final)
+	}
+
+	late, err := http.Get(server.URL + "/upload/" + uploadID + "/progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Body.Close()
+	if events := readEvents(t, late.Body); len(events) != 1 || events[0].name != "complete" {
+		t.Errorf("late subscriber: want only the complete event, got %+v", events)
+	}
+}
+
+// TestUploadProgressUnknownID checks an upload ID must come from init and
+// can only be used once, and unknown IDs have no progress stream
+func TestUploadProgressUnknownID(t *testing.T) {
+	server := newProgressServer(t)
+
+	resp, err := http.Get(server.URL + "/upload/unknown/progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("progress of an unknown upload: want 404, got %d", resp.StatusCode)
+	}
+
+	resp = postUpload(t, server, map[string]string{"upload_id": "unknown"}, testFile{"a.bin", []byte("data")})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("upload with an unknown ID: want 400, got %d", resp.StatusCode)
+	}
+
+	uploadID := initUpload(t, server)
+	for i, want := range []int{http.StatusOK, http.StatusBadRequest} {
+		resp := postUpload(t, server, map[string]string{"upload_id": uploadID}, testFile{"a.bin", []byte("data")})
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("upload %d with the same ID: want %d, got %d", i+1, want, resp.StatusCode)
+		}
+	}
+}
+
+// TestUploadWithoutProgress checks an upload without an ID still gets the
+// same synchronous response
+func TestUploadWithoutProgress(t *testing.T) {
+	server := newProgressServer(t)
+
+	resp := postUpload(t, server, nil, testFile{"a.bin", []byte("data")})
+	defer resp.Body.Close()
+	var result service.UploadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || resp.StatusCode != http.StatusOK || result.FileID == "" {
+		t.Errorf("want 200 with the upload result, got %d: %v", resp.StatusCode, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// downloadETag returns the strong entity tag of a file: its SHA-256, or for
+// files with no recorded hash, such as encrypted ones, its ID. Either way it
+// only changes with the contents, since a file ID's contents never do.
+func downloadETag(file *metadata.FileRecord) string {
+	if file.FileHash != "" {
+		return `"` + file.FileHash + `"`
+	}
+	return `"` + file.FileID + `"`
+}
+
+// parseRange parses a single-range "bytes=" Range header against a file of
+// the given size and returns the half-open range [start, end). ok is false
+// when the header is absent, malformed, or asks for multiple ranges; those
+// requests are answered with the whole file.
+func parseRange(header string, size int64) (start, end int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	// Suffix range: the final N bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+
+	end = size
+	if last != "" {
+		lastByte, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || lastByte < start {
+			return 0, 0, false, nil
+		}
+		if lastByte < size {
+			end = lastByte + 1
+		}
+	}
+
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+
+	return start, end, true, nil
+}
+
+// partialContentWriter sends the 206 status and range headers with the
+// first Write, so an error found before any data is streamed can still be
+// reported with a proper error status
+type partialContentWriter struct {
+	http.ResponseWriter
+	start, end, size int64
+	started          bool
+}
+
+func (pw *partialContentWriter) Write(p []byte) (int, error) {
+	if !pw.started {
+		pw.started = true
+		pw.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", pw.start, pw.end-1, pw.size))
+		pw.Header().Set("Content-Length", strconv.FormatInt(pw.end-pw.start, 10))
+		pw.WriteHeader(http.StatusPartialContent)
+	}
+	return pw.ResponseWriter.Write(p)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTimeout is how long a client's limiter is kept after its last request
+const limiterIdleTimeout = 10 * time.Minute
+
+// clientLimiter rate-limits requests per client. Authenticated requests are
+// keyed by API client name, anonymous ones by remote IP.
+type clientLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*clientEntry
+	lastSweep time.Time
+}
+
+type clientEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newClientLimiter allows each client requestsPerSecond sustained requests
+// with bursts of up to burst. A non-positive rate disables limiting.
+func newClientLimiter(requestsPerSecond float64, burst int) *clientLimiter {
+	return &clientLimiter{
+		limit:     rate.Limit(requestsPerSecond),
+		burst:     burst,
+		clients:   make(map[string]*clientEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Limit wraps a handler, answering 429 with a Retry-After header once the
+// client exceeds its rate
+func (cl *clientLimiter) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cl.limit <= 0 {
+			next(w, r)
+			return
+		}
+
+		reservation := cl.limiterFor(clientKeyFor(r)).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			// Give the token back; the request is rejected, not queued
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, codeRateLimited, "Rate limit exceeded")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// limiterFor returns the client's limiter, creating it on first use and
+// dropping limiters of clients that have gone idle
+func (cl *clientLimiter) limiterFor(key string) *rate.Limiter {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(cl.lastSweep) > time.Minute {
+		for k, entry := range cl.clients {
+			if now.Sub(entry.lastSeen) > limiterIdleTimeout {
+				delete(cl.clients, k)
+			}
+		}
+		cl.lastSweep = now
+	}
+
+	entry, exists := cl.clients[key]
+	if !exists {
+		entry = &clientEntry{limiter: rate.NewLimiter(cl.limit, cl.burst)}
+		cl.clients[key] = entry
+	}
+	entry.lastSeen = now
+
+	return entry.limiter
+}
+
+// clientKeyFor identifies the client behind a request for rate limiting
+func clientKeyFor(r *http.Request) string {
+	if name := clientName(r); name != "" {
+		return "client:" + name
+	}
+
+	return "ip:" + remoteHost(r)
+}
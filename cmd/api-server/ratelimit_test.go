@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestRateLimit hammers a limited endpoint from one client and checks the
+// requests past its burst get 429 with a Retry-After, while another client
+// is unaffected
+func TestRateLimit(t *testing.T) {
+	const burst = 5
+	limiter := newClientLimiter(1, burst)
+	handler := limiter.Limit(func(w http.ResponseWriter, r *http.Request) {})
+
+	send := func(remoteAddr, client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		req.RemoteAddr = remoteAddr
+		if client != "" {
+			req = req.WithContext(context.WithValue(req.Context(), clientKey, client))
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	var mu sync.Mutex
+	codes := make(map[int]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 4*burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := send("10.0.0.1:1234", "")
+			if rec.Code == http.StatusTooManyRequests {
+				if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 {
+					t.Errorf("want a Retry-After of at least 1 second, got %q", rec.Header().Get("Retry-After"))
+				}
+			}
+			mu.Lock()
+			codes[rec.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if codes[http.StatusOK] != burst || codes[http.StatusTooManyRequests] != 3*burst {
+		t.Errorf("want %d allowed and %d limited, got %v", burst, 3*burst, codes)
+	}
+
+	// Other addresses, and the same address authenticated as a client,
+	// have limits of their own
+	if rec := send("10.0.0.2:1234", ""); rec.Code != http.StatusOK {
+		t.Errorf("another address: want 200, got %d", rec.Code)
+	}
+	if rec := send("10.0.0.1:1234", "alice"); rec.Code != http.StatusOK {
+		t.Errorf("an API client: want 200, got %d", rec.Code)
+	}
+}
+
+// TestRateLimitDisabled checks a non-positive rate lets everything through
+func TestRateLimitDisabled(t *testing.T) {
+	handler := newClientLimiter(0, 0).Limit(func(w http.ResponseWriter, r *http.Request) {})
+
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/upload", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: want 200, got %d", i, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/version"
+)
+
+// TestRegisterProtocol registers nodes speaking the current protocol, one
+// too old to report any, and one speaking an unsupported protocol, and
+// checks only the last is turned away
+func TestRegisterProtocol(t *testing.T) {
+	defer func(saved *node.Registry) { nodeRegistry = saved }(nodeRegistry)
+	defer func(saved *node.ConsistentHash) { consistentHash = saved }(consistentHash)
+
+	nodeRegistry = node.NewRegistry(time.Minute)
+	ring, err := node.NewConsistentHash(node.DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consistentHash = ring
+
+	register := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		registerNodeHandler(rec, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body)))
+		return rec
+	}
+
+	current := `{"node_id": "current", "address": "127.0.0.1:9001", "protocol_version": ` + strconv.Itoa(version.Protocol) + `, "transports": ["json", "binary"]}`
+	if rec := register(current); rec.Code != http.StatusOK {
+		t.Fatalf("current protocol: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := register(`{"node_id": "old", "address": "127.0.0.1:9002"}`); rec.Code != http.StatusOK {
+		t.Fatalf("no protocol reported: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	for nodeID, binary := range map[string]bool{"current": true, "old": false} {
+		info, err := nodeRegistry.GetNode(nodeID)
+		if err != nil {
+			t.Fatalf("%s: %v", nodeID, err)
+		}
+		if !info.Supports(node.TransportJSON) || info.Supports(node.TransportBinary) != binary {
+			t.Errorf("%s: want JSON served and binary %v, got transports %v", nodeID, binary, info.Transports)
+		}
+	}
+
+	future := `{"node_id": "future", "address": "127.0.0.1:9003", "protocol_version": ` + strconv.Itoa(version.Protocol+1) + `}`
+	checkErrorResponse(t, register(future), http.StatusBadRequest, codeProtocolMismatch)
+	if _, err := nodeRegistry.GetNode("future"); err == nil {
+		t.Error("incompatible node registered")
+	}
+	if n := consistentHash.GetNodeCount(); n != 2 {
+		t.Errorf("want only the 2 compatible nodes on the ring, got %d", n)
+	}
+}
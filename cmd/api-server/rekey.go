@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// RekeyRequest carries the current and new password of an encrypted file
+type RekeyRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// rekeyFileHandler re-encrypts a file under a new password, keeping its ID
+func rekeyFileHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	var req RekeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid request")
+		return
+	}
+	if req.NewPassword == "" {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "New password required")
+		return
+	}
+
+	file, err := fileService.RekeyFile(r.Context(), fileID, req.OldPassword, req.NewPassword)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotEncrypted):
+			writeJSONError(w, http.StatusBadRequest, codeNotEncrypted, "File is not encrypted")
+		case errors.Is(err, service.ErrDecryptionFailed):
+			writeJSONError(w, http.StatusUnauthorized, codeBadPassword, "Decryption failed - incorrect password?")
+		default:
+			writeDownloadError(w, fileID, err)
+		}
+		return
+	}
+
+	log.Printf("Rekeyed file %s", fileID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// replicateFileHandler copies a file's under-replicated chunks to healthy
+// nodes right away and reports what it did for each chunk
+func replicateFileHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	report, err := fileService.ReplicateFile(r.Context(), fileID)
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found")
+		return
+	}
+	var missing *metadata.MissingChunksError
+	if errors.As(err, &missing) {
+		writeMissingChunks(w, missing)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to re-replicate file")
+		log.Printf("Re-replication of %s failed: %v", fileID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
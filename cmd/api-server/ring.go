@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// maxRingSamples bounds the samples parameter of /ring
+const maxRingSamples = 1000000
+
+// RingResponse is the consistent-hash ring as seen by the coordinator
+type RingResponse struct {
+	node.RingStats
+	Key       string   `json:"key,omitempty"`       // Sample key, e.g. a chunk hash
+	Placement []string `json:"placement,omitempty"` // Nodes GetNodes picks for Key, in order
+}
+
+// ringHandler exposes the ring read-only for debugging placement. With
+// ?key= it shows which nodes that key maps to (?count= nodes, default the
+// replication factor); with ?samples=N it places N random keys to show how
+// evenly the ring spreads load.
+func ringHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	samples := 0
+	if value := query.Get("samples"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > maxRingSamples {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid samples")
+			return
+		}
+		samples = n
+	}
+
+	count := service.ReplicationCount
+	if value := query.Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid count")
+			return
+		}
+		count = n
+	}
+
+	response := RingResponse{RingStats: consistentHash.GetRingStats(samples)}
+
+	if key := query.Get("key"); key != "" {
+		response.Key = key
+		placement, err := consistentHash.GetNodes(key, count)
+		if err == nil {
+			response.Placement = placement
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// ShareRequest holds the optional limits of a new share link
+type ShareRequest struct {
+	Password     string `json:"password"`      // Embedded so encrypted files download without it
+	ExpiresIn    string `json:"expires_in"`    // Duration such as "24h"; empty never expires
+	MaxDownloads int    `json:"max_downloads"` // Zero for no limit
+}
+
+// ShareResponse describes a new share link. The token is shown only once.
+type ShareResponse struct {
+	*service.Share
+	URL string `json:"url"`
+}
+
+// shareFileHandler creates a share link that downloads a file without auth
+func shareFileHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	// The body is optional; an empty one creates a link without limits
+	var req ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid request")
+		return
+	}
+
+	opts := service.ShareOptions{Password: req.Password, MaxDownloads: req.MaxDownloads}
+	if req.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid expires_in")
+			return
+		}
+		opts.ExpiresIn = expiresIn
+	}
+	if req.MaxDownloads < 0 {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid max_downloads")
+		return
+	}
+
+	share, err := fileService.ShareFile(fileID, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotEncrypted):
+			writeJSONError(w, http.StatusBadRequest, codeNotEncrypted, "Password given for a file that is not encrypted")
+		default:
+			writeDownloadError(w, fileID, err)
+		}
+		return
+	}
+
+	log.Printf("Created share link for file %s", fileID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ShareResponse{Share: share, URL: "/s/" + share.Token})
+}
+
+// sharedDownloadHandler downloads the file behind a share link. It needs no
+// auth; encrypted files whose link doesn't embed a password take one as the
+// password query parameter.
+func sharedDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	download, err := fileService.OpenShare(r.Context(), token, r.URL.Query().Get("password"))
+	if err != nil {
+		switch {
+		case errors.Is(err, metadata.ErrShareNotFound):
+			writeJSONError(w, http.StatusNotFound, codeShareNotFound, "Share link not found")
+		case errors.Is(err, metadata.ErrShareExpired):
+			writeJSONError(w, http.StatusGone, codeShareExpired, "Share link expired or download limit reached")
+		default:
+			writeDownloadError(w, "shared file", err)
+		}
+		return
+	}
+	defer download.Close()
+
+	serveDownload(w, r, download)
+}
+
+// revokeShareHandler deletes a share link
+func revokeShareHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	if err := fileService.RevokeShare(token); err != nil {
+		if errors.Is(err, metadata.ErrShareNotFound) {
+			writeJSONError(w, http.StatusNotFound, codeShareNotFound, "Share link not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to revoke share link")
+		log.Printf("Database error revoking share link: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// TestSweepDeletesOrphans runs a node sweeping every 200ms against the
+// coordinator, leaves a chunk on it that no file refers to, as a node that
+// missed a delete would, and checks the sweep deletes only that chunk
+func TestSweepDeletesOrphans(t *testing.T) {
+	coordinator := newNodeCoordinator(t, time.Minute)
+	sn := startHeartbeatingNode(t, coordinator, time.Second, func(sn *node.StorageNode) {
+		sn.SweepInterval = 200 * time.Millisecond
+	})
+	waitForNodes(t, 1)
+	ctx := context.Background()
+	client := node.NewNodeClient(http.DefaultClient, "http://"+sn.Address)
+
+	data := make([]byte, 2*chunking.MaxChunkSize)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	result, err := fileService.UploadFile(ctx, bytes.NewReader(data), service.UploadMetadata{FileName: "kept.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphanData := randomBytes(t, 1000)
+	orphan := chunking.SHA256.Sum(orphanData)
+	if err := client.Store(ctx, orphan, orphanData); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		exists, err := client.Exists(ctx, orphan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("orphan never swept")
+		}
+	}
+
+	for _, hash := range result.ChunkHashes {
+		if exists, err := client.Exists(ctx, hash); err != nil || !exists {
+			t.Errorf("referenced chunk %s swept: %v", hash[:8], err)
+		}
+	}
+	d, err := fileService.DownloadFile(ctx, result.FileID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// setTagsRequest is the body of PUT /files/{fileID}/tags
+type setTagsRequest struct {
+	Tags map[string]string `json:"tags"`
+}
+
+// fileTagsResponse is a file's complete set of tags
+type fileTagsResponse struct {
+	FileID string            `json:"file_id"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// parseTags parses key:value pairs, as sent in tag form fields and query
+// parameters. The value may contain ':'; a key may only appear once.
+func parseTags(pairs []string) (map[string]string, error) {
+	tags := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, ":")
+		if !found {
+			return nil, fmt.Errorf("tag %q is not of the form key:value", pair)
+		}
+		if _, duplicate := tags[key]; duplicate {
+			return nil, fmt.Errorf("tag %q given more than once", key)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// setFileTagsHandler replaces all tags of a file
+func setFileTagsHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	var req setTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid request")
+		return
+	}
+	if req.Tags == nil {
+		req.Tags = map[string]string{}
+	}
+
+	err := fileService.SetFileTags(fileID, req.Tags)
+	if errors.Is(err, service.ErrInvalidTags) {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidTags, err.Error())
+		return
+	}
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to set tags")
+		log.Printf("Setting tags of %s failed: %v", fileID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fileTagsResponse{FileID: fileID, Tags: req.Tags})
+}
+
+// getFileTagsHandler returns a file's tags
+func getFileTagsHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	tags, err := fileService.FileTags(fileID)
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to get tags")
+		log.Printf("Getting tags of %s failed: %v", fileID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fileTagsResponse{FileID: fileID, Tags: tags})
+}
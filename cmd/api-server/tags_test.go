@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// putTags sends PUT /files/{fileID}/tags with body
+func putTags(fileID, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/files/"+fileID+"/tags", strings.NewReader(body))
+	setFileTagsHandler(rec, mux.SetURLVars(r, map[string]string{"fileID": fileID}))
+	return rec
+}
+
+// listedByTags returns the names of the files GET /files lists for query,
+// sorted
+func listedByTags(t *testing.T, query string) []string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	listFilesHandler(rec, httptest.NewRequest(http.MethodGet, "/files?"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /files?%s: want 200, got %d: %s", query, rec.Code, rec.Body)
+	}
+
+	var listed struct {
+		Files []metadata.FileRecord `json:"files"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, file := range listed.Files {
+		names = append(names, file.FileName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestFileTags tags files on upload and through PUT, and checks GET /files
+// filters by every tag given
+func TestFileTags(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+
+	fileIDs := map[string]string{}
+	for name, tag := range map[string]string{"a.bin": "project:apollo", "b.bin": "project:apollo", "c.bin": "project:gemini"} {
+		rec := httptest.NewRecorder()
+		uploadHandler(rec, multipartRequest(t, "/upload", map[string]string{"tag": tag}, testFile{name, randomBytes(t, 100)}))
+		fileIDs[name] = decodeUploadResult(t, rec).FileID
+	}
+
+	rec := putTags(fileIDs["a.bin"], `{"tags": {"project": "apollo", "owner": "alice"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT tags: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var set fileTagsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"project": "apollo", "owner": "alice"}; set.FileID != fileIDs["a.bin"] || !reflect.DeepEqual(set.Tags, want) {
+		t.Errorf("want %v set, got %+v", want, set)
+	}
+	if rec := putTags(fileIDs["c.bin"], `{"tags": {"owner": "alice"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT tags: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	for query, want := range map[string][]string{
+		"":                                   {"a.bin", "b.bin", "c.bin"},
+		"tag=project:apollo":                 {"a.bin", "b.bin"},
+		"tag=owner:alice":                    {"a.bin", "c.bin"},
+		"tag=project:apollo&tag=owner:alice": {"a.bin"},
+		"tag=project:gemini":                 {}, // Replaced by the PUT
+	} {
+		if got := listedByTags(t, query); !reflect.DeepEqual(got, want) {
+			t.Errorf("GET /files?%s: want %v, got %v", query, want, got)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	listFilesHandler(rec, httptest.NewRequest(http.MethodGet, "/files?tag=project", nil))
+	checkErrorResponse(t, rec, http.StatusBadRequest, codeInvalidTags)
+
+	checkErrorResponse(t, putTags(fileIDs["a.bin"], `{"tags": {"a:b": "c"}}`), http.StatusBadRequest, codeInvalidTags)
+	checkErrorResponse(t, putTags("missing", `{"tags": {"a": "b"}}`), http.StatusNotFound, codeFileNotFound)
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags([]string{"project:apollo", "url:http://example.com", "empty:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"project": "apollo", "url": "http://example.com", "empty": ""}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("want %v, got %v", want, tags)
+	}
+
+	for _, pairs := range [][]string{{"project"}, {"a:1", "a:2"}} {
+		if _, err := parseTags(pairs); err == nil {
+			t.Errorf("%v: want an error", pairs)
+		}
+	}
+}
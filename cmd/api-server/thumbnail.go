@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// fileThumbnailHandler serves the JPEG thumbnail generated for an image
+// upload
+func fileThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	thumbnail, err := fileService.Thumbnail(fileID)
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found")
+		return
+	}
+	if errors.Is(err, service.ErrFileExpired) {
+		writeJSONError(w, http.StatusGone, codeFileExpired, "File has expired")
+		return
+	}
+	if errors.Is(err, metadata.ErrThumbnailNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeThumbnailNotFound, "File has no thumbnail")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to get thumbnail")
+		log.Printf("Thumbnail of %s failed: %v", fileID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(thumbnail)))
+	w.Write(thumbnail)
+}
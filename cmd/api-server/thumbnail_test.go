@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// TestFileThumbnail checks GET /files/{fileID}/thumbnail serves the JPEG
+// made for an image upload, and 404s for a file without one
+func TestFileThumbnail(t *testing.T) {
+	s := useTestService(t, metadata.NewMemoryStore())
+	if err := s.UseThumbnails(32); err != nil {
+		t.Fatal(err)
+	}
+
+	var sample bytes.Buffer
+	if err := png.Encode(&sample, image.NewGray(image.Rect(0, 0, 128, 64))); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	picture, err := s.UploadFile(ctx, bytes.NewReader(sample.Bytes()), service.UploadMetadata{FileName: "sample.png", ContentType: "image/png", Size: int64(sample.Len())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomBytes(t, 1000)
+	other, err := s.UploadFile(ctx, bytes.NewReader(data), service.UploadMetadata{FileName: "data.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := callFileHandler(fileThumbnailHandler, http.MethodGet, "/files/"+picture.FileID+"/thumbnail", picture.FileID)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("want a JPEG, got %d %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	thumbnail, err := jpeg.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := thumbnail.Bounds().Size(); size != image.Pt(32, 16) {
+		t.Errorf("want a 32x16 thumbnail, got %v", size)
+	}
+
+	rec = callFileHandler(fileThumbnailHandler, http.MethodGet, "/files/"+other.FileID+"/thumbnail", other.FileID)
+	checkErrorResponse(t, rec, http.StatusNotFound, codeThumbnailNotFound)
+	rec = callFileHandler(fileThumbnailHandler, http.MethodGet, "/files/missing/thumbnail", "missing")
+	checkErrorResponse(t, rec, http.StatusNotFound, codeFileNotFound)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// deleteFileHandler moves a file to the trash
+func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	if err := fileService.DeleteFile(fileID); err != nil {
+		if errors.Is(err, metadata.ErrFileNotFound) {
+			writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to delete file")
+		log.Printf("Database error deleting file %s: %v", fileID, err)
+		return
+	}
+
+	log.Printf("Moved file %s to trash", fileID)
+	recordAccess(r, fileID, metadata.AccessDelete, 0)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"file_id": fileID,
+		"status":  "deleted",
+	})
+}
+
+// restoreFileHandler takes a file back out of the trash
+func restoreFileHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["fileID"]
+
+	if err := fileService.RestoreFile(fileID); err != nil {
+		if errors.Is(err, metadata.ErrFileNotFound) {
+			writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found in trash")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to restore file")
+		log.Printf("Database error restoring file %s: %v", fileID, err)
+		return
+	}
+
+	log.Printf("Restored file %s from trash", fileID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"file_id": fileID,
+		"status":  "restored",
+	})
+}
+
+// listTrashHandler lists soft-deleted files that have not been purged yet
+func listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	files, err := fileService.ListTrash()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to list trash")
+		log.Printf("Database error listing trash: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count": len(files),
+		"files": files,
+	})
+}
+
+// purgeTrash periodically removes files that have been in the trash longer
+// than the retention period, along with chunks nothing else references
+func purgeTrash(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := fileService.PurgeDeletedFiles(retention)
+		if err != nil {
+			log.Printf("Trash purge failed: %v", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Purged %d files from trash", purged)
+		}
+	}
+}
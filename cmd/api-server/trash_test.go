@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// callFileHandler calls handler for fileID as the router would
+func callFileHandler(handler http.HandlerFunc, method, path, fileID string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest(method, path, nil), map[string]string{"fileID": fileID})
+	handler(rec, r)
+	return rec
+}
+
+// listedTrash returns the IDs GET /trash lists
+func listedTrash(t *testing.T) []string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	listTrashHandler(rec, httptest.NewRequest(http.MethodGet, "/trash", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /trash: want 200, got %d", rec.Code)
+	}
+
+	var listed struct {
+		Count int                   `json:"count"`
+		Files []metadata.FileRecord `json:"files"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if listed.Count != len(listed.Files) {
+		t.Errorf("count %d does not match %d files listed", listed.Count, len(listed.Files))
+	}
+
+	fileIDs := make([]string, len(listed.Files))
+	for i, file := range listed.Files {
+		fileIDs[i] = file.FileID
+	}
+	return fileIDs
+}
+
+// TestTrashEndpoints deletes a file, finds it in GET /trash, and restores it
+func TestTrashEndpoints(t *testing.T) {
+	s := useTestService(t, metadata.NewMemoryStore())
+	data := randomBytes(t, 1000)
+	result, err := s.UploadFile(context.Background(), bytes.NewReader(data), service.UploadMetadata{FileName: "test.bin", Size: int64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trash := listedTrash(t); len(trash) != 0 {
+		t.Fatalf("want the trash empty, got %v", trash)
+	}
+
+	path := "/files/" + result.FileID
+	if rec := callFileHandler(deleteFileHandler, http.MethodDelete, path, result.FileID); rec.Code != http.StatusOK {
+		t.Fatalf("delete: want 200, got %d", rec.Code)
+	}
+	if trash := listedTrash(t); len(trash) != 1 || trash[0] != result.FileID {
+		t.Fatalf("want the deleted file in the trash, got %v", trash)
+	}
+
+	if rec := callFileHandler(restoreFileHandler, http.MethodPost, path+"/restore", result.FileID); rec.Code != http.StatusOK {
+		t.Fatalf("restore: want 200, got %d", rec.Code)
+	}
+	if trash := listedTrash(t); len(trash) != 0 {
+		t.Errorf("want the trash empty after restoring, got %v", trash)
+	}
+	if rec := callFileHandler(restoreFileHandler, http.MethodPost, path+"/restore", result.FileID); rec.Code != http.StatusNotFound {
+		t.Errorf("restoring a file not in the trash: want 404, got %d", rec.Code)
+	}
+}
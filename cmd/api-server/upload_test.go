@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// useTestService points the handlers at a file service over store and an
+// in-memory chunk store, with no storage nodes, until the test ends
+func useTestService(t *testing.T, store service.MetadataStore) *service.FileService {
+	t.Helper()
+
+	savedService, savedDB, savedRegistry, savedRing := fileService, db, nodeRegistry, consistentHash
+	t.Cleanup(func() {
+		fileService, db, nodeRegistry, consistentHash = savedService, savedDB, savedRegistry, savedRing
+	})
+
+	ring, err := node.NewConsistentHash(node.DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, nodeRegistry, consistentHash = store, node.NewRegistry(time.Minute), ring
+	fileService = service.NewFileService(db, dedup.NewMemoryChunkStore(), nodeRegistry, consistentHash)
+	return fileService
+}
+
+// testFile is a file part of a multipart upload
+type testFile struct {
+	name string
+	data []byte
+}
+
+// multipartRequest builds a POST to path with the given form fields and
+// "file" parts
+func multipartRequest(t *testing.T, path string, fields map[string]string, files ...testFile) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range files {
+		part, err := form.CreateFormFile("file", file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file.data)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, path, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+// randomBytes returns n random bytes
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// rejectingStore fails to create files with one name
+type rejectingStore struct {
+	*metadata.MemoryStore
+	reject string
+}
+
+func (s *rejectingStore) CreateFile(file *metadata.FileRecord) error {
+	if file.FileName == s.reject {
+		return errors.New("rejected")
+	}
+	return s.MemoryStore.CreateFile(file)
+}
+
+// TestBatchUploadSharesDedup uploads a batch holding the same file twice
+// and one that fails, and checks the others are stored, the copy stores no
+// chunks of its own, and each file's outcome is reported
+func TestBatchUploadSharesDedup(t *testing.T) {
+	useTestService(t, &rejectingStore{MemoryStore: metadata.NewMemoryStore(), reject: "bad.bin"})
+
+	data := randomBytes(t, 1000)
+	rec := httptest.NewRecorder()
+	batchUploadHandler(rec, multipartRequest(t, "/upload/batch", nil,
+		testFile{"a.bin", data},
+		testFile{"bad.bin", randomBytes(t, 1000)},
+		testFile{"b.bin", data},
+	))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp BatchUploadResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Succeeded != 2 || resp.Failed != 1 {
+		t.Errorf("want 2 stored and 1 failed, got %d and %d", resp.Succeeded, resp.Failed)
+	}
+	if len(resp.Files) != 3 {
+		t.Fatalf("want 3 results, got %d", len(resp.Files))
+	}
+	for i, want := range []string{"stored", "failed", "stored"} {
+		if resp.Files[i].Status != want {
+			t.Errorf("%s: want %s, got %s (%s)", resp.Files[i].FileName, want, resp.Files[i].Status, resp.Files[i].Error)
+		}
+	}
+	if resp.Files[1].Error == "" {
+		t.Error("want the failure explained")
+	}
+
+	first, second := resp.Files[0].Upload, resp.Files[2].Upload
+	if first.FileID == second.FileID {
+		t.Error("want each file stored under its own ID")
+	}
+	if second.ChunksStored != 0 {
+		t.Errorf("want the copy to store no chunks, got %d", second.ChunksStored)
+	}
+	if resp.TotalChunks != 2 || resp.ChunksStored != 1 || resp.DedupRatio != 2 {
+		t.Errorf("want 2 chunks, 1 stored, 2x dedup; got %d, %d, %.2fx", resp.TotalChunks, resp.ChunksStored, resp.DedupRatio)
+	}
+}
+
+// useMaxUploadSize sets the upload size limit until the test ends
+func useMaxUploadSize(t *testing.T, size int64) {
+	saved := maxUploadSize
+	t.Cleanup(func() { maxUploadSize = saved })
+	maxUploadSize = size
+}
+
+// TestUploadTooLarge checks files over the size limit are refused with 413
+// on every upload path, whether the body is cut off while it is received
+// or the file part alone is over the limit, and that nothing is stored
+func TestUploadTooLarge(t *testing.T) {
+	const limit = 1000
+	useTestService(t, metadata.NewMemoryStore())
+	useMaxUploadSize(t, limit)
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		size    int
+	}{
+		{"file part over the limit", uploadHandler, "/upload", limit + 1},
+		{"body over the limit", uploadHandler, "/upload", limit + uploadFormOverhead + 1},
+		{"batch file over the limit", batchUploadHandler, "/upload/batch", limit + 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tc.handler(rec, multipartRequest(t, tc.path, nil, testFile{"big.bin", randomBytes(t, tc.size)}))
+			checkErrorResponse(t, rec, http.StatusRequestEntityTooLarge, codeFileTooLarge)
+		})
+	}
+
+	t.Run("direct upload over the limit", func(t *testing.T) {
+		body, err := json.Marshal(service.DirectUploadRequest{
+			FileName: "big.bin",
+			Chunks:   []service.DirectChunk{{Hash: "a", Size: limit / 2}, {Hash: "b", Size: limit/2 + 1}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		planDirectUploadHandler(rec, httptest.NewRequest(http.MethodPost, "/upload/direct", bytes.NewReader(body)))
+		checkErrorResponse(t, rec, http.StatusRequestEntityTooLarge, codeFileTooLarge)
+	})
+
+	files, err := db.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("oversized uploads stored %d files", len(files))
+	}
+
+	// A file of exactly the limit is accepted
+	rec := httptest.NewRecorder()
+	uploadHandler(rec, multipartRequest(t, "/upload", nil, testFile{"limit.bin", randomBytes(t, limit)}))
+	if rec.Code != http.StatusOK {
+		t.Errorf("file at the limit: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// TestUploadEmptyFile checks an empty file part is stored as a file with
+// no chunks, and downloads as an empty body
+func TestUploadEmptyFile(t *testing.T) {
+	useTestService(t, metadata.NewMemoryStore())
+
+	rec := httptest.NewRecorder()
+	uploadHandler(rec, multipartRequest(t, "/upload", nil, testFile{"empty.txt", nil}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var result service.UploadResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != 0 || len(result.ChunkHashes) != 0 {
+		t.Errorf("want an empty file with no chunks, got %+v", result)
+	}
+
+	rec = callFileHandler(downloadHandler, http.MethodGet, "/download/"+result.FileID, result.FileID)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("want an empty 200 download, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
+
+// spillWatchingStore counts the files in dir as each upload is recorded,
+// while the upload's multipart form is still open
+type spillWatchingStore struct {
+	*metadata.MemoryStore
+	t       *testing.T
+	dir     string
+	spilled int
+}
+
+func (s *spillWatchingStore) CreateFile(file *metadata.FileRecord) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		s.t.Error(err)
+	}
+	s.spilled = max(s.spilled, len(entries))
+	return s.MemoryStore.CreateFile(file)
+}
+
+// TestUploadRemovesSpilledFiles uploads files too big to parse in memory,
+// singly and in a batch, and checks the temp files they spilled to are
+// removed by the handler itself rather than left to net/http
+func TestUploadRemovesSpilledFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	store := &spillWatchingStore{MemoryStore: metadata.NewMemoryStore(), t: t, dir: dir}
+	useTestService(t, store)
+
+	data := randomBytes(t, 33<<20)
+	for name, handler := range map[string]http.HandlerFunc{"/upload": uploadHandler, "/upload/batch": batchUploadHandler} {
+		store.spilled = 0
+		rec := httptest.NewRecorder()
+		handler(rec, multipartRequest(t, name, nil, testFile{"big.bin", data}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d: %s", name, rec.Code, rec.Body)
+		}
+
+		if store.spilled == 0 {
+			t.Fatalf("%s: upload did not spill to %s", name, dir)
+		}
+		if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+			t.Errorf("%s: want spilled files removed, %d left (%v)", name, len(entries), err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/service"
+)
+
+// verifyChunksHandler cross-checks the chunks table against the chunks the
+// storage nodes hold. With repair=true, lost chunks that can still be read
+// are re-stored and orphans are deleted from the nodes.
+func verifyChunksHandler(w http.ResponseWriter, r *http.Request) {
+	repair := false
+	if value := r.URL.Query().Get("repair"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid repair flag")
+			return
+		}
+		repair = parsed
+	}
+
+	report, err := fileService.VerifyChunks(r.Context(), repair)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to verify chunks")
+		log.Printf("Chunk verification failed: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// unreferencedChunksHandler tells a node's orphan sweeper which of its
+// chunks no file refers to any more, so it can delete them
+func unreferencedChunksHandler(w http.ResponseWriter, r *http.Request) {
+	var req node.UnreferencedChunksRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid request")
+		return
+	}
+
+	if _, err := nodeRegistry.GetNode(req.NodeID); err != nil {
+		writeJSONError(w, http.StatusNotFound, codeNodeNotFound, "Unknown node")
+		return
+	}
+
+	unreferenced, err := fileService.ConfirmUnreferenced(req.ChunkHashes)
+	if errors.Is(err, service.ErrNoChunkMetadata) {
+		writeJSONError(w, http.StatusConflict, codeNoChunkMetadata, "No chunk metadata recorded; refusing to confirm deletions")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to check chunks")
+		log.Printf("Unreferenced chunk check failed: %v", err)
+		return
+	}
+
+	log.Printf("Node %s asked about %d chunks: %d unreferenced", req.NodeID, len(req.ChunkHashes), len(unreferenced))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.UnreferencedChunksResponse{Unreferenced: unreferenced})
+}
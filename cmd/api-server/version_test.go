@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/version"
+)
+
+// TestVersionEndpoint sets the build values as -ldflags would and checks
+// /version reports them with the protocol version
+func TestVersionEndpoint(t *testing.T) {
+	saved := [3]string{version.Version, version.Commit, version.BuildDate}
+	t.Cleanup(func() { version.Version, version.Commit, version.BuildDate = saved[0], saved[1], saved[2] })
+	version.Version, version.Commit, version.BuildDate = "v1.4.0", "9f2c1e0", "2026-10-16T08:00:00Z"
+
+	rec := httptest.NewRecorder()
+	versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("want 200 with JSON, got %d (%s): %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	var info version.Info
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "v1.4.0" || info.Commit != "9f2c1e0" || info.BuildDate != "2026-10-16T08:00:00Z" || info.Protocol != version.Protocol {
+		t.Errorf("want the injected build and protocol %d, got %+v", version.Protocol, info)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// getLatestFileVersionHandler resolves a logical name to its newest version
+func getLatestFileVersionHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	file, err := db.GetLatestFileVersion(name)
+	writeFileRecord(w, file, err)
+}
+
+// getFileVersionHandler returns a specific version of a file
+func getFileVersionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	version, err := strconv.Atoi(vars["version"])
+	if err != nil || version < 1 {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "Invalid version")
+		return
+	}
+
+	file, err := db.GetFileVersion(vars["name"], version)
+	writeFileRecord(w, file, err)
+}
+
+// listFileVersionsHandler lists every version of a file, newest first
+func listFileVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	versions, err := db.ListFileVersions(name)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to list versions")
+		log.Printf("Database error listing versions of %s: %v", name, err)
+		return
+	}
+	if len(versions) == 0 {
+		writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"file_name": name,
+		"count":     len(versions),
+		"versions":  versions,
+	})
+}
+
+// writeFileRecord writes a file lookup result as JSON, mapping a missing
+// file to 404 and an expired one to 410
+func writeFileRecord(w http.ResponseWriter, file *metadata.FileRecord, err error) {
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		writeJSONError(w, http.StatusNotFound, codeFileNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "Failed to look up file")
+		log.Printf("Database error looking up file: %v", err)
+		return
+	}
+	if file.Expired(time.Now()) {
+		writeJSONError(w, http.StatusGone, codeFileExpired, "File has expired")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
@@ -0,0 +1,348 @@
+// dfs-ctl is a command-line tool for operating a cluster through the
+// coordinator's HTTP API
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/pkg/client"
+)
+
+// command is one dfs-ctl subcommand
+type command struct {
+	usage string // Arguments, shown after the command name
+	help  string
+	run   func(c *client.Client, args []string, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"upload":   {"[-name NAME] [-password PW | -passphrase PP] FILE", "Upload a file", uploadCmd},
+	"download": {"[-o PATH] [-password PW | -passphrase PP] FILE_ID", "Download a file (to stdout by default)", downloadCmd},
+	"ls":       {"", "List files", lsCmd},
+	"rm":       {"FILE_ID...", "Move files to the trash", rmCmd},
+	"stats":    {"", "Show deduplication and storage statistics", statsCmd},
+	"nodes":    {"", "List storage nodes", nodesCmd},
+	"verify":   {"[-repair]", "Reconcile chunk metadata with the chunks on the nodes", verifyCmd},
+}
+
+// errUsage marks a mistake in the command line; the usage is printed for it
+var errUsage = errors.New("usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes a dfs-ctl command line and returns the exit code: 0 on
+// success, 1 when the command failed, 2 for a bad command line
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dfs-ctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { printUsage(stderr) }
+	addr := flags.String("addr", getEnv("DFS_COORDINATOR", "http://localhost:8080"), "Coordinator URL (defaults to $DFS_COORDINATOR)")
+	token := flags.String("token", os.Getenv("DFS_TOKEN"), "API token (defaults to $DFS_TOKEN)")
+	timeout := flags.Duration("timeout", 0, "Give up on a request after this long (0 waits forever)")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() == 0 {
+		printUsage(stderr)
+		return 2
+	}
+
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "dfs-ctl: unknown command %q\n", name)
+		printUsage(stderr)
+		return 2
+	}
+
+	c := client.New(*addr)
+	c.Token = *token
+	if *timeout > 0 {
+		c.HTTPClient = &http.Client{Timeout: *timeout}
+	}
+
+	err := cmd.run(c, flags.Args()[1:], stdout)
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(stderr, "usage: dfs-ctl %s %s\n", name, cmd.usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "dfs-ctl %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: dfs-ctl [-addr URL] [-token TOKEN] [-timeout D] COMMAND [ARGS]\n\nCommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", name, commands[name].usage, commands[name].help)
+	}
+	tw.Flush()
+}
+
+// parseFlags parses a subcommand's flags and checks it got between min and
+// max positional arguments (max < 0 means no limit)
+func parseFlags(flags *flag.FlagSet, args []string, min, max int) error {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return errUsage
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if flags.NArg() < min || (max >= 0 && flags.NArg() > max) {
+		return errUsage
+	}
+	return nil
+}
+
+func uploadCmd(c *client.Client, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	name := flags.String("name", "", "Logical file name (defaults to the file's base name)")
+	password := flags.String("password", "", "Encrypt on the server with this password")
+	passphrase := flags.String("passphrase", "", "Encrypt locally with this passphrase before uploading")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+	if *password != "" && *passphrase != "" {
+		return fmt.Errorf("-password and -passphrase are mutually exclusive")
+	}
+
+	path := flags.Arg(0)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	opts := client.UploadOptions{Name: *name}
+	var result *client.UploadResult
+	if *passphrase != "" {
+		result, err = c.UploadEncrypted(filepath.Base(path), file, *passphrase, opts)
+	} else {
+		opts.Password = *password
+		result, err = c.Upload(filepath.Base(path), file, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%s\t%s (version %d, %s, %d chunks, %d new)\n",
+		result.FileID, result.FileName, result.Version, formatBytes(result.Size), len(result.ChunkHashes), result.ChunksStored)
+	return nil
+}
+
+func downloadCmd(c *client.Client, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("download", flag.ContinueOnError)
+	output := flags.String("o", "", "Write to this file instead of stdout")
+	password := flags.String("password", "", "Password of a server-encrypted file")
+	passphrase := flags.String("passphrase", "", "Passphrase of a client-encrypted file")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+	fileID := flags.Arg(0)
+
+	w := stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var err error
+	if *passphrase != "" {
+		err = c.DownloadEncrypted(fileID, w, *passphrase)
+	} else {
+		err = c.Download(fileID, w, *password)
+	}
+	if err != nil && *output != "" {
+		// Don't leave a partial or unverified file behind
+		os.Remove(*output)
+	}
+	return err
+}
+
+func lsCmd(c *client.Client, args []string, stdout io.Writer) error {
+	if err := parseFlags(flag.NewFlagSet("ls", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+
+	files, err := c.List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE ID\tNAME\tVERSION\tSIZE\tENCRYPTED\tUPLOADED")
+	for _, file := range files {
+		encrypted := "no"
+		switch {
+		case file.ClientEncrypted:
+			encrypted = "client"
+		case file.Encrypted:
+			encrypted = "server"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			file.FileID, file.FileName, file.Version, formatBytes(file.Size), encrypted, file.UploadedAt.Local().Format(time.DateTime))
+	}
+	return tw.Flush()
+}
+
+func rmCmd(c *client.Client, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	if err := parseFlags(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	// Keep going past failures so one bad ID doesn't block the rest
+	var failed []string
+	for _, fileID := range flags.Args() {
+		if err := c.Delete(fileID); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", fileID, err))
+			continue
+		}
+		fmt.Fprintf(stdout, "%s\tmoved to trash\n", fileID)
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func statsCmd(c *client.Client, args []string, stdout io.Writer) error {
+	if err := parseFlags(flag.NewFlagSet("stats", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s:\t%v\n", key, stats[key])
+	}
+	return tw.Flush()
+}
+
+func nodesCmd(c *client.Client, args []string, stdout io.Writer) error {
+	if err := parseFlags(flag.NewFlagSet("nodes", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+
+	nodes, err := c.Nodes()
+	if err != nil {
+		return err
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE ID\tADDRESS\tSTATUS\tCHUNKS\tUSED\tLAST SEEN")
+	for _, node := range nodes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s ago\n",
+			node.NodeID, node.Address, node.Status, node.TotalChunks, formatBytes(node.Used), time.Since(node.LastSeen).Round(time.Second))
+	}
+	return tw.Flush()
+}
+
+func verifyCmd(c *client.Client, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "Re-store lost chunks and delete orphans")
+	if err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	report, err := c.Verify(*repair)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Checked %d chunks on %d nodes\n", report.ChunksChecked, len(report.NodesChecked))
+	if len(report.NodesUnreachable) > 0 {
+		fmt.Fprintf(stdout, "Unreachable nodes (their chunks may show as lost): %s\n", strings.Join(report.NodesUnreachable, ", "))
+	}
+	fmt.Fprintf(stdout, "Lost: %d\n", len(report.Lost))
+	for _, hash := range report.Lost {
+		fmt.Fprintf(stdout, "  %s\n", hash)
+	}
+	fmt.Fprintf(stdout, "Orphans: %d\n", len(report.Orphans))
+	for _, orphan := range report.Orphans {
+		fmt.Fprintf(stdout, "  %s on %s\n", orphan.Hash, strings.Join(orphan.Nodes, ", "))
+	}
+	fmt.Fprintf(stdout, "Ref count mismatches: %d\n", len(report.RefCountMismatches))
+	for _, mismatch := range report.RefCountMismatches {
+		fmt.Fprintf(stdout, "  %s: ref_count %d, %d links\n", mismatch.Hash, mismatch.RefCount, mismatch.Links)
+	}
+	if *repair {
+		fmt.Fprintf(stdout, "Repaired: %d, unrecoverable: %d, orphans deleted: %d\n",
+			len(report.Repaired), len(report.Unrecoverable), report.OrphansDeleted)
+	}
+
+	// Problems left unfixed make the command fail, so scripts can alert on
+	// it. Repair doesn't touch ref count mismatches.
+	unresolved := len(report.Lost) - len(report.Repaired) + len(report.RefCountMismatches)
+	if !*repair {
+		unresolved += len(report.Orphans)
+	}
+	if unresolved > 0 {
+		return fmt.Errorf("%d problems found", unresolved)
+	}
+	return nil
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
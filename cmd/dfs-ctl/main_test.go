@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/pkg/client"
+)
+
+// fakeCoordinator keeps uploads in memory and serves the endpoints dfs-ctl
+// calls, failing any request without the expected token
+type fakeCoordinator struct {
+	token  string
+	report client.VerifyReport // Returned by /chunks/verify without repair
+
+	mu    sync.Mutex
+	files map[string][]byte
+	names map[string]string
+}
+
+// startFakeCoordinator serves a fake coordinator expecting token and points
+// dfs-ctl at it through the environment
+func startFakeCoordinator(t *testing.T, token string) *fakeCoordinator {
+	t.Helper()
+
+	f := &fakeCoordinator{token: token, files: make(map[string][]byte), names: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /upload", f.upload)
+	mux.HandleFunc("GET /download/{id}", f.download)
+	mux.HandleFunc("GET /files", f.list)
+	mux.HandleFunc("DELETE /files/{id}", f.delete)
+	mux.HandleFunc("POST /chunks/verify", f.verify)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+f.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error": {"code": "UNAUTHORIZED", "message": "Unauthorized"}}`)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("DFS_COORDINATOR", server.URL)
+	t.Setenv("DFS_TOKEN", token)
+	return f
+}
+
+func (f *fakeCoordinator) upload(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	fileID := fmt.Sprintf("file-%d", len(f.names)+1)
+	f.files[fileID], f.names[fileID] = data, header.Filename
+	f.mu.Unlock()
+
+	json.NewEncoder(w).Encode(client.UploadResult{FileID: fileID, FileName: header.Filename, Version: 1, Size: int64(len(data))})
+}
+
+func (f *fakeCoordinator) download(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	data, exists := f.files[r.PathValue("id")]
+	f.mu.Unlock()
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error": {"code": "FILE_NOT_FOUND", "message": "File not found"}}`)
+		return
+	}
+	w.Write(data)
+}
+
+func (f *fakeCoordinator) list(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	files := []client.FileInfo{}
+	for fileID, data := range f.files {
+		files = append(files, client.FileInfo{FileID: fileID, FileName: f.names[fileID], Version: 1, Size: int64(len(data)), UploadedAt: time.Now()})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"files": files})
+}
+
+func (f *fakeCoordinator) delete(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.files[r.PathValue("id")]; !exists {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error": {"code": "FILE_NOT_FOUND", "message": "File not found"}}`)
+		return
+	}
+	delete(f.files, r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeCoordinator) verify(w http.ResponseWriter, r *http.Request) {
+	report := f.report
+	if r.URL.Query().Get("repair") == "true" {
+		report.Repaired = report.Lost
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// runCtl runs a dfs-ctl command line and returns its exit code and output
+func runCtl(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// TestRunCommandLine checks bad command lines exit with 2 and the relevant
+// usage before any request is sent
+func TestRunCommandLine(t *testing.T) {
+	t.Setenv("DFS_COORDINATOR", "http://127.0.0.1:1")
+
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{"no command", nil, 2, "usage: dfs-ctl [-addr URL]"},
+		{"help", []string{"-h"}, 0, "Commands:"},
+		{"unknown command", []string{"frobnicate"}, 2, `unknown command "frobnicate"`},
+		{"unknown global flag", []string{"-bogus", "ls"}, 2, "flag provided but not defined"},
+		{"missing argument", []string{"download"}, 2, "usage: dfs-ctl download [-o PATH]"},
+		{"extra argument", []string{"ls", "extra"}, 2, "usage: dfs-ctl ls"},
+		{"too many files", []string{"upload", "a", "b"}, 2, "usage: dfs-ctl upload"},
+		{"no file IDs", []string{"rm"}, 2, "usage: dfs-ctl rm FILE_ID..."},
+		{"unknown subcommand flag", []string{"verify", "-force"}, 2, "usage: dfs-ctl verify [-repair]"},
+		{"subcommand help", []string{"stats", "-h"}, 2, "usage: dfs-ctl stats"},
+		{"conflicting flags", []string{"upload", "-password", "a", "-passphrase", "b", "file"}, 1, "mutually exclusive"},
+		{"unreachable coordinator", []string{"ls"}, 1, "dfs-ctl ls:"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _, stderr := runCtl(tc.args...)
+			if code != tc.code {
+				t.Errorf("want exit code %d, got %d: %s", tc.code, code, stderr)
+			}
+			if !strings.Contains(stderr, tc.stderr) {
+				t.Errorf("want %q in the output, got %q", tc.stderr, stderr)
+			}
+		})
+	}
+}
+
+// TestRunAgainstCoordinator uploads, lists, downloads and removes a file
+// through a fake coordinator found through the environment, and checks
+// -addr and -token override it
+func TestRunAgainstCoordinator(t *testing.T) {
+	f := startFakeCoordinator(t, "secret")
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("dfs-ctl "), 1000)
+	path := filepath.Join(dir, "report.txt")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := runCtl("upload", path)
+	if code != 0 {
+		t.Fatalf("upload: exit code %d: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "file-1\treport.txt (version 1, 7.8 KiB") {
+		t.Errorf("unexpected upload output %q", stdout)
+	}
+
+	code, stdout, stderr = runCtl("ls")
+	if code != 0 {
+		t.Fatalf("ls: exit code %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "FILE ID") || !strings.Contains(lines[1], "report.txt") {
+		t.Errorf("want a header and the file listed, got %q", stdout)
+	}
+
+	output := filepath.Join(dir, "downloaded.txt")
+	if code, _, stderr := runCtl("download", "-o", output, "file-1"); code != 0 {
+		t.Fatalf("download: exit code %d: %s", code, stderr)
+	}
+	if got, err := os.ReadFile(output); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("downloaded file differs: %v", err)
+	}
+
+	// A failed download leaves no file behind
+	missing := filepath.Join(dir, "missing.txt")
+	if code, _, stderr := runCtl("download", "-o", missing, "file-9"); code != 1 || !strings.Contains(stderr, "FILE_NOT_FOUND") {
+		t.Errorf("want exit code 1 with the error code, got %d: %s", code, stderr)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("failed download left %s behind", missing)
+	}
+
+	// rm carries on past a bad ID, but fails
+	code, stdout, stderr = runCtl("rm", "file-9", "file-1")
+	if code != 1 || !strings.Contains(stderr, "file-9") || stdout != "file-1\tmoved to trash\n" {
+		t.Errorf("want file-1 removed and file-9 reported, got %d: %q %q", code, stdout, stderr)
+	}
+	f.mu.Lock()
+	left := len(f.files)
+	f.mu.Unlock()
+	if left != 0 {
+		t.Errorf("want no files left, got %d", left)
+	}
+
+	// Flags take precedence over the environment
+	if code, _, stderr := runCtl("-token", "wrong", "ls"); code != 1 || !strings.Contains(stderr, "UNAUTHORIZED") {
+		t.Errorf("want the -token used, got %d: %s", code, stderr)
+	}
+	if code, _, _ := runCtl("-addr", "http://127.0.0.1:1", "ls"); code != 1 {
+		t.Errorf("want the -addr used, got exit code %d", code)
+	}
+}
+
+// TestRunVerify checks verify fails while problems are left unresolved
+func TestRunVerify(t *testing.T) {
+	f := startFakeCoordinator(t, "secret")
+	f.report = client.VerifyReport{NodesChecked: []string{"node-1", "node-2"}, ChunksChecked: 10}
+
+	if code, stdout, stderr := runCtl("verify"); code != 0 || !strings.HasPrefix(stdout, "Checked 10 chunks on 2 nodes\n") {
+		t.Errorf("clean cluster: want exit code 0, got %d: %q %s", code, stdout, stderr)
+	}
+
+	f.report.Lost = []string{"abc123"}
+	code, stdout, stderr := runCtl("verify")
+	if code != 1 || !strings.Contains(stdout, "Lost: 1\n  abc123\n") || !strings.Contains(stderr, "1 problems found") {
+		t.Errorf("lost chunk: want exit code 1 with it listed, got %d: %q %s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = runCtl("verify", "-repair")
+	if code != 0 || !strings.Contains(stdout, "Repaired: 1, unrecoverable: 0") {
+		t.Errorf("repaired chunk: want exit code 0, got %d: %q %s", code, stdout, stderr)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1024:          "1.0 KiB",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		3 << 40:       "3.0 TiB",
+		1<<63 - 1:     "8.0 EiB",
+		1<<30 + 1<<29: "1.5 GiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d): want %q, got %q", n, want, got)
+		}
+	}
+}
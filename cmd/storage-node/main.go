@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/noorimat/distributed-file-storage/internal/auth"
 	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/shard"
+	"github.com/noorimat/distributed-file-storage/internal/version"
 	"github.com/google/uuid"
 )
 
@@ -15,22 +22,85 @@ func main() {
 	nodeID := flag.String("id", uuid.New().String(), "Node ID (auto-generated if not specified)")
 	port := flag.Int("port", 9001, "Port to listen on")
 	storagePath := flag.String("storage", "./node-storage", "Storage directory path")
-	coordinatorAddr := flag.String("coordinator", "localhost:8080", "Coordinator address")
+	coordinatorAddr := flag.String("coordinator", "localhost:8080", "Coordinator address, or a comma-separated list of coordinators standing by for each other")
+	scrubInterval := flag.Duration("scrub-interval", node.DefaultScrubInterval, "How often to verify stored chunks (0 disables)")
+	scrubRate := flag.Int64("scrub-rate", node.DefaultScrubRate, "Max scrub read rate in bytes/sec (0 = unlimited)")
+	grpcPort := flag.Int("grpc-port", 0, "Port for the gRPC data path (default port+1000, negative disables)")
+	packThreshold := flag.Int64("pack-threshold", 0, "Pack chunks smaller than this many bytes into pack files (0 disables)")
+	compactInterval := flag.Duration("compact-interval", node.DefaultCompactInterval, "How often to pack small chunks")
+	shardDepth := flag.Int("shard-depth", shard.DefaultDepth, "Directory levels to shard chunk files into (existing chunks are moved on start)")
+	heartbeatInterval := flag.Duration("heartbeat-interval", node.DefaultHeartbeatInterval, "How often to send heartbeats to the coordinator")
+	sweepInterval := flag.Duration("sweep-interval", node.DefaultSweepInterval, "How often to delete chunks the coordinator no longer references (0 disables)")
+	sweepRate := flag.Int("sweep-rate", node.DefaultSweepRate, "Max orphaned chunks deleted per second (0 = unlimited)")
+	maxWrites := flag.Int("max-writes", node.DefaultMaxWrites, "Max chunks written to disk at once; further stores wait (0 = unlimited)")
+	durability := flag.String("durability", node.DurabilityChunk, "When written chunks are fsynced: chunk (before each store is acknowledged), interval (every -sync-interval) or none")
+	syncInterval := flag.Duration("sync-interval", node.DefaultSyncInterval, "How often -durability interval fsyncs written chunks")
+	verifyDedup := flag.Bool("verify-dedup", false, "Refuse stores that would replace a held chunk with different bytes of the same hash")
+	quota := flag.Int64("quota", 0, "Max bytes of chunks to store; further stores are refused with 507 (0 = no quota)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS and calls the coordinator over TLS (plain HTTP if unset)")
+	tlsKey := flag.String("tls-key", "", "TLS private key file for -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "CA file for mutual TLS: the coordinator must present a certificate signed by it")
+	zone := flag.String("zone", "", "Failure domain (rack or zone) of this node; replicas are spread across zones")
+	clusterSecret := flag.String("cluster-secret", os.Getenv("CLUSTER_SECRET"), "Shared secret for cluster-internal calls (defaults to $CLUSTER_SECRET)")
 	flag.Parse()
 
 	// Create storage node
 	address := fmt.Sprintf("localhost:%d", *port)
 	storageNode := node.NewStorageNode(*nodeID, address, *storagePath, *coordinatorAddr)
+	storageNode.ScrubInterval = *scrubInterval
+	storageNode.ScrubRate = *scrubRate
+	storageNode.ClusterSecret = *clusterSecret
+	storageNode.PackThreshold = *packThreshold
+	storageNode.CompactInterval = *compactInterval
+	storageNode.ShardDepth = *shardDepth
+	storageNode.HeartbeatInterval = *heartbeatInterval
+	storageNode.SweepInterval = *sweepInterval
+	storageNode.SweepRate = *sweepRate
+	storageNode.MaxWrites = *maxWrites
+	storageNode.Durability = *durability
+	storageNode.SyncInterval = *syncInterval
+	storageNode.VerifyDedup = *verifyDedup
+	storageNode.Quota = *quota
+	storageNode.Zone = *zone
+	storageNode.TLS = auth.TLSConfig{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA}
+
+	if *grpcPort == 0 {
+		*grpcPort = *port + 1000
+	}
+	if *grpcPort > 0 {
+		storageNode.GRPCAddress = fmt.Sprintf("localhost:%d", *grpcPort)
+	}
 
 	log.Printf("Starting storage node...")
 	log.Printf("Node ID: %s", *nodeID)
 	log.Printf("Address: %s", address)
 	log.Printf("Storage: %s", *storagePath)
 	log.Printf("Coordinator: %s", *coordinatorAddr)
+	log.Printf("Version: %s", version.Get())
+
+	// Start the node in the background so we can watch for shutdown signals
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- storageNode.Start()
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			log.Fatalf("Failed to start storage node: %v", err)
+		}
+	case sig := <-sigCh:
+		log.Printf("Received %s, shutting down...", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
 
-	// Start the node (blocks)
-	if err := storageNode.Start(); err != nil {
-		log.Fatalf("Failed to start storage node: %v", err)
-		os.Exit(1)
+		if err := storageNode.Shutdown(ctx); err != nil {
+			log.Fatalf("Graceful shutdown failed: %v", err)
+		}
+		log.Printf("Storage node stopped")
 	}
 }
\ No newline at end of file
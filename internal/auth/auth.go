@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// BearerToken extracts the token from an "Authorization: Bearer <token>"
+// header, returning "" if there is none
+func BearerToken(r *http.Request) string {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Equal compares two tokens in constant time
+func Equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// SetBearer adds an Authorization header carrying token, if token is set
+func SetBearer(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// Transport is an http.RoundTripper that authenticates every request with a
+// bearer token
+type Transport struct {
+	Token string
+	Base  http.RoundTripper // Defaults to http.DefaultTransport
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	SetBearer(req, t.Token)
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// RPCCredentials attaches a bearer token to every gRPC call. It implements
+// credentials.PerRPCCredentials and works over plaintext connections.
+type RPCCredentials struct {
+	Token string
+}
+
+func (c RPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.Token}, nil
+}
+
+func (c RPCCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// RPCToken extracts the bearer token from incoming gRPC metadata
+func RPCToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+
+	token, found := strings.CutPrefix(values[0], "Bearer ")
+	if !found {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
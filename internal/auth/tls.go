@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// TLSConfig names the PEM files a server or cluster client uses for TLS.
+// The zero value leaves TLS off, so plain HTTP stays the default.
+type TLSConfig struct {
+	CertFile string // Certificate presented to peers, both when serving and when calling
+	KeyFile  string // Private key for CertFile
+	CAFile   string // CA that peer certificates must chain to; enables mutual TLS
+}
+
+// Enabled reports whether a certificate is configured
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Validate checks that the certificate and key are given together and that
+// a CA is only given alongside them
+func (c TLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("TLS certificate and key must be given together")
+	}
+	if c.CAFile != "" && !c.Enabled() {
+		return fmt.Errorf("TLS CA given without a certificate and key")
+	}
+	return nil
+}
+
+// Scheme returns the URL scheme for talking to a peer using this config
+func (c TLSConfig) Scheme() string {
+	if c.Enabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// ServerConfig returns the TLS config to serve with, or nil when TLS is off.
+// With a CA configured, client certificates signed by it are verified; they
+// are required only if requireClientCert is set, so a server that also
+// faces end users can still accept clients without one.
+func (c TLSConfig) ServerConfig(requireClientCert bool) (*tls.Config, error) {
+	if err := c.Validate(); err != nil || !c.Enabled() {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if requireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return config, nil
+}
+
+// ClientConfig returns the TLS config for calling peers, or nil when TLS is
+// off. The certificate is presented to peers that verify clients, and peer
+// certificates are checked against the CA, or the system roots without one.
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	if err := c.Validate(); err != nil || !c.Enabled() {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// TLSTransport returns an http.RoundTripper that dials peers with config,
+// or http.DefaultTransport when config is nil
+func TLSTransport(config *tls.Config) http.RoundTripper {
+	if config == nil {
+		return http.DefaultTransport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport
+}
+
+// VerifiedPeer reports whether the request came over TLS with a client
+// certificate that was verified against the configured CA
+func VerifiedPeer(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in TLS CA %s", path)
+	}
+	return pool, nil
+}
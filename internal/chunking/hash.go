@@ -0,0 +1,111 @@
+package chunking
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"lukechampine.com/blake3"
+)
+
+// HashAlgorithm names the function chunks are hashed with. Both produce
+// 256-bit digests, so hashes look alike; which one produced a chunk's hash
+// is recorded with the chunk.
+type HashAlgorithm string
+
+const (
+	SHA256 HashAlgorithm = "sha256"
+	BLAKE3 HashAlgorithm = "blake3" // Several times faster than SHA-256 on large inputs
+
+	DefaultHashAlgorithm = SHA256
+)
+
+// hashAlgorithms lists every supported algorithm, most common first
+var hashAlgorithms = []HashAlgorithm{SHA256, BLAKE3}
+
+// ParseHashAlgorithm returns the algorithm with the given name
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	for _, algorithm := range hashAlgorithms {
+		if string(algorithm) == name {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q (expected sha256 or blake3)", name)
+}
+
+// New returns a streaming hash for the algorithm
+func (a HashAlgorithm) New() hash.Hash {
+	if a == BLAKE3 {
+		return blake3.New(32, nil)
+	}
+	return sha256.New()
+}
+
+// Sum returns the hex-encoded hash of data
+func (a HashAlgorithm) Sum(data []byte) string {
+	if a == BLAKE3 {
+		sum := blake3.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchHash reports which supported algorithm hashes data to want, for
+// checking data whose algorithm isn't at hand. Data can only match under a
+// second algorithm by breaking it, so accepting either is as safe as
+// checking the recorded one.
+func MatchHash(data []byte, want string) (HashAlgorithm, bool) {
+	for _, algorithm := range hashAlgorithms {
+		if algorithm.Sum(data) == want {
+			return algorithm, true
+		}
+	}
+	return "", false
+}
+
+// MatchHashReader is MatchHash for data read from r, hashing it with every
+// algorithm in a single pass
+func MatchHashReader(r io.Reader, want string) (bool, error) {
+	matcher := NewMatcher()
+	if _, err := io.Copy(matcher, r); err != nil {
+		return false, err
+	}
+	return matcher.Matches(want), nil
+}
+
+// Matcher hashes what is written to it with every supported algorithm, so
+// data can be checked against a hash as it streams past
+type Matcher struct {
+	hashers []hash.Hash
+	w       io.Writer
+}
+
+// NewMatcher returns a Matcher that has seen no data
+func NewMatcher() *Matcher {
+	m := &Matcher{hashers: make([]hash.Hash, len(hashAlgorithms))}
+	writers := make([]io.Writer, len(hashAlgorithms))
+	for i, algorithm := range hashAlgorithms {
+		m.hashers[i] = algorithm.New()
+		writers[i] = m.hashers[i]
+	}
+	m.w = io.MultiWriter(writers...)
+	return m
+}
+
+func (m *Matcher) Write(p []byte) (int, error) {
+	return m.w.Write(p)
+}
+
+// Matches reports whether the data written so far hashes to want under any
+// supported algorithm
+func (m *Matcher) Matches(want string) bool {
+	for _, h := range m.hashers {
+		if hex.EncodeToString(h.Sum(nil)) == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package chunking
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func TestHashAlgorithmSum(t *testing.T) {
+	for _, tc := range []struct {
+		algorithm HashAlgorithm
+		data      string
+		want      string
+	}{
+		{SHA256, "", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{SHA256, "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{BLAKE3, "", "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{BLAKE3, "abc", "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+	} {
+		if got := tc.algorithm.Sum([]byte(tc.data)); got != tc.want {
+			t.Errorf("%s(%q) = %s, want %s", tc.algorithm, tc.data, got, tc.want)
+		}
+
+		// The streaming hash agrees with Sum
+		h := tc.algorithm.New()
+		h.Write([]byte(tc.data))
+		if got := tc.algorithm.Sum([]byte(tc.data)); got != hex.EncodeToString(h.Sum(nil)) {
+			t.Errorf("%s: streaming hash differs from Sum", tc.algorithm)
+		}
+	}
+}
+
+func TestParseHashAlgorithm(t *testing.T) {
+	for _, algorithm := range hashAlgorithms {
+		got, err := ParseHashAlgorithm(string(algorithm))
+		if err != nil || got != algorithm {
+			t.Errorf("ParseHashAlgorithm(%q) = %q, %v", algorithm, got, err)
+		}
+	}
+	if _, err := ParseHashAlgorithm("md5"); err == nil {
+		t.Error("want an error for an unknown algorithm")
+	}
+}
+
+func TestMatchHash(t *testing.T) {
+	data := randomData(t, 10000)
+	for _, algorithm := range hashAlgorithms {
+		want := algorithm.Sum(data)
+
+		got, ok := MatchHash(data, want)
+		if !ok || got != algorithm {
+			t.Errorf("MatchHash under %s = %q, %v", algorithm, got, ok)
+		}
+		if ok, err := MatchHashReader(bytes.NewReader(data), want); err != nil || !ok {
+			t.Errorf("MatchHashReader under %s = %v, %v", algorithm, ok, err)
+		}
+	}
+
+	if _, ok := MatchHash(data, SHA256.Sum(data[1:])); ok {
+		t.Error("want no match for another input's hash")
+	}
+}
+
+// TestChunkReaderHashAlgorithm checks chunks are hashed with the chosen
+// algorithm, and are cut at the same places whichever it is
+func TestChunkReaderHashAlgorithm(t *testing.T) {
+	data := randomData(t, 3*MaxChunkSize)
+
+	var boundaries [][]int64
+	for _, algorithm := range hashAlgorithms {
+		cr := NewChunkReader(bytes.NewReader(data))
+		cr.UseHashAlgorithm(algorithm)
+
+		var offsets []int64
+		var joined []byte
+		for {
+			chunk, err := cr.NextChunk()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunk.Algorithm != algorithm || chunk.Hash != algorithm.Sum(chunk.Data) {
+				t.Fatalf("chunk at %d not hashed with %s", chunk.Offset, algorithm)
+			}
+			offsets = append(offsets, chunk.Offset)
+			joined = append(joined, chunk.Data...)
+		}
+		cr.Close()
+
+		if !bytes.Equal(joined, data) {
+			t.Fatalf("%s: chunks don't join back into the input", algorithm)
+		}
+		boundaries = append(boundaries, offsets)
+	}
+
+	for _, offsets := range boundaries[1:] {
+		if len(offsets) != len(boundaries[0]) {
+			t.Fatal("chunk boundaries depend on the hash algorithm")
+		}
+	}
+}
+
+// BenchmarkChunking measures chunking throughput, including hashing every
+// chunk, under each algorithm
+func BenchmarkChunking(b *testing.B) {
+	data := make([]byte, 64<<20)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, algorithm := range hashAlgorithms {
+		b.Run(string(algorithm), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				cr := NewChunkReader(bytes.NewReader(data))
+				cr.UseHashAlgorithm(algorithm)
+				for {
+					if _, err := cr.NextChunk(); err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatal(err)
+					}
+				}
+				cr.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkHash measures the hash alone on one average-sized chunk
+func BenchmarkHash(b *testing.B) {
+	data := make([]byte, AvgChunkSize)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, algorithm := range hashAlgorithms {
+		b.Run(string(algorithm), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				algorithm.Sum(data)
+			}
+		})
+	}
+}
+
+func randomData(t *testing.T, n int) []byte {
+	t.Helper()
+
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,123 @@
+package chunking
+
+import (
+	"io"
+	"runtime"
+)
+
+// DefaultHashWorkers is how many goroutines hash chunks by default: one per
+// CPU the Go runtime may use, up to maxDefaultHashWorkers
+func DefaultHashWorkers() int {
+	return min(runtime.GOMAXPROCS(0), maxDefaultHashWorkers)
+}
+
+// maxDefaultHashWorkers caps the default, since every worker lets a reader
+// hold one more chunk in memory ahead of its caller
+const maxDefaultHashWorkers = 4
+
+// UseHashWorkers hashes chunks on that many goroutines while later chunks
+// are read, instead of hashing each inside NextChunk. Chunks still come out
+// in order with their offsets. Up to workers+1 chunks are read ahead of the
+// caller, so each worker costs up to MaxChunkSize of memory. Call it before
+// reading any chunks, and Close the reader when done with it.
+func (cr *ChunkReader) UseHashWorkers(workers int) {
+	cr.workers = workers
+}
+
+// Close stops the goroutines reading and hashing ahead. Chunks already read
+// ahead are dropped. It is a no-op for readers that hash in NextChunk.
+func (cr *ChunkReader) Close() {
+	if cr.pipeline != nil {
+		cr.pipeline.stopOnce()
+	}
+}
+
+// hashPipeline cuts chunks on one goroutine and hashes them on a pool of
+// workers. Chunks are queued for the caller in the order they were cut, so
+// hashes finishing out of order don't reorder them.
+type hashPipeline struct {
+	queue   chan *pendingChunk // In file order; closed after the last chunk or error
+	stop    chan struct{}
+	stopped bool
+}
+
+// pendingChunk is a cut chunk whose hash may still be being computed
+type pendingChunk struct {
+	chunk  *Chunk
+	err    error
+	hashed chan struct{} // Closed once chunk.Hash is set, or straight away on error
+}
+
+func (p *hashPipeline) stopOnce() {
+	if !p.stopped {
+		p.stopped = true
+		close(p.stop)
+	}
+}
+
+// nextHashed returns the next chunk from the pipeline, starting it on the
+// first call
+func (cr *ChunkReader) nextHashed() (*Chunk, error) {
+	if cr.pipeline == nil {
+		cr.pipeline = cr.startPipeline()
+	}
+
+	pending, ok := <-cr.pipeline.queue
+	if !ok {
+		return nil, io.EOF
+	}
+	<-pending.hashed
+	if pending.err != nil {
+		return nil, pending.err
+	}
+	return pending.chunk, nil
+}
+
+func (cr *ChunkReader) startPipeline() *hashPipeline {
+	p := &hashPipeline{
+		queue: make(chan *pendingChunk, cr.workers),
+		stop:  make(chan struct{}),
+	}
+
+	jobs := make(chan *pendingChunk)
+	for i := 0; i < cr.workers; i++ {
+		go func() {
+			for pending := range jobs {
+				pending.chunk.Hash = cr.hash.Sum(pending.chunk.Data)
+				close(pending.hashed)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(p.queue)
+		defer close(jobs)
+
+		for {
+			chunk, err := cr.cut()
+			pending := &pendingChunk{chunk: chunk, err: err, hashed: make(chan struct{})}
+			if err != nil {
+				// io.EOF included: the caller sees it after every chunk
+				close(pending.hashed)
+			}
+
+			// Queue the chunk before it is hashed to keep file order
+			select {
+			case p.queue <- pending:
+			case <-p.stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			select {
+			case jobs <- pending:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+
+	return p
+}
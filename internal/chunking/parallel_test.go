@@ -0,0 +1,142 @@
+package chunking
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// readChunks reads every chunk of r with the given hash workers, returning
+// the chunks read before any error
+func readChunks(r io.Reader, algorithm HashAlgorithm, workers int) ([]*Chunk, error) {
+	cr := NewChunkReader(r)
+	cr.UseHashAlgorithm(algorithm)
+	cr.UseHashWorkers(workers)
+	defer cr.Close()
+
+	var chunks []*Chunk
+	for {
+		chunk, err := cr.NextChunk()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return chunks, err
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+// TestParallelHashingMatchesSerial checks hashing on a pool of workers
+// yields the same chunks, hashes, offsets and order as hashing in NextChunk,
+// for inputs of no chunks, one short chunk and many
+func TestParallelHashingMatchesSerial(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty": nil,
+		"short": randomData(t, 1000),
+		"large": randomData(t, 10*MaxChunkSize),
+	}
+
+	for name, data := range inputs {
+		for _, algorithm := range hashAlgorithms {
+			serial, err := readChunks(bytes.NewReader(data), algorithm, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, workers := range []int{2, 4, 16} {
+				t.Run(fmt.Sprintf("%s/%s/%d", name, algorithm, workers), func(t *testing.T) {
+					parallel, err := readChunks(bytes.NewReader(data), algorithm, workers)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if len(parallel) != len(serial) {
+						t.Fatalf("want %d chunks, got %d", len(serial), len(parallel))
+					}
+					for i, chunk := range parallel {
+						want := serial[i]
+						if chunk.Hash != want.Hash || chunk.Offset != want.Offset || chunk.Size != want.Size ||
+							chunk.Algorithm != algorithm || !bytes.Equal(chunk.Data, want.Data) {
+							t.Errorf("chunk %d: want %s at %d, got %s at %d", i, want.Hash[:8], want.Offset, chunk.Hash[:8], chunk.Offset)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestParallelHashingReadError checks a read error reaches the caller after
+// the chunks cut before it, as it does when hashing serially
+func TestParallelHashingReadError(t *testing.T) {
+	data := randomData(t, 4*MaxChunkSize)
+	failure := errors.New("connection reset")
+	reader := func() io.Reader {
+		return io.MultiReader(bytes.NewReader(data), iotest.ErrReader(failure))
+	}
+
+	serial, err := readChunks(reader(), SHA256, 0)
+	if !errors.Is(err, failure) {
+		t.Fatalf("serial: want the read error, got %v", err)
+	}
+	parallel, err := readChunks(reader(), SHA256, 4)
+	if !errors.Is(err, failure) {
+		t.Fatalf("parallel: want the read error, got %v", err)
+	}
+	if len(parallel) != len(serial) {
+		t.Fatalf("want %d chunks before the error, got %d", len(serial), len(parallel))
+	}
+	for i, chunk := range parallel {
+		if chunk.Hash != serial[i].Hash {
+			t.Errorf("chunk %d differs", i)
+		}
+	}
+}
+
+// TestParallelHashingClose checks closing a reader part way through stops
+// its goroutines
+func TestParallelHashingClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	cr := NewChunkReader(bytes.NewReader(randomData(t, 10*MaxChunkSize)))
+	cr.UseHashWorkers(4)
+	if _, err := cr.NextChunk(); err != nil {
+		t.Fatal(err)
+	}
+	cr.Close()
+	cr.Close()
+
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d goroutines once closed, still %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// BenchmarkParallelHashing measures chunking throughput hashing serially
+// and on pools of workers
+func BenchmarkParallelHashing(b *testing.B) {
+	data := make([]byte, 64<<20)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, workers := range []int{0, 2, 4, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := readChunks(bytes.NewReader(data), SHA256, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,6 @@
 package chunking
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"io"
 )
 
@@ -17,10 +15,11 @@ const (
 
 // Chunk represents a single chunk of data with its hash
 type Chunk struct {
-	Data     []byte // The actual chunk data
-	Hash     string // SHA-256 hash of the chunk (used for deduplication)
-	Size     int    // Size in bytes
-	Offset   int64  // Offset in original file
+	Data      []byte        // The actual chunk data
+	Hash      string        // Hash of the chunk (used for deduplication)
+	Algorithm HashAlgorithm // Function Hash was computed with
+	Size      int           // Size in bytes
+	Offset    int64         // Offset in original file
 }
 
 // ChunkReader performs content-defined chunking using Rabin fingerprinting
@@ -30,6 +29,10 @@ type ChunkReader struct {
 	windowSize  int
 	polynomial  uint64
 	offset      int64
+	hash        HashAlgorithm
+
+	workers  int           // Goroutines hashing chunks; 0 or 1 hashes in NextChunk
+	pipeline *hashPipeline // nil until the first NextChunk with workers
 }
 
 // NewChunkReader creates a new ChunkReader with Rabin fingerprinting
@@ -40,12 +43,33 @@ func NewChunkReader(r io.Reader) *ChunkReader {
 		windowSize: WindowSize,
 		polynomial: RabinPolynomial,
 		offset:     0,
+		hash:       DefaultHashAlgorithm,
 	}
 }
 
+// UseHashAlgorithm sets the function chunks are hashed with. Call it before
+// reading any chunks.
+func (cr *ChunkReader) UseHashAlgorithm(algorithm HashAlgorithm) {
+	cr.hash = algorithm
+}
+
 // NextChunk reads the next content-defined chunk
 // Uses Rabin fingerprinting to find chunk boundaries based on content patterns
 func (cr *ChunkReader) NextChunk() (*Chunk, error) {
+	if cr.workers > 1 {
+		return cr.nextHashed()
+	}
+
+	chunk, err := cr.cut()
+	if err != nil {
+		return nil, err
+	}
+	chunk.Hash = cr.hash.Sum(chunk.Data)
+	return chunk, nil
+}
+
+// cut reads the next chunk without hashing it
+func (cr *ChunkReader) cut() (*Chunk, error) {
 	n, err := io.ReadFull(cr.reader, cr.buffer)
 	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return nil, err
@@ -70,13 +94,10 @@ func (cr *ChunkReader) NextChunk() (*Chunk, error) {
 	chunkData := make([]byte, chunkSize)
 	copy(chunkData, cr.buffer[:chunkSize])
 
-	// Calculate SHA-256 hash for deduplication
-	hash := sha256.Sum256(chunkData)
-	hashString := hex.EncodeToString(hash[:])
-
+	// The hash for deduplication is added by the caller
 	chunk := &Chunk{
-		Data:   chunkData,
-		Hash:   hashString,
+		Data:      chunkData,
+		Algorithm: cr.hash,
 		Size:   chunkSize,
 		Offset: cr.offset,
 	}
@@ -144,9 +165,23 @@ func (br *bufferReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
-// ChunkFile is a helper function that chunks an entire file
-func ChunkFile(r io.Reader) ([]*Chunk, error) {
+// WholeChunk returns data as a single chunk at offset 0, for files too
+// small to be worth content-defined chunking
+func WholeChunk(data []byte, algorithm HashAlgorithm) *Chunk {
+	return &Chunk{
+		Data:      data,
+		Hash:      algorithm.Sum(data),
+		Algorithm: algorithm,
+		Size:      len(data),
+	}
+}
+
+// ChunkFile is a helper function that chunks an entire file. An empty
+// reader yields no chunks and no error; the chunks of any other reader cover
+// it exactly, each starting at the offset where the previous one ended.
+func ChunkFile(r io.Reader, algorithm HashAlgorithm) ([]*Chunk, error) {
 	cr := NewChunkReader(r)
+	cr.UseHashAlgorithm(algorithm)
 	chunks := []*Chunk{}
 
 	for {
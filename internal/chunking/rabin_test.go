@@ -0,0 +1,84 @@
+package chunking
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// chunkSizes returns the sizes of data's chunks, checking they cover it in
+// order, each starting where the previous one ended
+func chunkSizes(t *testing.T, data []byte) []int {
+	t.Helper()
+
+	chunks, err := ChunkFile(bytes.NewReader(data), SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{}
+	var offset int64
+	for i, chunk := range chunks {
+		end := offset + int64(chunk.Size)
+		if chunk.Offset != offset || chunk.Size != len(chunk.Data) || end > int64(len(data)) || !bytes.Equal(chunk.Data, data[offset:end]) {
+			t.Fatalf("chunk %d: want the %d bytes at offset %d, got %d at %d", i, chunk.Size, offset, len(chunk.Data), chunk.Offset)
+		}
+		if chunk.Hash != SHA256.Sum(chunk.Data) {
+			t.Errorf("chunk %d: hash doesn't match its data", i)
+		}
+		offset = end
+		sizes = append(sizes, chunk.Size)
+	}
+	if offset != int64(len(data)) {
+		t.Fatalf("chunks cover %d of %d bytes", offset, len(data))
+	}
+	return sizes
+}
+
+// TestChunkFileBoundaries chunks files at and either side of the chunk size
+// limits. Zeros put a boundary at the first place one is allowed, and 0xff
+// bytes never match, so chunks of each are cut at exactly MinChunkSize and
+// MaxChunkSize.
+func TestChunkFileBoundaries(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fill byte
+		size int
+		want []int
+	}{
+		{"empty", 0, 0, []int{}},
+		{"one byte", 0, 1, []int{1}},
+		{"under min", 0, MinChunkSize - 1, []int{MinChunkSize - 1}},
+		{"min", 0, MinChunkSize, []int{MinChunkSize}},
+		{"over min", 0, MinChunkSize + 1, []int{MinChunkSize, 1}},
+		{"twice min", 0, 2 * MinChunkSize, []int{MinChunkSize, MinChunkSize}},
+		{"avg without boundary", 0xff, AvgChunkSize, []int{AvgChunkSize}},
+		{"under max", 0xff, MaxChunkSize - 1, []int{MaxChunkSize - 1}},
+		{"max", 0xff, MaxChunkSize, []int{MaxChunkSize}},
+		{"over max", 0xff, MaxChunkSize + 1, []int{MaxChunkSize, 1}},
+		{"twice max", 0xff, 2 * MaxChunkSize, []int{MaxChunkSize, MaxChunkSize}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte{tc.fill}, tc.size)
+			if got := chunkSizes(t, data); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want chunks of %v bytes, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+// TestChunkFileSizeLimits chunks random files at the chunk size limits and
+// checks every chunk but the last is between MinChunkSize and MaxChunkSize
+func TestChunkFileSizeLimits(t *testing.T) {
+	for _, size := range []int{MinChunkSize, AvgChunkSize, MaxChunkSize, MaxChunkSize + 1, 5 * MaxChunkSize} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			sizes := chunkSizes(t, randomData(t, size))
+			for i, chunkSize := range sizes {
+				if chunkSize > MaxChunkSize || chunkSize < MinChunkSize && i < len(sizes)-1 {
+					t.Errorf("chunk %d of %d: %d bytes is outside the limits", i, len(sizes), chunkSize)
+				}
+			}
+		})
+	}
+}
@@ -3,26 +3,75 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
-	KeySize   = 32 // AES-256 requires 32 byte key
-	SaltSize  = 32 // Salt for key derivation
-	NonceSize = 12 // GCM standard nonce size
-	Iterations = 100000 // PBKDF2 iterations for key derivation
+	KeySize         = 32     // AES-256 and ChaCha20 both take a 32 byte key
+	SaltSize        = 32     // Salt for key derivation
+	NonceSize       = 12     // GCM and ChaCha20-Poly1305 standard nonce size
+	NoncePrefixSize = 4      // Random per-file part of a counter nonce
+	Iterations      = 100000 // PBKDF2 iterations for key derivation
 )
 
+// AEAD algorithms chunks can be encrypted with
+const (
+	AlgorithmAESGCM           = "AES-256-GCM"
+	AlgorithmChaCha20Poly1305 = "ChaCha20-Poly1305" // Fast without AES hardware
+)
+
+// ErrUnknownAlgorithm is returned for an encryption algorithm that isn't
+// supported
+var ErrUnknownAlgorithm = errors.New("unknown encryption algorithm")
+
+// ParseAlgorithm returns the canonical name of an encryption algorithm,
+// matched without regard to case
+func ParseAlgorithm(name string) (string, error) {
+	for _, algorithm := range []string{AlgorithmAESGCM, AlgorithmChaCha20Poly1305} {
+		if strings.EqualFold(name, algorithm) {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q (expected %s or %s)", ErrUnknownAlgorithm, name, AlgorithmAESGCM, AlgorithmChaCha20Poly1305)
+}
+
 // EncryptionKey represents a derived encryption key
 type EncryptionKey struct {
 	Key  []byte
 	Salt []byte
+
+	// Algorithm is the AEAD chunks are sealed with under this key. Empty
+	// means AES-256-GCM, which every file encrypted before the algorithm
+	// was recorded used.
+	Algorithm string
+}
+
+// aead returns the cipher for the key's algorithm
+func (k *EncryptionKey) aead() (cipher.AEAD, error) {
+	switch k.Algorithm {
+	case "", AlgorithmAESGCM:
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case AlgorithmChaCha20Poly1305:
+		return chacha20poly1305.New(k.Key)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownAlgorithm, k.Algorithm)
+	}
 }
 
 // DeriveKey derives an encryption key from a password using PBKDF2
@@ -46,52 +95,113 @@ func DeriveKey(password string, salt []byte) (*EncryptionKey, error) {
 	}, nil
 }
 
-// EncryptChunk encrypts a chunk using AES-256-GCM
-// GCM provides both encryption and authentication (AEAD)
-func EncryptChunk(data []byte, key *EncryptionKey) ([]byte, error) {
-	// Create AES cipher
-	block, err := aes.NewCipher(key.Key)
-	if err != nil {
-		return nil, err
+// Zero overwrites the key bytes so the key doesn't linger in memory once it
+// is no longer needed. The salt is not secret and is kept. The key can't be
+// used afterwards.
+func (k *EncryptionKey) Zero() {
+	if k == nil {
+		return
 	}
+	clear(k.Key)
+}
 
-	// Use GCM mode (Galois/Counter Mode)
-	// GCM provides authenticated encryption - it detects tampering
-	gcm, err := cipher.NewGCM(block)
+// EncryptChunk encrypts a chunk with the key's algorithm, AES-256-GCM by
+// default. Both algorithms are AEADs: they also detect tampering.
+func EncryptChunk(data []byte, key *EncryptionKey) ([]byte, error) {
+	aead, err := key.aead()
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate a random nonce (number used once)
 	// Critical: Never reuse a nonce with the same key
-	nonce := make([]byte, gcm.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
 
 	// Encrypt and authenticate the data
 	// The nonce is prepended to the ciphertext so we can decrypt later
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
+	ciphertext := aead.Seal(nonce, nonce, data, nil)
 
 	return ciphertext, nil
 }
 
-// DecryptChunk decrypts a chunk encrypted with EncryptChunk
-func DecryptChunk(ciphertext []byte, key *EncryptionKey) ([]byte, error) {
-	// Create AES cipher
-	block, err := aes.NewCipher(key.Key)
+// MaxChunksPerKey caps how many chunks are encrypted under one key. Counter
+// nonces cannot repeat below 2^64, but NIST SP 800-38D limits a GCM key to
+// 2^32 invocations, which is also where random 96-bit nonces start to risk
+// collisions. Keys are derived per file, so this is a per-file limit.
+const MaxChunksPerKey = 1 << 32
+
+// ErrTooManyChunks is returned when a file would need more than
+// MaxChunksPerKey chunks under one key
+var ErrTooManyChunks = errors.New("too many chunks for one encryption key")
+
+// NewNoncePrefix returns a random prefix for a file's chunk nonces. It is
+// stored with the file; see ChunkNonce.
+func NewNoncePrefix() ([]byte, error) {
+	prefix := make([]byte, NoncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+	return prefix, nil
+}
+
+// ChunkNonce derives the nonce for the chunk at the given index of a file:
+// the file's 4-byte prefix followed by the 8-byte big-endian index. Every
+// chunk of a file gets a distinct nonce without relying on randomness.
+func ChunkNonce(prefix []byte, index uint64) ([]byte, error) {
+	if len(prefix) != NoncePrefixSize {
+		return nil, fmt.Errorf("nonce prefix must be %d bytes", NoncePrefixSize)
+	}
+	if index >= MaxChunksPerKey {
+		return nil, ErrTooManyChunks
+	}
+
+	nonce := make([]byte, NonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[NoncePrefixSize:], index)
+	return nonce, nil
+}
+
+// EncryptChunkAt encrypts the chunk at the given index of a file with the
+// key's algorithm and a counter nonce from ChunkNonce. The output has the
+// same layout as EncryptChunk, so DecryptChunk reads both.
+func EncryptChunkAt(data []byte, key *EncryptionKey, noncePrefix []byte, index uint64) ([]byte, error) {
+	nonce, err := ChunkNonce(noncePrefix, index)
 	if err != nil {
 		return nil, err
 	}
 
-	// Use GCM mode
-	gcm, err := cipher.NewGCM(block)
+	aead, err := key.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// DecryptChunk decrypts a chunk encrypted with EncryptChunk or EncryptChunkAt
+// under a key for the same algorithm
+func DecryptChunk(ciphertext []byte, key *EncryptionKey) ([]byte, error) {
+	return decryptChunk(ciphertext, key, false)
+}
+
+// DecryptChunkInPlace is DecryptChunk writing the plaintext over the
+// ciphertext instead of into new memory. data is overwritten even if
+// decryption fails.
+func DecryptChunkInPlace(data []byte, key *EncryptionKey) ([]byte, error) {
+	return decryptChunk(data, key, true)
+}
+
+func decryptChunk(ciphertext []byte, key *EncryptionKey, inPlace bool) ([]byte, error) {
+	aead, err := key.aead()
 	if err != nil {
 		return nil, err
 	}
 
 	// Extract nonce from the beginning of ciphertext
-	nonceSize := gcm.NonceSize()
+	nonceSize := aead.NonceSize()
 	if len(ciphertext) < nonceSize {
 		return nil, errors.New("ciphertext too short")
 	}
@@ -99,8 +209,15 @@ func DecryptChunk(ciphertext []byte, key *EncryptionKey) ([]byte, error) {
 	nonce := ciphertext[:nonceSize]
 	ciphertext = ciphertext[nonceSize:]
 
+	// Both AEADs decrypt in place when the plaintext starts where the
+	// ciphertext does
+	var dst []byte
+	if inPlace {
+		dst = ciphertext[:0]
+	}
+
 	// Decrypt and verify authentication tag
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aead.Open(dst, nonce, ciphertext, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -108,16 +225,43 @@ func DecryptChunk(ciphertext []byte, key *EncryptionKey) ([]byte, error) {
 	return plaintext, nil
 }
 
-// HashPassword creates a deterministic hash from password for server-side verification
-// This does NOT store the actual password
-func HashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
+const (
+	// passwordVerifyLabel separates the verifier from anything else keyed
+	// with a derived key
+	passwordVerifyLabel = "password-verify"
+
+	// verifierPrefix tells verifiers apart from the plain SHA-256
+	// password hashes older versions stored
+	verifierPrefix = "hmac:"
+)
+
+// PasswordVerifier returns the value stored to check passwords against on
+// the server. It is an HMAC keyed with the PBKDF2-derived key, so guessing
+// a password from it costs as much as deriving the key; it reveals
+// nothing about the key itself.
+func PasswordVerifier(key *EncryptionKey) string {
+	mac := hmac.New(sha256.New, key.Key)
+	mac.Write([]byte(passwordVerifyLabel))
+	return verifierPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsPasswordVerifier reports whether a stored password hash came from
+// PasswordVerifier. Hashes older versions stored aren't, and must be
+// dropped rather than checked against.
+func IsPasswordVerifier(hash string) bool {
+	return strings.HasPrefix(hash, verifierPrefix)
+}
+
+// VerifyPassword checks a derived key against a verifier from
+// PasswordVerifier in constant time. This is only a fast-fail check; AEAD
+// authentication remains the real guard.
+func VerifyPassword(key *EncryptionKey, verifier string) bool {
+	return subtle.ConstantTimeCompare([]byte(PasswordVerifier(key)), []byte(verifier)) == 1
 }
 
 // EncryptedChunkMetadata stores encryption information for a chunk
 type EncryptedChunkMetadata struct {
 	IsEncrypted bool   `json:"is_encrypted"`
 	Salt        string `json:"salt,omitempty"`        // Hex-encoded salt for key derivation
-	Algorithm   string `json:"algorithm,omitempty"`   // "AES-256-GCM"
+	Algorithm   string `json:"algorithm,omitempty"`   // AlgorithmAESGCM or AlgorithmChaCha20Poly1305
 }
\ No newline at end of file
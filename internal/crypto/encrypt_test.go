@@ -0,0 +1,214 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	key, err := DeriveKey("correct horse", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := PasswordVerifier(key)
+
+	same, err := DeriveKey("correct horse", key.Salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyPassword(same, verifier) {
+		t.Error("correct password rejected")
+	}
+
+	wrong, err := DeriveKey("battery staple", key.Salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyPassword(wrong, verifier) {
+		t.Error("wrong password accepted")
+	}
+}
+
+func TestPasswordVerifierIsNotFastHash(t *testing.T) {
+	key, err := DeriveKey("hunter2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := PasswordVerifier(key)
+
+	if !IsPasswordVerifier(verifier) {
+		t.Fatalf("verifier %q not recognized", verifier)
+	}
+
+	// The old scheme: SHA-256 of salt and password, checkable without PBKDF2
+	h := sha256.New()
+	h.Write(key.Salt)
+	h.Write([]byte("hunter2"))
+	legacy := hex.EncodeToString(h.Sum(nil))
+	if verifier == legacy || verifier[len(verifierPrefix):] == legacy {
+		t.Error("verifier is the plain SHA-256 of salt and password")
+	}
+	if IsPasswordVerifier(legacy) {
+		t.Error("legacy hash taken for a verifier")
+	}
+}
+
+// TestChunkNonce checks counter nonces are unique within a file, carry the
+// file's prefix, and stop at MaxChunksPerKey
+func TestChunkNonce(t *testing.T) {
+	prefix, err := NewNoncePrefix()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]uint64)
+	indexes := []uint64{MaxChunksPerKey - 1}
+	for i := uint64(0); i < 10000; i++ {
+		indexes = append(indexes, i)
+	}
+	for _, index := range indexes {
+		nonce, err := ChunkNonce(prefix, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nonce) != NonceSize || !bytes.Equal(nonce[:NoncePrefixSize], prefix) {
+			t.Fatalf("chunk %d: nonce %x does not start with prefix %x", index, nonce, prefix)
+		}
+		if other, repeated := seen[string(nonce)]; repeated {
+			t.Fatalf("chunks %d and %d share nonce %x", other, index, nonce)
+		}
+		seen[string(nonce)] = index
+	}
+
+	if _, err := ChunkNonce(prefix, MaxChunksPerKey); !errors.Is(err, ErrTooManyChunks) {
+		t.Errorf("want ErrTooManyChunks past the limit, got %v", err)
+	}
+	if _, err := ChunkNonce(prefix[:2], 0); err == nil {
+		t.Error("want a short prefix rejected")
+	}
+}
+
+// TestEncryptChunkAt encrypts a file's chunks with counter nonces under
+// each algorithm and checks every nonce differs and each chunk decrypts
+// to its plaintext
+func TestEncryptChunkAt(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmAESGCM, AlgorithmChaCha20Poly1305} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := DeriveKey("secret", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key.Algorithm = algorithm
+			prefix, err := NewNoncePrefix()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nonces := make(map[string]bool)
+			for i := uint64(0); i < 100; i++ {
+				plaintext := []byte(fmt.Sprintf("chunk %d", i))
+				ciphertext, err := EncryptChunkAt(plaintext, key, prefix, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				nonce := string(ciphertext[:NonceSize])
+				if nonces[nonce] {
+					t.Fatalf("chunk %d reuses a nonce", i)
+				}
+				nonces[nonce] = true
+
+				got, err := DecryptChunk(ciphertext, key)
+				if err != nil {
+					t.Fatalf("chunk %d: %v", i, err)
+				}
+				if !bytes.Equal(got, plaintext) {
+					t.Fatalf("chunk %d: want %q, got %q", i, plaintext, got)
+				}
+			}
+		})
+	}
+}
+
+// TestAlgorithms checks each algorithm decrypts what it encrypted, a key
+// for the other algorithm can't, and a key naming no algorithm is AES-GCM
+func TestAlgorithms(t *testing.T) {
+	plaintext := []byte("chunk data")
+	keys := make(map[string]*EncryptionKey)
+	for _, algorithm := range []string{"", AlgorithmAESGCM, AlgorithmChaCha20Poly1305} {
+		key, err := DeriveKey("secret", []byte("fixed salt for every algorithm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.Algorithm = algorithm
+		keys[algorithm] = key
+	}
+
+	for sealed, key := range keys {
+		ciphertext, err := EncryptChunk(plaintext, key)
+		if err != nil {
+			t.Fatalf("%q: %v", sealed, err)
+		}
+		for opened, other := range keys {
+			got, err := DecryptChunk(ciphertext, other)
+			same := sealed == opened || (sealed == "" && opened == AlgorithmAESGCM) || (sealed == AlgorithmAESGCM && opened == "")
+			if same && (err != nil || !bytes.Equal(got, plaintext)) {
+				t.Errorf("sealed with %q, opened with %q: want the plaintext, got %q (%v)", sealed, opened, got, err)
+			}
+			if !same && err == nil {
+				t.Errorf("sealed with %q, opened with %q: want an error", sealed, opened)
+			}
+		}
+	}
+
+	unknown := &EncryptionKey{Key: keys[""].Key, Algorithm: "DES"}
+	if _, err := EncryptChunk(plaintext, unknown); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("unknown algorithm: want ErrUnknownAlgorithm, got %v", err)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for name, want := range map[string]string{
+		"AES-256-GCM":       AlgorithmAESGCM,
+		"aes-256-gcm":       AlgorithmAESGCM,
+		"chacha20-poly1305": AlgorithmChaCha20Poly1305,
+	} {
+		if got, err := ParseAlgorithm(name); err != nil || got != want {
+			t.Errorf("%q: want %s, got %q (%v)", name, want, got, err)
+		}
+	}
+	for _, name := range []string{"", "aes", "ChaCha20"} {
+		if _, err := ParseAlgorithm(name); !errors.Is(err, ErrUnknownAlgorithm) {
+			t.Errorf("%q: want ErrUnknownAlgorithm, got %v", name, err)
+		}
+	}
+}
+
+// TestZero checks Zero wipes the key's own buffer, which every copy of the
+// key shares, and keeps the salt
+func TestZero(t *testing.T) {
+	key, err := DeriveKey("secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := key.Key
+	salt := bytes.Clone(key.Salt)
+	if bytes.Equal(buffer, make([]byte, len(buffer))) {
+		t.Fatal("derived key is all zeros")
+	}
+
+	key.Zero()
+	if !bytes.Equal(buffer, make([]byte, len(buffer))) {
+		t.Errorf("key buffer not zeroed: %x", buffer)
+	}
+	if !bytes.Equal(key.Salt, salt) {
+		t.Error("salt changed")
+	}
+
+	var none *EncryptionKey
+	none.Zero()
+}
@@ -0,0 +1,142 @@
+package dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// chunksBucket holds the index entries, keyed by chunk hash
+var chunksBucket = []byte("chunks")
+
+// boltIndex keeps the index in a bbolt database, so it is never loaded into
+// memory as a whole and each change writes only the entry it touches
+type boltIndex struct {
+	db *bolt.DB
+}
+
+// openBoltIndex opens the index database at path. A JSON index left at
+// jsonPath by an earlier run is imported into an empty database and renamed
+// out of the way, so switching backends keeps every chunk.
+func openBoltIndex(path, jsonPath string) (*boltIndex, error) {
+	// Another process holding the database would otherwise block forever
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	index := &boltIndex{db: db}
+	empty := true
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(chunksBucket)
+		if err != nil {
+			return err
+		}
+		empty = bucket.Stats().KeyN == 0
+		return nil
+	})
+	if err == nil && empty {
+		err = index.importJSON(jsonPath)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// importJSON copies the entries of a JSON index file into the database in a
+// single transaction, then renames the file so it isn't imported again
+func (bi *boltIndex) importJSON(jsonPath string) error {
+	legacy, err := openJSONIndex(jsonPath)
+	if err != nil {
+		return err
+	}
+	if len(legacy.entries) == 0 {
+		return nil
+	}
+
+	// Inserting in key order appends to the B+tree instead of splitting
+	// pages all over it, which is orders of magnitude faster for big indexes
+	hashes := make([]string, 0, len(legacy.entries))
+	for hash := range legacy.entries {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	err = bi.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(chunksBucket)
+		for _, hash := range hashes {
+			value, err := json.Marshal(legacy.entries[hash])
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(hash), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", jsonPath, err)
+	}
+
+	log.Printf("Imported %d chunks from %s into the chunk index database", len(legacy.entries), jsonPath)
+	return os.Rename(jsonPath, jsonPath+".imported")
+}
+
+func (bi *boltIndex) get(hash string) (*ChunkMetadata, bool, error) {
+	var metadata *ChunkMetadata
+	err := bi.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(chunksBucket).Get([]byte(hash))
+		if value == nil {
+			return nil
+		}
+		metadata = &ChunkMetadata{}
+		return json.Unmarshal(value, metadata)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return metadata, metadata != nil, nil
+}
+
+func (bi *boltIndex) put(metadata *ChunkMetadata) error {
+	value, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return bi.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(chunksBucket).Put([]byte(metadata.Hash), value)
+	})
+}
+
+func (bi *boltIndex) delete(hash string) error {
+	return bi.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(chunksBucket).Delete([]byte(hash))
+	})
+}
+
+// each walks the entries with a cursor, decoding one at a time
+func (bi *boltIndex) each(fn func(metadata *ChunkMetadata) error) error {
+	return bi.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(chunksBucket).ForEach(func(_, value []byte) error {
+			metadata := &ChunkMetadata{}
+			if err := json.Unmarshal(value, metadata); err != nil {
+				return err
+			}
+			return fn(metadata)
+		})
+	})
+}
+
+// flush syncs the database file; every change is already committed
+func (bi *boltIndex) flush() error {
+	return bi.db.Sync()
+}
@@ -0,0 +1,137 @@
+package dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Index backends ChunkStore can keep its chunk index in
+const (
+	IndexJSON = "json" // The whole index in memory, rewritten to one file on every change
+	IndexBolt = "bolt" // A bbolt database; only the entries being used are read
+)
+
+// chunkIndex maps chunk hashes to their metadata for a ChunkStore
+type chunkIndex interface {
+	get(hash string) (*ChunkMetadata, bool, error)
+	put(metadata *ChunkMetadata) error
+	delete(hash string) error
+	each(fn func(metadata *ChunkMetadata) error) error
+	flush() error
+}
+
+// openIndex opens the chunk index kept in basePath by the named backend
+func openIndex(basePath, backend string) (chunkIndex, error) {
+	switch backend {
+	case IndexJSON, "":
+		return openJSONIndex(filepath.Join(basePath, "chunk_index.json"))
+	case IndexBolt:
+		return openBoltIndex(filepath.Join(basePath, "chunk_index.db"), filepath.Join(basePath, "chunk_index.json"))
+	default:
+		return nil, fmt.Errorf("unknown chunk index backend %q (expected %s or %s)", backend, IndexJSON, IndexBolt)
+	}
+}
+
+// chunkTotals are the running totals deduplication statistics come from
+type chunkTotals struct {
+	chunks int
+	size   int64
+	refs   int
+}
+
+// add counts a chunk's metadata in the totals, or takes it out when sign is -1
+func (t *chunkTotals) add(metadata *ChunkMetadata, sign int) {
+	t.chunks += sign
+	t.size += int64(sign * metadata.Size)
+	t.refs += sign * metadata.RefCount
+}
+
+// stats reports the totals as deduplication statistics
+func (t *chunkTotals) stats() map[string]interface{} {
+	// Calculate space savings
+	var savedSpace int64
+	if t.refs > 0 {
+		savedSpace = t.size * int64(t.refs-t.chunks)
+	}
+
+	return map[string]interface{}{
+		"unique_chunks":    t.chunks,
+		"total_references": t.refs,
+		"storage_used":     t.size,
+		"space_saved":      savedSpace,
+		"dedup_ratio":      float64(t.refs) / float64(max(t.chunks, 1)),
+	}
+}
+
+// jsonIndex keeps the whole index in memory and saves it as a single JSON
+// file after every change
+type jsonIndex struct {
+	path    string
+	entries map[string]*ChunkMetadata // hash -> metadata
+}
+
+// openJSONIndex loads the index file at path, starting empty if there is none
+func openJSONIndex(path string) (*jsonIndex, error) {
+	index := &jsonIndex{path: path, entries: make(map[string]*ChunkMetadata)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index.entries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return index, nil
+}
+
+func (ji *jsonIndex) get(hash string) (*ChunkMetadata, bool, error) {
+	metadata, exists := ji.entries[hash]
+	if !exists {
+		return nil, false, nil
+	}
+	copied := *metadata
+	return &copied, true, nil
+}
+
+func (ji *jsonIndex) put(metadata *ChunkMetadata) error {
+	copied := *metadata
+	ji.entries[metadata.Hash] = &copied
+	return ji.flush()
+}
+
+func (ji *jsonIndex) delete(hash string) error {
+	delete(ji.entries, hash)
+	return ji.flush()
+}
+
+func (ji *jsonIndex) each(fn func(metadata *ChunkMetadata) error) error {
+	for _, metadata := range ji.entries {
+		copied := *metadata
+		if err := fn(&copied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush saves the index to disk
+// The index is written to a temp file and renamed into place so a crash
+// mid-write never leaves a half-written index behind
+func (ji *jsonIndex) flush() error {
+	data, err := json.MarshalIndent(ji.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := ji.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, ji.path)
+}
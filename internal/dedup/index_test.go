@@ -0,0 +1,201 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/shard"
+)
+
+// TestChunkStoreWriter isn't a test: TestChunkStoreCrashRecovery runs it in
+// a child process, which stores chunks into CHUNK_STORE_DIR and prints each
+// one's hash and references once stored, until it is killed
+func TestChunkStoreWriter(t *testing.T) {
+	dir := os.Getenv("CHUNK_STORE_DIR")
+	if dir == "" {
+		t.Skip("only run by TestChunkStoreCrashRecovery")
+	}
+
+	store, err := NewChunkStore(dir, shard.DefaultDepth, os.Getenv("CHUNK_STORE_INDEX"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10000; i++ {
+		data, hash := testChunk(t)
+		refs := 1 + i%2
+		for j := 0; j < refs; j++ {
+			if _, _, err := store.StoreChunk(hash, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fmt.Fprintln(os.Stdout, hash, refs)
+	}
+}
+
+// TestChunkStoreCrashRecovery kills a process storing chunks part way
+// through, reopens its store, and checks every chunk it finished storing
+// reads back with its references, under each index backend
+func TestChunkStoreCrashRecovery(t *testing.T) {
+	for _, backend := range []string{IndexJSON, IndexBolt} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			writer := exec.Command(os.Args[0], "-test.run=^TestChunkStoreWriter$")
+			writer.Env = append(os.Environ(), "CHUNK_STORE_DIR="+dir, "CHUNK_STORE_INDEX="+backend)
+			stdout, err := writer.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			stored := make(map[string]int)
+			lines := bufio.NewScanner(stdout)
+			for len(stored) < 50 && lines.Scan() {
+				fields := strings.Fields(lines.Text())
+				if len(fields) != 2 {
+					continue
+				}
+				refs, err := strconv.Atoi(fields[1])
+				if err != nil {
+					t.Fatalf("unexpected writer output %q", lines.Text())
+				}
+				stored[fields[0]] = refs
+			}
+			writer.Process.Kill()
+			writer.Wait()
+			if len(stored) < 50 {
+				t.Fatalf("writer stopped after %d chunks", len(stored))
+			}
+
+			store, err := NewChunkStore(dir, shard.DefaultDepth, backend)
+			if err != nil {
+				t.Fatalf("reopening after the crash: %v", err)
+			}
+			// A chunk being stored when the writer was killed may or may not
+			// have made it
+			if unique := store.GetStats()["unique_chunks"].(int); unique < len(stored) || unique > len(stored)+1 {
+				t.Errorf("want %d or %d chunks indexed, got %d", len(stored), len(stored)+1, unique)
+			}
+
+			for hash, refs := range stored {
+				data, err := store.GetChunk(hash)
+				if err != nil {
+					t.Fatalf("chunk %s lost: %v", hash[:8], err)
+				}
+				if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+					t.Fatalf("chunk %s reads back corrupted", hash[:8])
+				}
+				for i := 0; i < refs; i++ {
+					if !store.HasChunk(hash) {
+						t.Fatalf("chunk %s: want %d references, found %d", hash[:8], refs, i)
+					}
+					if err := store.ReleaseChunk(hash); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if store.HasChunk(hash) {
+					t.Errorf("chunk %s: want %d references, found more", hash[:8], refs)
+				}
+			}
+
+			data, hash := testChunk(t)
+			if _, isNew, err := store.StoreChunk(hash, data); err != nil || !isNew {
+				t.Errorf("storing after recovery: want a new chunk, got new=%v err=%v", isNew, err)
+			}
+		})
+	}
+}
+
+// TestChunkStoreLargeBoltIndex imports a JSON index of far more chunks
+// than it is convenient to store into the bolt backend, and checks lookups
+// and statistics cover all of them without the index being kept in memory
+func TestChunkStoreLargeBoltIndex(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a large index")
+	}
+
+	const chunks = 200000
+	dir := t.TempDir()
+	hashOf := func(i int) string {
+		sum := sha256.Sum256([]byte(strconv.Itoa(i)))
+		return hex.EncodeToString(sum[:])
+	}
+
+	legacy := &jsonIndex{path: filepath.Join(dir, "chunk_index.json"), entries: make(map[string]*ChunkMetadata, chunks)}
+	refs := 0
+	for i := 0; i < chunks; i++ {
+		hash := hashOf(i)
+		legacy.entries[hash] = &ChunkMetadata{
+			Hash:      hash,
+			Size:      1000,
+			RefCount:  1 + i%3,
+			StorePath: shard.Path(filepath.Join(dir, "chunks"), hash, shard.DefaultDepth),
+		}
+		refs += 1 + i%3
+	}
+	if err := legacy.flush(); err != nil {
+		t.Fatal(err)
+	}
+	legacy = nil
+
+	// Collecting twice frees pooled buffers too
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	store, err := NewChunkStore(dir, shard.DefaultDepth, IndexBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 30*time.Second {
+		t.Errorf("importing %d chunks took %v", chunks, took)
+	}
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if grown := int64(after.HeapAlloc) - int64(before.HeapAlloc); grown > 1<<20 {
+		t.Errorf("want the index left on disk, heap grew %d bytes opening it", grown)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "chunk_index.json.imported")); err != nil {
+		t.Errorf("JSON index not set aside once imported: %v", err)
+	}
+
+	stats := store.GetStats()
+	if stats["unique_chunks"] != chunks || stats["total_references"] != refs || stats["storage_used"] != int64(chunks*1000) {
+		t.Errorf("want %d chunks of 1000 bytes with %d references, got %v", chunks, refs, stats)
+	}
+	for _, i := range []int{0, 1, chunks / 2, chunks - 1} {
+		if !store.HasChunk(hashOf(i)) {
+			t.Errorf("chunk %d not found", i)
+		}
+	}
+	if store.HasChunk(hashOf(chunks)) {
+		t.Error("chunk never indexed found")
+	}
+
+	// New chunks go in alongside the imported ones and dedup as usual
+	data := bytes.Repeat([]byte{1}, 1000)
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	for _, wantNew := range []bool{true, false} {
+		if _, isNew, err := store.StoreChunk(hash, data); err != nil || isNew != wantNew {
+			t.Fatalf("want new=%v, got new=%v err=%v", wantNew, isNew, err)
+		}
+	}
+	if got := store.GetStats()["unique_chunks"]; got != chunks+1 {
+		t.Errorf("want %d chunks, got %v", chunks+1, got)
+	}
+}
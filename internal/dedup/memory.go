@@ -0,0 +1,120 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MemoryChunkStore is an in-memory implementation of the chunk store with the
+// same deduplication and reference counting semantics as ChunkStore. It is
+// intended for tests and throwaway development setups.
+type MemoryChunkStore struct {
+	chunks map[string][]byte
+	index  map[string]*ChunkMetadata
+	mu     sync.RWMutex
+}
+
+// NewMemoryChunkStore creates an empty in-memory chunk store
+func NewMemoryChunkStore() *MemoryChunkStore {
+	return &MemoryChunkStore{
+		chunks: make(map[string][]byte),
+		index:  make(map[string]*ChunkMetadata),
+	}
+}
+
+// StoreChunk stores a chunk if it doesn't exist, or increments ref count if it does
+func (ms *MemoryChunkStore) StoreChunk(hash string, data []byte) (string, bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	storePath := "memory:" + hash
+
+	if metadata, exists := ms.index[hash]; exists {
+		metadata.RefCount++
+		return metadata.StorePath, false, nil
+	}
+
+	ms.chunks[hash] = append([]byte(nil), data...)
+	ms.index[hash] = &ChunkMetadata{
+		Hash:      hash,
+		Size:      len(data),
+		RefCount:  1,
+		StorePath: storePath,
+	}
+
+	return storePath, true, nil
+}
+
+// GetChunk retrieves a chunk by its hash
+func (ms *MemoryChunkStore) GetChunk(hash string) ([]byte, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	data, exists := ms.chunks[hash]
+	if !exists {
+		return nil, fmt.Errorf("chunk not found: %s", hash)
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+// HasChunk reports whether a chunk is stored
+func (ms *MemoryChunkStore) HasChunk(hash string) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	_, exists := ms.index[hash]
+	return exists
+}
+
+// ReleaseChunk decrements the reference count, dropping the chunk at zero
+func (ms *MemoryChunkStore) ReleaseChunk(hash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	metadata, exists := ms.index[hash]
+	if !exists {
+		return fmt.Errorf("chunk not found: %s", hash)
+	}
+
+	metadata.RefCount--
+	if metadata.RefCount <= 0 {
+		delete(ms.chunks, hash)
+		delete(ms.index, hash)
+	}
+
+	return nil
+}
+
+// DeleteChunk drops a chunk regardless of its reference count
+func (ms *MemoryChunkStore) DeleteChunk(hash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, exists := ms.index[hash]; !exists {
+		return fmt.Errorf("chunk not found: %s", hash)
+	}
+
+	delete(ms.chunks, hash)
+	delete(ms.index, hash)
+	return nil
+}
+
+// GetStats returns deduplication statistics
+func (ms *MemoryChunkStore) GetStats() map[string]interface{} {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return indexStats(ms.index)
+}
+
+// CheckWritable always succeeds; memory is always writable
+func (ms *MemoryChunkStore) CheckWritable(ctx context.Context) error {
+	return nil
+}
+
+// Flush is a no-op; there is nothing to persist
+func (ms *MemoryChunkStore) Flush() error {
+	return nil
+}
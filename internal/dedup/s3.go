@@ -0,0 +1,300 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3RequestTimeout bounds a single S3 call
+const s3RequestTimeout = time.Minute
+
+// S3Config selects the bucket an S3ChunkStore writes to. Credentials come
+// from the standard AWS sources (environment, shared config, instance role).
+type S3Config struct {
+	Bucket   string
+	Prefix   string // Prepended to every object key, e.g. "dfs/"
+	Region   string
+	Endpoint string // Optional; for S3-compatible services such as MinIO or LocalStack
+}
+
+// S3ChunkStore stores chunks as objects in an S3 bucket, keyed by chunk
+// hash, so identical chunks are only uploaded once. Reference counts are
+// kept in an index object next to the chunks, like ChunkStore's index file.
+type S3ChunkStore struct {
+	client    *s3.Client
+	bucket    string
+	prefix    string
+	index     map[string]*ChunkMetadata // hash -> metadata
+	indexLock sync.RWMutex
+}
+
+// NewS3ChunkStore connects to the configured bucket and loads the chunk index
+func NewS3ChunkStore(cfg S3Config) (*S3ChunkStore, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	store := &S3ChunkStore{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: cfg.Prefix,
+		index:  make(map[string]*ChunkMetadata),
+	}
+
+	if err := store.loadIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// chunkKey returns the object key of a chunk
+func (ss *S3ChunkStore) chunkKey(hash string) string {
+	return ss.prefix + "chunks/" + hash
+}
+
+// indexKey returns the object key of the chunk index
+func (ss *S3ChunkStore) indexKey() string {
+	return ss.prefix + "chunk_index.json"
+}
+
+// StoreChunk uploads a chunk if it doesn't exist, or increments ref count if it does
+func (ss *S3ChunkStore) StoreChunk(hash string, data []byte) (string, bool, error) {
+	ss.indexLock.Lock()
+	defer ss.indexLock.Unlock()
+
+	if metadata, exists := ss.index[hash]; exists {
+		metadata.RefCount++
+		return metadata.StorePath, false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	key := ss.chunkKey(hash)
+	storePath := fmt.Sprintf("s3://%s/%s", ss.bucket, key)
+
+	// The object may exist without an index entry if the index wasn't
+	// flushed; the key is the content hash, so it can be reused as is
+	isNew := false
+	exists, err := ss.objectExists(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	if !exists {
+		_, err := ss.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(ss.bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(data),
+		})
+		if err != nil {
+			return "", false, fmt.Errorf("failed to upload chunk: %w", err)
+		}
+		isNew = true
+	}
+
+	ss.index[hash] = &ChunkMetadata{
+		Hash:      hash,
+		Size:      len(data),
+		RefCount:  1,
+		StorePath: storePath,
+	}
+
+	return storePath, isNew, nil
+}
+
+// GetChunk downloads a chunk by its hash
+func (ss *S3ChunkStore) GetChunk(hash string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	resp, err := ss.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.chunkKey(hash)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("chunk not found: %s", hash)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+// HasChunk reports whether a chunk is stored, checking the bucket for
+// chunks the index doesn't know about
+func (ss *S3ChunkStore) HasChunk(hash string) bool {
+	ss.indexLock.RLock()
+	_, exists := ss.index[hash]
+	ss.indexLock.RUnlock()
+	if exists {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	exists, err := ss.objectExists(ctx, ss.chunkKey(hash))
+	return err == nil && exists
+}
+
+// ReleaseChunk decrements the reference count, deleting the object at zero
+func (ss *S3ChunkStore) ReleaseChunk(hash string) error {
+	ss.indexLock.Lock()
+	defer ss.indexLock.Unlock()
+
+	metadata, exists := ss.index[hash]
+	if !exists {
+		return fmt.Errorf("chunk not found: %s", hash)
+	}
+
+	metadata.RefCount--
+	if metadata.RefCount <= 0 {
+		if err := ss.deleteObject(hash); err != nil {
+			return err
+		}
+		delete(ss.index, hash)
+	}
+
+	return nil
+}
+
+// DeleteChunk deletes a chunk's object regardless of its reference count,
+// including objects the index doesn't know about
+func (ss *S3ChunkStore) DeleteChunk(hash string) error {
+	ss.indexLock.Lock()
+	defer ss.indexLock.Unlock()
+
+	if err := ss.deleteObject(hash); err != nil {
+		return err
+	}
+	delete(ss.index, hash)
+
+	return nil
+}
+
+// deleteObject removes a chunk's object from the bucket
+func (ss *S3ChunkStore) deleteObject(hash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	_, err := ss.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.chunkKey(hash)),
+	})
+	return err
+}
+
+// GetStats returns deduplication statistics
+func (ss *S3ChunkStore) GetStats() map[string]interface{} {
+	ss.indexLock.RLock()
+	defer ss.indexLock.RUnlock()
+
+	return indexStats(ss.index)
+}
+
+// Flush uploads the current index. Call it before shutting down so no
+// index updates are lost.
+func (ss *S3ChunkStore) Flush() error {
+	ss.indexLock.RLock()
+	data, err := json.MarshalIndent(ss.index, "", "  ")
+	ss.indexLock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	_, err = ss.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(ss.bucket),
+		Key:         aws.String(ss.indexKey()),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// CheckWritable uploads and deletes a small probe object to check that
+// chunks can still be stored in the bucket
+func (ss *S3ChunkStore) CheckWritable(ctx context.Context) error {
+	key := ss.prefix + "healthcheck"
+	_, err := ss.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader([]byte("ok")),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ss.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// loadIndex downloads the chunk index, if one has been flushed before
+func (ss *S3ChunkStore) loadIndex(ctx context.Context) error {
+	resp, err := ss.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.indexKey()),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil
+		}
+		return fmt.Errorf("failed to load chunk index: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(&ss.index)
+}
+
+// objectExists reports whether an object is present in the bucket
+func (ss *S3ChunkStore) objectExists(ctx context.Context, key string) (bool, error) {
+	_, err := ss.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return true, nil
+	}
+
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return false, err
+}
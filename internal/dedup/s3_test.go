@@ -0,0 +1,119 @@
+package dedup
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 serves the path-style object calls S3ChunkStore makes from memory
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte // "bucket/key" -> data
+	puts    int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		f.puts++
+	case http.MethodGet, http.MethodHead:
+		data, exists := f.objects[key]
+		if !exists {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			}
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newFakeS3 starts a fake S3 endpoint and points the AWS SDK's credentials
+// at it for the rest of the test
+func newFakeS3(t *testing.T) (*fakeS3, S3Config) {
+	t.Helper()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", t.TempDir()+"/credentials")
+	// Plain request bodies, without aws-chunked checksum trailers
+	t.Setenv("AWS_REQUEST_CHECKSUM_CALCULATION", "when_required")
+	t.Setenv("AWS_RESPONSE_CHECKSUM_VALIDATION", "when_required")
+
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return fake, S3Config{Bucket: "chunks", Prefix: "dfs/", Region: "us-east-1", Endpoint: server.URL}
+}
+
+func TestS3ChunkStore(t *testing.T) {
+	_, cfg := newFakeS3(t)
+	store, err := NewS3ChunkStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testChunkStore(t, store)
+}
+
+// TestS3ChunkStoreDedup checks identical chunks are uploaded once under
+// their hash, and the index survives a restart once flushed
+func TestS3ChunkStoreDedup(t *testing.T) {
+	fake, cfg := newFakeS3(t)
+	store, err := NewS3ChunkStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, hash := testChunk(t)
+	for i := 0; i < 3; i++ {
+		if _, _, err := store.StoreChunk(hash, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.mu.Lock()
+	puts, object := fake.puts, fake.objects["chunks/dfs/chunks/"+hash]
+	fake.mu.Unlock()
+	if puts != 1 || !bytes.Equal(object, data) {
+		t.Fatalf("want the chunk uploaded once under its hash, got %d uploads", puts)
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewS3ChunkStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := reopened.GetStats(); stats["unique_chunks"] != 1 || stats["total_references"] != 3 {
+		t.Errorf("want the flushed index reloaded with 3 references, got %v", stats)
+	}
+	if got, err := reopened.GetChunk(hash); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("chunk does not read back after a restart: %v", err)
+	}
+}
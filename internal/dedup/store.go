@@ -1,20 +1,23 @@
 package dedup
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+
+	"github.com/noorimat/distributed-file-storage/internal/shard"
 )
 
 // ChunkStore manages deduplicated chunk storage
 // It keeps track of which chunks exist and their reference counts
 type ChunkStore struct {
-	basePath  string
-	index     map[string]*ChunkMetadata // hash -> metadata
-	indexLock sync.RWMutex
-	indexPath string
+	basePath   string
+	shardDepth int
+	index      chunkIndex
+	totals     chunkTotals
+	indexLock  sync.RWMutex
 }
 
 // ChunkMetadata tracks information about a stored chunk
@@ -25,52 +28,114 @@ type ChunkMetadata struct {
 	StorePath string `json:"store_path"` // Path where chunk is stored
 }
 
-// NewChunkStore creates a new deduplicated chunk store
-func NewChunkStore(basePath string) (*ChunkStore, error) {
+// NewChunkStore creates a new deduplicated chunk store. Chunks are sharded
+// into shardDepth levels of directories; chunks stored under another depth
+// are moved into the new layout. The chunk index is kept by indexBackend,
+// IndexJSON or IndexBolt.
+func NewChunkStore(basePath string, shardDepth int, indexBackend string) (*ChunkStore, error) {
+	if err := shard.ValidateDepth(shardDepth); err != nil {
+		return nil, err
+	}
+
 	// Create chunks directory
 	chunksPath := filepath.Join(basePath, "chunks")
 	if err := os.MkdirAll(chunksPath, 0755); err != nil {
 		return nil, err
 	}
 
-	indexPath := filepath.Join(basePath, "chunk_index.json")
+	// Load existing index
+	index, err := openIndex(basePath, indexBackend)
+	if err != nil {
+		return nil, err
+	}
 
 	store := &ChunkStore{
-		basePath:  chunksPath,
-		index:     make(map[string]*ChunkMetadata),
-		indexPath: indexPath,
+		basePath:   chunksPath,
+		shardDepth: shardDepth,
+		index:      index,
 	}
 
-	// Load existing index
-	if err := store.loadIndex(); err != nil && !os.IsNotExist(err) {
-		return nil, err
+	if err := store.migrateLayout(); err != nil {
+		return nil, fmt.Errorf("failed to migrate chunk layout: %w", err)
+	}
+
+	// Statistics are kept up to date as chunks change rather than
+	// recomputed over the whole index on every request
+	err = index.each(func(metadata *ChunkMetadata) error {
+		store.totals.add(metadata, 1)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk index: %w", err)
 	}
 
 	return store, nil
 }
 
+// migrateLayout moves chunks stored under a different shard depth to where
+// the current depth puts them, and points the index at the new paths
+func (cs *ChunkStore) migrateLayout() error {
+	moved, err := shard.Migrate(cs.basePath, cs.shardDepth, func(name string) bool {
+		_, indexed, _ := cs.index.get(name)
+		return indexed
+	})
+	if moved == 0 {
+		return err
+	}
+
+	// Record whatever moved, even if the migration stopped partway
+	var updated []*ChunkMetadata
+	walkErr := cs.index.each(func(metadata *ChunkMetadata) error {
+		path := shard.Path(cs.basePath, metadata.Hash, cs.shardDepth)
+		if metadata.StorePath == path {
+			return nil
+		}
+		if _, statErr := os.Stat(path); statErr == nil {
+			metadata.StorePath = path
+			updated = append(updated, metadata)
+		}
+		return nil
+	})
+	if walkErr != nil && err == nil {
+		err = walkErr
+	}
+	for _, metadata := range updated {
+		if putErr := cs.index.put(metadata); putErr != nil && err == nil {
+			err = putErr
+		}
+	}
+
+	return err
+}
+
 // StoreChunk stores a chunk if it doesn't exist, or increments ref count if it does
 // Returns: (chunkPath, isNewChunk, error)
 func (cs *ChunkStore) StoreChunk(hash string, data []byte) (string, bool, error) {
 	cs.indexLock.Lock()
 	defer cs.indexLock.Unlock()
 
+	metadata, exists, err := cs.index.get(hash)
+	if err != nil {
+		return "", false, err
+	}
+
 	// Check if chunk already exists (deduplication!)
-	if metadata, exists := cs.index[hash]; exists {
+	if exists {
 		// Chunk already exists - just increment reference count
 		metadata.RefCount++
-		cs.saveIndex()
+		if err := cs.index.put(metadata); err != nil {
+			return "", false, err
+		}
+		cs.totals.refs++
 		return metadata.StorePath, false, nil
 	}
 
 	// New chunk - store it
-	// Use first 2 chars of hash for directory sharding (prevents too many files in one dir)
-	shardDir := filepath.Join(cs.basePath, hash[:2])
-	if err := os.MkdirAll(shardDir, 0755); err != nil {
+	// Shard into directories by hash prefix (prevents too many files in one dir)
+	chunkPath := shard.Path(cs.basePath, hash, cs.shardDepth)
+	if err := os.MkdirAll(filepath.Dir(chunkPath), 0755); err != nil {
 		return "", false, err
 	}
-
-	chunkPath := filepath.Join(shardDir, hash)
 	
 	// Write chunk to disk
 	if err := os.WriteFile(chunkPath, data, 0644); err != nil {
@@ -78,23 +143,31 @@ func (cs *ChunkStore) StoreChunk(hash string, data []byte) (string, bool, error)
 	}
 
 	// Add to index
-	cs.index[hash] = &ChunkMetadata{
+	metadata = &ChunkMetadata{
 		Hash:      hash,
 		Size:      len(data),
 		RefCount:  1,
 		StorePath: chunkPath,
 	}
+	if err := cs.index.put(metadata); err != nil {
+		// Not indexed, so nothing would ever delete it
+		os.Remove(chunkPath)
+		return "", false, err
+	}
+	cs.totals.add(metadata, 1)
 
-	cs.saveIndex()
 	return chunkPath, true, nil
 }
 
 // GetChunk retrieves a chunk by its hash
 func (cs *ChunkStore) GetChunk(hash string) ([]byte, error) {
 	cs.indexLock.RLock()
-	metadata, exists := cs.index[hash]
+	metadata, exists, err := cs.index.get(hash)
 	cs.indexLock.RUnlock()
 
+	if err != nil {
+		return nil, err
+	}
 	if !exists {
 		return nil, fmt.Errorf("chunk not found: %s", hash)
 	}
@@ -107,28 +180,68 @@ func (cs *ChunkStore) GetChunk(hash string) ([]byte, error) {
 	return data, nil
 }
 
+// HasChunk reports whether a chunk is stored
+func (cs *ChunkStore) HasChunk(hash string) bool {
+	cs.indexLock.RLock()
+	defer cs.indexLock.RUnlock()
+
+	_, exists, err := cs.index.get(hash)
+	return err == nil && exists
+}
+
 // ReleaseChunk decrements the reference count for a chunk
 // If ref count reaches 0, the chunk is deleted (garbage collection)
 func (cs *ChunkStore) ReleaseChunk(hash string) error {
 	cs.indexLock.Lock()
 	defer cs.indexLock.Unlock()
 
-	metadata, exists := cs.index[hash]
+	metadata, exists, err := cs.index.get(hash)
+	if err != nil {
+		return err
+	}
 	if !exists {
 		return fmt.Errorf("chunk not found: %s", hash)
 	}
 
+	// If no more references, delete the chunk
+	if metadata.RefCount <= 1 {
+		return cs.removeChunk(metadata)
+	}
+
 	metadata.RefCount--
+	if err := cs.index.put(metadata); err != nil {
+		return err
+	}
+	cs.totals.refs--
+	return nil
+}
 
-	// If no more references, delete the chunk
-	if metadata.RefCount <= 0 {
-		if err := os.Remove(metadata.StorePath); err != nil {
-			return err
-		}
-		delete(cs.index, hash)
+// DeleteChunk removes a chunk regardless of its reference count. It is
+// used when the metadata store reports that nothing references it anymore.
+func (cs *ChunkStore) DeleteChunk(hash string) error {
+	cs.indexLock.Lock()
+	defer cs.indexLock.Unlock()
+
+	metadata, exists, err := cs.index.get(hash)
+	if err != nil {
+		return err
 	}
+	if !exists {
+		return fmt.Errorf("chunk not found: %s", hash)
+	}
+
+	return cs.removeChunk(metadata)
+}
 
-	cs.saveIndex()
+// removeChunk deletes a chunk's file and its index entry
+func (cs *ChunkStore) removeChunk(metadata *ChunkMetadata) error {
+	if err := os.Remove(metadata.StorePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := cs.index.delete(metadata.Hash); err != nil {
+		return err
+	}
+	cs.totals.add(metadata, -1)
 	return nil
 }
 
@@ -137,48 +250,44 @@ func (cs *ChunkStore) GetStats() map[string]interface{} {
 	cs.indexLock.RLock()
 	defer cs.indexLock.RUnlock()
 
-	totalChunks := len(cs.index)
-	totalSize := int64(0)
-	totalRefs := 0
+	return cs.totals.stats()
+}
 
-	for _, metadata := range cs.index {
-		totalSize += int64(metadata.Size)
-		totalRefs += metadata.RefCount
+// indexStats computes deduplication statistics over a chunk index
+func indexStats(index map[string]*ChunkMetadata) map[string]interface{} {
+	var totals chunkTotals
+	for _, metadata := range index {
+		totals.add(metadata, 1)
 	}
+	return totals.stats()
+}
 
-	// Calculate space savings
-	var savedSpace int64
-	if totalRefs > 0 {
-		savedSpace = totalSize * int64(totalRefs-totalChunks)
-	}
+// Flush writes the current index to disk. Call it before shutting down so
+// no index updates are lost.
+func (cs *ChunkStore) Flush() error {
+	cs.indexLock.RLock()
+	defer cs.indexLock.RUnlock()
 
-	return map[string]interface{}{
-		"unique_chunks":    totalChunks,
-		"total_references": totalRefs,
-		"storage_used":     totalSize,
-		"space_saved":      savedSpace,
-		"dedup_ratio":      float64(totalRefs) / float64(max(totalChunks, 1)),
-	}
+	return cs.index.flush()
 }
 
-// loadIndex loads the chunk index from disk
-func (cs *ChunkStore) loadIndex() error {
-	data, err := os.ReadFile(cs.indexPath)
+// CheckWritable writes and deletes a temp file in the chunks directory to
+// check that chunks can still be stored
+func (cs *ChunkStore) CheckWritable(ctx context.Context) error {
+	f, err := os.CreateTemp(cs.basePath, ".healthcheck-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 
-	return json.Unmarshal(data, &cs.index)
-}
-
-// saveIndex saves the chunk index to disk
-func (cs *ChunkStore) saveIndex() error {
-	data, err := json.MarshalIndent(cs.index, "", "  ")
+	_, err = f.Write([]byte("ok"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
-
-	return os.WriteFile(cs.indexPath, data, 0644)
+	return os.Remove(f.Name())
 }
 
 func max(a, b int) int {
@@ -0,0 +1,232 @@
+package dedup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/shard"
+)
+
+// chunkStorer is what the coordinator needs of a chunk store; ChunkStore
+// and MemoryChunkStore must behave the same behind it
+type chunkStorer interface {
+	StoreChunk(hash string, data []byte) (string, bool, error)
+	GetChunk(hash string) ([]byte, error)
+	HasChunk(hash string) bool
+	ReleaseChunk(hash string) error
+	DeleteChunk(hash string) error
+	GetStats() map[string]interface{}
+	Flush() error
+}
+
+func testChunk(t *testing.T) ([]byte, string) {
+	t.Helper()
+
+	data := make([]byte, 1000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:])
+}
+
+// testChunkStore checks a store dedups identical chunks, counts their
+// references, and drops a chunk with its last reference or when deleted
+func testChunkStore(t *testing.T, store chunkStorer) {
+	data, hash := testChunk(t)
+
+	path, isNew, err := store.StoreChunk(hash, data)
+	if err != nil || !isNew {
+		t.Fatalf("want a new chunk stored, got new=%v err=%v", isNew, err)
+	}
+	again, isNew, err := store.StoreChunk(hash, data)
+	if err != nil || isNew {
+		t.Fatalf("want the second store deduplicated, got new=%v err=%v", isNew, err)
+	}
+	if again != path {
+		t.Errorf("want both stores at %s, got %s", path, again)
+	}
+
+	if got, err := store.GetChunk(hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("chunk does not read back: %v", err)
+	}
+	stats := store.GetStats()
+	if stats["unique_chunks"] != 1 || stats["total_references"] != 2 {
+		t.Errorf("want 1 chunk with 2 references, got %v", stats)
+	}
+
+	// The first release leaves the other reference
+	if err := store.ReleaseChunk(hash); err != nil {
+		t.Fatal(err)
+	}
+	if !store.HasChunk(hash) {
+		t.Fatal("chunk dropped while still referenced")
+	}
+	if err := store.ReleaseChunk(hash); err != nil {
+		t.Fatal(err)
+	}
+	if store.HasChunk(hash) {
+		t.Error("chunk kept after its last reference was released")
+	}
+	if _, err := store.GetChunk(hash); err == nil {
+		t.Error("want a released chunk unreadable")
+	}
+	if err := store.ReleaseChunk(hash); err == nil {
+		t.Error("want releasing a missing chunk to fail")
+	}
+
+	// Deleting ignores references
+	other, otherHash := testChunk(t)
+	for i := 0; i < 3; i++ {
+		if _, _, err := store.StoreChunk(otherHash, other); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.DeleteChunk(otherHash); err != nil {
+		t.Fatal(err)
+	}
+	if store.HasChunk(otherHash) {
+		t.Error("chunk kept after it was deleted")
+	}
+	if err := store.DeleteChunk(otherHash); err == nil {
+		t.Error("want deleting a missing chunk to fail")
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryChunkStore(t *testing.T) {
+	testChunkStore(t, NewMemoryChunkStore())
+}
+
+func TestChunkStore(t *testing.T) {
+	for _, backend := range []string{IndexJSON, IndexBolt} {
+		t.Run(backend, func(t *testing.T) {
+			store, err := NewChunkStore(t.TempDir(), 2, backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testChunkStore(t, store)
+		})
+	}
+}
+
+// TestMemoryChunkStoreCopies checks callers can't change stored chunks
+// through the slices they pass in or get back
+func TestMemoryChunkStoreCopies(t *testing.T) {
+	store := NewMemoryChunkStore()
+	data, hash := testChunk(t)
+	want := append([]byte(nil), data...)
+
+	if _, _, err := store.StoreChunk(hash, data); err != nil {
+		t.Fatal(err)
+	}
+	data[0]++
+	got, err := store.GetChunk(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got[1]++
+
+	if got, _ := store.GetChunk(hash); !bytes.Equal(got, want) {
+		t.Error("stored chunk changed through a caller's slice")
+	}
+}
+
+// checkShardLayout checks each chunk is stored at the given shard depth and
+// reads back through the store
+func checkShardLayout(t *testing.T, store *ChunkStore, dir string, depth int, chunks map[string][]byte) {
+	t.Helper()
+
+	for hash, data := range chunks {
+		path := shard.Path(filepath.Join(dir, "chunks"), hash, depth)
+		if stored, err := os.ReadFile(path); err != nil || !bytes.Equal(stored, data) {
+			t.Errorf("depth %d: chunk %s not at %s: %v", depth, hash[:8], path, err)
+		}
+		if got, err := store.GetChunk(hash); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("depth %d: chunk %s does not read back: %v", depth, hash[:8], err)
+		}
+	}
+}
+
+// storeTestChunks stores n random chunks and returns them by hash
+func storeTestChunks(t *testing.T, store *ChunkStore, n int) map[string][]byte {
+	t.Helper()
+
+	chunks := make(map[string][]byte)
+	for i := 0; i < n; i++ {
+		data, hash := testChunk(t)
+		chunks[hash] = data
+		if _, _, err := store.StoreChunk(hash, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return chunks
+}
+
+// TestChunkStoreShardDepth stores chunks at shard depths 1 and 2 and checks
+// they are laid out and read back at that depth
+func TestChunkStoreShardDepth(t *testing.T) {
+	for _, backend := range []string{IndexJSON, IndexBolt} {
+		for _, depth := range []int{1, 2} {
+			t.Run(fmt.Sprintf("%s/%d", backend, depth), func(t *testing.T) {
+				dir := t.TempDir()
+				store, err := NewChunkStore(dir, depth, backend)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkShardLayout(t, store, dir, depth, storeTestChunks(t, store, 10))
+			})
+		}
+	}
+}
+
+// TestChunkStoreShardMigration reopens a store at another shard depth and
+// checks its chunks were moved, still read back, keep their references and
+// can be deleted
+func TestChunkStoreShardMigration(t *testing.T) {
+	for _, depths := range [][2]int{{1, 2}, {2, 1}} {
+		t.Run(fmt.Sprintf("%d to %d", depths[0], depths[1]), func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewChunkStore(dir, depths[0], IndexJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks := storeTestChunks(t, store, 10)
+			checkShardLayout(t, store, dir, depths[0], chunks)
+
+			store, err = NewChunkStore(dir, depths[1], IndexJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkShardLayout(t, store, dir, depths[1], chunks)
+
+			for hash, data := range chunks {
+				if _, isNew, err := store.StoreChunk(hash, data); err != nil || isNew {
+					t.Fatalf("want chunk %s deduplicated after the move, got new=%v err=%v", hash[:8], isNew, err)
+				}
+				if err := store.DeleteChunk(hash); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(shard.Path(filepath.Join(dir, "chunks"), hash, depths[1])); !os.IsNotExist(err) {
+					t.Errorf("deleted chunk %s left on disk", hash[:8])
+				}
+			}
+		})
+	}
+}
+
+func TestChunkStoreInvalidShardDepth(t *testing.T) {
+	for _, depth := range []int{0, shard.MaxDepth + 1} {
+		if _, err := NewChunkStore(t.TempDir(), depth, IndexJSON); err == nil {
+			t.Errorf("depth %d: want an error", depth)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package metadata
+
+import "time"
+
+// Actions recorded in the access log
+const (
+	AccessUpload   = "upload"
+	AccessDownload = "download"
+	AccessDelete   = "delete"
+)
+
+// AccessRecord is one entry of the access log: who did what to a file, and
+// how many bytes of it moved
+type AccessRecord struct {
+	ID         int64     `json:"id"`
+	FileID     string    `json:"file_id"`
+	Client     string    `json:"client,omitempty"` // API client name; empty when auth is off or for share links
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Action     string    `json:"action"`
+	Bytes      int64     `json:"bytes"`
+	At         time.Time `json:"at"`
+}
+
+// RecordAccess appends an entry to the access log. Its ID and At are
+// written back to record.
+func (d *Database) RecordAccess(record *AccessRecord) error {
+	return d.db.QueryRow(`
+		INSERT INTO access_log (file_id, client, remote_addr, action, bytes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, occurred_at
+	`, record.FileID, record.Client, record.RemoteAddr, record.Action, record.Bytes).Scan(&record.ID, &record.At)
+}
+
+// ListAccess returns up to limit access log entries, newest first. An empty
+// fileID lists every file's; a positive before only lists entries older
+// than the one with that ID, to page through the log.
+func (d *Database) ListAccess(fileID string, before int64, limit int) ([]AccessRecord, error) {
+	rows, err := d.db.Query(`
+		SELECT id, file_id, client, remote_addr, action, bytes, occurred_at
+		FROM access_log
+		WHERE ($1 = '' OR file_id = NULLIF($1, '')::uuid) AND ($2 <= 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`, fileID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []AccessRecord{}
+	for rows.Next() {
+		var record AccessRecord
+		if err := rows.Scan(&record.ID, &record.FileID, &record.Client, &record.RemoteAddr,
+			&record.Action, &record.Bytes, &record.At); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,70 @@
+package metadata
+
+import "testing"
+
+// accessStore is the part of a metadata store that keeps the access log
+type accessStore interface {
+	RecordAccess(record *AccessRecord) error
+	ListAccess(fileID string, before int64, limit int) ([]AccessRecord, error)
+}
+
+// testAccessLog records entries for two files and checks they list newest
+// first, for one file or all, a page at a time
+func testAccessLog(t *testing.T, store accessStore) {
+	const (
+		report = "10000000-0000-0000-0000-000000000001"
+		photo  = "10000000-0000-0000-0000-000000000002"
+	)
+	recorded := []AccessRecord{
+		{FileID: report, Client: "alice", RemoteAddr: "192.0.2.1", Action: AccessUpload, Bytes: 1000},
+		{FileID: photo, Client: "bob", RemoteAddr: "192.0.2.2", Action: AccessUpload, Bytes: 5000},
+		{FileID: report, Action: AccessDownload, Bytes: 100},
+		{FileID: report, Client: "alice", RemoteAddr: "192.0.2.1", Action: AccessDelete},
+	}
+	for i := range recorded {
+		if err := store.RecordAccess(&recorded[i]); err != nil {
+			t.Fatal(err)
+		}
+		if recorded[i].ID == 0 || recorded[i].At.IsZero() {
+			t.Fatalf("entry %d: want its ID and time written back, got %+v", i, recorded[i])
+		}
+		if i > 0 && recorded[i].ID <= recorded[i-1].ID {
+			t.Fatalf("entry %d: want IDs increasing, got %d after %d", i, recorded[i].ID, recorded[i-1].ID)
+		}
+	}
+
+	list := func(fileID string, before int64, limit int, want ...int) {
+		t.Helper()
+		got, err := store.ListAccess(fileID, before, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("want entries %v, got %+v", want, got)
+		}
+		for i, index := range want {
+			wantRecord := recorded[index]
+			if got[i].ID != wantRecord.ID || got[i].FileID != wantRecord.FileID || got[i].Client != wantRecord.Client ||
+				got[i].RemoteAddr != wantRecord.RemoteAddr || got[i].Action != wantRecord.Action || got[i].Bytes != wantRecord.Bytes {
+				t.Errorf("position %d: want %+v, got %+v", i, wantRecord, got[i])
+			}
+		}
+	}
+
+	list("", 0, 10, 3, 2, 1, 0)
+	list(report, 0, 10, 3, 2, 0)
+	list(photo, 0, 10, 1)
+	list("", 0, 2, 3, 2)
+	list("", recorded[2].ID, 2, 1, 0)
+	list(report, recorded[2].ID, 2, 0)
+	list(report, recorded[0].ID, 2)
+	list("10000000-0000-0000-0000-000000000003", 0, 10)
+}
+
+func TestAccessLogMemory(t *testing.T) {
+	testAccessLog(t, NewMemoryStore())
+}
+
+func TestAccessLogPostgres(t *testing.T) {
+	testAccessLog(t, testDatabase(t, true))
+}
@@ -0,0 +1,45 @@
+package metadata
+
+import "github.com/lib/pq"
+
+// ChunkAudit is a chunk's metadata next to the number of file links that
+// point at it, for cross-checking against what the nodes hold
+type ChunkAudit struct {
+	ChunkHash   string
+	StoragePath string
+	Replication int
+	RefCount    int
+	Links       int      // file_chunks rows for the chunk, trashed files included
+	DataShards  int      // Shards needed to rebuild the chunk; 0 unless erasure-coded
+	ShardHashes []string // Erasure-coded shards, which the nodes store like chunks
+}
+
+// AuditChunks returns every chunk with its reference count and link count,
+// in hash order
+func (d *Database) AuditChunks() ([]ChunkAudit, error) {
+	rows, err := d.db.Query(`
+		SELECT c.chunk_hash, c.storage_path, c.replication, c.ref_count, c.data_shards,
+			(SELECT COUNT(*) FROM file_chunks fc WHERE fc.chunk_hash = c.chunk_hash),
+			ARRAY(SELECT s.shard_hash FROM chunk_shards s WHERE s.chunk_hash = c.chunk_hash)
+		FROM chunks c
+		ORDER BY c.chunk_hash
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var audits []ChunkAudit
+	for rows.Next() {
+		var audit ChunkAudit
+		var shards pq.StringArray
+		if err := rows.Scan(&audit.ChunkHash, &audit.StoragePath, &audit.Replication, &audit.RefCount,
+			&audit.DataShards, &audit.Links, &shards); err != nil {
+			return nil, err
+		}
+		audit.ShardHashes = shards
+		audits = append(audits, audit)
+	}
+
+	return audits, rows.Err()
+}
@@ -0,0 +1,127 @@
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cascadeStore is the part of a metadata store that links files to chunks
+// and deletes files for good
+type cascadeStore interface {
+	refCounter
+	CreateFile(file *FileRecord) error
+	LinkFileChunk(fileID, chunkHash string, chunkOrder int, startOffset int64) error
+	PurgeFile(fileID string) ([]ChunkRecord, error)
+}
+
+// Files linkedFiles stores
+const (
+	firstFile  = "f0000000-0000-0000-0000-000000000001"
+	secondFile = "f0000000-0000-0000-0000-000000000002"
+)
+
+// linkedFiles stores two files, each referencing the chunks it links:
+// firstFile links chunks 0, 1 and 1 again, secondFile links chunks 0 and 2.
+// It returns the chunk hashes.
+func linkedFiles(t *testing.T, store cascadeStore) []string {
+	t.Helper()
+
+	hashes := make([]string, 3)
+	for i := range hashes {
+		hashes[i] = strings.Repeat(fmt.Sprintf("%x", i+7), 64)
+	}
+	for fileID, links := range map[string][]int{firstFile: {0, 1, 1}, secondFile: {0, 2}} {
+		if err := store.CreateFile(&FileRecord{FileID: fileID, FileName: fileID + ".bin", FileSize: int64(100 * len(links))}); err != nil {
+			t.Fatal(err)
+		}
+		for order, i := range links {
+			if _, err := store.CreateChunk(hashes[i], 100, "local", 1, "sha256"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.LinkFileChunk(fileID, hashes[i], order, int64(order*100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return hashes
+}
+
+// testPurgeReleasesChunks purges a file and checks every link it held
+// released a reference, and only chunks no other file links were removed
+func testPurgeReleasesChunks(t *testing.T, store cascadeStore) {
+	hashes := linkedFiles(t, store)
+	wantRefCount(t, store, hashes[0], 2)
+	wantRefCount(t, store, hashes[1], 2)
+
+	orphaned, err := store.PurgeFile(firstFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphaned) != 1 || orphaned[0].ChunkHash != hashes[1] {
+		t.Errorf("want only chunk 1 removed, got %v", orphaned)
+	}
+	wantRefCount(t, store, hashes[0], 1)
+	wantRefCount(t, store, hashes[2], 1)
+	if _, err := store.GetChunk(hashes[1]); err == nil {
+		t.Error("want chunk 1 gone once its only file was purged")
+	}
+
+	if orphaned, err := store.PurgeFile(secondFile); err != nil || len(orphaned) != 2 {
+		t.Errorf("want the last two chunks removed, got %v (%v)", orphaned, err)
+	}
+}
+
+func TestPurgeReleasesChunksMemory(t *testing.T) {
+	testPurgeReleasesChunks(t, NewMemoryStore())
+}
+
+func TestPurgeReleasesChunksPostgres(t *testing.T) {
+	testPurgeReleasesChunks(t, testDatabase(t, true))
+}
+
+// TestFileDeleteCascadesPostgres deletes a file row and chunk links directly,
+// as application code no longer has to, and checks the links go with the
+// file and each one removed releases its chunk reference
+func TestFileDeleteCascadesPostgres(t *testing.T) {
+	db := testDatabase(t, true)
+	hashes := linkedFiles(t, db)
+
+	links := func(fileID string) int {
+		t.Helper()
+		var n int
+		if err := db.db.QueryRow(`SELECT COUNT(*) FROM file_chunks WHERE file_id = $1`, fileID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if _, err := db.db.Exec(`DELETE FROM files WHERE file_id = $1`, firstFile); err != nil {
+		t.Fatal(err)
+	}
+	if n := links(firstFile); n != 0 {
+		t.Errorf("want the deleted file's links gone, %d left", n)
+	}
+	wantRefCount(t, db, hashes[0], 1)
+	wantRefCount(t, db, hashes[1], 0)
+
+	if _, err := db.db.Exec(`DELETE FROM file_chunks WHERE file_id = $1 AND chunk_hash = $2`, secondFile, hashes[2]); err != nil {
+		t.Fatal(err)
+	}
+	if n := links(secondFile); n != 1 {
+		t.Errorf("want 1 link left, got %d", n)
+	}
+	wantRefCount(t, db, hashes[0], 1)
+	wantRefCount(t, db, hashes[2], 0)
+
+	if err := db.LinkFileChunk("f0000000-0000-0000-0000-00000000000f", hashes[0], 0, 0); err == nil {
+		t.Error("linking a chunk to a file that doesn't exist: want an error")
+	}
+	if err := db.LinkFileChunk(secondFile, strings.Repeat("f", 64), 1, 100); err == nil {
+		t.Error("linking a chunk that doesn't exist: want an error")
+	}
+	if got, err := db.GetFileChunks(secondFile); err != nil || !reflect.DeepEqual(got, hashes[:1]) {
+		t.Errorf("want only chunk 0 still linked, got %v (%v)", got, err)
+	}
+}
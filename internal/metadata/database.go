@@ -1,13 +1,20 @@
 package metadata
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
+var (
+	ErrFileNotFound  = errors.New("file not found")
+	ErrChunkNotFound = errors.New("chunk not found")
+)
+
 // Database handles all database operations
 type Database struct {
 	db *sql.DB
@@ -15,12 +22,46 @@ type Database struct {
 
 // FileRecord represents a file in the database
 type FileRecord struct {
-	FileID     string    `json:"file_id"`
-	FileName   string    `json:"file_name"`
-	FileSize   int64     `json:"file_size"`
-	Encrypted  bool      `json:"encrypted"`
-	Salt       string    `json:"salt,omitempty"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	FileID       string     `json:"file_id"`
+	FileName     string     `json:"file_name"`
+	Version      int        `json:"version"` // Increments for each upload with the same name
+	FileSize     int64      `json:"file_size"`
+	Encrypted    bool       `json:"encrypted"`
+	ContentType  string     `json:"content_type,omitempty"`
+	Replication  int        `json:"replication"`
+	FileHash     string     `json:"file_hash,omitempty"` // SHA-256 of the contents; empty for encrypted files
+	Salt         string     `json:"salt,omitempty"`
+	NoncePrefix  string     `json:"nonce_prefix,omitempty"` // Counter nonce prefix; empty for random nonces
+	PasswordHash string     `json:"-"`                      // Lets downloads reject a wrong password before streaming
+	UploadedAt   time.Time  `json:"uploaded_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"` // Set while the file is in the trash
+
+	// ExpiresAt is when the file stops being served; the reaper purges it
+	// after that. Nil for files that never expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// EncryptionAlgorithm is the AEAD an encrypted file's chunks are sealed
+	// with. Empty means AES-256-GCM, which every file encrypted before it
+	// was recorded used.
+	EncryptionAlgorithm string `json:"encryption_algorithm,omitempty"`
+
+	// DedupSalt is the hex salt prefixed to every chunk of a file uploaded
+	// with deduplication disabled, so none of its chunks are shared with
+	// other files. Downloads strip it; empty for deduplicated files.
+	DedupSalt string `json:"dedup_salt,omitempty"`
+
+	// Set for files the client encrypted itself; the server stores the
+	// ciphertext as-is and cannot decrypt it
+	ClientEncrypted  bool   `json:"client_encrypted,omitempty"`
+	ClientEncryption string `json:"client_encryption,omitempty"` // Opaque client parameters (algorithm, salt)
+
+	// Set for files stored whole as one chunk because they were below the
+	// inline threshold
+	SingleChunk bool `json:"single_chunk,omitempty"`
+
+	// Free-form key/value tags. CreateFile stores them; only file listings
+	// and tag searches fill them in.
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 // ChunkRecord represents a chunk in the database
@@ -29,10 +70,42 @@ type ChunkRecord struct {
 	ChunkSize   int    `json:"chunk_size"`
 	RefCount    int    `json:"ref_count"`
 	StoragePath string `json:"storage_path"`
+	Replication int    `json:"replication"` // Highest replication of any file referencing the chunk
+
+	// HashAlgorithm is the function ChunkHash was computed with. Empty
+	// means sha256, which every chunk stored before it was recorded used.
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+
+	// ShardHashes is only set by PurgeFile for erasure-coded chunks: the
+	// shards that can now be deleted from the storage nodes
+	ShardHashes []string `json:"shard_hashes,omitempty"`
+}
+
+// defaultHashAlgorithm is recorded for chunks stored without an algorithm
+const defaultHashAlgorithm = "sha256"
+
+// Algorithm returns the function the chunk's hash was computed with
+func (c *ChunkRecord) Algorithm() string {
+	return hashAlgorithmOrDefault(c.HashAlgorithm)
+}
+
+func hashAlgorithmOrDefault(algorithm string) string {
+	if algorithm == "" {
+		return defaultHashAlgorithm
+	}
+	return algorithm
+}
+
+// FileChunk locates one chunk within a file's plaintext byte stream
+type FileChunk struct {
+	ChunkHash string `json:"chunk_hash"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
 }
 
 // NewDatabase creates a new database connection
-func NewDatabase(connectionString string) (*Database, error) {
+// If migrate is true, pending schema migrations are applied before returning
+func NewDatabase(connectionString string, migrate bool) (*Database, error) {
 	db, err := sql.Open("postgres", connectionString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -46,110 +119,209 @@ func NewDatabase(connectionString string) (*Database, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	return &Database{db: db}, nil
+	database := &Database{db: db}
+
+	if migrate {
+		if err := database.Migrate(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	return database, nil
 }
 
 func (d *Database) Close() error {
 	return d.db.Close()
 }
 
-func (d *Database) CreateFile(fileID, fileName string, fileSize int64, encrypted bool, salt string) error {
-	query := `
-		INSERT INTO files (file_id, file_name, file_size, encrypted, salt)
-		VALUES ($1, $2, $3, $4, $5)
-	`
-	_, err := d.db.Exec(query, fileID, fileName, fileSize, encrypted, sql.NullString{String: salt, Valid: salt != ""})
-	return err
+// Ping checks that the database is reachable
+func (d *Database) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
 }
 
-func (d *Database) GetFile(fileID string) (*FileRecord, error) {
+// CreateFile inserts a file as the next version of its name. The assigned
+// Version and UploadedAt are written back to file.
+func (d *Database) CreateFile(file *FileRecord) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize uploads of the same name so they get distinct versions
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, file.FileName); err != nil {
+		return err
+	}
+
 	query := `
-		SELECT file_id, file_name, file_size, encrypted, COALESCE(salt, ''), uploaded_at
+		INSERT INTO files (file_id, file_name, file_size, encrypted, salt, password_hash, content_type, nonce_prefix,
+			client_encrypted, client_encryption, replication, file_hash, single_chunk, encryption_algorithm, dedup_salt, expires_at, version)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, COALESCE(MAX(version), 0) + 1
 		FROM files
-		WHERE file_id = $1
+		WHERE file_name = $2
+		RETURNING version, uploaded_at
 	`
-	
+	err = tx.QueryRow(query, file.FileID, file.FileName, file.FileSize, file.Encrypted,
+		sql.NullString{String: file.Salt, Valid: file.Salt != ""},
+		sql.NullString{String: file.PasswordHash, Valid: file.PasswordHash != ""},
+		sql.NullString{String: file.ContentType, Valid: file.ContentType != ""},
+		sql.NullString{String: file.NoncePrefix, Valid: file.NoncePrefix != ""},
+		file.ClientEncrypted,
+		sql.NullString{String: file.ClientEncryption, Valid: file.ClientEncryption != ""},
+		file.Replication,
+		sql.NullString{String: file.FileHash, Valid: file.FileHash != ""},
+		file.SingleChunk,
+		sql.NullString{String: file.EncryptionAlgorithm, Valid: file.EncryptionAlgorithm != ""},
+		sql.NullString{String: file.DedupSalt, Valid: file.DedupSalt != ""},
+		file.ExpiresAt,
+	).Scan(&file.Version, &file.UploadedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := insertFileTags(tx, file.FileID, file.Tags); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// fileColumns is the column list expected by scanFile
+const fileColumns = `file_id, file_name, version, file_size, encrypted, COALESCE(salt, ''),
+	COALESCE(password_hash, ''), COALESCE(content_type, ''),
+	COALESCE(nonce_prefix, ''), client_encrypted, COALESCE(client_encryption, ''), replication,
+	COALESCE(file_hash, ''), single_chunk, COALESCE(encryption_algorithm, ''), COALESCE(dedup_salt, ''),
+	uploaded_at, deleted_at, expires_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanFile reads a FileRecord selected with fileColumns
+func scanFile(row rowScanner) (*FileRecord, error) {
 	var file FileRecord
-	err := d.db.QueryRow(query, fileID).Scan(
+	var deletedAt, expiresAt sql.NullTime
+
+	err := row.Scan(
 		&file.FileID,
 		&file.FileName,
+		&file.Version,
 		&file.FileSize,
 		&file.Encrypted,
 		&file.Salt,
+		&file.PasswordHash,
+		&file.ContentType,
+		&file.NoncePrefix,
+		&file.ClientEncrypted,
+		&file.ClientEncryption,
+		&file.Replication,
+		&file.FileHash,
+		&file.SingleChunk,
+		&file.EncryptionAlgorithm,
+		&file.DedupSalt,
 		&file.UploadedAt,
+		&deletedAt,
+		&expiresAt,
 	)
-	
+	if err != nil {
+		return nil, err
+	}
+
+	if deletedAt.Valid {
+		file.DeletedAt = &deletedAt.Time
+	}
+	if expiresAt.Valid {
+		file.ExpiresAt = &expiresAt.Time
+	}
+
+	return &file, nil
+}
+
+// GetFile returns a file that has not been soft-deleted. Expired files are
+// still returned, so callers can tell them apart from missing ones.
+func (d *Database) GetFile(fileID string) (*FileRecord, error) {
+	query := `SELECT ` + fileColumns + `
+		FROM files
+		WHERE file_id = $1 AND deleted_at IS NULL
+	`
+
+	file, err := scanFile(d.db.QueryRow(query, fileID))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("file not found")
+		return nil, ErrFileNotFound
 	}
 	if err != nil {
 		return nil, err
 	}
-	
-	return &file, nil
+
+	return file, nil
 }
 
+// ListFiles returns all files that are neither in the trash nor expired,
+// newest first, with their tags
 func (d *Database) ListFiles() ([]FileRecord, error) {
-	query := `
-		SELECT file_id, file_name, file_size, encrypted, COALESCE(salt, ''), uploaded_at
+	files, err := d.queryFiles(`SELECT ` + fileColumns + `
 		FROM files
+		WHERE deleted_at IS NULL AND ` + notExpired + `
 		ORDER BY uploaded_at DESC
-	`
-	
-	rows, err := d.db.Query(query)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return files, d.attachTags(files)
+}
+
+// queryFiles runs a query selecting fileColumns and collects the results
+func (d *Database) queryFiles(query string, args ...interface{}) ([]FileRecord, error) {
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	
+
 	var files []FileRecord
 	for rows.Next() {
-		var file FileRecord
-		err := rows.Scan(
-			&file.FileID,
-			&file.FileName,
-			&file.FileSize,
-			&file.Encrypted,
-			&file.Salt,
-			&file.UploadedAt,
-		)
+		file, err := scanFile(rows)
 		if err != nil {
 			return nil, err
 		}
-		files = append(files, file)
+		files = append(files, *file)
 	}
-	
-	return files, nil
+
+	return files, rows.Err()
 }
 
-func (d *Database) CreateChunk(chunkHash string, chunkSize int, storagePath string) (bool, error) {
-	var exists bool
-	checkQuery := `SELECT EXISTS(SELECT 1 FROM chunks WHERE chunk_hash = $1)`
-	err := d.db.QueryRow(checkQuery, chunkHash).Scan(&exists)
-	if err != nil {
-		return false, err
-	}
-	
-	if exists {
-		updateQuery := `UPDATE chunks SET ref_count = ref_count + 1 WHERE chunk_hash = $1`
-		_, err := d.db.Exec(updateQuery, chunkHash)
-		return false, err
-	}
-	
-	insertQuery := `
-		INSERT INTO chunks (chunk_hash, chunk_size, storage_path, ref_count)
-		VALUES ($1, $2, $3, 1)
+// CreateChunk records a reference to a chunk, inserting it if it is new.
+// It returns true when the chunk did not exist before. Existence and the
+// reference count update happen in one statement, so concurrent uploads of
+// the same chunk cannot both insert it. An existing chunk's replication is
+// raised to replication if that is higher, never lowered. hashAlgorithm is
+// only recorded for new chunks.
+func (d *Database) CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error) {
+	query := `
+		INSERT INTO chunks (chunk_hash, chunk_size, storage_path, replication, hash_algorithm, ref_count)
+		VALUES ($1, $2, $3, $4, $5, 1)
+		ON CONFLICT (chunk_hash) DO UPDATE SET
+			ref_count = chunks.ref_count + 1,
+			replication = GREATEST(chunks.replication, EXCLUDED.replication)
+		RETURNING (xmax = 0)
 	`
-	_, err = d.db.Exec(insertQuery, chunkHash, chunkSize, storagePath)
-	return true, err
+
+	var inserted bool
+	err := d.db.QueryRow(query, chunkHash, chunkSize, storagePath, replication, hashAlgorithmOrDefault(hashAlgorithm)).Scan(&inserted)
+	return inserted, err
 }
 
-func (d *Database) LinkFileChunk(fileID, chunkHash string, chunkOrder int) error {
+// LinkFileChunk records that a chunk appears at chunkOrder in a file and
+// starts at startOffset in its plaintext
+func (d *Database) LinkFileChunk(fileID, chunkHash string, chunkOrder int, startOffset int64) error {
 	query := `
-		INSERT INTO file_chunks (file_id, chunk_hash, chunk_order)
-		VALUES ($1, $2, $3)
+		INSERT INTO file_chunks (file_id, chunk_hash, chunk_order, start_offset)
+		VALUES ($1, $2, $3, $4)
 	`
-	_, err := d.db.Exec(query, fileID, chunkHash, chunkOrder)
+	_, err := d.db.Exec(query, fileID, chunkHash, chunkOrder, startOffset)
 	return err
 }
 
@@ -181,7 +353,7 @@ func (d *Database) GetFileChunks(fileID string) ([]string, error) {
 
 func (d *Database) GetChunk(chunkHash string) (*ChunkRecord, error) {
 	query := `
-		SELECT chunk_hash, chunk_size, ref_count, storage_path
+		SELECT chunk_hash, chunk_size, ref_count, storage_path, replication, hash_algorithm
 		FROM chunks
 		WHERE chunk_hash = $1
 	`
@@ -192,10 +364,12 @@ func (d *Database) GetChunk(chunkHash string) (*ChunkRecord, error) {
 		&chunk.ChunkSize,
 		&chunk.RefCount,
 		&chunk.StoragePath,
+		&chunk.Replication,
+		&chunk.HashAlgorithm,
 	)
 	
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("chunk not found")
+		return nil, ErrChunkNotFound
 	}
 	if err != nil {
 		return nil, err
@@ -209,32 +383,70 @@ func (d *Database) GetStats() (map[string]interface{}, error) {
 		SELECT 
 			COUNT(*) as unique_chunks,
 			COALESCE(SUM(ref_count), 0) as total_references,
+			COALESCE(SUM(ref_count::bigint * chunk_size), 0) as logical_bytes,
 			COALESCE(SUM(chunk_size), 0) as storage_used
 		FROM chunks
 	`
 	
 	var uniqueChunks, totalRefs int
-	var storageUsed int64
+	var logicalBytes, storageUsed int64
 	
-	err := d.db.QueryRow(query).Scan(&uniqueChunks, &totalRefs, &storageUsed)
+	err := d.db.QueryRow(query).Scan(&uniqueChunks, &totalRefs, &logicalBytes, &storageUsed)
 	if err != nil {
 		return nil, err
 	}
 	
+	return storageStats(uniqueChunks, totalRefs, logicalBytes, storageUsed), nil
+}
+
+// storageStats builds the dedup statistics shared by every metadata store.
+// logicalBytes is what the files would take without deduplication (each
+// chunk's size times its references) and uniqueBytes the size of the
+// distinct chunks. Chunks are stored as they are, so physical_bytes equals
+// uniqueBytes and compression_ratio is 1 until chunks are compressed. Ratios
+// are 0 when nothing is stored rather than dividing by zero.
+func storageStats(uniqueChunks, totalRefs int, logicalBytes, uniqueBytes int64) map[string]interface{} {
+	physicalBytes := uniqueBytes
+
 	spaceSaved := int64(0)
-	if totalRefs > uniqueChunks {
-		spaceSaved = storageUsed * int64(totalRefs-uniqueChunks) / int64(max(uniqueChunks, 1))
+	if logicalBytes > physicalBytes {
+		spaceSaved = logicalBytes - physicalBytes
 	}
-	
-	dedupRatio := float64(totalRefs) / float64(max(uniqueChunks, 1))
-	
+
+	dedupRatio, compressionRatio, savings := 0.0, 0.0, 0.0
+	if uniqueChunks > 0 {
+		dedupRatio = float64(totalRefs) / float64(uniqueChunks)
+	}
+	if physicalBytes > 0 {
+		compressionRatio = float64(uniqueBytes) / float64(physicalBytes)
+	}
+	if logicalBytes > 0 {
+		savings = 1 - float64(physicalBytes)/float64(logicalBytes)
+	}
+
 	return map[string]interface{}{
-		"unique_chunks":    uniqueChunks,
-		"total_references": totalRefs,
-		"storage_used":     storageUsed,
-		"space_saved":      spaceSaved,
-		"dedup_ratio":      dedupRatio,
-	}, nil
+		"unique_chunks":     uniqueChunks,
+		"total_references":  totalRefs,
+		"storage_used":      physicalBytes,
+		"space_saved":       spaceSaved,
+		"dedup_ratio":       dedupRatio,
+		"logical_bytes":     logicalBytes,
+		"physical_bytes":    physicalBytes,
+		"compression_ratio": compressionRatio,
+		"savings_ratio":     savings, // Fraction of logical bytes not stored, from dedup and compression together
+	}
+}
+
+// requireAffected returns notFound if an UPDATE/DELETE matched no rows
+func requireAffected(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
 }
 
 func max(a, b int) int {
@@ -0,0 +1,93 @@
+package metadata
+
+import "database/sql"
+
+// ShardRecord locates one erasure-coded shard of a chunk
+type ShardRecord struct {
+	Index     int    `json:"index"`
+	ShardHash string `json:"shard_hash"`
+	NodeID    string `json:"node_id"`
+}
+
+// ChunkCoding describes how an erasure-coded chunk was split. ChunkSize is
+// the size of the original chunk, needed to strip shard padding on rebuild.
+type ChunkCoding struct {
+	ChunkSize    int           `json:"chunk_size"`
+	DataShards   int           `json:"data_shards"`
+	ParityShards int           `json:"parity_shards"`
+	Shards       []ShardRecord `json:"shards"`
+}
+
+// SetChunkCoding records that a chunk is stored as erasure-coded shards
+func (d *Database) SetChunkCoding(chunkHash string, coding *ChunkCoding) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE chunks SET data_shards = $2, parity_shards = $3
+		WHERE chunk_hash = $1
+	`, chunkHash, coding.DataShards, coding.ParityShards)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result, ErrChunkNotFound); err != nil {
+		return err
+	}
+
+	for _, shard := range coding.Shards {
+		_, err := tx.Exec(`
+			INSERT INTO chunk_shards (chunk_hash, shard_index, shard_hash, node_id)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (chunk_hash, shard_index) DO UPDATE
+			SET shard_hash = EXCLUDED.shard_hash, node_id = EXCLUDED.node_id
+		`, chunkHash, shard.Index, shard.ShardHash, shard.NodeID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetChunkCoding returns how a chunk is erasure-coded, or nil if the chunk
+// is stored as full replicas
+func (d *Database) GetChunkCoding(chunkHash string) (*ChunkCoding, error) {
+	var coding ChunkCoding
+	err := d.db.QueryRow(`
+		SELECT chunk_size, data_shards, parity_shards FROM chunks WHERE chunk_hash = $1
+	`, chunkHash).Scan(&coding.ChunkSize, &coding.DataShards, &coding.ParityShards)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrChunkNotFound
+		}
+		return nil, err
+	}
+
+	if coding.DataShards == 0 {
+		return nil, nil
+	}
+
+	rows, err := d.db.Query(`
+		SELECT shard_index, shard_hash, node_id
+		FROM chunk_shards
+		WHERE chunk_hash = $1
+		ORDER BY shard_index ASC
+	`, chunkHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shard ShardRecord
+		if err := rows.Scan(&shard.Index, &shard.ShardHash, &shard.NodeID); err != nil {
+			return nil, err
+		}
+		coding.Shards = append(coding.Shards, shard)
+	}
+
+	return &coding, rows.Err()
+}
@@ -0,0 +1,61 @@
+package metadata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// codingStore is the part of a metadata store that records erasure coding
+type codingStore interface {
+	CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error)
+	SetChunkCoding(chunkHash string, coding *ChunkCoding) error
+	GetChunkCoding(chunkHash string) (*ChunkCoding, error)
+}
+
+// testChunkCoding records a chunk's shards and checks they read back as
+// stored, while a replicated chunk reads back with no coding
+func testChunkCoding(t *testing.T, store codingStore) {
+	coded, replicated := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	for _, hash := range []string{coded, replicated} {
+		if _, err := store.CreateChunk(hash, 1000, "local", 1, "sha256"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := &ChunkCoding{ChunkSize: 1000, DataShards: 2, ParityShards: 1}
+	for i, nodeID := range []string{"node-1", "node-2", "node-3"} {
+		want.Shards = append(want.Shards, ShardRecord{Index: i, ShardHash: strings.Repeat(string(rune('c'+i)), 64), NodeID: nodeID})
+	}
+	if err := store.SetChunkCoding(coded, want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetChunkCoding(coded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	if got, err := store.GetChunkCoding(replicated); err != nil || got != nil {
+		t.Errorf("replicated chunk: want no coding, got %+v, %v", got, err)
+	}
+
+	unknown := strings.Repeat("f", 64)
+	if err := store.SetChunkCoding(unknown, want); err != ErrChunkNotFound {
+		t.Errorf("set on an unknown chunk: want ErrChunkNotFound, got %v", err)
+	}
+	if _, err := store.GetChunkCoding(unknown); err != ErrChunkNotFound {
+		t.Errorf("get on an unknown chunk: want ErrChunkNotFound, got %v", err)
+	}
+}
+
+func TestChunkCodingMemory(t *testing.T) {
+	testChunkCoding(t, NewMemoryStore())
+}
+
+func TestChunkCodingPostgres(t *testing.T) {
+	testChunkCoding(t, testDatabase(t, true))
+}
@@ -0,0 +1,21 @@
+package metadata
+
+import "time"
+
+// notExpired is the condition selecting files that have not expired
+const notExpired = `(expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
+
+// Expired reports whether the file had expired by now
+func (f *FileRecord) Expired(now time.Time) bool {
+	return f.ExpiresAt != nil && !now.Before(*f.ExpiresAt)
+}
+
+// ListExpiredFiles returns the files that expired before now, in the trash
+// or not, soonest expired first
+func (d *Database) ListExpiredFiles(now time.Time) ([]FileRecord, error) {
+	return d.queryFiles(`SELECT `+fileColumns+`
+		FROM files
+		WHERE expires_at IS NOT NULL AND expires_at <= $1
+		ORDER BY expires_at ASC
+	`, now)
+}
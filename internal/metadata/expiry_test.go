@@ -0,0 +1,108 @@
+package metadata
+
+import (
+	"testing"
+	"time"
+)
+
+// expiryStore is the part of a metadata store that hides and lists expired
+// files
+type expiryStore interface {
+	CreateFile(file *FileRecord) error
+	GetFile(fileID string) (*FileRecord, error)
+	GetFileByHash(fileHash string) (*FileRecord, error)
+	ListFiles() ([]FileRecord, error)
+	ListFileVersions(fileName string) ([]FileRecord, error)
+	SoftDeleteFile(fileID string) error
+	ListExpiredFiles(now time.Time) ([]FileRecord, error)
+}
+
+// testFileExpiry stores files that never expire, expire later and have
+// expired, and checks only the expired ones are hidden from listings and
+// lookups and listed for the reaper, in the trash or not
+func testFileExpiry(t *testing.T, store expiryStore) {
+	const (
+		live     = "30000000-0000-0000-0000-000000000001"
+		later    = "30000000-0000-0000-0000-000000000002"
+		expired  = "30000000-0000-0000-0000-000000000003"
+		trashed  = "30000000-0000-0000-0000-000000000004"
+		fileName = "report.pdf"
+	)
+	now := time.Now().UTC().Truncate(time.Second)
+	at := func(d time.Duration) *time.Time {
+		when := now.Add(d)
+		return &when
+	}
+	hashes := randomHashes(t, 2)
+
+	for _, file := range []*FileRecord{
+		{FileID: live, FileName: fileName, FileSize: 100, FileHash: hashes[0]},
+		{FileID: later, FileName: "later.pdf", FileSize: 100, FileHash: hashes[1], ExpiresAt: at(time.Hour)},
+		{FileID: expired, FileName: fileName, FileSize: 100, FileHash: hashes[1], ExpiresAt: at(-time.Hour)},
+		{FileID: trashed, FileName: "trashed.pdf", FileSize: 100, ExpiresAt: at(-2 * time.Hour)},
+	} {
+		if err := store.CreateFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SoftDeleteFile(trashed); err != nil {
+		t.Fatal(err)
+	}
+
+	// The expiry is stored, and the file can still be read by ID for the
+	// reaper and for downloads to answer that it has expired
+	file, err := store.GetFile(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.ExpiresAt == nil || !file.ExpiresAt.Equal(*at(-time.Hour)) || !file.Expired(now) {
+		t.Errorf("want the file expired an hour ago, got %+v", file)
+	}
+	if file, err := store.GetFile(live); err != nil || file.ExpiresAt != nil || file.Expired(now) {
+		t.Errorf("want the live file never to expire, got %+v (%v)", file, err)
+	}
+
+	files, err := store.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[string]bool)
+	for _, file := range files {
+		listed[file.FileID] = true
+	}
+	if !listed[live] || !listed[later] || listed[expired] || listed[trashed] {
+		t.Errorf("want only the files yet to expire listed, got %v", listed)
+	}
+
+	versions, err := store.ListFileVersions(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].FileID != live {
+		t.Errorf("want only the live version of %s, got %+v", fileName, versions)
+	}
+
+	// The expired file's contents are found through the copy yet to expire
+	if file, err := store.GetFileByHash(hashes[1]); err != nil || file.FileID != later {
+		t.Errorf("want the copy yet to expire found by hash, got %+v (%v)", file, err)
+	}
+
+	due, err := store.ListExpiredFiles(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].FileID != trashed || due[1].FileID != expired {
+		t.Errorf("want the trashed then the expired file due, got %+v", due)
+	}
+	if due, err := store.ListExpiredFiles(now.Add(2 * time.Hour)); err != nil || len(due) != 3 || due[2].FileID != later {
+		t.Errorf("two hours on: want the later file due last, got %+v (%v)", due, err)
+	}
+}
+
+func TestFileExpiryMemory(t *testing.T) {
+	testFileExpiry(t, NewMemoryStore())
+}
+
+func TestFileExpiryPostgres(t *testing.T) {
+	testFileExpiry(t, testDatabase(t, true))
+}
@@ -0,0 +1,138 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// MissingChunksError is returned for a file whose chunk links have holes:
+// positions with no link, as left when a chunk row is deleted while files
+// still use it, or links to chunks with no record. The file can't be
+// reassembled.
+type MissingChunksError struct {
+	FileID string
+	Orders []int // Positions in the file's chunk order that are missing
+}
+
+func (e *MissingChunksError) Error() string {
+	return fmt.Sprintf("file %s is missing chunks at positions %v", e.FileID, e.Orders)
+}
+
+// missingChunkOrders returns the positions missing from a file's chunk
+// links, given the links' orders in ascending order and whether each one's
+// chunk has a record. Chunks missing after the last link can't be told apart
+// from the end of the file, except that a file with bytes needs at least one
+// chunk: only an empty file may have none.
+func missingChunkOrders(orders []int, known []bool, fileSize int64) []int {
+	if len(orders) == 0 && fileSize > 0 {
+		return []int{0}
+	}
+
+	var missing []int
+	expected := 0
+	for i, order := range orders {
+		for ; expected < order; expected++ {
+			missing = append(missing, expected)
+		}
+		if !known[i] {
+			missing = append(missing, order)
+		}
+		expected = order + 1
+	}
+	return missing
+}
+
+// ChunkDescriptor is one chunk of a file: where it sits in the plaintext
+// and which nodes are known to hold it
+type ChunkDescriptor struct {
+	ChunkHash string   `json:"chunk_hash"`
+	Offset    int64    `json:"offset"`
+	Size      int64    `json:"size"`
+	Nodes     []string `json:"nodes"` // Sorted; empty for locally stored chunks
+}
+
+// GetFileWithChunks returns a file that has not been soft-deleted together
+// with its chunks in order, in a single query. Each chunk's size is the
+// distance to the next chunk's offset, or to the end of the file for the
+// last one, as in GetFileChunksInRange. A file with holes in its chunk
+// links fails with *MissingChunksError rather than coming back short.
+func (d *Database) GetFileWithChunks(fileID string) (*FileRecord, []ChunkDescriptor, error) {
+	// The chunk columns are selected from a subquery so they can't clash
+	// with the unqualified fileColumns
+	query := `SELECT ` + fileColumns + `,
+			fc.chunk_hash, fc.chunk_order, fc.known, fc.start_offset,
+			LEAD(fc.start_offset, 1, file_size) OVER (ORDER BY fc.chunk_order) - fc.start_offset,
+			ARRAY(SELECT cl.node_id FROM chunk_locations cl
+			      WHERE cl.chunk_hash = fc.chunk_hash ORDER BY cl.node_id)
+		FROM files
+		LEFT JOIN (
+			SELECT l.chunk_hash, l.chunk_order, l.start_offset, c.chunk_hash IS NOT NULL AS known
+			FROM file_chunks l
+			LEFT JOIN chunks c ON c.chunk_hash = l.chunk_hash
+			WHERE l.file_id = $1
+		) fc ON TRUE
+		WHERE file_id = $1 AND deleted_at IS NULL
+		ORDER BY fc.chunk_order ASC
+	`
+
+	rows, err := d.db.Query(query, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var file *FileRecord
+	chunks := []ChunkDescriptor{}
+	var orders []int
+	var known []bool
+	for rows.Next() {
+		var hash sql.NullString
+		var order sql.NullInt64
+		var isKnown sql.NullBool
+		var offset, size sql.NullInt64
+		var nodes pq.StringArray
+
+		record, err := scanFile(withColumns{rows, []interface{}{&hash, &order, &isKnown, &offset, &size, &nodes}})
+		if err != nil {
+			return nil, nil, err
+		}
+		file = record
+
+		// A file without chunks comes back as one row with no chunk
+		if !hash.Valid {
+			continue
+		}
+		orders = append(orders, int(order.Int64))
+		known = append(known, isKnown.Bool)
+		chunks = append(chunks, ChunkDescriptor{
+			ChunkHash: hash.String,
+			Offset:    offset.Int64,
+			Size:      size.Int64,
+			Nodes:     []string(nodes),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if file == nil {
+		return nil, nil, ErrFileNotFound
+	}
+	if missing := missingChunkOrders(orders, known, file.FileSize); len(missing) > 0 {
+		return nil, nil, &MissingChunksError{FileID: fileID, Orders: missing}
+	}
+
+	return file, chunks, nil
+}
+
+// withColumns scans the fileColumns into the destinations scanFile passes
+// and any further columns into extra
+type withColumns struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (w withColumns) Scan(dest ...interface{}) error {
+	return w.row.Scan(append(dest, w.extra...)...)
+}
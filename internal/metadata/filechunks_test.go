@@ -0,0 +1,207 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fileChunksStore is the part of a metadata store that assembles a file
+// with its chunks, and the separate queries it replaces
+type fileChunksStore interface {
+	rangeStore
+	AddChunkLocations(chunkHash string, nodeIDs []string) error
+	SoftDeleteFile(fileID string) error
+	GetFile(fileID string) (*FileRecord, error)
+	GetFileChunks(fileID string) ([]string, error)
+	GetChunkLocations(hashes []string) (map[string][]string, error)
+	GetFileWithChunks(fileID string) (*FileRecord, []ChunkDescriptor, error)
+}
+
+// testFileWithChunks links a file's chunks out of order, some held by
+// nodes, and checks GetFileWithChunks returns what the separate queries do,
+// in chunk order
+func testFileWithChunks(t *testing.T, store fileChunksStore) {
+	sizes := []int64{100, 1, 5000, 37, 900}
+	nodes := [][]string{{"node-3", "node-1"}, nil, {"node-2"}, {"node-2", "node-3", "node-1"}, nil}
+
+	hashes := make([]string, len(sizes))
+	offsets := make([]int64, len(sizes))
+	var size int64
+	for i := range sizes {
+		hashes[i] = strings.Repeat(fmt.Sprintf("%x", i+1), 64)
+		offsets[i] = size
+		size += sizes[i]
+		if _, err := store.CreateChunk(hashes[i], int(sizes[i]), "local", 1, "sha256"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddChunkLocations(hashes[i], nodes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := &FileRecord{FileID: "d0000000-0000-0000-0000-000000000001", FileName: "joined.bin", FileSize: size}
+	if err := store.CreateFile(file); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{3, 0, 4, 1, 2} {
+		if err := store.LinkFileChunk(file.FileID, hashes[i], i, offsets[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, chunks, err := store.GetFileWithChunks(file.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantFile, err := store.GetFile(file.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, wantFile) {
+		t.Errorf("want file %+v, got %+v", wantFile, got)
+	}
+
+	wantHashes, err := store.GetFileChunks(file.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(wantHashes, hashes) {
+		t.Fatalf("GetFileChunks out of order: %v", wantHashes)
+	}
+	ranged, err := store.GetFileChunksInRange(file.FileID, 0, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locations, err := store.GetChunkLocations(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chunks) != len(hashes) {
+		t.Fatalf("want %d chunks, got %d", len(hashes), len(chunks))
+	}
+	for i, chunk := range chunks {
+		want := ChunkDescriptor{
+			ChunkHash: wantHashes[i],
+			Offset:    ranged[i].Offset,
+			Size:      ranged[i].Size,
+			Nodes:     locations[wantHashes[i]],
+		}
+		if want.Nodes == nil {
+			want.Nodes = []string{}
+		}
+		if !reflect.DeepEqual(chunk, want) {
+			t.Errorf("chunk %d: want %+v, got %+v", i, want, chunk)
+		}
+	}
+
+	// A file without chunks has none, rather than a row of nulls
+	empty := &FileRecord{FileID: "d0000000-0000-0000-0000-000000000002", FileName: "empty.bin"}
+	if err := store.CreateFile(empty); err != nil {
+		t.Fatal(err)
+	}
+	if _, chunks, err := store.GetFileWithChunks(empty.FileID); err != nil || len(chunks) != 0 {
+		t.Errorf("empty file: want no chunks, got %v (%v)", chunks, err)
+	}
+
+	// A hole in the links is reported rather than skipped
+	holed := &FileRecord{FileID: "d0000000-0000-0000-0000-000000000003", FileName: "holed.bin", FileSize: size}
+	if err := store.CreateFile(holed); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 2} {
+		if err := store.LinkFileChunk(holed.FileID, hashes[i], i, offsets[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var missing *MissingChunksError
+	if _, _, err := store.GetFileWithChunks(holed.FileID); !errors.As(err, &missing) || !reflect.DeepEqual(missing.Orders, []int{1}) {
+		t.Errorf("want chunk 1 missing, got %v", err)
+	}
+
+	if _, _, err := store.GetFileWithChunks("d0000000-0000-0000-0000-00000000000f"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("unknown file: want ErrFileNotFound, got %v", err)
+	}
+	if err := store.SoftDeleteFile(file.FileID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.GetFileWithChunks(file.FileID); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("deleted file: want ErrFileNotFound, got %v", err)
+	}
+}
+
+func TestFileWithChunksMemory(t *testing.T) {
+	testFileWithChunks(t, NewMemoryStore())
+}
+
+func TestFileWithChunksPostgres(t *testing.T) {
+	testFileWithChunks(t, testDatabase(t, true))
+}
+
+// testChunkRecordDeleted releases the only reference to the middle chunk of
+// a three-chunk file, as a drifted reference count would, and checks the
+// file is then reported missing that chunk
+func testChunkRecordDeleted(t *testing.T, store interface {
+	fileChunksStore
+	ReleaseChunks(hashes []string) ([]ChunkRecord, error)
+}) {
+	file := &FileRecord{FileID: "d0000000-0000-0000-0000-000000000004", FileName: "released.bin", FileSize: 300}
+	if err := store.CreateFile(file); err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, 3)
+	for i := range hashes {
+		hashes[i] = strings.Repeat(fmt.Sprintf("%x", i+10), 32)
+		if _, err := store.CreateChunk(hashes[i], 100, "local", 1, "sha256"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.LinkFileChunk(file.FileID, hashes[i], i, int64(i*100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, chunks, err := store.GetFileWithChunks(file.FileID); err != nil || len(chunks) != 3 {
+		t.Fatalf("want 3 chunks, got %d (%v)", len(chunks), err)
+	}
+
+	if orphaned, err := store.ReleaseChunks(hashes[1:2]); err != nil || len(orphaned) != 1 {
+		t.Fatalf("want the chunk released, got %v (%v)", orphaned, err)
+	}
+	var missing *MissingChunksError
+	if _, _, err := store.GetFileWithChunks(file.FileID); !errors.As(err, &missing) || missing.FileID != file.FileID || !reflect.DeepEqual(missing.Orders, []int{1}) {
+		t.Errorf("want chunk 1 of %s missing, got %v", file.FileID, err)
+	}
+}
+
+func TestChunkRecordDeletedMemory(t *testing.T) {
+	testChunkRecordDeleted(t, NewMemoryStore())
+}
+
+func TestChunkRecordDeletedPostgres(t *testing.T) {
+	testChunkRecordDeleted(t, testDatabase(t, true))
+}
+
+func TestMissingChunkOrders(t *testing.T) {
+	for _, tc := range []struct {
+		orders []int
+		known  []bool
+		size   int64
+		want   []int
+	}{
+		{nil, nil, 0, nil},
+		{nil, nil, 100, []int{0}},
+		{[]int{0, 1, 2}, []bool{true, true, true}, 300, nil},
+		{[]int{0, 2}, []bool{true, true}, 300, []int{1}},
+		{[]int{3}, []bool{true}, 400, []int{0, 1, 2}},
+		{[]int{0, 1, 2}, []bool{true, false, true}, 300, []int{1}},
+		{[]int{1, 4}, []bool{false, true}, 500, []int{0, 1, 2, 3}},
+	} {
+		if got := missingChunkOrders(tc.orders, tc.known, tc.size); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("orders %v, known %v, size %d: want %v missing, got %v", tc.orders, tc.known, tc.size, tc.want, got)
+		}
+	}
+}
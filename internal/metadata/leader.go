@@ -0,0 +1,107 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+)
+
+// leaderLockID is the advisory lock key held by the coordinator that leads
+// the cluster. It differs from migrationLockID so a leader holding it
+// doesn't block a standby starting up and migrating.
+const leaderLockID = 7283911
+
+// LeaderLock is a session advisory lock that at most one coordinator
+// sharing the database holds. Postgres releases it when the holder's
+// connection closes, so a coordinator that dies or loses the database
+// gives it up without any timeout to wait out.
+type LeaderLock struct {
+	db *sql.DB
+
+	mu   sync.Mutex
+	conn *sql.Conn // Set while the lock is held
+}
+
+// LeaderLock returns the cluster's leader lock, not yet acquired
+func (d *Database) LeaderLock() *LeaderLock {
+	return &LeaderLock{db: d.db}
+}
+
+// TryAcquire takes the lock if no other session holds it, reporting
+// whether this one now does. It doesn't wait for the lock to be free.
+func (l *LeaderLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		return true, nil
+	}
+
+	// Advisory locks belong to a session, so pin a single connection for
+	// as long as the lock is held
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockID).Scan(&acquired); err != nil {
+		discardConn(conn)
+		return false, fmt.Errorf("failed to try leader lock: %w", err)
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+
+	l.conn = conn
+	return true, nil
+}
+
+// Check confirms the lock is still held by checking its session is alive.
+// Once the session is lost so is the lock, and TryAcquire must take it
+// again.
+func (l *LeaderLock) Check(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return fmt.Errorf("leader lock not held")
+	}
+
+	var one int
+	if err := l.conn.QueryRowContext(ctx, `SELECT 1`).Scan(&one); err != nil {
+		discardConn(l.conn)
+		l.conn = nil
+		return fmt.Errorf("lost leader lock session: %w", err)
+	}
+	return nil
+}
+
+// Release gives the lock up so a standby can take over straight away
+func (l *LeaderLock) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+
+	_, err := l.conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, leaderLockID)
+	if err != nil {
+		discardConn(l.conn)
+	} else {
+		l.conn.Close()
+	}
+	l.conn = nil
+	return err
+}
+
+// discardConn closes a connection's session instead of returning it to the
+// pool, where it could otherwise go on holding the lock unnoticed
+func discardConn(conn *sql.Conn) {
+	conn.Raw(func(any) error { return driver.ErrBadConn })
+	conn.Close()
+}
@@ -0,0 +1,52 @@
+package metadata
+
+import (
+	"context"
+	"testing"
+)
+
+// TestLeaderLockPostgres checks only one session holds the leader lock, a
+// release hands it over, and a holder whose session ends loses it to the
+// next session to try
+func TestLeaderLockPostgres(t *testing.T) {
+	db := testDatabase(t, true)
+	ctx := context.Background()
+	first, second := db.LeaderLock(), db.LeaderLock()
+	t.Cleanup(func() {
+		first.Release()
+		second.Release()
+	})
+
+	tryAcquire := func(lock *LeaderLock, want bool) {
+		t.Helper()
+		if acquired, err := lock.TryAcquire(ctx); err != nil || acquired != want {
+			t.Fatalf("want acquired %v, got %v (%v)", want, acquired, err)
+		}
+	}
+
+	tryAcquire(first, true)
+	tryAcquire(first, true) // Already held
+	tryAcquire(second, false)
+	if err := first.Check(ctx); err != nil {
+		t.Errorf("held lock: %v", err)
+	}
+	if err := second.Check(ctx); err == nil {
+		t.Error("lock not held: want Check to fail")
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatal(err)
+	}
+	tryAcquire(second, true)
+	tryAcquire(first, false)
+
+	// The leader's session ends, as if it died or lost the database
+	if _, err := db.db.Exec(`SELECT pg_terminate_backend(pid) FROM pg_locks WHERE locktype = 'advisory' AND objid = $1 AND granted`, leaderLockID); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Check(ctx); err == nil {
+		t.Error("session ended: want Check to fail")
+	}
+	tryAcquire(first, true)
+	tryAcquire(second, false)
+}
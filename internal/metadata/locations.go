@@ -0,0 +1,48 @@
+package metadata
+
+import "github.com/lib/pq"
+
+// AddChunkLocations records that the given nodes hold a chunk. Recording a
+// known location again is a no-op.
+func (d *Database) AddChunkLocations(chunkHash string, nodeIDs []string) error {
+	if len(nodeIDs) == 0 {
+		return nil
+	}
+
+	_, err := d.db.Exec(`
+		INSERT INTO chunk_locations (chunk_hash, node_id)
+		SELECT $1, UNNEST($2::varchar[])
+		ON CONFLICT DO NOTHING
+	`, chunkHash, pq.Array(nodeIDs))
+	return err
+}
+
+// GetChunkLocations returns the nodes known to hold each of the given
+// chunks, keyed by chunk hash
+func (d *Database) GetChunkLocations(hashes []string) (map[string][]string, error) {
+	locations := make(map[string][]string, len(hashes))
+	if len(hashes) == 0 {
+		return locations, nil
+	}
+
+	rows, err := d.db.Query(`
+		SELECT chunk_hash, node_id
+		FROM chunk_locations
+		WHERE chunk_hash = ANY($1)
+		ORDER BY chunk_hash, node_id
+	`, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash, nodeID string
+		if err := rows.Scan(&hash, &nodeID); err != nil {
+			return nil, err
+		}
+		locations[hash] = append(locations[hash], nodeID)
+	}
+
+	return locations, rows.Err()
+}
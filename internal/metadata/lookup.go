@@ -0,0 +1,83 @@
+package metadata
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// ChunksExist reports which of the given hashes are already in the chunks
+// table, using a single query instead of one round trip per chunk
+func (d *Database) ChunksExist(hashes []string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(hashes))
+	if len(hashes) == 0 {
+		return exists, nil
+	}
+
+	rows, err := d.db.Query(`SELECT chunk_hash FROM chunks WHERE chunk_hash = ANY($1)`, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		exists[hash] = true
+	}
+
+	return exists, rows.Err()
+}
+
+// GetChunks returns the records of the given hashes that are in the chunks
+// table, keyed by hash, in a single query
+func (d *Database) GetChunks(hashes []string) (map[string]*ChunkRecord, error) {
+	chunks := make(map[string]*ChunkRecord, len(hashes))
+	if len(hashes) == 0 {
+		return chunks, nil
+	}
+
+	rows, err := d.db.Query(`
+		SELECT chunk_hash, chunk_size, ref_count, storage_path, replication, hash_algorithm
+		FROM chunks
+		WHERE chunk_hash = ANY($1)
+	`, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chunk ChunkRecord
+		if err := rows.Scan(&chunk.ChunkHash, &chunk.ChunkSize, &chunk.RefCount, &chunk.StoragePath, &chunk.Replication, &chunk.HashAlgorithm); err != nil {
+			return nil, err
+		}
+		chunks[chunk.ChunkHash] = &chunk
+	}
+
+	return chunks, rows.Err()
+}
+
+// GetFileByHash returns the newest file not in the trash whose contents
+// hash to fileHash. Files uploaded with deduplication disabled, and expired
+// files, never match.
+func (d *Database) GetFileByHash(fileHash string) (*FileRecord, error) {
+	query := `SELECT ` + fileColumns + `
+		FROM files
+		WHERE file_hash = $1 AND deleted_at IS NULL AND dedup_salt IS NULL AND ` + notExpired + `
+		ORDER BY uploaded_at DESC
+		LIMIT 1
+	`
+
+	file, err := scanFile(d.db.QueryRow(query, fileHash))
+	if err == sql.ErrNoRows {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -0,0 +1,166 @@
+package metadata
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// chunkLookup is the part of a metadata store uploads use to find which
+// chunks are already stored
+type chunkLookup interface {
+	CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error)
+	GetChunk(chunkHash string) (*ChunkRecord, error)
+	ChunksExist(hashes []string) (map[string]bool, error)
+	GetChunks(hashes []string) (map[string]*ChunkRecord, error)
+}
+
+func randomHashes(t testing.TB, n int) []string {
+	t.Helper()
+
+	hashes := make([]string, n)
+	for i := range hashes {
+		var raw [32]byte
+		if _, err := rand.Read(raw[:]); err != nil {
+			t.Fatal(err)
+		}
+		hashes[i] = hex.EncodeToString(raw[:])
+	}
+	return hashes
+}
+
+// testChunkLookup stores every other of a set of hashes and checks the bulk
+// lookups report exactly those
+func testChunkLookup(t *testing.T, store chunkLookup) {
+	hashes := randomHashes(t, 10)
+	present := make(map[string]bool)
+	for i, hash := range hashes {
+		if i%2 == 0 {
+			if _, err := store.CreateChunk(hash, 100+i, "local", 1, "sha256"); err != nil {
+				t.Fatal(err)
+			}
+			present[hash] = true
+		}
+	}
+
+	// Repeats and an empty list are handled too
+	lookups := [][]string{hashes, append(hashes[:2:2], hashes[:2]...), {}}
+	for _, lookup := range lookups {
+		exists, err := store.ChunksExist(lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks, err := store.GetChunks(lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantFound := make(map[string]bool)
+		for _, hash := range lookup {
+			if present[hash] {
+				wantFound[hash] = true
+			}
+			if exists[hash] != present[hash] {
+				t.Errorf("chunk %s: ChunksExist says %v, want %v", hash[:8], exists[hash], present[hash])
+			}
+			chunk, found := chunks[hash]
+			if found != present[hash] {
+				t.Errorf("chunk %s: GetChunks found it %v, want %v", hash[:8], found, present[hash])
+			}
+			if found && (chunk.ChunkHash != hash || chunk.RefCount != 1) {
+				t.Errorf("chunk %s: GetChunks returned %+v", hash[:8], chunk)
+			}
+		}
+		if len(exists) != len(wantFound) || len(chunks) != len(wantFound) {
+			t.Errorf("want %d chunks found, ChunksExist found %d and GetChunks %d", len(wantFound), len(exists), len(chunks))
+		}
+	}
+}
+
+func TestChunkLookupMemory(t *testing.T) {
+	testChunkLookup(t, NewMemoryStore())
+}
+
+func TestChunkLookupPostgres(t *testing.T) {
+	testChunkLookup(t, testDatabase(t, true))
+}
+
+// BenchmarkChunkLookupPostgres compares looking up a file's worth of chunks
+// one query per chunk with a single bulk query. It needs TEST_DATABASE_URL.
+func BenchmarkChunkLookupPostgres(b *testing.B) {
+	store := testDatabase(b, true)
+
+	const chunks = 256
+	hashes := randomHashes(b, chunks)
+	for _, hash := range hashes[:chunks/2] {
+		if _, err := store.CreateChunk(hash, 100, "local", 1, "sha256"); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run(fmt.Sprintf("per-chunk/%d", chunks), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, hash := range hashes {
+				if _, err := store.GetChunk(hash); err != nil && err != ErrChunkNotFound {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run(fmt.Sprintf("bulk/%d", chunks), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.GetChunks(hashes); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// fileLookup is the part of a metadata store uploads use to find a file
+// already stored with the same contents
+type fileLookup interface {
+	CreateFile(file *FileRecord) error
+	GetFile(fileID string) (*FileRecord, error)
+	GetFileByHash(fileHash string) (*FileRecord, error)
+}
+
+// testFileByHash stores a file with deduplication disabled and checks
+// looking up its contents' hash finds nothing until a shared copy is stored
+func testFileByHash(t *testing.T, store fileLookup) {
+	const (
+		isolated = "20000000-0000-0000-0000-000000000001"
+		shared   = "20000000-0000-0000-0000-000000000002"
+		salt     = "00112233445566778899aabbccddeeff"
+	)
+	fileHash := randomHashes(t, 1)[0]
+
+	if err := store.CreateFile(&FileRecord{FileID: isolated, FileName: "isolated.bin", FileSize: 100, FileHash: fileHash, DedupSalt: salt}); err != nil {
+		t.Fatal(err)
+	}
+	if file, err := store.GetFile(isolated); err != nil || file.DedupSalt != salt {
+		t.Fatalf("want the salt stored with the file, got %+v (%v)", file, err)
+	}
+	if file, err := store.GetFileByHash(fileHash); err != ErrFileNotFound {
+		t.Fatalf("only an isolated copy: want ErrFileNotFound, got %+v (%v)", file, err)
+	}
+
+	if err := store.CreateFile(&FileRecord{FileID: shared, FileName: "shared.bin", FileSize: 100, FileHash: fileHash}); err != nil {
+		t.Fatal(err)
+	}
+	file, err := store.GetFileByHash(fileHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.FileID != shared || file.DedupSalt != "" {
+		t.Errorf("want the shared copy, got %+v", file)
+	}
+}
+
+func TestFileByHashMemory(t *testing.T) {
+	testFileByHash(t, NewMemoryStore())
+}
+
+func TestFileByHashPostgres(t *testing.T) {
+	testFileByHash(t, testDatabase(t, true))
+}
@@ -0,0 +1,971 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory implementation of the metadata store. It is
+// intended for tests and for running the coordinator without PostgreSQL;
+// nothing is persisted across restarts.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	files      map[string]*FileRecord
+	chunks     map[string]*ChunkRecord
+	fileChunks map[string]map[int]chunkLink // fileID -> chunk order -> link
+	codings    map[string]*ChunkCoding      // chunkHash -> erasure coding
+	locations  map[string]map[string]bool   // chunkHash -> node IDs
+	shares     map[string]*ShareLink        // token hash -> link
+	tags       map[string]map[string]string // fileID -> tag key -> value
+	thumbnails map[string][]byte            // fileID -> JPEG thumbnail
+	failed     map[string]*FailedPlacement  // chunkHash -> queued placement
+	access     []AccessRecord               // Access log, oldest first
+}
+
+// chunkLink is one file_chunks row
+type chunkLink struct {
+	hash        string
+	startOffset int64
+}
+
+// NewMemoryStore creates an empty in-memory metadata store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		files:      make(map[string]*FileRecord),
+		chunks:     make(map[string]*ChunkRecord),
+		fileChunks: make(map[string]map[int]chunkLink),
+		codings:    make(map[string]*ChunkCoding),
+		locations:  make(map[string]map[string]bool),
+		shares:     make(map[string]*ShareLink),
+		tags:       make(map[string]map[string]string),
+		thumbnails: make(map[string][]byte),
+		failed:     make(map[string]*FailedPlacement),
+	}
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// Ping always succeeds; there is nothing to connect to
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryStore) CreateFile(file *FileRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.files[file.FileID]; exists {
+		return fmt.Errorf("file %s already exists", file.FileID)
+	}
+
+	version := 0
+	for _, existing := range m.files {
+		if existing.FileName == file.FileName && existing.Version > version {
+			version = existing.Version
+		}
+	}
+
+	file.Version = version + 1
+	file.UploadedAt = time.Now()
+	file.DeletedAt = nil
+
+	// Tags are kept apart, like the file_tags table
+	stored := copyFile(file)
+	stored.Tags = nil
+	m.files[file.FileID] = &stored
+	if len(file.Tags) > 0 {
+		m.tags[file.FileID] = copyTags(file.Tags)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetFile(fileID string) (*FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	file, exists := m.files[fileID]
+	if !exists || file.DeletedAt != nil {
+		return nil, ErrFileNotFound
+	}
+
+	copied := copyFile(file)
+	return &copied, nil
+}
+
+func (m *MemoryStore) GetFileByHash(fileHash string) (*FileRecord, error) {
+	if fileHash == "" {
+		return nil, ErrFileNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var newest *FileRecord
+	for _, file := range m.files {
+		if file.FileHash != fileHash || file.DeletedAt != nil || file.DedupSalt != "" || file.Expired(now) {
+			continue
+		}
+		if newest == nil || file.UploadedAt.After(newest.UploadedAt) {
+			newest = file
+		}
+	}
+	if newest == nil {
+		return nil, ErrFileNotFound
+	}
+
+	copied := copyFile(newest)
+	return &copied, nil
+}
+
+func (m *MemoryStore) ListFiles() ([]FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var files []FileRecord
+	for _, file := range m.files {
+		if file.DeletedAt == nil && !file.Expired(now) {
+			files = append(files, m.withTags(file))
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].UploadedAt.After(files[j].UploadedAt)
+	})
+
+	return files, nil
+}
+
+func (m *MemoryStore) CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if chunk, exists := m.chunks[chunkHash]; exists {
+		chunk.RefCount++
+		chunk.Replication = max(chunk.Replication, replication)
+		return false, nil
+	}
+
+	m.chunks[chunkHash] = &ChunkRecord{
+		ChunkHash:     chunkHash,
+		ChunkSize:     chunkSize,
+		RefCount:      1,
+		StoragePath:   storagePath,
+		Replication:   replication,
+		HashAlgorithm: hashAlgorithmOrDefault(hashAlgorithm),
+	}
+	return true, nil
+}
+
+func (m *MemoryStore) LinkFileChunk(fileID, chunkHash string, chunkOrder int, startOffset int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mirror the foreign key and unique constraints of the SQL schema
+	if _, exists := m.files[fileID]; !exists {
+		return ErrFileNotFound
+	}
+	if _, exists := m.chunks[chunkHash]; !exists {
+		return ErrChunkNotFound
+	}
+
+	links, exists := m.fileChunks[fileID]
+	if !exists {
+		links = make(map[int]chunkLink)
+		m.fileChunks[fileID] = links
+	}
+	if _, taken := links[chunkOrder]; taken {
+		return fmt.Errorf("chunk order %d already linked for file %s", chunkOrder, fileID)
+	}
+
+	links[chunkOrder] = chunkLink{hash: chunkHash, startOffset: startOffset}
+	return nil
+}
+
+func (m *MemoryStore) GetFileChunks(fileID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var chunkHashes []string
+	for _, link := range m.orderedLinks(fileID) {
+		chunkHashes = append(chunkHashes, link.hash)
+	}
+
+	return chunkHashes, nil
+}
+
+func (m *MemoryStore) GetFileChunksInRange(fileID string, start, end int64) ([]FileChunk, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	file, exists := m.files[fileID]
+	if !exists {
+		return nil, ErrFileNotFound
+	}
+
+	links := m.orderedLinks(fileID)
+
+	var chunks []FileChunk
+	for i, link := range links {
+		chunkEnd := file.FileSize
+		if i+1 < len(links) {
+			chunkEnd = links[i+1].startOffset
+		}
+
+		if link.startOffset < end && chunkEnd > start {
+			chunks = append(chunks, FileChunk{
+				ChunkHash: link.hash,
+				Offset:    link.startOffset,
+				Size:      chunkEnd - link.startOffset,
+			})
+		}
+	}
+
+	return chunks, nil
+}
+
+func (m *MemoryStore) GetFileWithChunks(fileID string) (*FileRecord, []ChunkDescriptor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	file, exists := m.files[fileID]
+	if !exists || file.DeletedAt != nil {
+		return nil, nil, ErrFileNotFound
+	}
+
+	orders := m.linkOrders(fileID)
+	known := make([]bool, len(orders))
+	for i, order := range orders {
+		_, known[i] = m.chunks[m.fileChunks[fileID][order].hash]
+	}
+	if missing := missingChunkOrders(orders, known, file.FileSize); len(missing) > 0 {
+		return nil, nil, &MissingChunksError{FileID: fileID, Orders: missing}
+	}
+
+	links := m.orderedLinks(fileID)
+	chunks := make([]ChunkDescriptor, len(links))
+	for i, link := range links {
+		chunkEnd := file.FileSize
+		if i+1 < len(links) {
+			chunkEnd = links[i+1].startOffset
+		}
+
+		nodes := []string{}
+		for nodeID := range m.locations[link.hash] {
+			nodes = append(nodes, nodeID)
+		}
+		sort.Strings(nodes)
+
+		chunks[i] = ChunkDescriptor{
+			ChunkHash: link.hash,
+			Offset:    link.startOffset,
+			Size:      chunkEnd - link.startOffset,
+			Nodes:     nodes,
+		}
+	}
+
+	copied := copyFile(file)
+	return &copied, chunks, nil
+}
+
+// orderedLinks returns a file's chunk links sorted by chunk order.
+// The caller must hold m.mu.
+func (m *MemoryStore) orderedLinks(fileID string) []chunkLink {
+	links := m.fileChunks[fileID]
+	orders := m.linkOrders(fileID)
+
+	ordered := make([]chunkLink, 0, len(orders))
+	for _, order := range orders {
+		ordered = append(ordered, links[order])
+	}
+	return ordered
+}
+
+// linkOrders returns the chunk orders a file has links for, ascending.
+// The caller must hold m.mu.
+func (m *MemoryStore) linkOrders(fileID string) []int {
+	orders := make([]int, 0, len(m.fileChunks[fileID]))
+	for order := range m.fileChunks[fileID] {
+		orders = append(orders, order)
+	}
+	sort.Ints(orders)
+	return orders
+}
+
+func (m *MemoryStore) GetChunk(chunkHash string) (*ChunkRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chunk, exists := m.chunks[chunkHash]
+	if !exists {
+		return nil, ErrChunkNotFound
+	}
+
+	copied := *chunk
+	return &copied, nil
+}
+
+func (m *MemoryStore) ChunksExist(hashes []string) (map[string]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	exists := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		if _, found := m.chunks[hash]; found {
+			exists[hash] = true
+		}
+	}
+
+	return exists, nil
+}
+
+func (m *MemoryStore) GetChunks(hashes []string) (map[string]*ChunkRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chunks := make(map[string]*ChunkRecord, len(hashes))
+	for _, hash := range hashes {
+		if chunk, found := m.chunks[hash]; found {
+			copied := *chunk
+			chunks[hash] = &copied
+		}
+	}
+
+	return chunks, nil
+}
+
+func (m *MemoryStore) GetStats() (map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	uniqueChunks := len(m.chunks)
+	totalRefs := 0
+	var logicalBytes, storageUsed int64
+
+	for _, chunk := range m.chunks {
+		totalRefs += chunk.RefCount
+		logicalBytes += int64(chunk.RefCount) * int64(chunk.ChunkSize)
+		storageUsed += int64(chunk.ChunkSize)
+	}
+
+	return storageStats(uniqueChunks, totalRefs, logicalBytes, storageUsed), nil
+}
+
+func (m *MemoryStore) SoftDeleteFile(fileID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, exists := m.files[fileID]
+	if !exists || file.DeletedAt != nil {
+		return ErrFileNotFound
+	}
+
+	now := time.Now()
+	file.DeletedAt = &now
+	return nil
+}
+
+func (m *MemoryStore) RestoreFile(fileID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, exists := m.files[fileID]
+	if !exists || file.DeletedAt == nil {
+		return ErrFileNotFound
+	}
+
+	file.DeletedAt = nil
+	return nil
+}
+
+func (m *MemoryStore) ListDeletedFiles() ([]FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var files []FileRecord
+	for _, file := range m.files {
+		if file.DeletedAt != nil {
+			files = append(files, copyFile(file))
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].DeletedAt.After(*files[j].DeletedAt)
+	})
+
+	return files, nil
+}
+
+func (m *MemoryStore) ListFilesDeletedBefore(cutoff time.Time) ([]FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var files []FileRecord
+	for _, file := range m.files {
+		if file.DeletedAt != nil && file.DeletedAt.Before(cutoff) {
+			files = append(files, copyFile(file))
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].DeletedAt.Before(*files[j].DeletedAt)
+	})
+
+	return files, nil
+}
+
+func (m *MemoryStore) PurgeFile(fileID string) ([]ChunkRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.files[fileID]; !exists {
+		return nil, ErrFileNotFound
+	}
+
+	orphaned := m.releaseFileChunks(fileID)
+	delete(m.files, fileID)
+	delete(m.tags, fileID)
+	delete(m.thumbnails, fileID)
+	for tokenHash, link := range m.shares {
+		if link.FileID == fileID {
+			delete(m.shares, tokenHash)
+		}
+	}
+
+	return orphaned, nil
+}
+
+func (m *MemoryStore) RekeyFile(fileID string, rekey *RekeyedFile) ([]ChunkRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, exists := m.files[fileID]
+	if !exists || !file.Encrypted || file.DeletedAt != nil {
+		return nil, ErrFileNotFound
+	}
+	for _, chunk := range rekey.Chunks {
+		if _, exists := m.chunks[chunk.ChunkHash]; !exists {
+			return nil, ErrChunkNotFound
+		}
+	}
+
+	file.Salt = rekey.Salt
+	file.PasswordHash = rekey.PasswordHash
+	file.NoncePrefix = rekey.NoncePrefix
+
+	orphaned := m.releaseFileChunks(fileID)
+
+	links := make(map[int]chunkLink, len(rekey.Chunks))
+	for i, chunk := range rekey.Chunks {
+		links[i] = chunkLink{hash: chunk.ChunkHash, startOffset: chunk.Offset}
+	}
+	m.fileChunks[fileID] = links
+
+	return orphaned, nil
+}
+
+func (m *MemoryStore) ReleaseChunks(hashes []string) ([]ChunkRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var orphaned []ChunkRecord
+	for _, hash := range hashes {
+		if released := m.releaseChunk(hash); released != nil {
+			orphaned = append(orphaned, *released)
+		}
+	}
+
+	m.dropSharedShards(orphaned)
+	return orphaned, nil
+}
+
+// releaseFileChunks drops a file's chunk links and their references,
+// removing and returning chunks left unreferenced. Callers hold m.mu.
+func (m *MemoryStore) releaseFileChunks(fileID string) []ChunkRecord {
+	var orphaned []ChunkRecord
+	for _, link := range m.fileChunks[fileID] {
+		if released := m.releaseChunk(link.hash); released != nil {
+			orphaned = append(orphaned, *released)
+		}
+	}
+	delete(m.fileChunks, fileID)
+
+	m.dropSharedShards(orphaned)
+	return orphaned
+}
+
+// releaseChunk drops one reference to a chunk and, if that was the last,
+// removes the chunk and returns its record with its shard hashes. Callers
+// hold m.mu.
+func (m *MemoryStore) releaseChunk(hash string) *ChunkRecord {
+	chunk, exists := m.chunks[hash]
+	if !exists {
+		return nil
+	}
+
+	chunk.RefCount--
+	if chunk.RefCount > 0 {
+		return nil
+	}
+
+	released := *chunk
+	if coding, coded := m.codings[hash]; coded {
+		for _, shard := range coding.Shards {
+			released.ShardHashes = append(released.ShardHashes, shard.ShardHash)
+		}
+		delete(m.codings, hash)
+	}
+	delete(m.chunks, hash)
+	delete(m.locations, hash)
+	delete(m.failed, hash)
+	return &released
+}
+
+func (m *MemoryStore) GetLatestFileVersion(fileName string) (*FileRecord, error) {
+	versions, err := m.ListFileVersions(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrFileNotFound
+	}
+	return &versions[0], nil
+}
+
+func (m *MemoryStore) GetFileVersion(fileName string, version int) (*FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, file := range m.files {
+		if file.FileName == fileName && file.Version == version && file.DeletedAt == nil {
+			copied := copyFile(file)
+			return &copied, nil
+		}
+	}
+	return nil, ErrFileNotFound
+}
+
+func (m *MemoryStore) ListFileVersions(fileName string) ([]FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var files []FileRecord
+	for _, file := range m.files {
+		if file.FileName == fileName && file.DeletedAt == nil && !file.Expired(now) {
+			files = append(files, copyFile(file))
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Version > files[j].Version
+	})
+
+	return files, nil
+}
+
+// copyFile copies a record, including its DeletedAt and ExpiresAt
+// timestamps, so callers cannot modify the store through the returned value
+func copyFile(file *FileRecord) FileRecord {
+	copied := *file
+	if file.DeletedAt != nil {
+		deletedAt := *file.DeletedAt
+		copied.DeletedAt = &deletedAt
+	}
+	if file.ExpiresAt != nil {
+		expiresAt := *file.ExpiresAt
+		copied.ExpiresAt = &expiresAt
+	}
+	return copied
+}
+
+func (m *MemoryStore) ListExpiredFiles(now time.Time) ([]FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var files []FileRecord
+	for _, file := range m.files {
+		if file.Expired(now) {
+			files = append(files, copyFile(file))
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ExpiresAt.Before(*files[j].ExpiresAt)
+	})
+
+	return files, nil
+}
+
+func (m *MemoryStore) SetChunkCoding(chunkHash string, coding *ChunkCoding) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.chunks[chunkHash]; !exists {
+		return ErrChunkNotFound
+	}
+
+	copied := *coding
+	copied.Shards = append([]ShardRecord(nil), coding.Shards...)
+	m.codings[chunkHash] = &copied
+	return nil
+}
+
+func (m *MemoryStore) GetChunkCoding(chunkHash string) (*ChunkCoding, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, exists := m.chunks[chunkHash]; !exists {
+		return nil, ErrChunkNotFound
+	}
+
+	coding, coded := m.codings[chunkHash]
+	if !coded {
+		return nil, nil
+	}
+
+	copied := *coding
+	copied.Shards = append([]ShardRecord(nil), coding.Shards...)
+	return &copied, nil
+}
+
+// dropSharedShards mirrors Database.dropSharedShards. Callers hold m.mu.
+func (m *MemoryStore) dropSharedShards(orphaned []ChunkRecord) {
+	shared := make(map[string]bool)
+	for hash, coding := range m.codings {
+		shared[hash] = true
+		for _, shard := range coding.Shards {
+			shared[shard.ShardHash] = true
+		}
+	}
+	for hash := range m.chunks {
+		shared[hash] = true
+	}
+
+	for i := range orphaned {
+		var kept []string
+		for _, hash := range orphaned[i].ShardHashes {
+			if !shared[hash] {
+				kept = append(kept, hash)
+			}
+		}
+		orphaned[i].ShardHashes = kept
+	}
+}
+
+func (m *MemoryStore) AddChunkLocations(chunkHash string, nodeIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.chunks[chunkHash]; !exists {
+		return ErrChunkNotFound
+	}
+
+	nodes, exists := m.locations[chunkHash]
+	if !exists {
+		nodes = make(map[string]bool)
+		m.locations[chunkHash] = nodes
+	}
+	for _, nodeID := range nodeIDs {
+		nodes[nodeID] = true
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) GetChunkLocations(hashes []string) (map[string][]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	locations := make(map[string][]string, len(hashes))
+	for _, hash := range hashes {
+		nodes, exists := m.locations[hash]
+		if !exists {
+			continue
+		}
+		for nodeID := range nodes {
+			locations[hash] = append(locations[hash], nodeID)
+		}
+		sort.Strings(locations[hash])
+	}
+
+	return locations, nil
+}
+
+func (m *MemoryStore) AuditChunks() ([]ChunkAudit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	links := make(map[string]int)
+	for _, fileLinks := range m.fileChunks {
+		for _, link := range fileLinks {
+			links[link.hash]++
+		}
+	}
+
+	audits := make([]ChunkAudit, 0, len(m.chunks))
+	for hash, chunk := range m.chunks {
+		audit := ChunkAudit{
+			ChunkHash:   hash,
+			StoragePath: chunk.StoragePath,
+			Replication: chunk.Replication,
+			RefCount:    chunk.RefCount,
+			Links:       links[hash],
+		}
+		if coding, coded := m.codings[hash]; coded {
+			audit.DataShards = coding.DataShards
+			for _, shard := range coding.Shards {
+				audit.ShardHashes = append(audit.ShardHashes, shard.ShardHash)
+			}
+		}
+		audits = append(audits, audit)
+	}
+
+	sort.Slice(audits, func(i, j int) bool { return audits[i].ChunkHash < audits[j].ChunkHash })
+	return audits, nil
+}
+
+func (m *MemoryStore) SetFileTags(fileID string, tags map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, exists := m.files[fileID]
+	if !exists || file.DeletedAt != nil {
+		return ErrFileNotFound
+	}
+
+	if len(tags) == 0 {
+		delete(m.tags, fileID)
+	} else {
+		m.tags[fileID] = copyTags(tags)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetFileTags(fileID string) (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	file, exists := m.files[fileID]
+	if !exists || file.DeletedAt != nil {
+		return nil, ErrFileNotFound
+	}
+
+	return copyTags(m.tags[fileID]), nil
+}
+
+func (m *MemoryStore) FindFilesByTags(tags map[string]string) ([]FileRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var files []FileRecord
+	for fileID, file := range m.files {
+		if file.DeletedAt != nil || file.Expired(now) {
+			continue
+		}
+
+		matches := true
+		for key, value := range tags {
+			if actual, ok := m.tags[fileID][key]; !ok || actual != value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			files = append(files, m.withTags(file))
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].UploadedAt.After(files[j].UploadedAt)
+	})
+
+	return files, nil
+}
+
+// withTags returns a copy of a file with its tags filled in. Callers hold
+// m.mu.
+func (m *MemoryStore) withTags(file *FileRecord) FileRecord {
+	copied := copyFile(file)
+	if tags := m.tags[file.FileID]; len(tags) > 0 {
+		copied.Tags = copyTags(tags)
+	}
+	return copied
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	copied := make(map[string]string, len(tags))
+	for key, value := range tags {
+		copied[key] = value
+	}
+	return copied
+}
+
+func (m *MemoryStore) SetThumbnail(fileID string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, exists := m.files[fileID]
+	if !exists || file.DeletedAt != nil {
+		return ErrFileNotFound
+	}
+
+	m.thumbnails[fileID] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *MemoryStore) GetThumbnail(fileID string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	file, exists := m.files[fileID]
+	thumbnail, found := m.thumbnails[fileID]
+	if !exists || file.DeletedAt != nil || !found {
+		return nil, ErrThumbnailNotFound
+	}
+
+	return append([]byte(nil), thumbnail...), nil
+}
+
+func (m *MemoryStore) RecordFailedPlacement(chunkHash string, intendedNodes []string, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.chunks[chunkHash]; !exists {
+		return ErrChunkNotFound
+	}
+
+	placement, queued := m.failed[chunkHash]
+	if !queued {
+		placement = &FailedPlacement{ChunkHash: chunkHash, FailedAt: time.Now()}
+		m.failed[chunkHash] = placement
+	}
+	placement.IntendedNodes = append([]string{}, intendedNodes...)
+	placement.Reason = reason
+	return nil
+}
+
+func (m *MemoryStore) RecordPlacementAttempt(chunkHash string, intendedNodes []string, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	placement, queued := m.failed[chunkHash]
+	if !queued {
+		return nil
+	}
+
+	now := time.Now()
+	placement.IntendedNodes = append([]string{}, intendedNodes...)
+	placement.Reason = reason
+	placement.Attempts++
+	placement.LastAttemptAt = &now
+	return nil
+}
+
+func (m *MemoryStore) ListFailedPlacements() ([]FailedPlacement, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	placements := make([]FailedPlacement, 0, len(m.failed))
+	for _, placement := range m.failed {
+		copied := *placement
+		copied.IntendedNodes = append([]string{}, placement.IntendedNodes...)
+		placements = append(placements, copied)
+	}
+
+	sort.Slice(placements, func(i, j int) bool {
+		if !placements[i].FailedAt.Equal(placements[j].FailedAt) {
+			return placements[i].FailedAt.Before(placements[j].FailedAt)
+		}
+		return placements[i].ChunkHash < placements[j].ChunkHash
+	})
+	return placements, nil
+}
+
+func (m *MemoryStore) DeleteFailedPlacement(chunkHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failed, chunkHash)
+	return nil
+}
+
+func (m *MemoryStore) CreateShareLink(link *ShareLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, exists := m.files[link.FileID]
+	if !exists || file.DeletedAt != nil {
+		return ErrFileNotFound
+	}
+	if _, exists := m.shares[link.TokenHash]; exists {
+		return fmt.Errorf("share link already exists")
+	}
+
+	link.CreatedAt = time.Now()
+	stored := *link
+	m.shares[link.TokenHash] = &stored
+	return nil
+}
+
+func (m *MemoryStore) UseShareLink(tokenHash string) (*ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, exists := m.shares[tokenHash]
+	if !exists {
+		return nil, ErrShareNotFound
+	}
+	if link.ExpiresAt != nil && !time.Now().Before(*link.ExpiresAt) {
+		return nil, ErrShareExpired
+	}
+	if link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads {
+		return nil, ErrShareExpired
+	}
+
+	link.Downloads++
+	used := *link
+	return &used, nil
+}
+
+func (m *MemoryStore) DeleteShareLink(tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.shares[tokenHash]; !exists {
+		return ErrShareNotFound
+	}
+	delete(m.shares, tokenHash)
+	return nil
+}
+
+func (m *MemoryStore) RecordAccess(record *AccessRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record.ID = int64(len(m.access)) + 1
+	record.At = time.Now()
+	m.access = append(m.access, *record)
+	return nil
+}
+
+func (m *MemoryStore) ListAccess(fileID string, before int64, limit int) ([]AccessRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := []AccessRecord{}
+	for i := len(m.access) - 1; i >= 0 && len(records) < limit; i-- {
+		record := m.access[i]
+		if (fileID == "" || record.FileID == fileID) && (before <= 0 || record.ID < before) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,127 @@
+package metadata
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestLoadMigrations checks the embedded migrations are numbered from 1
+// with no gaps or repeats, in order, starting with the initial schema
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d: want version %d, got %d (%s)", i, i+1, m.Version, m.Name)
+		}
+		if strings.TrimSpace(m.SQL) == "" {
+			t.Errorf("migration %04d_%s is empty", m.Version, m.Name)
+		}
+	}
+
+	initial := migrations[0]
+	for _, table := range []string{"files", "chunks", "file_chunks"} {
+		if !strings.Contains(initial.SQL, "CREATE TABLE IF NOT EXISTS "+table+" ") {
+			t.Errorf("initial schema does not create %s", table)
+		}
+	}
+}
+
+// testDatabase connects to the database at TEST_DATABASE_URL in a schema of
+// its own, dropped when the test ends, and skips the test without one
+func testDatabase(t testing.TB, migrate bool) *Database {
+	t.Helper()
+
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	var raw [8]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(raw[:])
+
+	admin, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("failed to drop test schema: %v", err)
+		}
+	})
+
+	// Every connection of the pool works in the test's schema
+	if parsed, err := url.Parse(dbURL); err == nil && parsed.Scheme != "" {
+		query := parsed.Query()
+		query.Set("search_path", schema)
+		parsed.RawQuery = query.Encode()
+		dbURL = parsed.String()
+	} else {
+		dbURL += " search_path=" + schema
+	}
+
+	db, err := NewDatabase(dbURL, migrate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestMigratePostgres migrates an empty database, then again, and checks
+// each migration is applied once and the schema is usable
+func TestMigratePostgres(t *testing.T) {
+	db := testDatabase(t, false)
+
+	for i := 0; i < 2; i++ {
+		if err := db.Migrate(); err != nil {
+			t.Fatalf("migration run %d: %v", i+1, err)
+		}
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied, latest int
+	if err := db.db.QueryRow(`SELECT COUNT(*), MAX(version) FROM schema_migrations`).Scan(&applied, &latest); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) || latest != migrations[len(migrations)-1].Version {
+		t.Errorf("want %d migrations applied up to %d, got %d up to %d",
+			len(migrations), migrations[len(migrations)-1].Version, applied, latest)
+	}
+
+	// The migrated schema takes a file and its chunks
+	if _, err := db.CreateChunk(strings.Repeat("a", 64), 100, "local", 1, "sha256"); err != nil {
+		t.Fatal(err)
+	}
+	file := &FileRecord{FileID: "b0000000-0000-0000-0000-000000000001", FileName: "test.bin", FileSize: 100}
+	if err := db.CreateFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LinkFileChunk(file.FileID, strings.Repeat("a", 64), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, chunks, err := db.GetFileWithChunks(file.FileID); err != nil || len(chunks) != 1 {
+		t.Errorf("want the file read back with 1 chunk, got %d: %v", len(chunks), err)
+	}
+}
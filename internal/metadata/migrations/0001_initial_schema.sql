@@ -1,4 +1,3 @@
-cat > scripts/init.sql << 'EOF'
 -- Files table: stores metadata about uploaded files
 CREATE TABLE IF NOT EXISTS files (
     file_id UUID PRIMARY KEY,
@@ -46,6 +45,7 @@ END;
 $$ language 'plpgsql';
 
 -- Trigger to auto-update updated_at
+-- (dropped first so databases created from the old init.sql can adopt migrations)
+DROP TRIGGER IF EXISTS update_files_updated_at ON files;
 CREATE TRIGGER update_files_updated_at BEFORE UPDATE ON files
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
-EOF
\ No newline at end of file
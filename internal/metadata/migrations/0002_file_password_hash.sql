@@ -0,0 +1,2 @@
+-- Password verification hash so downloads can reject a wrong password up front
+ALTER TABLE files ADD COLUMN IF NOT EXISTS password_hash VARCHAR(64);
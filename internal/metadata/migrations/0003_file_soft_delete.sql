@@ -0,0 +1,4 @@
+-- Soft delete: files stay recoverable in the trash until purged
+ALTER TABLE files ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_files_deleted_at ON files(deleted_at) WHERE deleted_at IS NOT NULL;
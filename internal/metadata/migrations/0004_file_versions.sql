@@ -0,0 +1,13 @@
+-- File versioning: uploads sharing a name become numbered versions of it
+ALTER TABLE files ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- Number existing uploads of the same name in upload order
+UPDATE files f
+SET version = v.version
+FROM (
+    SELECT file_id, ROW_NUMBER() OVER (PARTITION BY file_name ORDER BY uploaded_at, file_id) AS version
+    FROM files
+) v
+WHERE f.file_id = v.file_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_files_name_version ON files(file_name, version);
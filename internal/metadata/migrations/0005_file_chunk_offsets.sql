@@ -0,0 +1,24 @@
+-- Plaintext offset of each chunk within its file, so byte ranges can be
+-- mapped to chunks with an index lookup
+ALTER TABLE file_chunks ADD COLUMN IF NOT EXISTS start_offset BIGINT;
+
+-- Backfill existing links from chunk sizes. Encrypted chunks carry a 12-byte
+-- GCM nonce and 16-byte tag that are not part of the plaintext.
+UPDATE file_chunks fc
+SET start_offset = o.start_offset
+FROM (
+    SELECT l.id,
+        COALESCE(SUM(c.chunk_size - CASE WHEN f.encrypted THEN 28 ELSE 0 END) OVER (
+            PARTITION BY l.file_id
+            ORDER BY l.chunk_order
+            ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING
+        ), 0) AS start_offset
+    FROM file_chunks l
+    JOIN chunks c ON c.chunk_hash = l.chunk_hash
+    JOIN files f ON f.file_id = l.file_id
+) o
+WHERE fc.id = o.id AND fc.start_offset IS NULL;
+
+ALTER TABLE file_chunks ALTER COLUMN start_offset SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_file_chunks_offset ON file_chunks(file_id, start_offset);
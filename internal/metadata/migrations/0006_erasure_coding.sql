@@ -0,0 +1,15 @@
+-- Erasure coding: a chunk with data_shards > 0 is stored as data + parity
+-- shards instead of full replicas. Replicated chunks keep 0/0.
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS data_shards INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS parity_shards INTEGER NOT NULL DEFAULT 0;
+
+-- Shards are content-addressed like chunks and stored on nodes as such
+CREATE TABLE IF NOT EXISTS chunk_shards (
+    chunk_hash VARCHAR(64) REFERENCES chunks(chunk_hash) ON DELETE CASCADE,
+    shard_index INTEGER NOT NULL,
+    shard_hash VARCHAR(64) NOT NULL,
+    node_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (chunk_hash, shard_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_chunk_shards_shard_hash ON chunk_shards(shard_hash);
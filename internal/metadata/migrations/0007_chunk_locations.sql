@@ -0,0 +1,20 @@
+-- Record the content type clients upload files with
+ALTER TABLE files ADD COLUMN IF NOT EXISTS content_type VARCHAR(255);
+
+-- Nodes known to hold each chunk (or, for erasure-coded chunks, its shards)
+CREATE TABLE IF NOT EXISTS chunk_locations (
+    chunk_hash VARCHAR(64) REFERENCES chunks(chunk_hash) ON DELETE CASCADE,
+    node_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (chunk_hash, node_id)
+);
+
+-- Chunks stored before locations were tracked only know their first node
+INSERT INTO chunk_locations (chunk_hash, node_id)
+SELECT chunk_hash, SUBSTRING(storage_path FROM 13)
+FROM chunks
+WHERE storage_path LIKE 'distributed:%'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO chunk_locations (chunk_hash, node_id)
+SELECT DISTINCT chunk_hash, node_id FROM chunk_shards
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+-- Hex-encoded prefix of the counter nonces used to encrypt a file's chunks.
+-- NULL for files encrypted with random nonces before counters were used.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS nonce_prefix VARCHAR(16);
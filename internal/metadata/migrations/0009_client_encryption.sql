@@ -0,0 +1,5 @@
+-- Files encrypted by the client before upload. The server stores their
+-- ciphertext as-is; client_encryption holds the client's opaque parameters
+-- (algorithm, salt, ...) so it can decrypt after download.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS client_encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS client_encryption TEXT;
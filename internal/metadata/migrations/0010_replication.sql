@@ -0,0 +1,4 @@
+-- Replication factor requested for each file, and the highest factor of any
+-- file referencing each chunk, which is how many nodes hold the chunk
+ALTER TABLE files ADD COLUMN IF NOT EXISTS replication INT NOT NULL DEFAULT 3;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS replication INT NOT NULL DEFAULT 3;
@@ -0,0 +1,5 @@
+-- SHA-256 of each unencrypted file's contents, so an upload of bytes that
+-- are already stored can reuse the existing file's chunks
+ALTER TABLE files ADD COLUMN IF NOT EXISTS file_hash TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_files_file_hash ON files(file_hash) WHERE file_hash IS NOT NULL;
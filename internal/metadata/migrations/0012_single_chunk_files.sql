@@ -0,0 +1,3 @@
+-- Files below the inline threshold are stored whole as one chunk, without
+-- content-defined chunking
+ALTER TABLE files ADD COLUMN IF NOT EXISTS single_chunk BOOLEAN NOT NULL DEFAULT FALSE;
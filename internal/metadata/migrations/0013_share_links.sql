@@ -0,0 +1,14 @@
+-- Share links let anyone holding a token download one file. Only a hash of
+-- the token is stored, and an embedded password is sealed with a key derived
+-- from the token, so the table alone can't be used to download or decrypt.
+CREATE TABLE IF NOT EXISTS share_links (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    file_id UUID NOT NULL REFERENCES files(file_id) ON DELETE CASCADE,
+    sealed_password BYTEA,
+    expires_at TIMESTAMP,
+    max_downloads INTEGER,
+    downloads INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_file_id ON share_links(file_id);
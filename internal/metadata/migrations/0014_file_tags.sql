@@ -0,0 +1,10 @@
+-- Free-form key/value tags on files. Each key appears at most once per file.
+CREATE TABLE IF NOT EXISTS file_tags (
+    file_id UUID NOT NULL REFERENCES files(file_id) ON DELETE CASCADE,
+    tag_key VARCHAR(128) NOT NULL,
+    tag_value VARCHAR(1024) NOT NULL,
+    PRIMARY KEY (file_id, tag_key)
+);
+
+-- Searching by tag matches exact keys and values
+CREATE INDEX IF NOT EXISTS idx_file_tags_key_value ON file_tags(tag_key, tag_value);
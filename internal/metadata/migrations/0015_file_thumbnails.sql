@@ -0,0 +1,7 @@
+-- Small JPEG previews of image uploads, kept with the metadata since they
+-- are a few KB each and go away with their file
+CREATE TABLE IF NOT EXISTS file_thumbnails (
+    file_id UUID PRIMARY KEY REFERENCES files(file_id) ON DELETE CASCADE,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- The function each chunk's hash was computed with. Chunks stored before
+-- the hash became configurable were all hashed with SHA-256.
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS hash_algorithm VARCHAR(16) NOT NULL DEFAULT 'sha256';
@@ -0,0 +1,13 @@
+-- Replicated chunks that were stored on fewer nodes than they should be.
+-- A background worker copies them to more nodes and removes the row once
+-- enough hold them.
+CREATE TABLE IF NOT EXISTS failed_placements (
+    chunk_hash VARCHAR(64) PRIMARY KEY REFERENCES chunks(chunk_hash) ON DELETE CASCADE,
+    intended_nodes VARCHAR(255)[] NOT NULL DEFAULT '{}',
+    reason TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_failed_placements_failed_at ON failed_placements(failed_at);
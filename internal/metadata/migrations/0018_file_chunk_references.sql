@@ -0,0 +1,29 @@
+-- A chunk link always belongs to a file and a chunk. The columns allowed
+-- NULL, so links missing either are dropped before that is enforced.
+DELETE FROM file_chunks WHERE file_id IS NULL OR chunk_hash IS NULL;
+ALTER TABLE file_chunks ALTER COLUMN file_id SET NOT NULL;
+ALTER TABLE file_chunks ALTER COLUMN chunk_hash SET NOT NULL;
+
+-- Deleting a file cascades to its links (file_chunks.file_id is ON DELETE
+-- CASCADE), and every link removed, however it is removed, releases the
+-- chunk reference it held. Chunks left unreferenced keep their rows until
+-- the application deletes them along with their data.
+CREATE OR REPLACE FUNCTION release_chunk_references()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE chunks c
+    SET ref_count = c.ref_count - r.refs
+    FROM (
+        SELECT chunk_hash, COUNT(*) AS refs
+        FROM released_links
+        GROUP BY chunk_hash
+    ) r
+    WHERE c.chunk_hash = r.chunk_hash;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS release_chunk_references ON file_chunks;
+CREATE TRIGGER release_chunk_references AFTER DELETE ON file_chunks
+    REFERENCING OLD TABLE AS released_links
+    FOR EACH STATEMENT EXECUTE FUNCTION release_chunk_references();
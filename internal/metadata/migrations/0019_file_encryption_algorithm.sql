@@ -0,0 +1,4 @@
+-- The AEAD a password-encrypted file's chunks are sealed with. NULL for
+-- unencrypted files and for files encrypted before it was recorded, which
+-- all used AES-256-GCM.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS encryption_algorithm VARCHAR(32);
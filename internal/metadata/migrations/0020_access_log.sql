@@ -0,0 +1,13 @@
+-- Audit trail of who uploaded, downloaded and deleted which files. Rows are
+-- only ever appended, and outlive their files, so file_id has no foreign key.
+CREATE TABLE IF NOT EXISTS access_log (
+    id BIGSERIAL PRIMARY KEY,
+    file_id UUID NOT NULL,
+    client VARCHAR(255) NOT NULL DEFAULT '',
+    remote_addr VARCHAR(255) NOT NULL DEFAULT '',
+    action VARCHAR(16) NOT NULL,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_log_file_id ON access_log(file_id, id);
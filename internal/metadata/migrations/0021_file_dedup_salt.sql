@@ -0,0 +1,4 @@
+-- Random bytes prefixed to every chunk of a file uploaded with
+-- deduplication disabled, so its chunks hash apart from every other file's.
+-- NULL for files whose chunks are shared by content.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS dedup_salt VARCHAR(64);
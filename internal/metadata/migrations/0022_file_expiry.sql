@@ -0,0 +1,5 @@
+-- When a file stops being served and becomes due for purging. NULL for
+-- files that never expire.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_files_expires_at ON files(expires_at) WHERE expires_at IS NOT NULL;
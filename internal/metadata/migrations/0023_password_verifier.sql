@@ -0,0 +1,6 @@
+-- Password hashes used to be SHA-256 of salt and password, cheap to brute
+-- force. Drop them; downloads of those files fall back to the AEAD check.
+-- New verifiers are an HMAC keyed with the PBKDF2 key, prefixed "hmac:".
+UPDATE files SET password_hash = NULL WHERE password_hash NOT LIKE 'hmac:%';
+
+ALTER TABLE files ALTER COLUMN password_hash TYPE VARCHAR(80);
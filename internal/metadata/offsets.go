@@ -0,0 +1,44 @@
+package metadata
+
+// GetFileChunksInRange returns the chunks overlapping the plaintext byte
+// range [start, end) of a file, in order. The starting chunk is found with
+// the (file_id, start_offset) index instead of scanning every chunk; each
+// chunk's size is the distance to the next chunk's offset, or to the end of
+// the file for the last one.
+func (d *Database) GetFileChunksInRange(fileID string, start, end int64) ([]FileChunk, error) {
+	query := `
+		SELECT fc.chunk_hash, fc.start_offset,
+			COALESCE(
+				(SELECT MIN(n.start_offset) FROM file_chunks n
+				 WHERE n.file_id = fc.file_id AND n.start_offset > fc.start_offset),
+				f.file_size
+			) - fc.start_offset AS size
+		FROM file_chunks fc
+		JOIN files f ON f.file_id = fc.file_id
+		WHERE fc.file_id = $1
+		  AND fc.start_offset >= (
+			SELECT COALESCE(MAX(s.start_offset), 0) FROM file_chunks s
+			WHERE s.file_id = $1 AND s.start_offset <= $2
+		  )
+		  AND fc.start_offset < $3
+		  AND $2 < f.file_size
+		ORDER BY fc.start_offset ASC
+	`
+
+	rows, err := d.db.Query(query, fileID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []FileChunk
+	for rows.Next() {
+		var chunk FileChunk
+		if err := rows.Scan(&chunk.ChunkHash, &chunk.Offset, &chunk.Size); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, rows.Err()
+}
@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rangeStore is the part of a metadata store that maps byte ranges to chunks
+type rangeStore interface {
+	CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error)
+	CreateFile(file *FileRecord) error
+	LinkFileChunk(fileID, chunkHash string, chunkOrder int, startOffset int64) error
+	GetFileChunksInRange(fileID string, start, end int64) ([]FileChunk, error)
+}
+
+// testChunksInRange stores a file of unevenly sized chunks and checks every
+// range maps to exactly the chunks overlapping it, and one past the end of
+// the file to none
+func testChunksInRange(t *testing.T, store rangeStore) {
+	sizes := []int64{100, 1, 5000, 37, 900}
+
+	var chunks []FileChunk
+	var offset int64
+	for i, size := range sizes {
+		hash := strings.Repeat(fmt.Sprintf("%x", i+1), 64)
+		if _, err := store.CreateChunk(hash, int(size), "local", 1, "sha256"); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, FileChunk{ChunkHash: hash, Offset: offset, Size: size})
+		offset += size
+	}
+
+	file := &FileRecord{FileID: "c0000000-0000-0000-0000-000000000001", FileName: "ranged.bin", FileSize: offset}
+	if err := store.CreateFile(file); err != nil {
+		t.Fatal(err)
+	}
+	for i, chunk := range chunks {
+		if err := store.LinkFileChunk(file.FileID, chunk.ChunkHash, i, chunk.Offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every boundary, and a byte either side of it
+	var points []int64
+	for _, chunk := range chunks {
+		points = append(points, chunk.Offset-1, chunk.Offset, chunk.Offset+1)
+	}
+	points = append(points, offset-1, offset, offset+1)
+
+	for _, start := range points {
+		for _, end := range points {
+			if start < 0 || end <= start {
+				continue
+			}
+
+			var want []FileChunk
+			for _, chunk := range chunks {
+				if chunk.Offset < end && chunk.Offset+chunk.Size > start {
+					want = append(want, chunk)
+				}
+			}
+
+			got, err := store.GetFileChunksInRange(file.FileID, start, end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+				t.Errorf("range [%d, %d): want %v, got %v", start, end, want, got)
+			}
+		}
+	}
+}
+
+func TestChunksInRangeMemory(t *testing.T) {
+	testChunksInRange(t, NewMemoryStore())
+}
+
+func TestChunksInRangePostgres(t *testing.T) {
+	testChunksInRange(t, testDatabase(t, true))
+}
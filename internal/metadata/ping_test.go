@@ -0,0 +1,31 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+// TestPingClosedDatabase checks Ping fails once the connection pool is
+// closed, with or without a server behind it
+func TestPingClosedDatabase(t *testing.T) {
+	conn, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := (&Database{db: conn}).Ping(context.Background()); err == nil {
+		t.Error("want an error pinging a closed database")
+	}
+}
+
+func TestPingPostgres(t *testing.T) {
+	d := testDatabase(t, false)
+	if err := d.Ping(context.Background()); err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	d.Close()
+	if err := d.Ping(context.Background()); err == nil {
+		t.Error("want an error pinging a closed database")
+	}
+}
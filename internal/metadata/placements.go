@@ -0,0 +1,74 @@
+package metadata
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FailedPlacement is a replicated chunk that was stored on fewer nodes than
+// its replication. It stays queued until retries bring it back up.
+type FailedPlacement struct {
+	ChunkHash     string     `json:"chunk_hash"`
+	IntendedNodes []string   `json:"intended_nodes"` // Nodes that should hold it but don't
+	Reason        string     `json:"reason"`         // Why the last attempt fell short
+	Attempts      int        `json:"attempts"`       // Retries so far
+	FailedAt      time.Time  `json:"failed_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+}
+
+// RecordFailedPlacement queues a chunk that couldn't be stored on all of
+// its nodes. A chunk already queued keeps its place and attempts; only the
+// nodes and reason are updated.
+func (d *Database) RecordFailedPlacement(chunkHash string, intendedNodes []string, reason string) error {
+	_, err := d.db.Exec(`
+		INSERT INTO failed_placements (chunk_hash, intended_nodes, reason)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (chunk_hash) DO UPDATE
+		SET intended_nodes = EXCLUDED.intended_nodes, reason = EXCLUDED.reason
+	`, chunkHash, pq.Array(intendedNodes), reason)
+	return err
+}
+
+// RecordPlacementAttempt notes a retry that still left a queued chunk short
+func (d *Database) RecordPlacementAttempt(chunkHash string, intendedNodes []string, reason string) error {
+	_, err := d.db.Exec(`
+		UPDATE failed_placements
+		SET intended_nodes = $2, reason = $3, attempts = attempts + 1, last_attempt_at = CURRENT_TIMESTAMP
+		WHERE chunk_hash = $1
+	`, chunkHash, pq.Array(intendedNodes), reason)
+	return err
+}
+
+// ListFailedPlacements returns every queued chunk, oldest failure first
+func (d *Database) ListFailedPlacements() ([]FailedPlacement, error) {
+	rows, err := d.db.Query(`
+		SELECT chunk_hash, intended_nodes, reason, attempts, failed_at, last_attempt_at
+		FROM failed_placements
+		ORDER BY failed_at, chunk_hash
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	placements := []FailedPlacement{}
+	for rows.Next() {
+		var p FailedPlacement
+		var nodes pq.StringArray
+		if err := rows.Scan(&p.ChunkHash, &nodes, &p.Reason, &p.Attempts, &p.FailedAt, &p.LastAttemptAt); err != nil {
+			return nil, err
+		}
+		p.IntendedNodes = nodes
+		placements = append(placements, p)
+	}
+
+	return placements, rows.Err()
+}
+
+// DeleteFailedPlacement takes a chunk off the queue. Deleting one that
+// isn't queued is a no-op.
+func (d *Database) DeleteFailedPlacement(chunkHash string) error {
+	_, err := d.db.Exec(`DELETE FROM failed_placements WHERE chunk_hash = $1`, chunkHash)
+	return err
+}
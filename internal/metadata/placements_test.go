@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// placementStore is the part of a metadata store that queues chunks short
+// of their replication
+type placementStore interface {
+	CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error)
+	ReleaseChunks(hashes []string) ([]ChunkRecord, error)
+	RecordFailedPlacement(chunkHash string, intendedNodes []string, reason string) error
+	RecordPlacementAttempt(chunkHash string, intendedNodes []string, reason string) error
+	ListFailedPlacements() ([]FailedPlacement, error)
+	DeleteFailedPlacement(chunkHash string) error
+}
+
+func listFailedPlacements(t *testing.T, store placementStore) []FailedPlacement {
+	t.Helper()
+
+	placements, err := store.ListFailedPlacements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return placements
+}
+
+// testFailedPlacements queues chunks, records retries against them and
+// dequeues them, checking the queue keeps each chunk's place and counts its
+// attempts, and forgets chunks whose records are deleted
+func testFailedPlacements(t *testing.T, store placementStore) {
+	if placements := listFailedPlacements(t, store); len(placements) != 0 {
+		t.Fatalf("want an empty queue, got %v", placements)
+	}
+
+	hashes := make([]string, 3)
+	for i := range hashes {
+		hashes[i] = strings.Repeat(fmt.Sprintf("%x", i+1), 64)
+		if _, err := store.CreateChunk(hashes[i], 1000, "distributed:node-1", 2, "sha256"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RecordFailedPlacement(hashes[i], []string{"node-2"}, "stored on 1 of 2 nodes"); err != nil {
+			t.Fatal(err)
+		}
+		// Distinct failure times, so the queue order is theirs
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := store.RecordFailedPlacement(strings.Repeat("f", 64), []string{"node-2"}, "unknown"); err == nil {
+		t.Error("queueing a chunk without a record: want an error")
+	}
+
+	placements := listFailedPlacements(t, store)
+	if len(placements) != len(hashes) {
+		t.Fatalf("want %d chunks queued, got %v", len(hashes), placements)
+	}
+	for i, placement := range placements {
+		if placement.ChunkHash != hashes[i] || !reflect.DeepEqual(placement.IntendedNodes, []string{"node-2"}) ||
+			placement.Reason != "stored on 1 of 2 nodes" || placement.Attempts != 0 || placement.LastAttemptAt != nil || placement.FailedAt.IsZero() {
+			t.Errorf("placement %d: want %s queued for node-2 with no attempts, got %+v", i, hashes[i][:8], placement)
+		}
+	}
+	first := placements[0]
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := store.RecordPlacementAttempt(hashes[0], []string{"node-3"}, "no healthy node accepted a copy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Queueing it again updates its nodes and reason, not its place
+	if err := store.RecordFailedPlacement(hashes[0], []string{"node-4"}, "stored on 1 of 2 nodes again"); err != nil {
+		t.Fatal(err)
+	}
+	placement := listFailedPlacements(t, store)[0]
+	if placement.ChunkHash != hashes[0] || placement.Attempts != 2 || placement.LastAttemptAt == nil ||
+		!placement.FailedAt.Equal(first.FailedAt) || !reflect.DeepEqual(placement.IntendedNodes, []string{"node-4"}) || placement.Reason != "stored on 1 of 2 nodes again" {
+		t.Errorf("want %s first, queued for node-4 after 2 attempts, got %+v", hashes[0][:8], placement)
+	}
+
+	if err := store.DeleteFailedPlacement(hashes[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteFailedPlacement(hashes[1]); err != nil {
+		t.Errorf("dequeueing a chunk not queued: want no error, got %v", err)
+	}
+	// Attempts at chunks no longer queued are ignored
+	if err := store.RecordPlacementAttempt(hashes[1], nil, "late"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReleaseChunks(hashes[2:]); err != nil {
+		t.Fatal(err)
+	}
+
+	placements = listFailedPlacements(t, store)
+	if len(placements) != 1 || placements[0].ChunkHash != hashes[0] {
+		t.Errorf("want only %s left queued, got %v", hashes[0][:8], placements)
+	}
+}
+
+func TestFailedPlacementsMemory(t *testing.T) {
+	testFailedPlacements(t, NewMemoryStore())
+}
+
+func TestFailedPlacementsPostgres(t *testing.T) {
+	testFailedPlacements(t, testDatabase(t, true))
+}
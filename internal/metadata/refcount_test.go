@@ -0,0 +1,119 @@
+package metadata
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// refCounter is the part of a metadata store that counts chunk references
+type refCounter interface {
+	CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error)
+	ReleaseChunks(hashes []string) ([]ChunkRecord, error)
+	GetChunk(chunkHash string) (*ChunkRecord, error)
+}
+
+const refCallers = 50
+
+// testConcurrentRefCounts adds and drops references to one new chunk from
+// many goroutines at once, and checks none is lost or counted twice
+func testConcurrentRefCounts(t *testing.T, store refCounter) {
+	var raw [32]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		t.Fatal(err)
+	}
+	hash := hex.EncodeToString(raw[:])
+
+	// Every caller references the chunk; exactly one creates it
+	var inserted atomic.Int32
+	run(t, refCallers, func() error {
+		isNew, err := store.CreateChunk(hash, 100, "local", 1, "sha256")
+		if isNew {
+			inserted.Add(1)
+		}
+		return err
+	})
+	if n := inserted.Load(); n != 1 {
+		t.Errorf("want 1 caller to create the chunk, got %d", n)
+	}
+	wantRefCount(t, store, hash, refCallers)
+
+	// Adding and dropping as many references at once leaves the count unchanged
+	run(t, 2*refCallers, func() func() error {
+		var calls atomic.Int32
+		return func() error {
+			if calls.Add(1)%2 == 0 {
+				_, err := store.ReleaseChunks([]string{hash})
+				return err
+			}
+			_, err := store.CreateChunk(hash, 100, "local", 1, "sha256")
+			return err
+		}
+	}())
+	wantRefCount(t, store, hash, refCallers)
+
+	// Dropping every reference removes the chunk, and reports it once
+	var orphaned atomic.Int32
+	run(t, refCallers, func() error {
+		released, err := store.ReleaseChunks([]string{hash})
+		orphaned.Add(int32(len(released)))
+		return err
+	})
+	if n := orphaned.Load(); n != 1 {
+		t.Errorf("want the chunk reported removed once, got %d", n)
+	}
+	if _, err := store.GetChunk(hash); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("want the unreferenced chunk removed, got %v", err)
+	}
+}
+
+// run calls fn from n goroutines at once and fails on any error
+func run(t *testing.T, n int, fn func() error) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := fn(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func wantRefCount(t *testing.T, store refCounter, hash string, want int) {
+	t.Helper()
+
+	chunk, err := store.GetChunk(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk.RefCount != want {
+		t.Errorf("want ref_count %d, got %d", want, chunk.RefCount)
+	}
+}
+
+func TestConcurrentRefCountsMemory(t *testing.T) {
+	testConcurrentRefCounts(t, NewMemoryStore())
+}
+
+// TestConcurrentRefCountsPostgres runs against the database at
+// TEST_DATABASE_URL and is skipped without one
+func TestConcurrentRefCountsPostgres(t *testing.T) {
+	testConcurrentRefCounts(t, testDatabase(t, true))
+}
@@ -0,0 +1,70 @@
+package metadata
+
+import "database/sql"
+
+// RekeyedFile holds a file's new encryption parameters and the chunks it
+// was re-encrypted into, in file order
+type RekeyedFile struct {
+	Salt         string
+	PasswordHash string
+	NoncePrefix  string
+	Chunks       []FileChunk // ChunkHash and Offset of each chunk
+}
+
+// RekeyFile switches an encrypted file to re-encrypted chunks and a new
+// salt in one transaction. The new chunks must already exist, each holding
+// a reference for this file. References to the old chunks are released and
+// the chunks left unreferenced are removed and returned.
+func (d *Database) RekeyFile(fileID string, rekey *RekeyedFile) ([]ChunkRecord, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Updating the row first also serializes concurrent rekeys of the file
+	result, err := tx.Exec(`
+		UPDATE files SET salt = $2, password_hash = $3, nonce_prefix = $4
+		WHERE file_id = $1 AND encrypted AND deleted_at IS NULL
+	`, fileID, rekey.Salt,
+		sql.NullString{String: rekey.PasswordHash, Valid: rekey.PasswordHash != ""},
+		sql.NullString{String: rekey.NoncePrefix, Valid: rekey.NoncePrefix != ""},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireAffected(result, ErrFileNotFound); err != nil {
+		return nil, err
+	}
+
+	// Deleting the old links releases their references
+	released, err := linkedChunks(tx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM file_chunks WHERE file_id = $1`, fileID); err != nil {
+		return nil, err
+	}
+
+	for i, chunk := range rekey.Chunks {
+		_, err := tx.Exec(`
+			INSERT INTO file_chunks (file_id, chunk_hash, chunk_order, start_offset)
+			VALUES ($1, $2, $3, $4)
+		`, fileID, chunk.ChunkHash, i, chunk.Offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	orphaned, err := d.deleteOrphanedChunks(tx, released)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return orphaned, nil
+}
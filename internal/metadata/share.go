@@ -0,0 +1,86 @@
+package metadata
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrShareNotFound = errors.New("share link not found")
+	ErrShareExpired  = errors.New("share link expired or download limit reached")
+)
+
+// ShareLink lets whoever holds its token download one file. Only the
+// token's hash is stored.
+type ShareLink struct {
+	TokenHash      string
+	FileID         string
+	SealedPassword []byte     // The file's password, sealed with a key derived from the token; nil if not embedded
+	ExpiresAt      *time.Time // nil for links that never expire
+	MaxDownloads   int        // 0 for no limit
+	Downloads      int
+	CreatedAt      time.Time
+}
+
+// CreateShareLink stores a new share link for a file that isn't in the trash
+func (d *Database) CreateShareLink(link *ShareLink) error {
+	var maxDownloads sql.NullInt64
+	if link.MaxDownloads > 0 {
+		maxDownloads = sql.NullInt64{Int64: int64(link.MaxDownloads), Valid: true}
+	}
+
+	err := d.db.QueryRow(`
+		INSERT INTO share_links (token_hash, file_id, sealed_password, expires_at, max_downloads)
+		SELECT $1, file_id, $3, $4, $5
+		FROM files
+		WHERE file_id = $2 AND deleted_at IS NULL
+		RETURNING created_at
+	`, link.TokenHash, link.FileID, link.SealedPassword, link.ExpiresAt, maxDownloads).Scan(&link.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ErrFileNotFound
+	}
+	return err
+}
+
+// UseShareLink counts a download against a share link and returns it. It
+// fails with ErrShareExpired, without counting, once the link has expired
+// or reached its download limit.
+func (d *Database) UseShareLink(tokenHash string) (*ShareLink, error) {
+	link := &ShareLink{TokenHash: tokenHash}
+	var maxDownloads sql.NullInt64
+
+	err := d.db.QueryRow(`
+		UPDATE share_links SET downloads = downloads + 1
+		WHERE token_hash = $1
+			AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+			AND (max_downloads IS NULL OR downloads < max_downloads)
+		RETURNING file_id, sealed_password, expires_at, max_downloads, downloads, created_at
+	`, tokenHash).Scan(&link.FileID, &link.SealedPassword, &link.ExpiresAt, &maxDownloads, &link.Downloads, &link.CreatedAt)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := d.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM share_links WHERE token_hash = $1)`, tokenHash).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrShareExpired
+		}
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	link.MaxDownloads = int(maxDownloads.Int64)
+	return link, nil
+}
+
+// DeleteShareLink revokes a share link
+func (d *Database) DeleteShareLink(tokenHash string) error {
+	result, err := d.db.Exec(`DELETE FROM share_links WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result, ErrShareNotFound)
+}
@@ -0,0 +1,90 @@
+package metadata
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// shareStore is the part of a metadata store that keeps share links
+type shareStore interface {
+	CreateFile(file *FileRecord) error
+	SoftDeleteFile(fileID string) error
+	CreateShareLink(link *ShareLink) error
+	UseShareLink(tokenHash string) (*ShareLink, error)
+	DeleteShareLink(tokenHash string) error
+}
+
+// testShareLinks checks links stop working once expired or used up, without
+// counting refused downloads, and can't be made for a file in the trash
+func testShareLinks(t *testing.T, store shareStore) {
+	file := &FileRecord{FileID: "e0000000-0000-0000-0000-000000000001", FileName: "shared.bin"}
+	if err := store.CreateFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	for _, link := range []*ShareLink{
+		{TokenHash: "limited", FileID: file.FileID, MaxDownloads: 2},
+		{TokenHash: "expired", FileID: file.FileID, ExpiresAt: &past},
+		{TokenHash: "unlimited", FileID: file.FileID, ExpiresAt: &future, SealedPassword: []byte("sealed")},
+	} {
+		if err := store.CreateShareLink(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 1; i <= 2; i++ {
+		link, err := store.UseShareLink("limited")
+		if err != nil {
+			t.Fatalf("download %d: %v", i, err)
+		}
+		if link.FileID != file.FileID || link.Downloads != i || link.MaxDownloads != 2 {
+			t.Errorf("download %d: want %d of 2 downloads counted, got %+v", i, i, link)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := store.UseShareLink("limited"); !errors.Is(err, ErrShareExpired) {
+			t.Errorf("past the limit: want ErrShareExpired, got %v", err)
+		}
+	}
+
+	if _, err := store.UseShareLink("expired"); !errors.Is(err, ErrShareExpired) {
+		t.Errorf("expired link: want ErrShareExpired, got %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		link, err := store.UseShareLink("unlimited")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link.Downloads != i || string(link.SealedPassword) != "sealed" || link.ExpiresAt == nil {
+			t.Errorf("download %d: got %+v", i, link)
+		}
+	}
+
+	if err := store.DeleteShareLink("unlimited"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UseShareLink("unlimited"); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("revoked link: want ErrShareNotFound, got %v", err)
+	}
+	if err := store.DeleteShareLink("unlimited"); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("revoking twice: want ErrShareNotFound, got %v", err)
+	}
+
+	if err := store.SoftDeleteFile(file.FileID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateShareLink(&ShareLink{TokenHash: "trashed", FileID: file.FileID}); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("file in the trash: want ErrFileNotFound, got %v", err)
+	}
+}
+
+func TestShareLinksMemory(t *testing.T) {
+	testShareLinks(t, NewMemoryStore())
+}
+
+func TestShareLinksPostgres(t *testing.T) {
+	testShareLinks(t, testDatabase(t, true))
+}
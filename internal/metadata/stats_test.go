@@ -0,0 +1,74 @@
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// statsStore is the part of a metadata store that reports dedup statistics
+type statsStore interface {
+	CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error)
+	GetStats() (map[string]interface{}, error)
+}
+
+// testStats checks the statistics of an empty store are all zero, then
+// stores chunks with known sizes and reference counts and checks the math
+func testStats(t *testing.T, store statsStore) {
+	stats, err := store.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := map[string]interface{}{
+		"unique_chunks":     0,
+		"total_references":  0,
+		"storage_used":      int64(0),
+		"space_saved":       int64(0),
+		"dedup_ratio":       0.0,
+		"logical_bytes":     int64(0),
+		"physical_bytes":    int64(0),
+		"compression_ratio": 0.0,
+		"savings_ratio":     0.0,
+	}
+	if !reflect.DeepEqual(stats, empty) {
+		t.Errorf("empty store: want %v, got %v", empty, stats)
+	}
+
+	// Each reference past the first is another file sharing the chunk
+	for i, chunk := range []struct{ size, refs int }{{1000, 3}, {500, 1}, {2000, 2}} {
+		hash := strings.Repeat(fmt.Sprintf("%x", i+1), 64)
+		for range chunk.refs {
+			if _, err := store.CreateChunk(hash, chunk.size, "local", 1, "sha256"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	stats, err = store.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"unique_chunks":     3,
+		"total_references":  6,
+		"storage_used":      int64(3500),
+		"space_saved":       int64(4000),
+		"dedup_ratio":       2.0,
+		"logical_bytes":     int64(3*1000 + 500 + 2*2000),
+		"physical_bytes":    int64(3500),
+		"compression_ratio": 1.0,
+		"savings_ratio":     1 - 3500.0/7500.0,
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("want %v, got %v", want, stats)
+	}
+}
+
+func TestStatsMemory(t *testing.T) {
+	testStats(t, NewMemoryStore())
+}
+
+func TestStatsPostgres(t *testing.T) {
+	testStats(t, testDatabase(t, true))
+}
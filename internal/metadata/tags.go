@@ -0,0 +1,146 @@
+package metadata
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// SetFileTags replaces all tags of a file that isn't in the trash
+func (d *Database) SetFileTags(fileID string, tags map[string]string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the file so it can't be trashed or purged halfway through
+	var locked string
+	err = tx.QueryRow(`SELECT file_id FROM files WHERE file_id = $1 AND deleted_at IS NULL FOR UPDATE`, fileID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return ErrFileNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM file_tags WHERE file_id = $1`, fileID); err != nil {
+		return err
+	}
+	if err := insertFileTags(tx, fileID, tags); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertFileTags adds tags to a file that has none with the same keys
+func insertFileTags(tx *sql.Tx, fileID string, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	keys, values := splitTags(tags)
+	_, err := tx.Exec(`
+		INSERT INTO file_tags (file_id, tag_key, tag_value)
+		SELECT $1, k, v FROM unnest($2::text[], $3::text[]) AS t(k, v)
+	`, fileID, pq.Array(keys), pq.Array(values))
+	return err
+}
+
+// GetFileTags returns the tags of a file that isn't in the trash, empty if
+// it has none
+func (d *Database) GetFileTags(fileID string) (map[string]string, error) {
+	if _, err := d.GetFile(fileID); err != nil {
+		return nil, err
+	}
+
+	tags, err := d.loadTags([]string{fileID})
+	if err != nil {
+		return nil, err
+	}
+	if tags[fileID] == nil {
+		return map[string]string{}, nil
+	}
+	return tags[fileID], nil
+}
+
+// FindFilesByTags returns the unexpired files outside the trash that carry
+// every one of the given tags, newest first, with all their tags
+func (d *Database) FindFilesByTags(tags map[string]string) ([]FileRecord, error) {
+	keys, values := splitTags(tags)
+	files, err := d.queryFiles(`SELECT `+fileColumns+`
+		FROM files
+		WHERE deleted_at IS NULL AND `+notExpired+` AND file_id IN (
+			SELECT t.file_id
+			FROM file_tags t
+			JOIN unnest($1::text[], $2::text[]) AS f(k, v) ON t.tag_key = f.k AND t.tag_value = f.v
+			GROUP BY t.file_id
+			HAVING COUNT(*) = $3
+		)
+		ORDER BY uploaded_at DESC
+	`, pq.Array(keys), pq.Array(values), len(tags))
+	if err != nil {
+		return nil, err
+	}
+
+	return files, d.attachTags(files)
+}
+
+// attachTags fills in the Tags of files
+func (d *Database) attachTags(files []FileRecord) error {
+	ids := make([]string, len(files))
+	for i, file := range files {
+		ids[i] = file.FileID
+	}
+
+	tags, err := d.loadTags(ids)
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		files[i].Tags = tags[files[i].FileID]
+	}
+	return nil
+}
+
+// loadTags returns the tags of the given files, keyed by file ID. Files
+// without tags are left out.
+func (d *Database) loadTags(fileIDs []string) (map[string]map[string]string, error) {
+	tags := make(map[string]map[string]string)
+	if len(fileIDs) == 0 {
+		return tags, nil
+	}
+
+	rows, err := d.db.Query(`
+		SELECT file_id, tag_key, tag_value FROM file_tags WHERE file_id = ANY($1::uuid[])
+	`, pq.Array(fileIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fileID, key, value string
+		if err := rows.Scan(&fileID, &key, &value); err != nil {
+			return nil, err
+		}
+		if tags[fileID] == nil {
+			tags[fileID] = make(map[string]string)
+		}
+		tags[fileID][key] = value
+	}
+
+	return tags, rows.Err()
+}
+
+// splitTags returns tags as parallel key and value slices for unnest
+func splitTags(tags map[string]string) ([]string, []string) {
+	keys := make([]string, 0, len(tags))
+	values := make([]string, 0, len(tags))
+	for key, value := range tags {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values
+}
@@ -0,0 +1,129 @@
+package metadata
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// tagStore is the part of a metadata store that keeps file tags
+type tagStore interface {
+	CreateFile(file *FileRecord) error
+	SoftDeleteFile(fileID string) error
+	SetFileTags(fileID string, tags map[string]string) error
+	GetFileTags(fileID string) (map[string]string, error)
+	FindFilesByTags(tags map[string]string) ([]FileRecord, error)
+}
+
+// foundFiles returns the IDs of the files carrying every one of tags,
+// sorted, checking each came back with all of its tags
+func foundFiles(t *testing.T, store tagStore, tags map[string]string) []string {
+	t.Helper()
+
+	files, err := store.FindFilesByTags(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileIDs := []string{}
+	for _, file := range files {
+		stored, err := store.GetFileTags(file.FileID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(file.Tags, stored) {
+			t.Errorf("file %s found with tags %v, has %v", file.FileID, file.Tags, stored)
+		}
+		fileIDs = append(fileIDs, file.FileID)
+	}
+	sort.Strings(fileIDs)
+	return fileIDs
+}
+
+// testTags tags files on creation and afterwards, and checks searches match
+// only files carrying every tag asked for
+func testTags(t *testing.T, store tagStore) {
+	files := map[string]map[string]string{
+		"a0000000-0000-0000-0000-000000000001": {"project": "apollo", "owner": "alice", "type": "report"},
+		"a0000000-0000-0000-0000-000000000002": {"project": "apollo", "owner": "bob"},
+		"a0000000-0000-0000-0000-000000000003": {"project": "gemini", "owner": "alice", "note": "a:b"},
+		"a0000000-0000-0000-0000-000000000004": nil,
+	}
+	for fileID, tags := range files {
+		if err := store.CreateFile(&FileRecord{FileID: fileID, FileName: fileID + ".bin", Tags: tags}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, second, third, untagged := "a0000000-0000-0000-0000-000000000001", "a0000000-0000-0000-0000-000000000002", "a0000000-0000-0000-0000-000000000003", "a0000000-0000-0000-0000-000000000004"
+
+	for fileID, want := range files {
+		if want == nil {
+			want = map[string]string{}
+		}
+		if got, err := store.GetFileTags(fileID); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("file %s: want tags %v, got %v (%v)", fileID, want, got, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		filter map[string]string
+		want   []string
+	}{
+		{map[string]string{"project": "apollo"}, []string{first, second}},
+		{map[string]string{"owner": "alice"}, []string{first, third}},
+		{map[string]string{"project": "apollo", "owner": "alice"}, []string{first}},
+		{map[string]string{"project": "gemini", "owner": "bob"}, []string{}},
+		{map[string]string{"note": "a:b"}, []string{third}},
+		{map[string]string{"project": "mercury"}, []string{}},
+	} {
+		if got := foundFiles(t, store, tc.filter); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("tags %v: want %v, got %v", tc.filter, tc.want, got)
+		}
+	}
+
+	// Setting tags replaces all of them
+	if err := store.SetFileTags(second, map[string]string{"project": "gemini", "owner": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetFileTags(untagged, map[string]string{"project": "apollo"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := foundFiles(t, store, map[string]string{"owner": "bob"}); len(got) != 0 {
+		t.Errorf("want replaced tags gone, found %v", got)
+	}
+	if got := foundFiles(t, store, map[string]string{"project": "apollo"}); !reflect.DeepEqual(got, []string{first, untagged}) {
+		t.Errorf("after retagging, want %v, got %v", []string{first, untagged}, got)
+	}
+	if got := foundFiles(t, store, map[string]string{"project": "gemini", "owner": "alice"}); !reflect.DeepEqual(got, []string{second, third}) {
+		t.Errorf("after retagging, want %v, got %v", []string{second, third}, got)
+	}
+
+	if err := store.SetFileTags(first, map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.GetFileTags(first); err != nil || len(got) != 0 {
+		t.Errorf("want all tags cleared, got %v (%v)", got, err)
+	}
+
+	// Files in the trash are neither found nor retagged
+	if err := store.SoftDeleteFile(third); err != nil {
+		t.Fatal(err)
+	}
+	if got := foundFiles(t, store, map[string]string{"owner": "alice"}); !reflect.DeepEqual(got, []string{second}) {
+		t.Errorf("want the trashed file left out, got %v", got)
+	}
+	if err := store.SetFileTags(third, map[string]string{"owner": "carol"}); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("trashed file: want ErrFileNotFound, got %v", err)
+	}
+	if _, err := store.GetFileTags("a0000000-0000-0000-0000-000000000009"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("unknown file: want ErrFileNotFound, got %v", err)
+	}
+}
+
+func TestTagsMemory(t *testing.T) {
+	testTags(t, NewMemoryStore())
+}
+
+func TestTagsPostgres(t *testing.T) {
+	testTags(t, testDatabase(t, true))
+}
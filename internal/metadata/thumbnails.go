@@ -0,0 +1,38 @@
+package metadata
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrThumbnailNotFound = errors.New("thumbnail not found")
+
+// SetThumbnail stores the thumbnail of a file that isn't in the trash,
+// replacing any it had
+func (d *Database) SetThumbnail(fileID string, data []byte) error {
+	result, err := d.db.Exec(`
+		INSERT INTO file_thumbnails (file_id, data)
+		SELECT file_id, $2 FROM files WHERE file_id = $1 AND deleted_at IS NULL
+		ON CONFLICT (file_id) DO UPDATE SET data = EXCLUDED.data, created_at = CURRENT_TIMESTAMP
+	`, fileID, data)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result, ErrFileNotFound)
+}
+
+// GetThumbnail returns the thumbnail of a file that isn't in the trash
+func (d *Database) GetThumbnail(fileID string) ([]byte, error) {
+	var data []byte
+	err := d.db.QueryRow(`
+		SELECT t.data
+		FROM file_thumbnails t
+		JOIN files f ON f.file_id = t.file_id
+		WHERE t.file_id = $1 AND f.deleted_at IS NULL
+	`, fileID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrThumbnailNotFound
+	}
+	return data, err
+}
@@ -0,0 +1,232 @@
+package metadata
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SoftDeleteFile moves a file to the trash. Its chunks stay referenced
+// until the file is purged.
+func (d *Database) SoftDeleteFile(fileID string) error {
+	result, err := d.db.Exec(`
+		UPDATE files SET deleted_at = CURRENT_TIMESTAMP
+		WHERE file_id = $1 AND deleted_at IS NULL
+	`, fileID)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result, ErrFileNotFound)
+}
+
+// RestoreFile takes a file back out of the trash
+func (d *Database) RestoreFile(fileID string) error {
+	result, err := d.db.Exec(`
+		UPDATE files SET deleted_at = NULL
+		WHERE file_id = $1 AND deleted_at IS NOT NULL
+	`, fileID)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result, ErrFileNotFound)
+}
+
+// ListDeletedFiles returns the files in the trash, most recently deleted first
+func (d *Database) ListDeletedFiles() ([]FileRecord, error) {
+	return d.queryFiles(`SELECT ` + fileColumns + `
+		FROM files
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+	`)
+}
+
+// ListFilesDeletedBefore returns trashed files deleted before the cutoff
+func (d *Database) ListFilesDeletedBefore(cutoff time.Time) ([]FileRecord, error) {
+	return d.queryFiles(`SELECT `+fileColumns+`
+		FROM files
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		ORDER BY deleted_at ASC
+	`, cutoff)
+}
+
+// PurgeFile permanently deletes a file, releases one reference for every
+// chunk link it held, and removes chunks that are no longer referenced.
+// The removed chunk records are returned so their data can be deleted.
+func (d *Database) PurgeFile(fileID string) ([]ChunkRecord, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The cascade to file_chunks releases the references; note which
+	// chunks they were first
+	released, err := linkedChunks(tx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(`DELETE FROM files WHERE file_id = $1`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireAffected(result, ErrFileNotFound); err != nil {
+		return nil, err
+	}
+
+	orphaned, err := d.deleteOrphanedChunks(tx, released)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return orphaned, nil
+}
+
+// ReleaseChunks drops one reference for each hash given, once per time it
+// appears, and removes chunks that are no longer referenced. It undoes the
+// references an upload took before it was abandoned. The removed chunk
+// records are returned so their data can be deleted.
+func (d *Database) ReleaseChunks(hashes []string) ([]ChunkRecord, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		WITH released AS (
+			SELECT hash, COUNT(*) AS refs
+			FROM unnest($1::text[]) AS hash
+			GROUP BY hash
+		)
+		UPDATE chunks c
+		SET ref_count = c.ref_count - r.refs
+		FROM released r
+		WHERE c.chunk_hash = r.hash
+	`, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+
+	orphaned, err := d.deleteOrphanedChunks(tx, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return orphaned, nil
+}
+
+// linkedChunks returns the hashes of the chunks a file links to. Deleting
+// the links releases one reference for each (the release_chunk_references
+// trigger), after which these are the chunks that may be unreferenced.
+func linkedChunks(tx *sql.Tx, fileID string) ([]string, error) {
+	rows, err := tx.Query(`SELECT DISTINCT chunk_hash FROM file_chunks WHERE file_id = $1`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var released []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		released = append(released, hash)
+	}
+
+	return released, rows.Err()
+}
+
+// deleteOrphanedChunks removes the given chunks if nothing references them
+// anymore and returns the removed records
+func (d *Database) deleteOrphanedChunks(tx *sql.Tx, hashes []string) ([]ChunkRecord, error) {
+	rows, err := tx.Query(`
+		DELETE FROM chunks
+		WHERE chunk_hash = ANY($1) AND ref_count <= 0
+		RETURNING chunk_hash, chunk_size, ref_count, storage_path,
+			ARRAY(SELECT s.shard_hash FROM chunk_shards s WHERE s.chunk_hash = chunks.chunk_hash)
+	`, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+
+	var orphaned []ChunkRecord
+	for rows.Next() {
+		var chunk ChunkRecord
+		var shards pq.StringArray
+		if err := rows.Scan(&chunk.ChunkHash, &chunk.ChunkSize, &chunk.RefCount, &chunk.StoragePath, &shards); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		chunk.ShardHashes = shards
+		orphaned = append(orphaned, chunk)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := d.dropSharedShards(tx, orphaned); err != nil {
+		return nil, err
+	}
+
+	return orphaned, nil
+}
+
+// dropSharedShards removes shard hashes that are still stored for another
+// chunk, so purging one chunk never deletes data another chunk depends on
+func (d *Database) dropSharedShards(tx *sql.Tx, orphaned []ChunkRecord) error {
+	var hashes []string
+	for _, chunk := range orphaned {
+		hashes = append(hashes, chunk.ShardHashes...)
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT shard_hash FROM chunk_shards WHERE shard_hash = ANY($1)
+		UNION
+		SELECT chunk_hash FROM chunks WHERE chunk_hash = ANY($1)
+	`, pq.Array(hashes))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	shared := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return err
+		}
+		shared[hash] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range orphaned {
+		var kept []string
+		for _, hash := range orphaned[i].ShardHashes {
+			if !shared[hash] {
+				kept = append(kept, hash)
+			}
+		}
+		orphaned[i].ShardHashes = kept
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package metadata
+
+// GetLatestFileVersion returns the newest version of a file that is neither
+// in the trash nor expired
+func (d *Database) GetLatestFileVersion(fileName string) (*FileRecord, error) {
+	files, err := d.queryFiles(`SELECT `+fileColumns+`
+		FROM files
+		WHERE file_name = $1 AND deleted_at IS NULL AND `+notExpired+`
+		ORDER BY version DESC
+		LIMIT 1
+	`, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrFileNotFound
+	}
+	return &files[0], nil
+}
+
+// GetFileVersion returns a specific version of a file, even if it has
+// expired
+func (d *Database) GetFileVersion(fileName string, version int) (*FileRecord, error) {
+	files, err := d.queryFiles(`SELECT `+fileColumns+`
+		FROM files
+		WHERE file_name = $1 AND version = $2 AND deleted_at IS NULL
+	`, fileName, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrFileNotFound
+	}
+	return &files[0], nil
+}
+
+// ListFileVersions returns every version of a file that is neither in the
+// trash nor expired, newest first
+func (d *Database) ListFileVersions(fileName string) ([]FileRecord, error) {
+	return d.queryFiles(`SELECT `+fileColumns+`
+		FROM files
+		WHERE file_name = $1 AND deleted_at IS NULL AND `+notExpired+`
+		ORDER BY version DESC
+	`, fileName)
+}
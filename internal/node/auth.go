@@ -0,0 +1,92 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+)
+
+// requireClusterSecret rejects requests that do not carry the cluster secret,
+// or, with a TLS CA configured, a client certificate signed by it. /health
+// stays open so the coordinator's probes and load balancers work, and
+// /version so a build can be identified without credentials. Chunk PUTs
+// come from clients, so with a cluster secret they are authorized by a
+// chunk grant signed with it instead.
+func (sn *StorageNode) requireClusterSecret(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/version" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if sn.ClusterSecret != "" && r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/chunk/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if sn.TLS.CAFile != "" && !auth.VerifiedPeer(r) {
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		if sn.ClusterSecret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !auth.Equal(auth.BearerToken(r), sn.ClusterSecret) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// postToCoordinator sends a JSON request to the coordinator, authenticating
+// with the cluster secret and TLS client certificate when configured.
+// CoordinatorAddr may list several coordinators, comma-separated, of which
+// one leads and the rest stand by. The request goes to the last one that
+// answered; if it can't be reached or answers 503, as a standby does, the
+// others are tried in turn.
+func (sn *StorageNode) postToCoordinator(path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := strings.Split(sn.CoordinatorAddr, ",")
+	first := int(sn.coordinator.Load()) % len(addrs)
+
+	var resp *http.Response
+	for i := range addrs {
+		current := (first + i) % len(addrs)
+		resp, err = sn.postTo(strings.TrimSpace(addrs[current]), path, data)
+		if err == nil && resp.StatusCode != http.StatusServiceUnavailable {
+			sn.coordinator.Store(int32(current))
+			return resp, nil
+		}
+		if err == nil && i < len(addrs)-1 {
+			resp.Body.Close()
+		}
+	}
+	// Every coordinator failed; the last one's answer says how
+	return resp, err
+}
+
+// postTo sends an encoded JSON request to one coordinator
+func (sn *StorageNode) postTo(addr, path string, data []byte) (*http.Response, error) {
+	url := fmt.Sprintf("%s://%s%s", sn.TLS.Scheme(), addr, path)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	auth.SetBearer(req, sn.ClusterSecret)
+
+	return sn.client.Do(req)
+}
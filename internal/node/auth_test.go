@@ -0,0 +1,169 @@
+package node
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+)
+
+// TestClusterSecret checks a node with a cluster secret turns away calls
+// without it, except health and version checks
+func TestClusterSecret(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		secret string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"no secret configured", "", http.MethodPost, "/store", "", http.StatusOK},
+		{"missing secret", "secret", http.MethodPost, "/store", "", http.StatusUnauthorized},
+		{"wrong secret", "secret", http.MethodPost, "/store", "other", http.StatusUnauthorized},
+		{"right secret", "secret", http.MethodPost, "/store", "secret", http.StatusOK},
+		{"retrieve needs it too", "secret", http.MethodGet, "/retrieve/abc", "", http.StatusUnauthorized},
+		{"health is public", "secret", http.MethodGet, "/health", "", http.StatusOK},
+		{"version is public", "secret", http.MethodGet, "/version", "", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sn := newTestNode(t)
+			sn.ClusterSecret = tc.secret
+			handler := sn.requireClusterSecret(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.want {
+				t.Errorf("want %d, got %d", tc.want, rec.Code)
+			}
+		})
+	}
+}
+
+// testCoordinator answers as the leader while leading is set and as a
+// standby otherwise, counting the requests it receives
+type testCoordinator struct {
+	addr     string
+	leading  atomic.Bool
+	requests atomic.Int32
+}
+
+func newTestCoordinator(t *testing.T, leading bool) *testCoordinator {
+	t.Helper()
+
+	c := &testCoordinator{}
+	c.leading.Store(leading)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.requests.Add(1)
+		if !c.leading.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	c.addr = strings.TrimPrefix(server.URL, "http://")
+	return c
+}
+
+// TestCoordinatorFailover checks a node given several coordinators sends to
+// the one leading, skipping any it can't reach or that stand by, sticks with
+// it while it leads, and moves on when another takes over
+func TestCoordinatorFailover(t *testing.T) {
+	sn := newTestNode(t)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	standby, leader := newTestCoordinator(t, false), newTestCoordinator(t, true)
+	sn.CoordinatorAddr = strings.Join([]string{strings.TrimPrefix(down.URL, "http://"), standby.addr, leader.addr}, ", ")
+
+	for i := range 3 {
+		if err := sn.sendHeartbeat(); err != nil {
+			t.Fatalf("heartbeat %d: %v", i, err)
+		}
+	}
+	if leader.requests.Load() != 3 || standby.requests.Load() != 1 {
+		t.Errorf("want every heartbeat at the leader and only the first trying the standby, got %d and %d",
+			leader.requests.Load(), standby.requests.Load())
+	}
+
+	// The standby takes over
+	leader.leading.Store(false)
+	standby.leading.Store(true)
+	if err := sn.sendHeartbeat(); err != nil {
+		t.Fatalf("heartbeat after failover: %v", err)
+	}
+	if err := sn.sendHeartbeat(); err != nil {
+		t.Fatalf("heartbeat after failover: %v", err)
+	}
+	if standby.requests.Load() != 3 || leader.requests.Load() != 4 {
+		t.Errorf("want heartbeats at the new leader once the old one stood by, got %d at it and %d at the old leader",
+			standby.requests.Load(), leader.requests.Load())
+	}
+
+	standby.leading.Store(false)
+	if err := sn.sendHeartbeat(); err == nil {
+		t.Error("no coordinator leading: want the heartbeat to fail")
+	}
+}
+
+// TestPutChunkGrant checks a node with a cluster secret takes a chunk PUT
+// straight from a client only with an unexpired grant for that chunk, and
+// only when the data matches its hash
+func TestPutChunkGrant(t *testing.T) {
+	const secret = "cluster-secret"
+	sn := newTestNode(t)
+	sn.ClusterSecret = secret
+	client := startTestNode(t, sn)
+	data, hash := testChunk(t, 1000)
+	other, otherHash := testChunk(t, 1000)
+	expires := time.Now().Add(time.Minute)
+
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		grant string
+		want  int
+	}{
+		{"no grant", data, "", http.StatusForbidden},
+		{"cluster secret", data, secret, http.StatusForbidden},
+		{"grant for another chunk", data, auth.SignChunkGrant(secret, otherHash, expires), http.StatusForbidden},
+		{"grant signed with another secret", data, auth.SignChunkGrant("other-secret", hash, expires), http.StatusForbidden},
+		{"expired grant", data, auth.SignChunkGrant(secret, hash, time.Now().Add(-time.Minute)), http.StatusForbidden},
+		{"data not matching the hash", other, auth.SignChunkGrant(secret, hash, expires), http.StatusBadRequest},
+		{"valid grant", data, auth.SignChunkGrant(secret, hash, expires), http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, "http://"+sn.Address+"/chunk/"+hash, bytes.NewReader(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.grant != "" {
+				req.Header.Set(GrantHeader, tc.grant)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Errorf("want %d, got %s", tc.want, resp.Status)
+			}
+			if stored := sn.hasChunk(hash); stored != (tc.want == http.StatusOK) {
+				t.Errorf("want chunk stored %v, got %v", tc.want == http.StatusOK, stored)
+			}
+		})
+	}
+
+	// Grants only open PUTs; reads still need the secret
+	if _, err := client.Retrieve(t.Context(), hash); err == nil {
+		t.Error("read without the cluster secret: want an error")
+	}
+}
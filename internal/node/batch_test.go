@@ -0,0 +1,114 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// newTestServer serves sn's store endpoints and returns a client for them
+func newTestServer(t testing.TB, sn *StorageNode) *NodeClient {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /store", sn.storeChunkHandler)
+	mux.HandleFunc("POST /store/batch", sn.batchStoreHandler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return NewNodeClient(server.Client(), server.URL)
+}
+
+// TestBatchStoreReportsEachChunk stores a batch with one chunk the node
+// rejects and checks the others are stored and each result is reported
+func TestBatchStoreReportsEachChunk(t *testing.T) {
+	sn := newTestNode(t)
+	client := newTestServer(t, sn)
+
+	first, firstHash := testChunk(t, 1000)
+	second, secondHash := testChunk(t, 2000)
+	batch := []StoreChunkRequest{
+		{ChunkHash: firstHash, ChunkData: first},
+		{ChunkHash: "x", ChunkData: []byte("invalid hash")},
+		{ChunkHash: secondHash, ChunkData: second},
+	}
+
+	resp, err := client.StoreBatch(context.Background(), batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != len(batch) {
+		t.Fatalf("want %d results, got %d", len(batch), len(resp.Results))
+	}
+
+	for i, want := range []bool{true, false, true} {
+		result := resp.Results[i]
+		if result.ChunkHash != batch[i].ChunkHash {
+			t.Errorf("result %d: want chunk %q, got %q", i, batch[i].ChunkHash, result.ChunkHash)
+		}
+		if result.Success != want {
+			t.Errorf("result %d: want success %v, got %v (%s)", i, want, result.Success, result.Error)
+		}
+		if !want && result.Error == "" {
+			t.Errorf("result %d: want the failure explained", i)
+		}
+	}
+
+	for _, hash := range []string{firstHash, secondHash} {
+		if _, err := sn.readChunk(hash); err != nil {
+			t.Errorf("chunk %s not stored: %v", hash[:8], err)
+		}
+	}
+}
+
+// BenchmarkStore stores a file's worth of small chunks one request per
+// chunk, then in a single batch. Chunks aren't fsynced, so the difference
+// is the per-request overhead.
+func BenchmarkStore(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	const chunks, chunkSize = 256, 4 << 10
+
+	for _, batched := range []bool{false, true} {
+		name := "single"
+		if batched {
+			name = "batch"
+		}
+		b.Run(fmt.Sprintf("%s/%d-chunks", name, chunks), func(b *testing.B) {
+			sn := NewStorageNode("node-1", "", b.TempDir(), "")
+			sn.Durability = DurabilityNone
+			client := newTestServer(b, sn)
+			ctx := context.Background()
+
+			b.SetBytes(chunks * chunkSize)
+			for i := 0; i < b.N; i++ {
+				// Fresh chunks each round, so none is already stored
+				b.StopTimer()
+				batch := make([]StoreChunkRequest, chunks)
+				for j := range batch {
+					data, hash := testChunk(b, chunkSize)
+					batch[j] = StoreChunkRequest{ChunkHash: hash, ChunkData: data}
+				}
+				b.StartTimer()
+
+				if batched {
+					if _, err := client.StoreBatch(ctx, batch); err != nil {
+						b.Fatal(err)
+					}
+					continue
+				}
+				for _, req := range batch {
+					if err := client.Store(ctx, req.ChunkHash, req.ChunkData); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// NodeClient speaks a storage node's HTTP API. It holds no connections of
+// its own, so it is cheap to create per request around a shared http.Client.
+type NodeClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewNodeClient returns a client for the node at baseURL (for example
+// "http://localhost:9001"), sending requests through client
+func NewNodeClient(client *http.Client, baseURL string) *NodeClient {
+	return &NodeClient{baseURL: baseURL, client: client}
+}
+
+// Store sends one chunk to the node
+func (c *NodeClient) Store(ctx context.Context, chunkHash string, chunkData []byte) error {
+	resp, err := c.postJSON(ctx, "/store", StoreChunkRequest{
+		ChunkHash: chunkHash,
+		ChunkData: chunkData,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node returned %s", resp.Status)
+	}
+
+	var storeResp StoreChunkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&storeResp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if !storeResp.Success {
+		return fmt.Errorf("node rejected chunk: %s", storeResp.Error)
+	}
+
+	return nil
+}
+
+// StoreBatch sends several chunks to the node in one request. The response
+// reports each chunk's outcome.
+func (c *NodeClient) StoreBatch(ctx context.Context, batch []StoreChunkRequest) (*BatchStoreResponse, error) {
+	resp, err := c.postJSON(ctx, "/store/batch", BatchStoreRequest{Chunks: batch})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node returned %s", resp.Status)
+	}
+
+	var batchResp BatchStoreResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batchResp); err != nil {
+		return nil, err
+	}
+	return &batchResp, nil
+}
+
+// Retrieve fetches a chunk from the node
+func (c *NodeClient) Retrieve(ctx context.Context, chunkHash string) ([]byte, error) {
+	resp, err := c.get(ctx, "/retrieve/"+chunkHash)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node returned %s", resp.Status)
+	}
+
+	var retrieveResp RetrieveChunkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&retrieveResp); err != nil {
+		return nil, err
+	}
+	if !retrieveResp.Success {
+		return nil, fmt.Errorf("node failed to retrieve chunk: %s", retrieveResp.Error)
+	}
+
+	return retrieveResp.ChunkData, nil
+}
+
+// Open streams a chunk's raw bytes from the node, without the JSON and
+// base64 that Retrieve decodes. The caller closes the stream. The size is -1
+// if the node didn't send one.
+func (c *NodeClient) Open(ctx context.Context, chunkHash string) (io.ReadCloser, int64, error) {
+	resp, err := c.get(ctx, "/chunk/"+chunkHash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("node returned %s", resp.Status)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Exists reports whether the node holds a chunk. The HTTP API has no
+// cheaper check than serving the chunk, so its data is read and dropped.
+func (c *NodeClient) Exists(ctx context.Context, chunkHash string) (bool, error) {
+	resp, err := c.get(ctx, "/retrieve/"+chunkHash)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("node returned %s", resp.Status)
+	}
+}
+
+// Delete removes a chunk from the node. A node that never held the chunk
+// answers 404, which is not an error.
+func (c *NodeClient) Delete(ctx context.Context, chunkHash string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.baseURL+"/chunks/"+chunkHash, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("node returned %s", resp.Status)
+	}
+	return nil
+}
+
+// List returns the hashes of every chunk and shard the node holds
+func (c *NodeClient) List(ctx context.Context) ([]string, error) {
+	resp, err := c.get(ctx, "/chunks")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node returned %s", resp.Status)
+	}
+
+	var listing ListChunksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, err
+	}
+	return listing.Chunks, nil
+}
+
+// Health checks that the node answers its health endpoint with 200
+func (c *NodeClient) Health(ctx context.Context) error {
+	resp, err := c.get(ctx, "/health")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (c *NodeClient) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+func (c *NodeClient) postJSON(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.client.Do(req)
+}
@@ -0,0 +1,216 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// fakeNode implements the storage node endpoints NodeClient calls over an
+// in-memory map, so the client's side of the protocol is tested alone
+type fakeNode struct {
+	mu     sync.Mutex
+	chunks map[string][]byte
+	reject bool // Answer stores with success: false
+}
+
+// startFakeNode serves a fake node and returns a client for it
+func startFakeNode(t *testing.T) (*fakeNode, *NodeClient) {
+	t.Helper()
+
+	f := &fakeNode{chunks: make(map[string][]byte)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /store", f.store)
+	mux.HandleFunc("GET /retrieve/{hash}", f.retrieve)
+	mux.HandleFunc("GET /chunk/{hash}", f.open)
+	mux.HandleFunc("DELETE /chunks/{hash}", f.delete)
+	mux.HandleFunc("GET /chunks", f.list)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return f, NewNodeClient(server.Client(), server.URL)
+}
+
+func (f *fakeNode) store(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "want JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req StoreChunkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.reject {
+		json.NewEncoder(w).Encode(StoreChunkResponse{ChunkHash: req.ChunkHash, Error: "disk full"})
+		return
+	}
+	f.chunks[req.ChunkHash] = req.ChunkData
+	json.NewEncoder(w).Encode(StoreChunkResponse{Success: true, NodeID: "fake", ChunkHash: req.ChunkHash})
+}
+
+// chunk returns a stored chunk, answering 404 if there is none
+func (f *fakeNode) chunk(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	f.mu.Lock()
+	data, exists := f.chunks[r.PathValue("hash")]
+	f.mu.Unlock()
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(RetrieveChunkResponse{ChunkHash: r.PathValue("hash"), Error: "chunk not found"})
+	}
+	return data, exists
+}
+
+func (f *fakeNode) retrieve(w http.ResponseWriter, r *http.Request) {
+	if data, exists := f.chunk(w, r); exists {
+		json.NewEncoder(w).Encode(RetrieveChunkResponse{Success: true, ChunkHash: r.PathValue("hash"), ChunkData: data})
+	}
+}
+
+func (f *fakeNode) open(w http.ResponseWriter, r *http.Request) {
+	if data, exists := f.chunk(w, r); exists {
+		w.Write(data)
+	}
+}
+
+func (f *fakeNode) delete(w http.ResponseWriter, r *http.Request) {
+	if _, exists := f.chunk(w, r); exists {
+		f.mu.Lock()
+		delete(f.chunks, r.PathValue("hash"))
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeNode) list(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	listing := ListChunksResponse{NodeID: "fake", Chunks: []string{}}
+	for hash := range f.chunks {
+		listing.Chunks = append(listing.Chunks, hash)
+	}
+	sort.Strings(listing.Chunks)
+	listing.Count = len(listing.Chunks)
+	json.NewEncoder(w).Encode(listing)
+}
+
+// TestNodeClientProtocol stores, reads, lists and deletes chunks on a fake
+// node, checking each call's result against what the node holds
+func TestNodeClientProtocol(t *testing.T) {
+	f, client := startFakeNode(t)
+	ctx := context.Background()
+
+	if err := client.Health(ctx); err != nil {
+		t.Fatalf("health: %v", err)
+	}
+
+	data, hash := testChunk(t, 1000)
+	other, otherHash := testChunk(t, 10)
+	for h, d := range map[string][]byte{hash: data, otherHash: other} {
+		if err := client.Store(ctx, h, d); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+	}
+	f.mu.Lock()
+	held := f.chunks[hash]
+	f.mu.Unlock()
+	if !bytes.Equal(held, data) {
+		t.Fatal("node holds different data than was stored")
+	}
+
+	got, err := client.Retrieve(ctx, hash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("retrieve: want the stored chunk, got %d bytes (%v)", len(got), err)
+	}
+
+	stream, size, err := client.Open(ctx, hash)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	got, err = io.ReadAll(stream)
+	stream.Close()
+	if err != nil || !bytes.Equal(got, data) || size != int64(len(data)) {
+		t.Errorf("open: want %d bytes, got %d of %d (%v)", len(data), len(got), size, err)
+	}
+
+	want := []string{hash, otherHash}
+	sort.Strings(want)
+	if listed, err := client.List(ctx); err != nil || !reflect.DeepEqual(listed, want) {
+		t.Errorf("list: want %v, got %v (%v)", want, listed, err)
+	}
+
+	if exists, err := client.Exists(ctx, hash); err != nil || !exists {
+		t.Errorf("exists: want the chunk found, got %v (%v)", exists, err)
+	}
+	if err := client.Delete(ctx, hash); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if exists, err := client.Exists(ctx, hash); err != nil || exists {
+		t.Errorf("exists after delete: want the chunk gone, got %v (%v)", exists, err)
+	}
+
+	// A chunk the node never held is missing, not a failure, except to read
+	if err := client.Delete(ctx, hash); err != nil {
+		t.Errorf("deleting a missing chunk: want no error, got %v", err)
+	}
+	if _, err := client.Retrieve(ctx, hash); err == nil {
+		t.Error("retrieving a missing chunk: want an error")
+	}
+	if _, _, err := client.Open(ctx, hash); err == nil {
+		t.Error("opening a missing chunk: want an error")
+	}
+}
+
+// TestNodeClientErrors checks node failures, refusals and unreachable nodes
+// come back as errors from every call
+func TestNodeClientErrors(t *testing.T) {
+	ctx := context.Background()
+	data, hash := testChunk(t, 100)
+
+	f, client := startFakeNode(t)
+	f.reject = true
+	if err := client.Store(ctx, hash, data); err == nil {
+		t.Error("store refused by the node: want an error")
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	for name, client := range map[string]*NodeClient{
+		"failing node":     NewNodeClient(failing.Client(), failing.URL),
+		"unreachable node": NewNodeClient(http.DefaultClient, unreachable.URL),
+	} {
+		calls := map[string]error{"store": client.Store(ctx, hash, data), "delete": client.Delete(ctx, hash), "health": client.Health(ctx)}
+		_, calls["retrieve"] = client.Retrieve(ctx, hash)
+		_, calls["exists"] = client.Exists(ctx, hash)
+		_, calls["list"] = client.List(ctx)
+		_, _, calls["open"] = client.Open(ctx, hash)
+		for call, err := range calls {
+			if err == nil {
+				t.Errorf("%s: want an error from %s", name, call)
+			}
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.Retrieve(cancelled, hash); err == nil {
+		t.Error("cancelled context: want an error")
+	}
+}
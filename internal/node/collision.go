@@ -0,0 +1,36 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// errHashCollision is returned when a store would replace a chunk with
+// different bytes while the stored bytes still match its hash
+var errHashCollision = errors.New("chunk hash collision")
+
+// checkCollision compares a chunk about to be stored with the copy this
+// node already holds, if any. A stored copy that no longer matches its hash
+// is corrupt, and may be overwritten, which is how repairs replace it; one
+// that still matches but differs from the incoming bytes means two
+// different chunks share a hash, and the store is refused.
+func (sn *StorageNode) checkCollision(chunkHash string, chunkData []byte) error {
+	stored, err := sn.readChunk(chunkHash)
+	if err != nil {
+		// Not held, or unreadable and fine to overwrite
+		return nil
+	}
+	if bytes.Equal(stored, chunkData) {
+		return nil
+	}
+	if _, ok := chunking.MatchHash(stored, chunkHash); !ok {
+		return nil
+	}
+
+	log.Printf("HASH COLLISION: refused to overwrite chunk %s (%d bytes) with %d different bytes", chunkHash, len(stored), len(chunkData))
+	return fmt.Errorf("%w: chunk %s is stored with different bytes", errHashCollision, chunkHash[:8])
+}
@@ -0,0 +1,59 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// TestVerifyDedupCollision stores a chunk, then forces a collision by
+// storing other bytes under its hash, and checks the node refuses them and
+// keeps the stored bytes, while still taking the same bytes again or
+// replacing a corrupt copy
+func TestVerifyDedupCollision(t *testing.T) {
+	sn := newTestNode(t)
+	sn.VerifyDedup = true
+	startTestNode(t, sn)
+	ctx := context.Background()
+	data, hash := storeTestChunk(t, sn, 1000)
+	other, _ := testChunk(t, 1000)
+
+	if err := sn.writeChunk(ctx, hash, other); !errors.Is(err, errHashCollision) {
+		t.Fatalf("different bytes: want errHashCollision, got %v", err)
+	}
+	body, err := json.Marshal(StoreChunkRequest{ChunkHash: hash, ChunkData: other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post("http://"+sn.Address+"/store", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("different bytes over HTTP: want 409, got %s", resp.Status)
+	}
+	if stored, err := sn.readChunk(hash); err != nil || !bytes.Equal(stored, data) {
+		t.Fatalf("want the stored bytes kept (%v)", err)
+	}
+
+	if err := sn.writeChunk(ctx, hash, data); err != nil {
+		t.Errorf("same bytes: want them taken, got %v", err)
+	}
+
+	// A stored copy that no longer matches its hash is corrupt, not a
+	// collision, and a repair may overwrite it
+	if err := os.WriteFile(sn.chunkPath(hash), other, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.writeChunk(ctx, hash, data); err != nil {
+		t.Fatalf("repair of a corrupt copy: want it taken, got %v", err)
+	}
+	if stored, err := sn.readChunk(hash); err != nil || !bytes.Equal(stored, data) {
+		t.Errorf("want the corrupt copy replaced (%v)", err)
+	}
+}
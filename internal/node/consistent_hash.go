@@ -4,39 +4,85 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
+	"math/rand/v2"
+	"slices"
 	"sort"
 	"sync"
 )
 
 const (
-	// Virtual nodes per physical node for better distribution
-	VirtualNodesPerNode = 150
+	// DefaultVirtualNodesPerNode is how many ring positions each physical
+	// node gets by default. More positions spread keys more evenly across
+	// nodes but cost memory and lookup time in proportion: the ring holds
+	// nodes*virtualNodes entries.
+	DefaultVirtualNodesPerNode = 150
+)
+
+// Algorithms a ConsistentHash can pick a chunk's primary node with
+const (
+	ConsistentHashRing = "ring" // The first virtual node clockwise from the hash
+	ConsistentHashJump = "jump" // Jump consistent hash over the nodes in ID order
 )
 
 // ConsistentHash implements consistent hashing for chunk distribution
 type ConsistentHash struct {
+	algorithm    string            // ConsistentHashRing or ConsistentHashJump
+	virtualNodes int               // Ring positions per physical node; unused by jump hash
 	circle       map[uint32]string // hash -> nodeID
 	sortedHashes []uint32
-	nodes        map[string]bool // set of node IDs
+	sortedNodes  []string          // Node IDs in order, the buckets of jump hash
+	nodes        map[string]bool   // set of node IDs
+	zones        map[string]string // node ID -> failure domain, for nodes that have one
 	mu           sync.RWMutex
 }
 
-// NewConsistentHash creates a new consistent hash ring
-func NewConsistentHash() *ConsistentHash {
+// NewConsistentHash creates a new consistent hash ring giving each node
+// virtualNodes positions. Every coordinator of a cluster must use the same
+// count, since it decides where chunks are placed.
+func NewConsistentHash(virtualNodes int) (*ConsistentHash, error) {
+	if virtualNodes <= 0 {
+		return nil, fmt.Errorf("virtual nodes per node must be positive, got %d", virtualNodes)
+	}
+
 	return &ConsistentHash{
+		algorithm:    ConsistentHashRing,
+		virtualNodes: virtualNodes,
 		circle:       make(map[uint32]string),
 		sortedHashes: []uint32{},
 		nodes:        make(map[string]bool),
-	}
+		zones:        make(map[string]string),
+	}, nil
 }
 
-// AddNode adds a node to the hash ring
+// AddNode adds a node without a zone to the hash ring
 func (ch *ConsistentHash) AddNode(nodeID string) {
+	ch.AddNodeInZone(nodeID, "")
+}
+
+// AddNodeInZone adds a node in the given failure domain (rack, zone) to the
+// hash ring. Re-adding a node only updates its zone.
+func (ch *ConsistentHash) AddNodeInZone(nodeID, zone string) {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
+	if zone == "" {
+		delete(ch.zones, nodeID)
+	} else {
+		ch.zones[nodeID] = zone
+	}
+	if ch.nodes[nodeID] {
+		return
+	}
+
+	ch.nodes[nodeID] = true
+	i, _ := slices.BinarySearch(ch.sortedNodes, nodeID)
+	ch.sortedNodes = slices.Insert(ch.sortedNodes, i, nodeID)
+	if ch.algorithm == ConsistentHashJump {
+		return
+	}
+
 	// Add virtual nodes to distribute load evenly
-	for i := 0; i < VirtualNodesPerNode; i++ {
+	for i := 0; i < ch.virtualNodes; i++ {
 		virtualNodeID := fmt.Sprintf("%s-vnode-%d", nodeID, i)
 		hash := ch.hashKey(virtualNodeID)
 		ch.circle[hash] = nodeID
@@ -46,8 +92,6 @@ func (ch *ConsistentHash) AddNode(nodeID string) {
 	sort.Slice(ch.sortedHashes, func(i, j int) bool {
 		return ch.sortedHashes[i] < ch.sortedHashes[j]
 	})
-
-	ch.nodes[nodeID] = true
 }
 
 // RemoveNode removes a node from the hash ring
@@ -55,8 +99,17 @@ func (ch *ConsistentHash) RemoveNode(nodeID string) {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
+	if i, found := slices.BinarySearch(ch.sortedNodes, nodeID); found {
+		ch.sortedNodes = slices.Delete(ch.sortedNodes, i, i+1)
+	}
+	delete(ch.nodes, nodeID)
+	delete(ch.zones, nodeID)
+	if ch.algorithm == ConsistentHashJump {
+		return
+	}
+
 	// Remove all virtual nodes for this physical node
-	for i := 0; i < VirtualNodesPerNode; i++ {
+	for i := 0; i < ch.virtualNodes; i++ {
 		virtualNodeID := fmt.Sprintf("%s-vnode-%d", nodeID, i)
 		hash := ch.hashKey(virtualNodeID)
 		delete(ch.circle, hash)
@@ -70,8 +123,6 @@ func (ch *ConsistentHash) RemoveNode(nodeID string) {
 	sort.Slice(ch.sortedHashes, func(i, j int) bool {
 		return ch.sortedHashes[i] < ch.sortedHashes[j]
 	})
-
-	delete(ch.nodes, nodeID)
 }
 
 // GetNode returns the node responsible for a given chunk hash
@@ -79,9 +130,12 @@ func (ch *ConsistentHash) GetNode(chunkHash string) (string, error) {
 	ch.mu.RLock()
 	defer ch.mu.RUnlock()
 
-	if len(ch.circle) == 0 {
+	if len(ch.nodes) == 0 {
 		return "", fmt.Errorf("no nodes available")
 	}
+	if ch.algorithm == ConsistentHashJump {
+		return ch.sortedNodes[ch.jumpIndex(chunkHash)], nil
+	}
 
 	hash := ch.hashKey(chunkHash)
 
@@ -98,7 +152,14 @@ func (ch *ConsistentHash) GetNode(chunkHash string) (string, error) {
 	return ch.circle[ch.sortedHashes[idx]], nil
 }
 
-// GetNodes returns N nodes for replication (for storing the same chunk on multiple nodes)
+// GetNodes returns N nodes for replication (for storing the same chunk on
+// multiple nodes). Candidates come in ring order from the chunk's hash, or
+// under jump hash in node ID order from its primary node. Replicas are
+// spread across distinct zones when possible: a candidate in an already
+// used zone is skipped, and skipped nodes fill the remaining slots in
+// candidate order only if there aren't enough zones. Nodes without a zone
+// never conflict with each other, so a ring without zones places chunks
+// exactly as before.
 func (ch *ConsistentHash) GetNodes(chunkHash string, count int) ([]string, error) {
 	ch.mu.RLock()
 	defer ch.mu.RUnlock()
@@ -111,36 +172,76 @@ func (ch *ConsistentHash) GetNodes(chunkHash string, count int) ([]string, error
 		count = len(ch.nodes)
 	}
 
+	picker := zonePicker{zones: ch.zones, count: count, seen: make(map[string]bool), usedZones: make(map[string]bool)}
+
+	if ch.algorithm == ConsistentHashJump {
+		start := ch.jumpIndex(chunkHash)
+		for i := 0; i < len(ch.sortedNodes) && !picker.done(); i++ {
+			picker.offer(ch.sortedNodes[(start+i)%len(ch.sortedNodes)])
+		}
+		return picker.result(), nil
+	}
+
 	hash := ch.hashKey(chunkHash)
-	selectedNodes := make(map[string]bool)
-	result := []string{}
 
 	// Start from the hash position and walk the ring
-	idx := sort.Search(len(ch.sortedHashes), func(i int) bool {
+	start := sort.Search(len(ch.sortedHashes), func(i int) bool {
 		return ch.sortedHashes[i] >= hash
 	})
 
-	// Collect unique nodes
-	for len(result) < count {
-		if idx >= len(ch.sortedHashes) {
-			idx = 0
-		}
+	// Visit each ring position at most once, wrapping around the end, until
+	// enough distinct nodes are found or every node has been seen
+	for i := 0; i < len(ch.sortedHashes) && !picker.done() && len(picker.seen) < len(ch.nodes); i++ {
+		picker.offer(ch.circle[ch.sortedHashes[(start+i)%len(ch.sortedHashes)]])
+	}
 
-		nodeID := ch.circle[ch.sortedHashes[idx]]
-		if !selectedNodes[nodeID] {
-			selectedNodes[nodeID] = true
-			result = append(result, nodeID)
-		}
+	return picker.result(), nil
+}
 
-		idx++
+// zonePicker chooses replicas from candidate nodes offered in placement
+// order, preferring a node in a zone not used yet
+type zonePicker struct {
+	zones     map[string]string
+	count     int
+	seen      map[string]bool
+	usedZones map[string]bool
+	picked    []string
+	sameZone  []string // Skipped for sharing a zone, in candidate order
+}
 
-		// Prevent infinite loop if we've checked all positions
-		if idx >= len(ch.sortedHashes)*2 {
+// offer considers the next candidate; nodes offered before are ignored
+func (p *zonePicker) offer(nodeID string) {
+	if p.seen[nodeID] {
+		return
+	}
+	p.seen[nodeID] = true
+
+	zone, zoned := p.zones[nodeID]
+	if zoned && p.usedZones[zone] {
+		p.sameZone = append(p.sameZone, nodeID)
+		return
+	}
+	if zoned {
+		p.usedZones[zone] = true
+	}
+	p.picked = append(p.picked, nodeID)
+}
+
+func (p *zonePicker) done() bool {
+	return len(p.picked) >= p.count
+}
+
+// result returns the picked nodes, co-locating the remaining replicas when
+// there aren't enough zones
+func (p *zonePicker) result() []string {
+	result := append([]string{}, p.picked...)
+	for _, nodeID := range p.sameZone {
+		if len(result) == p.count {
 			break
 		}
+		result = append(result, nodeID)
 	}
-
-	return result, nil
+	return result
 }
 
 // hashKey generates a 32-bit hash from a string
@@ -154,4 +255,76 @@ func (ch *ConsistentHash) GetNodeCount() int {
 	ch.mu.RLock()
 	defer ch.mu.RUnlock()
 	return len(ch.nodes)
-}
\ No newline at end of file
+}
+
+// RingStats describes the ring for debugging placement
+type RingStats struct {
+	Algorithm           string            `json:"algorithm"` // ConsistentHashRing or ConsistentHashJump
+	Nodes               int               `json:"nodes"`
+	VirtualNodesPerNode int               `json:"virtual_nodes_per_node,omitempty"`
+	VirtualNodes        map[string]int    `json:"virtual_nodes,omitempty"` // Ring positions held by each node; only for the ring
+	Zones               map[string]string `json:"zones,omitempty"`         // Zone of each node that has one
+	Samples             int               `json:"samples,omitempty"`       // Random keys placed for Distribution
+	Distribution        map[string]int    `json:"distribution,omitempty"`  // How many sample keys each node is primary for
+}
+
+// GetRingStats returns per-node virtual node counts and, when samples is
+// positive, which node would be primary for that many random keys
+func (ch *ConsistentHash) GetRingStats(samples int) RingStats {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	stats := RingStats{
+		Algorithm: ch.algorithm,
+		Nodes:     len(ch.nodes),
+	}
+	if len(ch.zones) > 0 {
+		stats.Zones = make(map[string]string, len(ch.zones))
+		for nodeID, zone := range ch.zones {
+			stats.Zones[nodeID] = zone
+		}
+	}
+
+	if ch.algorithm == ConsistentHashJump {
+		if samples > 0 && len(ch.sortedNodes) > 0 {
+			stats.Samples = samples
+			stats.Distribution = make(map[string]int, len(ch.nodes))
+			for nodeID := range ch.nodes {
+				stats.Distribution[nodeID] = 0
+			}
+			for i := 0; i < samples; i++ {
+				stats.Distribution[ch.sortedNodes[jumpHash(rand.Uint64(), len(ch.sortedNodes))]]++
+			}
+		}
+		return stats
+	}
+
+	stats.VirtualNodesPerNode = ch.virtualNodes
+	stats.VirtualNodes = make(map[string]int, len(ch.nodes))
+
+	// Colliding virtual node hashes leave a node with fewer positions
+	for nodeID := range ch.nodes {
+		stats.VirtualNodes[nodeID] = 0
+	}
+	for _, nodeID := range ch.circle {
+		stats.VirtualNodes[nodeID]++
+	}
+
+	if samples > 0 && len(ch.sortedHashes) > 0 {
+		stats.Samples = samples
+		stats.Distribution = make(map[string]int, len(ch.nodes))
+		for nodeID := range ch.nodes {
+			stats.Distribution[nodeID] = 0
+		}
+
+		for i := 0; i < samples; i++ {
+			key := rand.Uint32()
+			idx := sort.Search(len(ch.sortedHashes), func(j int) bool {
+				return ch.sortedHashes[j] >= key
+			})
+			stats.Distribution[ch.circle[ch.sortedHashes[idx%len(ch.sortedHashes)]]]++
+		}
+	}
+
+	return stats
+}
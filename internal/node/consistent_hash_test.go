@@ -0,0 +1,271 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestGetNodesFewNodes asks for 3 replicas from rings of 1, 2 and 3 nodes,
+// including rings of a single slot per node and rings that lost a node, and
+// checks every node is returned once, primary first, without spinning
+func TestGetNodesFewNodes(t *testing.T) {
+	for _, algorithm := range []string{ConsistentHashRing, ConsistentHashJump} {
+		for _, virtualNodes := range []int{1, DefaultVirtualNodesPerNode} {
+			for _, n := range []int{1, 2, 3} {
+				name := fmt.Sprintf("%s/%d vnodes/%d nodes", algorithm, virtualNodes, n)
+				t.Run(name, func(t *testing.T) {
+					ch := NewJumpHash()
+					if algorithm == ConsistentHashRing {
+						var err error
+						if ch, err = NewConsistentHash(virtualNodes); err != nil {
+							t.Fatal(err)
+						}
+					}
+
+					// A removed node leaves the others with fewer slots
+					for _, nodeID := range nodeIDs(n + 1) {
+						ch.AddNode(nodeID)
+					}
+					ch.RemoveNode(nodeIDs(n + 1)[n])
+
+					done := make(chan struct{})
+					go func() {
+						defer close(done)
+						checkGetNodes(t, ch, n)
+					}()
+					select {
+					case <-done:
+					case <-time.After(10 * time.Second):
+						t.Fatal("GetNodes did not return")
+					}
+				})
+			}
+		}
+	}
+}
+
+// checkGetNodes checks GetNodes(key, 3) returns all n nodes, each once, with
+// the key's primary first
+func checkGetNodes(t *testing.T, ch *ConsistentHash, n int) {
+	for i := 0; i < 1000; i++ {
+		key := placementKey(i)
+		nodes, err := ch.GetNodes(key, 3)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(nodes) != n {
+			t.Errorf("key %d: want %d nodes, got %v", i, n, nodes)
+			return
+		}
+
+		seen := make(map[string]bool)
+		for _, nodeID := range nodes {
+			if seen[nodeID] {
+				t.Errorf("key %d: node %s returned twice in %v", i, nodeID, nodes)
+				return
+			}
+			seen[nodeID] = true
+		}
+
+		primary, err := ch.GetNode(key)
+		if err != nil || nodes[0] != primary {
+			t.Errorf("key %d: want primary %s first, got %v", i, primary, nodes)
+			return
+		}
+	}
+}
+
+func TestGetNodesEmpty(t *testing.T) {
+	ch, err := NewConsistentHash(DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.GetNodes(placementKey(0), 3); err == nil {
+		t.Error("want an error from an empty ring")
+	}
+}
+
+// zonedPlacer returns a jump hash or a ring holding the given nodes, each
+// in the zone mapped to it
+func zonedPlacer(t *testing.T, algorithm string, zones map[string]string) *ConsistentHash {
+	t.Helper()
+
+	ch := newPlacer(t, algorithm, nil)
+	for nodeID, zone := range zones {
+		ch.AddNodeInZone(nodeID, zone)
+	}
+	return ch
+}
+
+// TestGetNodesZoneSpread asks for 3 replicas from nodes in two zones and
+// checks every key gets both zones, with its primary first, and that with
+// a third zone each replica is in a zone of its own
+func TestGetNodesZoneSpread(t *testing.T) {
+	for _, algorithm := range []string{ConsistentHashRing, ConsistentHashJump} {
+		t.Run(algorithm, func(t *testing.T) {
+			zones := map[string]string{
+				"node-01": "a", "node-02": "a", "node-03": "a", "node-04": "a",
+				"node-05": "b", "node-06": "b",
+			}
+			checkZoneSpread(t, zonedPlacer(t, algorithm, zones), zones, 3, 2)
+
+			zones["node-07"] = "c"
+			checkZoneSpread(t, zonedPlacer(t, algorithm, zones), zones, 3, 3)
+		})
+	}
+}
+
+// checkZoneSpread checks GetNodes(key, count) returns count distinct nodes
+// spanning the given number of zones, primary first, for many keys
+func checkZoneSpread(t *testing.T, ch *ConsistentHash, zones map[string]string, count, spread int) {
+	t.Helper()
+
+	for i := 0; i < 1000; i++ {
+		key := placementKey(i)
+		nodes, err := ch.GetNodes(key, count)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen := make(map[string]bool)
+		used := make(map[string]bool)
+		for _, nodeID := range nodes {
+			seen[nodeID] = true
+			used[zones[nodeID]] = true
+		}
+		if len(nodes) != count || len(seen) != count || len(used) != spread {
+			t.Fatalf("key %d: want %d nodes over %d zones, got %v", i, count, spread, nodes)
+		}
+
+		primary, err := ch.GetNode(key)
+		if err != nil || nodes[0] != primary {
+			t.Fatalf("key %d: want primary %s first, got %v", i, primary, nodes)
+		}
+	}
+}
+
+// TestGetNodesSingleZone checks nodes all in one zone, or in none, are
+// placed exactly as on a ring without zones
+func TestGetNodesSingleZone(t *testing.T) {
+	for _, algorithm := range []string{ConsistentHashRing, ConsistentHashJump} {
+		t.Run(algorithm, func(t *testing.T) {
+			plain := newPlacer(t, algorithm, nodeIDs(5))
+			oneZone := make(map[string]string)
+			noZone := make(map[string]string)
+			for _, nodeID := range nodeIDs(5) {
+				oneZone[nodeID] = "a"
+				noZone[nodeID] = ""
+			}
+
+			for _, zones := range []map[string]string{oneZone, noZone} {
+				zoned := zonedPlacer(t, algorithm, zones)
+				for i := 0; i < 1000; i++ {
+					want, err := plain.GetNodes(placementKey(i), 3)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got, err := zoned.GetNodes(placementKey(i), 3); err != nil || !reflect.DeepEqual(got, want) {
+						t.Fatalf("key %d: want %v, got %v (%v)", i, want, got, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestRegistryRecordsZone checks the zone a node registers with is what
+// the registry reports for it
+func TestRegistryRecordsZone(t *testing.T) {
+	sn := newTestNode(t)
+	sn.Zone = "rack-2"
+
+	registry := NewRegistry(time.Minute)
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		var reported NodeInfo
+		if err := json.NewDecoder(r.Body).Decode(&reported); err != nil {
+			t.Error(err)
+		}
+		registry.RegisterNode(&reported)
+	})
+	if err := sn.registerWithCoordinator(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := registry.GetNode("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Zone != "rack-2" {
+		t.Errorf("want zone rack-2 recorded, got %q", info.Zone)
+	}
+}
+
+// primaryShareSpread returns the relative standard deviation of the share of
+// the test keys each node is primary for
+func primaryShareSpread(t *testing.T, ch *ConsistentHash, nodes []string) float64 {
+	t.Helper()
+
+	counts := map[string]int{}
+	for _, nodeID := range primaries(t, ch) {
+		counts[nodeID]++
+	}
+
+	mean := float64(placementKeys) / float64(len(nodes))
+	var variance float64
+	for _, nodeID := range nodes {
+		d := float64(counts[nodeID]) - mean
+		variance += d * d / float64(len(nodes))
+	}
+	return math.Sqrt(variance) / mean
+}
+
+// TestVirtualNodesBalance builds rings of 50 and 500 virtual nodes per node
+// and checks each holds that many positions per node, through adds and
+// removes, and that the larger count spreads 100k keys more evenly
+func TestVirtualNodesBalance(t *testing.T) {
+	nodes := nodeIDs(10)
+	spread := map[int]float64{}
+	for _, virtualNodes := range []int{50, 500} {
+		ch, err := NewConsistentHash(virtualNodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, nodeID := range nodes {
+			ch.AddNode(nodeID)
+		}
+		ch.AddNode("node-extra")
+		ch.RemoveNode("node-extra")
+
+		stats := ch.GetRingStats(0)
+		if stats.VirtualNodesPerNode != virtualNodes || len(ch.sortedHashes) != len(nodes)*virtualNodes {
+			t.Errorf("%d vnodes: want %d ring positions, got %d", virtualNodes, len(nodes)*virtualNodes, len(ch.sortedHashes))
+		}
+		for nodeID, n := range stats.VirtualNodes {
+			if n != virtualNodes {
+				t.Errorf("%d vnodes: node %s holds %d positions", virtualNodes, nodeID, n)
+			}
+		}
+
+		spread[virtualNodes] = primaryShareSpread(t, ch, nodes)
+		t.Logf("%d vnodes: primary shares vary by %.3f of the mean", virtualNodes, spread[virtualNodes])
+	}
+
+	if spread[500] >= spread[50] {
+		t.Errorf("want 500 vnodes to spread keys more evenly than 50, got %.3f against %.3f", spread[500], spread[50])
+	}
+	if spread[500] > 0.10 {
+		t.Errorf("500 vnodes: want shares within 10%% of the mean, got %.3f", spread[500])
+	}
+
+	for _, virtualNodes := range []int{0, -1} {
+		if _, err := NewConsistentHash(virtualNodes); err == nil {
+			t.Errorf("want an error for %d virtual nodes", virtualNodes)
+		}
+	}
+}
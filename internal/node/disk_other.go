@@ -0,0 +1,14 @@
+//go:build !unix
+
+package node
+
+// diskCapacity is not supported on this platform; capacity is reported as 0
+func diskCapacity(path string) (int64, error) {
+	return 0, nil
+}
+
+// syncDir is a no-op on this platform, where directories can't be opened
+// for syncing
+func (sn *StorageNode) syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package node
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskCapacity returns the total size in bytes of the filesystem holding path
+func diskCapacity(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Blocks) * int64(stat.Bsize), nil
+}
+
+// syncDir fsyncs a directory, so entries just created or renamed in it
+// survive a power loss
+func (sn *StorageNode) syncDir(dir string) error {
+	d, err := sn.openFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
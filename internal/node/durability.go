@@ -0,0 +1,172 @@
+package node
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Durability policies: when a stored chunk is forced to disk
+const (
+	// DurabilityChunk fsyncs each chunk file and its directory before the
+	// store is acknowledged, so an acknowledged chunk survives a power loss
+	DurabilityChunk = "chunk"
+	// DurabilityInterval acknowledges stores at once and fsyncs the chunks
+	// written since the last sync every SyncInterval, so a power loss can
+	// lose at most that long's worth of acknowledged chunks
+	DurabilityInterval = "interval"
+	// DurabilityNone leaves flushing to the operating system
+	DurabilityNone = "none"
+)
+
+// DefaultSyncInterval is how often DurabilityInterval fsyncs written chunks
+const DefaultSyncInterval = time.Second
+
+// syncedFile is the part of *os.File that chunk writes and syncs use, so
+// tests can see which files and directories are synced
+type syncedFile interface {
+	Write(p []byte) (int, error)
+	Sync() error
+	Close() error
+}
+
+// fileOpener opens a file as os.OpenFile does
+type fileOpener func(name string, flag int, perm os.FileMode) (syncedFile, error)
+
+// openOSFile is the fileOpener nodes use outside tests
+func openOSFile(name string, flag int, perm os.FileMode) (syncedFile, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// validateDurability checks the node's durability policy and sync interval
+func (sn *StorageNode) validateDurability() error {
+	switch sn.Durability {
+	case DurabilityChunk, DurabilityNone:
+		return nil
+	case DurabilityInterval:
+		if sn.SyncInterval <= 0 {
+			return fmt.Errorf("sync interval must be positive, got %s", sn.SyncInterval)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown durability policy %q (want %s, %s or %s)",
+			sn.Durability, DurabilityChunk, DurabilityInterval, DurabilityNone)
+	}
+}
+
+// writeNewFile writes data to path, fsyncing it before it is closed under
+// DurabilityChunk
+func (sn *StorageNode) writeNewFile(path string, data []byte) error {
+	f, err := sn.openFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && sn.Durability == DurabilityChunk {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncPlaced makes a chunk file just renamed into place as durable as the
+// policy asks: under DurabilityChunk the directories whose entries changed
+// are fsynced now, and under DurabilityInterval the file and those
+// directories are queued for the next sync. dirs lists the directories
+// whose entries the write added: the chunk's own, plus the parents of any
+// created for it.
+func (sn *StorageNode) syncPlaced(chunkPath string, dirs []string) error {
+	switch sn.Durability {
+	case DurabilityChunk:
+		for _, dir := range dirs {
+			if err := sn.syncDir(dir); err != nil {
+				return err
+			}
+		}
+	case DurabilityInterval:
+		sn.unsyncedLock.Lock()
+		sn.unsynced[chunkPath] = true
+		for _, dir := range dirs {
+			sn.unsyncedDirs[dir] = true
+		}
+		sn.unsyncedLock.Unlock()
+	}
+	return nil
+}
+
+// createdDirs returns dir and each of its ancestors below the storage path
+// that doesn't exist yet, deepest first
+func (sn *StorageNode) createdDirs(dir string) []string {
+	var created []string
+	for dir != sn.StoragePath && dir != filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		created = append(created, dir)
+		dir = filepath.Dir(dir)
+	}
+	return created
+}
+
+// startSyncer fsyncs the chunks written under DurabilityInterval every
+// SyncInterval
+func (sn *StorageNode) startSyncer() {
+	ticker := time.NewTicker(sn.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sn.stop:
+			return
+		case <-ticker.C:
+			sn.syncPending()
+		}
+	}
+}
+
+// syncPending fsyncs every chunk file written since the last sync, then the
+// directories holding them. Chunks deleted meanwhile are skipped.
+func (sn *StorageNode) syncPending() {
+	sn.unsyncedLock.Lock()
+	files, dirs := sn.unsynced, sn.unsyncedDirs
+	sn.unsynced, sn.unsyncedDirs = make(map[string]bool), make(map[string]bool)
+	sn.unsyncedLock.Unlock()
+
+	failed := 0
+	for path := range files {
+		if err := sn.syncFile(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to sync chunk file %s: %v", filepath.Base(path), err)
+			failed++
+		}
+	}
+	for dir := range dirs {
+		if err := sn.syncDir(dir); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to sync chunk directory %s: %v", dir, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("Sync: %d of %d chunk files and directories could not be synced", failed, len(files)+len(dirs))
+	}
+}
+
+// syncFile fsyncs a file that is already written
+func (sn *StorageNode) syncFile(path string) error {
+	f, err := sn.openFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncLog records the files and directories a node fsyncs, in order
+type syncLog struct {
+	mu     sync.Mutex
+	synced []string
+}
+
+// watchSyncs makes sn open its files through l
+func (l *syncLog) watchSyncs(sn *StorageNode) {
+	sn.openFile = func(name string, flag int, perm os.FileMode) (syncedFile, error) {
+		f, err := openOSFile(name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return &loggedFile{syncedFile: f, name: name, log: l}, nil
+	}
+}
+
+// paths returns what has been synced so far, and forgets it
+func (l *syncLog) paths() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	synced := l.synced
+	l.synced = nil
+	return synced
+}
+
+type loggedFile struct {
+	syncedFile
+	name string
+	log  *syncLog
+}
+
+func (f *loggedFile) Sync() error {
+	f.log.mu.Lock()
+	f.log.synced = append(f.log.synced, f.name)
+	f.log.mu.Unlock()
+	return f.syncedFile.Sync()
+}
+
+// chunkDirs returns the directories from a chunk file's own up to the
+// storage path, each of whose entries a first write into an empty node adds
+func chunkDirs(sn *StorageNode, chunkPath string) []string {
+	var dirs []string
+	for dir := filepath.Dir(chunkPath); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == sn.StoragePath {
+			return dirs
+		}
+	}
+}
+
+// TestDurabilityChunk stores a chunk under the strict policy and checks its
+// file, and every directory its write added an entry to, are fsynced
+// before the store is acknowledged
+func TestDurabilityChunk(t *testing.T) {
+	sn := newTestNode(t)
+	var syncs syncLog
+	syncs.watchSyncs(sn)
+	client := startTestNode(t, sn)
+
+	data, hash := testChunk(t, 1000)
+	if err := client.Store(context.Background(), hash, data); err != nil {
+		t.Fatal(err)
+	}
+	synced := syncs.paths()
+	chunkPath := sn.chunkPath(hash)
+	want := append([]string{chunkPath + partialSuffix}, chunkDirs(sn, chunkPath)...)
+	if !slices.Equal(synced, want) {
+		t.Errorf("want %v synced before the store returned, got %v", want, synced)
+	}
+}
+
+// TestDurabilityInterval checks a chunk stored under the interval policy is
+// acknowledged before anything is synced, and its file and directories are
+// synced on the next pass
+func TestDurabilityInterval(t *testing.T) {
+	sn := newTestNode(t)
+	sn.Durability = DurabilityInterval
+	sn.SyncInterval = time.Hour
+	var syncs syncLog
+	syncs.watchSyncs(sn)
+
+	_, hash := storeTestChunk(t, sn, 1000)
+	if synced := syncs.paths(); len(synced) != 0 {
+		t.Fatalf("want nothing synced before the interval passes, got %v", synced)
+	}
+
+	sn.syncPending()
+	synced := syncs.paths()
+	chunkPath := sn.chunkPath(hash)
+	want := append([]string{chunkPath}, chunkDirs(sn, chunkPath)...)
+	if len(synced) != len(want) || synced[0] != chunkPath {
+		t.Fatalf("want the chunk file then its directories synced, got %v", synced)
+	}
+	for _, path := range want {
+		if !slices.Contains(synced, path) {
+			t.Errorf("want %s synced, got %v", path, synced)
+		}
+	}
+
+	// Each pass syncs only what was written since the last one, and skips
+	// chunks deleted meanwhile
+	_, deleted := storeTestChunk(t, sn, 1000)
+	if err := sn.deleteChunk(deleted); err != nil {
+		t.Fatal(err)
+	}
+	sn.syncPending()
+	if synced := syncs.paths(); slices.Contains(synced, chunkPath) || slices.Contains(synced, sn.chunkPath(deleted)) {
+		t.Errorf("want neither the synced nor the deleted chunk synced again, got %v", synced)
+	}
+}
+
+func TestDurabilityNone(t *testing.T) {
+	sn := newTestNode(t)
+	sn.Durability = DurabilityNone
+	var syncs syncLog
+	syncs.watchSyncs(sn)
+
+	storeTestChunk(t, sn, 1000)
+	sn.syncPending()
+	if synced := syncs.paths(); len(synced) != 0 {
+		t.Errorf("want nothing synced, got %v", synced)
+	}
+}
+
+func TestValidateDurability(t *testing.T) {
+	for _, tc := range []struct {
+		durability string
+		interval   time.Duration
+		valid      bool
+	}{
+		{DurabilityChunk, 0, true},
+		{DurabilityInterval, time.Second, true},
+		{DurabilityInterval, 0, false},
+		{DurabilityNone, 0, true},
+		{"always", time.Second, false},
+		{"", time.Second, false},
+	} {
+		sn := &StorageNode{Durability: tc.durability, SyncInterval: tc.interval}
+		if err := sn.validateDurability(); (err == nil) != tc.valid {
+			t.Errorf("%q every %v: want valid %t, got %v", tc.durability, tc.interval, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+	"github.com/noorimat/distributed-file-storage/internal/node/nodepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ChunkPieceSize is how much chunk data each streamed gRPC message carries
+	ChunkPieceSize = 1 << 20
+	// GRPCMaxMessageSize bounds a single gRPC message; it must fit a full
+	// BatchStore request
+	GRPCMaxMessageSize = 64 << 20
+)
+
+// grpcServer exposes the node's chunk store over gRPC
+type grpcServer struct {
+	nodepb.UnimplementedStorageNodeServer
+	sn *StorageNode
+}
+
+// newGRPCServer creates a gRPC server for the node, requiring the cluster
+// secret on every call when one is configured. With TLS configured it
+// serves over TLS, and a CA makes client certificates mandatory.
+func (sn *StorageNode) newGRPCServer() (*grpc.Server, error) {
+	tlsConfig, err := sn.TLS.ServerConfig(true)
+	if err != nil {
+		return nil, err
+	}
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	server := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.MaxRecvMsgSize(GRPCMaxMessageSize),
+		grpc.MaxSendMsgSize(GRPCMaxMessageSize),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := sn.checkRPCSecret(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := sn.checkRPCSecret(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+
+	nodepb.RegisterStorageNodeServer(server, &grpcServer{sn: sn})
+	return server, nil
+}
+
+func (sn *StorageNode) checkRPCSecret(ctx context.Context) error {
+	if sn.ClusterSecret != "" && !auth.Equal(auth.RPCToken(ctx), sn.ClusterSecret) {
+		return status.Error(codes.Unauthenticated, "invalid cluster secret")
+	}
+	return nil
+}
+
+func (g *grpcServer) StoreChunk(stream nodepb.StorageNode_StoreChunkServer) error {
+	var chunkHash string
+	var chunkData bytes.Buffer
+
+	for {
+		piece, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if chunkHash == "" {
+			chunkHash = piece.GetChunkHash()
+		}
+		chunkData.Write(piece.GetData())
+	}
+
+	if chunkHash == "" {
+		return status.Error(codes.InvalidArgument, "first piece must carry the chunk hash")
+	}
+
+	response := &nodepb.StoreChunkResponse{
+		Success:   true,
+		NodeId:    g.sn.NodeID,
+		ChunkHash: chunkHash,
+	}
+
+	if err := g.sn.writeChunk(stream.Context(), chunkHash, chunkData.Bytes()); err != nil {
+		log.Printf("Failed to store chunk: %v", err)
+		response.Success = false
+		response.Error = err.Error()
+	} else {
+		log.Printf("Stored chunk %s on node %s", chunkHash[:8], g.sn.NodeID)
+	}
+
+	return stream.SendAndClose(response)
+}
+
+func (g *grpcServer) RetrieveChunk(req *nodepb.RetrieveChunkRequest, stream nodepb.StorageNode_RetrieveChunkServer) error {
+	chunkData, err := g.sn.readChunk(req.GetChunkHash())
+	if errors.Is(err, errChunkNotFound) {
+		return status.Error(codes.NotFound, "chunk not found")
+	}
+	if err != nil {
+		log.Printf("Failed to read chunk: %v", err)
+		return status.Error(codes.Internal, "failed to read chunk")
+	}
+
+	// Always send at least one piece so the hash arrives even for empty chunks
+	for offset := 0; ; offset += ChunkPieceSize {
+		end := min(offset+ChunkPieceSize, len(chunkData))
+
+		piece := &nodepb.ChunkPiece{Data: chunkData[offset:end]}
+		if offset == 0 {
+			piece.ChunkHash = req.GetChunkHash()
+		}
+		if err := stream.Send(piece); err != nil {
+			return err
+		}
+		if end == len(chunkData) {
+			return nil
+		}
+	}
+}
+
+func (g *grpcServer) BatchStore(ctx context.Context, req *nodepb.BatchStoreRequest) (*nodepb.BatchStoreResponse, error) {
+	response := &nodepb.BatchStoreResponse{
+		NodeId:  g.sn.NodeID,
+		Results: make([]*nodepb.StoreChunkResponse, 0, len(req.GetChunks())),
+	}
+
+	stored := 0
+	for _, chunk := range req.GetChunks() {
+		result := &nodepb.StoreChunkResponse{
+			Success:   true,
+			NodeId:    g.sn.NodeID,
+			ChunkHash: chunk.GetChunkHash(),
+		}
+
+		if err := g.sn.writeChunk(ctx, chunk.GetChunkHash(), chunk.GetChunkData()); err != nil {
+			log.Printf("Failed to store chunk in batch: %v", err)
+			result.Success = false
+			result.Error = err.Error()
+		} else {
+			stored++
+		}
+
+		response.Results = append(response.Results, result)
+	}
+
+	log.Printf("Stored batch of %d/%d chunks on node %s", stored, len(req.GetChunks()), g.sn.NodeID)
+	return response, nil
+}
+
+func (g *grpcServer) Exists(ctx context.Context, req *nodepb.ExistsRequest) (*nodepb.ExistsResponse, error) {
+	response := &nodepb.ExistsResponse{}
+	for _, chunkHash := range req.GetChunkHashes() {
+		if g.sn.hasChunk(chunkHash) {
+			response.Present = append(response.Present, chunkHash)
+		}
+	}
+	return response, nil
+}
+
+func (g *grpcServer) Delete(ctx context.Context, req *nodepb.DeleteRequest) (*nodepb.DeleteResponse, error) {
+	err := g.sn.deleteChunk(req.GetChunkHash())
+	if errors.Is(err, errChunkNotFound) {
+		return &nodepb.DeleteResponse{Deleted: false}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to delete chunk: %v", err)
+		return nil, status.Error(codes.Internal, "failed to delete chunk")
+	}
+	return &nodepb.DeleteResponse{Deleted: true}, nil
+}
@@ -0,0 +1,198 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+	"github.com/noorimat/distributed-file-storage/internal/node/nodepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPCNode serves sn's gRPC data path over an in-memory connection and
+// returns a client for it, sending secret as the cluster secret if set
+func startGRPCNode(t *testing.T, sn *StorageNode, secret string) nodepb.StorageNodeClient {
+	t.Helper()
+
+	server, err := sn.newGRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(GRPCMaxMessageSize), grpc.MaxCallSendMsgSize(GRPCMaxMessageSize)),
+	}
+	if secret != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(auth.RPCCredentials{Token: secret}))
+	}
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return nodepb.NewStorageNodeClient(conn)
+}
+
+// grpcStore streams a chunk to the node in ChunkPieceSize pieces
+func grpcStore(t *testing.T, client nodepb.StorageNodeClient, hash string, data []byte) *nodepb.StoreChunkResponse {
+	t.Helper()
+
+	stream, err := client.StoreChunk(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset := 0; offset == 0 || offset < len(data); offset += ChunkPieceSize {
+		piece := &nodepb.ChunkPiece{Data: data[offset:min(offset+ChunkPieceSize, len(data))]}
+		if offset == 0 {
+			piece.ChunkHash = hash
+		}
+		if err := stream.Send(piece); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// grpcRetrieve reads a chunk back, joining its pieces
+func grpcRetrieve(client nodepb.StorageNodeClient, hash string) ([]byte, int, error) {
+	stream, err := client.RetrieveChunk(context.Background(), &nodepb.RetrieveChunkRequest{ChunkHash: hash})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var data bytes.Buffer
+	pieces := 0
+	for {
+		piece, err := stream.Recv()
+		if err == io.EOF {
+			return data.Bytes(), pieces, nil
+		}
+		if err != nil {
+			return nil, pieces, err
+		}
+		data.Write(piece.GetData())
+		pieces++
+	}
+}
+
+// TestGRPCStoreRetrieve stores a chunk spanning several pieces over gRPC,
+// reads it back, and checks it exists until deleted
+func TestGRPCStoreRetrieve(t *testing.T) {
+	sn := newTestNode(t)
+	client := startGRPCNode(t, sn, "")
+	ctx := context.Background()
+
+	data, hash := testChunk(t, 2*ChunkPieceSize+1000)
+	if resp := grpcStore(t, client, hash, data); !resp.GetSuccess() || resp.GetChunkHash() != hash || resp.GetNodeId() != sn.NodeID {
+		t.Fatalf("store failed: %+v", resp)
+	}
+
+	got, pieces, err := grpcRetrieve(client, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("retrieved chunk differs from the one stored")
+	}
+	if pieces != 3 {
+		t.Errorf("want the chunk streamed in 3 pieces, got %d", pieces)
+	}
+
+	missing := "ab" + hash[2:]
+	if _, _, err := grpcRetrieve(client, missing); status.Code(err) != codes.NotFound {
+		t.Errorf("want NotFound for a missing chunk, got %v", err)
+	}
+
+	exists, err := client.Exists(ctx, &nodepb.ExistsRequest{ChunkHashes: []string{hash, missing}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exists.GetPresent()) != 1 || exists.GetPresent()[0] != hash {
+		t.Errorf("want only the stored chunk present, got %v", exists.GetPresent())
+	}
+
+	for _, want := range []bool{true, false} {
+		deleted, err := client.Delete(ctx, &nodepb.DeleteRequest{ChunkHash: hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted.GetDeleted() != want {
+			t.Errorf("want deleted=%v, got %v", want, deleted.GetDeleted())
+		}
+	}
+	if _, _, err := grpcRetrieve(client, hash); status.Code(err) != codes.NotFound {
+		t.Errorf("want a deleted chunk NotFound, got %v", err)
+	}
+}
+
+// TestGRPCBatchStore stores a batch with one bad chunk over gRPC and checks
+// each chunk's outcome is reported
+func TestGRPCBatchStore(t *testing.T) {
+	sn := newTestNode(t)
+	client := startGRPCNode(t, sn, "")
+
+	first, firstHash := testChunk(t, 1000)
+	second, secondHash := testChunk(t, 2000)
+	resp, err := client.BatchStore(context.Background(), &nodepb.BatchStoreRequest{Chunks: []*nodepb.StoreChunkRequest{
+		{ChunkHash: firstHash, ChunkData: first},
+		{ChunkHash: "x", ChunkData: []byte("invalid hash")},
+		{ChunkHash: secondHash, ChunkData: second},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := resp.GetResults()
+	if len(results) != 3 || !results[0].GetSuccess() || results[1].GetSuccess() || !results[2].GetSuccess() {
+		t.Fatalf("want the middle chunk alone rejected, got %v", results)
+	}
+	for _, hash := range []string{firstHash, secondHash} {
+		if _, _, err := grpcRetrieve(client, hash); err != nil {
+			t.Errorf("chunk %s not stored: %v", hash[:8], err)
+		}
+	}
+}
+
+// TestGRPCClusterSecret checks calls without the cluster secret are refused
+func TestGRPCClusterSecret(t *testing.T) {
+	sn := newTestNode(t)
+	sn.ClusterSecret = "secret"
+	ctx := context.Background()
+	req := &nodepb.ExistsRequest{ChunkHashes: []string{"abc"}}
+
+	for _, tc := range []struct {
+		secret string
+		want   codes.Code
+	}{
+		{"", codes.Unauthenticated},
+		{"wrong", codes.Unauthenticated},
+		{"secret", codes.OK},
+	} {
+		client := startGRPCNode(t, sn, tc.secret)
+		if _, err := client.Exists(ctx, req); status.Code(err) != tc.want {
+			t.Errorf("secret %q: want %s, got %v", tc.secret, tc.want, err)
+		}
+		if _, _, err := grpcRetrieve(client, "abc"); tc.want != codes.OK && status.Code(err) != tc.want {
+			t.Errorf("secret %q: want a streamed call refused with %s, got %v", tc.secret, tc.want, err)
+		}
+	}
+}
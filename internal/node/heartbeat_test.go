@@ -0,0 +1,228 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHeartbeatReportsUsedBytes stores and deletes chunks and checks each
+// heartbeat reports the bytes actually stored
+func TestHeartbeatReportsUsedBytes(t *testing.T) {
+	sn := newTestNode(t)
+
+	var last HeartbeatMessage
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&last); err != nil {
+			t.Error(err)
+		}
+	})
+	heartbeat := func() HeartbeatMessage {
+		t.Helper()
+		if err := sn.sendHeartbeat(); err != nil {
+			t.Fatal(err)
+		}
+		return last
+	}
+
+	if got := heartbeat(); got.Used != 0 || got.TotalChunks != 0 {
+		t.Fatalf("empty node reports %d bytes in %d chunks", got.Used, got.TotalChunks)
+	}
+
+	storeTestChunk(t, sn, 1000)
+	data, hash := storeTestChunk(t, sn, 2500)
+	got := heartbeat()
+	if got.Used != 3500 || got.TotalChunks != 2 {
+		t.Errorf("want 3500 bytes in 2 chunks, got %d in %d", got.Used, got.TotalChunks)
+	}
+	if got.Capacity <= 0 {
+		t.Errorf("want the disk's capacity reported, got %d", got.Capacity)
+	}
+
+	// Storing a chunk again doesn't count it twice
+	if err := sn.writeChunk(context.Background(), hash, data); err != nil {
+		t.Fatal(err)
+	}
+	if got := heartbeat(); got.Used != 3500 {
+		t.Errorf("after storing a chunk again, want 3500 bytes, got %d", got.Used)
+	}
+
+	if err := sn.deleteChunk(hash); err != nil {
+		t.Fatal(err)
+	}
+	if got := heartbeat(); got.Used != 1000 || got.TotalChunks != 1 {
+		t.Errorf("after a delete, want 1000 bytes in 1 chunk, got %d in %d", got.Used, got.TotalChunks)
+	}
+
+	// A restarted node counts the chunks already on disk
+	restarted := NewStorageNode(sn.NodeID, "", sn.StoragePath, sn.CoordinatorAddr)
+	if err := restarted.loadExistingChunks(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.sendHeartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	if last.Used != 1000 || last.TotalChunks != 1 {
+		t.Errorf("after a restart, want 1000 bytes in 1 chunk, got %d in %d", last.Used, last.TotalChunks)
+	}
+}
+
+// TestFirstHeartbeatImmediate starts a node heartbeating once an hour and
+// checks it registers and sends its first heartbeat straight away
+func TestFirstHeartbeatImmediate(t *testing.T) {
+	sn := newTestNode(t)
+	sn.HeartbeatInterval = time.Hour
+
+	var mu sync.Mutex
+	var calls []string
+	heartbeat := make(chan struct{}, 1)
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/heartbeat" {
+			select {
+			case heartbeat <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	started := time.Now()
+	startTestNode(t, sn)
+	select {
+	case <-heartbeat:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no heartbeat within 2s of starting")
+	}
+	t.Logf("first heartbeat %s after start", time.Since(started))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 2 || calls[0] != "/register" || calls[1] != "/heartbeat" {
+		t.Errorf("want a registration then a heartbeat, got %v", calls)
+	}
+}
+
+// TestHeartbeatJitter checks heartbeat waits stay within heartbeatJitter of
+// the interval and are spread across that range
+func TestHeartbeatJitter(t *testing.T) {
+	const interval = 10 * time.Second
+	spread := time.Duration(float64(interval) * heartbeatJitter)
+
+	seen := make(map[time.Duration]bool)
+	var lowest, highest time.Duration = interval, interval
+	for i := 0; i < 1000; i++ {
+		d := jitter(interval, heartbeatJitter)
+		if d < interval-spread || d > interval+spread {
+			t.Fatalf("jittered wait %s outside %s +/- %s", d, interval, spread)
+		}
+		seen[d] = true
+		lowest, highest = min(lowest, d), max(highest, d)
+	}
+	if len(seen) < 900 || lowest > interval-spread/2 || highest < interval+spread/2 {
+		t.Errorf("waits not spread out: %d distinct between %s and %s", len(seen), lowest, highest)
+	}
+
+	if d := jitter(interval, 0); d != interval {
+		t.Errorf("want no jitter with fraction 0, got %s", d)
+	}
+}
+
+// TestRegisterRetriesUntilCoordinatorUp starts a node while the coordinator
+// is still refusing requests, and checks the node keeps trying, backing off
+// between attempts, and registers and heartbeats once the coordinator is up
+func TestRegisterRetriesUntilCoordinatorUp(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for registration retries")
+	}
+
+	sn := newTestNode(t)
+	sn.HeartbeatInterval = 100 * time.Millisecond
+
+	var mu sync.Mutex
+	var attempts []time.Time
+	up := time.Now().Add(1500 * time.Millisecond)
+	heartbeat := make(chan struct{}, 1)
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		if time.Now().Before(up) {
+			if r.URL.Path == "/register" {
+				mu.Lock()
+				attempts = append(attempts, time.Now())
+				mu.Unlock()
+			}
+			http.Error(w, "starting up", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/heartbeat" {
+			select {
+			case heartbeat <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	startTestNode(t, sn)
+	select {
+	case <-heartbeat:
+	case <-time.After(10 * time.Second):
+		t.Fatal("node never registered once the coordinator was up")
+	}
+
+	// Attempts at about 0s and 1s fail; the next waits about 2s
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 2 {
+		t.Fatalf("want 2 failed attempts before the coordinator was up, got %d", len(attempts))
+	}
+	first := attempts[1].Sub(attempts[0])
+	if first < 800*time.Millisecond || first > 1200*time.Millisecond {
+		t.Errorf("want about %s before the first retry, got %s", registerRetryMin, first)
+	}
+	if second := time.Since(attempts[1]); second < 3*first/2 {
+		t.Errorf("want the wait to grow after the first retry, got %s then at least %s", first, second)
+	}
+}
+
+// TestReregisterAfterHeartbeatRejected has the coordinator forget a node,
+// as on a coordinator restart, and checks the node registers again as soon
+// as a heartbeat is turned away
+func TestReregisterAfterHeartbeatRejected(t *testing.T) {
+	sn := newTestNode(t)
+	sn.HeartbeatInterval = 100 * time.Millisecond
+
+	var mu sync.Mutex
+	known := false
+	registered := make(chan struct{}, 2)
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/register":
+			known = true
+			registered <- struct{}{}
+		case "/heartbeat":
+			if !known {
+				http.Error(w, "node not found", http.StatusNotFound)
+			}
+		}
+	})
+
+	startTestNode(t, sn)
+	for i, event := range []string{"registration", "registration after the coordinator forgot the node"} {
+		select {
+		case <-registered:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %s", event)
+		}
+		if i == 0 {
+			mu.Lock()
+			known = false
+			mu.Unlock()
+		}
+	}
+}
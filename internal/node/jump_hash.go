@@ -0,0 +1,46 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// NewJumpHash creates a ConsistentHash that picks each chunk's primary node
+// with jump consistent hash (Lamping and Veach) over the nodes sorted by
+// ID, instead of a ring. It keeps no virtual nodes, so it needs memory for
+// the node list only, and spreads keys almost exactly evenly. Further
+// replicas go to the nodes following the primary in ID order.
+//
+// Jump hash only moves the keys a new last bucket takes over, so adding a
+// node whose ID sorts after every other one moves just 1/n of the keys.
+// Adding or removing a node anywhere else in the order renumbers the nodes
+// after it and moves more keys than the ring would. Every coordinator of a
+// cluster must use the same algorithm, since it decides where chunks are
+// placed.
+func NewJumpHash() *ConsistentHash {
+	return &ConsistentHash{
+		algorithm: ConsistentHashJump,
+		circle:    make(map[uint32]string),
+		nodes:     make(map[string]bool),
+		zones:     make(map[string]string),
+	}
+}
+
+// jumpIndex returns the position in sortedNodes of a chunk's primary node.
+// Callers hold ch.mu and have checked there is at least one node.
+func (ch *ConsistentHash) jumpIndex(chunkHash string) int {
+	hash := sha256.Sum256([]byte(chunkHash))
+	return jumpHash(binary.BigEndian.Uint64(hash[:8]), len(ch.sortedNodes))
+}
+
+// jumpHash maps key to one of buckets buckets, moving only 1/buckets of
+// the keys when a bucket is added at the end
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
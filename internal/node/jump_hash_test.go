@@ -0,0 +1,126 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+)
+
+const placementKeys = 100000
+
+// placementKey returns the i-th test chunk hash
+func placementKey(i int) string {
+	return fmt.Sprintf("%064x", i)
+}
+
+// newPlacer returns a jump hash or a ring holding nodes
+func newPlacer(t *testing.T, algorithm string, nodes []string) *ConsistentHash {
+	t.Helper()
+
+	ch := NewJumpHash()
+	if algorithm == ConsistentHashRing {
+		var err error
+		if ch, err = NewConsistentHash(DefaultVirtualNodesPerNode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, nodeID := range nodes {
+		ch.AddNode(nodeID)
+	}
+	return ch
+}
+
+// primaries returns each test key's primary node
+func primaries(t *testing.T, ch *ConsistentHash) []string {
+	t.Helper()
+
+	owners := make([]string, placementKeys)
+	for i := range owners {
+		nodeID, err := ch.GetNode(placementKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		owners[i] = nodeID
+	}
+	return owners
+}
+
+func nodeIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("node-%02d", i+1)
+	}
+	return ids
+}
+
+// TestJumpHashSpread checks jump hash gives each of 10 nodes close to a
+// tenth of the chunks, and spreads them more evenly than the default ring
+func TestJumpHashSpread(t *testing.T) {
+	nodes := nodeIDs(10)
+	jump := newPlacer(t, ConsistentHashJump, nodes)
+	counts := map[string]int{}
+	for _, nodeID := range primaries(t, jump) {
+		counts[nodeID]++
+	}
+
+	mean := placementKeys / len(nodes)
+	for _, nodeID := range nodes {
+		if n := counts[nodeID]; n < mean*95/100 || n > mean*105/100 {
+			t.Errorf("node %s holds %d keys, want within 5%% of %d", nodeID, n, mean)
+		}
+	}
+
+	jumpSpread := primaryShareSpread(t, jump, nodes)
+	ringSpread := primaryShareSpread(t, newPlacer(t, ConsistentHashRing, nodes), nodes)
+	t.Logf("primary shares vary by %.3f of the mean under jump hash, %.3f on the ring", jumpSpread, ringSpread)
+	if jumpSpread >= ringSpread {
+		t.Errorf("want jump hash to spread keys more evenly than the ring, got %.3f against %.3f", jumpSpread, ringSpread)
+	}
+}
+
+// TestPlacementMovedOnAdd adds an 11th node to 10 and counts the chunks
+// whose primary moves. Jump hash moves only the new node's share when its ID
+// sorts last, but about half of all chunks when it sorts in the middle; the
+// ring moves about the new node's share either way.
+func TestPlacementMovedOnAdd(t *testing.T) {
+	for _, tc := range []struct {
+		algorithm string
+		added     string
+		min, max  float64 // Bounds on the fraction of chunks moved
+	}{
+		{ConsistentHashJump, "node-11", 0.08, 0.10},
+		{ConsistentHashJump, "node-05a", 0.35, 0.65},
+		{ConsistentHashRing, "node-11", 0.05, 0.14},
+		{ConsistentHashRing, "node-05a", 0.05, 0.14},
+	} {
+		t.Run(tc.algorithm+"/"+tc.added, func(t *testing.T) {
+			ch := newPlacer(t, tc.algorithm, nodeIDs(10))
+			before := primaries(t, ch)
+			ch.AddNode(tc.added)
+			after := primaries(t, ch)
+
+			moved, movedElsewhere := 0, 0
+			for i := range before {
+				if before[i] == after[i] {
+					continue
+				}
+				moved++
+				if after[i] != tc.added {
+					movedElsewhere++
+				}
+			}
+
+			fraction := float64(moved) / placementKeys
+			t.Logf("%s adding %s moved %d of %d chunks (%.3f)", tc.algorithm, tc.added, moved, placementKeys, fraction)
+			if fraction < tc.min || fraction > tc.max {
+				t.Errorf("moved %.3f of chunks, want between %.2f and %.2f", fraction, tc.min, tc.max)
+			}
+
+			// Only a node sorting last leaves every other chunk in place
+			if tc.algorithm == ConsistentHashRing || tc.added == "node-11" {
+				if movedElsewhere > 0 {
+					t.Errorf("%d chunks moved between existing nodes", movedElsewhere)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/shard"
+)
+
+// checkNodeLayout checks each chunk is stored at the node's shard depth and
+// reads back through it
+func checkNodeLayout(t *testing.T, sn *StorageNode, client *NodeClient, chunks map[string][]byte) {
+	t.Helper()
+
+	for hash, data := range chunks {
+		path := shard.Path(sn.StoragePath, hash, sn.ShardDepth)
+		if stored, err := os.ReadFile(path); err != nil || !bytes.Equal(stored, data) {
+			t.Errorf("depth %d: chunk %s not at %s: %v", sn.ShardDepth, hash[:8], path, err)
+		}
+		if got, err := client.Retrieve(context.Background(), hash); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("depth %d: chunk %s does not read back: %v", sn.ShardDepth, hash[:8], err)
+		}
+	}
+}
+
+// TestShardDepth stores chunks on nodes sharding 1 and 2 levels deep,
+// restarts each at the other depth, and checks the chunks are moved and
+// still served, counted and deletable
+func TestShardDepth(t *testing.T) {
+	for _, depths := range [][2]int{{1, 2}, {2, 1}} {
+		t.Run(fmt.Sprintf("%d to %d", depths[0], depths[1]), func(t *testing.T) {
+			ctx := context.Background()
+			sn := newTestNode(t)
+			sn.ShardDepth = depths[0]
+			client := startTestNode(t, sn)
+
+			chunks := make(map[string][]byte)
+			for i := 0; i < 10; i++ {
+				data, hash := testChunk(t, 1000)
+				chunks[hash] = data
+				if err := client.Store(ctx, hash, data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkNodeLayout(t, sn, client, chunks)
+
+			if err := shutdownTestNode(sn); err != nil {
+				t.Fatal(err)
+			}
+
+			restarted := newTestNode(t)
+			restarted.StoragePath = sn.StoragePath
+			restarted.ShardDepth = depths[1]
+			client = startTestNode(t, restarted)
+			checkNodeLayout(t, restarted, client, chunks)
+			for hash := range chunks {
+				if _, err := os.Stat(shard.Path(sn.StoragePath, hash, depths[0])); !os.IsNotExist(err) {
+					t.Errorf("chunk %s left at depth %d", hash[:8], depths[0])
+				}
+			}
+			restarted.chunksLock.RLock()
+			count := len(restarted.chunks)
+			restarted.chunksLock.RUnlock()
+			if count != len(chunks) {
+				t.Errorf("want %d chunks tracked after the move, got %d", len(chunks), count)
+			}
+
+			for hash := range chunks {
+				if err := client.Delete(ctx, hash); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(shard.Path(sn.StoragePath, hash, depths[1])); !os.IsNotExist(err) {
+					t.Errorf("deleted chunk %s left on disk", hash[:8])
+				}
+			}
+		})
+	}
+}
+
+func TestShardDepthInvalid(t *testing.T) {
+	sn := newTestNode(t)
+	sn.ShardDepth = 0
+	if err := sn.Start(); err == nil {
+		t.Error("want a node with shard depth 0 to fail to start")
+	}
+}
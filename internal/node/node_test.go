@@ -0,0 +1,117 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// newTestNode returns a node storing chunks in a temporary directory, with
+// no coordinator and no background work, for calling its methods directly
+func newTestNode(t *testing.T) *StorageNode {
+	t.Helper()
+
+	sn := NewStorageNode("node-1", "", t.TempDir(), "")
+	sn.ScrubInterval = 0
+	sn.SweepInterval = 0
+	sn.ScrubRate = 0
+	return sn
+}
+
+// testChunk returns n random bytes and their SHA-256 chunk hash
+func testChunk(t testing.TB, n int) ([]byte, string) {
+	t.Helper()
+
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data, chunking.SHA256.Sum(data)
+}
+
+// storeTestChunk writes a random chunk of n bytes to sn and returns it
+func storeTestChunk(t *testing.T, sn *StorageNode, n int) ([]byte, string) {
+	t.Helper()
+
+	data, hash := testChunk(t, n)
+	if err := sn.writeChunk(context.Background(), hash, data); err != nil {
+		t.Fatal(err)
+	}
+	return data, hash
+}
+
+// fakeCoordinator serves handler as the coordinator sn talks to
+func fakeCoordinator(t *testing.T, sn *StorageNode, handler http.HandlerFunc) {
+	t.Helper()
+
+	coordinator := httptest.NewServer(handler)
+	t.Cleanup(coordinator.Close)
+	sn.CoordinatorAddr = strings.TrimPrefix(coordinator.URL, "http://")
+}
+
+// testShutdownTimeout bounds shutting down a test node, with room to spare
+// for slow runs such as those under the race detector
+const testShutdownTimeout = 30 * time.Second
+
+// shutdownTestNode shuts sn down within testShutdownTimeout. The client's
+// idle connections are closed first: http.Server only counts a connection
+// that never sent a request as idle once it is 5s old, and a slow client
+// can leave such a spare connection behind.
+func shutdownTestNode(sn *StorageNode) error {
+	http.DefaultClient.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), testShutdownTimeout)
+	defer cancel()
+	return sn.Shutdown(ctx)
+}
+
+// startTestNode serves sn on a free local port until the test ends, and
+// returns a client for it once it answers health checks
+func startTestNode(t *testing.T, sn *StorageNode) *NodeClient {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sn.Address = listener.Addr().String()
+	sn.server.Addr = sn.Address
+	listener.Close()
+
+	var startErr error
+	stopped := make(chan struct{})
+	go func() {
+		startErr = sn.Start()
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		shutdownTestNode(sn)
+		<-stopped
+		if startErr != nil {
+			t.Errorf("node stopped with error: %v", startErr)
+		}
+	})
+
+	client := NewNodeClient(http.DefaultClient, "http://"+sn.Address)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		select {
+		case <-stopped:
+			t.Fatalf("node failed to start: %v", startErr)
+		default:
+		}
+		err := client.Health(context.Background())
+		if err == nil {
+			return client
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node never answered health checks: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,5 @@
+// Package nodepb holds the gRPC service storage nodes expose to the
+// coordinator. Regenerate after editing storage.proto.
+package nodepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative storage.proto
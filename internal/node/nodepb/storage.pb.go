@@ -0,0 +1,631 @@
+// Protocol for the coordinator <-> storage node data path. It mirrors the
+// JSON messages in internal/node/protocol.go but carries chunk data as raw
+// bytes, and streams single chunks in pieces so large chunks are never
+// buffered into one oversized message.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: storage.proto
+
+package nodepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ChunkPiece struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ChunkHash     string                 `protobuf:"bytes,1,opt,name=chunk_hash,json=chunkHash,proto3" json:"chunk_hash,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChunkPiece) Reset() {
+	*x = ChunkPiece{}
+	mi := &file_storage_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChunkPiece) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChunkPiece) ProtoMessage() {}
+
+func (x *ChunkPiece) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChunkPiece.ProtoReflect.Descriptor instead.
+func (*ChunkPiece) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ChunkPiece) GetChunkHash() string {
+	if x != nil {
+		return x.ChunkHash
+	}
+	return ""
+}
+
+func (x *ChunkPiece) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type StoreChunkResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	NodeId        string                 `protobuf:"bytes,2,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	ChunkHash     string                 `protobuf:"bytes,3,opt,name=chunk_hash,json=chunkHash,proto3" json:"chunk_hash,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StoreChunkResponse) Reset() {
+	*x = StoreChunkResponse{}
+	mi := &file_storage_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StoreChunkResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoreChunkResponse) ProtoMessage() {}
+
+func (x *StoreChunkResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoreChunkResponse.ProtoReflect.Descriptor instead.
+func (*StoreChunkResponse) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StoreChunkResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *StoreChunkResponse) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *StoreChunkResponse) GetChunkHash() string {
+	if x != nil {
+		return x.ChunkHash
+	}
+	return ""
+}
+
+func (x *StoreChunkResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type RetrieveChunkRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ChunkHash     string                 `protobuf:"bytes,1,opt,name=chunk_hash,json=chunkHash,proto3" json:"chunk_hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RetrieveChunkRequest) Reset() {
+	*x = RetrieveChunkRequest{}
+	mi := &file_storage_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RetrieveChunkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetrieveChunkRequest) ProtoMessage() {}
+
+func (x *RetrieveChunkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetrieveChunkRequest.ProtoReflect.Descriptor instead.
+func (*RetrieveChunkRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RetrieveChunkRequest) GetChunkHash() string {
+	if x != nil {
+		return x.ChunkHash
+	}
+	return ""
+}
+
+type StoreChunkRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ChunkHash     string                 `protobuf:"bytes,1,opt,name=chunk_hash,json=chunkHash,proto3" json:"chunk_hash,omitempty"`
+	ChunkData     []byte                 `protobuf:"bytes,2,opt,name=chunk_data,json=chunkData,proto3" json:"chunk_data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StoreChunkRequest) Reset() {
+	*x = StoreChunkRequest{}
+	mi := &file_storage_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StoreChunkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoreChunkRequest) ProtoMessage() {}
+
+func (x *StoreChunkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoreChunkRequest.ProtoReflect.Descriptor instead.
+func (*StoreChunkRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StoreChunkRequest) GetChunkHash() string {
+	if x != nil {
+		return x.ChunkHash
+	}
+	return ""
+}
+
+func (x *StoreChunkRequest) GetChunkData() []byte {
+	if x != nil {
+		return x.ChunkData
+	}
+	return nil
+}
+
+type BatchStoreRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Chunks        []*StoreChunkRequest   `protobuf:"bytes,1,rep,name=chunks,proto3" json:"chunks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchStoreRequest) Reset() {
+	*x = BatchStoreRequest{}
+	mi := &file_storage_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchStoreRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchStoreRequest) ProtoMessage() {}
+
+func (x *BatchStoreRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchStoreRequest.ProtoReflect.Descriptor instead.
+func (*BatchStoreRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BatchStoreRequest) GetChunks() []*StoreChunkRequest {
+	if x != nil {
+		return x.Chunks
+	}
+	return nil
+}
+
+type BatchStoreResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	Results       []*StoreChunkResponse  `protobuf:"bytes,2,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchStoreResponse) Reset() {
+	*x = BatchStoreResponse{}
+	mi := &file_storage_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchStoreResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchStoreResponse) ProtoMessage() {}
+
+func (x *BatchStoreResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchStoreResponse.ProtoReflect.Descriptor instead.
+func (*BatchStoreResponse) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BatchStoreResponse) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *BatchStoreResponse) GetResults() []*StoreChunkResponse {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type ExistsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ChunkHashes   []string               `protobuf:"bytes,1,rep,name=chunk_hashes,json=chunkHashes,proto3" json:"chunk_hashes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExistsRequest) Reset() {
+	*x = ExistsRequest{}
+	mi := &file_storage_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExistsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExistsRequest) ProtoMessage() {}
+
+func (x *ExistsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExistsRequest.ProtoReflect.Descriptor instead.
+func (*ExistsRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ExistsRequest) GetChunkHashes() []string {
+	if x != nil {
+		return x.ChunkHashes
+	}
+	return nil
+}
+
+type ExistsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Present       []string               `protobuf:"bytes,1,rep,name=present,proto3" json:"present,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExistsResponse) Reset() {
+	*x = ExistsResponse{}
+	mi := &file_storage_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExistsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExistsResponse) ProtoMessage() {}
+
+func (x *ExistsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExistsResponse.ProtoReflect.Descriptor instead.
+func (*ExistsResponse) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ExistsResponse) GetPresent() []string {
+	if x != nil {
+		return x.Present
+	}
+	return nil
+}
+
+type DeleteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ChunkHash     string                 `protobuf:"bytes,1,opt,name=chunk_hash,json=chunkHash,proto3" json:"chunk_hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_storage_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DeleteRequest) GetChunkHash() string {
+	if x != nil {
+		return x.ChunkHash
+	}
+	return ""
+}
+
+type DeleteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Deleted       bool                   `protobuf:"varint,1,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteResponse) Reset() {
+	*x = DeleteResponse{}
+	mi := &file_storage_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteResponse) ProtoMessage() {}
+
+func (x *DeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_storage_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteResponse.ProtoReflect.Descriptor instead.
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return file_storage_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *DeleteResponse) GetDeleted() bool {
+	if x != nil {
+		return x.Deleted
+	}
+	return false
+}
+
+var File_storage_proto protoreflect.FileDescriptor
+
+const file_storage_proto_rawDesc = "" +
+	"\n" +
+	"\rstorage.proto\x12\vdfs.node.v1\"?\n" +
+	"\n" +
+	"ChunkPiece\x12\x1d\n" +
+	"\n" +
+	"chunk_hash\x18\x01 \x01(\tR\tchunkHash\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"|\n" +
+	"\x12StoreChunkResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x17\n" +
+	"\anode_id\x18\x02 \x01(\tR\x06nodeId\x12\x1d\n" +
+	"\n" +
+	"chunk_hash\x18\x03 \x01(\tR\tchunkHash\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"5\n" +
+	"\x14RetrieveChunkRequest\x12\x1d\n" +
+	"\n" +
+	"chunk_hash\x18\x01 \x01(\tR\tchunkHash\"Q\n" +
+	"\x11StoreChunkRequest\x12\x1d\n" +
+	"\n" +
+	"chunk_hash\x18\x01 \x01(\tR\tchunkHash\x12\x1d\n" +
+	"\n" +
+	"chunk_data\x18\x02 \x01(\fR\tchunkData\"K\n" +
+	"\x11BatchStoreRequest\x126\n" +
+	"\x06chunks\x18\x01 \x03(\v2\x1e.dfs.node.v1.StoreChunkRequestR\x06chunks\"h\n" +
+	"\x12BatchStoreResponse\x12\x17\n" +
+	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x129\n" +
+	"\aresults\x18\x02 \x03(\v2\x1f.dfs.node.v1.StoreChunkResponseR\aresults\"2\n" +
+	"\rExistsRequest\x12!\n" +
+	"\fchunk_hashes\x18\x01 \x03(\tR\vchunkHashes\"*\n" +
+	"\x0eExistsResponse\x12\x18\n" +
+	"\apresent\x18\x01 \x03(\tR\apresent\".\n" +
+	"\rDeleteRequest\x12\x1d\n" +
+	"\n" +
+	"chunk_hash\x18\x01 \x01(\tR\tchunkHash\"*\n" +
+	"\x0eDeleteResponse\x12\x18\n" +
+	"\adeleted\x18\x01 \x01(\bR\adeleted2\xfb\x02\n" +
+	"\vStorageNode\x12H\n" +
+	"\n" +
+	"StoreChunk\x12\x17.dfs.node.v1.ChunkPiece\x1a\x1f.dfs.node.v1.StoreChunkResponse(\x01\x12M\n" +
+	"\rRetrieveChunk\x12!.dfs.node.v1.RetrieveChunkRequest\x1a\x17.dfs.node.v1.ChunkPiece0\x01\x12M\n" +
+	"\n" +
+	"BatchStore\x12\x1e.dfs.node.v1.BatchStoreRequest\x1a\x1f.dfs.node.v1.BatchStoreResponse\x12A\n" +
+	"\x06Exists\x12\x1a.dfs.node.v1.ExistsRequest\x1a\x1b.dfs.node.v1.ExistsResponse\x12A\n" +
+	"\x06Delete\x12\x1a.dfs.node.v1.DeleteRequest\x1a\x1b.dfs.node.v1.DeleteResponseBCZAgithub.com/noorimat/distributed-file-storage/internal/node/nodepbb\x06proto3"
+
+var (
+	file_storage_proto_rawDescOnce sync.Once
+	file_storage_proto_rawDescData []byte
+)
+
+func file_storage_proto_rawDescGZIP() []byte {
+	file_storage_proto_rawDescOnce.Do(func() {
+		file_storage_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_storage_proto_rawDesc), len(file_storage_proto_rawDesc)))
+	})
+	return file_storage_proto_rawDescData
+}
+
+var file_storage_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_storage_proto_goTypes = []any{
+	(*ChunkPiece)(nil),           // 0: dfs.node.v1.ChunkPiece
+	(*StoreChunkResponse)(nil),   // 1: dfs.node.v1.StoreChunkResponse
+	(*RetrieveChunkRequest)(nil), // 2: dfs.node.v1.RetrieveChunkRequest
+	(*StoreChunkRequest)(nil),    // 3: dfs.node.v1.StoreChunkRequest
+	(*BatchStoreRequest)(nil),    // 4: dfs.node.v1.BatchStoreRequest
+	(*BatchStoreResponse)(nil),   // 5: dfs.node.v1.BatchStoreResponse
+	(*ExistsRequest)(nil),        // 6: dfs.node.v1.ExistsRequest
+	(*ExistsResponse)(nil),       // 7: dfs.node.v1.ExistsResponse
+	(*DeleteRequest)(nil),        // 8: dfs.node.v1.DeleteRequest
+	(*DeleteResponse)(nil),       // 9: dfs.node.v1.DeleteResponse
+}
+var file_storage_proto_depIdxs = []int32{
+	3, // 0: dfs.node.v1.BatchStoreRequest.chunks:type_name -> dfs.node.v1.StoreChunkRequest
+	1, // 1: dfs.node.v1.BatchStoreResponse.results:type_name -> dfs.node.v1.StoreChunkResponse
+	0, // 2: dfs.node.v1.StorageNode.StoreChunk:input_type -> dfs.node.v1.ChunkPiece
+	2, // 3: dfs.node.v1.StorageNode.RetrieveChunk:input_type -> dfs.node.v1.RetrieveChunkRequest
+	4, // 4: dfs.node.v1.StorageNode.BatchStore:input_type -> dfs.node.v1.BatchStoreRequest
+	6, // 5: dfs.node.v1.StorageNode.Exists:input_type -> dfs.node.v1.ExistsRequest
+	8, // 6: dfs.node.v1.StorageNode.Delete:input_type -> dfs.node.v1.DeleteRequest
+	1, // 7: dfs.node.v1.StorageNode.StoreChunk:output_type -> dfs.node.v1.StoreChunkResponse
+	0, // 8: dfs.node.v1.StorageNode.RetrieveChunk:output_type -> dfs.node.v1.ChunkPiece
+	5, // 9: dfs.node.v1.StorageNode.BatchStore:output_type -> dfs.node.v1.BatchStoreResponse
+	7, // 10: dfs.node.v1.StorageNode.Exists:output_type -> dfs.node.v1.ExistsResponse
+	9, // 11: dfs.node.v1.StorageNode.Delete:output_type -> dfs.node.v1.DeleteResponse
+	7, // [7:12] is the sub-list for method output_type
+	2, // [2:7] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_storage_proto_init() }
+func file_storage_proto_init() {
+	if File_storage_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_storage_proto_rawDesc), len(file_storage_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_storage_proto_goTypes,
+		DependencyIndexes: file_storage_proto_depIdxs,
+		MessageInfos:      file_storage_proto_msgTypes,
+	}.Build()
+	File_storage_proto = out.File
+	file_storage_proto_goTypes = nil
+	file_storage_proto_depIdxs = nil
+}
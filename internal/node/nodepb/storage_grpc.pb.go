@@ -0,0 +1,287 @@
+// Protocol for the coordinator <-> storage node data path. It mirrors the
+// JSON messages in internal/node/protocol.go but carries chunk data as raw
+// bytes, and streams single chunks in pieces so large chunks are never
+// buffered into one oversized message.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: storage.proto
+
+package nodepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	StorageNode_StoreChunk_FullMethodName    = "/dfs.node.v1.StorageNode/StoreChunk"
+	StorageNode_RetrieveChunk_FullMethodName = "/dfs.node.v1.StorageNode/RetrieveChunk"
+	StorageNode_BatchStore_FullMethodName    = "/dfs.node.v1.StorageNode/BatchStore"
+	StorageNode_Exists_FullMethodName        = "/dfs.node.v1.StorageNode/Exists"
+	StorageNode_Delete_FullMethodName        = "/dfs.node.v1.StorageNode/Delete"
+)
+
+// StorageNodeClient is the client API for StorageNode service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type StorageNodeClient interface {
+	// StoreChunk receives one chunk as a stream of pieces. The first piece must
+	// carry the chunk hash.
+	StoreChunk(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[ChunkPiece, StoreChunkResponse], error)
+	// RetrieveChunk streams a stored chunk back in pieces
+	RetrieveChunk(ctx context.Context, in *RetrieveChunkRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChunkPiece], error)
+	// BatchStore stores several small chunks in one call
+	BatchStore(ctx context.Context, in *BatchStoreRequest, opts ...grpc.CallOption) (*BatchStoreResponse, error)
+	// Exists reports which of the given chunks the node holds
+	Exists(ctx context.Context, in *ExistsRequest, opts ...grpc.CallOption) (*ExistsResponse, error)
+	// Delete removes a chunk the coordinator no longer references
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+}
+
+type storageNodeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStorageNodeClient(cc grpc.ClientConnInterface) StorageNodeClient {
+	return &storageNodeClient{cc}
+}
+
+func (c *storageNodeClient) StoreChunk(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[ChunkPiece, StoreChunkResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &StorageNode_ServiceDesc.Streams[0], StorageNode_StoreChunk_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ChunkPiece, StoreChunkResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StorageNode_StoreChunkClient = grpc.ClientStreamingClient[ChunkPiece, StoreChunkResponse]
+
+func (c *storageNodeClient) RetrieveChunk(ctx context.Context, in *RetrieveChunkRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChunkPiece], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &StorageNode_ServiceDesc.Streams[1], StorageNode_RetrieveChunk_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RetrieveChunkRequest, ChunkPiece]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StorageNode_RetrieveChunkClient = grpc.ServerStreamingClient[ChunkPiece]
+
+func (c *storageNodeClient) BatchStore(ctx context.Context, in *BatchStoreRequest, opts ...grpc.CallOption) (*BatchStoreResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchStoreResponse)
+	err := c.cc.Invoke(ctx, StorageNode_BatchStore_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageNodeClient) Exists(ctx context.Context, in *ExistsRequest, opts ...grpc.CallOption) (*ExistsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExistsResponse)
+	err := c.cc.Invoke(ctx, StorageNode_Exists_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageNodeClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, StorageNode_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StorageNodeServer is the server API for StorageNode service.
+// All implementations must embed UnimplementedStorageNodeServer
+// for forward compatibility.
+type StorageNodeServer interface {
+	// StoreChunk receives one chunk as a stream of pieces. The first piece must
+	// carry the chunk hash.
+	StoreChunk(grpc.ClientStreamingServer[ChunkPiece, StoreChunkResponse]) error
+	// RetrieveChunk streams a stored chunk back in pieces
+	RetrieveChunk(*RetrieveChunkRequest, grpc.ServerStreamingServer[ChunkPiece]) error
+	// BatchStore stores several small chunks in one call
+	BatchStore(context.Context, *BatchStoreRequest) (*BatchStoreResponse, error)
+	// Exists reports which of the given chunks the node holds
+	Exists(context.Context, *ExistsRequest) (*ExistsResponse, error)
+	// Delete removes a chunk the coordinator no longer references
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	mustEmbedUnimplementedStorageNodeServer()
+}
+
+// UnimplementedStorageNodeServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedStorageNodeServer struct{}
+
+func (UnimplementedStorageNodeServer) StoreChunk(grpc.ClientStreamingServer[ChunkPiece, StoreChunkResponse]) error {
+	return status.Error(codes.Unimplemented, "method StoreChunk not implemented")
+}
+func (UnimplementedStorageNodeServer) RetrieveChunk(*RetrieveChunkRequest, grpc.ServerStreamingServer[ChunkPiece]) error {
+	return status.Error(codes.Unimplemented, "method RetrieveChunk not implemented")
+}
+func (UnimplementedStorageNodeServer) BatchStore(context.Context, *BatchStoreRequest) (*BatchStoreResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method BatchStore not implemented")
+}
+func (UnimplementedStorageNodeServer) Exists(context.Context, *ExistsRequest) (*ExistsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Exists not implemented")
+}
+func (UnimplementedStorageNodeServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedStorageNodeServer) mustEmbedUnimplementedStorageNodeServer() {}
+func (UnimplementedStorageNodeServer) testEmbeddedByValue()                     {}
+
+// UnsafeStorageNodeServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StorageNodeServer will
+// result in compilation errors.
+type UnsafeStorageNodeServer interface {
+	mustEmbedUnimplementedStorageNodeServer()
+}
+
+func RegisterStorageNodeServer(s grpc.ServiceRegistrar, srv StorageNodeServer) {
+	// If the following call panics, it indicates UnimplementedStorageNodeServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&StorageNode_ServiceDesc, srv)
+}
+
+func _StorageNode_StoreChunk_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StorageNodeServer).StoreChunk(&grpc.GenericServerStream[ChunkPiece, StoreChunkResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StorageNode_StoreChunkServer = grpc.ClientStreamingServer[ChunkPiece, StoreChunkResponse]
+
+func _StorageNode_RetrieveChunk_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RetrieveChunkRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageNodeServer).RetrieveChunk(m, &grpc.GenericServerStream[RetrieveChunkRequest, ChunkPiece]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StorageNode_RetrieveChunkServer = grpc.ServerStreamingServer[ChunkPiece]
+
+func _StorageNode_BatchStore_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchStoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageNodeServer).BatchStore(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StorageNode_BatchStore_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageNodeServer).BatchStore(ctx, req.(*BatchStoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageNode_Exists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExistsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageNodeServer).Exists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StorageNode_Exists_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageNodeServer).Exists(ctx, req.(*ExistsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageNode_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageNodeServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StorageNode_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageNodeServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// StorageNode_ServiceDesc is the grpc.ServiceDesc for StorageNode service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var StorageNode_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dfs.node.v1.StorageNode",
+	HandlerType: (*StorageNodeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "BatchStore",
+			Handler:    _StorageNode_BatchStore_Handler,
+		},
+		{
+			MethodName: "Exists",
+			Handler:    _StorageNode_Exists_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _StorageNode_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StoreChunk",
+			Handler:       _StorageNode_StoreChunk_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "RetrieveChunk",
+			Handler:       _StorageNode_RetrieveChunk_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "storage.proto",
+}
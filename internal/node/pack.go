@@ -0,0 +1,419 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+const (
+	// DefaultCompactInterval is how often small chunks are packed
+	DefaultCompactInterval = time.Hour
+
+	// maxPackSize is the size at which a pack file stops receiving chunks
+	maxPackSize = 256 << 20
+
+	// packDirName holds pack files and their index inside the storage path
+	packDirName = "packs"
+)
+
+// packEntry locates a chunk inside a pack file
+type packEntry struct {
+	Pack   string `json:"pack"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// packStore keeps small chunks appended to a few large pack files instead
+// of one file each, which saves inodes on nodes holding many small chunks.
+// Pack files are append-only; the index maps each packed chunk to its byte
+// range and is rewritten (atomically) whenever it changes.
+type packStore struct {
+	dir     string
+	mu      sync.RWMutex
+	entries map[string]packEntry // hash -> location
+}
+
+// openPackStore loads the pack index from dir, creating dir if needed
+func openPackStore(dir string) (*packStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	ps := &packStore{dir: dir, entries: make(map[string]packEntry)}
+
+	data, err := os.ReadFile(ps.indexPath())
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack index: %w", err)
+	}
+	if err := json.Unmarshal(data, &ps.entries); err != nil {
+		return nil, fmt.Errorf("failed to parse pack index: %w", err)
+	}
+
+	return ps, nil
+}
+
+func (ps *packStore) indexPath() string {
+	return filepath.Join(ps.dir, "index.json")
+}
+
+// saveIndex writes the index to a temporary file and renames it into
+// place, so a crash never leaves a partial index. Callers hold ps.mu.
+func (ps *packStore) saveIndex() error {
+	data, err := json.Marshal(ps.entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := ps.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write pack index: %w", err)
+	}
+	return os.Rename(tmp, ps.indexPath())
+}
+
+// sizes returns the length of every packed chunk
+func (ps *packStore) sizes() map[string]int64 {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	sizes := make(map[string]int64, len(ps.entries))
+	for hash, entry := range ps.entries {
+		sizes[hash] = entry.Length
+	}
+	return sizes
+}
+
+// read returns a packed chunk's data, or errChunkNotFound
+func (ps *packStore) read(hash string) ([]byte, error) {
+	// Hold the read lock so compaction can't remove the pack mid-read
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	entry, ok := ps.entries[hash]
+	if !ok {
+		return nil, errChunkNotFound
+	}
+
+	f, err := os.Open(filepath.Join(ps.dir, entry.Pack))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, entry.Length)
+	if _, err := f.ReadAt(data, entry.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read packed chunk: %w", err)
+	}
+	return data, nil
+}
+
+// open returns a reader over a packed chunk and its length, or
+// errChunkNotFound. The open pack file stays readable even if compaction
+// removes it before the reader is closed.
+func (ps *packStore) open(hash string) (io.ReadCloser, int64, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	entry, ok := ps.entries[hash]
+	if !ok {
+		return nil, 0, errChunkNotFound
+	}
+
+	f, err := os.Open(filepath.Join(ps.dir, entry.Pack))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	chunk := struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, entry.Offset, entry.Length), f}
+	return chunk, entry.Length, nil
+}
+
+// remove drops a chunk from the index. Its bytes stay in the pack until
+// the pack is rewritten.
+func (ps *packStore) remove(hash string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, ok := ps.entries[hash]; !ok {
+		return nil
+	}
+	delete(ps.entries, hash)
+	return ps.saveIndex()
+}
+
+// sparsePacks returns packs, other than the newest, in which less than half
+// of the bytes still belong to indexed chunks
+func (ps *packStore) sparsePacks() (map[string][]string, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	names, err := ps.packNames()
+	if err != nil || len(names) < 2 {
+		return nil, err
+	}
+
+	live := make(map[string]int64)
+	hashes := make(map[string][]string)
+	for hash, entry := range ps.entries {
+		live[entry.Pack] += entry.Length
+		hashes[entry.Pack] = append(hashes[entry.Pack], hash)
+	}
+
+	sparse := make(map[string][]string)
+	for _, name := range names[:len(names)-1] {
+		info, err := os.Stat(filepath.Join(ps.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if live[name]*2 < info.Size() {
+			sparse[name] = hashes[name]
+		}
+	}
+	return sparse, nil
+}
+
+// packNames lists the pack files in creation order. Callers hold ps.mu.
+func (ps *packStore) packNames() ([]string, error) {
+	dirEntries, err := os.ReadDir(ps.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range dirEntries {
+		if strings.HasPrefix(entry.Name(), "pack-") && strings.HasSuffix(entry.Name(), ".dat") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// openActivePack opens the newest pack for appending, or starts a new one
+// if it is full. Callers hold ps.mu.
+func (ps *packStore) openActivePack() (*os.File, string, int64, error) {
+	names, err := ps.packNames()
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	seq := 1
+	if len(names) > 0 {
+		last := names[len(names)-1]
+		if _, err := fmt.Sscanf(last, "pack-%06d.dat", &seq); err != nil {
+			return nil, "", 0, fmt.Errorf("unexpected pack file %s", last)
+		}
+
+		info, err := os.Stat(filepath.Join(ps.dir, last))
+		if err != nil {
+			return nil, "", 0, err
+		}
+		if info.Size() >= maxPackSize {
+			seq++
+		}
+	}
+
+	name := fmt.Sprintf("pack-%06d.dat", seq)
+	f, err := os.OpenFile(filepath.Join(ps.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, "", 0, err
+	}
+	return f, name, info.Size(), nil
+}
+
+// write appends chunks to the active pack, rolling over to a new pack when
+// it fills up, and returns where each one landed. The index is not changed;
+// the caller adds the entries once the pack is synced. Callers hold ps.mu.
+func (ps *packStore) write(chunks map[string][]byte) (map[string]packEntry, error) {
+	hashes := make([]string, 0, len(chunks))
+	for hash := range chunks {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	written := make(map[string]packEntry, len(chunks))
+	var f *os.File
+	var name string
+	var offset int64
+
+	closePack := func() error {
+		if f == nil {
+			return nil
+		}
+		err := f.Sync()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		f = nil
+		return err
+	}
+
+	for _, hash := range hashes {
+		if f == nil || offset >= maxPackSize {
+			if err := closePack(); err != nil {
+				return nil, err
+			}
+
+			var err error
+			if f, name, offset, err = ps.openActivePack(); err != nil {
+				return nil, fmt.Errorf("failed to open pack: %w", err)
+			}
+		}
+
+		data := chunks[hash]
+		if _, err := f.Write(data); err != nil {
+			closePack()
+			return nil, fmt.Errorf("failed to append to pack: %w", err)
+		}
+		written[hash] = packEntry{Pack: name, Offset: offset, Length: int64(len(data))}
+		offset += int64(len(data))
+	}
+
+	if err := closePack(); err != nil {
+		return nil, fmt.Errorf("failed to sync pack: %w", err)
+	}
+	return written, nil
+}
+
+// startCompactor periodically packs small chunks
+func (sn *StorageNode) startCompactor() {
+	if sn.packs == nil || sn.CompactInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sn.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sn.stop:
+			return
+		case <-ticker.C:
+			if err := sn.compactChunks(); err != nil {
+				log.Printf("Compaction failed: %v", err)
+			}
+		}
+	}
+}
+
+// compactChunks moves chunks smaller than PackThreshold from their own files
+// into a pack, and rewrites packs that are mostly deleted chunks. Each chunk
+// is verified against its hash before it is packed, so a corrupted file is
+// left for the scrubber instead of being copied.
+func (sn *StorageNode) compactChunks() error {
+	// Serializes with deleteChunk so a chunk deleted mid-compaction isn't
+	// added back to the index
+	sn.packLock.Lock()
+	defer sn.packLock.Unlock()
+
+	sn.chunksLock.RLock()
+	var candidates []string
+	for hash, size := range sn.chunks {
+		if size < sn.PackThreshold {
+			candidates = append(candidates, hash)
+		}
+	}
+	sn.chunksLock.RUnlock()
+
+	chunks := make(map[string][]byte)
+	var loose []string
+	for _, hash := range candidates {
+		data, err := os.ReadFile(sn.chunkPath(hash))
+		if os.IsNotExist(err) {
+			continue // Already packed
+		}
+		if err != nil {
+			log.Printf("Compaction: failed to read chunk %s: %v", hash[:8], err)
+			continue
+		}
+
+		if _, ok := chunking.MatchHash(data, hash); !ok {
+			log.Printf("Compaction: chunk %s does not match its hash, skipping", hash[:8])
+			continue
+		}
+
+		chunks[hash] = data
+		loose = append(loose, hash)
+	}
+
+	sparse, err := sn.packs.sparsePacks()
+	if err != nil {
+		return fmt.Errorf("failed to inspect packs: %w", err)
+	}
+	for _, hashes := range sparse {
+		for _, hash := range hashes {
+			if _, ok := chunks[hash]; ok {
+				continue
+			}
+			data, err := sn.packs.read(hash)
+			if err != nil {
+				return fmt.Errorf("failed to read chunk %s for repacking: %w", hash[:8], err)
+			}
+			chunks[hash] = data
+		}
+	}
+
+	if len(chunks) == 0 && len(sparse) == 0 {
+		return nil
+	}
+
+	ps := sn.packs
+	ps.mu.Lock()
+	written, err := ps.write(chunks)
+	if err != nil {
+		ps.mu.Unlock()
+		return err
+	}
+
+	for hash, entry := range written {
+		ps.entries[hash] = entry
+	}
+	if err := ps.saveIndex(); err != nil {
+		// The old index still points at valid data; the appended bytes are
+		// wasted until the pack is rewritten
+		for hash := range written {
+			delete(ps.entries, hash)
+		}
+		ps.mu.Unlock()
+		return err
+	}
+
+	// Nothing in the index points at the sparse packs any more
+	for name := range sparse {
+		if err := os.Remove(filepath.Join(ps.dir, name)); err != nil {
+			log.Printf("Compaction: failed to remove pack %s: %v", name, err)
+		}
+	}
+	ps.mu.Unlock()
+
+	for _, hash := range loose {
+		if err := os.Remove(sn.chunkPath(hash)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Compaction: failed to remove packed chunk file %s: %v", hash[:8], err)
+		}
+	}
+
+	log.Printf("Compaction complete: packed %d chunks, rewrote %d packs", len(loose), len(sparse))
+	return nil
+}
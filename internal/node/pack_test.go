@@ -0,0 +1,129 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/throttle"
+)
+
+// TestCompactChunks packs a node's small chunks and checks they leave their
+// own files, read back unchanged through every path, survive a restart, and
+// can still be deleted; large chunks keep their files
+func TestCompactChunks(t *testing.T) {
+	sn := newTestNode(t)
+	sn.PackThreshold = 1 << 10
+	sn.CompactInterval = 0
+	client := startTestNode(t, sn)
+	ctx := context.Background()
+
+	chunks := make(map[string][]byte)
+	var small []string
+	for i := 0; i < 50; i++ {
+		data, hash := testChunk(t, 1+i*10)
+		chunks[hash] = data
+		small = append(small, hash)
+	}
+	large, largeHash := testChunk(t, 4<<10)
+	chunks[largeHash] = large
+	for hash, data := range chunks {
+		if err := client.Store(ctx, hash, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sn.compactChunks(); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range small {
+		if _, err := os.Stat(sn.chunkPath(hash)); !os.IsNotExist(err) {
+			t.Errorf("small chunk %s still has its own file", hash[:8])
+		}
+	}
+	if _, err := os.Stat(sn.chunkPath(largeHash)); err != nil {
+		t.Errorf("large chunk lost its own file: %v", err)
+	}
+
+	readAll := func(t *testing.T, client *NodeClient) {
+		t.Helper()
+		for hash, data := range chunks {
+			got, err := client.Retrieve(ctx, hash)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("chunk %s does not read back: %v", hash[:8], err)
+			}
+
+			stream, size, err := client.Open(ctx, hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			streamed, err := io.ReadAll(stream)
+			stream.Close()
+			if err != nil || size != int64(len(data)) || !bytes.Equal(streamed, data) {
+				t.Fatalf("chunk %s does not stream back: %v", hash[:8], err)
+			}
+		}
+	}
+	readAll(t, client)
+	for _, hash := range small[:5] {
+		if ok, err := sn.verifyChunk(hash, throttle.NewLimiter(0)); !ok || err != nil {
+			t.Errorf("packed chunk %s fails scrubbing: %v", hash[:8], err)
+		}
+	}
+
+	// Compacting again leaves everything where it is
+	if err := sn.compactChunks(); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, client)
+
+	// A restarted node finds the packed chunks
+	if err := shutdownTestNode(sn); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewStorageNode("node-1", "", sn.StoragePath, "")
+	restarted.ScrubInterval, restarted.SweepInterval = 0, 0
+	client = startTestNode(t, restarted)
+	readAll(t, client)
+
+	deleted := small[0]
+	if err := client.Delete(ctx, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := client.Exists(ctx, deleted); err != nil || exists {
+		t.Errorf("deleted packed chunk still exists: %v", err)
+	}
+	if _, err := restarted.packs.read(deleted); err != errChunkNotFound {
+		t.Errorf("deleted chunk still in the pack index: %v", err)
+	}
+	if packs, err := filepath.Glob(filepath.Join(sn.StoragePath, packDirName, "pack-*.dat")); err != nil || len(packs) != 1 {
+		t.Errorf("want the small chunks in one pack, got %v", packs)
+	}
+}
+
+// TestCompactSkipsCorruptChunk checks a chunk file that no longer matches
+// its hash is left for the scrubber instead of being packed
+func TestCompactSkipsCorruptChunk(t *testing.T) {
+	sn := newTestNode(t)
+	sn.PackThreshold = 1 << 10
+	sn.CompactInterval = 0
+	startTestNode(t, sn)
+
+	_, hash := storeTestChunk(t, sn, 100)
+	if err := os.WriteFile(sn.chunkPath(hash), []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sn.compactChunks(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sn.chunkPath(hash)); err != nil {
+		t.Errorf("corrupt chunk file removed: %v", err)
+	}
+	if _, err := sn.packs.read(hash); err != errChunkNotFound {
+		t.Errorf("corrupt chunk packed: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package node
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Placement strategies a Placer can be created for
+const (
+	PlacementConsistentHash = "consistent-hash" // Where the hash ring puts the chunk
+	PlacementLeastUsed      = "least-used"      // The healthy nodes holding the fewest chunks
+	PlacementRoundRobin     = "round-robin"     // The next healthy nodes in turn
+)
+
+// Placer chooses the nodes a new chunk's replicas are stored on. It returns
+// at most count distinct nodes, fewer if there aren't enough, and none if no
+// node is available. Nodes that are Full are passed over.
+type Placer interface {
+	SelectNodes(chunkHash string, count int, registry *Registry) []string
+}
+
+// NewPlacer returns the Placer for a placement strategy. The consistent hash
+// strategy places chunks on ring.
+func NewPlacer(strategy string, ring *ConsistentHash) (Placer, error) {
+	switch strategy {
+	case PlacementConsistentHash:
+		return &ConsistentHashPlacer{Ring: ring}, nil
+	case PlacementLeastUsed:
+		return NewLeastUsedPlacer(), nil
+	case PlacementRoundRobin:
+		return &RoundRobinPlacer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown placement strategy %q (expected %s, %s or %s)",
+			strategy, PlacementConsistentHash, PlacementLeastUsed, PlacementRoundRobin)
+	}
+}
+
+// ConsistentHashPlacer places chunks where the hash ring puts them, spread
+// across zones. It is the only strategy under which a chunk's nodes can be
+// worked out from its hash alone.
+type ConsistentHashPlacer struct {
+	Ring *ConsistentHash
+}
+
+// SelectNodes returns the chunk's replica nodes on the ring, with full
+// nodes replaced by the next nodes along it. Only fullness is looked up in
+// the registry: the ring holds every registered node, healthy or not.
+func (p *ConsistentHashPlacer) SelectNodes(chunkHash string, count int, registry *Registry) []string {
+	nodes, err := PlaceOnRing(p.Ring, chunkHash, count, registry.GetHealthyNodes())
+	if err != nil {
+		return nil
+	}
+	return nodes
+}
+
+// Ring walks the hash ring from a chunk's hash, as ConsistentHash does
+type Ring interface {
+	GetNodes(chunkHash string, count int) ([]string, error)
+}
+
+// PlaceOnRing returns the first count nodes along the ring from a chunk's
+// hash, skipping those among healthy that are full. While none are, these
+// are the chunk's replica nodes.
+func PlaceOnRing(ring Ring, chunkHash string, count int, healthy []*NodeInfo) ([]string, error) {
+	full := make(map[string]bool)
+	for _, nodeInfo := range healthy {
+		if nodeInfo.Full() {
+			full[nodeInfo.NodeID] = true
+		}
+	}
+	if len(full) == 0 {
+		return ring.GetNodes(chunkHash, count)
+	}
+
+	// GetNodes caps count at the size of the ring, so this is every node
+	ringOrder, err := ring.GetNodes(chunkHash, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]string, 0, count)
+	for _, nodeID := range ringOrder {
+		if len(nodes) == count {
+			break
+		}
+		if !full[nodeID] {
+			nodes = append(nodes, nodeID)
+		}
+	}
+	return nodes, nil
+}
+
+// LeastUsedPlacer places chunks on the healthy nodes holding the fewest
+// chunks, other than full ones. Nodes report their chunk counts with each heartbeat; chunks placed
+// on a node since its last heartbeat are added to its count, so a burst of
+// uploads doesn't all land on the same nodes.
+type LeastUsedPlacer struct {
+	mu       sync.Mutex
+	assigned map[string]placedSince // node ID -> chunks placed since its last heartbeat
+}
+
+type placedSince struct {
+	heartbeat time.Time
+	chunks    int
+}
+
+// NewLeastUsedPlacer creates a LeastUsedPlacer
+func NewLeastUsedPlacer() *LeastUsedPlacer {
+	return &LeastUsedPlacer{assigned: make(map[string]placedSince)}
+}
+
+// SelectNodes returns the count least used healthy nodes. Ties go to the
+// node using fewer bytes, then to the lower node ID.
+func (p *LeastUsedPlacer) SelectNodes(chunkHash string, count int, registry *Registry) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	type candidate struct {
+		nodeID string
+		chunks int
+		used   int64
+	}
+	var candidates []candidate
+	for _, nodeInfo := range registry.GetHealthyNodes() {
+		if nodeInfo.Full() {
+			continue
+		}
+		placed := p.assigned[nodeInfo.NodeID]
+		if !placed.heartbeat.Equal(nodeInfo.LastSeen) {
+			// A newer heartbeat already counts what was placed before it
+			placed = placedSince{heartbeat: nodeInfo.LastSeen}
+			p.assigned[nodeInfo.NodeID] = placed
+		}
+		candidates = append(candidates, candidate{nodeInfo.NodeID, nodeInfo.TotalChunks + placed.chunks, nodeInfo.Used})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.chunks != b.chunks {
+			return a.chunks < b.chunks
+		}
+		if a.used != b.used {
+			return a.used < b.used
+		}
+		return a.nodeID < b.nodeID
+	})
+
+	selected := make([]string, 0, min(count, len(candidates)))
+	for _, c := range candidates[:min(count, len(candidates))] {
+		placed := p.assigned[c.nodeID]
+		placed.chunks++
+		p.assigned[c.nodeID] = placed
+		selected = append(selected, c.nodeID)
+	}
+	return selected
+}
+
+// RoundRobinPlacer places each chunk on the next count healthy nodes that
+// aren't full, in node ID order, starting one node further along for every
+// chunk
+type RoundRobinPlacer struct {
+	next atomic.Uint64
+}
+
+// SelectNodes returns count consecutive healthy nodes, wrapping around
+func (p *RoundRobinPlacer) SelectNodes(chunkHash string, count int, registry *Registry) []string {
+	var nodeIDs []string
+	for _, nodeInfo := range registry.GetHealthyNodes() {
+		if !nodeInfo.Full() {
+			nodeIDs = append(nodeIDs, nodeInfo.NodeID)
+		}
+	}
+	if len(nodeIDs) == 0 {
+		return nil
+	}
+	sort.Strings(nodeIDs)
+
+	start := int((p.next.Add(1) - 1) % uint64(len(nodeIDs)))
+	selected := make([]string, 0, min(count, len(nodeIDs)))
+	for i := 0; i < min(count, len(nodeIDs)); i++ {
+		selected = append(selected, nodeIDs[(start+i)%len(nodeIDs)])
+	}
+	return selected
+}
@@ -0,0 +1,163 @@
+package node
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// placerRegistry registers healthy nodes node-1 to node-n, each with a
+// heartbeat reporting no chunks stored
+func placerRegistry(t *testing.T, n int) *Registry {
+	t.Helper()
+
+	registry := NewRegistry(time.Minute)
+	for i := 1; i <= n; i++ {
+		nodeID := fmt.Sprintf("node-%d", i)
+		if err := registry.RegisterNode(&NodeInfo{NodeID: nodeID, Address: "127.0.0.1:1"}); err != nil {
+			t.Fatal(err)
+		}
+		heartbeat(t, registry, nodeID, 0, 0, 0)
+	}
+	return registry
+}
+
+func heartbeat(t *testing.T, registry *Registry, nodeID string, chunks int, used, capacity int64) {
+	t.Helper()
+
+	if err := registry.UpdateHeartbeat(nodeID, chunks, used, capacity); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewPlacer(t *testing.T) {
+	ring, err := NewConsistentHash(DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for strategy, want := range map[string]Placer{
+		PlacementConsistentHash: &ConsistentHashPlacer{Ring: ring},
+		PlacementLeastUsed:      NewLeastUsedPlacer(),
+		PlacementRoundRobin:     &RoundRobinPlacer{},
+	} {
+		placer, err := NewPlacer(strategy, ring)
+		if err != nil || reflect.TypeOf(placer) != reflect.TypeOf(want) {
+			t.Errorf("%s: want a %T, got %T (%v)", strategy, want, placer, err)
+		}
+	}
+	if _, err := NewPlacer("random", ring); err == nil {
+		t.Error("unknown strategy: want an error")
+	}
+}
+
+// TestConsistentHashPlacer checks chunks go to their replica nodes on the
+// ring, with a full node replaced by the next one along it
+func TestConsistentHashPlacer(t *testing.T) {
+	registry := placerRegistry(t, 4)
+	ring, err := NewConsistentHash(DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nodeInfo := range registry.GetAllNodes() {
+		ring.AddNode(nodeInfo.NodeID)
+	}
+	placer := &ConsistentHashPlacer{Ring: ring}
+
+	for i := range 20 {
+		hash := fmt.Sprintf("%064x", i)
+		want, err := ring.GetNodes(hash, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := placer.SelectNodes(hash, 2, registry); !reflect.DeepEqual(got, want) {
+			t.Errorf("chunk %d: want its ring nodes %v, got %v", i, want, got)
+		}
+	}
+
+	hash := fmt.Sprintf("%064x", 0)
+	ringOrder, err := ring.GetNodes(hash, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heartbeat(t, registry, ringOrder[0], 10, 100, 100)
+	if got := placer.SelectNodes(hash, 2, registry); !reflect.DeepEqual(got, ringOrder[1:3]) {
+		t.Errorf("first ring node full: want %v, got %v", ringOrder[1:3], got)
+	}
+	if got := placer.SelectNodes(hash, 10, registry); !reflect.DeepEqual(got, ringOrder[1:]) {
+		t.Errorf("more replicas than nodes: want every node with room %v, got %v", ringOrder[1:], got)
+	}
+}
+
+// TestLeastUsedPlacer checks chunks go to the nodes reporting the fewest
+// chunks, counting those placed since each node's last heartbeat, and skip
+// full and unhealthy nodes
+func TestLeastUsedPlacer(t *testing.T) {
+	registry := placerRegistry(t, 4)
+	heartbeat(t, registry, "node-1", 10, 0, 0)
+	heartbeat(t, registry, "node-2", 5, 0, 0)
+	heartbeat(t, registry, "node-3", 5, 1000, 0)
+	heartbeat(t, registry, "node-4", 20, 0, 0)
+	placer := NewLeastUsedPlacer()
+
+	// Ties go to the node using fewer bytes
+	if got := placer.SelectNodes("chunk-1", 2, registry); !reflect.DeepEqual(got, []string{"node-2", "node-3"}) {
+		t.Errorf("want the two nodes with 5 chunks, got %v", got)
+	}
+	// Each now counts 6, so node-2 and node-3 take chunks until they pass
+	// node-1's 10
+	for i := range 4 {
+		if got := placer.SelectNodes("chunk", 1, registry); len(got) != 1 || got[0] == "node-1" || got[0] == "node-4" {
+			t.Errorf("placement %d: want node-2 or node-3 while they hold fewer than 10, got %v", i, got)
+		}
+	}
+	if got := placer.SelectNodes("chunk", 3, registry); !reflect.DeepEqual(got, []string{"node-2", "node-3", "node-1"}) {
+		t.Errorf("with node-2 and node-3 at 8 chunks and node-1 at 10: want them least used first, got %v", got)
+	}
+
+	// A heartbeat replaces what was counted before it
+	heartbeat(t, registry, "node-4", 0, 0, 0)
+	if got := placer.SelectNodes("chunk", 1, registry); !reflect.DeepEqual(got, []string{"node-4"}) {
+		t.Errorf("node-4 reporting no chunks: want it chosen, got %v", got)
+	}
+
+	heartbeat(t, registry, "node-4", 0, 100, 100)
+	info, _ := registry.GetNode("node-1")
+	info.LastSeen = time.Now().Add(-2 * time.Minute)
+	if got := placer.SelectNodes("chunk", 4, registry); len(got) != 2 {
+		t.Errorf("node-1 offline and node-4 full: want only node-2 and node-3, got %v", got)
+	}
+
+	if got := NewLeastUsedPlacer().SelectNodes("chunk", 2, NewRegistry(time.Minute)); len(got) != 0 {
+		t.Errorf("no nodes: want none selected, got %v", got)
+	}
+}
+
+// TestRoundRobinPlacer checks each chunk starts one node further along the
+// nodes in ID order, wrapping around, and full nodes are skipped
+func TestRoundRobinPlacer(t *testing.T) {
+	registry := placerRegistry(t, 3)
+	placer := &RoundRobinPlacer{}
+
+	for i, want := range [][]string{
+		{"node-1", "node-2"},
+		{"node-2", "node-3"},
+		{"node-3", "node-1"},
+		{"node-1", "node-2"},
+	} {
+		if got := placer.SelectNodes("chunk", 2, registry); !reflect.DeepEqual(got, want) {
+			t.Errorf("chunk %d: want %v, got %v", i, want, got)
+		}
+	}
+
+	heartbeat(t, registry, "node-2", 1, chunking.MaxChunkSize, chunking.MaxChunkSize)
+	if got := placer.SelectNodes("chunk", 5, registry); len(got) != 2 || got[0] == "node-2" || got[1] == "node-2" {
+		t.Errorf("node-2 full: want node-1 and node-3, got %v", got)
+	}
+
+	if got := (&RoundRobinPlacer{}).SelectNodes("chunk", 2, NewRegistry(time.Minute)); got != nil {
+		t.Errorf("no nodes: want none selected, got %v", got)
+	}
+}
@@ -2,18 +2,59 @@ package node
 
 import (
 	"encoding/json"
+	"slices"
 	"time"
 )
 
+// Transports a node can serve chunks over
+const (
+	TransportJSON   = "json"   // /store and /retrieve, with chunk data base64 encoded in JSON
+	TransportBinary = "binary" // /chunk/{hash}, streaming a chunk's raw bytes
+	TransportGRPC   = "grpc"   // The gRPC data path on GRPCAddress
+	TransportDirect = "direct" // PUT /chunk/{hash} from clients holding a chunk grant
+)
+
+// GrantHeader carries the grant a client stores a chunk with over the
+// direct transport, in place of the cluster secret
+const GrantHeader = "X-Chunk-Grant"
+
 // NodeInfo represents metadata about a storage node
 type NodeInfo struct {
-	NodeID      string    `json:"node_id"`      // Unique identifier for this node
-	Address     string    `json:"address"`      // HTTP address (e.g., "localhost:9001")
-	Status      string    `json:"status"`       // "healthy", "degraded", "offline"
-	TotalChunks int       `json:"total_chunks"` // Number of chunks stored on this node
-	LastSeen    time.Time `json:"last_seen"`    // Last heartbeat timestamp
-	Capacity    int64     `json:"capacity"`     // Total storage capacity in bytes
-	Used        int64     `json:"used"`         // Used storage in bytes
+	NodeID      string    `json:"node_id"`                // Unique identifier for this node
+	Address     string    `json:"address"`                // HTTP address (e.g., "localhost:9001")
+	GRPCAddress string    `json:"grpc_address,omitempty"` // gRPC data path address, if the node serves one
+	Zone        string    `json:"zone,omitempty"`         // Failure domain (rack, zone); replicas are spread across zones
+	Status      string    `json:"status"`                 // "healthy", "degraded", "offline"
+	TotalChunks int       `json:"total_chunks"`           // Number of chunks stored on this node
+	LastSeen    time.Time `json:"last_seen"`              // Last heartbeat timestamp
+	Capacity    int64     `json:"capacity"`               // Total storage capacity in bytes
+	Used        int64     `json:"used"`                   // Used storage in bytes
+	LastProbe   time.Time `json:"last_probe"`             // Last active health probe by the coordinator
+	ProbeOK     bool      `json:"probe_ok"`               // Whether the last active probe succeeded
+
+	// HeartbeatInterval is how often the node sends heartbeats, as it
+	// reported on registration (0 if it didn't)
+	HeartbeatInterval time.Duration `json:"heartbeat_interval,omitempty"`
+
+	// Reported on registration so the coordinator can turn away a node it
+	// can't talk to and pick transports it serves; empty from nodes that
+	// predate them
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Version         string   `json:"version,omitempty"`
+	Transports      []string `json:"transports,omitempty"`
+}
+
+// Supports reports whether the node serves chunks over transport. A node
+// that reported no transports predates the binary transport: it serves
+// JSON, and gRPC if it advertised a gRPC address.
+func (n *NodeInfo) Supports(transport string) bool {
+	if transport == TransportGRPC && n.GRPCAddress == "" {
+		return false
+	}
+	if len(n.Transports) == 0 {
+		return transport == TransportJSON || transport == TransportGRPC
+	}
+	return slices.Contains(n.Transports, transport)
 }
 
 // ChunkLocation represents where a chunk is stored
@@ -36,6 +77,17 @@ type StoreChunkResponse struct {
 	Error     string `json:"error,omitempty"`
 }
 
+// BatchStoreRequest stores several chunks on a node in a single request
+type BatchStoreRequest struct {
+	Chunks []StoreChunkRequest `json:"chunks"`
+}
+
+// BatchStoreResponse reports the outcome of each chunk in a batch, in request order
+type BatchStoreResponse struct {
+	NodeID  string               `json:"node_id"`
+	Results []StoreChunkResponse `json:"results"`
+}
+
 // RetrieveChunkRequest asks a node for a specific chunk
 type RetrieveChunkRequest struct {
 	ChunkHash string `json:"chunk_hash"`
@@ -49,12 +101,20 @@ type RetrieveChunkResponse struct {
 	Error     string `json:"error,omitempty"`
 }
 
+// ListChunksResponse lists every chunk (or shard) a node holds
+type ListChunksResponse struct {
+	NodeID string   `json:"node_id"`
+	Count  int      `json:"count"`
+	Chunks []string `json:"chunks"`
+}
+
 // HeartbeatMessage is sent periodically by nodes to indicate they're alive
 type HeartbeatMessage struct {
 	NodeID      string    `json:"node_id"`
 	Address     string    `json:"address"`
 	TotalChunks int       `json:"total_chunks"`
-	Used        int64     `json:"used"`
+	Used        int64     `json:"used"`     // Bytes used by stored chunks
+	Capacity    int64     `json:"capacity"` // The node's quota, or its filesystem's capacity, in bytes
 	Timestamp   time.Time `json:"timestamp"`
 }
 
@@ -68,4 +128,30 @@ func NodeInfoFromJSON(data []byte) (*NodeInfo, error) {
 	var node NodeInfo
 	err := json.Unmarshal(data, &node)
 	return &node, err
-}
\ No newline at end of file
+}
+
+// DeregisterRequest is sent by a node when it is shutting down
+type DeregisterRequest struct {
+	NodeID string `json:"node_id"`
+}
+
+// CorruptChunkReport is sent by a node when its scrubber finds chunks whose
+// content no longer matches their hash
+type CorruptChunkReport struct {
+	NodeID      string    `json:"node_id"`
+	ChunkHashes []string  `json:"chunk_hashes"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// UnreferencedChunksRequest is sent by a node's sweeper to ask which of its
+// chunks the coordinator no longer references
+type UnreferencedChunksRequest struct {
+	NodeID      string   `json:"node_id"`
+	ChunkHashes []string `json:"chunk_hashes"`
+}
+
+// UnreferencedChunksResponse lists the requested chunks the coordinator
+// confirms are unreferenced. Chunks missing from it must be kept.
+type UnreferencedChunksResponse struct {
+	Unreferenced []string `json:"unreferenced"`
+}
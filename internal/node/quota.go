@@ -0,0 +1,59 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// errQuotaExceeded is returned when a store would take a node past its quota
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+// Full reports whether the node's last heartbeat left it less than a
+// maximum-size chunk of its capacity, so new chunks should go elsewhere. A
+// node that reported no capacity is never full.
+func (n *NodeInfo) Full() bool {
+	return n.Capacity > 0 && n.Capacity-n.Used < chunking.MaxChunkSize
+}
+
+// reserveQuota sets aside room for a chunk about to be written, failing
+// with errQuotaExceeded if it would take the node past Quota. A chunk
+// already held is rewritten in place and needs no more room. The returned
+// func gives the room back once the write is tracked or has failed.
+func (sn *StorageNode) reserveQuota(chunkHash string, size int64) (func(), error) {
+	if sn.Quota <= 0 {
+		return func() {}, nil
+	}
+
+	sn.chunksLock.Lock()
+	defer sn.chunksLock.Unlock()
+
+	if _, held := sn.chunks[chunkHash]; held {
+		return func() {}, nil
+	}
+	if sn.usedBytes+sn.reservedBytes+size > sn.Quota {
+		return nil, fmt.Errorf("%w: %d of %d bytes used, chunk needs %d", errQuotaExceeded, sn.usedBytes+sn.reservedBytes, sn.Quota, size)
+	}
+	sn.reservedBytes += size
+
+	return func() {
+		sn.chunksLock.Lock()
+		sn.reservedBytes -= size
+		sn.chunksLock.Unlock()
+	}, nil
+}
+
+// reportedCapacity is the capacity sent with heartbeats: the quota, if one
+// is set and the disk is at least that large, or else the disk's size
+func (sn *StorageNode) reportedCapacity() int64 {
+	capacity, err := diskCapacity(sn.StoragePath)
+	if err != nil {
+		log.Printf("Failed to read disk capacity: %v", err)
+	}
+	if sn.Quota > 0 && (capacity == 0 || sn.Quota < capacity) {
+		return sn.Quota
+	}
+	return capacity
+}
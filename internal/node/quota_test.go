@@ -0,0 +1,78 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// TestQuota fills a node to its quota and checks further stores are
+// refused, with 507 over HTTP, until a chunk is deleted, while a chunk
+// already held can still be stored again
+func TestQuota(t *testing.T) {
+	sn := newTestNode(t)
+	sn.Quota = 2500
+	startTestNode(t, sn)
+	ctx := context.Background()
+
+	data, hash := storeTestChunk(t, sn, 1000)
+	_, second := storeTestChunk(t, sn, 1000)
+	if capacity := sn.reportedCapacity(); capacity != sn.Quota {
+		t.Errorf("want the quota reported as capacity, got %d", capacity)
+	}
+
+	over, overHash := testChunk(t, 1000)
+	if err := sn.writeChunk(ctx, overHash, over); !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("past the quota: want errQuotaExceeded, got %v", err)
+	}
+	body, err := json.Marshal(StoreChunkRequest{ChunkHash: overHash, ChunkData: over})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post("http://"+sn.Address+"/store", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInsufficientStorage {
+		t.Errorf("past the quota over HTTP: want 507, got %s", resp.Status)
+	}
+	if sn.hasChunk(overHash) {
+		t.Error("refused chunk was stored")
+	}
+
+	if err := sn.writeChunk(ctx, hash, data); err != nil {
+		t.Errorf("chunk already held: want it stored again, got %v", err)
+	}
+	if err := sn.deleteChunk(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := sn.writeChunk(ctx, overHash, over); err != nil {
+		t.Errorf("room freed by a delete: want the chunk stored, got %v", err)
+	}
+}
+
+func TestNodeInfoFull(t *testing.T) {
+	for _, tc := range []struct {
+		used, capacity int64
+		full           bool
+	}{
+		{0, 0, false},
+		{100, 0, false},
+		{0, chunking.MaxChunkSize, false},
+		{1, chunking.MaxChunkSize, true},
+		{100, 100, true},
+		{0, 10 * chunking.MaxChunkSize, false},
+		{9*chunking.MaxChunkSize + 1, 10 * chunking.MaxChunkSize, true},
+	} {
+		nodeInfo := &NodeInfo{Used: tc.used, Capacity: tc.capacity}
+		if got := nodeInfo.Full(); got != tc.full {
+			t.Errorf("%d of %d bytes used: want full %t, got %t", tc.used, tc.capacity, tc.full, got)
+		}
+	}
+}
@@ -2,10 +2,51 @@ package node
 
 import (
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 )
 
+// Node status values
+const (
+	StatusHealthy  = "healthy"  // Heartbeats and probes agree the node is up
+	StatusDegraded = "degraded" // Heartbeats and probes disagree
+	StatusOffline  = "offline"  // Neither heartbeats nor probes reach the node
+)
+
+const (
+	// DefaultHeartbeatInterval is how often nodes send heartbeats
+	DefaultHeartbeatInterval = 10 * time.Second
+	// DefaultHeartbeatTimeout is how long the coordinator goes without a
+	// heartbeat before it stops counting on a node
+	DefaultHeartbeatTimeout = 30 * time.Second
+	// MinHeartbeatsPerTimeout is how many heartbeat intervals a timeout must
+	// span, so one late or lost heartbeat doesn't flap a node offline
+	MinHeartbeatsPerTimeout = 3
+
+	// registerRetryMin and registerRetryMax bound the backoff between a
+	// node's attempts to register with the coordinator
+	registerRetryMin = time.Second
+	registerRetryMax = time.Minute
+
+	// heartbeatJitter is the fraction of the interval a node's heartbeats are
+	// randomly moved by, well inside the slack MinHeartbeatsPerTimeout leaves
+	heartbeatJitter = 0.1
+)
+
+// ValidateHeartbeat checks that a heartbeat timeout spans at least
+// MinHeartbeatsPerTimeout heartbeat intervals
+func ValidateHeartbeat(interval, timeout time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("heartbeat interval must be positive, got %s", interval)
+	}
+	if timeout < MinHeartbeatsPerTimeout*interval {
+		return fmt.Errorf("heartbeat timeout %s is less than %d heartbeat intervals of %s",
+			timeout, MinHeartbeatsPerTimeout, interval)
+	}
+	return nil
+}
+
 // Registry manages the cluster of storage nodes
 type Registry struct {
 	nodes     map[string]*NodeInfo // nodeID -> NodeInfo
@@ -13,7 +54,8 @@ type Registry struct {
 	heartbeatTimeout time.Duration
 }
 
-// NewRegistry creates a new node registry
+// NewRegistry creates a new node registry that marks nodes offline once
+// heartbeatTimeout passes without a heartbeat
 func NewRegistry(heartbeatTimeout time.Duration) *Registry {
 	return &Registry{
 		nodes:            make(map[string]*NodeInfo),
@@ -21,23 +63,34 @@ func NewRegistry(heartbeatTimeout time.Duration) *Registry {
 	}
 }
 
-// RegisterNode adds a new node to the registry
-func (r *Registry) RegisterNode(nodeID, address string) error {
+// HeartbeatTimeout returns how long a node may go without a heartbeat
+func (r *Registry) HeartbeatTimeout() time.Duration {
+	return r.heartbeatTimeout
+}
+
+// RegisterNode adds a new node to the registry with the addresses, zone and
+// protocol it reported on registration
+func (r *Registry) RegisterNode(reported *NodeInfo) error {
 	r.nodeLock.Lock()
 	defer r.nodeLock.Unlock()
 
-	r.nodes[nodeID] = &NodeInfo{
-		NodeID:   nodeID,
-		Address:  address,
-		Status:   "healthy",
-		LastSeen: time.Now(),
+	r.nodes[reported.NodeID] = &NodeInfo{
+		NodeID:          reported.NodeID,
+		Address:         reported.Address,
+		GRPCAddress:     reported.GRPCAddress,
+		Zone:            reported.Zone,
+		Status:          StatusHealthy,
+		LastSeen:        time.Now(),
+		ProtocolVersion: reported.ProtocolVersion,
+		Version:         reported.Version,
+		Transports:      slices.Clone(reported.Transports),
 	}
 
 	return nil
 }
 
 // UpdateHeartbeat updates the last seen time for a node
-func (r *Registry) UpdateHeartbeat(nodeID string, totalChunks int, used int64) error {
+func (r *Registry) UpdateHeartbeat(nodeID string, totalChunks int, used, capacity int64) error {
 	r.nodeLock.Lock()
 	defer r.nodeLock.Unlock()
 
@@ -49,26 +102,65 @@ func (r *Registry) UpdateHeartbeat(nodeID string, totalChunks int, used int64) e
 	node.LastSeen = time.Now()
 	node.TotalChunks = totalChunks
 	node.Used = used
-	node.Status = "healthy"
+	node.Capacity = capacity
+	r.refreshStatus(node, time.Now())
+
+	return nil
+}
+
+// RecordProbe records the result of an active health probe against a node
+func (r *Registry) RecordProbe(nodeID string, ok bool) error {
+	r.nodeLock.Lock()
+	defer r.nodeLock.Unlock()
+
+	node, exists := r.nodes[nodeID]
+	if !exists {
+		return fmt.Errorf("node %s not found", nodeID)
+	}
+
+	node.LastProbe = time.Now()
+	node.ProbeOK = ok
+	r.refreshStatus(node, node.LastProbe)
 
 	return nil
 }
 
-// GetHealthyNodes returns all nodes that are currently healthy
+// refreshStatus reconciles heartbeat and probe results into a node status.
+// Caller must hold nodeLock for writing.
+func (r *Registry) refreshStatus(node *NodeInfo, now time.Time) {
+	heartbeatOK := now.Sub(node.LastSeen) < r.heartbeatTimeout
+
+	switch {
+	case node.LastProbe.IsZero():
+		// Not probed yet, so heartbeats are all we have to go on
+		if heartbeatOK {
+			node.Status = StatusHealthy
+		} else {
+			node.Status = StatusOffline
+		}
+	case heartbeatOK && node.ProbeOK:
+		node.Status = StatusHealthy
+	case !heartbeatOK && !node.ProbeOK:
+		node.Status = StatusOffline
+	default:
+		node.Status = StatusDegraded
+	}
+}
+
+// GetHealthyNodes returns all nodes that can currently serve requests.
+// Degraded nodes are included when the coordinator's own probe reached them.
 func (r *Registry) GetHealthyNodes() []*NodeInfo {
-	r.nodeLock.RLock()
-	defer r.nodeLock.RUnlock()
+	r.nodeLock.Lock()
+	defer r.nodeLock.Unlock()
 
 	var healthyNodes []*NodeInfo
 	now := time.Now()
 
 	for _, node := range r.nodes {
-		// Check if node has sent a heartbeat recently
-		if now.Sub(node.LastSeen) < r.heartbeatTimeout {
-			node.Status = "healthy"
+		r.refreshStatus(node, now)
+
+		if node.Status == StatusHealthy || (node.Status == StatusDegraded && node.ProbeOK) {
 			healthyNodes = append(healthyNodes, node)
-		} else {
-			node.Status = "offline"
 		}
 	}
 
@@ -90,11 +182,13 @@ func (r *Registry) GetNode(nodeID string) (*NodeInfo, error) {
 
 // GetAllNodes returns all registered nodes (healthy and unhealthy)
 func (r *Registry) GetAllNodes() []*NodeInfo {
-	r.nodeLock.RLock()
-	defer r.nodeLock.RUnlock()
+	r.nodeLock.Lock()
+	defer r.nodeLock.Unlock()
 
+	now := time.Now()
 	nodes := make([]*NodeInfo, 0, len(r.nodes))
 	for _, node := range r.nodes {
+		r.refreshStatus(node, now)
 		nodes = append(nodes, node)
 	}
 
@@ -102,11 +196,16 @@ func (r *Registry) GetAllNodes() []*NodeInfo {
 }
 
 // RemoveNode removes a node from the registry
-func (r *Registry) RemoveNode(nodeID string) {
+func (r *Registry) RemoveNode(nodeID string) error {
 	r.nodeLock.Lock()
 	defer r.nodeLock.Unlock()
 
+	if _, exists := r.nodes[nodeID]; !exists {
+		return fmt.Errorf("node %s not found", nodeID)
+	}
+
 	delete(r.nodes, nodeID)
+	return nil
 }
 
 // GetNodeCount returns the number of registered nodes
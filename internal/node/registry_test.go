@@ -0,0 +1,108 @@
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRegistryReconcilesProbes checks each combination of heartbeat and
+// probe results gives the status the probe loop relies on
+func TestRegistryReconcilesProbes(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		heartbeat bool // A heartbeat arrived within the timeout
+		probe     *bool
+		want      string
+		serving   bool // Returned by GetHealthyNodes
+	}{
+		{"heartbeat, not probed", true, nil, StatusHealthy, true},
+		{"no heartbeat, not probed", false, nil, StatusOffline, false},
+		{"heartbeat, probe ok", true, ptr(true), StatusHealthy, true},
+		{"no heartbeat, probe ok", false, ptr(true), StatusDegraded, true},
+		{"heartbeat, probe failed", true, ptr(false), StatusDegraded, false},
+		{"no heartbeat, probe failed", false, ptr(false), StatusOffline, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := NewRegistry(time.Minute)
+			if err := registry.RegisterNode(&NodeInfo{NodeID: "node-1", Address: "127.0.0.1:1"}); err != nil {
+				t.Fatal(err)
+			}
+			info, _ := registry.GetNode("node-1")
+			if !tc.heartbeat {
+				info.LastSeen = time.Now().Add(-2 * time.Minute)
+			}
+			if tc.probe != nil {
+				if err := registry.RecordProbe("node-1", *tc.probe); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			nodes := registry.GetAllNodes()
+			if len(nodes) != 1 || nodes[0].Status != tc.want {
+				t.Fatalf("want status %s, got %+v", tc.want, nodes)
+			}
+			if serving := len(registry.GetHealthyNodes()) == 1; serving != tc.serving {
+				t.Errorf("want serving %v, got %v", tc.serving, serving)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestValidateHeartbeat(t *testing.T) {
+	for _, tc := range []struct {
+		interval, timeout time.Duration
+		ok                bool
+	}{
+		{DefaultHeartbeatInterval, DefaultHeartbeatTimeout, true},
+		{100 * time.Millisecond, 300 * time.Millisecond, true},
+		{time.Second, time.Minute, true},
+		{100 * time.Millisecond, 299 * time.Millisecond, false},
+		{20 * time.Second, DefaultHeartbeatTimeout, false},
+		{0, DefaultHeartbeatTimeout, false},
+		{-time.Second, DefaultHeartbeatTimeout, false},
+	} {
+		if err := ValidateHeartbeat(tc.interval, tc.timeout); (err == nil) != tc.ok {
+			t.Errorf("interval %s, timeout %s: want ok %v, got %v", tc.interval, tc.timeout, tc.ok, err)
+		}
+	}
+}
+
+// TestSupports checks nodes serve the transports they reported, and nodes
+// that reported none serve what existed before transports were reported
+func TestSupports(t *testing.T) {
+	for _, tc := range []struct {
+		name                 string
+		info                 NodeInfo
+		json, binary, grpcOK bool
+	}{
+		{"reported none", NodeInfo{}, true, false, false},
+		{"reported none, gRPC address", NodeInfo{GRPCAddress: "127.0.0.1:1"}, true, false, true},
+		{"JSON and binary", NodeInfo{Transports: []string{TransportJSON, TransportBinary}}, true, true, false},
+		{"all", NodeInfo{GRPCAddress: "127.0.0.1:1", Transports: []string{TransportJSON, TransportBinary, TransportGRPC}}, true, true, true},
+		{"gRPC without an address", NodeInfo{Transports: []string{TransportGRPC}}, false, false, false},
+	} {
+		for transport, want := range map[string]bool{TransportJSON: tc.json, TransportBinary: tc.binary, TransportGRPC: tc.grpcOK} {
+			if got := tc.info.Supports(transport); got != want {
+				t.Errorf("%s: want %s supported %v, got %v", tc.name, transport, want, got)
+			}
+		}
+	}
+
+	registry := NewRegistry(time.Minute)
+	reported := &NodeInfo{NodeID: "node-1", Address: "127.0.0.1:1", ProtocolVersion: 2, Version: "v1.4.0", Transports: []string{TransportJSON, TransportBinary}}
+	if err := registry.RegisterNode(reported); err != nil {
+		t.Fatal(err)
+	}
+	reported.Transports[1] = TransportGRPC
+	info, err := registry.GetNode("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ProtocolVersion != 2 || info.Version != "v1.4.0" || !info.Supports(TransportBinary) {
+		t.Errorf("want the reported protocol, version and transports kept, got %+v", info)
+	}
+}
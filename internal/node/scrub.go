@@ -0,0 +1,112 @@
+package node
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/throttle"
+)
+
+const (
+	// DefaultScrubInterval is how often a node re-verifies all of its chunks
+	DefaultScrubInterval = 6 * time.Hour
+	// DefaultScrubRate caps scrub reads at 10MB/s so user traffic isn't starved
+	DefaultScrubRate = 10 * 1024 * 1024
+)
+
+// startScrubber periodically re-hashes every stored chunk to detect bit-rot
+func (sn *StorageNode) startScrubber() {
+	if sn.ScrubInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sn.ScrubInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sn.stop:
+			return
+		case <-ticker.C:
+			corrupt := sn.scrubChunks()
+			if len(corrupt) > 0 {
+				sn.reportCorruptChunks(corrupt)
+			}
+		}
+	}
+}
+
+// scrubChunks verifies each chunk on disk against its hash and returns the
+// hashes of any chunks that are missing or corrupted. Bad chunks are dropped
+// from the index so they are no longer served.
+func (sn *StorageNode) scrubChunks() []string {
+	sn.chunksLock.RLock()
+	hashes := make([]string, 0, len(sn.chunks))
+	for hash := range sn.chunks {
+		hashes = append(hashes, hash)
+	}
+	sn.chunksLock.RUnlock()
+
+	log.Printf("Scrub started: verifying %d chunks", len(hashes))
+
+	// One limit for the whole pass, not each chunk
+	limiter := throttle.NewLimiter(sn.ScrubRate)
+
+	var corrupt []string
+	for _, hash := range hashes {
+		ok, err := sn.verifyChunk(hash, limiter)
+		if err != nil {
+			log.Printf("Scrub: failed to read chunk %s: %v", hash[:8], err)
+		}
+		if ok {
+			continue
+		}
+
+		log.Printf("Scrub: chunk %s is corrupted", hash[:8])
+		corrupt = append(corrupt, hash)
+
+		sn.untrackChunk(hash)
+	}
+
+	log.Printf("Scrub complete: %d chunks verified, %d corrupted", len(hashes), len(corrupt))
+	return corrupt
+}
+
+// verifyChunk re-hashes a chunk file, or its packed copy, no faster than
+// limiter allows, and compares it to its name
+func (sn *StorageNode) verifyChunk(hash string, limiter *throttle.Limiter) (bool, error) {
+	chunk, _, err := sn.openChunk(hash)
+	if err != nil {
+		return false, err
+	}
+	defer chunk.Close()
+
+	return chunking.MatchHashReader(limiter.Reader(context.Background(), chunk), hash)
+}
+
+// reportCorruptChunks tells the coordinator which chunks need repair
+func (sn *StorageNode) reportCorruptChunks(hashes []string) {
+	if sn.CoordinatorAddr == "" {
+		return
+	}
+
+	report := CorruptChunkReport{
+		NodeID:      sn.NodeID,
+		ChunkHashes: hashes,
+		Timestamp:   time.Now(),
+	}
+
+	resp, err := sn.postToCoordinator("/chunks/corrupt", report)
+	if err != nil {
+		log.Printf("Failed to report corrupt chunks: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Coordinator rejected corrupt chunk report: %s", resp.Status)
+	}
+}
@@ -0,0 +1,70 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// TestScrubReportsCorruptChunk plants a corrupted chunk among good ones and
+// checks the scrubber finds it, stops serving it and reports it
+func TestScrubReportsCorruptChunk(t *testing.T) {
+	sn := newTestNode(t)
+
+	var reports []CorruptChunkReport
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chunks/corrupt" {
+			http.NotFound(w, r)
+			return
+		}
+		var report CorruptChunkReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Error(err)
+		}
+		reports = append(reports, report)
+	})
+
+	_, good := storeTestChunk(t, sn, 1000)
+	data, bad := storeTestChunk(t, sn, 1000)
+
+	// Flip one bit on disk
+	data[0] ^= 1
+	if err := os.WriteFile(sn.chunkPath(bad), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := sn.scrubChunks()
+	if len(corrupt) != 1 || corrupt[0] != bad {
+		t.Fatalf("want only %s reported corrupt, got %v", bad[:8], corrupt)
+	}
+	if sn.hasChunk(bad) {
+		t.Error("corrupt chunk is still served")
+	}
+	if !sn.hasChunk(good) {
+		t.Error("intact chunk is no longer served")
+	}
+
+	sn.reportCorruptChunks(corrupt)
+	if len(reports) != 1 || len(reports[0].ChunkHashes) != 1 || reports[0].ChunkHashes[0] != bad {
+		t.Fatalf("want one report of %s, got %+v", bad[:8], reports)
+	}
+	if reports[0].NodeID != sn.NodeID {
+		t.Errorf("report names node %q, want %q", reports[0].NodeID, sn.NodeID)
+	}
+}
+
+// TestScrubReportsMissingChunk checks a chunk whose file vanished is
+// reported like a corrupt one
+func TestScrubReportsMissingChunk(t *testing.T) {
+	sn := newTestNode(t)
+	_, hash := storeTestChunk(t, sn, 1000)
+
+	if err := os.Remove(sn.chunkPath(hash)); err != nil {
+		t.Fatal(err)
+	}
+
+	if corrupt := sn.scrubChunks(); len(corrupt) != 1 || corrupt[0] != hash {
+		t.Fatalf("want %s reported, got %v", hash[:8], corrupt)
+	}
+}
@@ -1,38 +1,102 @@
 package node
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/shard"
+	"github.com/noorimat/distributed-file-storage/internal/version"
+	"google.golang.org/grpc"
 )
 
+var errChunkNotFound = errors.New("chunk not found")
+
 // StorageNode represents a single storage node in the cluster
 type StorageNode struct {
-	NodeID           string
-	Address          string
-	StoragePath      string
-	CoordinatorAddr  string
-	chunks           map[string]bool // Track which chunks this node has
-	chunksLock       sync.RWMutex
-	server           *http.Server
+	NodeID            string
+	Address           string
+	StoragePath       string
+	CoordinatorAddr   string           // Comma-separated when coordinators stand by for each other
+	ScrubInterval     time.Duration    // How often to re-verify stored chunks (0 disables)
+	ScrubRate         int64            // Max scrub read rate in bytes/sec (0 = unlimited)
+	ClusterSecret     string           // Shared secret for cluster-internal calls ("" disables auth)
+	GRPCAddress       string           // Listen address for the gRPC data path ("" disables it)
+	Zone              string           // Failure domain reported to the coordinator ("" for none)
+	PackThreshold     int64            // Chunks smaller than this are moved into pack files (0 disables packing)
+	CompactInterval   time.Duration    // How often to pack small chunks
+	ShardDepth        int              // Directory levels chunk files are sharded into
+	HeartbeatInterval time.Duration    // How often to send heartbeats to the coordinator
+	SweepInterval     time.Duration    // How often to delete chunks the coordinator no longer references (0 disables)
+	SweepRate         int              // Max orphaned chunks deleted per second (0 = unlimited)
+	MaxWrites         int              // Max chunks written to disk at once (0 = unlimited)
+	Durability        string           // When written chunks are fsynced: DurabilityChunk, DurabilityInterval or DurabilityNone
+	SyncInterval      time.Duration    // How often DurabilityInterval fsyncs written chunks
+	VerifyDedup       bool             // Refuse stores that would replace a chunk with different bytes of the same hash
+	Quota             int64            // Max bytes of chunks stored; further stores are refused (0 = no quota)
+	TLS               auth.TLSConfig   // Serve and call the coordinator over TLS (zero value keeps plain HTTP)
+	chunks            map[string]int64 // Track which chunks this node has (hash -> size in bytes)
+	usedBytes         int64            // Sum of all chunk sizes, kept in sync with chunks
+	reservedBytes     int64            // Room set aside for chunks being written under Quota
+	chunksLock        sync.RWMutex
+	writes            map[string]*chunkWrite // Writes in progress, by chunk hash
+	writesLock        sync.Mutex
+	writeSlots        chan struct{}   // Holds one token per write in progress; nil if unlimited
+	unsynced          map[string]bool // Chunk files written since the last interval sync
+	unsyncedDirs      map[string]bool // Directories whose entries changed since the last interval sync
+	unsyncedLock      sync.Mutex
+	openFile          fileOpener // Opens files to write and sync; os.OpenFile outside tests
+	packs             *packStore // nil unless packing is enabled or packs exist
+	packLock          sync.Mutex // Serializes compaction with deletes
+	server            *http.Server
+	client            *http.Client // Calls the coordinator; set up for TLS in Start
+	coordinator       atomic.Int32 // Index in CoordinatorAddr of the coordinator last answering
+	grpcServer        *grpc.Server
+	stop              chan struct{} // Closed on shutdown to stop background loops
+	stopOnce          sync.Once
 }
 
 // NewStorageNode creates a new storage node
 func NewStorageNode(nodeID, address, storagePath, coordinatorAddr string) *StorageNode {
 	return &StorageNode{
-		NodeID:          nodeID,
-		Address:         address,
-		StoragePath:     storagePath,
-		CoordinatorAddr: coordinatorAddr,
-		chunks:          make(map[string]bool),
+		NodeID:            nodeID,
+		Address:           address,
+		StoragePath:       storagePath,
+		CoordinatorAddr:   coordinatorAddr,
+		ScrubInterval:     DefaultScrubInterval,
+		ScrubRate:         DefaultScrubRate,
+		CompactInterval:   DefaultCompactInterval,
+		ShardDepth:        shard.DefaultDepth,
+		HeartbeatInterval: DefaultHeartbeatInterval,
+		SweepInterval:     DefaultSweepInterval,
+		SweepRate:         DefaultSweepRate,
+		MaxWrites:         DefaultMaxWrites,
+		Durability:        DurabilityChunk,
+		SyncInterval:      DefaultSyncInterval,
+		chunks:            make(map[string]int64),
+		writes:            make(map[string]*chunkWrite),
+		unsynced:          make(map[string]bool),
+		unsyncedDirs:      make(map[string]bool),
+		openFile:          openOSFile,
+		server:            &http.Server{Addr: address},
+		client:            http.DefaultClient,
+		stop:              make(chan struct{}),
 	}
 }
 
@@ -43,6 +107,59 @@ func (sn *StorageNode) Start() error {
 		return fmt.Errorf("failed to create storage directory: %w", err)
 	}
 
+	// Open pack files if packing is enabled or was enabled before, so
+	// packed chunks stay readable
+	packDir := filepath.Join(sn.StoragePath, packDirName)
+	if _, err := os.Stat(packDir); sn.PackThreshold > 0 || err == nil {
+		packs, err := openPackStore(packDir)
+		if err != nil {
+			return err
+		}
+		sn.packs = packs
+	}
+
+	if sn.HeartbeatInterval <= 0 {
+		return fmt.Errorf("heartbeat interval must be positive, got %s", sn.HeartbeatInterval)
+	}
+	if sn.MaxWrites < 0 {
+		return fmt.Errorf("max concurrent writes must not be negative, got %d", sn.MaxWrites)
+	}
+	if sn.MaxWrites > 0 {
+		sn.writeSlots = make(chan struct{}, sn.MaxWrites)
+	}
+	if err := sn.validateDurability(); err != nil {
+		return err
+	}
+	if sn.Quota < 0 {
+		return fmt.Errorf("quota must not be negative, got %d", sn.Quota)
+	}
+
+	// Load TLS certificates up front so a bad path fails startup
+	serverTLS, err := sn.TLS.ServerConfig(false)
+	if err != nil {
+		return err
+	}
+	clientTLS, err := sn.TLS.ClientConfig()
+	if err != nil {
+		return err
+	}
+	sn.server.TLSConfig = serverTLS
+	if clientTLS != nil {
+		sn.client = &http.Client{Transport: auth.TLSTransport(clientTLS)}
+	}
+
+	// Move chunks stored under another shard depth into the current layout
+	if err := shard.ValidateDepth(sn.ShardDepth); err != nil {
+		return err
+	}
+	moved, err := shard.Migrate(sn.StoragePath, sn.ShardDepth, isChunkFile, packDirName)
+	if err != nil {
+		return fmt.Errorf("failed to migrate chunk layout: %w", err)
+	}
+	if moved > 0 {
+		log.Printf("Moved %d chunks to shard depth %d", moved, sn.ShardDepth)
+	}
+
 	// Load existing chunks
 	if err := sn.loadExistingChunks(); err != nil {
 		return fmt.Errorf("failed to load existing chunks: %w", err)
@@ -51,29 +168,103 @@ func (sn *StorageNode) Start() error {
 	// Set up HTTP routes
 	router := mux.NewRouter()
 	router.HandleFunc("/health", sn.healthHandler).Methods("GET")
+	router.HandleFunc("/version", sn.versionHandler).Methods("GET")
 	router.HandleFunc("/store", sn.storeChunkHandler).Methods("POST")
+	router.HandleFunc("/store/batch", sn.batchStoreHandler).Methods("POST")
 	router.HandleFunc("/retrieve/{hash}", sn.retrieveChunkHandler).Methods("GET")
+	router.HandleFunc("/chunk/{hash}", sn.streamChunkHandler).Methods("GET")
+	router.HandleFunc("/chunk/{hash}", sn.putChunkHandler).Methods("PUT")
 	router.HandleFunc("/chunks", sn.listChunksHandler).Methods("GET")
+	router.HandleFunc("/chunks/{hash}", sn.deleteChunkHandler).Methods("DELETE")
 
-	sn.server = &http.Server{
-		Addr:    sn.Address,
-		Handler: router,
-	}
+	router.Use(sn.requireClusterSecret)
+
+	sn.server.Handler = router
+
+	// Serve the gRPC data path alongside HTTP
+	if sn.GRPCAddress != "" {
+		listener, err := net.Listen("tcp", sn.GRPCAddress)
+		if err != nil {
+			return fmt.Errorf("failed to listen for gRPC: %w", err)
+		}
 
-	// Register with coordinator
-	go sn.registerWithCoordinator()
+		grpcServer, err := sn.newGRPCServer()
+		if err != nil {
+			return err
+		}
+		sn.grpcServer = grpcServer
+		go func() {
+			log.Printf("Storage Node %s serving gRPC on %s", sn.NodeID, sn.GRPCAddress)
+			if err := sn.grpcServer.Serve(listener); err != nil {
+				log.Printf("gRPC server stopped: %v", err)
+			}
+		}()
+	}
 
-	// Start heartbeat
+	// Register with coordinator and start heartbeat
 	go sn.startHeartbeat()
 
-	log.Printf("Storage Node %s starting on %s", sn.NodeID, sn.Address)
-	return sn.server.ListenAndServe()
+	// Start background integrity scrubbing
+	go sn.startScrubber()
+
+	// Start deleting chunks the coordinator no longer references
+	go sn.startSweeper()
+
+	// Start packing small chunks
+	if sn.PackThreshold > 0 {
+		go sn.startCompactor()
+	}
+
+	// Start fsyncing written chunks in batches
+	if sn.Durability == DurabilityInterval {
+		go sn.startSyncer()
+	}
+
+	log.Printf("Storage Node %s starting on %s://%s", sn.NodeID, sn.TLS.Scheme(), sn.Address)
+	if serverTLS != nil {
+		// The certificate is already in TLSConfig
+		err = sn.server.ListenAndServeTLS("", "")
+	} else {
+		err = sn.server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops background work, deregisters from the coordinator so the
+// node leaves the ring immediately, waits for in-flight requests to finish,
+// and fsyncs any chunks still waiting for an interval sync
+func (sn *StorageNode) Shutdown(ctx context.Context) error {
+	sn.stopOnce.Do(func() { close(sn.stop) })
+
+	sn.deregisterFromCoordinator()
+
+	if sn.grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			sn.grpcServer.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			sn.grpcServer.Stop()
+		}
+	}
+
+	err := sn.server.Shutdown(ctx)
+	sn.syncPending()
+	return err
 }
 
 // healthHandler returns the health status of this node
 func (sn *StorageNode) healthHandler(w http.ResponseWriter, r *http.Request) {
 	sn.chunksLock.RLock()
 	chunkCount := len(sn.chunks)
+	used := sn.usedBytes
 	sn.chunksLock.RUnlock()
 
 	response := map[string]interface{}{
@@ -81,13 +272,23 @@ func (sn *StorageNode) healthHandler(w http.ResponseWriter, r *http.Request) {
 		"node_id":      sn.NodeID,
 		"address":      sn.Address,
 		"total_chunks": chunkCount,
+		"used":         used,
 		"timestamp":    time.Now().Format(time.RFC3339),
 	}
+	if sn.Quota > 0 {
+		response["quota"] = sn.Quota
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// versionHandler reports which build this node is running
+func (sn *StorageNode) versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version.Get())
+}
+
 // storeChunkHandler handles storing a chunk on this node
 func (sn *StorageNode) storeChunkHandler(w http.ResponseWriter, r *http.Request) {
 	var req StoreChunkRequest
@@ -96,28 +297,12 @@ func (sn *StorageNode) storeChunkHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Store chunk to disk
-	chunkPath := filepath.Join(sn.StoragePath, req.ChunkHash[:2], req.ChunkHash)
-	
-	// Create directory if needed
-	if err := os.MkdirAll(filepath.Dir(chunkPath), 0755); err != nil {
-		log.Printf("Failed to create chunk directory: %v", err)
-		http.Error(w, "Failed to store chunk", http.StatusInternalServerError)
-		return
-	}
-
-	// Write chunk data
-	if err := os.WriteFile(chunkPath, req.ChunkData, 0644); err != nil {
-		log.Printf("Failed to write chunk: %v", err)
-		http.Error(w, "Failed to store chunk", http.StatusInternalServerError)
+	if err := sn.writeChunk(r.Context(), req.ChunkHash, req.ChunkData); err != nil {
+		log.Printf("Failed to store chunk: %v", err)
+		storeFailed(w, err)
 		return
 	}
 
-	// Track chunk
-	sn.chunksLock.Lock()
-	sn.chunks[req.ChunkHash] = true
-	sn.chunksLock.Unlock()
-
 	log.Printf("Stored chunk %s on node %s", req.ChunkHash[:8], sn.NodeID)
 
 	response := StoreChunkResponse{
@@ -130,24 +315,150 @@ func (sn *StorageNode) storeChunkHandler(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
+// storeFailed replies to a store that writeChunk refused or failed
+func storeFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, errHashCollision) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	http.Error(w, "Failed to store chunk", http.StatusInternalServerError)
+}
+
+// batchStoreHandler stores multiple chunks in one request, reporting
+// success or failure for each chunk individually
+func (sn *StorageNode) batchStoreHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchStoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	response := BatchStoreResponse{
+		NodeID:  sn.NodeID,
+		Results: make([]StoreChunkResponse, 0, len(req.Chunks)),
+	}
+
+	stored := 0
+	for _, chunk := range req.Chunks {
+		result := StoreChunkResponse{
+			Success:   true,
+			NodeID:    sn.NodeID,
+			ChunkHash: chunk.ChunkHash,
+		}
+
+		if err := sn.writeChunk(r.Context(), chunk.ChunkHash, chunk.ChunkData); err != nil {
+			log.Printf("Failed to store chunk in batch: %v", err)
+			result.Success = false
+			result.Error = err.Error()
+		} else {
+			stored++
+		}
+
+		response.Results = append(response.Results, result)
+	}
+
+	log.Printf("Stored batch of %d/%d chunks on node %s", stored, len(req.Chunks), sn.NodeID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// hasChunk reports whether the chunk is in this node's index
+func (sn *StorageNode) hasChunk(chunkHash string) bool {
+	sn.chunksLock.RLock()
+	defer sn.chunksLock.RUnlock()
+
+	_, exists := sn.chunks[chunkHash]
+	return exists
+}
+
+// chunkPath returns where a chunk is stored in its own file
+func (sn *StorageNode) chunkPath(chunkHash string) string {
+	return shard.Path(sn.StoragePath, chunkHash, sn.ShardDepth)
+}
+
+// isChunkFile reports whether a file name looks like a chunk hash (SHA-256
+// is 64 hex chars)
+func isChunkFile(name string) bool {
+	return len(name) == 64
+}
+
+// readChunk reads a chunk from disk, returning errChunkNotFound if this
+// node does not hold it. A chunk's own file takes precedence over a packed
+// copy, since chunks rewritten by repair are not packed until the next
+// compaction.
+func (sn *StorageNode) readChunk(chunkHash string) ([]byte, error) {
+	if !sn.hasChunk(chunkHash) {
+		return nil, errChunkNotFound
+	}
+
+	data, err := os.ReadFile(sn.chunkPath(chunkHash))
+	if os.IsNotExist(err) && sn.packs != nil {
+		return sn.packs.read(chunkHash)
+	}
+	return data, err
+}
+
+// openChunk opens a chunk for streaming like readChunk reads it, also
+// returning its size
+func (sn *StorageNode) openChunk(chunkHash string) (io.ReadCloser, int64, error) {
+	if !sn.hasChunk(chunkHash) {
+		return nil, 0, errChunkNotFound
+	}
+
+	f, err := os.Open(sn.chunkPath(chunkHash))
+	if os.IsNotExist(err) && sn.packs != nil {
+		return sn.packs.open(chunkHash)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// deleteChunk removes a chunk from disk and from the index
+func (sn *StorageNode) deleteChunk(chunkHash string) error {
+	sn.packLock.Lock()
+	defer sn.packLock.Unlock()
+
+	if !sn.hasChunk(chunkHash) {
+		return errChunkNotFound
+	}
+
+	if err := os.Remove(sn.chunkPath(chunkHash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if sn.packs != nil {
+		if err := sn.packs.remove(chunkHash); err != nil {
+			return err
+		}
+	}
+
+	sn.untrackChunk(chunkHash)
+	log.Printf("Deleted chunk %s from node %s", chunkHash, sn.NodeID)
+	return nil
+}
+
 // retrieveChunkHandler handles retrieving a chunk from this node
 func (sn *StorageNode) retrieveChunkHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	chunkHash := vars["hash"]
 
-	// Check if chunk exists
-	sn.chunksLock.RLock()
-	exists := sn.chunks[chunkHash]
-	sn.chunksLock.RUnlock()
-
-	if !exists {
+	chunkData, err := sn.readChunk(chunkHash)
+	if errors.Is(err, errChunkNotFound) {
 		http.Error(w, "Chunk not found", http.StatusNotFound)
 		return
 	}
-
-	// Read chunk from disk
-	chunkPath := filepath.Join(sn.StoragePath, chunkHash[:2], chunkHash)
-	chunkData, err := os.ReadFile(chunkPath)
 	if err != nil {
 		log.Printf("Failed to read chunk: %v", err)
 		http.Error(w, "Failed to retrieve chunk", http.StatusInternalServerError)
@@ -164,6 +475,85 @@ func (sn *StorageNode) retrieveChunkHandler(w http.ResponseWriter, r *http.Reque
 	json.NewEncoder(w).Encode(response)
 }
 
+// streamChunkHandler serves a chunk's raw bytes, copied from disk to the
+// response without holding the chunk in memory
+func (sn *StorageNode) streamChunkHandler(w http.ResponseWriter, r *http.Request) {
+	chunkHash := mux.Vars(r)["hash"]
+
+	chunk, size, err := sn.openChunk(chunkHash)
+	if errors.Is(err, errChunkNotFound) {
+		http.Error(w, "Chunk not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open chunk: %v", err)
+		http.Error(w, "Failed to retrieve chunk", http.StatusInternalServerError)
+		return
+	}
+	defer chunk.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if _, err := io.Copy(w, chunk); err != nil {
+		log.Printf("Failed to stream chunk %s: %v", chunkHash, err)
+	}
+}
+
+// putChunkHandler stores a chunk's raw bytes sent straight from a client.
+// The client holds a grant for the hash rather than the cluster secret, so
+// the data is checked against the hash before it is written.
+func (sn *StorageNode) putChunkHandler(w http.ResponseWriter, r *http.Request) {
+	chunkHash := mux.Vars(r)["hash"]
+
+	if sn.ClusterSecret != "" && !auth.VerifyChunkGrant(sn.ClusterSecret, chunkHash, r.Header.Get(GrantHeader), time.Now()) {
+		http.Error(w, "Invalid or expired chunk grant", http.StatusForbidden)
+		return
+	}
+
+	chunkData, err := io.ReadAll(http.MaxBytesReader(w, r.Body, chunking.MaxChunkSize))
+	if err != nil {
+		http.Error(w, "Failed to read chunk", http.StatusBadRequest)
+		return
+	}
+	if _, ok := chunking.MatchHash(chunkData, chunkHash); !ok {
+		http.Error(w, "Chunk data does not match its hash", http.StatusBadRequest)
+		return
+	}
+
+	if err := sn.writeChunk(r.Context(), chunkHash, chunkData); err != nil {
+		log.Printf("Failed to store chunk: %v", err)
+		storeFailed(w, err)
+		return
+	}
+
+	log.Printf("Stored chunk %s on node %s from a client", chunkHash[:8], sn.NodeID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StoreChunkResponse{
+		Success:   true,
+		NodeID:    sn.NodeID,
+		ChunkHash: chunkHash,
+	})
+}
+
+// deleteChunkHandler removes a chunk that the coordinator no longer references
+func (sn *StorageNode) deleteChunkHandler(w http.ResponseWriter, r *http.Request) {
+	chunkHash := mux.Vars(r)["hash"]
+
+	err := sn.deleteChunk(chunkHash)
+	if errors.Is(err, errChunkNotFound) {
+		http.Error(w, "Chunk not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete chunk: %v", err)
+		http.Error(w, "Failed to delete chunk", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // listChunksHandler returns all chunks stored on this node
 func (sn *StorageNode) listChunksHandler(w http.ResponseWriter, r *http.Request) {
 	sn.chunksLock.RLock()
@@ -174,85 +564,198 @@ func (sn *StorageNode) listChunksHandler(w http.ResponseWriter, r *http.Request)
 	sn.chunksLock.RUnlock()
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"node_id": sn.NodeID,
-		"count":   len(chunks),
-		"chunks":  chunks,
+	json.NewEncoder(w).Encode(ListChunksResponse{
+		NodeID: sn.NodeID,
+		Count:  len(chunks),
+		Chunks: chunks,
 	})
 }
 
 // registerWithCoordinator registers this node with the coordinator
-func (sn *StorageNode) registerWithCoordinator() {
-	if sn.CoordinatorAddr == "" {
-		return
-	}
-
-	url := fmt.Sprintf("http://%s/register", sn.CoordinatorAddr)
-	
+func (sn *StorageNode) registerWithCoordinator() error {
 	nodeInfo := NodeInfo{
-		NodeID:  sn.NodeID,
-		Address: sn.Address,
-		Status:  "healthy",
+		NodeID:      sn.NodeID,
+		Address:     sn.Address,
+		GRPCAddress: sn.GRPCAddress,
+		Zone:        sn.Zone,
+		Status:      "healthy",
+
+		HeartbeatInterval: sn.HeartbeatInterval,
+		ProtocolVersion:   version.Protocol,
+		Version:           version.Version,
+		Transports:        []string{TransportJSON, TransportBinary, TransportDirect},
+	}
+	if sn.GRPCAddress != "" {
+		nodeInfo.Transports = append(nodeInfo.Transports, TransportGRPC)
 	}
 
-	data, _ := json.Marshal(nodeInfo)
-	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	resp, err := sn.postToCoordinator("/register", nodeInfo)
 	if err != nil {
-		log.Printf("Failed to register with coordinator: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		log.Printf("Successfully registered with coordinator")
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("coordinator rejected registration: %s: %s", resp.Status, strings.TrimSpace(string(reason)))
 	}
+
+	log.Printf("Successfully registered with coordinator")
+	return nil
 }
 
-// startHeartbeat sends periodic heartbeats to the coordinator
+// startHeartbeat registers with the coordinator, sends a first heartbeat
+// straight away, then keeps sending them every HeartbeatInterval. Each wait
+// is jittered so nodes started together don't heartbeat in lockstep.
+//
+// Registration is retried with exponential backoff until the coordinator
+// accepts it, so a node started before the coordinator still joins. A failed
+// heartbeat means the coordinator is down or has forgotten the node, e.g.
+// after a restart, so the node registers again before the next one.
 func (sn *StorageNode) startHeartbeat() {
 	if sn.CoordinatorAddr == "" {
 		return
 	}
 
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+	registered := false
+	retry := registerRetryMin
 
-	for range ticker.C {
-		sn.chunksLock.RLock()
-		chunkCount := len(sn.chunks)
-		sn.chunksLock.RUnlock()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
-		url := fmt.Sprintf("http://%s/heartbeat", sn.CoordinatorAddr)
-		
-		heartbeat := HeartbeatMessage{
-			NodeID:      sn.NodeID,
-			Address:     sn.Address,
-			TotalChunks: chunkCount,
-			Timestamp:   time.Now(),
+	for {
+		select {
+		case <-sn.stop:
+			return
+		case <-timer.C:
 		}
 
-		data, _ := json.Marshal(heartbeat)
-		_, err := http.Post(url, "application/json", bytes.NewReader(data))
-		if err != nil {
-			log.Printf("Failed to send heartbeat: %v", err)
+		if !registered {
+			if err := sn.registerWithCoordinator(); err != nil {
+				log.Printf("Failed to register with coordinator, retrying in %s: %v", retry, err)
+				timer.Reset(jitter(retry, heartbeatJitter))
+				retry = min(retry*2, registerRetryMax)
+				continue
+			}
+			registered = true
+			retry = registerRetryMin
+		}
+
+		if err := sn.sendHeartbeat(); err != nil {
+			log.Printf("Failed to send heartbeat, registering again: %v", err)
+			registered = false
+			timer.Reset(0)
+			continue
 		}
+		timer.Reset(jitter(sn.HeartbeatInterval, heartbeatJitter))
 	}
 }
 
-// loadExistingChunks scans the storage directory and loads chunk hashes
+// jitter returns d moved by a random amount of up to fraction*d either way
+func jitter(d time.Duration, fraction float64) time.Duration {
+	spread := time.Duration(float64(d) * fraction)
+	if spread <= 0 {
+		return d
+	}
+	return d - spread + rand.N(2*spread+1)
+}
+
+// sendHeartbeat reports this node's chunk count and disk usage to the coordinator
+func (sn *StorageNode) sendHeartbeat() error {
+	sn.chunksLock.RLock()
+	chunkCount := len(sn.chunks)
+	used := sn.usedBytes
+	sn.chunksLock.RUnlock()
+
+	heartbeat := HeartbeatMessage{
+		NodeID:      sn.NodeID,
+		Address:     sn.Address,
+		TotalChunks: chunkCount,
+		Used:        used,
+		Capacity:    sn.reportedCapacity(),
+		Timestamp:   time.Now(),
+	}
+
+	resp, err := sn.postToCoordinator("/heartbeat", heartbeat)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("coordinator rejected heartbeat: %s", resp.Status)
+	}
+	return nil
+}
+
+// deregisterFromCoordinator tells the coordinator this node is leaving
+func (sn *StorageNode) deregisterFromCoordinator() {
+	if sn.CoordinatorAddr == "" {
+		return
+	}
+
+	resp, err := sn.postToCoordinator("/deregister", DeregisterRequest{NodeID: sn.NodeID})
+	if err != nil {
+		log.Printf("Failed to deregister from coordinator: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		log.Printf("Deregistered from coordinator")
+	case http.StatusNotFound:
+		log.Printf("Coordinator had already removed this node")
+	default:
+		log.Printf("Failed to deregister from coordinator: %s", resp.Status)
+	}
+}
+
+// loadExistingChunks scans the storage directory and the pack index and
+// loads chunk hashes
 func (sn *StorageNode) loadExistingChunks() error {
+	if sn.packs != nil {
+		for hash, size := range sn.packs.sizes() {
+			sn.trackChunk(hash, size)
+		}
+	}
+
 	return filepath.Walk(sn.StoragePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if !info.IsDir() && len(info.Name()) == 64 {
-			// Looks like a chunk hash (SHA-256 is 64 hex chars)
-			sn.chunksLock.Lock()
-			sn.chunks[info.Name()] = true
-			sn.chunksLock.Unlock()
+		if info.IsDir() && info.Name() == packDirName {
+			return filepath.SkipDir
+		}
+
+		if !info.IsDir() && isPartialChunkFile(info.Name()) {
+			// Left by a write that never finished
+			return os.Remove(path)
+		}
+		if !info.IsDir() && isChunkFile(info.Name()) {
+			sn.trackChunk(info.Name(), info.Size())
 		}
 
 		return nil
 	})
-}
\ No newline at end of file
+}
+
+// trackChunk records a chunk in the index and updates the used byte count
+func (sn *StorageNode) trackChunk(hash string, size int64) {
+	sn.chunksLock.Lock()
+	defer sn.chunksLock.Unlock()
+
+	// Overwriting an existing chunk replaces its size rather than adding to it
+	sn.usedBytes += size - sn.chunks[hash]
+	sn.chunks[hash] = size
+}
+
+// untrackChunk removes a chunk from the index and updates the used byte count
+func (sn *StorageNode) untrackChunk(hash string) {
+	sn.chunksLock.Lock()
+	defer sn.chunksLock.Unlock()
+
+	sn.usedBytes -= sn.chunks[hash]
+	delete(sn.chunks, hash)
+}
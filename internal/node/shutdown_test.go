@@ -0,0 +1,101 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestShutdownFinishesInFlightStore shuts a node down while a store request
+// is still sending its body, and checks the store completes, the node
+// deregisters, and Shutdown waits for it
+func TestShutdownFinishesInFlightStore(t *testing.T) {
+	sn := newTestNode(t)
+
+	var mu sync.Mutex
+	var deregistered []string
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deregister" {
+			var req DeregisterRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			deregistered = append(deregistered, req.NodeID)
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	startTestNode(t, sn)
+
+	data, hash := testChunk(t, 1000)
+	body, err := json.Marshal(StoreChunkRequest{ChunkHash: hash, ChunkData: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Send the first half of the body, holding the request open. A client
+	// of its own opens exactly one connection; one with an idle connection
+	// can dial a spare, which Shutdown waits out as a new connection.
+	client := &http.Client{Transport: &http.Transport{}}
+	pr, pw := io.Pipe()
+	responses := make(chan *http.Response, 1)
+	errs := make(chan error, 1)
+	go func() {
+		resp, err := client.Post("http://"+sn.Address+"/store", "application/json", pr)
+		if err != nil {
+			errs <- err
+			return
+		}
+		responses <- resp
+	}()
+	if _, err := pw.Write(body[:len(body)/2]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- sn.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned with a request in flight: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Finish the request; it completes and shutdown follows
+	if _, err := pw.Write(body[len(body)/2:]); err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+
+	select {
+	case resp := <-responses:
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("want the in-flight store to succeed, got %s", resp.Status)
+		}
+	case err := <-errs:
+		t.Fatalf("in-flight store failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight store never completed")
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+
+	if got, err := sn.readChunk(hash); err != nil || string(got) != string(data) {
+		t.Errorf("chunk stored during shutdown not readable: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deregistered) == 0 || deregistered[0] != sn.NodeID {
+		t.Errorf("want the node to deregister as %s, got %v", sn.NodeID, deregistered)
+	}
+}
@@ -0,0 +1,141 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSweepInterval is how often a node asks the coordinator which of
+	// its chunks are no longer referenced
+	DefaultSweepInterval = 24 * time.Hour
+	// DefaultSweepRate caps orphan deletions at 10 per second
+	DefaultSweepRate = 10
+
+	// sweepBatchSize is how many chunk hashes go into one coordinator query
+	sweepBatchSize = 10000
+)
+
+// startSweeper periodically deletes chunks that no file on the coordinator
+// refers to any more, such as chunks of files deleted while this node was
+// offline
+func (sn *StorageNode) startSweeper() {
+	if sn.SweepInterval <= 0 || sn.CoordinatorAddr == "" {
+		return
+	}
+
+	ticker := time.NewTicker(sn.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sn.stop:
+			return
+		case <-ticker.C:
+			sn.sweepOrphans()
+		}
+	}
+}
+
+// sweepOrphans asks the coordinator which stored chunks are unreferenced and
+// deletes them, at most SweepRate per second. Only chunks the coordinator
+// explicitly confirms are deleted, and chunks written since the sweep
+// started are kept, since an upload may have stored them again. It returns
+// the number of chunks deleted.
+func (sn *StorageNode) sweepOrphans() int {
+	started := time.Now()
+
+	sn.chunksLock.RLock()
+	hashes := make([]string, 0, len(sn.chunks))
+	for hash := range sn.chunks {
+		hashes = append(hashes, hash)
+	}
+	sn.chunksLock.RUnlock()
+
+	log.Printf("Sweep started: checking %d chunks with the coordinator", len(hashes))
+
+	var pause time.Duration
+	if sn.SweepRate > 0 {
+		pause = time.Second / time.Duration(sn.SweepRate)
+	}
+
+	deleted := 0
+	for start := 0; start < len(hashes); start += sweepBatchSize {
+		batch := hashes[start:min(start+sweepBatchSize, len(hashes))]
+
+		unreferenced, err := sn.queryUnreferenced(batch)
+		if err != nil {
+			log.Printf("Sweep: failed to check chunks with the coordinator, keeping them: %v", err)
+			break
+		}
+
+		requested := make(map[string]bool, len(batch))
+		for _, hash := range batch {
+			requested[hash] = true
+		}
+
+		for _, hash := range unreferenced {
+			if !requested[hash] || sn.chunkWrittenSince(hash, started) {
+				continue
+			}
+
+			err := sn.deleteChunk(hash)
+			if errors.Is(err, errChunkNotFound) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Sweep: failed to delete chunk %s: %v", hash[:8], err)
+				continue
+			}
+			deleted++
+
+			select {
+			case <-sn.stop:
+				log.Printf("Sweep stopped: %d orphaned chunks deleted", deleted)
+				return deleted
+			case <-time.After(pause):
+			}
+		}
+	}
+
+	log.Printf("Sweep complete: %d chunks checked, %d orphaned chunks deleted", len(hashes), deleted)
+	return deleted
+}
+
+// queryUnreferenced asks the coordinator which of the given chunks it no
+// longer references
+func (sn *StorageNode) queryUnreferenced(hashes []string) ([]string, error) {
+	resp, err := sn.postToCoordinator("/chunks/unreferenced", UnreferencedChunksRequest{
+		NodeID:      sn.NodeID,
+		ChunkHashes: hashes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("coordinator returned %s: %s", resp.Status, strings.TrimSpace(string(reason)))
+	}
+
+	var result UnreferencedChunksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Unreferenced, nil
+}
+
+// chunkWrittenSince reports whether a chunk's own file was written at or
+// after t
+func (sn *StorageNode) chunkWrittenSince(hash string, t time.Time) bool {
+	info, err := os.Stat(sn.chunkPath(hash))
+	return err == nil && !info.ModTime().Before(t)
+}
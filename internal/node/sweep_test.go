@@ -0,0 +1,111 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// unreferencedCoordinator answers a node's sweep queries with the given
+// hashes, and calls during, if set, before answering
+func unreferencedCoordinator(t *testing.T, sn *StorageNode, unreferenced []string, during func()) *[]UnreferencedChunksRequest {
+	t.Helper()
+
+	var queries []UnreferencedChunksRequest
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chunks/unreferenced" {
+			http.NotFound(w, r)
+			return
+		}
+		var req UnreferencedChunksRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		queries = append(queries, req)
+		if during != nil {
+			during()
+		}
+		json.NewEncoder(w).Encode(UnreferencedChunksResponse{Unreferenced: unreferenced})
+	})
+	return &queries
+}
+
+// TestSweepOrphans stores chunks on a node, has the coordinator confirm
+// some of them unreferenced, and checks only those are deleted, no faster
+// than the sweep rate
+func TestSweepOrphans(t *testing.T) {
+	sn := newTestNode(t)
+	sn.SweepRate = 20
+
+	var hashes []string
+	for i := 0; i < 5; i++ {
+		_, hash := storeTestChunk(t, sn, 1000)
+		hashes = append(hashes, hash)
+	}
+	_, unknown := testChunk(t, 1000)
+
+	// A hash the node didn't ask about is never deleted
+	queries := unreferencedCoordinator(t, sn, []string{hashes[0], hashes[1], unknown}, nil)
+
+	started := time.Now()
+	if deleted := sn.sweepOrphans(); deleted != 2 {
+		t.Errorf("want 2 chunks deleted, got %d", deleted)
+	}
+	if elapsed, pause := time.Since(started), time.Second/20; elapsed < 2*pause {
+		t.Errorf("2 deletions took %s, want at least %s at 20 per second", elapsed, 2*pause)
+	}
+
+	if len(*queries) != 1 || (*queries)[0].NodeID != "node-1" || len((*queries)[0].ChunkHashes) != 5 {
+		t.Errorf("want one query about all 5 chunks, got %+v", *queries)
+	}
+	for i, hash := range hashes {
+		if held := sn.hasChunk(hash); held != (i >= 2) {
+			t.Errorf("chunk %d: want held %v, got %v", i, i >= 2, held)
+		}
+	}
+}
+
+// TestSweepKeepsUnconfirmed checks nothing is deleted when the coordinator
+// can't confirm which chunks are unreferenced
+func TestSweepKeepsUnconfirmed(t *testing.T) {
+	sn := newTestNode(t)
+	_, hash := storeTestChunk(t, sn, 1000)
+
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no chunk metadata recorded", http.StatusConflict)
+	})
+	if deleted := sn.sweepOrphans(); deleted != 0 || !sn.hasChunk(hash) {
+		t.Errorf("want the chunk kept, got %d deleted", deleted)
+	}
+
+	// Nor without a coordinator to ask
+	sn.CoordinatorAddr = "127.0.0.1:1"
+	if deleted := sn.sweepOrphans(); deleted != 0 || !sn.hasChunk(hash) {
+		t.Errorf("want the chunk kept, got %d deleted", deleted)
+	}
+}
+
+// TestSweepKeepsRewrittenChunk checks a chunk written again while the sweep
+// was waiting on the coordinator survives, since an upload may need it
+func TestSweepKeepsRewrittenChunk(t *testing.T) {
+	sn := newTestNode(t)
+	sn.SweepRate = 0
+	_, rewritten := storeTestChunk(t, sn, 1000)
+	_, orphan := storeTestChunk(t, sn, 1000)
+
+	unreferencedCoordinator(t, sn, []string{rewritten, orphan}, func() {
+		now := time.Now().Add(time.Second)
+		if err := os.Chtimes(sn.chunkPath(rewritten), now, now); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if deleted := sn.sweepOrphans(); deleted != 1 {
+		t.Errorf("want 1 chunk deleted, got %d", deleted)
+	}
+	if !sn.hasChunk(rewritten) || sn.hasChunk(orphan) {
+		t.Errorf("want only the untouched orphan deleted")
+	}
+}
@@ -0,0 +1,211 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+)
+
+// testCA issues certificates for the cluster in a test, written as PEM
+// files auth.TLSConfig can load
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+	file string // The CA certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	ca := &testCA{dir: t.TempDir()}
+	ca.cert, ca.key = ca.sign(t, "test CA", nil)
+	ca.file = filepath.Join(ca.dir, "ca.pem")
+	writePEM(t, ca.file, "CERTIFICATE", ca.cert.Raw)
+	return ca
+}
+
+// sign creates a certificate for name, self-signed when parent is nil
+func (ca *testCA) sign(t *testing.T, name string, parent *testCA) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// issue returns the TLS config of a cluster member named name, holding a
+// certificate signed by the CA and trusting only the CA
+func (ca *testCA) issue(t *testing.T, name string) auth.TLSConfig {
+	t.Helper()
+
+	cert, key := ca.sign(t, name, ca)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := auth.TLSConfig{
+		CertFile: filepath.Join(ca.dir, name+".pem"),
+		KeyFile:  filepath.Join(ca.dir, name+"-key.pem"),
+		CAFile:   ca.file,
+	}
+	writePEM(t, config.CertFile, "CERTIFICATE", cert.Raw)
+	writePEM(t, config.KeyFile, "EC PRIVATE KEY", keyDER)
+	return config
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chunkRouter routes sn's chunk endpoints as Start does
+func chunkRouter(sn *StorageNode) http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/health", sn.healthHandler).Methods("GET")
+	router.HandleFunc("/store", sn.storeChunkHandler).Methods("POST")
+	router.HandleFunc("/retrieve/{hash}", sn.retrieveChunkHandler).Methods("GET")
+	router.HandleFunc("/chunks/{hash}", sn.deleteChunkHandler).Methods("DELETE")
+	router.Use(sn.requireClusterSecret)
+	return router
+}
+
+// tlsClient returns a NodeClient for server calling with config's TLS
+func tlsClient(t *testing.T, server *httptest.Server, config auth.TLSConfig) *NodeClient {
+	t.Helper()
+
+	clientTLS, err := config.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewNodeClient(&http.Client{Transport: auth.TLSTransport(clientTLS)}, server.URL)
+}
+
+// checkChunkRoundTrip stores, reads back and deletes a chunk through client
+func checkChunkRoundTrip(t *testing.T, client *NodeClient) {
+	t.Helper()
+
+	ctx := context.Background()
+	data, hash := testChunk(t, 1000)
+	if err := client.Store(ctx, hash, data); err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.Retrieve(ctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("chunk read back over TLS differs")
+	}
+	if err := client.Delete(ctx, hash); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := client.Exists(ctx, hash); err != nil || exists {
+		t.Errorf("chunk still held after delete: %v", err)
+	}
+}
+
+// TestNodeClientTLS checks NodeClient stores and reads chunks from a node
+// served over HTTPS, and refuses a node whose certificate it doesn't trust
+func TestNodeClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(chunkRouter(newTestNode(t)))
+	defer server.Close()
+
+	checkChunkRoundTrip(t, NewNodeClient(server.Client(), server.URL))
+
+	untrusting := NewNodeClient(http.DefaultClient, server.URL)
+	if err := untrusting.Health(context.Background()); err == nil {
+		t.Error("want an error from a node with an untrusted certificate")
+	}
+}
+
+// TestNodeClientMutualTLS runs a node requiring certificates signed by the
+// cluster CA, and checks only a client presenting one is served
+func TestNodeClientMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	sn := newTestNode(t)
+	sn.TLS = ca.issue(t, "node-1")
+	config, err := sn.TLS.ServerConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(chunkRouter(sn))
+	server.TLS = config
+	server.StartTLS()
+	defer server.Close()
+
+	checkChunkRoundTrip(t, tlsClient(t, server, ca.issue(t, "coordinator")))
+
+	ctx := context.Background()
+	_, hash := testChunk(t, 1000)
+
+	// Trusting the node is not enough without a certificate of its own
+	anonymous := NewNodeClient(&http.Client{Transport: auth.TLSTransport(&tls.Config{RootCAs: config.ClientCAs})}, server.URL)
+	if err := anonymous.Health(ctx); err != nil {
+		t.Fatalf("health checks need no certificate: %v", err)
+	}
+	if _, err := anonymous.Exists(ctx, hash); err == nil {
+		t.Error("want a client without a certificate turned away")
+	}
+
+	// Nor is a certificate from another CA
+	outsiderTLS := newTestCA(t).issue(t, "outsider")
+	outsiderTLS.CAFile = ca.file
+	if _, err := tlsClient(t, server, outsiderTLS).Exists(ctx, hash); err == nil {
+		t.Error("want a client with another CA's certificate turned away")
+	}
+}
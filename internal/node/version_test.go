@@ -0,0 +1,52 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/version"
+)
+
+// TestVersionEndpoint sets the build values as -ldflags would and checks
+// /version reports them, and that registering reports the node's protocol
+func TestVersionEndpoint(t *testing.T) {
+	saved := [3]string{version.Version, version.Commit, version.BuildDate}
+	t.Cleanup(func() { version.Version, version.Commit, version.BuildDate = saved[0], saved[1], saved[2] })
+	version.Version, version.Commit, version.BuildDate = "v1.4.0", "9f2c1e0", "2026-10-16T08:00:00Z"
+
+	sn := newTestNode(t)
+	registered := make(chan NodeInfo, 1)
+	fakeCoordinator(t, sn, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/register" {
+			return
+		}
+		var info NodeInfo
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			t.Error(err)
+		}
+		select {
+		case registered <- info:
+		default:
+		}
+	})
+	startTestNode(t, sn)
+
+	resp, err := http.Get("http://" + sn.Address + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var info version.Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "v1.4.0" || info.Commit != "9f2c1e0" || info.BuildDate != "2026-10-16T08:00:00Z" || info.Protocol != version.Protocol {
+		t.Errorf("want the injected build and protocol %d, got %+v", version.Protocol, info)
+	}
+
+	reported := <-registered
+	if reported.ProtocolVersion != version.Protocol || reported.Version != "v1.4.0" {
+		t.Errorf("want protocol %d and v1.4.0 reported on registration, got %d and %q", version.Protocol, reported.ProtocolVersion, reported.Version)
+	}
+}
@@ -0,0 +1,126 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxWrites caps how many chunks a node writes to disk at once, so
+// a burst of stores queues instead of exhausting file descriptors and
+// disk bandwidth
+const DefaultMaxWrites = 32
+
+// partialSuffix marks a chunk file still being written. It is renamed into
+// place once complete, so readers never see part of a chunk.
+const partialSuffix = ".partial"
+
+// chunkWrite is a write of one chunk in progress. Stores of the same hash
+// that arrive meanwhile wait for it and share its result, since a hash
+// names its data.
+type chunkWrite struct {
+	done chan struct{} // Closed once err is set
+	err  error
+}
+
+// isPartialChunkFile reports whether a file name is a chunk file that was
+// still being written
+func isPartialChunkFile(name string) bool {
+	return strings.HasSuffix(name, partialSuffix) && isChunkFile(strings.TrimSuffix(name, partialSuffix))
+}
+
+// writeChunk writes chunk data to disk and adds it to the index. A store of
+// a chunk already being written waits for that write instead of racing it
+// for the same file. Writes beyond MaxWrites wait for a free slot, or
+// until ctx is done.
+func (sn *StorageNode) writeChunk(ctx context.Context, chunkHash string, chunkData []byte) error {
+	if len(chunkHash) < 2 {
+		return fmt.Errorf("invalid chunk hash %q", chunkHash)
+	}
+
+	sn.writesLock.Lock()
+	if inFlight, ok := sn.writes[chunkHash]; ok {
+		sn.writesLock.Unlock()
+		select {
+		case <-inFlight.done:
+			if inFlight.err == nil && sn.VerifyDedup {
+				return sn.checkCollision(chunkHash, chunkData)
+			}
+			return inFlight.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	write := &chunkWrite{done: make(chan struct{})}
+	sn.writes[chunkHash] = write
+	sn.writesLock.Unlock()
+
+	write.err = sn.writeChunkFile(ctx, chunkHash, chunkData)
+
+	sn.writesLock.Lock()
+	delete(sn.writes, chunkHash)
+	sn.writesLock.Unlock()
+	close(write.done)
+
+	return write.err
+}
+
+// writeChunkFile writes a chunk to a partial file in a write slot and
+// renames it into place
+func (sn *StorageNode) writeChunkFile(ctx context.Context, chunkHash string, chunkData []byte) error {
+	if sn.writeSlots != nil {
+		select {
+		case sn.writeSlots <- struct{}{}:
+			defer func() { <-sn.writeSlots }()
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for a write slot: %w", ctx.Err())
+		}
+	}
+
+	if sn.VerifyDedup {
+		if err := sn.checkCollision(chunkHash, chunkData); err != nil {
+			return err
+		}
+	}
+
+	release, err := sn.reserveQuota(chunkHash, int64(len(chunkData)))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	chunkPath := sn.chunkPath(chunkHash)
+
+	// Create directory if needed, noting the directories created so their
+	// entries can be synced too
+	dirs := sn.createdDirs(filepath.Dir(chunkPath))
+	if err := os.MkdirAll(filepath.Dir(chunkPath), 0755); err != nil {
+		return fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+	if len(dirs) == 0 {
+		dirs = []string{filepath.Dir(chunkPath)}
+	} else {
+		// The topmost new directory's entry is in its parent
+		dirs = append(dirs, filepath.Dir(dirs[len(dirs)-1]))
+	}
+
+	// Write chunk data
+	partialPath := chunkPath + partialSuffix
+	if err := sn.writeNewFile(partialPath, chunkData); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := os.Rename(partialPath, chunkPath); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := sn.syncPlaced(chunkPath, dirs); err != nil {
+		os.Remove(chunkPath)
+		return fmt.Errorf("failed to sync chunk: %w", err)
+	}
+
+	sn.trackChunk(chunkHash, int64(len(chunkData)))
+	return nil
+}
@@ -0,0 +1,137 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentStoresOfOneChunk stores the same chunk from many clients at
+// once and checks every store succeeds and the node ends up with one
+// complete file for it, counted once
+func TestConcurrentStoresOfOneChunk(t *testing.T) {
+	sn := newTestNode(t)
+	sn.MaxWrites = 4
+	client := startTestNode(t, sn)
+	data, hash := testChunk(t, 64<<10)
+
+	const stores = 50
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, stores)
+	for range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- client.Store(context.Background(), hash, data)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("store: %v", err)
+		}
+	}
+
+	var files []string
+	err := filepath.WalkDir(sn.StoragePath, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, d.Name())
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != hash {
+		t.Errorf("want only the chunk's file, found %v", files)
+	}
+	if stored, err := os.ReadFile(sn.chunkPath(hash)); err != nil || !bytes.Equal(stored, data) {
+		t.Errorf("chunk file differs from what was stored (%v)", err)
+	}
+
+	sn.chunksLock.RLock()
+	chunks, used := len(sn.chunks), sn.usedBytes
+	sn.chunksLock.RUnlock()
+	if chunks != 1 || used != int64(len(data)) {
+		t.Errorf("want 1 chunk of %d bytes counted, got %d chunks of %d bytes", len(data), chunks, used)
+	}
+	sn.writesLock.Lock()
+	defer sn.writesLock.Unlock()
+	if len(sn.writes) != 0 {
+		t.Errorf("want no writes left in progress, got %d", len(sn.writes))
+	}
+}
+
+// TestWriteSlots fills every write slot and checks further writes wait for
+// one, giving up when their context ends, and a store of a chunk already
+// being written shares that write's result rather than writing it again
+func TestWriteSlots(t *testing.T) {
+	sn := newTestNode(t)
+	sn.writeSlots = make(chan struct{}, 2)
+	sn.writeSlots <- struct{}{}
+	sn.writeSlots <- struct{}{}
+	data, hash := testChunk(t, 1000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sn.writeChunk(ctx, hash, data); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("no free slot: want the write to give up, got %v", err)
+	}
+	if sn.hasChunk(hash) {
+		t.Fatal("chunk stored without a write slot")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	written := make(chan error)
+	go func() { written <- sn.writeChunk(ctx, hash, data) }()
+	for waiting := false; !waiting; {
+		sn.writesLock.Lock()
+		_, waiting = sn.writes[hash]
+		sn.writesLock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	joined := make(chan error)
+	joinedCtx, cancelJoined := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelJoined()
+	go func() { joined <- sn.writeChunk(joinedCtx, hash, data) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// The joined store's own context is live, so only sharing the first
+	// write's result fails it
+	cancel()
+	if err := <-written; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled write: want context.Canceled, got %v", err)
+	}
+	if err := <-joined; !errors.Is(err, context.Canceled) {
+		t.Errorf("joined write: want the first write's error, got %v", err)
+	}
+
+	<-sn.writeSlots
+	if err := sn.writeChunk(context.Background(), hash, data); err != nil {
+		t.Fatalf("write once a slot was free: %v", err)
+	}
+	if !sn.hasChunk(hash) {
+		t.Error("chunk not stored once a slot was free")
+	}
+	if len(sn.writeSlots) != 1 {
+		t.Errorf("want the write's slot given back, %d of 2 still held", len(sn.writeSlots))
+	}
+}
+
+func TestMaxWritesMustNotBeNegative(t *testing.T) {
+	sn := newTestNode(t)
+	sn.MaxWrites = -1
+	if err := sn.Start(); err == nil {
+		t.Error("want negative max writes refused")
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// RecordAccess appends an action on a file to the access log. The action
+// has already happened by the time it is recorded, so a failure is logged
+// rather than returned.
+func (s *FileService) RecordAccess(record metadata.AccessRecord) {
+	if err := s.db.RecordAccess(&record); err != nil {
+		log.Printf("Failed to record %s of file %s in the access log: %v", record.Action, record.FileID, err)
+	}
+}
+
+// AccessLog returns up to limit access log entries, newest first, for one
+// file or for all when fileID is empty. A positive before continues from
+// the entry with that ID.
+func (s *FileService) AccessLog(fileID string, before int64, limit int) ([]metadata.AccessRecord, error) {
+	return s.db.ListAccess(fileID, before, limit)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// AnalysisResult projects what uploading a file would cost without storing it
+type AnalysisResult struct {
+	FileName       string  `json:"file_name"`
+	Size           int64   `json:"size"`
+	TotalChunks    int     `json:"total_chunks"`
+	NewChunks      int     `json:"new_chunks"`
+	ExistingChunks int     `json:"existing_chunks"`
+	NewBytes       int64   `json:"new_bytes"`       // Unique bytes not yet stored
+	ProjectedBytes int64   `json:"projected_bytes"` // NewBytes across all replicas
+	DedupRatio     float64 `json:"dedup_ratio"`
+}
+
+// AnalyzeFile chunks a file and compares its chunks against those already
+// stored, without storing anything. Encrypted uploads get fresh chunk hashes,
+// so the projection only holds for unencrypted uploads.
+func (s *FileService) AnalyzeFile(file io.Reader, meta UploadMetadata) (*AnalysisResult, error) {
+	chunks, err := chunking.ChunkFile(file, s.hashAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to chunk file: %w", err)
+	}
+
+	// Repeated chunks within the file are only stored once
+	sizes := make(map[string]int)
+	var hashes []string
+	for _, chunk := range chunks {
+		if _, seen := sizes[chunk.Hash]; !seen {
+			hashes = append(hashes, chunk.Hash)
+		}
+		sizes[chunk.Hash] = len(chunk.Data)
+	}
+
+	stored, err := s.db.ChunksExist(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up existing chunks: %w", err)
+	}
+
+	result := &AnalysisResult{
+		FileName:    meta.FileName,
+		Size:        meta.Size,
+		TotalChunks: len(chunks),
+	}
+	for _, hash := range hashes {
+		if stored[hash] {
+			result.ExistingChunks++
+			continue
+		}
+		result.NewChunks++
+		result.NewBytes += int64(sizes[hash])
+	}
+
+	result.ProjectedBytes = result.NewBytes
+	if len(s.registry.GetHealthyNodes()) > 0 {
+		replication := meta.Replication
+		if replication == 0 {
+			replication = ReplicationCount
+		}
+		result.ProjectedBytes *= int64(replication)
+	}
+	result.DedupRatio = float64(len(chunks)) / float64(max(result.NewChunks, 1))
+
+	return result, nil
+}
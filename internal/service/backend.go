@@ -0,0 +1,259 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// ChunkBackend is a place chunks can be stored. Chunks are addressed by
+// content hash, so storing the same chunk twice is harmless. Backends that
+// replicate keep replicas copies of each chunk; others ignore it. Store and
+// Get give up when ctx is done.
+type ChunkBackend interface {
+	Name() string
+	Store(ctx context.Context, hash string, data []byte, replicas int) (*Placement, error)
+	Get(ctx context.Context, hash string) ([]byte, error)
+	Exists(hash string) (bool, error)
+	Delete(hash string) error
+}
+
+// batchBackend is implemented by backends that can store many chunks more
+// efficiently than one at a time. Chunks missing from the result were not
+// stored.
+type batchBackend interface {
+	StoreBatch(ctx context.Context, chunks []*chunking.Chunk, replicas int) map[string]*Placement
+}
+
+// streamBackend is implemented by backends that can hand out a chunk as a
+// stream instead of reading it into memory whole. Data is checked against
+// the chunk's hash as it streams, so a bad copy is only caught at its end:
+// the Read that reaches it fails. The size is -1 when unknown.
+type streamBackend interface {
+	Open(ctx context.Context, hash string) (io.ReadCloser, int64, error)
+}
+
+// Placement records where a backend put a chunk
+type Placement struct {
+	StoragePath string                // Saved with the chunk's metadata
+	Nodes       []string              // Storage nodes holding the chunk or its shards
+	Coding      *metadata.ChunkCoding // Set when the chunk was erasure-coded
+}
+
+// storeBatch stores chunks on a backend, batching when it supports it
+func storeBatch(ctx context.Context, backend ChunkBackend, chunks []*chunking.Chunk, replicas int) map[string]*Placement {
+	if batcher, ok := backend.(batchBackend); ok {
+		return batcher.StoreBatch(ctx, chunks, replicas)
+	}
+
+	placements := make(map[string]*Placement)
+	for _, chunk := range chunks {
+		if ctx.Err() != nil {
+			break
+		}
+		if placements[chunk.Hash] != nil {
+			continue
+		}
+
+		placement, err := backend.Store(ctx, chunk.Hash, chunk.Data, replicas)
+		if err != nil {
+			log.Printf("Failed to store chunk %s on %s backend: %v", chunk.Hash[:8], backend.Name(), err)
+			continue
+		}
+		placements[chunk.Hash] = placement
+	}
+
+	return placements
+}
+
+// openChunk streams a chunk from a backend, reading it whole first from
+// backends that can't stream
+func openChunk(ctx context.Context, backend ChunkBackend, hash string) (io.ReadCloser, int64, error) {
+	if streamer, ok := backend.(streamBackend); ok {
+		return streamer.Open(ctx, hash)
+	}
+
+	data, err := backend.Get(ctx, hash)
+	if err != nil {
+		return nil, 0, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+// FallbackBackend tries its backends in order: chunks are stored on the
+// first backend that accepts them and read from the first that has them.
+// Once ctx is done, later backends are not tried.
+type FallbackBackend struct {
+	backends []ChunkBackend
+}
+
+// NewFallbackBackend chains backends, most preferred first
+func NewFallbackBackend(backends ...ChunkBackend) *FallbackBackend {
+	return &FallbackBackend{backends: backends}
+}
+
+func (f *FallbackBackend) Name() string {
+	names := make([]string, len(f.backends))
+	for i, backend := range f.backends {
+		names[i] = backend.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Store stores a chunk on the first backend that accepts it
+func (f *FallbackBackend) Store(ctx context.Context, hash string, data []byte, replicas int) (*Placement, error) {
+	var errs []error
+	for _, backend := range f.backends {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		placement, err := backend.Store(ctx, hash, data, replicas)
+		if err == nil {
+			return placement, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", backend.Name(), err))
+	}
+
+	return nil, fmt.Errorf("no backend stored chunk: %w", errors.Join(errs...))
+}
+
+// StoreBatch offers all chunks to the first backend, then whatever it
+// didn't store to the next, and so on
+func (f *FallbackBackend) StoreBatch(ctx context.Context, chunks []*chunking.Chunk, replicas int) map[string]*Placement {
+	placements := make(map[string]*Placement)
+	pending := chunks
+
+	for i, backend := range f.backends {
+		if len(pending) == 0 || ctx.Err() != nil {
+			break
+		}
+		if i > 0 {
+			log.Printf("%d chunks not stored, falling back to %s backend", len(pending), backend.Name())
+		}
+
+		stored := storeBatch(ctx, backend, pending, replicas)
+
+		var remaining []*chunking.Chunk
+		for _, chunk := range pending {
+			if placement := stored[chunk.Hash]; placement != nil {
+				placements[chunk.Hash] = placement
+			} else {
+				remaining = append(remaining, chunk)
+			}
+		}
+		pending = remaining
+	}
+
+	return placements
+}
+
+// Get reads a chunk from the first backend that has it
+func (f *FallbackBackend) Get(ctx context.Context, hash string) ([]byte, error) {
+	var errs []error
+	for _, backend := range f.backends {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := backend.Get(ctx, hash)
+		if err == nil {
+			return data, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", backend.Name(), err))
+	}
+
+	return nil, fmt.Errorf("chunk not found: %w", errors.Join(errs...))
+}
+
+// Open streams a chunk from the first backend that has it
+func (f *FallbackBackend) Open(ctx context.Context, hash string) (io.ReadCloser, int64, error) {
+	var errs []error
+	for _, backend := range f.backends {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		stream, size, err := openChunk(ctx, backend, hash)
+		if err == nil {
+			return stream, size, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", backend.Name(), err))
+	}
+
+	return nil, 0, fmt.Errorf("chunk not found: %w", errors.Join(errs...))
+}
+
+// Exists reports whether any backend has the chunk
+func (f *FallbackBackend) Exists(hash string) (bool, error) {
+	var errs []error
+	for _, backend := range f.backends {
+		exists, err := backend.Exists(hash)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", backend.Name(), err))
+			continue
+		}
+		if exists {
+			return true, nil
+		}
+	}
+
+	return false, errors.Join(errs...)
+}
+
+// Delete removes a chunk from every backend, since earlier fallbacks or
+// repairs may have left copies on more than one
+func (f *FallbackBackend) Delete(hash string) error {
+	var errs []error
+	for _, backend := range f.backends {
+		if err := backend.Delete(hash); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", backend.Name(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// localBackend stores chunks in the coordinator's own ChunkStorer (disk,
+// memory, or S3)
+type localBackend struct {
+	store ChunkStorer
+}
+
+// NewLocalBackend wraps a chunk store as a backend
+func NewLocalBackend(store ChunkStorer) ChunkBackend {
+	return &localBackend{store: store}
+}
+
+func (b *localBackend) Name() string {
+	return "local"
+}
+
+func (b *localBackend) Store(ctx context.Context, hash string, data []byte, replicas int) (*Placement, error) {
+	storagePath, _, err := b.store.StoreChunk(hash, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Placement{StoragePath: storagePath}, nil
+}
+
+func (b *localBackend) Get(ctx context.Context, hash string) ([]byte, error) {
+	return b.store.GetChunk(hash)
+}
+
+func (b *localBackend) Exists(hash string) (bool, error) {
+	return b.store.HasChunk(hash), nil
+}
+
+// Delete removes the chunk if the store has it; a chunk that was never
+// stored locally is not an error
+func (b *localBackend) Delete(hash string) error {
+	if !b.store.HasChunk(hash) {
+		return nil
+	}
+	return b.store.DeleteChunk(hash)
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+)
+
+// fakeBackend keeps chunks in memory and can be made to refuse stores, of
+// every chunk or of the chunks in refuse
+type fakeBackend struct {
+	name    string
+	down    bool
+	refuse  map[string]bool
+	mu      sync.Mutex
+	chunks  map[string][]byte
+	stores  int
+	deletes int
+}
+
+func newFakeBackend(name string) *fakeBackend {
+	return &fakeBackend{name: name, refuse: make(map[string]bool), chunks: make(map[string][]byte)}
+}
+
+func (b *fakeBackend) Name() string {
+	return b.name
+}
+
+func (b *fakeBackend) Store(ctx context.Context, hash string, data []byte, replicas int) (*Placement, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stores++
+	if b.down || b.refuse[hash] {
+		return nil, fmt.Errorf("%s refused the chunk", b.name)
+	}
+	b.chunks[hash] = data
+	return &Placement{StoragePath: b.name}, nil
+}
+
+func (b *fakeBackend) Get(ctx context.Context, hash string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, exists := b.chunks[hash]
+	if !exists {
+		return nil, fmt.Errorf("chunk not found: %s", hash)
+	}
+	return data, nil
+}
+
+func (b *fakeBackend) Exists(hash string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.down {
+		return false, errors.New("backend down")
+	}
+	_, exists := b.chunks[hash]
+	return exists, nil
+}
+
+func (b *fakeBackend) Delete(hash string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deletes++
+	delete(b.chunks, hash)
+	return nil
+}
+
+// TestFallbackBackendStore checks a chunk goes to the first backend that
+// takes it, reads back from whichever has it, and fails only when every
+// backend refuses it
+func TestFallbackBackendStore(t *testing.T) {
+	primary, secondary := newFakeBackend("primary"), newFakeBackend("secondary")
+	local := dedup.NewMemoryChunkStore()
+	fallback := NewFallbackBackend(primary, secondary, NewLocalBackend(local))
+	ctx := context.Background()
+
+	if name := fallback.Name(); name != "primary,secondary,local" {
+		t.Errorf("want the chain named primary,secondary,local, got %s", name)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		primaryDown    bool
+		secondaryDown  bool
+		wantStoredOn   string
+		wantLocalChunk bool
+	}{
+		{"primary up", false, false, "primary", false},
+		{"primary down", true, false, "secondary", false},
+		{"both down", true, true, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			primary.down, secondary.down = tc.primaryDown, tc.secondaryDown
+			data := randomBytes(t, 1000)
+			hash := chunking.SHA256.Sum(data)
+
+			placement, err := fallback.Store(ctx, hash, data, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantStoredOn != "" && placement.StoragePath != tc.wantStoredOn {
+				t.Errorf("want the chunk on %s, got %s", tc.wantStoredOn, placement.StoragePath)
+			}
+			if local.HasChunk(hash) != tc.wantLocalChunk {
+				t.Errorf("want the chunk in the local store: %v", tc.wantLocalChunk)
+			}
+
+			got, err := fallback.Get(ctx, hash)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("chunk does not read back: %v", err)
+			}
+			if exists, err := fallback.Exists(hash); !exists {
+				t.Errorf("want the chunk found, got %v", err)
+			}
+		})
+	}
+
+	// Without the local store, a chunk every backend refuses is not stored
+	primary.down, secondary.down = true, true
+	if _, err := NewFallbackBackend(primary, secondary).Store(ctx, "abc", []byte("x"), 1); err == nil ||
+		!strings.Contains(err.Error(), "primary") || !strings.Contains(err.Error(), "secondary") {
+		t.Errorf("want an error naming every backend, got %v", err)
+	}
+	if _, err := fallback.Get(ctx, strings.Repeat("0", 64)); err == nil {
+		t.Error("want reading an unknown chunk to fail")
+	}
+}
+
+// TestFallbackBackendStoreBatch checks only the chunks a backend refused
+// are offered to the next one
+func TestFallbackBackendStoreBatch(t *testing.T) {
+	primary, secondary := newFakeBackend("primary"), newFakeBackend("secondary")
+	fallback := NewFallbackBackend(primary, secondary)
+
+	var chunks []*chunking.Chunk
+	for i := 0; i < 6; i++ {
+		data := randomBytes(t, 100)
+		chunk := &chunking.Chunk{Data: data, Hash: chunking.SHA256.Sum(data)}
+		chunks = append(chunks, chunk)
+		if i%2 == 1 {
+			primary.refuse[chunk.Hash] = true
+		}
+	}
+
+	placements := fallback.StoreBatch(context.Background(), chunks, 1)
+	for i, chunk := range chunks {
+		want := "primary"
+		if i%2 == 1 {
+			want = "secondary"
+		}
+		if placement := placements[chunk.Hash]; placement == nil || placement.StoragePath != want {
+			t.Errorf("chunk %d: want it on %s, got %+v", i, want, placement)
+		}
+	}
+	if secondary.stores != 3 {
+		t.Errorf("want only the 3 refused chunks offered to the fallback, got %d", secondary.stores)
+	}
+}
+
+// TestFallbackBackendCancelled checks a done context stops the chain
+// before it reaches later backends
+func TestFallbackBackendCancelled(t *testing.T) {
+	primary, secondary := newFakeBackend("primary"), newFakeBackend("secondary")
+	primary.down = true
+	fallback := NewFallbackBackend(primary, secondary)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fallback.Store(ctx, "abc", []byte("x"), 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	if primary.stores != 0 || secondary.stores != 0 {
+		t.Errorf("want no backend tried, got %d and %d stores", primary.stores, secondary.stores)
+	}
+}
+
+// TestFallbackBackendDelete checks a chunk is deleted from every backend,
+// wherever it was stored
+func TestFallbackBackendDelete(t *testing.T) {
+	primary, secondary := newFakeBackend("primary"), newFakeBackend("secondary")
+	fallback := NewFallbackBackend(primary, secondary)
+	ctx := context.Background()
+
+	primary.Store(ctx, "abc", []byte("x"), 1)
+	secondary.Store(ctx, "abc", []byte("x"), 1)
+	if err := fallback.Delete("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if primary.deletes != 1 || secondary.deletes != 1 {
+		t.Errorf("want the chunk deleted from both backends, got %d and %d", primary.deletes, secondary.deletes)
+	}
+	if exists, _ := fallback.Exists("abc"); exists {
+		t.Error("chunk still found after delete")
+	}
+}
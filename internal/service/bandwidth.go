@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidBandwidth is returned for a negative bandwidth limit
+var ErrInvalidBandwidth = errors.New("invalid bandwidth limit")
+
+// SetBackgroundBandwidth caps the bytes per second the coordinator moves
+// between nodes for background work: re-replication, placement retries,
+// lost chunk restores, replica checks and read-repair. Uploads and
+// downloads are never held back by it. Zero removes the cap. It may be
+// changed while transfers are running, which switch to the new rate within
+// about a second.
+func (s *FileService) SetBackgroundBandwidth(bytesPerSec int64) error {
+	if bytesPerSec < 0 {
+		return ErrInvalidBandwidth
+	}
+	s.bandwidth.SetRate(bytesPerSec)
+	return nil
+}
+
+// BackgroundBandwidth returns the background transfer cap in bytes per
+// second, or 0 if there is none
+func (s *FileService) BackgroundBandwidth() int64 {
+	return s.bandwidth.Rate()
+}
+
+// backgroundStore stores a chunk on a node as background work, once the
+// bandwidth cap allows it
+func (s *FileService) backgroundStore(ctx context.Context, chunkHash string, chunkData []byte, nodeID string) error {
+	if err := s.bandwidth.WaitN(ctx, len(chunkData)); err != nil {
+		return err
+	}
+	return s.storeChunkOnNode(ctx, chunkHash, chunkData, nodeID)
+}
+
+// backgroundRead reads a chunk from a node as background work. The size of
+// a chunk is only known once it is read, so the wait comes after.
+func (s *FileService) backgroundRead(ctx context.Context, chunkHash, nodeID string) ([]byte, error) {
+	chunkData, err := s.retrieveChunkFromNode(ctx, chunkHash, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.bandwidth.WaitN(ctx, len(chunkData)); err != nil {
+		return nil, err
+	}
+	return chunkData, nil
+}
+
+// backgroundRetrieve reads a chunk from wherever it is held, as
+// backgroundRead does from one node
+func (s *FileService) backgroundRetrieve(ctx context.Context, chunkHash string) ([]byte, error) {
+	chunkData, err := s.retrieveChunk(chunkHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.bandwidth.WaitN(ctx, len(chunkData)); err != nil {
+		return nil, err
+	}
+	return chunkData, nil
+}
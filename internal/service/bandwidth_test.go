@@ -0,0 +1,81 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// TestBackgroundBandwidth caps background transfers and checks a repair
+// copying a chunk to two nodes takes at least as long as the cap allows,
+// while uploads and downloads go through under a cap of 1 byte/sec
+func TestBackgroundBandwidth(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 3)
+	if err := s.SetBackgroundBandwidth(-1); !errors.Is(err, ErrInvalidBandwidth) {
+		t.Errorf("negative cap: want ErrInvalidBandwidth, got %v", err)
+	}
+
+	// A throttled transfer fails once it can't finish before the deadline,
+	// so these only succeed if nothing waits on the cap
+	if err := s.SetBackgroundBandwidth(1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	data := randomBytes(t, 1000)
+	result, err := s.UploadFile(ctx, bytes.NewReader(data), UploadMetadata{FileName: "test.bin", Size: int64(len(data)), Replication: 3})
+	if err != nil {
+		t.Fatalf("upload under a 1 byte/sec cap: %v", err)
+	}
+	d, err := s.DownloadFile(ctx, result.FileID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var downloaded bytes.Buffer
+	_, err = d.WriteTo(&downloaded)
+	d.Close()
+	if err != nil || !bytes.Equal(downloaded.Bytes(), data) {
+		t.Fatalf("download under a 1 byte/sec cap: want the file, got %d bytes (%v)", downloaded.Len(), err)
+	}
+
+	hash := result.ChunkHashes[0]
+	ctx = context.Background()
+	for _, sn := range nodes[:2] {
+		if err := node.NewNodeClient(http.DefaultClient, "http://"+sn.Address).Delete(ctx, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reading the good copy and storing two more moves 3000 bytes, of which
+	// a second's worth may pass at once
+	const bytesPerSec = 2000
+	if err := s.SetBackgroundBandwidth(bytesPerSec); err != nil {
+		t.Fatal(err)
+	}
+	if s.BackgroundBandwidth() != bytesPerSec {
+		t.Errorf("want a cap of %d, got %d", bytesPerSec, s.BackgroundBandwidth())
+	}
+	start := time.Now()
+	check, err := s.CheckReplicas(ctx, hash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, want := time.Since(start), time.Duration(3*len(data)-bytesPerSec)*time.Second/bytesPerSec; elapsed < want {
+		t.Errorf("want the repair to take at least %v, took %v", want, elapsed)
+	}
+	if !check.Consistent || check.Repaired != 2 {
+		t.Errorf("want both missing replicas repaired, got %+v", check)
+	}
+
+	if err := s.SetBackgroundBandwidth(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.BackgroundBandwidth() != 0 {
+		t.Errorf("want no cap once lifted, got %d", s.BackgroundBandwidth())
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// chunkLimiter bounds how many chunks uploads hold in memory at once, so a
+// burst of uploads queues instead of exhausting the coordinator's memory.
+// Each slot stands for one chunk read but not yet stored.
+type chunkLimiter struct {
+	slots chan struct{}
+
+	waiting atomic.Int64 // uploads blocked waiting for a slot
+	waits   atomic.Int64 // times an upload had to wait
+}
+
+// ChunkBufferStats reports how many chunk buffers uploads hold
+type ChunkBufferStats struct {
+	InFlight int   `json:"in_flight"`
+	Max      int   `json:"max"`
+	Waiting  int64 `json:"waiting"` // Uploads queued for a buffer right now
+	Waits    int64 `json:"waits"`   // Times an upload has had to queue
+}
+
+func newChunkLimiter(max int) *chunkLimiter {
+	return &chunkLimiter{slots: make(chan struct{}, max)}
+}
+
+// tryAcquire takes a slot if one is free
+func (l *chunkLimiter) tryAcquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire waits for a free slot, giving up when ctx is done
+func (l *chunkLimiter) acquire(ctx context.Context) error {
+	if l.tryAcquire() {
+		return nil
+	}
+
+	l.waiting.Add(1)
+	l.waits.Add(1)
+	defer l.waiting.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release returns n slots
+func (l *chunkLimiter) release(n int) {
+	for i := 0; i < n; i++ {
+		<-l.slots
+	}
+}
+
+func (l *chunkLimiter) stats() ChunkBufferStats {
+	return ChunkBufferStats{
+		InFlight: len(l.slots),
+		Max:      cap(l.slots),
+		Waiting:  l.waiting.Load(),
+		Waits:    l.waits.Load(),
+	}
+}
+
+// UseMaxChunkBuffers caps the chunks all uploads together hold in memory
+// between reading and storing them. An upload that finds none free stores
+// the chunks it holds before queueing for more, so uploads never wait on
+// each other while holding buffers. Zero removes the cap.
+func (s *FileService) UseMaxChunkBuffers(max int) error {
+	if max < 0 {
+		return fmt.Errorf("chunk buffer limit must not be negative, got %d", max)
+	}
+	if max == 0 {
+		s.chunkBuffers = nil
+		return nil
+	}
+	s.chunkBuffers = newChunkLimiter(max)
+	return nil
+}
+
+// ChunkBufferStats returns how many chunk buffers uploads hold and how many
+// are waiting for one, or nil if buffers aren't limited
+func (s *FileService) ChunkBufferStats() *ChunkBufferStats {
+	if s.chunkBuffers == nil {
+		return nil
+	}
+	stats := s.chunkBuffers.stats()
+	return &stats
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// TestChunkBufferLimit runs more concurrent uploads than there are chunk
+// buffers, sampling the buffers held throughout, and checks they never
+// exceed the limit, uploads queued for them, and every upload completes
+// intact with all buffers returned
+func TestChunkBufferLimit(t *testing.T) {
+	s, _, _ := newTestService(t)
+	if s.ChunkBufferStats() != nil {
+		t.Fatal("want no buffer stats without a limit")
+	}
+	if err := s.UseMaxChunkBuffers(-1); err == nil {
+		t.Error("negative limit: want an error")
+	}
+	const limit = 3
+	if err := s.UseMaxChunkBuffers(limit); err != nil {
+		t.Fatal(err)
+	}
+
+	files := make([][]byte, 6)
+	for i := range files {
+		files[i] = randomBytes(t, 2*chunking.MaxChunkSize)
+	}
+
+	done := make(chan struct{})
+	peak := make(chan int)
+	go func() {
+		highest := 0
+		for {
+			select {
+			case <-done:
+				peak <- highest
+				return
+			default:
+			}
+			highest = max(highest, s.ChunkBufferStats().InFlight)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	results := make([]*UploadResult, len(files))
+	var wg sync.WaitGroup
+	for i, data := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := s.UploadFile(context.Background(), bytes.NewReader(data), UploadMetadata{FileName: "test.bin", Size: int64(len(data))})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	close(done)
+
+	if highest := <-peak; highest > limit {
+		t.Errorf("want at most %d buffers held, saw %d", limit, highest)
+	}
+	stats := s.ChunkBufferStats()
+	if stats.InFlight != 0 || stats.Waiting != 0 || stats.Max != limit {
+		t.Errorf("want every buffer returned, got %+v", stats)
+	}
+	if stats.Waits == 0 {
+		t.Error("want uploads to have queued for buffers")
+	}
+
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		if got := download(t, s, result.FileID, ""); !bytes.Equal(got, files[i]) {
+			t.Errorf("upload %d differs", i)
+		}
+	}
+}
+
+// TestChunkBufferWaitCancelled checks an upload queued for a buffer gives up
+// when its context ends
+func TestChunkBufferWaitCancelled(t *testing.T) {
+	s, _, _ := newTestService(t)
+	if err := s.UseMaxChunkBuffers(1); err != nil {
+		t.Fatal(err)
+	}
+	// Another upload holds the only buffer
+	if !s.chunkBuffers.tryAcquire() {
+		t.Fatal("buffer not free")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	data := randomBytes(t, 1000)
+	_, err := s.UploadFile(ctx, bytes.NewReader(data), UploadMetadata{FileName: "test.bin", Size: int64(len(data))})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want the wait to time out, got %v", err)
+	}
+
+	if stats := s.ChunkBufferStats(); stats.InFlight != 1 || stats.Waiting != 0 || stats.Waits != 1 {
+		t.Errorf("want only the held buffer left, got %+v", stats)
+	}
+}
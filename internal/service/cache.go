@@ -0,0 +1,101 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// chunkCache is an LRU cache of chunk data read from the nodes, bounded by
+// the total bytes held. Chunks are content-addressed and never change, so
+// entries need no invalidation. Cached slices are shared between readers
+// and must not be modified.
+type chunkCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	bytes   int64
+	order   *list.List               // Most recently used at the front
+	entries map[string]*list.Element // hash -> element holding a *cachedChunk
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cachedChunk struct {
+	hash string
+	data []byte
+}
+
+// ChunkCacheStats reports how the coordinator's chunk cache is doing
+type ChunkCacheStats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+func newChunkCache(maxBytes int64) *chunkCache {
+	return &chunkCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns a cached chunk, marking it recently used
+func (c *chunkCache) get(hash string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[hash]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedChunk).data, true
+}
+
+// add caches a chunk, evicting the least recently used chunks to make room.
+// Chunks bigger than the whole cache are not cached.
+func (c *chunkCache) add(hash string, data []byte) {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[hash]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	for c.bytes+size > c.maxBytes {
+		oldest := c.order.Back()
+		evicted := c.order.Remove(oldest).(*cachedChunk)
+		delete(c.entries, evicted.hash)
+		c.bytes -= int64(len(evicted.data))
+	}
+
+	c.entries[hash] = c.order.PushFront(&cachedChunk{hash: hash, data: data})
+	c.bytes += size
+}
+
+func (c *chunkCache) stats() ChunkCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ChunkCacheStats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Entries:  len(c.entries),
+		Bytes:    c.bytes,
+		MaxBytes: c.maxBytes,
+	}
+}
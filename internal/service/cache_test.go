@@ -0,0 +1,104 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// TestChunkCacheServesSecondDownload downloads a file once, deletes its
+// chunks from every node, and checks a second download is still served,
+// entirely from the cache, with encrypted files cached as ciphertext
+func TestChunkCacheServesSecondDownload(t *testing.T) {
+	for _, password := range []string{"", "secret"} {
+		name := "plain"
+		if password != "" {
+			name = "encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			s, _, nodes := newTestCluster(t, 3)
+			s.UseChunkCache(64 << 20)
+			ctx := context.Background()
+
+			data := randomBytes(t, 3*chunking.MaxChunkSize)
+			result := upload(t, s, data, UploadMetadata{Password: password})
+			chunks := len(result.ChunkHashes)
+
+			if got := download(t, s, result.FileID, password); !bytes.Equal(got, data) {
+				t.Fatal("first download differs")
+			}
+			if stats := s.ChunkCacheStats(); stats.Hits != 0 || stats.Misses != int64(chunks) || stats.Entries != chunks {
+				t.Fatalf("after the first download, want %d misses cached, got %+v", chunks, stats)
+			}
+
+			for _, hash := range result.ChunkHashes {
+				cached, ok := s.cache.get(hash)
+				if !ok {
+					t.Fatalf("chunk %s not cached", hash[:8])
+				}
+				if _, ok := chunking.MatchHash(cached, hash); !ok {
+					t.Errorf("chunk %s cached with data not matching its hash", hash[:8])
+				}
+				if password != "" && bytes.Contains(data, cached[:64]) {
+					t.Errorf("chunk %s cached as plaintext", hash[:8])
+				}
+			}
+
+			for _, sn := range nodes {
+				client := node.NewNodeClient(http.DefaultClient, "http://"+sn.Address)
+				for _, hash := range result.ChunkHashes {
+					if err := client.Delete(ctx, hash); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			before := s.ChunkCacheStats()
+			if got := download(t, s, result.FileID, password); !bytes.Equal(got, data) {
+				t.Fatal("second download differs")
+			}
+			if stats := s.ChunkCacheStats(); stats.Hits-before.Hits != int64(chunks) || stats.Misses != before.Misses {
+				t.Errorf("want the second download's %d chunks all cache hits, got %+v after %+v", chunks, stats, before)
+			}
+		})
+	}
+}
+
+// TestChunkCacheEviction checks the cache stays within its byte limit by
+// evicting the least recently used chunks, and skips chunks bigger than it
+func TestChunkCacheEviction(t *testing.T) {
+	cache := newChunkCache(3000)
+	chunk := func(b byte) []byte { return bytes.Repeat([]byte{b}, 1000) }
+
+	cache.add("a", chunk('a'))
+	cache.add("b", chunk('b'))
+	cache.add("c", chunk('c'))
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("chunk a not cached")
+	}
+
+	// b is now the least recently used
+	cache.add("d", chunk('d'))
+	for hash, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := cache.get(hash); ok != want {
+			t.Errorf("chunk %s: want cached %v, got %v", hash, want, ok)
+		}
+	}
+
+	cache.add("huge", make([]byte, 3001))
+	if _, ok := cache.get("huge"); ok {
+		t.Error("chunk bigger than the cache was cached")
+	}
+
+	stats := cache.stats()
+	if stats.Bytes != 3000 || stats.Entries != 3 || stats.MaxBytes != 3000 {
+		t.Errorf("want 3 chunks in 3000 bytes, got %+v", stats)
+	}
+	if stats.Hits != 4 || stats.Misses != 2 {
+		t.Errorf("want 4 hits and 2 misses, got %+v", stats)
+	}
+}
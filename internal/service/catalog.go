@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// ErrInvalidCatalog is returned when an import stream can't be parsed
+var ErrInvalidCatalog = errors.New("invalid catalog")
+
+// CatalogEntry is one line of a metadata export: a file record with its
+// chunk manifest. It holds everything needed to recreate the file's
+// metadata, but none of its data.
+type CatalogEntry struct {
+	File         metadata.FileRecord `json:"file"`
+	PasswordHash string              `json:"password_hash,omitempty"` // FileRecord leaves it out of its JSON
+	Chunks       []CatalogChunk      `json:"chunks"`
+}
+
+// CatalogChunk is one chunk of a CatalogEntry, in file order
+type CatalogChunk struct {
+	Hash          string                `json:"hash"`
+	Offset        int64                 `json:"offset"`      // Start in the plaintext
+	StoredSize    int                   `json:"stored_size"` // Bytes as stored
+	StoragePath   string                `json:"storage_path"`
+	Replication   int                   `json:"replication"`
+	HashAlgorithm string                `json:"hash_algorithm,omitempty"`
+	Nodes         []string              `json:"nodes,omitempty"`  // Nodes recorded holding it
+	Coding        *metadata.ChunkCoding `json:"coding,omitempty"` // Set for erasure-coded chunks
+}
+
+// CatalogImport reports how an import went
+type CatalogImport struct {
+	Imported int             `json:"imported"`
+	Skipped  int             `json:"skipped"` // Already present
+	Failed   []CatalogFailed `json:"failed"`
+}
+
+// CatalogFailed is a file an import could not recreate
+type CatalogFailed struct {
+	Line   int    `json:"line"`
+	FileID string `json:"file_id,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ExportCatalog writes every file that is neither in the trash nor expired
+// to w as JSON Lines, one CatalogEntry per file, oldest first so an import
+// numbers the versions of a name in the same order. Each file's chunks are
+// looked up and written before the next file's, so the export is never held
+// in memory. It returns how many files were exported.
+func (s *FileService) ExportCatalog(ctx context.Context, w io.Writer) (int, error) {
+	files, err := s.db.ListFiles()
+	if err != nil {
+		return 0, err
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].UploadedAt.Before(files[j].UploadedAt)
+	})
+
+	encoder := json.NewEncoder(w)
+	exported := 0
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return exported, err
+		}
+
+		entry, err := s.catalogEntry(file)
+		if errors.Is(err, metadata.ErrFileNotFound) {
+			// Deleted since it was listed
+			continue
+		}
+		if err != nil {
+			return exported, fmt.Errorf("failed to export file %s: %w", file.FileID, err)
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+
+	log.Printf("Exported metadata of %d files", exported)
+	return exported, nil
+}
+
+// catalogEntry looks up a listed file's chunk manifest
+func (s *FileService) catalogEntry(listed metadata.FileRecord) (*CatalogEntry, error) {
+	file, chunks, err := s.db.GetFileWithChunks(listed.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = chunk.ChunkHash
+	}
+	records, err := s.db.GetChunks(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunks: %w", err)
+	}
+
+	entry := &CatalogEntry{
+		File:         *file,
+		PasswordHash: file.PasswordHash,
+		Chunks:       make([]CatalogChunk, len(chunks)),
+	}
+	// The listing is what fills in tags
+	entry.File.Tags = listed.Tags
+
+	for i, chunk := range chunks {
+		record := records[chunk.ChunkHash]
+		if record == nil {
+			return nil, fmt.Errorf("chunk %d (%s) has no metadata", i, chunk.ChunkHash[:8])
+		}
+
+		entry.Chunks[i] = CatalogChunk{
+			Hash:          chunk.ChunkHash,
+			Offset:        chunk.Offset,
+			StoredSize:    record.ChunkSize,
+			StoragePath:   record.StoragePath,
+			Replication:   record.Replication,
+			HashAlgorithm: record.HashAlgorithm,
+			Nodes:         chunk.Nodes,
+		}
+		if strings.HasPrefix(record.StoragePath, "erasure:") {
+			if entry.Chunks[i].Coding, err = s.db.GetChunkCoding(chunk.ChunkHash); err != nil {
+				return nil, fmt.Errorf("failed to look up coding of chunk %d: %w", i, err)
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+// ImportCatalog recreates the metadata of the files in an export read from
+// r, keeping their file IDs. Chunk data is not read: it must be restored
+// to the storage nodes separately. Files whose ID already exists are
+// skipped, so an interrupted import can be run again. A file that fails is
+// reported and the rest are still imported; a line that isn't valid JSON
+// stops the import with ErrInvalidCatalog.
+func (s *FileService) ImportCatalog(ctx context.Context, r io.Reader) (*CatalogImport, error) {
+	result := &CatalogImport{Failed: []CatalogFailed{}}
+
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		var entry CatalogEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return result, fmt.Errorf("%w: line %d: %v", ErrInvalidCatalog, line, err)
+		}
+
+		err := s.importCatalogEntry(&entry)
+		switch {
+		case errors.Is(err, ErrFileExists):
+			result.Skipped++
+		case err != nil:
+			result.Failed = append(result.Failed, CatalogFailed{Line: line, FileID: entry.File.FileID, Error: err.Error()})
+		default:
+			result.Imported++
+		}
+	}
+
+	log.Printf("Imported metadata of %d files (%d skipped, %d failed)", result.Imported, result.Skipped, len(result.Failed))
+	return result, nil
+}
+
+// importCatalogEntry recreates one file's metadata, taking a reference on
+// each of its chunks the way an upload does
+func (s *FileService) importCatalogEntry(entry *CatalogEntry) error {
+	file := entry.File
+	if file.FileID == "" || file.FileName == "" {
+		return fmt.Errorf("file_id and file_name are required")
+	}
+	if file.FileSize > 0 && len(entry.Chunks) == 0 {
+		return fmt.Errorf("non-empty file has no chunks")
+	}
+	for i, chunk := range entry.Chunks {
+		if chunk.Hash == "" {
+			return fmt.Errorf("chunk %d has no hash", i)
+		}
+		if chunk.Offset < 0 || chunk.Offset > file.FileSize || (i > 0 && chunk.Offset < entry.Chunks[i-1].Offset) {
+			return fmt.Errorf("chunk %d has an invalid offset", i)
+		}
+	}
+
+	if file.EncryptionAlgorithm != "" {
+		if _, err := crypto.ParseAlgorithm(file.EncryptionAlgorithm); err != nil {
+			return err
+		}
+	}
+	if _, err := hex.DecodeString(file.DedupSalt); err != nil {
+		return fmt.Errorf("invalid dedup_salt: %w", err)
+	}
+
+	if _, err := s.db.GetFile(file.FileID); err == nil {
+		return ErrFileExists
+	} else if !errors.Is(err, metadata.ErrFileNotFound) {
+		return err
+	}
+
+	replication := file.Replication
+	if replication < 1 {
+		replication = ReplicationCount
+	}
+
+	referenced := make([]string, 0, len(entry.Chunks))
+	release := func() {
+		// The chunk data was restored by someone else, so it stays even
+		// if no file references it now
+		s.storing.Lock()
+		defer s.storing.Unlock()
+		if _, err := s.db.ReleaseChunks(referenced); err != nil {
+			log.Printf("Failed to release chunks of file %s: %v", file.FileID, err)
+		}
+	}
+
+	for i, chunk := range entry.Chunks {
+		isNew, err := s.db.CreateChunk(chunk.Hash, chunk.StoredSize, chunk.StoragePath, max(chunk.Replication, 1), chunk.HashAlgorithm)
+		if err != nil {
+			release()
+			return fmt.Errorf("failed to record chunk %d: %w", i, err)
+		}
+		referenced = append(referenced, chunk.Hash)
+
+		if isNew && chunk.Coding != nil {
+			if err := s.db.SetChunkCoding(chunk.Hash, chunk.Coding); err != nil {
+				release()
+				return fmt.Errorf("failed to record coding of chunk %d: %w", i, err)
+			}
+		}
+		if len(chunk.Nodes) > 0 {
+			if err := s.db.AddChunkLocations(chunk.Hash, chunk.Nodes); err != nil {
+				release()
+				return fmt.Errorf("failed to record locations of chunk %d: %w", i, err)
+			}
+		}
+	}
+
+	record := &metadata.FileRecord{
+		FileID:           file.FileID,
+		FileName:         file.FileName,
+		FileSize:         file.FileSize,
+		Encrypted:        file.Encrypted,
+		ContentType:      file.ContentType,
+		Replication:      replication,
+		FileHash:         file.FileHash,
+		Salt:             file.Salt,
+		NoncePrefix:      file.NoncePrefix,
+		PasswordHash:     importedPasswordHash(entry.PasswordHash),
+		ClientEncrypted:  file.ClientEncrypted,
+		ClientEncryption: file.ClientEncryption,
+		SingleChunk:      file.SingleChunk,
+		Tags:             file.Tags,
+
+		EncryptionAlgorithm: file.EncryptionAlgorithm,
+		DedupSalt:           file.DedupSalt,
+		ExpiresAt:           file.ExpiresAt,
+	}
+	// Its version is numbered afresh among the files sharing its name
+	if err := s.db.CreateFile(record); err != nil {
+		release()
+		return fmt.Errorf("failed to save file metadata: %w", err)
+	}
+
+	for i, chunk := range entry.Chunks {
+		if err := s.db.LinkFileChunk(record.FileID, chunk.Hash, i, chunk.Offset); err != nil {
+			return fmt.Errorf("failed to link file chunks: %w", err)
+		}
+	}
+	return nil
+}
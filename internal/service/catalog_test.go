@@ -0,0 +1,174 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// exportedFiles returns the files a store lists, by ID, with the fields an
+// import sets afresh cleared
+func exportedFiles(t *testing.T, db MetadataStore) map[string]metadata.FileRecord {
+	t.Helper()
+
+	files, err := db.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]metadata.FileRecord, len(files))
+	for _, file := range files {
+		file.UploadedAt = time.Time{}
+		byID[file.FileID] = file
+	}
+	return byID
+}
+
+// TestCatalogRoundTrip exports files of every kind from one metadata store,
+// imports them into an empty one over the same chunk data, and checks the
+// files, their chunk references and their contents come back the same
+func TestCatalogRoundTrip(t *testing.T) {
+	source, sourceDB, chunks := newTestService(t)
+	shared := randomBytes(t, 3*chunking.MaxChunkSize)
+
+	type uploaded struct {
+		name     string
+		data     []byte
+		password string
+	}
+	uploads := map[string]uploaded{}
+	for _, u := range []struct {
+		name     string
+		data     []byte
+		meta     UploadMetadata
+		password string
+	}{
+		{"plain", randomBytes(t, 2*chunking.MaxChunkSize), UploadMetadata{FileName: "plain.bin", ContentType: "application/octet-stream"}, ""},
+		{"encrypted", randomBytes(t, 1000), UploadMetadata{FileName: "secret.bin"}, "s3cret"},
+		{"tagged", randomBytes(t, 100), UploadMetadata{FileName: "tagged.bin", Tags: map[string]string{"project": "apollo"}}, ""},
+		{"empty", nil, UploadMetadata{FileName: "empty.bin"}, ""},
+		// Two versions of one name, sharing their chunks
+		{"version 1", shared, UploadMetadata{FileName: "shared.bin"}, ""},
+		{"version 2", shared, UploadMetadata{FileName: "shared.bin"}, ""},
+	} {
+		u.meta.Password = u.password
+		result := upload(t, source, u.data, u.meta)
+		uploads[result.FileID] = uploaded{u.name, u.data, u.password}
+	}
+
+	var export bytes.Buffer
+	exported, err := source.ExportCatalog(context.Background(), &export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(export.String(), "\n"); exported != len(uploads) || lines != len(uploads) {
+		t.Fatalf("want %d files exported one per line, got %d on %d lines", len(uploads), exported, lines)
+	}
+
+	ring, err := node.NewConsistentHash(node.DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetDB := metadata.NewMemoryStore()
+	target := NewFileService(targetDB, chunks, node.NewRegistry(testHeartbeatTimeout), ring)
+	result, err := target.ImportCatalog(context.Background(), bytes.NewReader(export.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != len(uploads) || result.Skipped != 0 || len(result.Failed) != 0 {
+		t.Fatalf("want all %d files imported, got %+v", len(uploads), result)
+	}
+
+	if want, got := exportedFiles(t, sourceDB), exportedFiles(t, targetDB); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported files differ:\nwant %+v\n got %+v", want, got)
+	}
+	for fileID, u := range uploads {
+		if got := download(t, target, fileID, u.password); !bytes.Equal(got, u.data) {
+			t.Errorf("%s: imported file reads back different contents", u.name)
+		}
+		if u.password == "" {
+			continue
+		}
+		// The password hash came across, so a wrong password is refused
+		// before any chunk is read
+		if _, err := target.DownloadFile(context.Background(), fileID, "wrong"); !errors.Is(err, ErrIncorrectPassword) {
+			t.Errorf("%s: wrong password: want ErrIncorrectPassword, got %v", u.name, err)
+		}
+	}
+
+	var hashes []string
+	for _, file := range exportedFiles(t, sourceDB) {
+		fileHashes, err := sourceDB.GetFileChunks(file.FileID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, fileHashes...)
+	}
+	sort.Strings(hashes)
+	want, err := sourceDB.GetChunks(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := targetDB.GetChunks(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("imported chunk records, or their reference counts, differ")
+	}
+
+	// Running it again finds every file already there
+	result, err = target.ImportCatalog(context.Background(), bytes.NewReader(export.Bytes()))
+	if err != nil || result.Imported != 0 || result.Skipped != len(uploads) {
+		t.Errorf("second import: want every file skipped, got %+v (%v)", result, err)
+	}
+	if again, err := targetDB.GetChunks(hashes); err != nil || !reflect.DeepEqual(again, want) {
+		t.Errorf("second import changed chunk references: %v", err)
+	}
+}
+
+// TestCatalogImportErrors checks an entry that can't be imported is
+// reported while the rest are imported, and a line that isn't JSON stops the
+// import
+func TestCatalogImportErrors(t *testing.T) {
+	source, _, chunks := newTestService(t)
+	upload(t, source, randomBytes(t, 1000), UploadMetadata{FileName: "good.bin"})
+	var export bytes.Buffer
+	if _, err := source.ExportCatalog(context.Background(), &export); err != nil {
+		t.Fatal(err)
+	}
+
+	newTarget := func() *FileService {
+		ring, err := node.NewConsistentHash(node.DefaultVirtualNodesPerNode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewFileService(metadata.NewMemoryStore(), chunks, node.NewRegistry(testHeartbeatTimeout), ring)
+	}
+
+	catalog := strings.Join([]string{
+		`{"file": {"file_name": "no-id.bin"}, "chunks": []}`,
+		`{"file": {"file_id": "a0000000-0000-0000-0000-000000000001", "file_name": "chunkless.bin", "file_size": 10}, "chunks": []}`,
+		strings.TrimSuffix(export.String(), "\n"),
+	}, "\n")
+	result, err := newTarget().ImportCatalog(context.Background(), strings.NewReader(catalog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 1 || len(result.Failed) != 2 || result.Failed[0].Line != 1 || result.Failed[1].Line != 2 {
+		t.Errorf("want lines 1 and 2 failed and the exported file imported, got %+v", result)
+	}
+
+	result, err = newTarget().ImportCatalog(context.Background(), strings.NewReader(export.String()+"not json\n"))
+	if !errors.Is(err, ErrInvalidCatalog) || result.Imported != 1 {
+		t.Errorf("invalid line: want ErrInvalidCatalog after 1 file imported, got %+v (%v)", result, err)
+	}
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Where a chunk's holders came from
+const (
+	HoldersRecorded = "recorded" // Chunk locations recorded when it was stored
+	HoldersProbed   = "probed"   // Chunk listings of the healthy nodes, fetched just now
+	HoldersRing     = "ring"     // Nothing recorded; assumed to be where the ring places it
+	HoldersLocal    = "local"    // Stored on the coordinator, not on nodes
+)
+
+// ChunkPlacement compares where one chunk of a file should be with where it
+// is. For erasure-coded chunks the nodes hold its shards.
+type ChunkPlacement struct {
+	Index         int      `json:"index"`
+	Hash          string   `json:"hash"`
+	Offset        int64    `json:"offset"`
+	Size          int64    `json:"size"`
+	Coded         bool     `json:"coded,omitempty"`
+	ExpectedNodes []string `json:"expected_nodes"` // Where the ring places the chunk now
+	Holders       []string `json:"holders"`
+	HoldersSource string   `json:"holders_source"`       // One of the Holders* constants
+	Missing       []string `json:"missing,omitempty"`    // Expected nodes that don't hold it
+	Unexpected    []string `json:"unexpected,omitempty"` // Holders the ring doesn't place it on
+}
+
+// FileChunkMap lists a file's chunks with their expected and actual nodes,
+// for debugging a failed download
+type FileChunkMap struct {
+	FileID           string           `json:"file_id"`
+	FileName         string           `json:"file_name"`
+	Replication      int              `json:"replication"`
+	Probed           bool             `json:"probed"`
+	NodesUnreachable []string         `json:"nodes_unreachable,omitempty"` // Couldn't be probed; they may hold chunks too
+	Chunks           []ChunkPlacement `json:"chunks"`
+}
+
+// FileChunkMap returns each chunk of a file with the nodes the ring places
+// it on and the nodes holding it. Holders are the recorded locations or,
+// with probe set, what the healthy nodes report holding right now. Chunks
+// without recorded locations fall back to the ring's placement.
+func (s *FileService) FileChunkMap(ctx context.Context, fileID string, probe bool) (*FileChunkMap, error) {
+	file, chunks, err := s.db.GetFileWithChunks(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = chunk.ChunkHash
+	}
+	records, err := s.db.GetChunks(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunks: %w", err)
+	}
+
+	report := &FileChunkMap{
+		FileID:      file.FileID,
+		FileName:    file.FileName,
+		Replication: file.Replication,
+		Probed:      probe,
+		Chunks:      make([]ChunkPlacement, len(chunks)),
+	}
+
+	held := make(map[string][]string) // hash -> node IDs, when probing
+	if probe {
+		for _, nodeInfo := range s.registry.GetHealthyNodes() {
+			nodeHashes, err := s.NodeChunks(ctx, nodeInfo)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				log.Printf("Chunk map: failed to list chunks on node %s: %v", nodeInfo.NodeID, err)
+				report.NodesUnreachable = append(report.NodesUnreachable, nodeInfo.NodeID)
+				continue
+			}
+			for _, hash := range nodeHashes {
+				held[hash] = append(held[hash], nodeInfo.NodeID)
+			}
+		}
+		sort.Strings(report.NodesUnreachable)
+	}
+
+	for i, chunk := range chunks {
+		placement := ChunkPlacement{
+			Index:  i,
+			Hash:   chunk.ChunkHash,
+			Offset: chunk.Offset,
+			Size:   chunk.Size,
+		}
+
+		record := records[chunk.ChunkHash]
+		if record == nil {
+			return nil, fmt.Errorf("chunk %d (%s) has no metadata", i, chunk.ChunkHash[:8])
+		}
+		coding, err := s.db.GetChunkCoding(chunk.ChunkHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up coding of chunk %d: %w", i, err)
+		}
+
+		if !strings.HasPrefix(record.StoragePath, "distributed:") && coding == nil {
+			placement.ExpectedNodes = []string{}
+			placement.Holders = []string{}
+			placement.HoldersSource = HoldersLocal
+			report.Chunks[i] = placement
+			continue
+		}
+
+		count := max(record.Replication, 1)
+		if coding != nil {
+			placement.Coded = true
+			count = coding.DataShards + coding.ParityShards
+		}
+		placement.ExpectedNodes, err = s.ring.GetNodes(chunk.ChunkHash, count)
+		if err != nil {
+			placement.ExpectedNodes = []string{}
+		}
+
+		switch {
+		case probe && coding != nil:
+			placement.HoldersSource = HoldersProbed
+			for _, shard := range coding.Shards {
+				placement.Holders = append(placement.Holders, held[shard.ShardHash]...)
+			}
+		case probe:
+			placement.HoldersSource = HoldersProbed
+			placement.Holders = held[chunk.ChunkHash]
+		case len(chunk.Nodes) > 0:
+			placement.HoldersSource = HoldersRecorded
+			placement.Holders = chunk.Nodes
+		default:
+			placement.HoldersSource = HoldersRing
+			placement.Holders = placement.ExpectedNodes
+		}
+		placement.Holders = uniqueSorted(placement.Holders)
+
+		holding := make(map[string]bool, len(placement.Holders))
+		for _, nodeID := range placement.Holders {
+			holding[nodeID] = true
+		}
+		expected := make(map[string]bool, len(placement.ExpectedNodes))
+		for _, nodeID := range placement.ExpectedNodes {
+			expected[nodeID] = true
+			if !holding[nodeID] {
+				placement.Missing = append(placement.Missing, nodeID)
+			}
+		}
+		for _, nodeID := range placement.Holders {
+			if !expected[nodeID] {
+				placement.Unexpected = append(placement.Unexpected, nodeID)
+			}
+		}
+
+		report.Chunks[i] = placement
+	}
+
+	return report, nil
+}
+
+// uniqueSorted returns the distinct values of ids in order, never nil
+func uniqueSorted(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	result := []string{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
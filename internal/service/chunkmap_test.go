@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+func fileChunkMap(t *testing.T, s *FileService, fileID string, probe bool) *FileChunkMap {
+	t.Helper()
+
+	report, err := s.FileChunkMap(context.Background(), fileID, probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// checkChunkLayout checks a chunk map lists the file's chunks in order,
+// covering it end to end, each expected on the nodes the ring places it on
+func checkChunkLayout(t *testing.T, s *FileService, report *FileChunkMap, result *UploadResult, replication int) {
+	t.Helper()
+
+	if report.FileID != result.FileID || report.Replication != replication || len(report.Chunks) != len(result.ChunkHashes) {
+		t.Fatalf("want %d chunks of %s at replication %d, got %+v", len(result.ChunkHashes), result.FileID, replication, report)
+	}
+
+	var offset int64
+	for i, chunk := range report.Chunks {
+		if chunk.Index != i || chunk.Hash != result.ChunkHashes[i] || chunk.Offset != offset || chunk.Size <= 0 {
+			t.Errorf("chunk %d: want %s at offset %d, got %+v", i, result.ChunkHashes[i][:8], offset, chunk)
+		}
+		offset += chunk.Size
+
+		expected, err := s.ring.GetNodes(chunk.Hash, replication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(chunk.ExpectedNodes, expected) {
+			t.Errorf("chunk %d: want expected nodes %v, got %v", i, expected, chunk.ExpectedNodes)
+		}
+	}
+	if offset != result.Size {
+		t.Errorf("chunks cover %d bytes of %d", offset, result.Size)
+	}
+}
+
+// TestFileChunkMap maps a file replicated on 2 of 4 nodes, and checks the
+// recorded holders match where the ring placed each chunk, until a copy is
+// deleted, which only probing the nodes finds
+func TestFileChunkMap(t *testing.T) {
+	s, db, nodes := newTestCluster(t, 4)
+	result := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{Replication: 2})
+
+	locations, err := db.GetChunkLocations(result.ChunkHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, probe := range []bool{false, true} {
+		report := fileChunkMap(t, s, result.FileID, probe)
+		checkChunkLayout(t, s, report, result, 2)
+
+		source := HoldersRecorded
+		if probe {
+			source = HoldersProbed
+		}
+		for i, chunk := range report.Chunks {
+			if chunk.HoldersSource != source || !reflect.DeepEqual(chunk.Holders, locations[chunk.Hash]) {
+				t.Errorf("probe %v, chunk %d: want %s holders %v, got %s %v", probe, i, source, locations[chunk.Hash], chunk.HoldersSource, chunk.Holders)
+			}
+			if len(chunk.Missing) != 0 || len(chunk.Unexpected) != 0 {
+				t.Errorf("probe %v, chunk %d: want it where expected, got missing %v and unexpected %v", probe, i, chunk.Missing, chunk.Unexpected)
+			}
+		}
+	}
+
+	// Lose one copy of the first chunk
+	hash := result.ChunkHashes[0]
+	lost := locations[hash][0]
+	for _, sn := range nodes {
+		if sn.NodeID == lost {
+			if err := node.NewNodeClient(http.DefaultClient, "http://"+sn.Address).Delete(context.Background(), hash); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if chunk := fileChunkMap(t, s, result.FileID, false).Chunks[0]; len(chunk.Missing) != 0 {
+		t.Errorf("recorded locations can't know of the loss, got missing %v", chunk.Missing)
+	}
+	report := fileChunkMap(t, s, result.FileID, true)
+	if chunk := report.Chunks[0]; fmt.Sprint(chunk.Missing) != fmt.Sprintf("[%s]", lost) || len(chunk.Holders) != 1 {
+		t.Errorf("probing: want %s missing, got holders %v and missing %v", lost, chunk.Holders, chunk.Missing)
+	}
+	if len(report.NodesUnreachable) != 0 {
+		t.Errorf("want every node probed, got %v unreachable", report.NodesUnreachable)
+	}
+}
+
+// unlocatedStore is a metadata store with no chunk locations recorded, as
+// for chunks stored before locations were
+type unlocatedStore struct {
+	*metadata.MemoryStore
+}
+
+func (s unlocatedStore) GetFileWithChunks(fileID string) (*metadata.FileRecord, []metadata.ChunkDescriptor, error) {
+	file, chunks, err := s.MemoryStore.GetFileWithChunks(fileID)
+	for i := range chunks {
+		chunks[i].Nodes = nil
+	}
+	return file, chunks, err
+}
+
+// TestFileChunkMapRingFallback checks chunks without recorded locations are
+// assumed to be where the ring places them
+func TestFileChunkMapRingFallback(t *testing.T) {
+	s, _ := newTestServiceWith(t, unlocatedStore{metadata.NewMemoryStore()})
+	for i := 1; i <= 3; i++ {
+		startTestNode(t, s, fmt.Sprintf("node-%d", i), nil)
+	}
+	result := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{Replication: 2})
+
+	report := fileChunkMap(t, s, result.FileID, false)
+	checkChunkLayout(t, s, report, result, 2)
+	for i, chunk := range report.Chunks {
+		if chunk.HoldersSource != HoldersRing || len(chunk.Holders) != 2 || len(chunk.Missing) != 0 {
+			t.Errorf("chunk %d: want the ring's 2 nodes as holders, got %+v", i, chunk)
+		}
+	}
+}
+
+// TestFileChunkMapLocal checks chunks stored on the coordinator are listed
+// with no nodes
+func TestFileChunkMapLocal(t *testing.T) {
+	s, _, _ := newTestService(t)
+	result := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{})
+
+	for i, chunk := range fileChunkMap(t, s, result.FileID, true).Chunks {
+		if chunk.HoldersSource != HoldersLocal || len(chunk.ExpectedNodes) != 0 || len(chunk.Holders) != 0 {
+			t.Errorf("chunk %d: want it local, got %+v", i, chunk)
+		}
+	}
+
+	if _, err := s.FileChunkMap(context.Background(), "missing", false); !errors.Is(err, metadata.ErrFileNotFound) {
+		t.Errorf("unknown file: want ErrFileNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// clusterBackend stores chunks on the storage nodes, replicated or
+// erasure-coded, using consistent hashing to pick nodes
+type clusterBackend struct {
+	s *FileService
+}
+
+// ClusterBackend returns the backend that stores chunks on the storage nodes
+func (s *FileService) ClusterBackend() ChunkBackend {
+	return &clusterBackend{s: s}
+}
+
+func (b *clusterBackend) Name() string {
+	return "cluster"
+}
+
+func (b *clusterBackend) Store(ctx context.Context, hash string, data []byte, replicas int) (*Placement, error) {
+	placements := b.StoreBatch(ctx, []*chunking.Chunk{{Hash: hash, Data: data, Size: len(data)}}, replicas)
+	if placement := placements[hash]; placement != nil {
+		return placement, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("chunk could not be placed on any node")
+}
+
+// StoreBatch erasure-codes chunks when enabled, and replicates the rest in
+// per-node batches. Only chunks at the default replication are erasure-coded;
+// a file asking for another factor gets exactly that many full replicas.
+func (b *clusterBackend) StoreBatch(ctx context.Context, chunks []*chunking.Chunk, replicas int) map[string]*Placement {
+	placements := make(map[string]*Placement)
+
+	healthyNodes := b.s.registry.GetHealthyNodes()
+	if len(healthyNodes) == 0 {
+		return placements
+	}
+	log.Printf("Distributing chunks across %d nodes", len(healthyNodes))
+
+	// Chunks that cannot be coded (too few nodes, or a shard failed to
+	// store) are replicated as usual
+	pending := chunks
+	if b.s.encoder != nil && replicas == ReplicationCount {
+		var uncoded []*chunking.Chunk
+		codings := b.s.distributeShards(ctx, pending)
+		for _, chunk := range pending {
+			coding := codings[chunk.Hash]
+			if coding == nil {
+				uncoded = append(uncoded, chunk)
+				continue
+			}
+
+			placement := &Placement{StoragePath: erasureStoragePath(coding), Coding: coding}
+			for _, shard := range coding.Shards {
+				placement.Nodes = append(placement.Nodes, shard.NodeID)
+			}
+			placements[chunk.Hash] = placement
+		}
+		pending = uncoded
+	}
+
+	if len(pending) > 0 {
+		// In async mode only the quorum's worth of replicas is sent now;
+		// storeChunks queues the rest
+		sent := replicas
+		if b.s.replicationMode == ReplicationAsync {
+			sent = b.s.writeQuorumFor(replicas)
+		}
+		acked, targets := b.s.distributeChunksInBatches(ctx, pending, sent)
+
+		// Chunks short of the write quorum try the rest of the ring
+		if b.s.writeQuorum > 0 || sent < replicas {
+			quorum := b.s.writeQuorumFor(replicas)
+			for _, chunk := range pending {
+				if len(acked[chunk.Hash]) < quorum && ctx.Err() == nil {
+					acked[chunk.Hash] = b.s.storeOnSpareNodes(ctx, chunk, acked[chunk.Hash], targets[chunk.Hash], quorum)
+				}
+			}
+		}
+
+		for hash, storedOn := range acked {
+			if len(storedOn) == 0 {
+				continue
+			}
+			placements[hash] = &Placement{
+				StoragePath: fmt.Sprintf("distributed:%s", storedOn[0]),
+				Nodes:       storedOn,
+			}
+		}
+	}
+
+	return placements
+}
+
+// Get fetches a chunk from the nodes. Replicas or shards found missing are
+// re-stored in the background so reads heal the cluster without adding
+// latency.
+func (b *clusterBackend) Get(ctx context.Context, hash string) ([]byte, error) {
+	coding, err := b.s.db.GetChunkCoding(hash)
+	if err == nil && coding != nil {
+		chunkData, missing, err := b.s.retrieveCodedChunk(ctx, hash, coding)
+		if err != nil {
+			return nil, err
+		}
+		if len(missing) > 0 {
+			go b.s.repairShards(hash, chunkData, coding, missing)
+		}
+		return chunkData, nil
+	}
+
+	chunkData, missing, err := b.s.retrieveChunkFromNodes(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		go b.s.readRepair(hash, chunkData, missing)
+	}
+
+	return chunkData, nil
+}
+
+// Open streams a replicated chunk from the first replica that serves it;
+// see openChunkFromNodes. Erasure-coded chunks are read whole, as are all
+// chunks while the chunk cache is on, since it keeps them whole anyway.
+func (b *clusterBackend) Open(ctx context.Context, hash string) (io.ReadCloser, int64, error) {
+	coding, err := b.s.db.GetChunkCoding(hash)
+	if b.s.cache != nil || (err == nil && coding != nil) {
+		chunkData, err := b.Get(ctx, hash)
+		if err != nil {
+			return nil, 0, err
+		}
+		return io.NopCloser(bytes.NewReader(chunkData)), int64(len(chunkData)), nil
+	}
+
+	return b.s.openChunkFromNodes(ctx, hash)
+}
+
+// Exists reports whether any of the chunk's replica nodes holds it. An
+// erasure-coded chunk exists if its shards were recorded.
+func (b *clusterBackend) Exists(hash string) (bool, error) {
+	coding, err := b.s.db.GetChunkCoding(hash)
+	if err == nil && coding != nil {
+		return true, nil
+	}
+
+	targetNodes, err := b.s.replicaNodes(hash)
+	if err != nil {
+		return false, err
+	}
+
+	for _, nodeID := range targetNodes {
+		nodeInfo, err := b.s.registry.GetNode(nodeID)
+		if err != nil {
+			continue
+		}
+
+		exists, err := b.s.nodeHasChunk(context.Background(), nodeInfo, hash)
+		if err == nil && exists {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// nodeHasChunk asks a node whether it holds a chunk, over gRPC when the
+// node serves it
+func (s *FileService) nodeHasChunk(ctx context.Context, nodeInfo *node.NodeInfo, hash string) (bool, error) {
+	if nodeInfo.Supports(node.TransportGRPC) {
+		return s.grpcChunkExists(nodeInfo, hash)
+	}
+	return s.nodeClient(nodeInfo).Exists(ctx, hash)
+}
+
+// Delete removes a chunk (or a shard, which nodes store like a chunk) from
+// every healthy node. Replicas may have moved since upload, and read-repair
+// can copy locally stored chunks to nodes, so every node is asked.
+func (b *clusterBackend) Delete(hash string) error {
+	var failed int
+	for _, nodeInfo := range b.s.registry.GetHealthyNodes() {
+		if err := b.s.deleteChunkFromNode(hash, nodeInfo); err != nil {
+			log.Printf("Failed to delete chunk %s from node %s: %v", hash[:8], nodeInfo.NodeID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete from %d nodes", failed)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// ErrHashCollision is returned when an uploaded chunk's hash is already
+// stored for different bytes
+var ErrHashCollision = errors.New("chunk hash collision")
+
+// UseVerifyDedup makes uploads compare each chunk whose hash is already
+// stored against the stored bytes before referencing it, failing with
+// ErrHashCollision if they differ, rather than trusting that equal hashes
+// mean equal data. It costs a read of every deduplicated chunk. Chunks of
+// direct uploads never reach the coordinator and are not compared.
+func (s *FileService) UseVerifyDedup(verify bool) {
+	s.verifyDedup = verify
+}
+
+// verifyDuplicate checks that an uploaded chunk whose hash is already stored
+// holds the same bytes as the stored chunk
+func (s *FileService) verifyDuplicate(ctx context.Context, chunk *chunking.Chunk) error {
+	stored, err := s.RetrieveChunk(ctx, chunk.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to read stored chunk %s to compare: %w", chunk.Hash[:8], err)
+	}
+	if !bytes.Equal(stored, chunk.Data) {
+		log.Printf("HASH COLLISION: uploaded chunk %s (%d bytes) differs from the stored chunk (%d bytes)", chunk.Hash, len(chunk.Data), len(stored))
+		return fmt.Errorf("%w: chunk %s differs from the stored chunk with the same hash", ErrHashCollision, chunk.Hash[:8])
+	}
+	return nil
+}
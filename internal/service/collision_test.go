@@ -0,0 +1,85 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+)
+
+// forgeCollision replaces the stored bytes of a chunk with other bytes, as
+// if those bytes had hashed to the same hash when they were stored
+func forgeCollision(t *testing.T, chunks *dedup.MemoryChunkStore, hash string, other []byte) {
+	t.Helper()
+
+	if err := chunks.DeleteChunk(hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := chunks.StoreChunk(hash, other); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestVerifyDedupCollision forces a collision on the last chunk of a file
+// and checks uploading the file again fails with ErrHashCollision while
+// dedup is verified, without referencing any of its chunks, where otherwise
+// the upload would silently take the stored bytes
+func TestVerifyDedupCollision(t *testing.T) {
+	s, db, chunks := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	stored := upload(t, s, data, UploadMetadata{})
+	last := stored.ChunkHashes[len(stored.ChunkHashes)-1]
+	forgeCollision(t, chunks, last, randomBytes(t, 1000))
+
+	// With the first copy in the trash, the upload isn't matched as a
+	// whole file, so each of its chunks is looked up
+	if err := s.DeleteFile(stored.FileID); err != nil {
+		t.Fatal(err)
+	}
+
+	refCounts := func() []int {
+		t.Helper()
+		counts := make([]int, len(stored.ChunkHashes))
+		for i, hash := range stored.ChunkHashes {
+			record, err := db.GetChunk(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts[i] = record.RefCount
+		}
+		return counts
+	}
+	before := refCounts()
+
+	s.UseVerifyDedup(true)
+	_, err := s.UploadFile(context.Background(), bytes.NewReader(data), UploadMetadata{FileName: "again.bin", Size: int64(len(data))})
+	if !errors.Is(err, ErrHashCollision) {
+		t.Fatalf("want ErrHashCollision, got %v", err)
+	}
+	if after := refCounts(); !slices.Equal(after, before) {
+		t.Errorf("want reference counts left at %v, got %v", before, after)
+	}
+	if files, err := db.ListFiles(); err != nil || len(files) != 0 {
+		t.Errorf("want no file saved, got %d (%v)", len(files), err)
+	}
+
+	// Chunks that really are the same pass the comparison
+	other := randomBytes(t, 1000)
+	upload(t, s, other, UploadMetadata{})
+	if again := upload(t, s, other, UploadMetadata{}); again.ChunksStored != 0 {
+		t.Errorf("want the identical upload deduplicated, got %+v", again)
+	}
+
+	// Unverified, the upload takes the colliding bytes without noticing
+	s.UseVerifyDedup(false)
+	if unverified := upload(t, s, data, UploadMetadata{}); unverified.ChunksStored != 0 {
+		t.Errorf("unverified: want every chunk deduplicated, got %+v", unverified)
+	}
+	if after := refCounts(); after[len(after)-1] != before[len(before)-1]+1 {
+		t.Errorf("unverified: want the colliding chunk referenced once more, got %v after %v", after, before)
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// ErrNotReplicated is returned when checking the replicas of a chunk that
+// is erasure-coded or stored on the coordinator rather than copied whole
+// to nodes
+var ErrNotReplicated = errors.New("chunk is not replicated on storage nodes")
+
+// What checking one replica of a chunk found
+const (
+	ReplicaConsistent  = "consistent"  // Its bytes hash to the chunk's hash
+	ReplicaDivergent   = "divergent"   // It holds different bytes
+	ReplicaMissing     = "missing"     // The node doesn't hold it
+	ReplicaUnreadable  = "unreadable"  // The node failed to serve it
+	ReplicaUnreachable = "unreachable" // The node is down or not registered
+)
+
+// ReplicaState is one node's copy of a chunk as a consistency check found it
+type ReplicaState struct {
+	NodeID   string `json:"node_id"`
+	Status   string `json:"status"`           // One of the constants above
+	Size     int    `json:"size,omitempty"`   // Bytes the node returned
+	Digest   string `json:"digest,omitempty"` // Hash of those bytes, under the chunk's algorithm
+	Repaired bool   `json:"repaired,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ReplicaCheck is the result of comparing every replica of a chunk
+type ReplicaCheck struct {
+	Hash        string         `json:"hash"`
+	Replication int            `json:"replication"`
+	Consistent  bool           `json:"consistent"` // Every healthy node's replica matches, or was repaired to
+	Divergent   int            `json:"divergent"`
+	Missing     int            `json:"missing"`
+	Unreadable  int            `json:"unreadable"`
+	Repaired    int            `json:"repaired"`
+	Replicas    []ReplicaState `json:"replicas"`
+	Error       string         `json:"error,omitempty"` // Why a repair couldn't be made
+}
+
+// CheckReplicas reads a replicated chunk from each node it is recorded on
+// or placed on, and compares what each returns against the chunk's hash,
+// so replicas that have drifted apart are found even if reads keep landing
+// on a good one. With repair set, divergent, missing and unreadable
+// replicas on healthy nodes are overwritten from a consistent one.
+func (s *FileService) CheckReplicas(ctx context.Context, chunkHash string, repair bool) (*ReplicaCheck, error) {
+	record, err := s.db.GetChunk(chunkHash)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(record.StoragePath, "distributed:") {
+		return nil, ErrNotReplicated
+	}
+	coding, err := s.db.GetChunkCoding(chunkHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunk coding: %w", err)
+	}
+	if coding != nil {
+		return nil, ErrNotReplicated
+	}
+
+	algorithm, err := chunking.ParseHashAlgorithm(record.Algorithm())
+	if err != nil {
+		return nil, err
+	}
+
+	locations, err := s.db.GetChunkLocations([]string{chunkHash})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunk locations: %w", err)
+	}
+	// An empty ring places it nowhere; recorded locations are still checked
+	placed, _ := s.replicaNodes(chunkHash)
+
+	healthy := make(map[string]bool)
+	for _, nodeInfo := range s.registry.GetHealthyNodes() {
+		healthy[nodeInfo.NodeID] = true
+	}
+
+	check := &ReplicaCheck{
+		Hash:        chunkHash,
+		Replication: max(record.Replication, 1),
+		Replicas:    []ReplicaState{},
+	}
+
+	var good []byte // A consistent copy to repair from
+	for _, nodeID := range uniqueSorted(append(append([]string{}, locations[chunkHash]...), placed...)) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		replica := ReplicaState{NodeID: nodeID}
+		if !healthy[nodeID] {
+			replica.Status = ReplicaUnreachable
+			replica.Error = "node is not healthy"
+			check.Replicas = append(check.Replicas, replica)
+			continue
+		}
+
+		chunkData, err := s.backgroundRead(ctx, chunkHash, nodeID)
+		switch {
+		case err != nil:
+			replica.Status = s.unreadableReplica(ctx, chunkHash, nodeID)
+			replica.Error = err.Error()
+		case algorithm.Sum(chunkData) == chunkHash:
+			replica.Status = ReplicaConsistent
+			replica.Size = len(chunkData)
+			replica.Digest = chunkHash
+			if good == nil {
+				good = chunkData
+			}
+		default:
+			replica.Status = ReplicaDivergent
+			replica.Size = len(chunkData)
+			replica.Digest = algorithm.Sum(chunkData)
+		}
+
+		switch replica.Status {
+		case ReplicaDivergent:
+			check.Divergent++
+			log.Printf("Replica check: node %s holds divergent data for chunk %s", nodeID, chunkHash[:8])
+		case ReplicaMissing:
+			check.Missing++
+		case ReplicaUnreadable:
+			check.Unreadable++
+		}
+		check.Replicas = append(check.Replicas, replica)
+	}
+
+	bad := check.Divergent + check.Missing + check.Unreadable
+	if repair && bad > 0 {
+		if good == nil {
+			check.Error = "no consistent replica to repair from"
+		} else {
+			s.repairReplicaStates(ctx, check, good)
+		}
+	}
+
+	check.Consistent = bad == check.Repaired
+	return check, nil
+}
+
+// unreadableReplica tells a node that doesn't hold a chunk apart from one
+// that failed to serve it
+func (s *FileService) unreadableReplica(ctx context.Context, chunkHash, nodeID string) string {
+	nodeInfo, err := s.registry.GetNode(nodeID)
+	if err != nil {
+		return ReplicaUnreachable
+	}
+	if held, err := s.nodeHasChunk(ctx, nodeInfo, chunkHash); err == nil && !held {
+		return ReplicaMissing
+	}
+	return ReplicaUnreadable
+}
+
+// repairReplicaStates overwrites the divergent, missing and unreadable
+// replicas of a check with a consistent copy
+func (s *FileService) repairReplicaStates(ctx context.Context, check *ReplicaCheck, good []byte) {
+	for i := range check.Replicas {
+		replica := &check.Replicas[i]
+		if replica.Status == ReplicaConsistent || replica.Status == ReplicaUnreachable {
+			continue
+		}
+
+		if err := s.backgroundStore(ctx, check.Hash, good, replica.NodeID); err != nil {
+			log.Printf("Replica check: failed to repair chunk %s on node %s: %v", check.Hash[:8], replica.NodeID, err)
+			replica.Error = fmt.Sprintf("repair failed: %v", err)
+			continue
+		}
+
+		replica.Repaired = true
+		check.Repaired++
+		s.recordLocation(check.Hash, replica.NodeID)
+		log.Printf("Replica check: repaired %s replica of chunk %s on node %s", replica.Status, check.Hash[:8], replica.NodeID)
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// overwriteReplica replaces the bytes of a node's copy of a chunk on disk,
+// as a buggy store might, without the node noticing
+func overwriteReplica(t *testing.T, sn *node.StorageNode, chunkHash string, data []byte) {
+	t.Helper()
+
+	found := false
+	err := filepath.WalkDir(sn.StoragePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.Name() != chunkHash {
+			return err
+		}
+		found = true
+		return os.WriteFile(path, data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatalf("node %s holds no file for chunk %s", sn.NodeID, chunkHash[:8])
+	}
+}
+
+// replicaStatuses returns each checked node's replica status
+func replicaStatuses(check *ReplicaCheck) map[string]string {
+	statuses := make(map[string]string, len(check.Replicas))
+	for _, replica := range check.Replicas {
+		statuses[replica.NodeID] = replica.Status
+	}
+	return statuses
+}
+
+// TestCheckReplicas gives a chunk one divergent and one missing replica and
+// checks both are found, and repaired from the good one when asked
+func TestCheckReplicas(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 4)
+	ctx := context.Background()
+	data := randomBytes(t, 1000)
+	hash := upload(t, s, data, UploadMetadata{Replication: 3}).ChunkHashes[0]
+
+	var holders []*node.StorageNode
+	for _, sn := range nodes {
+		if nodeHolds(t, sn, hash) {
+			holders = append(holders, sn)
+		}
+	}
+	if len(holders) != 3 {
+		t.Fatalf("want the chunk on 3 nodes, found it on %d", len(holders))
+	}
+	divergent, missing, good := holders[0], holders[1], holders[2]
+
+	corrupted := bytes.Clone(data)
+	corrupted[len(corrupted)/2] ^= 0xff
+	overwriteReplica(t, divergent, hash, corrupted)
+	if err := node.NewNodeClient(http.DefaultClient, "http://"+missing.Address).Delete(ctx, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	check, err := s.CheckReplicas(ctx, hash, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{divergent.NodeID: ReplicaDivergent, missing.NodeID: ReplicaMissing, good.NodeID: ReplicaConsistent}
+	if got := replicaStatuses(check); len(got) != len(want) || got[divergent.NodeID] != want[divergent.NodeID] ||
+		got[missing.NodeID] != want[missing.NodeID] || got[good.NodeID] != want[good.NodeID] {
+		t.Errorf("want replicas %v, got %v", want, got)
+	}
+	if check.Consistent || check.Divergent != 1 || check.Missing != 1 || check.Repaired != 0 || check.Replication != 3 {
+		t.Errorf("want 1 divergent and 1 missing replica, none repaired, got %+v", check)
+	}
+	for _, replica := range check.Replicas {
+		if replica.NodeID == divergent.NodeID && (replica.Digest != chunking.SHA256.Sum(corrupted) || replica.Size != len(data)) {
+			t.Errorf("want the divergent replica's digest and size reported, got %+v", replica)
+		}
+	}
+	if nodeHolds(t, missing, hash) {
+		t.Error("missing replica restored without repair")
+	}
+
+	check, err = s.CheckReplicas(ctx, hash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Consistent || check.Repaired != 2 {
+		t.Errorf("want both bad replicas repaired, got %+v", check)
+	}
+	for _, sn := range holders {
+		got, err := node.NewNodeClient(http.DefaultClient, "http://"+sn.Address).Retrieve(ctx, hash)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("node %s: want the chunk's bytes after repair (%v)", sn.NodeID, err)
+		}
+	}
+
+	// A node that is down is reported, and left alone by repairs
+	takeOffline(t, s, good.NodeID)
+	check, err = s.CheckReplicas(ctx, hash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := replicaStatuses(check); got[good.NodeID] != ReplicaUnreachable || got[divergent.NodeID] != ReplicaConsistent || got[missing.NodeID] != ReplicaConsistent {
+		t.Errorf("want %s unreachable and the others consistent, got %v", good.NodeID, got)
+	}
+	if !check.Consistent || check.Repaired != 0 {
+		t.Errorf("want nothing to repair, got %+v", check)
+	}
+}
+
+// TestCheckReplicasWithoutGoodCopy checks a chunk with no replica matching
+// its hash is reported inconsistent, and left as it is by a repair
+func TestCheckReplicasWithoutGoodCopy(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 2)
+	data := randomBytes(t, 1000)
+	hash := upload(t, s, data, UploadMetadata{Replication: 2}).ChunkHashes[0]
+	for _, sn := range nodes {
+		overwriteReplica(t, sn, hash, []byte("not the chunk"))
+	}
+
+	check, err := s.CheckReplicas(context.Background(), hash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Consistent || check.Divergent != 2 || check.Repaired != 0 || check.Error == "" {
+		t.Errorf("want 2 divergent replicas and no repair, got %+v", check)
+	}
+}
+
+func TestCheckReplicasErrors(t *testing.T) {
+	s, _, _ := newTestService(t)
+	local := upload(t, s, randomBytes(t, 1000), UploadMetadata{}).ChunkHashes[0]
+
+	if _, err := s.CheckReplicas(context.Background(), local, false); !errors.Is(err, ErrNotReplicated) {
+		t.Errorf("chunk stored on the coordinator: want ErrNotReplicated, got %v", err)
+	}
+	if _, err := s.CheckReplicas(context.Background(), chunking.SHA256.Sum([]byte("unknown")), false); !errors.Is(err, metadata.ErrChunkNotFound) {
+		t.Errorf("unknown chunk: want ErrChunkNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,513 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// DirectUploadTTL is how long a client has to send a planned direct
+// upload's chunks to the nodes and complete it
+const DirectUploadTTL = time.Hour
+
+var (
+	ErrDirectUploadNotFound = errors.New("direct upload not found or expired")
+	ErrDirectUploadBusy     = errors.New("direct upload is already being completed")
+	ErrInvalidDirectUpload  = errors.New("invalid direct upload")
+	ErrNoDirectNodes        = errors.New("no healthy storage node with room accepts direct uploads")
+	ErrChunksNotUploaded    = errors.New("chunks not stored on any node")
+)
+
+// DirectChunk is one chunk of a file a client chunked itself
+type DirectChunk struct {
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+}
+
+// DirectUploadRequest describes a file whose chunks the client sends to the
+// storage nodes itself, so their data never passes through the coordinator.
+// Only plaintext or client-encrypted files can be uploaded this way, since
+// server-side encryption needs the data.
+type DirectUploadRequest struct {
+	FileName    string            `json:"file_name"`
+	ContentType string            `json:"content_type,omitempty"`
+	Replication int               `json:"replication,omitempty"` // ReplicationCount if zero
+	Tags        map[string]string `json:"tags,omitempty"`
+
+	// HashAlgorithm is what the chunks were hashed with, sha256 if empty
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+
+	ClientEncrypted  bool   `json:"client_encrypted,omitempty"`
+	ClientEncryption string `json:"client_encryption,omitempty"`
+
+	Chunks []DirectChunk `json:"chunks"` // In file order
+
+	// Owner names the client planning the upload; no other may complete it
+	Owner string `json:"-"`
+}
+
+// ChunkTarget is a node a client sends one chunk to
+type ChunkTarget struct {
+	NodeID string `json:"node_id"`
+	URL    string `json:"url"`             // PUT the chunk's raw bytes here
+	Grant  string `json:"grant,omitempty"` // Sent in the X-Chunk-Grant header; empty without a cluster secret
+}
+
+// PlannedChunk says where a client sends one chunk of a direct upload
+type PlannedChunk struct {
+	Hash    string        `json:"hash"`
+	Size    int           `json:"size"`
+	Stored  bool          `json:"stored"`            // Already stored, so there is nothing to send
+	Targets []ChunkTarget `json:"targets,omitempty"` // Every node to send it to
+}
+
+// DirectUploadPlan is the coordinator's answer to a DirectUploadRequest.
+// The client PUTs each chunk to its targets, then completes the upload
+// before ExpiresAt.
+type DirectUploadPlan struct {
+	UploadID    string         `json:"upload_id"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+	Replication int            `json:"replication"`
+	Chunks      []PlannedChunk `json:"chunks"` // One per distinct hash, in order of first appearance
+}
+
+// directUpload is a planned direct upload awaiting completion
+type directUpload struct {
+	request    DirectUploadRequest
+	plan       *DirectUploadPlan
+	algorithm  chunking.HashAlgorithm
+	completing bool
+}
+
+// directUploads holds the direct uploads planned but not yet completed.
+// Their chunks may be on the nodes without being recorded, so they are
+// kept from being deleted as orphans until the plan expires.
+type directUploads struct {
+	mu      sync.Mutex
+	uploads map[string]*directUpload
+}
+
+func (d *directUploads) add(upload *directUpload) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pruneLocked(time.Now())
+	if d.uploads == nil {
+		d.uploads = make(map[string]*directUpload)
+	}
+	d.uploads[upload.plan.UploadID] = upload
+}
+
+// begin marks an upload as being completed by owner
+func (d *directUploads) begin(uploadID, owner string) (*directUpload, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pruneLocked(time.Now())
+	upload := d.uploads[uploadID]
+	if upload == nil || upload.request.Owner != owner {
+		return nil, ErrDirectUploadNotFound
+	}
+	if upload.completing {
+		return nil, ErrDirectUploadBusy
+	}
+	upload.completing = true
+	return upload, nil
+}
+
+// end forgets an upload once completed, or lets it be completed again
+// after a failure, such as chunks the client hadn't sent yet
+func (d *directUploads) end(uploadID string, completed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if completed {
+		delete(d.uploads, uploadID)
+	} else if upload := d.uploads[uploadID]; upload != nil {
+		upload.completing = false
+	}
+}
+
+// addHashes adds the chunk hashes of every unexpired upload to known
+func (d *directUploads) addHashes(known map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pruneLocked(time.Now())
+	for _, upload := range d.uploads {
+		for _, planned := range upload.plan.Chunks {
+			known[planned.Hash] = true
+		}
+	}
+}
+
+func (d *directUploads) pruneLocked(now time.Time) {
+	for uploadID, upload := range d.uploads {
+		if !upload.completing && now.After(upload.plan.ExpiresAt) {
+			delete(d.uploads, uploadID)
+		}
+	}
+}
+
+// validChunkHash reports whether hash looks like a chunk hash: 64 lowercase
+// hex characters
+func validChunkHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// PlanDirectUpload works out which of a file's chunks still need storing
+// and which nodes the client should send each to, signing a grant for
+// each chunk that the nodes accept in place of the cluster secret. Chunks
+// stored already, at the replication asked for, need not be sent again.
+func (s *FileService) PlanDirectUpload(req DirectUploadRequest) (*DirectUploadPlan, error) {
+	if req.FileName == "" {
+		return nil, fmt.Errorf("%w: file_name is required", ErrInvalidDirectUpload)
+	}
+	if req.ClientEncryption != "" && !req.ClientEncrypted {
+		return nil, fmt.Errorf("%w: client_encryption requires client_encrypted", ErrInvalidDirectUpload)
+	}
+	if err := ValidateTags(req.Tags); err != nil {
+		return nil, err
+	}
+
+	algorithm := chunking.DefaultHashAlgorithm
+	if req.HashAlgorithm != "" {
+		parsed, err := chunking.ParseHashAlgorithm(req.HashAlgorithm)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDirectUpload, err)
+		}
+		algorithm = parsed
+	}
+
+	hashes := make([]string, 0, len(req.Chunks))
+	sizes := make(map[string]int, len(req.Chunks))
+	for i, chunk := range req.Chunks {
+		if !validChunkHash(chunk.Hash) {
+			return nil, fmt.Errorf("%w: chunk %d has an invalid hash", ErrInvalidDirectUpload, i)
+		}
+		if chunk.Size < 1 || chunk.Size > chunking.MaxChunkSize {
+			return nil, fmt.Errorf("%w: chunk %d must be between 1 and %d bytes", ErrInvalidDirectUpload, i, chunking.MaxChunkSize)
+		}
+		if size, seen := sizes[chunk.Hash]; seen {
+			if size != chunk.Size {
+				return nil, fmt.Errorf("%w: chunk %d repeats an earlier hash with another size", ErrInvalidDirectUpload, i)
+			}
+			continue
+		}
+		sizes[chunk.Hash] = chunk.Size
+		hashes = append(hashes, chunk.Hash)
+	}
+
+	healthy := s.registry.GetHealthyNodes()
+	direct := make(map[string]*node.NodeInfo)
+	for _, nodeInfo := range healthy {
+		if nodeInfo.Supports(node.TransportDirect) && !nodeInfo.Full() {
+			direct[nodeInfo.NodeID] = nodeInfo
+		}
+	}
+	if len(direct) == 0 {
+		return nil, ErrNoDirectNodes
+	}
+
+	replication := ReplicationCount
+	if req.Replication != 0 {
+		if req.Replication < 1 || req.Replication > len(healthy) {
+			return nil, ErrInvalidReplication
+		}
+		replication = req.Replication
+	}
+
+	existing, err := s.db.GetChunks(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up existing chunks: %w", err)
+	}
+	locations, err := s.db.GetChunkLocations(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunk locations: %w", err)
+	}
+
+	plan := &DirectUploadPlan{
+		UploadID:    uuid.New().String(),
+		ExpiresAt:   time.Now().Add(DirectUploadTTL),
+		Replication: replication,
+		Chunks:      make([]PlannedChunk, 0, len(hashes)),
+	}
+
+	for _, hash := range hashes {
+		planned := PlannedChunk{Hash: hash, Size: sizes[hash]}
+
+		// Stored chunks are reused, like in an upload through the
+		// coordinator, unless this file wants more replicas than they have
+		var holders []string
+		record := existing[hash]
+		if record != nil {
+			// Equal hashes from different algorithms say nothing about the data
+			if record.Algorithm() != string(algorithm) {
+				return nil, fmt.Errorf("%w: chunk %s is stored as a %s hash, not %s", ErrInvalidDirectUpload, hash[:8], record.Algorithm(), algorithm)
+			}
+			if record.Replication >= replication || !strings.HasPrefix(record.StoragePath, "distributed:") {
+				planned.Stored = true
+				plan.Chunks = append(plan.Chunks, planned)
+				continue
+			}
+			holders = locations[hash]
+		}
+
+		planned.Targets = s.directTargets(hash, replication, holders, direct, plan.ExpiresAt)
+		if len(planned.Targets) == 0 {
+			if record == nil {
+				return nil, ErrNoDirectNodes
+			}
+			// No node left to raise its replication on; it stays as it is
+			planned.Stored = true
+		}
+		plan.Chunks = append(plan.Chunks, planned)
+	}
+
+	s.direct.add(&directUpload{request: req, plan: plan, algorithm: algorithm})
+
+	log.Printf("Planned direct upload %s: %s, %d chunks", plan.UploadID, req.FileName, len(req.Chunks))
+	return plan, nil
+}
+
+// directTargets picks the nodes a client sends a chunk to, enough that
+// replication nodes hold it counting holders: its replica nodes first,
+// then the rest of the ring in order, among the nodes that take direct
+// uploads
+func (s *FileService) directTargets(chunkHash string, replication int, holders []string, direct map[string]*node.NodeInfo, expires time.Time) []ChunkTarget {
+	placed, _ := s.placeReplicas(chunkHash, replication)
+	// GetNodes caps count at the size of the ring, so this is every node
+	ringOrder, _ := s.ring.GetNodes(chunkHash, math.MaxInt)
+
+	skip := make(map[string]bool, len(holders))
+	for _, nodeID := range holders {
+		skip[nodeID] = true
+	}
+
+	var grant string
+	if s.clusterSecret != "" {
+		grant = auth.SignChunkGrant(s.clusterSecret, chunkHash, expires)
+	}
+
+	var targets []ChunkTarget
+	for _, nodeID := range append(placed, ringOrder...) {
+		if len(targets)+len(holders) >= replication {
+			break
+		}
+		nodeInfo := direct[nodeID]
+		if nodeInfo == nil || skip[nodeID] {
+			continue
+		}
+		skip[nodeID] = true
+		targets = append(targets, ChunkTarget{
+			NodeID: nodeID,
+			URL:    s.nodeURL(nodeInfo) + "/chunk/" + chunkHash,
+			Grant:  grant,
+		})
+	}
+	return targets
+}
+
+// CompleteDirectUpload records a direct upload once the client has sent its
+// chunks. Each target is asked whether it holds its chunk rather than
+// taking the client's word for it; every new chunk must be held by at least
+// one node, or the write quorum if one is set. Targets the client didn't
+// reach are queued for another placement attempt, as in any upload.
+func (s *FileService) CompleteDirectUpload(ctx context.Context, uploadID, owner string) (*UploadResult, error) {
+	upload, err := s.direct.begin(uploadID, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.completeDirectUpload(ctx, upload)
+	s.direct.end(uploadID, err == nil)
+	return result, err
+}
+
+func (s *FileService) completeDirectUpload(ctx context.Context, upload *directUpload) (*UploadResult, error) {
+	req, plan := upload.request, upload.plan
+
+	// References taken before a failure are released after the lock below
+	// is given up, since releasing them takes it for writing, along with
+	// the file if it was saved
+	var abandoned []string
+	savedFile := ""
+	defer func() {
+		if savedFile != "" {
+			s.abandonFile(savedFile, abandoned)
+		} else {
+			s.abandonUpload(abandoned)
+		}
+	}()
+
+	// Nothing is deleted as an orphan between finding the chunks on the
+	// nodes and recording them
+	s.storing.RLock()
+	defer s.storing.RUnlock()
+
+	planned := make(map[string]PlannedChunk, len(plan.Chunks))
+	holders := make(map[string][]string, len(plan.Chunks))
+	hashes := make([]string, 0, len(plan.Chunks))
+	for _, chunk := range plan.Chunks {
+		planned[chunk.Hash] = chunk
+		hashes = append(hashes, chunk.Hash)
+
+		for _, target := range chunk.Targets {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			nodeInfo, err := s.registry.GetNode(target.NodeID)
+			if err != nil {
+				continue
+			}
+			held, err := s.nodeHasChunk(ctx, nodeInfo, chunk.Hash)
+			if err != nil {
+				log.Printf("Failed to check chunk %s on node %s: %v", chunk.Hash[:8], target.NodeID, err)
+				continue
+			}
+			if held {
+				holders[chunk.Hash] = append(holders[chunk.Hash], target.NodeID)
+			}
+		}
+	}
+
+	existing, err := s.db.GetChunks(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up existing chunks: %w", err)
+	}
+
+	// Check every new chunk before recording any. A chunk planned as
+	// stored that has since been deleted is missing too.
+	var missing []string
+	for _, hash := range hashes {
+		if existing[hash] != nil {
+			continue
+		}
+		storedOn := len(holders[hash])
+		if storedOn == 0 {
+			missing = append(missing, hash[:8])
+			continue
+		}
+		if s.writeQuorum > 0 && storedOn < s.writeQuorumFor(plan.Replication) {
+			return nil, fmt.Errorf("%w: chunk %s stored on %d of %d required nodes", ErrWriteQuorum, hash[:8], storedOn, s.writeQuorumFor(plan.Replication))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrChunksNotUploaded, strings.Join(missing, ", "))
+	}
+
+	fileID := uuid.New().String()
+	chunkHashes := make([]string, 0, len(req.Chunks))
+	newChunksStored := 0
+	minReplicas := 0
+	var replicateLater []string
+
+	record := &metadata.FileRecord{
+		FileID:      fileID,
+		FileName:    req.FileName,
+		ContentType: req.ContentType,
+		Replication: plan.Replication,
+		Tags:        req.Tags,
+
+		ClientEncrypted:  req.ClientEncrypted,
+		ClientEncryption: req.ClientEncryption,
+	}
+	for _, chunk := range req.Chunks {
+		record.FileSize += int64(chunk.Size)
+	}
+
+	log.Printf("Completing direct upload %s: %s (ID: %s, Size: %d bytes)", plan.UploadID, req.FileName, fileID, record.FileSize)
+
+	for i, chunk := range req.Chunks {
+		storedOn := holders[chunk.Hash]
+		storagePath := ""
+		chunkReplication := plan.Replication
+		isNew := existing[chunk.Hash] == nil
+
+		if isNew {
+			storagePath = "distributed:" + storedOn[0]
+		} else if targets := planned[chunk.Hash].Targets; len(targets) == 0 || len(storedOn) < len(targets) {
+			// A stored chunk's replication is only raised once every
+			// replica the plan added is in place
+			chunkReplication = existing[chunk.Hash].Replication
+		}
+
+		dbIsNew, err := s.db.CreateChunk(chunk.Hash, chunk.Size, storagePath, chunkReplication, string(upload.algorithm))
+		if err != nil {
+			abandoned = chunkHashes
+			return nil, fmt.Errorf("failed to save metadata for chunk %d: %w", i, err)
+		}
+		chunkHashes = append(chunkHashes, chunk.Hash)
+		if err := s.db.AddChunkLocations(chunk.Hash, storedOn); err != nil {
+			abandoned = chunkHashes
+			return nil, fmt.Errorf("failed to save locations for chunk %d: %w", i, err)
+		}
+
+		if isNew && dbIsNew {
+			newChunksStored++
+			if minReplicas == 0 || len(storedOn) < minReplicas {
+				minReplicas = len(storedOn)
+			}
+			// Replicas the client couldn't place are retried in the background
+			if len(storedOn) < chunkReplication {
+				s.queueFailedPlacement(chunk.Hash, chunkReplication, storedOn)
+				if s.replicationMode == ReplicationAsync {
+					replicateLater = append(replicateLater, chunk.Hash)
+				}
+			}
+		}
+	}
+
+	if err := s.db.CreateFile(record); err != nil {
+		abandoned = chunkHashes
+		return nil, fmt.Errorf("failed to save file metadata: %w", err)
+	}
+
+	var offset int64
+	for i, chunk := range req.Chunks {
+		if err := s.db.LinkFileChunk(fileID, chunk.Hash, i, offset); err != nil {
+			savedFile, abandoned = fileID, chunkHashes[i:]
+			return nil, fmt.Errorf("failed to link file chunks: %w", err)
+		}
+		offset += int64(chunk.Size)
+	}
+
+	if len(replicateLater) > 0 {
+		go s.replicateQueued(replicateLater)
+	}
+
+	dedupRatio := float64(len(chunkHashes)) / float64(max(newChunksStored, 1))
+	log.Printf("Direct upload complete: %d total chunks, %d stored, %d deduplicated (%.2fx dedup ratio)",
+		len(chunkHashes), newChunksStored, len(chunkHashes)-newChunksStored, dedupRatio)
+
+	return &UploadResult{
+		FileID:       fileID,
+		FileName:     req.FileName,
+		Version:      record.Version,
+		Size:         record.FileSize,
+		ChunkHashes:  chunkHashes,
+		ChunksStored: newChunksStored,
+		MinReplicas:  minReplicas,
+		DedupRatio:   dedupRatio,
+		Replication:  plan.Replication,
+	}, nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// acceptDirectUploads registers nodes again as taking chunks straight from
+// clients
+func acceptDirectUploads(t *testing.T, s *FileService, nodes []*node.StorageNode) {
+	t.Helper()
+
+	for _, sn := range nodes {
+		nodeInfo := &node.NodeInfo{NodeID: sn.NodeID, Address: sn.Address, Transports: []string{node.TransportBinary, node.TransportDirect}}
+		if err := s.registry.(*node.Registry).RegisterNode(nodeInfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// putPlanned sends a planned chunk to each of its targets as a client would
+func putPlanned(t *testing.T, planned PlannedChunk, data []byte) {
+	t.Helper()
+
+	for _, target := range planned.Targets {
+		req, err := http.NewRequest(http.MethodPut, target.URL, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT to %s: want 200, got %s", target.NodeID, resp.Status)
+		}
+	}
+}
+
+// TestDirectUpload plans a direct upload, checks it can't be completed
+// until its chunks are on the nodes, or by another client, then completes
+// it and checks the file downloads
+func TestDirectUpload(t *testing.T) {
+	s, db, nodes := newTestCluster(t, 3)
+	acceptDirectUploads(t, s, nodes)
+	ctx := context.Background()
+
+	first, second := randomBytes(t, 1000), randomBytes(t, 500)
+	stored := upload(t, s, second, UploadMetadata{})
+	firstHash, secondHash := chunking.SHA256.Sum(first), stored.ChunkHashes[0]
+
+	plan, err := s.PlanDirectUpload(DirectUploadRequest{
+		FileName: "direct.bin",
+		Owner:    "alice",
+		Chunks:   []DirectChunk{{firstHash, len(first)}, {secondHash, len(second)}, {firstHash, len(first)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Chunks) != 2 || plan.Chunks[0].Hash != firstHash || plan.Chunks[1].Hash != secondHash {
+		t.Fatalf("want each distinct chunk planned once, in order, got %+v", plan.Chunks)
+	}
+	if plan.Chunks[0].Stored || len(plan.Chunks[0].Targets) != ReplicationCount {
+		t.Errorf("new chunk: want it sent to %d nodes, got %+v", ReplicationCount, plan.Chunks[0])
+	}
+	if !plan.Chunks[1].Stored || len(plan.Chunks[1].Targets) != 0 {
+		t.Errorf("stored chunk: want nothing to send, got %+v", plan.Chunks[1])
+	}
+
+	if _, err := s.CompleteDirectUpload(ctx, plan.UploadID, "alice"); !errors.Is(err, ErrChunksNotUploaded) {
+		t.Fatalf("chunks not sent: want ErrChunksNotUploaded, got %v", err)
+	}
+	putPlanned(t, plan.Chunks[0], first)
+	if _, err := s.CompleteDirectUpload(ctx, plan.UploadID, "bob"); !errors.Is(err, ErrDirectUploadNotFound) {
+		t.Fatalf("another client: want ErrDirectUploadNotFound, got %v", err)
+	}
+
+	result, err := s.CompleteDirectUpload(ctx, plan.UploadID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != int64(2*len(first)+len(second)) || result.ChunksStored != 1 || result.MinReplicas != ReplicationCount {
+		t.Errorf("want 1 new chunk on %d nodes, got %+v", ReplicationCount, result)
+	}
+	want := append(append(append([]byte{}, first...), second...), first...)
+	if got := download(t, s, result.FileID, ""); !bytes.Equal(got, want) {
+		t.Error("download differs from the chunks sent")
+	}
+	if record, err := db.GetChunk(firstHash); err != nil || record.RefCount != 2 {
+		t.Errorf("want the repeated chunk referenced twice, got %+v (%v)", record, err)
+	}
+
+	if _, err := s.CompleteDirectUpload(ctx, plan.UploadID, "alice"); !errors.Is(err, ErrDirectUploadNotFound) {
+		t.Errorf("completed twice: want ErrDirectUploadNotFound, got %v", err)
+	}
+}
+
+func TestPlanDirectUploadErrors(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 2)
+	hash := chunking.SHA256.Sum([]byte("chunk"))
+
+	if _, err := s.PlanDirectUpload(DirectUploadRequest{FileName: "direct.bin", Chunks: []DirectChunk{{hash, 5}}}); !errors.Is(err, ErrNoDirectNodes) {
+		t.Errorf("no node takes direct uploads: want ErrNoDirectNodes, got %v", err)
+	}
+	acceptDirectUploads(t, s, nodes)
+
+	for _, tc := range []struct {
+		name string
+		req  DirectUploadRequest
+		want error
+	}{
+		{"no name", DirectUploadRequest{Chunks: []DirectChunk{{hash, 5}}}, ErrInvalidDirectUpload},
+		{"invalid hash", DirectUploadRequest{FileName: "a", Chunks: []DirectChunk{{"not-a-hash", 5}}}, ErrInvalidDirectUpload},
+		{"uppercase hash", DirectUploadRequest{FileName: "a", Chunks: []DirectChunk{{strings.ToUpper(hash), 5}}}, ErrInvalidDirectUpload},
+		{"empty chunk", DirectUploadRequest{FileName: "a", Chunks: []DirectChunk{{hash, 0}}}, ErrInvalidDirectUpload},
+		{"oversized chunk", DirectUploadRequest{FileName: "a", Chunks: []DirectChunk{{hash, chunking.MaxChunkSize + 1}}}, ErrInvalidDirectUpload},
+		{"repeat with another size", DirectUploadRequest{FileName: "a", Chunks: []DirectChunk{{hash, 5}, {hash, 6}}}, ErrInvalidDirectUpload},
+		{"unknown hash algorithm", DirectUploadRequest{FileName: "a", HashAlgorithm: "md5", Chunks: []DirectChunk{{hash, 5}}}, ErrInvalidDirectUpload},
+		{"encryption parameters without encryption", DirectUploadRequest{FileName: "a", ClientEncryption: "{}", Chunks: []DirectChunk{{hash, 5}}}, ErrInvalidDirectUpload},
+		{"more replicas than nodes", DirectUploadRequest{FileName: "a", Replication: 3, Chunks: []DirectChunk{{hash, 5}}}, ErrInvalidReplication},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := s.PlanDirectUpload(tc.req); !errors.Is(err, tc.want) {
+				t.Errorf("want %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// distributeChunksInBatches places each chunk on the nodes placeReplicas
+// picks, sending all chunks destined for the same node in as few
+// /store/batch requests as possible. Chunks that fail within a batch are
+// retried individually. Returns the nodes that acknowledged each chunk and
+// the nodes each was sent to, keyed by chunk hash.
+func (s *FileService) distributeChunksInBatches(ctx context.Context, chunks []*chunking.Chunk, replicas int) (map[string][]string, map[string][]string) {
+	batches := make(map[string][]node.StoreChunkRequest) // nodeID -> chunks
+	targets := make(map[string][]string)
+	seen := make(map[string]bool)
+
+	for _, chunk := range chunks {
+		// A file can contain the same chunk more than once; only send it once
+		if seen[chunk.Hash] {
+			continue
+		}
+		seen[chunk.Hash] = true
+
+		targetNodes, err := s.placeReplicas(chunk.Hash, replicas)
+		if err != nil {
+			log.Printf("Failed to get target nodes: %v", err)
+			continue
+		}
+		targets[chunk.Hash] = targetNodes
+
+		for _, nodeID := range targetNodes {
+			batches[nodeID] = append(batches[nodeID], node.StoreChunkRequest{
+				ChunkHash: chunk.Hash,
+				ChunkData: chunk.Data,
+			})
+		}
+	}
+
+	placements := make(map[string][]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for nodeID, reqs := range batches {
+		wg.Add(1)
+		go func(nodeID string, reqs []node.StoreChunkRequest) {
+			defer wg.Done()
+
+			stored := s.storeBatchesOnNode(ctx, nodeID, reqs)
+
+			mu.Lock()
+			for _, hash := range stored {
+				placements[hash] = append(placements[hash], nodeID)
+			}
+			mu.Unlock()
+		}(nodeID, reqs)
+	}
+	wg.Wait()
+
+	return placements, targets
+}
+
+// placeReplicas returns the nodes a new chunk's replicas go to: those the
+// configured Placer picks, or the chunk's replica nodes on the ring. Either
+// way full nodes are passed over.
+func (s *FileService) placeReplicas(chunkHash string, count int) ([]string, error) {
+	if s.placer == nil {
+		nodeIDs, err := node.PlaceOnRing(s.ring, chunkHash, count, s.registry.GetHealthyNodes())
+		if err == nil && len(nodeIDs) == 0 {
+			return nil, fmt.Errorf("every node is full")
+		}
+		return nodeIDs, err
+	}
+
+	nodeIDs := s.placer.SelectNodes(chunkHash, count, s.placerRegistry)
+	if len(nodeIDs) == 0 {
+		return nil, fmt.Errorf("no nodes available")
+	}
+	return nodeIDs, nil
+}
+
+// replicaNodes returns the nodes to read a replicated chunk from. Without a
+// Placer these are its replica nodes on the ring, followed by any other
+// nodes recorded holding it, such as those it was placed on in place of
+// full ones. A Placer's choices can't be worked out again, so the nodes
+// recorded holding the chunk are used, or the ring's if none are recorded.
+func (s *FileService) replicaNodes(chunkHash string) ([]string, error) {
+	locations, locErr := s.db.GetChunkLocations([]string{chunkHash})
+	if s.placer != nil && locErr == nil && len(locations[chunkHash]) > 0 {
+		return locations[chunkHash], nil
+	}
+
+	nodeIDs, err := s.ring.GetNodes(chunkHash, s.chunkReplication(chunkHash))
+	if err != nil || locErr != nil {
+		return nodeIDs, err
+	}
+	for _, nodeID := range locations[chunkHash] {
+		if !slices.Contains(nodeIDs, nodeID) {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+	return nodeIDs, nil
+}
+
+// storeOnSpareNodes stores a chunk on healthy nodes that aren't full, in
+// its ring order, other than the tried ones, until quorum nodes hold it,
+// counting storedOn, and returns every node that does
+func (s *FileService) storeOnSpareNodes(ctx context.Context, chunk *chunking.Chunk, storedOn, tried []string, quorum int) []string {
+	// GetNodes caps count at the size of the ring, so this is every node in
+	// ring order, the chunk's replica nodes first
+	ringOrder, err := s.ring.GetNodes(chunk.Hash, math.MaxInt)
+	if err != nil {
+		return storedOn
+	}
+
+	healthy := make(map[string]bool)
+	for _, nodeInfo := range s.registry.GetHealthyNodes() {
+		healthy[nodeInfo.NodeID] = !nodeInfo.Full()
+	}
+	skip := make(map[string]bool, len(tried)+len(storedOn))
+	for _, nodeID := range append(append([]string{}, tried...), storedOn...) {
+		skip[nodeID] = true
+	}
+
+	for _, nodeID := range ringOrder {
+		if len(storedOn) >= quorum || ctx.Err() != nil {
+			break
+		}
+		if !healthy[nodeID] || skip[nodeID] {
+			continue
+		}
+		if err := s.storeChunkOnNode(ctx, chunk.Hash, chunk.Data, nodeID); err != nil {
+			log.Printf("Failed to store chunk %s on spare node %s: %v", chunk.Hash[:8], nodeID, err)
+			continue
+		}
+		log.Printf("Stored chunk %s on spare node %s to meet the write quorum", chunk.Hash[:8], nodeID)
+		storedOn = append(storedOn, nodeID)
+	}
+
+	return storedOn
+}
+
+// storeBatchesOnNode sends chunks to a node in batches of at most BatchMaxBytes,
+// retrying any chunk the batch didn't store. Returns the hashes that were stored.
+// Nothing more is sent once ctx is done.
+func (s *FileService) storeBatchesOnNode(ctx context.Context, nodeID string, reqs []node.StoreChunkRequest) []string {
+	var stored []string
+	var failed []node.StoreChunkRequest
+
+	for start := 0; start < len(reqs) && ctx.Err() == nil; {
+		// Fill the batch up to the byte cap (always at least one chunk)
+		end, size := start, 0
+		for end < len(reqs) && (end == start || size+len(reqs[end].ChunkData) <= BatchMaxBytes) {
+			size += len(reqs[end].ChunkData)
+			end++
+		}
+		batch := reqs[start:end]
+		start = end
+
+		resp, err := s.sendBatchToNode(ctx, nodeID, batch)
+		if err != nil {
+			log.Printf("Batch store of %d chunks on node %s failed: %v", len(batch), nodeID, err)
+			failed = append(failed, batch...)
+			continue
+		}
+
+		succeeded := make(map[string]bool, len(resp.Results))
+		for _, result := range resp.Results {
+			if result.Success {
+				succeeded[result.ChunkHash] = true
+			}
+		}
+		for _, req := range batch {
+			if succeeded[req.ChunkHash] {
+				stored = append(stored, req.ChunkHash)
+			} else {
+				failed = append(failed, req)
+			}
+		}
+	}
+
+	// Retry only the chunks that failed, one at a time
+	for _, req := range failed {
+		if ctx.Err() != nil {
+			break
+		}
+		if s.storeChunkOnNodes(ctx, req.ChunkHash, req.ChunkData, []string{nodeID}) > 0 {
+			stored = append(stored, req.ChunkHash)
+		}
+	}
+
+	log.Printf("Stored %d/%d chunks on node %s", len(stored), len(reqs), nodeID)
+	return stored
+}
+
+// sendBatchToNode sends one batch to a node over gRPC when the node serves
+// it, otherwise as a /store/batch request
+func (s *FileService) sendBatchToNode(ctx context.Context, nodeID string, batch []node.StoreChunkRequest) (*node.BatchStoreResponse, error) {
+	nodeInfo, err := s.registry.GetNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if nodeInfo.Supports(node.TransportGRPC) {
+		return s.grpcBatchStore(ctx, nodeInfo, batch)
+	}
+
+	return s.nodeClient(nodeInfo).StoreBatch(ctx, batch)
+}
+
+// storeChunkOnNodes sends a chunk to each of the given nodes and returns how
+// many acknowledged it
+func (s *FileService) storeChunkOnNodes(ctx context.Context, chunkHash string, chunkData []byte, nodeIDs []string) int {
+	stored := 0
+
+	for _, nodeID := range nodeIDs {
+		if err := s.storeChunkOnNode(ctx, chunkHash, chunkData, nodeID); err != nil {
+			log.Printf("Failed to store chunk %s on node %s: %v", chunkHash[:8], nodeID, err)
+			continue
+		}
+
+		log.Printf("Stored chunk %s on node %s", chunkHash[:8], nodeID)
+		stored++
+	}
+
+	return stored
+}
+
+// storeChunkOnNode sends one chunk to a node over gRPC when the node serves
+// it, otherwise as a /store request
+func (s *FileService) storeChunkOnNode(ctx context.Context, chunkHash string, chunkData []byte, nodeID string) error {
+	nodeInfo, err := s.registry.GetNode(nodeID)
+	if err != nil {
+		return err
+	}
+
+	if nodeInfo.Supports(node.TransportGRPC) {
+		return s.grpcStoreChunk(ctx, nodeInfo, chunkHash, chunkData)
+	}
+
+	return s.nodeClient(nodeInfo).Store(ctx, chunkHash, chunkData)
+}
+
+// RetrieveChunk reads a chunk from the configured backends
+func (s *FileService) RetrieveChunk(ctx context.Context, chunkHash string) ([]byte, error) {
+	return s.backend.Get(ctx, chunkHash)
+}
+
+// retrieveChunk fetches a chunk from the nodes or the local store without
+// repairing anything
+func (s *FileService) retrieveChunk(chunkHash string) ([]byte, error) {
+	coding, err := s.db.GetChunkCoding(chunkHash)
+	if err == nil && coding != nil {
+		chunkData, _, err := s.retrieveCodedChunk(context.Background(), chunkHash, coding)
+		return chunkData, err
+	}
+
+	// Try to get from distributed nodes first
+	chunkData, _, err := s.retrieveChunkFromNodes(context.Background(), chunkHash)
+	if err == nil {
+		return chunkData, nil
+	}
+
+	// Fallback to local storage
+	return s.chunks.GetChunk(chunkHash)
+}
+
+// retrieveChunkFromNodes attempts to retrieve a chunk from storage nodes,
+// least busy replica first. A replica whose data doesn't match the hash is
+// skipped like one that failed. It also returns the nodes that were tried
+// and failed before one succeeded, so read-repair overwrites bad copies. A
+// read cut short by ctx returns ctx's error, and the node it was reading
+// from is not counted as missing. Chunks in the chunk cache are returned
+// without contacting any node.
+func (s *FileService) retrieveChunkFromNodes(ctx context.Context, chunkHash string) ([]byte, []string, error) {
+	if s.cache != nil {
+		if chunkData, ok := s.cache.get(chunkHash); ok {
+			return chunkData, nil, nil
+		}
+	}
+
+	targetNodes, err := s.replicaNodes(chunkHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var missing []string
+	for _, nodeID := range s.orderReplicas(targetNodes) {
+		chunkData, err := s.retrieveChunkFromNode(ctx, chunkHash, nodeID)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Failed to retrieve from node %s: %v", nodeID, err)
+			missing = append(missing, nodeID)
+			continue
+		}
+		// A node can return the wrong data; never pass it on
+		if _, ok := chunking.MatchHash(chunkData, chunkHash); !ok {
+			log.Printf("Node %s returned data for chunk %s that does not match its hash", nodeID, chunkHash[:8])
+			missing = append(missing, nodeID)
+			continue
+		}
+		if s.cache != nil {
+			s.cache.add(chunkHash, chunkData)
+		}
+		return chunkData, missing, nil
+	}
+
+	return nil, missing, fmt.Errorf("chunk not found on any node")
+}
+
+// chunkReplication returns how many nodes a chunk is replicated on. The
+// ring is walked in the same order for every factor, so a chunk's replicas
+// are always the first that many nodes GetNodes returns.
+func (s *FileService) chunkReplication(chunkHash string) int {
+	chunk, err := s.db.GetChunk(chunkHash)
+	if err != nil || chunk.Replication < 1 {
+		return ReplicationCount
+	}
+	return chunk.Replication
+}
+
+// addReplicas stores an existing chunk on the nodes it gains when its
+// replication rises from one factor to a higher one, and returns the nodes
+// that accepted it
+func (s *FileService) addReplicas(ctx context.Context, chunkHash string, chunkData []byte, from, to int) ([]string, error) {
+	targetNodes, err := s.ring.GetNodes(chunkHash, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(targetNodes) <= from {
+		return nil, nil
+	}
+
+	var added []string
+	for _, nodeID := range targetNodes[from:] {
+		if err := s.storeChunkOnNode(ctx, chunkHash, chunkData, nodeID); err != nil {
+			log.Printf("Failed to add replica of chunk %s on node %s: %v", chunkHash[:8], nodeID, err)
+			continue
+		}
+		added = append(added, nodeID)
+	}
+	if len(added) < len(targetNodes)-from {
+		return added, fmt.Errorf("stored %d of %d new replicas", len(added), len(targetNodes)-from)
+	}
+
+	log.Printf("Raised replication of chunk %s from %d to %d", chunkHash[:8], from, to)
+	return added, nil
+}
+
+// orderReplicas orders a chunk's replica nodes for reading so the first
+// replica in ring order doesn't take every read. Healthy nodes come first,
+// then nodes with fewer reads in flight from this coordinator; ties are
+// broken round-robin. Every node is still returned, so reads fall back
+// through all replicas.
+func (s *FileService) orderReplicas(nodeIDs []string) []string {
+	healthy := make(map[string]bool)
+	for _, nodeInfo := range s.registry.GetHealthyNodes() {
+		healthy[nodeInfo.NodeID] = true
+	}
+
+	// Rotate first so the stable sort breaks ties in a different order
+	// on each read
+	ordered := make([]string, len(nodeIDs))
+	if len(nodeIDs) > 0 {
+		turn := int(s.readTurn.Add(1) % uint64(len(nodeIDs)))
+		copy(ordered, nodeIDs[turn:])
+		copy(ordered[len(nodeIDs)-turn:], nodeIDs[:turn])
+	}
+
+	load := make(map[string]int64, len(ordered))
+	for _, nodeID := range ordered {
+		load[nodeID] = s.readCounter(nodeID).Load()
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if healthy[a] != healthy[b] {
+			return healthy[a]
+		}
+		return load[a] < load[b]
+	})
+	return ordered
+}
+
+// readCounter returns the number of chunk reads in flight to a node
+func (s *FileService) readCounter(nodeID string) *atomic.Int64 {
+	counter, _ := s.readsInFlight.LoadOrStore(nodeID, new(atomic.Int64))
+	return counter.(*atomic.Int64)
+}
+
+// readRepair re-stores a chunk on the healthy nodes that should have held it
+// but didn't. Concurrent reads of the same chunk share one repair.
+func (s *FileService) readRepair(chunkHash string, chunkData []byte, missing []string) {
+	if _, inFlight := s.repairing.LoadOrStore(chunkHash, struct{}{}); inFlight {
+		return
+	}
+	defer s.repairing.Delete(chunkHash)
+
+	// Never spread a corrupted copy
+	if _, ok := chunking.MatchHash(chunkData, chunkHash); !ok {
+		log.Printf("Read-repair skipped for chunk %s: retrieved copy is corrupted", chunkHash[:8])
+		return
+	}
+
+	// Unreachable and full nodes would just fail again; only repair ones
+	// that are up and have room
+	healthy := make(map[string]bool)
+	for _, nodeInfo := range s.registry.GetHealthyNodes() {
+		healthy[nodeInfo.NodeID] = !nodeInfo.Full()
+	}
+
+	for _, nodeID := range missing {
+		if !healthy[nodeID] {
+			continue
+		}
+		if err := s.backgroundStore(context.Background(), chunkHash, chunkData, nodeID); err != nil {
+			log.Printf("Read-repair of chunk %s on node %s failed: %v", chunkHash[:8], nodeID, err)
+			continue
+		}
+
+		s.readRepairs.Add(1)
+		s.recordLocation(chunkHash, nodeID)
+		log.Printf("Read-repaired chunk %s on node %s", chunkHash[:8], nodeID)
+	}
+}
+
+// ReadRepairs returns how many replicas have been restored by read-repair
+func (s *FileService) ReadRepairs() int64 {
+	return s.readRepairs.Load()
+}
+
+// retrieveChunkFromNode fetches a chunk from a single node, over gRPC when
+// the node serves it
+func (s *FileService) retrieveChunkFromNode(ctx context.Context, chunkHash, nodeID string) ([]byte, error) {
+	nodeInfo, err := s.registry.GetNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	inFlight := s.readCounter(nodeID)
+	inFlight.Add(1)
+	defer inFlight.Add(-1)
+
+	if nodeInfo.Supports(node.TransportGRPC) {
+		return s.grpcRetrieveChunk(ctx, nodeInfo, chunkHash)
+	}
+
+	return s.nodeClient(nodeInfo).Retrieve(ctx, chunkHash)
+}
+
+// RepairChunkOnNode fetches a good copy of a chunk and stores it on the given node
+func (s *FileService) RepairChunkOnNode(chunkHash, nodeID string) {
+	// The reporting node has dropped the chunk from its index, so any copy
+	// we get back comes from a healthy replica or the local store
+	chunkData, err := s.backgroundRetrieve(context.Background(), chunkHash)
+	if err != nil {
+		log.Printf("Repair failed for chunk %s: no healthy copy available", chunkHash[:8])
+		return
+	}
+
+	if _, ok := chunking.MatchHash(chunkData, chunkHash); !ok {
+		log.Printf("Repair failed for chunk %s: retrieved copy is also corrupted", chunkHash[:8])
+		return
+	}
+
+	if err := s.backgroundStore(context.Background(), chunkHash, chunkData, nodeID); err != nil {
+		log.Printf("Repair failed for chunk %s on node %s: %v", chunkHash[:8], nodeID, err)
+		return
+	}
+
+	s.recordLocation(chunkHash, nodeID)
+	log.Printf("Repaired chunk %s on node %s", chunkHash[:8], nodeID)
+}
+
+// recordLocation notes that a repair placed a chunk on a node. The chunk may
+// have been purged meanwhile, so failures are only logged.
+func (s *FileService) recordLocation(chunkHash, nodeID string) {
+	if err := s.db.AddChunkLocations(chunkHash, []string{nodeID}); err != nil {
+		log.Printf("Failed to record location of chunk %s on node %s: %v", chunkHash[:8], nodeID, err)
+	}
+}
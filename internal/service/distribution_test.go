@@ -0,0 +1,332 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// addFakeNode serves handler as a storage node and adds it to the service's
+// registry and ring, which must be the ones newTestService creates
+func addFakeNode(t *testing.T, s *FileService, nodeID string, handler http.Handler) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	if err := s.registry.(*node.Registry).RegisterNode(&node.NodeInfo{
+		NodeID:  nodeID,
+		Address: strings.TrimPrefix(server.URL, "http://"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.ring.(*node.ConsistentHash).AddNode(nodeID)
+}
+
+// TestBatchStoreRetriesFailedChunks has a node reject every other chunk of
+// a batch and checks only those are sent again, one at a time
+func TestBatchStoreRetriesFailedChunks(t *testing.T) {
+	s, _, _ := newTestService(t)
+
+	var mu sync.Mutex
+	var batches int
+	retried := make(map[string]bool)
+	rejected := make(map[string]bool)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /store/batch", func(w http.ResponseWriter, r *http.Request) {
+		var req node.BatchStoreRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		batches++
+		resp := node.BatchStoreResponse{NodeID: "node-1"}
+		for i, chunk := range req.Chunks {
+			result := node.StoreChunkResponse{Success: i%2 == 0, NodeID: "node-1", ChunkHash: chunk.ChunkHash}
+			if !result.Success {
+				result.Error = "disk full"
+				rejected[chunk.ChunkHash] = true
+			}
+			resp.Results = append(resp.Results, result)
+		}
+		mu.Unlock()
+
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("POST /store", func(w http.ResponseWriter, r *http.Request) {
+		var req node.StoreChunkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		retried[req.ChunkHash] = true
+		mu.Unlock()
+
+		json.NewEncoder(w).Encode(node.StoreChunkResponse{Success: true, NodeID: "node-1", ChunkHash: req.ChunkHash})
+	})
+	addFakeNode(t, s, "node-1", mux)
+
+	chunks := make([]*chunking.Chunk, 20)
+	for i := range chunks {
+		data := randomBytes(t, 1000)
+		chunks[i] = &chunking.Chunk{Hash: chunking.SHA256.Sum(data), Data: data, Size: len(data)}
+	}
+
+	placements := s.ClusterBackend().(*clusterBackend).StoreBatch(context.Background(), chunks, 1)
+
+	if batches != 1 {
+		t.Errorf("want the chunks sent in 1 batch, got %d", batches)
+	}
+	if len(rejected) != len(chunks)/2 {
+		t.Fatalf("want %d chunks rejected, got %d", len(chunks)/2, len(rejected))
+	}
+	for _, chunk := range chunks {
+		if retried[chunk.Hash] != rejected[chunk.Hash] {
+			t.Errorf("chunk %s: rejected %v but retried %v", chunk.Hash[:8], rejected[chunk.Hash], retried[chunk.Hash])
+		}
+		placement := placements[chunk.Hash]
+		if placement == nil || len(placement.Nodes) != 1 || placement.Nodes[0] != "node-1" {
+			t.Errorf("chunk %s: want it placed on node-1, got %+v", chunk.Hash[:8], placement)
+		}
+	}
+}
+
+// replicaNode is a fake storage node serving one chunk and counting reads
+type replicaNode struct {
+	mu      sync.Mutex
+	reads   int
+	delay   time.Duration
+	fail    bool
+	corrupt bool // Serve data that doesn't match the chunk's hash
+}
+
+func (n *replicaNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	n.reads++
+	delay, fail, corrupt := n.delay, n.fail, n.corrupt
+	n.mu.Unlock()
+
+	time.Sleep(delay)
+	if fail {
+		http.Error(w, "disk error", http.StatusInternalServerError)
+		return
+	}
+	if corrupt {
+		json.NewEncoder(w).Encode(node.RetrieveChunkResponse{Success: true, ChunkData: []byte("corrupted chunk!")})
+		return
+	}
+	json.NewEncoder(w).Encode(node.RetrieveChunkResponse{Success: true, ChunkData: replicaData})
+}
+
+func (n *replicaNode) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.reads
+}
+
+var replicaData = []byte("replicated chunk")
+
+// newReplicaNodes adds three fake nodes holding a chunk's replicas
+func newReplicaNodes(t *testing.T, s *FileService) []*replicaNode {
+	t.Helper()
+
+	nodes := make([]*replicaNode, 3)
+	for i := range nodes {
+		nodes[i] = &replicaNode{}
+		mux := http.NewServeMux()
+		mux.Handle("GET /retrieve/{hash}", nodes[i])
+		addFakeNode(t, s, fmt.Sprintf("node-%d", i+1), mux)
+	}
+	return nodes
+}
+
+// TestReadsSpreadAcrossReplicas reads a chunk with three replicas many
+// times and checks the reads are shared evenly rather than all going to
+// the first replica
+func TestReadsSpreadAcrossReplicas(t *testing.T) {
+	s, _, _ := newTestService(t)
+	nodes := newReplicaNodes(t, s)
+	hash := chunking.SHA256.Sum(replicaData)
+
+	const reads = 300
+	for i := 0; i < reads; i++ {
+		if _, _, err := s.retrieveChunkFromNodes(context.Background(), hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, n := range nodes {
+		if got := n.count(); got != reads/len(nodes) {
+			t.Errorf("node-%d: want %d reads, got %d", i+1, reads/len(nodes), got)
+		}
+	}
+}
+
+// TestReadsAvoidBusyReplica makes one replica slow and checks concurrent
+// reads go mostly to the others, which have fewer reads in flight
+func TestReadsAvoidBusyReplica(t *testing.T) {
+	s, _, _ := newTestService(t)
+	nodes := newReplicaNodes(t, s)
+	nodes[0].delay = 200 * time.Millisecond
+	hash := chunking.SHA256.Sum(replicaData)
+
+	// One read occupies the slow replica first
+	var wg sync.WaitGroup
+	for nodes[0].count() == 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.retrieveChunkFromNodes(context.Background(), hash)
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 60; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := s.retrieveChunkFromNodes(context.Background(), hash); err != nil {
+				t.Error(err)
+			}
+		}()
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+
+	slow, fast := nodes[0].count(), nodes[1].count()+nodes[2].count()
+	if slow*4 > fast {
+		t.Errorf("want the busy replica to get far fewer reads, got %d against %d", slow, fast)
+	}
+}
+
+// TestReadsFallThroughReplicas fails two replicas and checks every read
+// still succeeds from the third
+func TestReadsFallThroughReplicas(t *testing.T) {
+	s, _, _ := newTestService(t)
+	nodes := newReplicaNodes(t, s)
+	nodes[0].fail, nodes[1].fail = true, true
+	hash := chunking.SHA256.Sum(replicaData)
+
+	for i := 0; i < 30; i++ {
+		data, _, err := s.retrieveChunkFromNodes(context.Background(), hash)
+		if err != nil || string(data) != string(replicaData) {
+			t.Fatalf("read %d failed: %v", i, err)
+		}
+	}
+	if got := nodes[2].count(); got != 30 {
+		t.Errorf("want the healthy replica to serve all 30 reads, got %d", got)
+	}
+}
+
+// TestReadsSkipMismatchedReplicas has two replicas return data not matching
+// the chunk's hash, and checks every read falls through to the good one,
+// reporting the bad ones for repair, and fails once no replica is good
+func TestReadsSkipMismatchedReplicas(t *testing.T) {
+	s, _, _ := newTestService(t)
+	nodes := newReplicaNodes(t, s)
+	nodes[0].corrupt, nodes[1].corrupt = true, true
+	hash := chunking.SHA256.Sum(replicaData)
+
+	for i := 0; i < 30; i++ {
+		data, missing, err := s.retrieveChunkFromNodes(context.Background(), hash)
+		if err != nil || string(data) != string(replicaData) {
+			t.Fatalf("read %d: want the good replica's data, got %q (%v)", i, data, err)
+		}
+		for _, nodeID := range missing {
+			if nodeID == "node-3" {
+				t.Fatalf("read %d: good replica reported for repair", i)
+			}
+		}
+	}
+	if got := nodes[2].count(); got != 30 {
+		t.Errorf("want the good replica to serve all 30 reads, got %d", got)
+	}
+	if nodes[0].count()+nodes[1].count() == 0 {
+		t.Error("want the mismatched replicas tried too")
+	}
+
+	nodes[2].mu.Lock()
+	nodes[2].corrupt = true
+	nodes[2].mu.Unlock()
+	if data, _, err := s.retrieveChunkFromNodes(context.Background(), hash); err == nil {
+		t.Errorf("no good replica: want an error, got %q", data)
+	}
+}
+
+// TestDownloadSkipsMismatchedReplicas records a file made of the fake
+// nodes' chunk and has two of them serve data not matching its hash, and
+// checks every download through DownloadFile writes exactly the good
+// replica's bytes
+func TestDownloadSkipsMismatchedReplicas(t *testing.T) {
+	s, db, _ := newTestService(t)
+	nodes := newReplicaNodes(t, s)
+	nodes[0].corrupt, nodes[1].corrupt = true, true
+	hash := chunking.SHA256.Sum(replicaData)
+
+	const fileID = "replicated-file"
+	if _, err := db.CreateChunk(hash, len(replicaData), "distributed:node-1", 3, string(chunking.SHA256)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateFile(&metadata.FileRecord{FileID: fileID, FileName: "replicated.bin", FileSize: int64(len(replicaData)), FileHash: hash}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LinkFileChunk(fileID, hash, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 9; i++ {
+		d, err := s.DownloadFile(context.Background(), fileID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		_, err = d.WriteTo(&buf)
+		d.Close()
+		if err != nil || !bytes.Equal(buf.Bytes(), replicaData) {
+			t.Fatalf("download %d: want exactly the good replica's data, got %q (%v)", i, buf.Bytes(), err)
+		}
+	}
+	if nodes[0].count()+nodes[1].count() == 0 {
+		t.Error("want the mismatched replicas tried too")
+	}
+}
+
+// TestUploadUsesPlacer uploads with round-robin placement and checks each
+// chunk went to the next node in turn rather than where the ring puts it,
+// and that the file reads back from the nodes recorded holding it
+func TestUploadUsesPlacer(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 3)
+	s.UsePlacer(&node.RoundRobinPlacer{}, s.registry.(*node.Registry))
+
+	data := randomBytes(t, 6*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Replication: 1})
+	if len(result.ChunkHashes) < 6 {
+		t.Fatalf("want at least 6 chunks, got %d", len(result.ChunkHashes))
+	}
+	for i, hash := range result.ChunkHashes {
+		for j, sn := range nodes {
+			if held, want := nodeHolds(t, sn, hash), j == i%len(nodes); held != want {
+				t.Errorf("chunk %d on %s: want held %v, got %v", i, sn.NodeID, want, held)
+			}
+		}
+	}
+
+	if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+		t.Error("download differs from the upload")
+	}
+}
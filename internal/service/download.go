@@ -0,0 +1,286 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// Download is a file that has passed lookup and password checks and is
+// ready to be streamed
+type Download struct {
+	File        *metadata.FileRecord
+	Chunks      []metadata.ChunkDescriptor // In file order
+	ChunkHashes []string
+
+	svc       *FileService
+	key       *crypto.EncryptionKey
+	dedupSalt []byte          // Prefix stripped from each chunk; see metadata.FileRecord.DedupSalt
+	ctx       context.Context // No chunks are fetched once it is done
+}
+
+// DownloadFile looks up a file and prepares it for streaming. The password is
+// only required for encrypted files. Streaming stops once ctx is done; Close
+// the download when finished with it.
+func (s *FileService) DownloadFile(ctx context.Context, fileID, password string) (*Download, error) {
+	// Get file metadata and its chunks from database in one round trip
+	fileRecord, chunks, err := s.db.GetFileWithChunks(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if fileRecord.Expired(time.Now()) {
+		return nil, ErrFileExpired
+	}
+
+	// Check encryption
+	var decryptionKey *crypto.EncryptionKey
+
+	if fileRecord.Encrypted {
+		if password == "" {
+			return nil, ErrPasswordRequired
+		}
+
+		salt, err := hex.DecodeString(fileRecord.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption metadata: %w", err)
+		}
+
+		key, err := crypto.DeriveKey(password, salt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive decryption key: %w", err)
+		}
+
+		// Fail fast on a wrong password instead of midway through the stream.
+		// Files without a recorded verifier skip this check.
+		if fileRecord.PasswordHash != "" && !crypto.VerifyPassword(key, fileRecord.PasswordHash) {
+			key.Zero()
+			return nil, ErrIncorrectPassword
+		}
+		key.Algorithm = fileRecord.EncryptionAlgorithm
+		decryptionKey = key
+	}
+
+	dedupSalt, err := hex.DecodeString(fileRecord.DedupSalt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dedup salt: %w", err)
+	}
+
+	chunkHashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		chunkHashes[i] = chunk.ChunkHash
+	}
+
+	return &Download{
+		File:        fileRecord,
+		Chunks:      chunks,
+		ChunkHashes: chunkHashes,
+		svc:         s,
+		key:         decryptionKey,
+		dedupSalt:   dedupSalt,
+		ctx:         ctx,
+	}, nil
+}
+
+// Close wipes the decryption key of an encrypted file. Call it once the
+// download is done; encrypted chunks can't be decrypted afterwards.
+func (d *Download) Close() {
+	d.key.Zero()
+}
+
+// WriteTo streams the file's chunks, in order, to w. Files with a stored
+// hash are hashed as they stream; if the reassembled bytes don't match, the
+// whole file has already been written and ErrChecksumMismatch is returned.
+func (d *Download) WriteTo(w io.Writer) (int64, error) {
+	log.Printf("Downloading: %s (ID: %s, %d chunks, Encrypted: %v)",
+		d.File.FileName, d.File.FileID, len(d.ChunkHashes), d.File.Encrypted)
+
+	var hasher hash.Hash
+	if d.File.FileHash != "" {
+		hasher = sha256.New()
+	}
+
+	out := w
+	if hasher != nil {
+		out = io.MultiWriter(w, hasher)
+	}
+
+	var written int64
+	for i, hash := range d.ChunkHashes {
+		n, err := d.writeChunk(out, i, hash, 0, -1)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	if hasher != nil {
+		if actual := hex.EncodeToString(hasher.Sum(nil)); actual != d.File.FileHash {
+			return written, fmt.Errorf("%w: expected %s, reassembled %s", ErrChecksumMismatch, d.File.FileHash, actual)
+		}
+	}
+
+	log.Printf("Download complete: %s", d.File.FileName)
+	return written, nil
+}
+
+// WriteRange streams the plaintext bytes [start, end) of the file to w.
+// Only the chunks overlapping the range are fetched.
+func (d *Download) WriteRange(w io.Writer, start, end int64) (int64, error) {
+	var chunks []metadata.ChunkDescriptor
+	for _, chunk := range d.Chunks {
+		if chunk.Offset < end && chunk.Offset+chunk.Size > start {
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	log.Printf("Downloading range %d-%d of %s (ID: %s, %d chunks)",
+		start, end-1, d.File.FileName, d.File.FileID, len(chunks))
+
+	var written int64
+	for i, chunk := range chunks {
+		// Trim the first and last chunks to the requested range
+		n, err := d.writeChunk(w, i, chunk.ChunkHash, max(start-chunk.Offset, 0), end-chunk.Offset)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// writeChunk writes bytes [from, to) of a chunk's plaintext to w, or from
+// from on if to is negative. Each chunk is read from its node into one
+// buffer and only written once it has checked out, so a replica serving the
+// wrong bytes never reaches w. If streaming fails before anything was
+// written, the chunk is read whole instead, which tries every replica and
+// skips those whose data doesn't match the chunk's hash.
+func (d *Download) writeChunk(w io.Writer, i int, hash string, from, to int64) (int64, error) {
+	if err := d.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	window := &windowWriter{w: w, from: from, to: to}
+
+	err := d.streamChunk(window, i, hash)
+	if err == nil || window.written > 0 || d.ctx.Err() != nil || errors.Is(err, ErrDecryptionFailed) {
+		return window.written, err
+	}
+	log.Printf("Streaming chunk %s failed, reading it whole: %v", hash[:8], err)
+
+	chunkData, err := d.readChunk(i, hash)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := window.Write(chunkData); err != nil {
+		return window.written, fmt.Errorf("failed to write chunk %d: %w", i, err)
+	}
+	return window.written, nil
+}
+
+// streamChunk reads one chunk from its backend and writes its plaintext to
+// w. A node's stream is only checked against the chunk's hash at its end,
+// and encrypted chunks can only be authenticated whole, so the chunk is read
+// into a single buffer, decrypted in place if need be, and nothing is
+// written until all of it has checked out.
+func (d *Download) streamChunk(w io.Writer, i int, hash string) error {
+	stream, size, err := openChunk(d.ctx, d.svc.backend, hash)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve chunk %d (hash: %s): %w", i, hash[:8], err)
+	}
+	defer stream.Close()
+
+	// Room for the whole chunk plus what ReadFrom needs to see the end
+	buf := bytes.NewBuffer(make([]byte, 0, max(size, 0)+bytes.MinRead))
+	if _, err := buf.ReadFrom(stream); err != nil {
+		return fmt.Errorf("failed to stream chunk %d (hash: %s): %w", i, hash[:8], err)
+	}
+	chunkData := buf.Bytes()
+
+	if d.key != nil {
+		plaintext, err := crypto.DecryptChunkInPlace(chunkData, d.key)
+		if err != nil {
+			return fmt.Errorf("%w on chunk %d: %v", ErrDecryptionFailed, i, err)
+		}
+		chunkData = plaintext
+	}
+	if len(d.dedupSalt) > 0 {
+		if !bytes.HasPrefix(chunkData, d.dedupSalt) {
+			return fmt.Errorf("chunk %d (hash: %s) lacks the file's dedup salt", i, hash[:8])
+		}
+		chunkData = chunkData[len(d.dedupSalt):]
+	}
+
+	if _, err := w.Write(chunkData); err != nil {
+		return fmt.Errorf("failed to write chunk %d: %w", i, err)
+	}
+	return nil
+}
+
+// windowWriter passes on only bytes [from, to) of what is written to it, or
+// everything from from on if to is negative
+type windowWriter struct {
+	w        io.Writer
+	from, to int64
+	offset   int64 // Bytes written to the windowWriter so far
+	written  int64 // Bytes passed on to w
+}
+
+func (ww *windowWriter) Write(p []byte) (int, error) {
+	start := min(max(ww.from-ww.offset, 0), int64(len(p)))
+	end := int64(len(p))
+	if ww.to >= 0 {
+		end = min(end, max(ww.to-ww.offset, 0))
+	}
+	ww.offset += int64(len(p))
+
+	if start >= end {
+		return len(p), nil
+	}
+	n, err := ww.w.Write(p[start:end])
+	ww.written += int64(n)
+	if err != nil {
+		return int(start) + n, err
+	}
+	return len(p), nil
+}
+
+// readChunk retrieves a chunk and decrypts it if the file is encrypted, or
+// strips its dedup salt
+func (d *Download) readChunk(i int, hash string) ([]byte, error) {
+	if err := d.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	chunkData, err := d.svc.RetrieveChunk(d.ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve chunk %d (hash: %s): %w", i, hash[:8], err)
+	}
+
+	// Decrypt if needed
+	if d.key != nil {
+		decrypted, err := crypto.DecryptChunk(chunkData, d.key)
+		if err != nil {
+			return nil, fmt.Errorf("%w on chunk %d: %v", ErrDecryptionFailed, i, err)
+		}
+		chunkData = decrypted
+	}
+	if len(d.dedupSalt) > 0 {
+		if !bytes.HasPrefix(chunkData, d.dedupSalt) {
+			return nil, fmt.Errorf("chunk %d (hash: %s) lacks the file's dedup salt", i, hash[:8])
+		}
+		chunkData = chunkData[len(d.dedupSalt):]
+	}
+
+	return chunkData, nil
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// TestWriteRange reads ranges starting, ending and spanning chunk
+// boundaries, plain and encrypted, and checks each matches the upload
+func TestWriteRange(t *testing.T) {
+	s, _, _ := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+
+	for _, password := range []string{"", "secret"} {
+		name := "plain"
+		if password != "" {
+			name = "encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			result := upload(t, s, data, UploadMetadata{Password: password})
+
+			d, err := s.DownloadFile(context.Background(), result.FileID, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			if len(d.Chunks) < 3 {
+				t.Fatalf("want at least 3 chunks, got %d", len(d.Chunks))
+			}
+
+			size := int64(len(data))
+			second, third := d.Chunks[1].Offset, d.Chunks[2].Offset
+			ranges := [][2]int64{
+				{0, 1},
+				{0, size},
+				{second - 1, second + 1},  // Across a boundary
+				{second, third},           // Exactly one chunk
+				{second + 10, third - 10}, // Inside one chunk
+				{second - 5, third + 5},   // A whole chunk and part of either neighbour
+				{size - 1, size},
+			}
+			for _, r := range ranges {
+				var buf bytes.Buffer
+				n, err := d.WriteRange(&buf, r[0], r[1])
+				if err != nil {
+					t.Fatalf("range [%d, %d): %v", r[0], r[1], err)
+				}
+				if n != r[1]-r[0] || !bytes.Equal(buf.Bytes(), data[r[0]:r[1]]) {
+					t.Errorf("range [%d, %d): got %d bytes that differ from the upload", r[0], r[1], n)
+				}
+			}
+		})
+	}
+}
+
+// TestDownloadChecksumMismatch overwrites a chunk with other bytes under its
+// hash and checks the whole file is still written, then reported as not
+// matching its checksum
+func TestDownloadChecksumMismatch(t *testing.T) {
+	s, _, chunks := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{})
+
+	sum := sha256.Sum256(data)
+	d, err := s.DownloadFile(context.Background(), result.FileID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.File.FileHash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("want the file's SHA-256 stored, got %q", d.File.FileHash)
+	}
+
+	hash := result.ChunkHashes[1]
+	corrupted, err := chunks.GetChunk(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted[len(corrupted)/2] ^= 0xff
+	if err := chunks.DeleteChunk(hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := chunks.StoreChunk(hash, corrupted); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := d.WriteTo(&buf)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("want ErrChecksumMismatch, got %v", err)
+	}
+	if n != int64(len(data)) || buf.Len() != len(data) {
+		t.Errorf("want all %d bytes written before the mismatch is found, got %d", len(data), n)
+	}
+}
+
+// TestDownloadCloseZeroesKey checks closing an encrypted download wipes the
+// key derived from its password, after which its chunks can't be decrypted
+func TestDownloadCloseZeroesKey(t *testing.T) {
+	s, _, _ := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Password: "secret"})
+
+	d, err := s.DownloadFile(context.Background(), result.FileID, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := d.key.Key
+	var buf bytes.Buffer
+	if _, err := d.WriteTo(&buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("download differs: %v", err)
+	}
+
+	d.Close()
+	if !bytes.Equal(key, make([]byte, len(key))) {
+		t.Error("key not zeroed once the download was closed")
+	}
+	if _, err := d.WriteTo(io.Discard); err == nil {
+		t.Error("want the file undecryptable with the wiped key")
+	}
+}
+
+// heapWatcher passes writes on to w, recording the most heap in use as each
+// arrives
+type heapWatcher struct {
+	w    io.Writer
+	peak uint64
+}
+
+func (hw *heapWatcher) Write(p []byte) (int, error) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	hw.peak = max(hw.peak, stats.HeapAlloc)
+	return hw.w.Write(p)
+}
+
+// TestDownloadStreamsChunks downloads a file of large chunks from a node,
+// collecting garbage eagerly, and checks the heap never grows by as much as
+// two of the largest chunks, so only the chunk being checked is held rather
+// than the file
+func TestDownloadStreamsChunks(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 1)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Replication: 1})
+
+	// Register the node as it registers itself, able to stream raw chunks
+	if err := s.registry.(*node.Registry).RegisterNode(&node.NodeInfo{
+		NodeID:     nodes[0].NodeID,
+		Address:    nodes[0].Address,
+		Transports: []string{node.TransportJSON, node.TransportBinary},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := s.DownloadFile(context.Background(), result.FileID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	defer debug.SetGCPercent(debug.SetGCPercent(1))
+	hasher := sha256.New()
+	watcher := &heapWatcher{w: hasher}
+	// Twice, so pooled buffers are freed too
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	n, err := d.WriteTo(watcher)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("want %d bytes downloaded, got %d (%v)", len(data), n, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if got := hasher.Sum(nil); !bytes.Equal(got, sum[:]) {
+		t.Errorf("download differs: want %x, got %x", sum, got)
+	}
+	if grown := int64(watcher.peak) - int64(before.HeapAlloc); grown > 2*chunking.MaxChunkSize {
+		t.Errorf("want the heap to grow by under %d bytes streaming %d, grew %d", 2*chunking.MaxChunkSize, len(data), grown)
+	}
+}
+
+// TestDownloadSkipsCorruptReplica streams a file's chunks from three
+// nodes, two of which flip a byte of every chunk they serve, and checks each
+// download writes exactly the original bytes: a bad replica is caught
+// before any of its data is written, and the chunk is read from the good one
+func TestDownloadSkipsCorruptReplica(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 3)
+	data := randomBytes(t, 2*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Replication: 3})
+
+	var mu sync.Mutex
+	corrupted := 0
+	for _, sn := range nodes[:2] {
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: sn.Address})
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			if !strings.HasPrefix(resp.Request.URL.Path, "/chunk/") || resp.StatusCode != http.StatusOK {
+				return nil
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			body[len(body)/2] ^= 0xff
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+
+			mu.Lock()
+			corrupted++
+			mu.Unlock()
+			return nil
+		}
+		addFakeNode(t, s, sn.NodeID, proxy)
+	}
+	// Register every node as it registers itself, able to stream raw chunks
+	for _, sn := range nodes {
+		info, err := s.registry.GetNode(sn.NodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.registry.(*node.Registry).RegisterNode(&node.NodeInfo{
+			NodeID:     info.NodeID,
+			Address:    info.Address,
+			Transports: []string{node.TransportJSON, node.TransportBinary},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 6; i++ {
+		d, err := s.DownloadFile(context.Background(), result.FileID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		n, err := d.WriteTo(&buf)
+		d.Close()
+		if err != nil {
+			t.Fatalf("download %d: %v", i, err)
+		}
+		if n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("download %d: want exactly the %d bytes uploaded, got %d differing", i, len(data), n)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if corrupted == 0 {
+		t.Error("want the corrupt replicas read first some of the time")
+	}
+}
+
+// TestDownloadTransport downloads from a node that reported no transports
+// and checks its chunks are read as JSON, then from the same node reporting
+// the binary transport and checks they are streamed raw instead
+func TestDownloadTransport(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 1)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Replication: 1})
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: nodes[0].Address})
+	addFakeNode(t, s, nodes[0].NodeID, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[strings.SplitN(r.URL.Path, "/", 3)[1]]++
+		mu.Unlock()
+		proxy.ServeHTTP(w, r)
+	}))
+	served := func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		retrieved, streamed := requests["retrieve"], requests["chunk"]
+		clear(requests)
+		return retrieved, streamed
+	}
+
+	chunks := len(result.ChunkHashes)
+	if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+		t.Fatal("download differs from the upload")
+	}
+	if retrieved, streamed := served(); retrieved != chunks || streamed != 0 {
+		t.Errorf("no transports reported: want %d chunks read as JSON, got %d as JSON and %d streamed", chunks, retrieved, streamed)
+	}
+
+	info, err := s.registry.GetNode(nodes[0].NodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.registry.(*node.Registry).RegisterNode(&node.NodeInfo{
+		NodeID:     info.NodeID,
+		Address:    info.Address,
+		Transports: []string{node.TransportJSON, node.TransportBinary},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+		t.Fatal("download differs from the upload")
+	}
+	if retrieved, streamed := served(); retrieved != 0 || streamed != chunks {
+		t.Errorf("binary transport reported: want %d chunks streamed, got %d as JSON and %d streamed", chunks, retrieved, streamed)
+	}
+}
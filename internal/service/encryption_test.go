@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// algorithmStore reports every file as encrypted with algorithm, whatever
+// it was uploaded with
+type algorithmStore struct {
+	*metadata.MemoryStore
+	algorithm string
+}
+
+func (a *algorithmStore) GetFileWithChunks(fileID string) (*metadata.FileRecord, []metadata.ChunkDescriptor, error) {
+	file, chunks, err := a.MemoryStore.GetFileWithChunks(fileID)
+	if file != nil {
+		file.EncryptionAlgorithm = a.algorithm
+	}
+	return file, chunks, err
+}
+
+// TestEncryptionAlgorithms uploads files sealed with each algorithm, chosen
+// per upload or as the service default, and checks each records its
+// algorithm and downloads unchanged
+func TestEncryptionAlgorithms(t *testing.T) {
+	s, db, _ := newTestService(t)
+	data := randomBytes(t, 2*chunking.MaxChunkSize+100)
+
+	for _, tc := range []struct {
+		name       string
+		defaultAlg string
+		algorithm  string
+		want       string
+	}{
+		{"default", "", "", crypto.AlgorithmAESGCM},
+		{"AES-GCM", "", crypto.AlgorithmAESGCM, crypto.AlgorithmAESGCM},
+		{"ChaCha20-Poly1305", "", crypto.AlgorithmChaCha20Poly1305, crypto.AlgorithmChaCha20Poly1305},
+		{"lowercase name", "", "chacha20-poly1305", crypto.AlgorithmChaCha20Poly1305},
+		{"ChaCha20-Poly1305 default", crypto.AlgorithmChaCha20Poly1305, "", crypto.AlgorithmChaCha20Poly1305},
+		{"AES-GCM over the default", crypto.AlgorithmChaCha20Poly1305, crypto.AlgorithmAESGCM, crypto.AlgorithmAESGCM},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defaultAlg := tc.defaultAlg
+			if defaultAlg == "" {
+				defaultAlg = crypto.AlgorithmAESGCM
+			}
+			if err := s.UseEncryptionAlgorithm(defaultAlg); err != nil {
+				t.Fatal(err)
+			}
+
+			result := upload(t, s, data, UploadMetadata{Password: "s3cret", Algorithm: tc.algorithm})
+			file, err := db.GetFile(result.FileID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.EncryptionAlgorithm != tc.want {
+				t.Errorf("want %s recorded, got %q", tc.want, file.EncryptionAlgorithm)
+			}
+			if got := download(t, s, result.FileID, "s3cret"); !bytes.Equal(got, data) {
+				t.Error("download differs from upload")
+			}
+		})
+	}
+
+	// Files keep the algorithm they were sealed with after the default
+	// changes
+	if err := s.UseEncryptionAlgorithm(crypto.AlgorithmAESGCM); err != nil {
+		t.Fatal(err)
+	}
+	files, err := db.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if got := download(t, s, file.FileID, "s3cret"); !bytes.Equal(got, data) {
+			t.Errorf("%s file unreadable after the default changed", file.EncryptionAlgorithm)
+		}
+	}
+}
+
+func TestInvalidEncryptionAlgorithm(t *testing.T) {
+	s, _, _ := newTestService(t)
+
+	if err := s.UseEncryptionAlgorithm("DES"); !errors.Is(err, crypto.ErrUnknownAlgorithm) {
+		t.Errorf("default: want ErrUnknownAlgorithm, got %v", err)
+	}
+	meta := UploadMetadata{FileName: "secret.bin", Size: 3, Password: "s3cret", Algorithm: "DES"}
+	if _, err := s.UploadFile(context.Background(), bytes.NewReader([]byte("abc")), meta); !errors.Is(err, ErrInvalidAlgorithm) {
+		t.Errorf("upload: want ErrInvalidAlgorithm, got %v", err)
+	}
+}
+
+// TestStoredAlgorithmDecrypts checks downloads decrypt with the algorithm
+// the file records rather than the service default: a file recorded with
+// the other algorithm fails to decrypt with the right password
+func TestStoredAlgorithmDecrypts(t *testing.T) {
+	swapped := map[string]string{
+		crypto.AlgorithmAESGCM:           crypto.AlgorithmChaCha20Poly1305,
+		crypto.AlgorithmChaCha20Poly1305: crypto.AlgorithmAESGCM,
+	}
+	for sealed, recorded := range swapped {
+		t.Run(sealed, func(t *testing.T) {
+			db := &algorithmStore{MemoryStore: metadata.NewMemoryStore()}
+			s, _ := newTestServiceWith(t, db)
+			data := randomBytes(t, 1000)
+			result := upload(t, s, data, UploadMetadata{Password: "s3cret", Algorithm: sealed})
+
+			db.algorithm = sealed
+			if got := download(t, s, result.FileID, "s3cret"); !bytes.Equal(got, data) {
+				t.Fatal("download with the recorded algorithm differs from upload")
+			}
+
+			// The default matching what the file was sealed with doesn't help
+			if err := s.UseEncryptionAlgorithm(sealed); err != nil {
+				t.Fatal(err)
+			}
+			db.algorithm = recorded
+			d, err := s.DownloadFile(context.Background(), result.FileID, "s3cret")
+			if err == nil {
+				defer d.Close()
+				_, err = d.WriteTo(io.Discard)
+			}
+			if !errors.Is(err, ErrDecryptionFailed) {
+				t.Errorf("recorded as %s: want ErrDecryptionFailed, got %v", recorded, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// UseErasureCoding stores new chunks as dataShards data shards plus
+// parityShards parity shards on dataShards+parityShards distinct nodes,
+// instead of ReplicationCount full copies. Any dataShards of the shards are
+// enough to rebuild a chunk. Chunks already stored keep their scheme.
+func (s *FileService) UseErasureCoding(dataShards, parityShards int) error {
+	encoder, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return err
+	}
+
+	s.encoder = encoder
+	s.dataShards = dataShards
+	s.parityShards = parityShards
+	return nil
+}
+
+// erasureStoragePath is recorded as the storage path of erasure-coded chunks
+func erasureStoragePath(coding *metadata.ChunkCoding) string {
+	return fmt.Sprintf("erasure:%d+%d", coding.DataShards, coding.ParityShards)
+}
+
+// distributeShards erasure-codes each chunk and places shard i on the i-th
+// node the ring picks for the chunk. Only chunks whose shards were all stored
+// are returned; the caller falls back to replication for the rest.
+func (s *FileService) distributeShards(ctx context.Context, chunks []*chunking.Chunk) map[string]*metadata.ChunkCoding {
+	total := s.dataShards + s.parityShards
+	batches := make(map[string][]node.StoreChunkRequest) // nodeID -> shards
+	codings := make(map[string]*metadata.ChunkCoding)
+
+	for _, chunk := range chunks {
+		if ctx.Err() != nil {
+			break
+		}
+		if codings[chunk.Hash] != nil {
+			continue
+		}
+
+		targetNodes, err := s.ring.GetNodes(chunk.Hash, total)
+		if err != nil || len(targetNodes) < total {
+			log.Printf("Not enough nodes to erasure-code chunk %s, replicating instead", chunk.Hash[:8])
+			continue
+		}
+
+		shards, err := s.encoder.Split(chunk.Data)
+		if err != nil {
+			log.Printf("Failed to split chunk %s: %v", chunk.Hash[:8], err)
+			continue
+		}
+		if err := s.encoder.Encode(shards); err != nil {
+			log.Printf("Failed to encode chunk %s: %v", chunk.Hash[:8], err)
+			continue
+		}
+
+		coding := &metadata.ChunkCoding{
+			ChunkSize:    len(chunk.Data),
+			DataShards:   s.dataShards,
+			ParityShards: s.parityShards,
+		}
+		for i, shard := range shards {
+			shardHash := s.hashAlgorithm.Sum(shard)
+
+			coding.Shards = append(coding.Shards, metadata.ShardRecord{
+				Index:     i,
+				ShardHash: shardHash,
+				NodeID:    targetNodes[i],
+			})
+			batches[targetNodes[i]] = append(batches[targetNodes[i]], node.StoreChunkRequest{
+				ChunkHash: shardHash,
+				ChunkData: shard,
+			})
+		}
+		codings[chunk.Hash] = coding
+	}
+
+	stored := make(map[string]map[string]bool) // nodeID -> shard hashes
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for nodeID, reqs := range batches {
+		wg.Add(1)
+		go func(nodeID string, reqs []node.StoreChunkRequest) {
+			defer wg.Done()
+
+			hashes := s.storeBatchesOnNode(ctx, nodeID, reqs)
+
+			mu.Lock()
+			stored[nodeID] = make(map[string]bool, len(hashes))
+			for _, hash := range hashes {
+				stored[nodeID][hash] = true
+			}
+			mu.Unlock()
+		}(nodeID, reqs)
+	}
+	wg.Wait()
+
+	for chunkHash, coding := range codings {
+		for _, shard := range coding.Shards {
+			if !stored[shard.NodeID][shard.ShardHash] {
+				log.Printf("Shard %d of chunk %s was not stored, replicating instead", shard.Index, chunkHash[:8])
+				delete(codings, chunkHash)
+				break
+			}
+		}
+	}
+
+	return codings
+}
+
+// retrieveCodedChunk fetches the data shards of an erasure-coded chunk,
+// falling back to parity shards for any that are missing or corrupted, and
+// rebuilds the chunk. It also returns the indexes of shards that could not
+// be read.
+func (s *FileService) retrieveCodedChunk(ctx context.Context, chunkHash string, coding *metadata.ChunkCoding) ([]byte, []int, error) {
+	encoder, err := reedsolomon.New(coding.DataShards, coding.ParityShards)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shards := make([][]byte, len(coding.Shards))
+	var missing []int
+
+	// Data shards first; parity shards are only needed to cover for them
+	fetch := func(records []metadata.ShardRecord) int {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		found := 0
+
+		for _, shard := range records {
+			wg.Add(1)
+			go func(shard metadata.ShardRecord) {
+				defer wg.Done()
+
+				data, err := s.retrieveShard(ctx, shard)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					log.Printf("Failed to retrieve shard %d of chunk %s from node %s: %v",
+						shard.Index, chunkHash[:8], shard.NodeID, err)
+					missing = append(missing, shard.Index)
+					return
+				}
+				shards[shard.Index] = data
+				found++
+			}(shard)
+		}
+		wg.Wait()
+
+		return found
+	}
+
+	found := fetch(coding.Shards[:coding.DataShards])
+	if found < coding.DataShards && ctx.Err() == nil {
+		found += fetch(coding.Shards[coding.DataShards:])
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if found < coding.DataShards {
+		return nil, missing, fmt.Errorf("only %d of %d shards available for chunk %s",
+			found, coding.DataShards, chunkHash[:8])
+	}
+
+	if err := encoder.ReconstructData(shards); err != nil {
+		return nil, missing, fmt.Errorf("failed to reconstruct chunk: %w", err)
+	}
+
+	chunkData := make([]byte, 0, coding.ChunkSize)
+	for _, shard := range shards[:coding.DataShards] {
+		chunkData = append(chunkData, shard...)
+	}
+	chunkData = chunkData[:coding.ChunkSize]
+
+	return chunkData, missing, nil
+}
+
+// retrieveShard fetches one shard from the node it was placed on and checks
+// it against its recorded hash
+func (s *FileService) retrieveShard(ctx context.Context, shard metadata.ShardRecord) ([]byte, error) {
+	data, err := s.retrieveChunkFromNode(ctx, shard.ShardHash, shard.NodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := chunking.MatchHash(data, shard.ShardHash); !ok {
+		return nil, fmt.Errorf("shard is corrupted")
+	}
+
+	return data, nil
+}
+
+// repairShards rebuilds the shards of a chunk that could not be read and
+// stores them back on their nodes, if those nodes are healthy
+func (s *FileService) repairShards(chunkHash string, chunkData []byte, coding *metadata.ChunkCoding, missing []int) {
+	if _, inFlight := s.repairing.LoadOrStore(chunkHash, struct{}{}); inFlight {
+		return
+	}
+	defer s.repairing.Delete(chunkHash)
+
+	encoder, err := reedsolomon.New(coding.DataShards, coding.ParityShards)
+	if err != nil {
+		return
+	}
+
+	// Re-encoding the rebuilt chunk yields exactly the original shards
+	shards, err := encoder.Split(chunkData)
+	if err != nil {
+		return
+	}
+	if err := encoder.Encode(shards); err != nil {
+		return
+	}
+
+	healthy := make(map[string]bool)
+	for _, nodeInfo := range s.registry.GetHealthyNodes() {
+		healthy[nodeInfo.NodeID] = true
+	}
+
+	for _, index := range missing {
+		shard := coding.Shards[index]
+		if !healthy[shard.NodeID] {
+			continue
+		}
+
+		if _, ok := chunking.MatchHash(shards[index], shard.ShardHash); !ok {
+			log.Printf("Shard repair skipped for chunk %s: rebuilt shard %d does not match", chunkHash[:8], index)
+			continue
+		}
+
+		if err := s.backgroundStore(context.Background(), shard.ShardHash, shards[index], shard.NodeID); err != nil {
+			log.Printf("Repair of shard %d of chunk %s on node %s failed: %v", index, chunkHash[:8], shard.NodeID, err)
+			continue
+		}
+
+		s.readRepairs.Add(1)
+		log.Printf("Read-repaired shard %d of chunk %s on node %s", index, chunkHash[:8], shard.NodeID)
+	}
+}
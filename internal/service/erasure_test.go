@@ -0,0 +1,164 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// newErasureCluster returns a service erasure-coding chunks as 3+2 shards
+// over five nodes, keyed by node ID
+func newErasureCluster(t *testing.T) (*FileService, *metadata.MemoryStore, map[string]*node.StorageNode) {
+	t.Helper()
+
+	s, db, nodes := newTestCluster(t, 5)
+	if err := s.UseErasureCoding(3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	byID := make(map[string]*node.StorageNode, len(nodes))
+	for _, sn := range nodes {
+		byID[sn.NodeID] = sn
+	}
+	return s, db, byID
+}
+
+// chunkCodings returns the recorded coding of each chunk of an upload
+func chunkCodings(t *testing.T, db *metadata.MemoryStore, result *UploadResult) []*metadata.ChunkCoding {
+	t.Helper()
+
+	codings := make([]*metadata.ChunkCoding, len(result.ChunkHashes))
+	for i, hash := range result.ChunkHashes {
+		coding, err := db.GetChunkCoding(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if coding == nil {
+			t.Fatalf("chunk %s was not erasure-coded", hash[:8])
+		}
+		codings[i] = coding
+	}
+	return codings
+}
+
+// deleteShard removes a shard from the node it was placed on
+func deleteShard(t *testing.T, nodes map[string]*node.StorageNode, shard metadata.ShardRecord) {
+	t.Helper()
+
+	client := node.NewNodeClient(http.DefaultClient, "http://"+nodes[shard.NodeID].Address)
+	if err := client.Delete(context.Background(), shard.ShardHash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestErasureCodingPlacement checks each chunk is stored as k+m shards on
+// as many distinct nodes, with no node holding the whole chunk
+func TestErasureCodingPlacement(t *testing.T) {
+	s, db, nodes := newErasureCluster(t)
+	result := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{})
+
+	for i, coding := range chunkCodings(t, db, result) {
+		hash := result.ChunkHashes[i]
+		if coding.DataShards != 3 || coding.ParityShards != 2 || len(coding.Shards) != 5 {
+			t.Fatalf("chunk %s: want 3+2 shards, got %d+%d with %d recorded",
+				hash[:8], coding.DataShards, coding.ParityShards, len(coding.Shards))
+		}
+
+		used := make(map[string]bool)
+		for j, shard := range coding.Shards {
+			if shard.Index != j {
+				t.Errorf("chunk %s: shard %d recorded with index %d", hash[:8], j, shard.Index)
+			}
+			if used[shard.NodeID] {
+				t.Errorf("chunk %s: two shards on node %s", hash[:8], shard.NodeID)
+			}
+			used[shard.NodeID] = true
+			if !nodeHolds(t, nodes[shard.NodeID], shard.ShardHash) {
+				t.Errorf("chunk %s: shard %d missing from node %s", hash[:8], j, shard.NodeID)
+			}
+		}
+
+		for _, sn := range nodes {
+			if nodeHolds(t, sn, hash) {
+				t.Errorf("chunk %s: node %s holds the whole chunk", hash[:8], sn.NodeID)
+			}
+		}
+	}
+}
+
+// TestErasureCodingReconstruction deletes m shards of every chunk, data and
+// parity alike, and checks the file still downloads and the data shards
+// are repaired; with m+1 gone the download fails
+func TestErasureCodingReconstruction(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		deleted []int
+		fails   bool
+	}{
+		{"two data shards", []int{0, 2}, false},
+		{"a data and a parity shard", []int{1, 4}, false},
+		{"both parity shards", []int{3, 4}, false},
+		{"one more than the parity", []int{0, 1, 3}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, db, nodes := newErasureCluster(t)
+			data := randomBytes(t, 3*chunking.MaxChunkSize)
+			result := upload(t, s, data, UploadMetadata{})
+
+			codings := chunkCodings(t, db, result)
+			for _, coding := range codings {
+				for _, index := range tc.deleted {
+					deleteShard(t, nodes, coding.Shards[index])
+				}
+			}
+
+			d, err := s.DownloadFile(context.Background(), result.FileID, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			var buf bytes.Buffer
+			_, err = d.WriteTo(&buf)
+
+			if tc.fails {
+				if err == nil {
+					t.Fatal("want the download to fail with too few shards left")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatal("reconstructed file differs from the upload")
+			}
+
+			// Read-repair puts back the deleted data shards; parity shards
+			// are only read, and so only noticed, when data shards are missing
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+				var missing []string
+				for i, coding := range codings {
+					for _, index := range tc.deleted {
+						shard := coding.Shards[index]
+						if index < coding.DataShards && !nodeHolds(t, nodes[shard.NodeID], shard.ShardHash) {
+							missing = append(missing, fmt.Sprintf("%s/%d", result.ChunkHashes[i][:8], index))
+						}
+					}
+				}
+				if len(missing) == 0 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("shards never repaired: %v", missing)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrFileExpired is returned for downloads of a file past its expiry that
+// the reaper has not purged yet
+var ErrFileExpired = errors.New("file has expired")
+
+// PurgeExpiredFiles permanently removes files past their expiry, whether
+// or not they are in the trash, and returns how many were purged. Chunks
+// another file still references are kept.
+func (s *FileService) PurgeExpiredFiles() (int, error) {
+	files, err := s.db.ListExpiredFiles(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, file := range files {
+		if err := s.PurgeFile(file.FileID); err != nil {
+			log.Printf("Failed to purge expired file %s: %v", file.FileID, err)
+			continue
+		}
+		purged++
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// TestPurgeExpiredFiles stores a live file, an expired copy of it sharing
+// its chunks and an expired file of its own, and checks the expired files
+// are hidden and refused, then reaped without freeing the shared chunks
+func TestPurgeExpiredFiles(t *testing.T) {
+	s, db, chunks := newTestService(t)
+	expiry := time.Now().Add(-time.Minute)
+
+	data := randomBytes(t, 3000)
+	live := upload(t, s, data, UploadMetadata{FileName: "live.bin"})
+	copied := upload(t, s, data, UploadMetadata{FileName: "copy.bin", ExpiresAt: &expiry})
+	own := upload(t, s, randomBytes(t, 3000), UploadMetadata{FileName: "own.bin", ExpiresAt: &expiry})
+	for _, hash := range live.ChunkHashes {
+		if record, err := db.GetChunk(hash); err != nil || record.RefCount != 2 {
+			t.Fatalf("chunk %s: want it shared by the live file and its copy, got %+v (%v)", hash[:8], record, err)
+		}
+	}
+
+	files, err := db.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].FileID != live.FileID {
+		t.Errorf("want only the live file listed, got %+v", files)
+	}
+	for _, fileID := range []string{copied.FileID, own.FileID} {
+		if _, err := s.DownloadFile(context.Background(), fileID, ""); !errors.Is(err, ErrFileExpired) {
+			t.Errorf("download of expired file %s: want ErrFileExpired, got %v", fileID, err)
+		}
+	}
+
+	// The copy's trash is no hiding place from the reaper
+	if err := s.DeleteFile(copied.FileID); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := s.PurgeExpiredFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Errorf("want both expired files purged, got %d", purged)
+	}
+	for _, fileID := range []string{copied.FileID, own.FileID} {
+		if _, err := db.GetFile(fileID); !errors.Is(err, metadata.ErrFileNotFound) {
+			t.Errorf("expired file %s: want it gone, got %v", fileID, err)
+		}
+	}
+
+	for _, hash := range live.ChunkHashes {
+		if record, err := db.GetChunk(hash); err != nil || record.RefCount != 1 {
+			t.Errorf("shared chunk %s: want it kept for the live file, got %+v (%v)", hash[:8], record, err)
+		}
+		if !chunks.HasChunk(hash) {
+			t.Errorf("shared chunk %s: data deleted", hash[:8])
+		}
+	}
+	for _, hash := range own.ChunkHashes {
+		if _, err := db.GetChunk(hash); !errors.Is(err, metadata.ErrChunkNotFound) {
+			t.Errorf("unshared chunk %s: want it freed, got %v", hash[:8], err)
+		}
+		if chunks.HasChunk(hash) {
+			t.Errorf("unshared chunk %s: data left behind", hash[:8])
+		}
+	}
+	if got := download(t, s, live.FileID, ""); !bytes.Equal(got, data) {
+		t.Error("live file differs after the reaper ran")
+	}
+
+	if purged, err := s.PurgeExpiredFiles(); err != nil || purged != 0 {
+		t.Errorf("second run: want nothing to purge, got %d (%v)", purged, err)
+	}
+}
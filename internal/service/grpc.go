@@ -0,0 +1,211 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/node/nodepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// nodeRPCTimeout bounds a single gRPC call to a storage node
+const nodeRPCTimeout = 2 * time.Minute
+
+// grpcClient returns a client for the node's gRPC data path, reusing one
+// connection per address
+func (s *FileService) grpcClient(nodeInfo *node.NodeInfo) (nodepb.StorageNodeClient, error) {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+
+	if conn, exists := s.conns[nodeInfo.GRPCAddress]; exists {
+		return nodepb.NewStorageNodeClient(conn), nil
+	}
+
+	creds := insecure.NewCredentials()
+	if s.tlsConfig != nil {
+		creds = credentials.NewTLS(s.tlsConfig)
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(node.GRPCMaxMessageSize),
+			grpc.MaxCallSendMsgSize(node.GRPCMaxMessageSize),
+		),
+	}
+	if s.clusterSecret != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(auth.RPCCredentials{Token: s.clusterSecret}))
+	}
+
+	conn, err := grpc.NewClient(nodeInfo.GRPCAddress, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.conns[nodeInfo.GRPCAddress] = conn
+	return nodepb.NewStorageNodeClient(conn), nil
+}
+
+// Close releases the service's connections to storage nodes
+func (s *FileService) Close() error {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+
+	for address, conn := range s.conns {
+		conn.Close()
+		delete(s.conns, address)
+	}
+	return nil
+}
+
+// grpcStoreChunk streams a chunk to a node in ChunkPieceSize pieces
+func (s *FileService) grpcStoreChunk(ctx context.Context, nodeInfo *node.NodeInfo, chunkHash string, chunkData []byte) error {
+	client, err := s.grpcClient(nodeInfo)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, nodeRPCTimeout)
+	defer cancel()
+
+	stream, err := client.StoreChunk(ctx)
+	if err != nil {
+		return err
+	}
+
+	for offset := 0; ; offset += node.ChunkPieceSize {
+		end := min(offset+node.ChunkPieceSize, len(chunkData))
+
+		piece := &nodepb.ChunkPiece{Data: chunkData[offset:end]}
+		if offset == 0 {
+			piece.ChunkHash = chunkHash
+		}
+		if err := stream.Send(piece); err != nil {
+			return err
+		}
+		if end == len(chunkData) {
+			break
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if !resp.GetSuccess() {
+		return fmt.Errorf("node rejected chunk: %s", resp.GetError())
+	}
+
+	return nil
+}
+
+// grpcBatchStore stores several chunks on a node in one call
+func (s *FileService) grpcBatchStore(ctx context.Context, nodeInfo *node.NodeInfo, batch []node.StoreChunkRequest) (*node.BatchStoreResponse, error) {
+	client, err := s.grpcClient(nodeInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &nodepb.BatchStoreRequest{Chunks: make([]*nodepb.StoreChunkRequest, 0, len(batch))}
+	for _, chunk := range batch {
+		req.Chunks = append(req.Chunks, &nodepb.StoreChunkRequest{
+			ChunkHash: chunk.ChunkHash,
+			ChunkData: chunk.ChunkData,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, nodeRPCTimeout)
+	defer cancel()
+
+	resp, err := client.BatchStore(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	batchResp := &node.BatchStoreResponse{
+		NodeID:  resp.GetNodeId(),
+		Results: make([]node.StoreChunkResponse, 0, len(resp.GetResults())),
+	}
+	for _, result := range resp.GetResults() {
+		batchResp.Results = append(batchResp.Results, node.StoreChunkResponse{
+			Success:   result.GetSuccess(),
+			NodeID:    result.GetNodeId(),
+			ChunkHash: result.GetChunkHash(),
+			Error:     result.GetError(),
+		})
+	}
+
+	return batchResp, nil
+}
+
+// grpcRetrieveChunk reassembles a chunk streamed back from a node
+func (s *FileService) grpcRetrieveChunk(ctx context.Context, nodeInfo *node.NodeInfo, chunkHash string) ([]byte, error) {
+	client, err := s.grpcClient(nodeInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, nodeRPCTimeout)
+	defer cancel()
+
+	stream, err := client.RetrieveChunk(ctx, &nodepb.RetrieveChunkRequest{ChunkHash: chunkHash})
+	if err != nil {
+		return nil, err
+	}
+
+	var chunkData bytes.Buffer
+	for {
+		piece, err := stream.Recv()
+		if err == io.EOF {
+			return chunkData.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunkData.Write(piece.GetData())
+	}
+}
+
+// grpcDeleteChunk removes a chunk from a node. A node that never held the
+// chunk is not an error.
+func (s *FileService) grpcDeleteChunk(nodeInfo *node.NodeInfo, chunkHash string) error {
+	client, err := s.grpcClient(nodeInfo)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), nodeRPCTimeout)
+	defer cancel()
+
+	_, err = client.Delete(ctx, &nodepb.DeleteRequest{ChunkHash: chunkHash})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
+// grpcChunkExists asks a node whether it holds a chunk
+func (s *FileService) grpcChunkExists(nodeInfo *node.NodeInfo, chunkHash string) (bool, error) {
+	client, err := s.grpcClient(nodeInfo)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), nodeRPCTimeout)
+	defer cancel()
+
+	resp, err := client.Exists(ctx, &nodepb.ExistsRequest{ChunkHashes: []string{chunkHash}})
+	if err != nil {
+		return false, err
+	}
+	return len(resp.GetPresent()) > 0, nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// TestUploadOverGRPC uploads to a node serving the gRPC data path, checks
+// the chunks went over it, and downloads the file back
+func TestUploadOverGRPC(t *testing.T) {
+	s, _, _ := newTestService(t)
+	sn := startTestNode(t, s, "node-1", func(sn *node.StorageNode) {
+		sn.GRPCAddress = freeAddress(t)
+	})
+	t.Cleanup(func() { s.Close() })
+
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{})
+
+	s.connsLock.Lock()
+	_, dialed := s.conns[sn.GRPCAddress]
+	s.connsLock.Unlock()
+	if !dialed {
+		t.Fatal("upload did not use the node's gRPC data path")
+	}
+
+	for _, hash := range result.ChunkHashes {
+		if !nodeHolds(t, sn, hash) {
+			t.Errorf("chunk %s not on the node", hash[:8])
+		}
+	}
+
+	if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from the upload")
+	}
+}
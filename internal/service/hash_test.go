@@ -0,0 +1,71 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// TestRoundTripUnderEachAlgorithm stores and reads back files under each
+// chunk hash algorithm, and checks the algorithm is recorded per chunk
+func TestRoundTripUnderEachAlgorithm(t *testing.T) {
+	for _, algorithm := range []chunking.HashAlgorithm{chunking.SHA256, chunking.BLAKE3} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			s, db, _ := newTestService(t)
+			s.UseHashAlgorithm(algorithm)
+
+			for _, size := range []int{1000, 3 * chunking.MaxChunkSize} {
+				data := randomBytes(t, size)
+				result := upload(t, s, data, UploadMetadata{})
+
+				if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+					t.Fatalf("%d bytes: downloaded data differs from upload", size)
+				}
+				for _, hash := range result.ChunkHashes {
+					chunk, err := db.GetChunk(hash)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if chunk.Algorithm() != string(algorithm) {
+						t.Errorf("chunk %s recorded as %s, want %s", hash[:8], chunk.Algorithm(), algorithm)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestSwitchHashAlgorithm stores content sharing every chunk but the last
+// before and after a switch, and checks no chunk is deduplicated across it
+func TestSwitchHashAlgorithm(t *testing.T) {
+	s, db, _ := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	before := upload(t, s, data, UploadMetadata{})
+
+	// One more byte, so the file isn't matched whole against the first
+	s.UseHashAlgorithm(chunking.BLAKE3)
+	extended := append(data[:len(data):len(data)], 'x')
+	after := upload(t, s, extended, UploadMetadata{})
+	if after.ChunksStored != len(after.ChunkHashes) {
+		t.Errorf("want every chunk stored anew, got %d of %d", after.ChunksStored, len(after.ChunkHashes))
+	}
+
+	for _, tc := range []struct {
+		result *UploadResult
+		data   []byte
+	}{{before, data}, {after, extended}} {
+		if got := download(t, s, tc.result.FileID, ""); !bytes.Equal(got, tc.data) {
+			t.Error("downloaded data differs from upload")
+		}
+		for _, hash := range tc.result.ChunkHashes {
+			chunk, err := db.GetChunk(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunk.RefCount != 1 {
+				t.Errorf("chunk %s has %d references, want 1", hash[:8], chunk.RefCount)
+			}
+		}
+	}
+}
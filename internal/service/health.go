@@ -0,0 +1,168 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Storage health of a file, from best to worst. A chunk is at risk when
+// losing one more node would lose it.
+const (
+	HealthHealthy         = "healthy"
+	HealthUnderReplicated = "under-replicated" // Chunk only
+	HealthDegraded        = "degraded"         // File only: some chunks under-replicated
+	HealthAtRisk          = "at-risk"
+	HealthLost            = "lost"
+)
+
+// ChunkHealth is how many healthy nodes hold one chunk of a file. For
+// erasure-coded chunks the counts are shards, any Minimum of which rebuild
+// the chunk.
+type ChunkHealth struct {
+	Index          int      `json:"index"`
+	Hash           string   `json:"hash"`
+	Status         string   `json:"status"`
+	Required       int      `json:"required"`  // Copies or shards the chunk should have
+	Minimum        int      `json:"minimum"`   // Copies or shards needed to read it
+	Available      int      `json:"available"` // Copies or shards on healthy nodes
+	HealthyNodes   []string `json:"healthy_nodes"`
+	UnhealthyNodes []string `json:"unhealthy_nodes,omitempty"` // Offline, degraded or unregistered
+	Local          bool     `json:"local,omitempty"`           // Stored on the coordinator, not on nodes
+	Queued         bool     `json:"queued,omitempty"`          // Waiting to be placed on more nodes; see FailedPlacements
+}
+
+// FileHealth summarizes whether a file's chunks are safely replicated
+type FileHealth struct {
+	FileID          string        `json:"file_id"`
+	FileName        string        `json:"file_name"`
+	Status          string        `json:"status"`
+	Replication     int           `json:"replication"`
+	HealthyChunks   int           `json:"healthy_chunks"`
+	UnderReplicated int           `json:"under_replicated_chunks"`
+	AtRiskChunks    int           `json:"at_risk_chunks"`
+	LostChunks      int           `json:"lost_chunks"`
+	Chunks          []ChunkHealth `json:"chunks"`
+}
+
+// FileHealth checks each chunk of a file against the nodes that are healthy
+// right now. Chunk locations are the last known ones; chunks stored before
+// locations were tracked are assumed to be where the ring places them.
+func (s *FileService) FileHealth(fileID string) (*FileHealth, error) {
+	file, fileChunks, err := s.db.GetFileWithChunks(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(fileChunks))
+	for i, chunk := range fileChunks {
+		hashes[i] = chunk.ChunkHash
+	}
+	records, err := s.db.GetChunks(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunks: %w", err)
+	}
+	locations, err := s.db.GetChunkLocations(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunk locations: %w", err)
+	}
+
+	healthy := make(map[string]bool)
+	for _, nodeInfo := range s.registry.GetHealthyNodes() {
+		healthy[nodeInfo.NodeID] = true
+	}
+
+	// Chunks still short of replicas they are queued to gain, such as
+	// async uploads' replicas that haven't been copied yet
+	placements, err := s.db.ListFailedPlacements()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up queued placements: %w", err)
+	}
+	queued := make(map[string]bool, len(placements))
+	for _, placement := range placements {
+		queued[placement.ChunkHash] = true
+	}
+
+	report := &FileHealth{
+		FileID:      file.FileID,
+		FileName:    file.FileName,
+		Replication: file.Replication,
+		Chunks:      make([]ChunkHealth, len(fileChunks)),
+	}
+
+	for i, chunk := range fileChunks {
+		health := ChunkHealth{Index: i, Hash: chunk.ChunkHash, HealthyNodes: []string{}, Queued: queued[chunk.ChunkHash]}
+
+		record := records[chunk.ChunkHash]
+		if record == nil {
+			return nil, fmt.Errorf("chunk %d (%s) has no metadata", i, chunk.ChunkHash[:8])
+		}
+
+		coding, err := s.db.GetChunkCoding(chunk.ChunkHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up coding of chunk %d: %w", i, err)
+		}
+
+		var nodeIDs []string
+		switch {
+		case coding != nil:
+			health.Required = coding.DataShards + coding.ParityShards
+			health.Minimum = coding.DataShards
+			for _, shard := range coding.Shards {
+				nodeIDs = append(nodeIDs, shard.NodeID)
+			}
+		case strings.HasPrefix(record.StoragePath, "distributed:"):
+			health.Required = max(record.Replication, 1)
+			health.Minimum = 1
+			nodeIDs = locations[chunk.ChunkHash]
+			if len(nodeIDs) == 0 {
+				nodeIDs, _ = s.ring.GetNodes(chunk.ChunkHash, health.Required)
+			}
+		default:
+			health.Local = true
+			health.Required = 1
+			health.Minimum = 1
+			if s.chunks.HasChunk(chunk.ChunkHash) {
+				health.Available = 1
+			}
+		}
+
+		for _, nodeID := range nodeIDs {
+			if healthy[nodeID] {
+				health.HealthyNodes = append(health.HealthyNodes, nodeID)
+			} else {
+				health.UnhealthyNodes = append(health.UnhealthyNodes, nodeID)
+			}
+		}
+		health.Available += len(health.HealthyNodes)
+
+		switch {
+		case health.Available < health.Minimum:
+			health.Status = HealthLost
+			report.LostChunks++
+		case health.Available == health.Minimum && health.Required > health.Minimum:
+			health.Status = HealthAtRisk
+			report.AtRiskChunks++
+		case health.Available < health.Required:
+			health.Status = HealthUnderReplicated
+			report.UnderReplicated++
+		default:
+			health.Status = HealthHealthy
+			report.HealthyChunks++
+		}
+
+		report.Chunks[i] = health
+	}
+
+	switch {
+	case report.LostChunks > 0:
+		report.Status = HealthLost
+	case report.AtRiskChunks > 0:
+		report.Status = HealthAtRisk
+	case report.UnderReplicated > 0:
+		report.Status = HealthDegraded
+	default:
+		report.Status = HealthHealthy
+	}
+
+	return report, nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// takeOffline makes the registry stop counting nodes as healthy
+func takeOffline(t *testing.T, s *FileService, nodeIDs ...string) {
+	t.Helper()
+
+	for _, nodeID := range nodeIDs {
+		if err := s.registry.(*node.Registry).RecordProbe(nodeID, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func fileHealth(t *testing.T, s *FileService, fileID string) *FileHealth {
+	t.Helper()
+
+	health, err := s.FileHealth(fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return health
+}
+
+// TestFileHealth takes down, one by one, the three nodes holding every
+// chunk of a file and checks its health goes from healthy to lost
+func TestFileHealth(t *testing.T) {
+	s, _, _ := newTestCluster(t, 3)
+	result := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{})
+	chunks := len(result.ChunkHashes)
+
+	for _, tc := range []struct {
+		offline     string
+		fileStatus  string
+		chunkStatus string
+		available   int
+	}{
+		{"", HealthHealthy, HealthHealthy, 3},
+		{"node-1", HealthDegraded, HealthUnderReplicated, 2},
+		{"node-2", HealthAtRisk, HealthAtRisk, 1},
+		{"node-3", HealthLost, HealthLost, 0},
+	} {
+		if tc.offline != "" {
+			takeOffline(t, s, tc.offline)
+		}
+
+		health := fileHealth(t, s, result.FileID)
+		if health.Status != tc.fileStatus || len(health.Chunks) != chunks {
+			t.Fatalf("%s offline: want the file %s with %d chunks, got %s with %d", tc.offline, tc.fileStatus, chunks, health.Status, len(health.Chunks))
+		}
+		for _, chunk := range health.Chunks {
+			if chunk.Status != tc.chunkStatus || chunk.Available != tc.available || len(chunk.HealthyNodes) != tc.available ||
+				len(chunk.UnhealthyNodes) != 3-tc.available || chunk.Required != 3 || chunk.Minimum != 1 {
+				t.Errorf("%s offline: want chunk %d %s on %d nodes, got %+v", tc.offline, chunk.Index, tc.chunkStatus, tc.available, chunk)
+			}
+		}
+	}
+}
+
+// TestFileHealthMixedNodes spreads a file over five nodes, takes two of
+// them offline, and checks each chunk is judged by the healthy nodes among
+// those that hold it
+func TestFileHealthMixedNodes(t *testing.T) {
+	s, db, _ := newTestCluster(t, 5)
+	result := upload(t, s, randomBytes(t, 6*chunking.MaxChunkSize), UploadMetadata{})
+
+	offline := map[string]bool{"node-1": true, "node-2": true}
+	takeOffline(t, s, "node-1", "node-2")
+
+	locations, err := db.GetChunkLocations(result.ChunkHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	health := fileHealth(t, s, result.FileID)
+	counts := make(map[string]int)
+	for i, chunk := range health.Chunks {
+		hash := result.ChunkHashes[i]
+		if chunk.Hash != hash || len(locations[hash]) != 3 {
+			t.Fatalf("chunk %d: want %s on 3 nodes, got %s on %v", i, hash[:8], chunk.Hash[:8], locations[hash])
+		}
+
+		available := 0
+		for _, nodeID := range locations[hash] {
+			if !offline[nodeID] {
+				available++
+			}
+		}
+		want := map[int]string{1: HealthAtRisk, 2: HealthUnderReplicated, 3: HealthHealthy}[available]
+		if chunk.Status != want || chunk.Available != available {
+			t.Errorf("chunk %d on %v: want %s with %d available, got %s with %d", i, locations[hash], want, available, chunk.Status, chunk.Available)
+		}
+		for _, nodeID := range chunk.HealthyNodes {
+			if offline[nodeID] {
+				t.Errorf("chunk %d: offline node %s counted healthy", i, nodeID)
+			}
+		}
+		for _, nodeID := range chunk.UnhealthyNodes {
+			if !offline[nodeID] {
+				t.Errorf("chunk %d: healthy node %s counted unhealthy", i, nodeID)
+			}
+		}
+		counts[want]++
+	}
+
+	if health.HealthyChunks != counts[HealthHealthy] || health.UnderReplicated != counts[HealthUnderReplicated] ||
+		health.AtRiskChunks != counts[HealthAtRisk] || health.LostChunks != 0 {
+		t.Errorf("want chunk totals %v, got %+v", counts, health)
+	}
+	want := HealthHealthy
+	switch {
+	case counts[HealthAtRisk] > 0:
+		want = HealthAtRisk
+	case counts[HealthUnderReplicated] > 0:
+		want = HealthDegraded
+	}
+	if health.Status != want {
+		t.Errorf("want the file %s, got %s", want, health.Status)
+	}
+}
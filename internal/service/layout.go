@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// ChunkLayout describes one chunk of a file without its data. Offset and
+// Size are in the plaintext byte stream; encrypted chunks are stored with
+// the AES-GCM nonce and tag added.
+type ChunkLayout struct {
+	Index  int                   `json:"index"`
+	Hash   string                `json:"hash"`
+	Offset int64                 `json:"offset"`
+	Size   int64                 `json:"size"`
+	Nodes  []string              `json:"nodes"`            // Last known nodes holding the chunk or its shards
+	Coding *metadata.ChunkCoding `json:"coding,omitempty"` // Set for erasure-coded chunks
+}
+
+// FileLayout is the ordered chunk list of a file, enough for a client to
+// fetch chunks from the nodes directly and in parallel
+type FileLayout struct {
+	FileID      string        `json:"file_id"`
+	FileName    string        `json:"file_name"`
+	Version     int           `json:"version"`
+	Size        int64         `json:"size"`
+	Encrypted   bool          `json:"encrypted"`
+	ContentType string        `json:"content_type"`
+	Replication int           `json:"replication"`
+	Chunks      []ChunkLayout `json:"chunks"`
+
+	ClientEncrypted  bool   `json:"client_encrypted,omitempty"`
+	ClientEncryption string `json:"client_encryption,omitempty"`
+
+	// DedupSalt is set for files uploaded with deduplication disabled: each
+	// chunk as stored on the nodes starts with these hex-encoded bytes
+	DedupSalt string `json:"dedup_salt,omitempty"`
+}
+
+// Layout returns the file's chunk layout. It reads metadata only; no chunk
+// data is fetched.
+func (d *Download) Layout() (*FileLayout, error) {
+	contentType := d.File.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	layout := &FileLayout{
+		FileID:      d.File.FileID,
+		FileName:    d.File.FileName,
+		Version:     d.File.Version,
+		Size:        d.File.FileSize,
+		Encrypted:   d.File.Encrypted,
+		ContentType: contentType,
+		Replication: d.File.Replication,
+		Chunks:      make([]ChunkLayout, len(d.Chunks)),
+
+		ClientEncrypted:  d.File.ClientEncrypted,
+		ClientEncryption: d.File.ClientEncryption,
+		DedupSalt:        d.File.DedupSalt,
+	}
+
+	codings := make(map[string]*metadata.ChunkCoding)
+	for i, chunk := range d.Chunks {
+		coding, seen := codings[chunk.ChunkHash]
+		if !seen {
+			var err error
+			coding, err = d.svc.db.GetChunkCoding(chunk.ChunkHash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up coding of chunk %d: %w", i, err)
+			}
+			codings[chunk.ChunkHash] = coding
+		}
+
+		layout.Chunks[i] = ChunkLayout{
+			Index:  i,
+			Hash:   chunk.ChunkHash,
+			Offset: chunk.Offset,
+			Size:   chunk.Size,
+			Nodes:  chunk.Nodes,
+			Coding: coding,
+		}
+	}
+
+	return layout, nil
+}
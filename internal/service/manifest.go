@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// ManifestFormat is the version of the manifest layout
+const ManifestFormat = 1
+
+var (
+	ErrManifestKeyMissing = errors.New("manifest signing key not configured")
+	ErrInvalidManifest    = errors.New("invalid manifest")
+	ErrFileExists         = errors.New("file already exists")
+)
+
+// Manifest is a portable description of a file: its metadata, encryption
+// parameters and ordered chunk list, signed so a restore can trust it. With
+// the chunk data it is enough to recreate the file on another cluster.
+type Manifest struct {
+	Format      int    `json:"format"`
+	FileID      string `json:"file_id"`
+	FileName    string `json:"file_name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	Replication int    `json:"replication"`
+	FileHash    string `json:"file_hash,omitempty"`
+
+	Encryption       *ManifestEncryption `json:"encryption,omitempty"` // Set for password-encrypted files
+	ClientEncrypted  bool                `json:"client_encrypted,omitempty"`
+	ClientEncryption string              `json:"client_encryption,omitempty"`
+
+	// DedupSalt is set for files uploaded with deduplication disabled;
+	// each stored chunk starts with it
+	DedupSalt string `json:"dedup_salt,omitempty"`
+
+	Chunks []ManifestChunk `json:"chunks"`
+
+	// Signature is the hex HMAC-SHA256 of the manifest with this field empty
+	Signature string `json:"signature,omitempty"`
+}
+
+// ManifestEncryption holds what a password needs to decrypt the file
+type ManifestEncryption struct {
+	Algorithm    string `json:"algorithm"`
+	Salt         string `json:"salt"`
+	NoncePrefix  string `json:"nonce_prefix,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// ManifestChunk is one chunk of a manifest, in file order
+type ManifestChunk struct {
+	Hash       string `json:"hash"`        // SHA-256 of the stored bytes
+	Offset     int64  `json:"offset"`      // Start in the plaintext
+	Size       int64  `json:"size"`        // Plaintext bytes
+	StoredSize int    `json:"stored_size"` // Bytes as stored, after encryption or salting
+}
+
+// UseManifestKey sets the key manifests are signed and verified with.
+// Clusters that exchange manifests must share it.
+func (s *FileService) UseManifestKey(key string) {
+	s.manifestKey = []byte(key)
+}
+
+// signature computes a manifest's HMAC, ignoring its Signature field
+func (s *FileService) signature(m *Manifest) (string, error) {
+	unsigned := *m
+	unsigned.Signature = ""
+
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, s.manifestKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Manifest exports the file as a signed manifest. It reads metadata only.
+func (d *Download) Manifest() (*Manifest, error) {
+	s := d.svc
+	if len(s.manifestKey) == 0 {
+		return nil, ErrManifestKeyMissing
+	}
+
+	fileChunks := d.Chunks
+
+	hashes := make([]string, len(fileChunks))
+	for i, chunk := range fileChunks {
+		hashes[i] = chunk.ChunkHash
+	}
+	records, err := s.db.GetChunks(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunks: %w", err)
+	}
+
+	m := &Manifest{
+		Format:      ManifestFormat,
+		FileID:      d.File.FileID,
+		FileName:    d.File.FileName,
+		Size:        d.File.FileSize,
+		ContentType: d.File.ContentType,
+		Replication: d.File.Replication,
+		FileHash:    d.File.FileHash,
+		Chunks:      make([]ManifestChunk, len(fileChunks)),
+
+		ClientEncrypted:  d.File.ClientEncrypted,
+		ClientEncryption: d.File.ClientEncryption,
+		DedupSalt:        d.File.DedupSalt,
+	}
+	if d.File.Encrypted {
+		algorithm := d.File.EncryptionAlgorithm
+		if algorithm == "" {
+			algorithm = crypto.AlgorithmAESGCM
+		}
+		m.Encryption = &ManifestEncryption{
+			Algorithm:    algorithm,
+			Salt:         d.File.Salt,
+			NoncePrefix:  d.File.NoncePrefix,
+			PasswordHash: d.File.PasswordHash,
+		}
+	}
+
+	for i, chunk := range fileChunks {
+		record := records[chunk.ChunkHash]
+		if record == nil {
+			return nil, fmt.Errorf("chunk %d (%s) has no metadata", i, chunk.ChunkHash[:8])
+		}
+		m.Chunks[i] = ManifestChunk{
+			Hash:       chunk.ChunkHash,
+			Offset:     chunk.Offset,
+			Size:       chunk.Size,
+			StoredSize: record.ChunkSize,
+		}
+	}
+
+	if m.Signature, err = s.signature(m); err != nil {
+		return nil, fmt.Errorf("failed to sign manifest: %w", err)
+	}
+	return m, nil
+}
+
+// RestoreManifest recreates a file from a signed manifest, keeping its file
+// ID. Chunk data is taken from chunkData, keyed by hash; chunks not in it
+// must still be readable from this cluster. Every chunk is checked against
+// its hash before anything is stored.
+func (s *FileService) RestoreManifest(ctx context.Context, m *Manifest, chunkData map[string][]byte) (*metadata.FileRecord, error) {
+	if len(s.manifestKey) == 0 {
+		return nil, ErrManifestKeyMissing
+	}
+
+	expected, err := s.signature(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify manifest: %w", err)
+	}
+	if !hmac.Equal([]byte(expected), []byte(m.Signature)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidManifest)
+	}
+	if m.Format != ManifestFormat {
+		return nil, fmt.Errorf("%w: unsupported format %d", ErrInvalidManifest, m.Format)
+	}
+	var algorithm string
+	if m.Encryption != nil {
+		if algorithm, err = crypto.ParseAlgorithm(m.Encryption.Algorithm); err != nil {
+			return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidManifest, m.Encryption.Algorithm)
+		}
+	}
+	if _, err := hex.DecodeString(m.DedupSalt); err != nil {
+		return nil, fmt.Errorf("%w: invalid dedup salt", ErrInvalidManifest)
+	}
+
+	if _, err := s.db.GetFile(m.FileID); err == nil {
+		return nil, ErrFileExists
+	} else if !errors.Is(err, metadata.ErrFileNotFound) {
+		return nil, err
+	}
+
+	log.Printf("Restoring: %s (ID: %s, %d chunks)", m.FileName, m.FileID, len(m.Chunks))
+
+	chunks := make([]*chunking.Chunk, len(m.Chunks))
+	for i, entry := range m.Chunks {
+		data, ok := chunkData[entry.Hash]
+		if !ok {
+			data, err = s.backend.Get(ctx, entry.Hash)
+			if err != nil {
+				return nil, fmt.Errorf("%w: chunk %d (%s) not supplied and not stored: %v",
+					ErrInvalidManifest, i, entry.Hash[:min(len(entry.Hash), 8)], err)
+			}
+		}
+
+		algorithm, ok := chunking.MatchHash(data, entry.Hash)
+		if !ok || len(data) != entry.StoredSize {
+			return nil, fmt.Errorf("%w: chunk %d does not match its hash", ErrInvalidManifest, i)
+		}
+
+		chunks[i] = &chunking.Chunk{Data: data, Hash: entry.Hash, Algorithm: algorithm, Size: len(data), Offset: entry.Offset}
+	}
+
+	replication := m.Replication
+	if replication < 1 {
+		replication = ReplicationCount
+	}
+	newChunksStored, _, err := s.storeChunks(ctx, chunks, replication, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &metadata.FileRecord{
+		FileID:      m.FileID,
+		FileName:    m.FileName,
+		FileSize:    m.Size,
+		ContentType: m.ContentType,
+		Replication: replication,
+		FileHash:    m.FileHash,
+
+		ClientEncrypted:  m.ClientEncrypted,
+		ClientEncryption: m.ClientEncryption,
+		DedupSalt:        m.DedupSalt,
+	}
+	if m.Encryption != nil {
+		record.Encrypted = true
+		record.Salt = m.Encryption.Salt
+		record.NoncePrefix = m.Encryption.NoncePrefix
+		record.PasswordHash = importedPasswordHash(m.Encryption.PasswordHash)
+		record.EncryptionAlgorithm = algorithm
+	}
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = chunk.Hash
+	}
+	if err := s.db.CreateFile(record); err != nil {
+		s.abandonUpload(hashes)
+		return nil, fmt.Errorf("failed to save file metadata: %w", err)
+	}
+
+	for i, chunk := range chunks {
+		if err := s.db.LinkFileChunk(record.FileID, chunk.Hash, i, chunk.Offset); err != nil {
+			s.abandonFile(record.FileID, hashes[i:])
+			return nil, fmt.Errorf("failed to link file chunks: %w", err)
+		}
+	}
+
+	log.Printf("Restore complete: %d chunks, %d stored", len(chunks), newChunksStored)
+	return record, nil
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+const testManifestKey = "manifest-key"
+
+// exportManifest exports a stored file's manifest
+func exportManifest(t *testing.T, s *FileService, fileID, password string) *Manifest {
+	t.Helper()
+
+	d, err := s.DownloadFile(context.Background(), fileID, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	m, err := d.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// restoreService returns a FileService over an empty metadata store and
+// the given chunk store, as a cluster whose database was wiped
+func restoreService(t *testing.T, chunks *dedup.MemoryChunkStore) (*FileService, *metadata.MemoryStore) {
+	t.Helper()
+
+	ring, err := node.NewConsistentHash(node.DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := metadata.NewMemoryStore()
+	s := NewFileService(db, chunks, node.NewRegistry(testHeartbeatTimeout), ring)
+	s.UseManifestKey(testManifestKey)
+	return s, db
+}
+
+// TestManifestRoundTrip exports files' manifests, restores them once the
+// file rows are gone, and checks they download unchanged under their
+// original IDs, whether the chunk data comes with the manifest or is still
+// stored
+func TestManifestRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		password string
+		supply   bool // Send the chunk data with the manifest
+	}{
+		{"plain", "", false},
+		{"encrypted", "secret", false},
+		{"plain to a fresh cluster", "", true},
+		{"encrypted to a fresh cluster", "secret", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _, chunks := newTestService(t)
+			s.UseManifestKey(testManifestKey)
+			data := randomBytes(t, 3*chunking.MaxChunkSize)
+			result := upload(t, s, data, UploadMetadata{FileName: "archive.bin", Password: tc.password, ContentType: "application/x-test"})
+
+			m := exportManifest(t, s, result.FileID, tc.password)
+			if len(m.Chunks) != len(result.ChunkHashes) || m.Size != int64(len(data)) || (m.Encryption != nil) != (tc.password != "") {
+				t.Fatalf("manifest does not describe the file: %+v", m)
+			}
+
+			var chunkData map[string][]byte
+			restoreChunks := chunks
+			if tc.supply {
+				chunkData = make(map[string][]byte)
+				for _, chunk := range m.Chunks {
+					if chunkData[chunk.Hash], _ = chunks.GetChunk(chunk.Hash); chunkData[chunk.Hash] == nil {
+						t.Fatalf("chunk %s not stored", chunk.Hash[:8])
+					}
+				}
+				restoreChunks = dedup.NewMemoryChunkStore()
+			}
+
+			restored, db := restoreService(t, restoreChunks)
+			if _, err := restored.DownloadFile(context.Background(), result.FileID, tc.password); err == nil {
+				t.Fatal("file still stored before the restore")
+			}
+			record, err := restored.RestoreManifest(context.Background(), m, chunkData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.FileID != result.FileID || record.FileName != "archive.bin" || record.ContentType != "application/x-test" {
+				t.Errorf("restored the wrong file: %+v", record)
+			}
+			if got := download(t, restored, result.FileID, tc.password); !bytes.Equal(got, data) {
+				t.Error("restored file does not read back")
+			}
+			if tc.password != "" {
+				if _, err := restored.DownloadFile(context.Background(), result.FileID, "wrong"); !errors.Is(err, ErrIncorrectPassword) {
+					t.Errorf("want ErrIncorrectPassword with the wrong password, got %v", err)
+				}
+			}
+			for _, hash := range result.ChunkHashes {
+				if chunk, err := db.GetChunk(hash); err != nil || chunk.RefCount != 1 {
+					t.Errorf("chunk %s: want one reference after the restore, got %+v (%v)", hash[:8], chunk, err)
+				}
+			}
+
+			if _, err := restored.RestoreManifest(context.Background(), m, chunkData); !errors.Is(err, ErrFileExists) {
+				t.Errorf("restoring twice: want ErrFileExists, got %v", err)
+			}
+		})
+	}
+}
+
+// TestManifestRestoreRejected checks a restore needs a manifest signed with
+// the cluster's key and chunk data matching it
+func TestManifestRestoreRejected(t *testing.T) {
+	s, _, chunks := newTestService(t)
+	s.UseManifestKey(testManifestKey)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{})
+	m := exportManifest(t, s, result.FileID, "")
+
+	first, _ := chunks.GetChunk(m.Chunks[0].Hash)
+	for _, tc := range []struct {
+		name      string
+		key       string
+		tamper    func(m *Manifest)
+		chunkData map[string][]byte
+		want      error
+	}{
+		{"tampered", testManifestKey, func(m *Manifest) { m.FileName = "other.bin" }, nil, ErrInvalidManifest},
+		{"other key", "other-key", nil, nil, ErrInvalidManifest},
+		{"no key", "", nil, nil, ErrManifestKeyMissing},
+		{"missing chunks", testManifestKey, nil, nil, ErrInvalidManifest},
+		{"wrong chunk data", testManifestKey, nil, map[string][]byte{m.Chunks[1].Hash: first}, ErrInvalidManifest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			restored, db := restoreService(t, dedup.NewMemoryChunkStore())
+			restored.UseManifestKey(tc.key)
+
+			copied := *m
+			copied.Chunks = append([]ManifestChunk(nil), m.Chunks...)
+			if tc.tamper != nil {
+				tc.tamper(&copied)
+			}
+			if _, err := restored.RestoreManifest(context.Background(), &copied, tc.chunkData); !errors.Is(err, tc.want) {
+				t.Errorf("want %v, got %v", tc.want, err)
+			}
+			if _, err := db.GetFile(m.FileID); !errors.Is(err, metadata.ErrFileNotFound) {
+				t.Errorf("rejected restore created the file: %v", err)
+			}
+		})
+	}
+
+	s.UseManifestKey("")
+	d, err := s.DownloadFile(context.Background(), result.FileID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.Manifest(); !errors.Is(err, ErrManifestKeyMissing) {
+		t.Errorf("export without a key: want ErrManifestKeyMissing, got %v", err)
+	}
+}
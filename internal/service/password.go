@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+)
+
+// importedPasswordHash returns a password hash from an export or manifest
+// if it is a current verifier. Older exports carry plain SHA-256 hashes,
+// which are dropped; those files then rely on the AEAD check alone.
+func importedPasswordHash(hash string) string {
+	if !crypto.IsPasswordVerifier(hash) {
+		return ""
+	}
+	return hash
+}
+
+// VerifyFilePassword reports whether password unlocks an encrypted file,
+// without downloading it. The password is checked against the file's stored
+// hash, when it has one, and then by decrypting the file's first chunk.
+// Unencrypted files fail with ErrNotEncrypted.
+func (s *FileService) VerifyFilePassword(ctx context.Context, fileID, password string) (bool, error) {
+	download, err := s.DownloadFile(ctx, fileID, password)
+	if errors.Is(err, ErrIncorrectPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer download.Close()
+
+	if !download.File.Encrypted {
+		return false, ErrNotEncrypted
+	}
+	// An empty file has nothing to decrypt; its hash already matched
+	if len(download.ChunkHashes) == 0 {
+		return true, nil
+	}
+
+	_, err = download.readChunk(0, download.ChunkHashes[0])
+	if errors.Is(err, ErrDecryptionFailed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+func TestDownloadPassword(t *testing.T) {
+	s, _, _ := newTestService(t)
+	data := randomBytes(t, 200<<10)
+	result := upload(t, s, data, UploadMetadata{Password: "s3cret"})
+
+	if got := download(t, s, result.FileID, "s3cret"); !bytes.Equal(got, data) {
+		t.Error("download with the correct password returned different contents")
+	}
+
+	_, err := s.DownloadFile(context.Background(), result.FileID, "wrong")
+	if !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("wrong password: got %v, want ErrIncorrectPassword", err)
+	}
+
+	_, err = s.DownloadFile(context.Background(), result.FileID, "")
+	if !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("no password: got %v, want ErrPasswordRequired", err)
+	}
+}
+
+// hashlessStore serves files without their password hash, as files imported
+// from an older export are
+type hashlessStore struct {
+	*metadata.MemoryStore
+}
+
+func (h hashlessStore) GetFileWithChunks(fileID string) (*metadata.FileRecord, []metadata.ChunkDescriptor, error) {
+	file, chunks, err := h.MemoryStore.GetFileWithChunks(fileID)
+	if file != nil {
+		file.PasswordHash = ""
+	}
+	return file, chunks, err
+}
+
+// countingChunks counts the chunks read from a chunk store
+type countingChunks struct {
+	*dedup.MemoryChunkStore
+	reads atomic.Int64
+}
+
+func (c *countingChunks) GetChunk(hash string) ([]byte, error) {
+	c.reads.Add(1)
+	return c.MemoryChunkStore.GetChunk(hash)
+}
+
+// TestVerifyFilePassword checks correct and incorrect passwords against
+// encrypted files, with and without a stored password hash and with no
+// chunks at all, and that a check reads no more than the first chunk
+func TestVerifyFilePassword(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		db   MetadataStore
+		size int
+	}{
+		{"hashed", metadata.NewMemoryStore(), 3 * chunking.MaxChunkSize},
+		{"decrypted", hashlessStore{metadata.NewMemoryStore()}, 3 * chunking.MaxChunkSize},
+		{"empty", metadata.NewMemoryStore(), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ring, err := node.NewConsistentHash(node.DefaultVirtualNodesPerNode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks := &countingChunks{MemoryChunkStore: dedup.NewMemoryChunkStore()}
+			s := NewFileService(tc.db, chunks, node.NewRegistry(testHeartbeatTimeout), ring)
+			result := upload(t, s, randomBytes(t, tc.size), UploadMetadata{Password: "s3cret"})
+
+			for password, want := range map[string]bool{"s3cret": true, "wrong": false} {
+				chunks.reads.Store(0)
+				ok, err := s.VerifyFilePassword(context.Background(), result.FileID, password)
+				if err != nil {
+					t.Fatalf("%q: %v", password, err)
+				}
+				if ok != want {
+					t.Errorf("%q: got %v, want %v", password, ok, want)
+				}
+				if reads := chunks.reads.Load(); reads > 1 {
+					t.Errorf("%q: want at most the first chunk read, read %d", password, reads)
+				}
+			}
+		})
+	}
+
+	s, _, _ := newTestService(t)
+	plain := upload(t, s, randomBytes(t, 1000), UploadMetadata{})
+	if _, err := s.VerifyFilePassword(context.Background(), plain.FileID, "s3cret"); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("unencrypted file: want ErrNotEncrypted, got %v", err)
+	}
+	if _, err := s.VerifyFilePassword(context.Background(), "unknown-file", "s3cret"); !errors.Is(err, metadata.ErrFileNotFound) {
+		t.Errorf("unknown file: want ErrFileNotFound, got %v", err)
+	}
+}
+
+func TestImportedPasswordHashDropsLegacyHashes(t *testing.T) {
+	if got := importedPasswordHash("5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"); got != "" {
+		t.Errorf("legacy hash kept: %q", got)
+	}
+	if got := importedPasswordHash("hmac:00"); got != "hmac:00" {
+		t.Errorf("verifier dropped: %q", got)
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// FailedPlacements returns the replicated chunks queued because fewer nodes
+// than their replication hold them, oldest first
+func (s *FileService) FailedPlacements() ([]metadata.FailedPlacement, error) {
+	return s.db.ListFailedPlacements()
+}
+
+// queueFailedPlacement records a newly stored chunk that fewer than
+// replication nodes acknowledged, so RetryFailedPlacements tops it up
+// instead of the shortfall going unnoticed
+func (s *FileService) queueFailedPlacement(chunkHash string, replication int, storedOn []string) {
+	intended := s.missingReplicas(chunkHash, replication, storedOn)
+	reason := fmt.Sprintf("stored on %d of %d nodes", len(storedOn), replication)
+	if s.replicationMode == ReplicationAsync {
+		reason += ", awaiting asynchronous replication"
+	}
+
+	if err := s.db.RecordFailedPlacement(chunkHash, intended, reason); err != nil {
+		log.Printf("Failed to queue under-replicated chunk %s: %v", chunkHash[:8], err)
+		return
+	}
+	log.Printf("Queued chunk %s for another placement attempt: %s", chunkHash[:8], reason)
+}
+
+// RetryFailedPlacements makes another attempt at every queued chunk,
+// copying it to healthy nodes the way ReplicateFile does. Chunks that reach
+// their replication leave the queue; the rest record the attempt. It
+// returns how many chunks were placed and how many remain queued.
+func (s *FileService) RetryFailedPlacements(ctx context.Context) (int, int, error) {
+	queued, err := s.db.ListFailedPlacements()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(queued) == 0 {
+		return 0, 0, nil
+	}
+	return s.retryPlacements(ctx, queued)
+}
+
+// replicateQueued makes the first placement attempt at chunks an async
+// upload just queued, instead of leaving them to the next retry. Chunks it
+// can't place stay queued for RetryFailedPlacements.
+func (s *FileService) replicateQueued(hashes []string) {
+	queued := make([]metadata.FailedPlacement, len(hashes))
+	for i, hash := range hashes {
+		queued[i] = metadata.FailedPlacement{ChunkHash: hash}
+	}
+
+	placed, remaining, err := s.retryPlacements(context.Background(), queued)
+	if err != nil {
+		log.Printf("Asynchronous replication failed: %v", err)
+		return
+	}
+	log.Printf("Asynchronous replication: %d chunks fully replicated, %d still queued", placed, remaining)
+}
+
+// retryPlacements makes a placement attempt at each queued chunk
+func (s *FileService) retryPlacements(ctx context.Context, queued []metadata.FailedPlacement) (int, int, error) {
+	hashes := make([]string, len(queued))
+	for i, placement := range queued {
+		hashes[i] = placement.ChunkHash
+	}
+	records, err := s.db.GetChunks(hashes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to look up chunks: %w", err)
+	}
+	locations, err := s.db.GetChunkLocations(hashes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to look up chunk locations: %w", err)
+	}
+
+	healthy := make(map[string]*node.NodeInfo)
+	for _, nodeInfo := range s.registry.GetHealthyNodes() {
+		healthy[nodeInfo.NodeID] = nodeInfo
+	}
+
+	placed, remaining := 0, 0
+	for i, placement := range queued {
+		if err := ctx.Err(); err != nil {
+			return placed, len(queued) - i + remaining, err
+		}
+
+		hash := placement.ChunkHash
+		record := records[hash]
+		if record == nil {
+			// Purged since it was queued
+			s.clearFailedPlacement(hash)
+			continue
+		}
+
+		replica := ChunkReplica{Hash: hash, Required: max(record.Replication, 1), Holders: []string{}}
+		if recordedOnAll(locations[hash], healthy) {
+			// Checking the holders again can't help until another node
+			// joins, and reads every copy
+			replica.Holders = locations[hash]
+			replica.Error = "no other healthy node to copy it to"
+		} else {
+			s.replicateChunk(ctx, &replica, locations[hash], healthy)
+		}
+
+		if replica.Status == ReplicaOK || replica.Status == ReplicaRestored {
+			s.clearFailedPlacement(hash)
+			placed++
+			log.Printf("Placed queued chunk %s after %d attempts", hash[:8], placement.Attempts+1)
+			continue
+		}
+
+		remaining++
+		holders := append(replica.Holders, replica.Added...)
+		intended := s.missingReplicas(hash, replica.Required, holders)
+		if err := s.db.RecordPlacementAttempt(hash, intended, replica.Error); err != nil {
+			log.Printf("Failed to record placement attempt for chunk %s: %v", hash[:8], err)
+		}
+	}
+
+	return placed, remaining, nil
+}
+
+// clearFailedPlacement takes a chunk that holds all its replicas off the
+// queue. Most chunks were never queued, which is fine.
+func (s *FileService) clearFailedPlacement(chunkHash string) {
+	if err := s.db.DeleteFailedPlacement(chunkHash); err != nil {
+		log.Printf("Failed to dequeue chunk %s: %v", chunkHash[:8], err)
+	}
+}
+
+// recordedOnAll reports whether every healthy node is recorded holding a chunk
+func recordedOnAll(recorded []string, healthy map[string]*node.NodeInfo) bool {
+	holding := 0
+	for _, nodeID := range recorded {
+		if healthy[nodeID] != nil {
+			holding++
+		}
+	}
+	return holding == len(healthy)
+}
+
+// missingReplicas returns the nodes the ring places a chunk's replicas on
+// that aren't among holders
+func (s *FileService) missingReplicas(chunkHash string, replication int, holders []string) []string {
+	targets, err := s.ring.GetNodes(chunkHash, replication)
+	if err != nil {
+		return nil
+	}
+
+	holding := make(map[string]bool, len(holders))
+	for _, nodeID := range holders {
+		holding[nodeID] = true
+	}
+
+	missing := []string{}
+	for _, nodeID := range targets {
+		if !holding[nodeID] {
+			missing = append(missing, nodeID)
+		}
+	}
+	return missing
+}
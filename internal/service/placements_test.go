@@ -0,0 +1,76 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+func retryFailedPlacements(t *testing.T, s *FileService) (int, int) {
+	t.Helper()
+
+	placed, remaining, err := s.RetryFailedPlacements(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return placed, remaining
+}
+
+// TestRetryFailedPlacements uploads with one of a file's two replica nodes
+// failing every store, and checks its chunks are queued and retried without
+// success until another node joins, when the retry places them all
+func TestRetryFailedPlacements(t *testing.T) {
+	s, _, _ := newTestCluster(t, 1)
+	failingNode(t, s, "node-broken")
+
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Replication: 2})
+	chunks := len(result.ChunkHashes)
+
+	queued, err := s.FailedPlacements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != chunks {
+		t.Fatalf("want all %d chunks queued, got %d", chunks, len(queued))
+	}
+	for _, placement := range queued {
+		if !reflect.DeepEqual(placement.IntendedNodes, []string{"node-broken"}) || placement.Reason != "stored on 1 of 2 nodes" {
+			t.Errorf("chunk %s: want it queued for node-broken, got %+v", placement.ChunkHash[:8], placement)
+		}
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if placed, remaining := retryFailedPlacements(t, s); placed != 0 || remaining != chunks {
+			t.Fatalf("attempt %d with no node to take them: want %d chunks still queued, got %d placed and %d remaining", attempt, chunks, placed, remaining)
+		}
+	}
+	queued, _ = s.FailedPlacements()
+	for _, placement := range queued {
+		if placement.Attempts != 2 || placement.LastAttemptAt == nil {
+			t.Errorf("chunk %s: want 2 attempts recorded, got %+v", placement.ChunkHash[:8], placement)
+		}
+	}
+
+	joined := startTestNode(t, s, "node-2", nil)
+	if placed, remaining := retryFailedPlacements(t, s); placed != chunks || remaining != 0 {
+		t.Fatalf("want all %d chunks placed on the new node, got %d placed and %d remaining", chunks, placed, remaining)
+	}
+	if queued, _ := s.FailedPlacements(); len(queued) != 0 {
+		t.Errorf("want the queue empty, got %v", queued)
+	}
+	for _, hash := range result.ChunkHashes {
+		if !nodeHolds(t, joined, hash) {
+			t.Errorf("chunk %s not copied to the new node", hash[:8])
+		}
+	}
+	if placed, remaining := retryFailedPlacements(t, s); placed != 0 || remaining != 0 {
+		t.Errorf("empty queue: want nothing to retry, got %d placed and %d remaining", placed, remaining)
+	}
+	if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+		t.Error("download differs")
+	}
+}
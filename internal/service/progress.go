@@ -0,0 +1,83 @@
+package service
+
+// Upload stages reported in UploadProgress
+const (
+	StageChunking = "chunking" // Reading the file; full batches are stored as it goes
+	StageStoring  = "storing"  // Storing the last batch
+	StageComplete = "complete"
+)
+
+// UploadProgress is a snapshot of an upload in flight
+type UploadProgress struct {
+	Stage        string `json:"stage"`
+	TotalBytes   int64  `json:"total_bytes"`
+	BytesChunked int64  `json:"bytes_chunked"`
+	TotalChunks  int    `json:"total_chunks"`  // Chunks found so far while chunking
+	ChunksDone   int    `json:"chunks_done"`   // Chunks stored or deduplicated
+	ChunksStored int    `json:"chunks_stored"` // Chunks that were new
+	DedupHits    int    `json:"dedup_hits"`    // Chunks that were already stored
+}
+
+// ProgressFunc receives progress snapshots. It is called synchronously from
+// the upload, so it should return quickly.
+type ProgressFunc func(UploadProgress)
+
+// progressTracker accumulates an upload's progress and reports each change.
+// A nil tracker ignores all updates.
+type progressTracker struct {
+	progress UploadProgress
+	report   ProgressFunc
+}
+
+func newProgressTracker(totalBytes int64, report ProgressFunc) *progressTracker {
+	if report == nil {
+		return nil
+	}
+	return &progressTracker{
+		progress: UploadProgress{Stage: StageChunking, TotalBytes: totalBytes},
+		report:   report,
+	}
+}
+
+func (t *progressTracker) chunked(size int) {
+	if t == nil {
+		return
+	}
+	t.progress.BytesChunked += int64(size)
+	t.progress.TotalChunks++
+	t.report(t.progress)
+}
+
+func (t *progressTracker) stage(stage string) {
+	if t == nil {
+		return
+	}
+	t.progress.Stage = stage
+	t.report(t.progress)
+}
+
+func (t *progressTracker) stored(isNew bool) {
+	if t == nil {
+		return
+	}
+	t.progress.ChunksDone++
+	if isNew {
+		t.progress.ChunksStored++
+	} else {
+		t.progress.DedupHits++
+	}
+	t.report(t.progress)
+}
+
+// reused records that the whole file matched a stored one, so all of its
+// chunks were deduplicated without being chunked again
+func (t *progressTracker) reused(size int64, chunks int) {
+	if t == nil {
+		return
+	}
+	t.progress.BytesChunked += size
+	t.progress.TotalChunks += chunks
+	t.progress.ChunksDone += chunks
+	t.progress.DedupHits += chunks
+	t.report(t.progress)
+}
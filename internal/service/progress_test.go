@@ -0,0 +1,59 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// uploadWithProgress uploads data and returns every progress snapshot
+func uploadWithProgress(t *testing.T, s *FileService, data []byte) (*UploadResult, []UploadProgress) {
+	t.Helper()
+
+	var events []UploadProgress
+	result := upload(t, s, data, UploadMetadata{Progress: func(progress UploadProgress) {
+		events = append(events, progress)
+	}})
+	return result, events
+}
+
+// TestUploadProgress drives an upload and checks its progress only moves
+// forward, accounts for every byte and chunk, and ends complete; uploading
+// the same data again reports every chunk as a dedup hit
+func TestUploadProgress(t *testing.T) {
+	s, _, _ := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+
+	result, events := uploadWithProgress(t, s, data)
+	if len(events) == 0 {
+		t.Fatal("no progress reported")
+	}
+
+	var prev UploadProgress
+	for i, event := range events {
+		if event.TotalBytes != int64(len(data)) {
+			t.Fatalf("event %d: want total %d bytes, got %d", i, len(data), event.TotalBytes)
+		}
+		if event.BytesChunked < prev.BytesChunked || event.TotalChunks < prev.TotalChunks || event.ChunksDone < prev.ChunksDone {
+			t.Fatalf("event %d went backwards: %+v after %+v", i, event, prev)
+		}
+		if event.ChunksDone > event.TotalChunks || event.ChunksStored+event.DedupHits != event.ChunksDone {
+			t.Fatalf("event %d does not add up: %+v", i, event)
+		}
+		prev = event
+	}
+
+	last := events[len(events)-1]
+	chunks := len(result.ChunkHashes)
+	if last.Stage != StageComplete || last.BytesChunked != int64(len(data)) || last.TotalChunks != chunks || last.ChunksDone != chunks {
+		t.Errorf("want %d bytes and %d chunks done at the end, got %+v", len(data), chunks, last)
+	}
+	if last.ChunksStored != chunks || last.DedupHits != 0 {
+		t.Errorf("want every chunk stored new, got %+v", last)
+	}
+
+	_, events = uploadWithProgress(t, s, data)
+	if last := events[len(events)-1]; last.DedupHits != chunks || last.ChunksStored != 0 {
+		t.Errorf("second upload: want all %d chunks deduplicated, got %+v", chunks, last)
+	}
+}
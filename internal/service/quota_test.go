@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// checkPlacedAround checks every chunk of an upload is recorded on
+// replication nodes, none of them full, and that full holds none of them
+// though the ring places some there, and the file reads back
+func checkPlacedAround(t *testing.T, s *FileService, db *metadata.MemoryStore, full *node.StorageNode, result *UploadResult, data []byte, replication int) {
+	t.Helper()
+
+	if result.MinReplicas != replication {
+		t.Errorf("want every chunk on %d nodes, got min_replicas %d", replication, result.MinReplicas)
+	}
+	locations, err := db.GetChunkLocations(result.ChunkHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onFull := 0
+	for i, hash := range result.ChunkHashes {
+		ringNodes, err := s.ring.GetNodes(hash, replication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.Contains(ringNodes, full.NodeID) {
+			onFull++
+		}
+		if len(locations[hash]) != replication {
+			t.Errorf("chunk %d: want it recorded on %d nodes, got %v", i, replication, locations[hash])
+		}
+		if slices.Contains(locations[hash], full.NodeID) || nodeHolds(t, full, hash) {
+			t.Errorf("chunk %d: stored on the full node", i)
+		}
+	}
+	if onFull == 0 {
+		t.Error("the ring places no chunk on the full node, so none was routed around it")
+	}
+	if !bytes.Equal(download(t, s, result.FileID, ""), data) {
+		t.Error("download differs from upload")
+	}
+}
+
+// TestPlacementSkipsFullNodes has a node's heartbeat report it full and
+// checks new chunks are placed on the other nodes instead
+func TestPlacementSkipsFullNodes(t *testing.T) {
+	s, db, nodes := newTestCluster(t, 3)
+	full := nodes[0]
+	if err := s.registry.(*node.Registry).UpdateHeartbeat(full.NodeID, 10, chunking.MaxChunkSize, chunking.MaxChunkSize); err != nil {
+		t.Fatal(err)
+	}
+
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Replication: 2})
+	checkPlacedAround(t, s, db, full, result, data, 2)
+}
+
+// TestStoreRoutedAroundNodeAtQuota fills a node to its quota before the
+// coordinator hears of it, and checks the chunks it refuses are stored on
+// the next nodes along the ring instead
+func TestStoreRoutedAroundNodeAtQuota(t *testing.T) {
+	s, db, _ := newTestService(t)
+	full := startTestNode(t, s, "node-1", func(sn *node.StorageNode) { sn.Quota = 1 })
+	startTestNode(t, s, "node-2", nil)
+	startTestNode(t, s, "node-3", nil)
+	if err := s.UseWriteQuorum(2); err != nil {
+		t.Fatal(err)
+	}
+
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Replication: 2})
+	checkPlacedAround(t, s, db, full, result, data, 2)
+}
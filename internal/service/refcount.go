@@ -0,0 +1,55 @@
+package service
+
+import (
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// ChunkRelease is the outcome of dropping a chunk reference by hand
+type ChunkRelease struct {
+	Chunk   metadata.ChunkRecord `json:"chunk"`   // The chunk after the release
+	Deleted bool                 `json:"deleted"` // That was the last reference and the chunk is gone
+}
+
+// ChunkInfo returns a chunk's metadata, including its reference count
+func (s *FileService) ChunkInfo(chunkHash string) (*metadata.ChunkRecord, error) {
+	return s.db.GetChunk(chunkHash)
+}
+
+// ReleaseChunk drops one reference to a chunk, for correcting a reference
+// count that drifted above the files actually using it. A chunk left
+// unreferenced is deleted from the metadata store and every backend, even if
+// files still link to it, so only release references nothing holds.
+func (s *FileService) ReleaseChunk(chunkHash string) (*ChunkRelease, error) {
+	chunk, err := s.db.GetChunk(chunkHash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.storing.Lock()
+	defer s.storing.Unlock()
+
+	orphaned, err := s.db.ReleaseChunks([]string{chunkHash})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(orphaned) > 0 {
+		for _, released := range orphaned {
+			s.deleteChunkData(released)
+		}
+		log.Printf("Released last reference to chunk %s; deleted it", chunkHash[:8])
+
+		chunk.RefCount = 0
+		return &ChunkRelease{Chunk: *chunk, Deleted: true}, nil
+	}
+
+	chunk, err = s.db.GetChunk(chunkHash)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Released a reference to chunk %s; %d left", chunkHash[:8], chunk.RefCount)
+
+	return &ChunkRelease{Chunk: *chunk}, nil
+}
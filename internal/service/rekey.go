@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// RekeyFile re-encrypts an encrypted file under a new password. Every chunk
+// is decrypted with the old key and re-encrypted with a key derived from a
+// fresh salt; the file keeps its ID. The new chunks are stored first, then
+// the file is switched to them and its old chunks released in one step, so
+// a failure leaves the file readable with the old password.
+func (s *FileService) RekeyFile(ctx context.Context, fileID, oldPassword, newPassword string) (*metadata.FileRecord, error) {
+	if newPassword == "" {
+		return nil, ErrPasswordRequired
+	}
+
+	download, err := s.DownloadFile(ctx, fileID, oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	defer download.Close()
+	if !download.File.Encrypted {
+		return nil, ErrNotEncrypted
+	}
+
+	fileChunks := download.Chunks
+
+	key, err := crypto.DeriveKey(newPassword, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	defer key.Zero()
+	key.Algorithm = download.File.EncryptionAlgorithm // Rekeying keeps the algorithm
+	noncePrefix, err := crypto.NewNoncePrefix()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce prefix: %w", err)
+	}
+
+	log.Printf("Rekeying: %s (ID: %s, %d chunks)", download.File.FileName, fileID, len(fileChunks))
+
+	chunks := make([]*chunking.Chunk, len(fileChunks))
+	rekey := &metadata.RekeyedFile{
+		Salt:         hex.EncodeToString(key.Salt),
+		PasswordHash: crypto.PasswordVerifier(key),
+		NoncePrefix:  hex.EncodeToString(noncePrefix),
+		Chunks:       make([]metadata.FileChunk, len(fileChunks)),
+	}
+
+	for i, fileChunk := range fileChunks {
+		plaintext, err := download.readChunk(i, fileChunk.ChunkHash)
+		if err != nil {
+			return nil, err
+		}
+
+		encrypted, err := crypto.EncryptChunkAt(plaintext, key, noncePrefix, uint64(i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt chunk %d: %w", i, err)
+		}
+
+		chunks[i] = &chunking.Chunk{
+			Data:      encrypted,
+			Hash:      s.hashAlgorithm.Sum(encrypted),
+			Algorithm: s.hashAlgorithm,
+			Size:      len(encrypted),
+			Offset:    fileChunk.Offset,
+		}
+		rekey.Chunks[i] = metadata.FileChunk{ChunkHash: chunks[i].Hash, Offset: fileChunk.Offset}
+	}
+
+	if _, _, err := s.storeChunks(ctx, chunks, max(download.File.Replication, 1), nil); err != nil {
+		return nil, err
+	}
+
+	// The old chunks are freed with uploads held off, as in PurgeFile
+	s.storing.Lock()
+	orphaned, err := s.db.RekeyFile(fileID, rekey)
+	if err == nil {
+		for _, chunk := range orphaned {
+			s.deleteChunkData(chunk)
+		}
+	}
+	s.storing.Unlock()
+	if err != nil {
+		// The new chunks were never linked, so only their references go
+		hashes := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			hashes[i] = chunk.Hash
+		}
+		s.abandonUpload(hashes)
+		return nil, fmt.Errorf("failed to switch file to new key: %w", err)
+	}
+
+	log.Printf("Rekeyed file %s (%d old chunks freed)", fileID, len(orphaned))
+	return s.db.GetFile(fileID)
+}
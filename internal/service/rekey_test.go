@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// TestRekeyFile rekeys a file and checks it downloads with the new password
+// but not the old, under a new salt, with its old chunks released
+func TestRekeyFile(t *testing.T) {
+	s, db, chunks := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Password: "old"})
+
+	before, err := db.GetFile(result.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := s.RekeyFile(context.Background(), result.FileID, "old", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.FileID != result.FileID {
+		t.Errorf("want the file to keep ID %s, got %s", result.FileID, file.FileID)
+	}
+	if file.Salt == before.Salt || file.NoncePrefix == before.NoncePrefix {
+		t.Error("want a new salt and nonce prefix")
+	}
+
+	if got := download(t, s, result.FileID, "new"); !bytes.Equal(got, data) {
+		t.Error("file does not download with the new password")
+	}
+	if _, err := s.DownloadFile(context.Background(), result.FileID, "old"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("old password: want ErrIncorrectPassword, got %v", err)
+	}
+
+	// The chunks encrypted under the old key are gone
+	for _, hash := range result.ChunkHashes {
+		if chunks.HasChunk(hash) {
+			t.Errorf("old chunk %s still stored", hash[:8])
+		}
+		if _, err := db.GetChunk(hash); err == nil {
+			t.Errorf("old chunk %s still recorded", hash[:8])
+		}
+	}
+	_, fileChunks, err := db.GetFileWithChunks(result.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fileChunks) != len(result.ChunkHashes) {
+		t.Errorf("want %d chunks linked, got %d", len(result.ChunkHashes), len(fileChunks))
+	}
+	for _, chunk := range fileChunks {
+		if !chunks.HasChunk(chunk.ChunkHash) {
+			t.Errorf("new chunk %s not stored", chunk.ChunkHash[:8])
+		}
+	}
+}
+
+// TestRekeyFileRejected checks rekeying needs the right old password, a new
+// password and an encrypted file, and leaves the file untouched otherwise
+func TestRekeyFileRejected(t *testing.T) {
+	s, _, _ := newTestService(t)
+	data := randomBytes(t, 1000)
+	encrypted := upload(t, s, data, UploadMetadata{Password: "old"})
+	plain := upload(t, s, data, UploadMetadata{})
+
+	for _, tc := range []struct {
+		name        string
+		fileID      string
+		oldPassword string
+		newPassword string
+		want        error
+	}{
+		{"wrong old password", encrypted.FileID, "wrong", "new", ErrIncorrectPassword},
+		{"no new password", encrypted.FileID, "old", "", ErrPasswordRequired},
+		{"plain file", plain.FileID, "", "new", ErrNotEncrypted},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := s.RekeyFile(context.Background(), tc.fileID, tc.oldPassword, tc.newPassword); !errors.Is(err, tc.want) {
+				t.Errorf("want %v, got %v", tc.want, err)
+			}
+		})
+	}
+
+	if got := download(t, s, encrypted.FileID, "old"); !bytes.Equal(got, data) {
+		t.Error("file no longer downloads with the old password")
+	}
+}
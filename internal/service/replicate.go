@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// Outcomes of re-replicating one chunk of a file
+const (
+	ReplicaOK       = "ok"       // Already on enough healthy nodes
+	ReplicaRestored = "restored" // Copied until it was
+	ReplicaPartial  = "partial"  // Copied, but too few nodes accepted it
+	ReplicaFailed   = "failed"   // No good copy could be read, or no node accepted it
+	ReplicaSkipped  = "skipped"  // Erasure-coded or stored locally; see Error
+)
+
+// ChunkReplica is what re-replicating one chunk of a file found and did
+type ChunkReplica struct {
+	Index    int      `json:"index"`
+	Hash     string   `json:"hash"`
+	Status   string   `json:"status"`          // One of the Replica* constants
+	Required int      `json:"required"`        // Copies the chunk should have
+	Holders  []string `json:"holders"`         // Healthy nodes found holding it beforehand
+	Added    []string `json:"added,omitempty"` // Nodes it was copied to
+	Error    string   `json:"error,omitempty"` // Why it was skipped or fell short
+}
+
+// ReplicateReport is the result of re-replicating a file's chunks
+type ReplicateReport struct {
+	FileID      string         `json:"file_id"`
+	FileName    string         `json:"file_name"`
+	Replication int            `json:"replication"`
+	Restored    int            `json:"chunks_restored"`
+	Failed      int            `json:"chunks_failed"` // Failed or partial
+	CopiesAdded int            `json:"copies_added"`
+	Chunks      []ChunkReplica `json:"chunks"`
+}
+
+// ReplicateFile brings every replicated chunk of a file back to its
+// replication on healthy nodes right away, instead of waiting for reads or
+// verification to notice. Holders are the recorded locations and ring
+// placement, confirmed by asking each healthy one. Missing copies go to the
+// healthy nodes the ring places the chunk on, then to the next ones in ring
+// order, which take over its replicas once dead nodes are deregistered.
+// Erasure-coded and locally stored chunks are left alone. Chunks that end
+// up fully replicated leave the failed-placement queue.
+func (s *FileService) ReplicateFile(ctx context.Context, fileID string) (*ReplicateReport, error) {
+	file, fileChunks, err := s.db.GetFileWithChunks(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(fileChunks))
+	for i, chunk := range fileChunks {
+		hashes[i] = chunk.ChunkHash
+	}
+	records, err := s.db.GetChunks(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunks: %w", err)
+	}
+	locations, err := s.db.GetChunkLocations(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunk locations: %w", err)
+	}
+
+	healthy := make(map[string]*node.NodeInfo)
+	for _, nodeInfo := range s.registry.GetHealthyNodes() {
+		healthy[nodeInfo.NodeID] = nodeInfo
+	}
+
+	report := &ReplicateReport{
+		FileID:      file.FileID,
+		FileName:    file.FileName,
+		Replication: file.Replication,
+		Chunks:      make([]ChunkReplica, len(fileChunks)),
+	}
+
+	for i, chunk := range fileChunks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		replica := ChunkReplica{Index: i, Hash: chunk.ChunkHash, Holders: []string{}}
+
+		record := records[chunk.ChunkHash]
+		if record == nil {
+			return nil, fmt.Errorf("chunk %d (%s) has no metadata", i, chunk.ChunkHash[:8])
+		}
+		coding, err := s.db.GetChunkCoding(chunk.ChunkHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up coding of chunk %d: %w", i, err)
+		}
+
+		switch {
+		case coding != nil:
+			replica.Status = ReplicaSkipped
+			replica.Required = coding.DataShards + coding.ParityShards
+			replica.Error = "erasure-coded; missing shards are rebuilt when the chunk is read"
+		case !strings.HasPrefix(record.StoragePath, "distributed:"):
+			replica.Status = ReplicaSkipped
+			replica.Required = 1
+			replica.Error = "stored on the coordinator, not on nodes"
+		default:
+			replica.Required = max(record.Replication, 1)
+			s.replicateChunk(ctx, &replica, locations[chunk.ChunkHash], healthy)
+		}
+
+		switch replica.Status {
+		case ReplicaOK:
+			s.clearFailedPlacement(chunk.ChunkHash)
+		case ReplicaRestored:
+			s.clearFailedPlacement(chunk.ChunkHash)
+			report.Restored++
+		case ReplicaPartial, ReplicaFailed:
+			report.Failed++
+		}
+		report.CopiesAdded += len(replica.Added)
+		report.Chunks[i] = replica
+	}
+
+	log.Printf("Re-replicated %s: %d chunks restored, %d copies added, %d chunks short",
+		fileID, report.Restored, report.CopiesAdded, report.Failed)
+	return report, nil
+}
+
+// replicateChunk finds which healthy nodes hold a chunk and copies it to
+// more until replica.Required do, filling in replica
+func (s *FileService) replicateChunk(ctx context.Context, replica *ChunkReplica, recorded []string, healthy map[string]*node.NodeInfo) {
+	hash := replica.Hash
+
+	// GetNodes caps count at the size of the ring, so this is every node in
+	// ring order, the chunk's own replicas first
+	ringOrder, err := s.ring.GetNodes(hash, math.MaxInt)
+	if err != nil {
+		replica.Status = ReplicaFailed
+		replica.Error = err.Error()
+		return
+	}
+
+	candidates := uniqueSorted(append(append([]string{}, recorded...), ringOrder[:min(replica.Required, len(ringOrder))]...))
+	holding := make(map[string]bool)
+	for _, nodeID := range candidates {
+		nodeInfo := healthy[nodeID]
+		if nodeInfo == nil {
+			continue
+		}
+		held, err := s.nodeHasChunk(ctx, nodeInfo, hash)
+		if err != nil {
+			log.Printf("Replicate: failed to check chunk %s on node %s: %v", hash[:8], nodeID, err)
+			continue
+		}
+		if held {
+			holding[nodeID] = true
+			replica.Holders = append(replica.Holders, nodeID)
+		}
+	}
+
+	if len(replica.Holders) >= replica.Required {
+		replica.Status = ReplicaOK
+		return
+	}
+
+	chunkData, err := s.readReplica(ctx, hash, replica.Holders)
+	if err != nil {
+		replica.Status = ReplicaFailed
+		replica.Error = err.Error()
+		return
+	}
+
+	tried := 0
+	for _, nodeID := range ringOrder {
+		if len(replica.Holders)+len(replica.Added) >= replica.Required {
+			break
+		}
+		if holding[nodeID] || healthy[nodeID] == nil {
+			continue
+		}
+		tried++
+		if err := s.backgroundStore(ctx, hash, chunkData, nodeID); err != nil {
+			log.Printf("Replicate: failed to copy chunk %s to node %s: %v", hash[:8], nodeID, err)
+			continue
+		}
+		replica.Added = append(replica.Added, nodeID)
+	}
+
+	if len(replica.Added) > 0 {
+		if err := s.db.AddChunkLocations(hash, replica.Added); err != nil {
+			log.Printf("Replicate: failed to record locations of chunk %s: %v", hash[:8], err)
+		}
+	}
+
+	switch {
+	case len(replica.Holders)+len(replica.Added) >= replica.Required:
+		replica.Status = ReplicaRestored
+	case len(replica.Added) > 0:
+		replica.Status = ReplicaPartial
+		replica.Error = fmt.Sprintf("only %d healthy nodes hold it", len(replica.Holders)+len(replica.Added))
+	case tried == 0:
+		replica.Status = ReplicaFailed
+		replica.Error = "no other healthy node to copy it to"
+	default:
+		replica.Status = ReplicaFailed
+		replica.Error = "no healthy node accepted a copy"
+	}
+}
+
+// readReplica reads a good copy of a chunk from one of its holders, falling
+// back to wherever else it can still be read from
+func (s *FileService) readReplica(ctx context.Context, hash string, holders []string) ([]byte, error) {
+	for _, nodeID := range holders {
+		chunkData, err := s.backgroundRead(ctx, hash, nodeID)
+		if err != nil {
+			log.Printf("Replicate: failed to read chunk %s from node %s: %v", hash[:8], nodeID, err)
+			continue
+		}
+		if _, ok := chunking.MatchHash(chunkData, hash); ok {
+			return chunkData, nil
+		}
+		log.Printf("Replicate: copy of chunk %s on node %s is corrupted", hash[:8], nodeID)
+	}
+
+	chunkData, err := s.backgroundRetrieve(ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("no copy left: %w", err)
+	}
+	if _, ok := chunking.MatchHash(chunkData, hash); !ok {
+		return nil, fmt.Errorf("only copy left is corrupted")
+	}
+	return chunkData, nil
+}
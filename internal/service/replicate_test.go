@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+func replicateFile(t *testing.T, s *FileService, fileID string) *ReplicateReport {
+	t.Helper()
+
+	report, err := s.ReplicateFile(context.Background(), fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// TestReplicateFile loses one copy of a chunk to deletion and one of
+// another to a node going offline, and checks re-replicating the file copies
+// each back onto a healthy node and leaves the rest alone
+func TestReplicateFile(t *testing.T) {
+	s, db, nodes := newTestCluster(t, 4)
+	byID := make(map[string]*node.StorageNode, len(nodes))
+	for _, sn := range nodes {
+		byID[sn.NodeID] = sn
+	}
+	result := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{Replication: 2})
+
+	report := replicateFile(t, s, result.FileID)
+	if report.Restored != 0 || report.Failed != 0 || report.CopiesAdded != 0 {
+		t.Fatalf("fully replicated file: want nothing to do, got %+v", report)
+	}
+	for _, chunk := range report.Chunks {
+		if chunk.Status != ReplicaOK || chunk.Required != 2 || len(chunk.Holders) != 2 {
+			t.Errorf("chunk %d: want ok on 2 nodes, got %+v", chunk.Index, chunk)
+		}
+	}
+
+	locations, err := db.GetChunkLocations(result.ChunkHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, offline := locations[result.ChunkHashes[0]][0], ""
+	if err := node.NewNodeClient(http.DefaultClient, "http://"+byID[deleted].Address).Delete(context.Background(), result.ChunkHashes[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Take offline a node not holding the first chunk, so no chunk loses
+	// both copies
+	for nodeID := range byID {
+		if !slices.Contains(locations[result.ChunkHashes[0]], nodeID) {
+			offline = nodeID
+			break
+		}
+	}
+	takeOffline(t, s, offline)
+
+	report = replicateFile(t, s, result.FileID)
+	if report.Failed != 0 || report.Restored == 0 || report.CopiesAdded != report.Restored {
+		t.Errorf("want each damaged chunk restored with one copy, got %+v", report)
+	}
+	for i, chunk := range report.Chunks {
+		// The deleted copy was only of the first chunk, but every chunk on
+		// the offline node lost a copy
+		damaged := i == 0
+		for _, nodeID := range locations[chunk.Hash] {
+			damaged = damaged || nodeID == offline
+		}
+
+		want := ReplicaOK
+		if damaged {
+			want = ReplicaRestored
+		}
+		if chunk.Status != want || len(chunk.Holders)+len(chunk.Added) != 2 {
+			t.Errorf("chunk %d: want %s on 2 nodes, got %+v", i, want, chunk)
+		}
+		for _, nodeID := range chunk.Added {
+			if nodeID == offline {
+				t.Errorf("chunk %d copied to offline %s", i, nodeID)
+			}
+			if !nodeHolds(t, byID[nodeID], chunk.Hash) {
+				t.Errorf("chunk %d reported copied to %s, which doesn't hold it", i, nodeID)
+			}
+		}
+	}
+
+	if report := replicateFile(t, s, result.FileID); report.Restored != 0 || report.Failed != 0 {
+		t.Errorf("second run: want nothing left to do, got %+v", report)
+	}
+}
+
+// TestReplicateFileShortOfNodes checks chunks that can't reach their
+// replication on the healthy nodes left are reported failed, and chunks
+// stored on the coordinator are skipped
+func TestReplicateFileShortOfNodes(t *testing.T) {
+	s, _, _ := newTestCluster(t, 2)
+	result := upload(t, s, randomBytes(t, 2*chunking.MaxChunkSize), UploadMetadata{Replication: 2})
+	takeOffline(t, s, "node-2")
+
+	report := replicateFile(t, s, result.FileID)
+	if report.Failed != len(result.ChunkHashes) {
+		t.Errorf("want all %d chunks short, got %+v", len(result.ChunkHashes), report)
+	}
+	for _, chunk := range report.Chunks {
+		if chunk.Status != ReplicaFailed || len(chunk.Holders) != 1 || chunk.Error == "" {
+			t.Errorf("chunk %d: want failed with 1 holder, got %+v", chunk.Index, chunk)
+		}
+	}
+
+	local, _, _ := newTestService(t)
+	result = upload(t, local, randomBytes(t, 1000), UploadMetadata{})
+	if chunk := replicateFile(t, local, result.FileID).Chunks[0]; chunk.Status != ReplicaSkipped {
+		t.Errorf("local chunk: want skipped, got %+v", chunk)
+	}
+}
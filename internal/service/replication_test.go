@@ -0,0 +1,285 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// replicaCounts returns how many nodes hold each chunk of an upload
+func replicaCounts(t *testing.T, nodes []*node.StorageNode, result *UploadResult) []int {
+	t.Helper()
+
+	counts := make([]int, len(result.ChunkHashes))
+	for i, hash := range result.ChunkHashes {
+		for _, sn := range nodes {
+			if nodeHolds(t, sn, hash) {
+				counts[i]++
+			}
+		}
+	}
+	return counts
+}
+
+// checkReplication checks an upload's file and chunk records carry the
+// replication factor, and each chunk is on that many nodes
+func checkReplication(t *testing.T, db *metadata.MemoryStore, nodes []*node.StorageNode, result *UploadResult, want int) {
+	t.Helper()
+
+	file, err := db.GetFile(result.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Replication != want || result.Replication != want {
+		t.Errorf("want replication %d recorded, got %d (reported %d)", want, file.Replication, result.Replication)
+	}
+
+	for i, count := range replicaCounts(t, nodes, result) {
+		hash := result.ChunkHashes[i]
+		chunk, err := db.GetChunk(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Replication != want || count != want {
+			t.Errorf("chunk %s: want %d replicas, recorded %d and found %d", hash[:8], want, chunk.Replication, count)
+		}
+	}
+}
+
+// TestUploadReplication uploads with replication 1, 5 and the default
+// across five nodes and checks each file's chunks are placed that many
+// times
+func TestUploadReplication(t *testing.T) {
+	s, db, nodes := newTestCluster(t, 5)
+
+	for _, tc := range []struct {
+		replication int
+		want        int
+	}{
+		{1, 1},
+		{5, 5},
+		{0, ReplicationCount},
+	} {
+		t.Run(fmt.Sprint(tc.replication), func(t *testing.T) {
+			data := randomBytes(t, 3*chunking.MaxChunkSize)
+			result := upload(t, s, data, UploadMetadata{Replication: tc.replication})
+			checkReplication(t, db, nodes, result, tc.want)
+		})
+	}
+}
+
+// TestUploadRaisesReplication uploads a file with replication 1, then the
+// same chunks with replication 5, and checks the shared chunks gain the
+// extra replicas
+func TestUploadRaisesReplication(t *testing.T) {
+	s, db, nodes := newTestCluster(t, 5)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+
+	first := upload(t, s, data, UploadMetadata{Replication: 1})
+	checkReplication(t, db, nodes, first, 1)
+
+	second := upload(t, s, data, UploadMetadata{Replication: 5})
+	checkReplication(t, db, nodes, second, 5)
+}
+
+// TestUploadReplicationBounds checks the factor must be at least 1 and no
+// more than the healthy nodes
+func TestUploadReplicationBounds(t *testing.T) {
+	s, _, _ := newTestCluster(t, 5)
+
+	for _, replication := range []int{-1, 6} {
+		_, err := s.UploadFile(context.Background(), bytes.NewReader(randomBytes(t, 100)), UploadMetadata{FileName: "a.bin", Size: 100, Replication: replication})
+		if !errors.Is(err, ErrInvalidReplication) {
+			t.Errorf("replication %d: want ErrInvalidReplication, got %v", replication, err)
+		}
+	}
+}
+
+// failingNode registers a node that answers every request with an error,
+// so chunks the ring places on it are never acknowledged there
+func failingNode(t *testing.T, s *FileService, nodeID string) {
+	t.Helper()
+
+	addFakeNode(t, s, nodeID, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "disk failed", http.StatusInternalServerError)
+	}))
+}
+
+// TestWriteQuorum has a node fail every store, and checks uploads meet the
+// write quorum on the healthy nodes past a chunk's replicas, while without
+// one a single acknowledgement is enough
+func TestWriteQuorum(t *testing.T) {
+	s, db, nodes := newTestCluster(t, 3)
+	failingNode(t, s, "node-broken")
+
+	result := upload(t, s, randomBytes(t, 5*chunking.MaxChunkSize), UploadMetadata{Replication: 2})
+	if result.MinReplicas != 1 {
+		t.Fatalf("no quorum: want some chunk on 1 node, got min_replicas %d", result.MinReplicas)
+	}
+
+	if err := s.UseWriteQuorum(-1); err == nil {
+		t.Error("negative quorum: want an error")
+	}
+	// Capped at the upload's replication
+	for _, quorum := range []int{2, 5} {
+		if err := s.UseWriteQuorum(quorum); err != nil {
+			t.Fatal(err)
+		}
+		result := upload(t, s, randomBytes(t, 5*chunking.MaxChunkSize), UploadMetadata{Replication: 2})
+		if result.MinReplicas != 2 {
+			t.Errorf("quorum %d: want min_replicas 2, got %d", quorum, result.MinReplicas)
+		}
+		for i, count := range replicaCounts(t, nodes, result) {
+			if count < 2 {
+				t.Errorf("quorum %d, chunk %d: want it on 2 healthy nodes, found on %d", quorum, i, count)
+			}
+		}
+		if _, err := db.GetFile(result.FileID); err != nil {
+			t.Errorf("quorum %d: file not recorded: %v", quorum, err)
+		}
+	}
+}
+
+// TestWriteQuorumNotMet checks an upload fails when fewer nodes than the
+// quorum can take its chunks, leaving no file and no chunks behind
+func TestWriteQuorumNotMet(t *testing.T) {
+	s, db, nodes := newTestCluster(t, 2)
+	failingNode(t, s, "node-broken")
+	if err := s.UseWriteQuorum(3); err != nil {
+		t.Fatal(err)
+	}
+
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	_, err := s.UploadFile(context.Background(), bytes.NewReader(data), UploadMetadata{FileName: "a.bin", Size: int64(len(data)), Replication: 3})
+	if !errors.Is(err, ErrWriteQuorum) {
+		t.Fatalf("want ErrWriteQuorum, got %v", err)
+	}
+
+	if files, err := db.ListFiles(); err != nil || len(files) != 0 {
+		t.Errorf("want no file recorded, got %d (%v)", len(files), err)
+	}
+	for _, sn := range nodes {
+		held, err := node.NewNodeClient(http.DefaultClient, "http://"+sn.Address).List(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(held) != 0 {
+			t.Errorf("node %s kept %d chunks of the failed upload", sn.NodeID, len(held))
+		}
+	}
+}
+
+// gateReads puts each node behind a proxy that holds back every request
+// but stores until the returned function is called, so uploads, which only
+// store, go through while background copies, which first read, wait
+func gateReads(t *testing.T, s *FileService, nodes []*node.StorageNode) func() {
+	t.Helper()
+
+	gate := make(chan struct{})
+	var once sync.Once
+	release := func() { once.Do(func() { close(gate) }) }
+	t.Cleanup(release)
+
+	for _, sn := range nodes {
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: sn.Address})
+		addFakeNode(t, s, sn.NodeID, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				<-gate
+			}
+			proxy.ServeHTTP(w, r)
+		}))
+	}
+	return release
+}
+
+// queuedChunks returns how many of a file's chunks FileHealth reports
+// queued for more replicas
+func queuedChunks(t *testing.T, s *FileService, fileID string) int {
+	t.Helper()
+
+	queued := 0
+	for _, chunk := range fileHealth(t, s, fileID).Chunks {
+		if chunk.Queued {
+			queued++
+		}
+	}
+	return queued
+}
+
+// TestReplicationModes uploads with replication 3 while nothing can be read
+// from the nodes, and checks a sync upload returns with every replica
+// stored, while an async one returns with only the primary stored and the
+// rest queued, which are copied once reads go through
+func TestReplicationModes(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 3)
+	release := gateReads(t, s, nodes)
+	if err := s.UseReplicationMode("eventually"); err == nil {
+		t.Error("unknown mode: want an error")
+	}
+
+	result := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{Replication: 3})
+	if result.MinReplicas != 3 {
+		t.Errorf("sync: want every chunk on 3 nodes, got min_replicas %d", result.MinReplicas)
+	}
+	for i, count := range replicaCounts(t, nodes, result) {
+		if count != 3 {
+			t.Errorf("sync, chunk %d: want it on 3 nodes once uploaded, found on %d", i, count)
+		}
+	}
+	if queued := queuedChunks(t, s, result.FileID); queued != 0 {
+		t.Errorf("sync: want no chunks queued, got %d", queued)
+	}
+
+	if err := s.UseReplicationMode(ReplicationAsync); err != nil {
+		t.Fatal(err)
+	}
+	result = upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{Replication: 3})
+	chunks := len(result.ChunkHashes)
+	if result.MinReplicas != 1 {
+		t.Errorf("async: want chunks acknowledged on 1 node, got min_replicas %d", result.MinReplicas)
+	}
+	for i, count := range replicaCounts(t, nodes, result) {
+		if count != 1 {
+			t.Errorf("async, chunk %d: want only the primary holding it before copies can run, found on %d", i, count)
+		}
+	}
+	if queued := queuedChunks(t, s, result.FileID); queued != chunks {
+		t.Errorf("async: want all %d chunks queued, got %d", chunks, queued)
+	}
+	placements, err := s.FailedPlacements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, placement := range placements {
+		if len(placement.IntendedNodes) != 2 || !strings.Contains(placement.Reason, "asynchronous") {
+			t.Errorf("chunk %s: want it awaiting 2 asynchronous replicas, got %+v", placement.ChunkHash[:8], placement)
+		}
+	}
+
+	// The upload's own pass copies them once reads go through; waiting for
+	// it rather than retrying keeps it from outliving the test's nodes
+	release()
+	for deadline := time.Now().Add(time.Minute); queuedChunks(t, s, result.FileID) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("async replication never finished")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	for i, count := range replicaCounts(t, nodes, result) {
+		if count != 3 {
+			t.Errorf("async, chunk %d: want it on 3 nodes once copied, found on %d", i, count)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/noorimat/distributed-file-storage/internal/auth"
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+	"github.com/noorimat/distributed-file-storage/internal/throttle"
+	"google.golang.org/grpc"
+)
+
+const (
+	ReplicationCount = 3        // Store each chunk on 3 nodes
+	BatchMaxBytes    = 32 << 20 // Max chunk bytes per /store/batch request
+	UploadBatchBytes = 64 << 20 // Chunk bytes an upload buffers before storing them
+
+	// DefaultInlineThreshold is the size below which files are stored whole
+	// as one chunk instead of being chunked
+	DefaultInlineThreshold = 64 << 10
+
+	// DedupSaltSize is the length of the salt prefixed to the chunks of a
+	// file uploaded with deduplication disabled
+	DedupSaltSize = 16
+)
+
+var (
+	ErrPasswordRequired   = errors.New("password required for encrypted file")
+	ErrIncorrectPassword  = errors.New("incorrect password")
+	ErrDecryptionFailed   = errors.New("decryption failed")
+	ErrNotEncrypted       = errors.New("file is not encrypted")
+	ErrPasswordNotAllowed = errors.New("password not allowed for client-encrypted upload")
+	ErrInvalidReplication = errors.New("invalid replication factor")
+	ErrInvalidAlgorithm   = errors.New("invalid encryption algorithm")
+	ErrChecksumMismatch   = errors.New("reassembled file does not match its checksum")
+	ErrWriteQuorum        = errors.New("write quorum not met")
+)
+
+// MetadataStore persists file and chunk metadata. It is implemented by
+// metadata.Database (PostgreSQL) and metadata.MemoryStore.
+type MetadataStore interface {
+	CreateFile(file *metadata.FileRecord) error
+	GetFile(fileID string) (*metadata.FileRecord, error)
+	GetFileByHash(fileHash string) (*metadata.FileRecord, error)
+	ListFiles() ([]metadata.FileRecord, error)
+	GetLatestFileVersion(fileName string) (*metadata.FileRecord, error)
+	GetFileVersion(fileName string, version int) (*metadata.FileRecord, error)
+	ListFileVersions(fileName string) ([]metadata.FileRecord, error)
+	CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error)
+	GetChunk(chunkHash string) (*metadata.ChunkRecord, error)
+	GetChunks(hashes []string) (map[string]*metadata.ChunkRecord, error)
+	ChunksExist(hashes []string) (map[string]bool, error)
+	LinkFileChunk(fileID, chunkHash string, chunkOrder int, startOffset int64) error
+	GetFileChunks(fileID string) ([]string, error)
+	GetFileWithChunks(fileID string) (*metadata.FileRecord, []metadata.ChunkDescriptor, error)
+	SoftDeleteFile(fileID string) error
+	RestoreFile(fileID string) error
+	ListDeletedFiles() ([]metadata.FileRecord, error)
+	ListFilesDeletedBefore(cutoff time.Time) ([]metadata.FileRecord, error)
+	ListExpiredFiles(now time.Time) ([]metadata.FileRecord, error)
+	PurgeFile(fileID string) ([]metadata.ChunkRecord, error)
+	ReleaseChunks(hashes []string) ([]metadata.ChunkRecord, error)
+	RekeyFile(fileID string, rekey *metadata.RekeyedFile) ([]metadata.ChunkRecord, error)
+	SetChunkCoding(chunkHash string, coding *metadata.ChunkCoding) error
+	GetChunkCoding(chunkHash string) (*metadata.ChunkCoding, error)
+	AddChunkLocations(chunkHash string, nodeIDs []string) error
+	GetChunkLocations(hashes []string) (map[string][]string, error)
+	AuditChunks() ([]metadata.ChunkAudit, error)
+	CreateShareLink(link *metadata.ShareLink) error
+	SetFileTags(fileID string, tags map[string]string) error
+	GetFileTags(fileID string) (map[string]string, error)
+	FindFilesByTags(tags map[string]string) ([]metadata.FileRecord, error)
+	SetThumbnail(fileID string, data []byte) error
+	GetThumbnail(fileID string) ([]byte, error)
+	RecordFailedPlacement(chunkHash string, intendedNodes []string, reason string) error
+	RecordPlacementAttempt(chunkHash string, intendedNodes []string, reason string) error
+	ListFailedPlacements() ([]metadata.FailedPlacement, error)
+	DeleteFailedPlacement(chunkHash string) error
+	RecordAccess(record *metadata.AccessRecord) error
+	ListAccess(fileID string, before int64, limit int) ([]metadata.AccessRecord, error)
+	UseShareLink(tokenHash string) (*metadata.ShareLink, error)
+	DeleteShareLink(tokenHash string) error
+	GetStats() (map[string]interface{}, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// ChunkStorer is the local chunk store used when no storage nodes are
+// available. It is implemented by dedup.ChunkStore and dedup.MemoryChunkStore.
+type ChunkStorer interface {
+	StoreChunk(hash string, data []byte) (string, bool, error)
+	GetChunk(hash string) ([]byte, error)
+	HasChunk(hash string) bool
+	ReleaseChunk(hash string) error
+	DeleteChunk(hash string) error
+	GetStats() map[string]interface{}
+	CheckWritable(ctx context.Context) error
+	Flush() error
+}
+
+// NodeRegistry tracks the storage nodes in the cluster
+type NodeRegistry interface {
+	GetHealthyNodes() []*node.NodeInfo
+	GetNode(nodeID string) (*node.NodeInfo, error)
+}
+
+// NodeSelector decides which nodes should hold a chunk
+type NodeSelector interface {
+	GetNodes(chunkHash string, count int) ([]string, error)
+}
+
+// FileService implements the upload and download pipelines independently
+// of HTTP so they can be exercised with fake dependencies
+type FileService struct {
+	db       MetadataStore
+	chunks   ChunkStorer
+	registry NodeRegistry
+	ring     NodeSelector
+	client   *http.Client
+	backend  ChunkBackend // where chunks are stored and read from
+
+	repairing   sync.Map     // chunk hashes with a read-repair in flight
+	readRepairs atomic.Int64 // replicas restored by read-repair
+
+	// storing is held for reading while chunks are on the nodes but not
+	// yet recorded, or found stored but not yet referenced, and for
+	// writing while orphans or freed chunks are deleted, so an upload's
+	// chunks are never mistaken for orphans nor deleted from under it
+	storing sync.RWMutex
+
+	readsInFlight sync.Map      // node ID -> *atomic.Int64 of chunk reads in progress
+	readTurn      atomic.Uint64 // rotates which replica wins ties
+
+	clusterSecret string
+	tlsConfig     *tls.Config                 // nil for plain HTTP; see UseTLS
+	conns         map[string]*grpc.ClientConn // gRPC address -> connection
+	connsLock     sync.Mutex
+
+	manifestKey []byte // signs exported manifests; see UseManifestKey
+
+	inlineThreshold int64 // files smaller than this skip chunking; see UseInlineThreshold
+
+	hashAlgorithm chunking.HashAlgorithm // hashes new chunks and shards; see UseHashAlgorithm
+	hashWorkers   int                    // goroutines hashing each upload's chunks; see UseHashWorkers
+	verifyDedup   bool                   // compare deduplicated chunks with the stored bytes; see UseVerifyDedup
+
+	writeQuorum     int    // nodes that must acknowledge a new chunk, 0 for any one; see UseWriteQuorum
+	replicationMode string // when uploads are acknowledged; see UseReplicationMode
+
+	encryptionAlgorithm string // seals password-encrypted uploads that name none; see UseEncryptionAlgorithm
+
+	placer         node.Placer    // nil to place chunks on the ring; see UsePlacer
+	placerRegistry *node.Registry // the nodes placer chooses from
+
+	cache *chunkCache // nil unless enabled; see UseChunkCache
+
+	chunkBuffers *chunkLimiter // nil unless limited; see UseMaxChunkBuffers
+
+	thumbnailSize int // longer side of image thumbnails, 0 for none; see UseThumbnails
+
+	direct directUploads // direct uploads awaiting completion; see PlanDirectUpload
+
+	bandwidth *throttle.Limiter // caps background transfers; see SetBackgroundBandwidth
+
+	encoder      reedsolomon.Encoder // nil unless erasure coding is enabled
+	dataShards   int
+	parityShards int
+}
+
+// NewFileService creates a file service backed by the given stores and
+// cluster view. Chunks go to the storage nodes, falling back to the local
+// chunk store; see UseBackends.
+func NewFileService(db MetadataStore, chunks ChunkStorer, registry NodeRegistry, ring NodeSelector) *FileService {
+	s := &FileService{
+		db:       db,
+		chunks:   chunks,
+		registry: registry,
+		ring:     ring,
+		client:   &http.Client{},
+		conns:    make(map[string]*grpc.ClientConn),
+
+		bandwidth: throttle.NewLimiter(0),
+
+		inlineThreshold: DefaultInlineThreshold,
+		hashAlgorithm:   chunking.DefaultHashAlgorithm,
+		replicationMode: ReplicationSync,
+
+		encryptionAlgorithm: crypto.AlgorithmAESGCM,
+	}
+	s.backend = NewFallbackBackend(s.ClusterBackend(), NewLocalBackend(chunks))
+	return s
+}
+
+// UseBackends sets where chunks are stored, as a fallback chain of named
+// backends: "cluster" (the storage nodes) and "local" (the chunk store the
+// service was created with)
+func (s *FileService) UseBackends(names ...string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("no chunk backends given")
+	}
+
+	backends := make([]ChunkBackend, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case "cluster":
+			backends = append(backends, s.ClusterBackend())
+		case "local":
+			backends = append(backends, NewLocalBackend(s.chunks))
+		default:
+			return fmt.Errorf("unknown chunk backend %q", name)
+		}
+	}
+
+	if len(backends) == 1 {
+		s.backend = backends[0]
+	} else {
+		s.backend = NewFallbackBackend(backends...)
+	}
+	return nil
+}
+
+// UseInlineThreshold sets the size in bytes below which uploads are stored
+// whole as a single chunk, skipping content-defined chunking. Zero chunks
+// every file.
+func (s *FileService) UseInlineThreshold(threshold int64) {
+	s.inlineThreshold = threshold
+}
+
+// UseWriteQuorum makes uploads fail unless every chunk they store is
+// acknowledged by at least quorum storage nodes, capped at the upload's
+// replication. Nodes beyond a chunk's ring placement are tried when its own
+// replicas fall short. Chunks stored elsewhere, such as the local fallback,
+// don't count. Zero keeps the default of any one acknowledgement, with
+// chunks no node takes falling back to the next backend.
+func (s *FileService) UseWriteQuorum(quorum int) error {
+	if quorum < 0 {
+		return fmt.Errorf("write quorum must not be negative, got %d", quorum)
+	}
+	s.writeQuorum = quorum
+	return nil
+}
+
+// UsePlacer sets how the nodes for new replicated chunks are chosen. Unless
+// it is a ConsistentHashPlacer, reads look up the nodes recorded holding a
+// chunk before the ring's. Erasure-coded shards, and replicas added by
+// repairs or raised replication, are still placed on the ring.
+func (s *FileService) UsePlacer(placer node.Placer, registry *node.Registry) {
+	if _, ring := placer.(*node.ConsistentHashPlacer); ring {
+		s.placer, s.placerRegistry = nil, nil
+		return
+	}
+	s.placer, s.placerRegistry = placer, registry
+}
+
+// Replication modes, deciding when an upload's replicated chunks count as
+// stored
+const (
+	ReplicationSync  = "sync"  // Once every replica node has answered
+	ReplicationAsync = "async" // Once the write quorum holds them; other replicas are copied in the background
+)
+
+// UseReplicationMode sets when uploads are acknowledged. In sync mode, the
+// default, each chunk is sent to all of its replica nodes before the upload
+// returns. In async mode it is only sent to as many as the write quorum asks
+// (the primary replica unless UseWriteQuorum is set), and the remaining
+// replicas are queued as failed placements and copied in the background, so
+// they survive a restart and show up in FileHealth until they are placed.
+// Erasure-coded chunks always wait for every shard.
+func (s *FileService) UseReplicationMode(mode string) error {
+	switch mode {
+	case ReplicationSync, ReplicationAsync:
+		s.replicationMode = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown replication mode %q (expected %s or %s)", mode, ReplicationSync, ReplicationAsync)
+	}
+}
+
+// writeQuorumFor returns how many nodes must acknowledge a chunk stored
+// with the given replication
+func (s *FileService) writeQuorumFor(replication int) int {
+	return max(min(s.writeQuorum, replication), 1)
+}
+
+// UseHashAlgorithm sets the function new chunks and shards are hashed with.
+// Each chunk records the algorithm that hashed it, and chunks hashed with
+// another algorithm stay readable, but content stored before a switch isn't
+// deduplicated against content stored after it.
+func (s *FileService) UseHashAlgorithm(algorithm chunking.HashAlgorithm) {
+	s.hashAlgorithm = algorithm
+}
+
+// UseEncryptionAlgorithm sets the AEAD password-encrypted uploads are
+// sealed with when they don't choose one. Each file records its algorithm,
+// so files encrypted before a switch stay readable.
+func (s *FileService) UseEncryptionAlgorithm(algorithm string) error {
+	algorithm, err := crypto.ParseAlgorithm(algorithm)
+	if err != nil {
+		return err
+	}
+	s.encryptionAlgorithm = algorithm
+	return nil
+}
+
+// UseHashWorkers hashes each upload's chunks on that many goroutines while
+// later chunks are read, so large uploads use more than one core. Each
+// worker lets an upload read one more chunk ahead. 0 or 1 hashes chunks one
+// at a time as they are read.
+func (s *FileService) UseHashWorkers(workers int) error {
+	if workers < 0 {
+		return fmt.Errorf("hash workers must not be negative, got %d", workers)
+	}
+	s.hashWorkers = workers
+	return nil
+}
+
+// UseChunkCache keeps up to maxBytes of recently read chunk data in memory,
+// so popular files are served without going back to the nodes. Chunks are
+// cached as stored, so encrypted files are cached as ciphertext. Zero
+// disables the cache.
+func (s *FileService) UseChunkCache(maxBytes int64) {
+	if maxBytes <= 0 {
+		s.cache = nil
+		return
+	}
+	s.cache = newChunkCache(maxBytes)
+}
+
+// ChunkCacheStats returns the chunk cache's hit and miss counts and size,
+// or nil if the cache is disabled
+func (s *FileService) ChunkCacheStats() *ChunkCacheStats {
+	if s.cache == nil {
+		return nil
+	}
+	stats := s.cache.stats()
+	return &stats
+}
+
+// UseClusterSecret authenticates all requests to storage nodes with the
+// shared cluster secret. An empty secret leaves requests unauthenticated.
+// Call it before the service talks to any node.
+func (s *FileService) UseClusterSecret(secret string) {
+	s.clusterSecret = secret
+	s.updateTransport()
+}
+
+// UseTLS talks to storage nodes over HTTPS and TLS-secured gRPC using
+// config, which carries the CA nodes are verified against and the
+// certificate presented to them. A nil config keeps plain HTTP. Call it
+// before the service talks to any node.
+func (s *FileService) UseTLS(config *tls.Config) {
+	s.tlsConfig = config
+	s.updateTransport()
+}
+
+// updateTransport rebuilds the HTTP transport from the cluster secret and
+// TLS settings
+func (s *FileService) updateTransport() {
+	var transport http.RoundTripper
+	if s.tlsConfig != nil {
+		transport = auth.TLSTransport(s.tlsConfig)
+	}
+	if s.clusterSecret != "" {
+		transport = &auth.Transport{Token: s.clusterSecret, Base: transport}
+	}
+	s.client.Transport = transport
+}
+
+// nodeClient returns a client for a storage node's HTTP API, sharing the
+// service's transport
+func (s *FileService) nodeClient(nodeInfo *node.NodeInfo) *node.NodeClient {
+	return node.NewNodeClient(s.client, s.nodeURL(nodeInfo))
+}
+
+// nodeURL returns the base URL of a node's HTTP API
+func (s *FileService) nodeURL(nodeInfo *node.NodeInfo) string {
+	scheme := "http"
+	if s.tlsConfig != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, nodeInfo.Address)
+}
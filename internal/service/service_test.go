@@ -0,0 +1,288 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+
+	"github.com/noorimat/distributed-file-storage/internal/dedup"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// testHeartbeatTimeout is the test registry's heartbeat timeout. Test nodes
+// are registered directly and never heartbeat, so it must outlast the
+// slowest test, such as one run with the race detector
+const testHeartbeatTimeout = time.Hour
+
+// newTestService returns a FileService with in-memory metadata and chunk
+// stores and no storage nodes, so every chunk lands in the local store
+func newTestService(t *testing.T) (*FileService, *metadata.MemoryStore, *dedup.MemoryChunkStore) {
+	t.Helper()
+
+	db := metadata.NewMemoryStore()
+	s, chunks := newTestServiceWith(t, db)
+	return s, db, chunks
+}
+
+// newTestServiceWith is newTestService over the given metadata store, for
+// tests that wrap a MemoryStore to intercept its calls
+func newTestServiceWith(t *testing.T, db MetadataStore) (*FileService, *dedup.MemoryChunkStore) {
+	t.Helper()
+
+	ring, err := node.NewConsistentHash(node.DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := dedup.NewMemoryChunkStore()
+	return NewFileService(db, chunks, node.NewRegistry(testHeartbeatTimeout), ring), chunks
+}
+
+// newTestCluster returns a FileService like newTestService's, placing
+// chunks on n storage nodes running in-process, named node-1 to node-n
+func newTestCluster(t *testing.T, n int) (*FileService, *metadata.MemoryStore, []*node.StorageNode) {
+	t.Helper()
+
+	s, db, _ := newTestService(t)
+	nodes := make([]*node.StorageNode, n)
+	for i := range nodes {
+		nodes[i] = startTestNode(t, s, fmt.Sprintf("node-%d", i+1), nil)
+	}
+	return s, db, nodes
+}
+
+// freeAddress returns a local address nothing is listening on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// startTestNode runs a storage node on a free local port until the test
+// ends, and adds it to the registry and ring newTestService created.
+// configure, if not nil, adjusts the node before it starts.
+func startTestNode(t *testing.T, s *FileService, nodeID string, configure func(sn *node.StorageNode)) *node.StorageNode {
+	t.Helper()
+
+	address := freeAddress(t)
+	sn := node.NewStorageNode(nodeID, address, t.TempDir(), "")
+	sn.Durability = node.DurabilityNone
+	sn.ScrubInterval = 0
+	sn.SweepInterval = 0
+	if configure != nil {
+		configure(sn)
+	}
+
+	var startErr error
+	stopped := make(chan struct{})
+	go func() {
+		startErr = sn.Start()
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sn.Shutdown(ctx)
+		<-stopped
+		if startErr != nil {
+			t.Errorf("node %s stopped with error: %v", nodeID, startErr)
+		}
+	})
+
+	client := node.NewNodeClient(http.DefaultClient, "http://"+address)
+	for deadline := time.Now().Add(5 * time.Second); client.Health(context.Background()) != nil; {
+		select {
+		case <-stopped:
+			t.Fatalf("node %s failed to start: %v", nodeID, startErr)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node %s never answered health checks", nodeID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	nodeInfo := &node.NodeInfo{NodeID: nodeID, Address: address, GRPCAddress: sn.GRPCAddress}
+	if err := s.registry.(*node.Registry).RegisterNode(nodeInfo); err != nil {
+		t.Fatal(err)
+	}
+	s.ring.(*node.ConsistentHash).AddNode(nodeID)
+	return sn
+}
+
+// nodeHolds reports whether a storage node serves the chunk
+func nodeHolds(t *testing.T, sn *node.StorageNode, chunkHash string) bool {
+	t.Helper()
+
+	exists, err := node.NewNodeClient(http.DefaultClient, "http://"+sn.Address).Exists(context.Background(), chunkHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+// randomBytes returns n random bytes
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// upload stores data under name and fails the test on error
+func upload(t *testing.T, s *FileService, data []byte, meta UploadMetadata) *UploadResult {
+	t.Helper()
+
+	meta.Size = int64(len(data))
+	if meta.FileName == "" {
+		meta.FileName = "test.bin"
+	}
+	result, err := s.UploadFile(context.Background(), bytes.NewReader(data), meta)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	return result
+}
+
+// download reads a whole file back and fails the test on error
+func download(t *testing.T, s *FileService, fileID, password string) []byte {
+	t.Helper()
+
+	d, err := s.DownloadFile(context.Background(), fileID, password)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	defer d.Close()
+
+	var buf bytes.Buffer
+	if _, err := d.WriteTo(&buf); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestUploadRoundTrip uploads files of various sizes, including each chunk
+// size limit, from a seekable reader and a stream, and checks each
+// downloads unchanged
+func TestUploadRoundTrip(t *testing.T) {
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"small", 100},
+		{"inline", 1 << 20},
+		{"min", chunking.MinChunkSize},
+		{"avg", chunking.AvgChunkSize},
+		{"max", chunking.MaxChunkSize},
+		{"chunked", 3 * chunking.MaxChunkSize},
+	}
+
+	s, _, _ := newTestService(t)
+	for _, size := range sizes {
+		data := randomBytes(t, size.size)
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/stream=%v", size.name, stream), func(t *testing.T) {
+				var r io.Reader = bytes.NewReader(data)
+				if stream {
+					r = struct{ io.Reader }{r}
+				}
+				result, err := s.UploadFile(context.Background(), r, UploadMetadata{FileName: size.name, Size: int64(len(data))})
+				if err != nil {
+					t.Fatalf("upload failed: %v", err)
+				}
+				if result.Size != int64(len(data)) {
+					t.Errorf("want size %d, got %d", len(data), result.Size)
+				}
+				if chunks := len(result.ChunkHashes); (chunks == 0) != (len(data) == 0) {
+					t.Errorf("want chunks only for a non-empty file, got %d", chunks)
+				}
+				if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+					t.Error("downloaded data differs from upload")
+				}
+			})
+		}
+	}
+}
+
+// TestDownloadUnknownFile checks a missing file is reported as not found
+func TestDownloadUnknownFile(t *testing.T) {
+	s, _, _ := newTestService(t)
+
+	if _, err := s.DownloadFile(context.Background(), "no-such-file", ""); !errors.Is(err, metadata.ErrFileNotFound) {
+		t.Errorf("want ErrFileNotFound, got %v", err)
+	}
+}
+
+// TestUploadToNodes uploads to a cluster and checks every chunk is on
+// ReplicationCount nodes and the file downloads from them alone
+func TestUploadToNodes(t *testing.T) {
+	s, _, nodes := newTestCluster(t, 4)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{})
+
+	for _, hash := range result.ChunkHashes {
+		held := 0
+		for _, sn := range nodes {
+			if nodeHolds(t, sn, hash) {
+				held++
+			}
+		}
+		if held != ReplicationCount {
+			t.Errorf("chunk %s: want it on %d nodes, got %d", hash[:8], ReplicationCount, held)
+		}
+		if s.chunks.HasChunk(hash) {
+			t.Errorf("chunk %s fell back to the local store", hash[:8])
+		}
+	}
+
+	if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+		t.Error("downloaded data differs from upload")
+	}
+}
+
+// TestUploadEmptyFile checks an empty upload, plain or encrypted, is stored
+// as a file with no chunks that downloads as nothing, whole or as a range
+func TestUploadEmptyFile(t *testing.T) {
+	s, db, _ := newTestService(t)
+
+	for _, password := range []string{"", "secret"} {
+		result := upload(t, s, nil, UploadMetadata{Password: password})
+		if result.ChunkHashes == nil || len(result.ChunkHashes) != 0 || result.Size != 0 || result.ChunksStored != 0 {
+			t.Errorf("password %q: want an empty file with no chunks, got %+v", password, result)
+		}
+		if _, chunks, err := db.GetFileWithChunks(result.FileID); err != nil || len(chunks) != 0 {
+			t.Errorf("password %q: want a file record with no chunks, got %v (%v)", password, chunks, err)
+		}
+
+		if got := download(t, s, result.FileID, password); len(got) != 0 {
+			t.Errorf("password %q: want an empty download, got %d bytes", password, len(got))
+		}
+		d, err := s.DownloadFile(context.Background(), result.FileID, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if n, err := d.WriteRange(&buf, 0, 0); err != nil || n != 0 {
+			t.Errorf("password %q: want an empty range, got %d bytes (%v)", password, n, err)
+		}
+		d.Close()
+	}
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// ShareOptions are the optional limits of a share link
+type ShareOptions struct {
+	// Password is embedded in the link so an encrypted file downloads
+	// without asking for it. It must be the file's password.
+	Password     string
+	ExpiresIn    time.Duration // Zero for a link that never expires
+	MaxDownloads int           // Zero for no limit
+}
+
+// Share is a newly created share link. The token is only known to its
+// creator; the metadata store keeps a hash of it.
+type Share struct {
+	Token        string     `json:"token"`
+	FileID       string     `json:"file_id"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads int        `json:"max_downloads,omitempty"`
+}
+
+// ShareFile creates a share link for a file. A password is checked against
+// the file before it is embedded.
+func (s *FileService) ShareFile(fileID string, opts ShareOptions) (*Share, error) {
+	if opts.ExpiresIn < 0 || opts.MaxDownloads < 0 {
+		return nil, fmt.Errorf("share expiry and download limit must not be negative")
+	}
+
+	file, err := s.db.GetFile(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file.Expired(time.Now()) {
+		return nil, ErrFileExpired
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate share token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	link := &metadata.ShareLink{
+		TokenHash:    shareTokenHash(token),
+		FileID:       fileID,
+		MaxDownloads: opts.MaxDownloads,
+	}
+
+	if opts.Password != "" {
+		if !file.Encrypted {
+			return nil, ErrNotEncrypted
+		}
+		salt, err := hex.DecodeString(file.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption metadata: %w", err)
+		}
+		if file.PasswordHash != "" {
+			key, err := crypto.DeriveKey(opts.Password, salt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+			}
+			matches := crypto.VerifyPassword(key, file.PasswordHash)
+			key.Zero()
+			if !matches {
+				return nil, ErrIncorrectPassword
+			}
+		}
+
+		link.SealedPassword, err = sealSharePassword(token, opts.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(opts.ExpiresIn).UTC()
+		link.ExpiresAt = &expiresAt
+	}
+
+	if err := s.db.CreateShareLink(link); err != nil {
+		return nil, err
+	}
+
+	return &Share{
+		Token:        token,
+		FileID:       fileID,
+		ExpiresAt:    link.ExpiresAt,
+		MaxDownloads: opts.MaxDownloads,
+	}, nil
+}
+
+// OpenShare counts a download against a share link and prepares the file
+// for download. The password is only needed for encrypted files whose link
+// doesn't embed one. A download is counted even if it later fails.
+func (s *FileService) OpenShare(ctx context.Context, token, password string) (*Download, error) {
+	link, err := s.db.UseShareLink(shareTokenHash(token))
+	if err != nil {
+		return nil, err
+	}
+
+	if link.SealedPassword != nil {
+		password, err = openSharePassword(token, link.SealedPassword)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.DownloadFile(ctx, link.FileID, password)
+}
+
+// RevokeShare deletes a share link
+func (s *FileService) RevokeShare(token string) error {
+	return s.db.DeleteShareLink(shareTokenHash(token))
+}
+
+func shareTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// sharePasswordAEAD derives the key that seals a link's password from its
+// token, so the metadata store alone can't recover the password. The token
+// is 256 random bits, so a plain hash is enough of a KDF.
+func sharePasswordAEAD(token string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("share-link-password\x00" + token))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealSharePassword(token, password string) ([]byte, error) {
+	aead, err := sharePasswordAEAD(token)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, []byte(password), nil), nil
+}
+
+func openSharePassword(token string, sealed []byte) (string, error) {
+	aead, err := sharePasswordAEAD(token)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("invalid sealed share password")
+	}
+	password, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to unseal share password: %w", err)
+	}
+	return string(password), nil
+}
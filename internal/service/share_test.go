@@ -0,0 +1,145 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// openShare downloads a file through a share link
+func openShare(t *testing.T, s *FileService, token, password string) ([]byte, error) {
+	t.Helper()
+
+	d, err := s.OpenShare(context.Background(), token, password)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	var buf bytes.Buffer
+	if _, err := d.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// TestShareDownloadLimit checks a link allows exactly its download limit
+func TestShareDownloadLimit(t *testing.T) {
+	s, _, _ := newTestService(t)
+	data := randomBytes(t, 1000)
+	result := upload(t, s, data, UploadMetadata{})
+
+	share, err := s.ShareFile(result.FileID, ShareOptions{MaxDownloads: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		got, err := openShare(t, s, share.Token, "")
+		if err != nil {
+			t.Fatalf("download %d: %v", i, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("download %d differs", i)
+		}
+	}
+	if _, err := openShare(t, s, share.Token, ""); !errors.Is(err, metadata.ErrShareExpired) {
+		t.Errorf("download 4: want ErrShareExpired, got %v", err)
+	}
+
+	// Other links to the file have their own counts
+	other, err := s.ShareFile(result.FileID, ShareOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openShare(t, s, other.Token, ""); err != nil {
+		t.Errorf("another link: %v", err)
+	}
+}
+
+// TestShareExpiry checks a link works until it expires
+func TestShareExpiry(t *testing.T) {
+	s, _, _ := newTestService(t)
+	result := upload(t, s, randomBytes(t, 1000), UploadMetadata{})
+
+	share, err := s.ShareFile(result.FileID, ShareOptions{ExpiresIn: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if share.ExpiresAt == nil || time.Until(*share.ExpiresAt) > 200*time.Millisecond {
+		t.Errorf("want the link to expire within 200ms, got %v", share.ExpiresAt)
+	}
+	if _, err := openShare(t, s, share.Token, ""); err != nil {
+		t.Fatalf("before expiry: %v", err)
+	}
+
+	time.Sleep(time.Until(*share.ExpiresAt) + 10*time.Millisecond)
+	if _, err := openShare(t, s, share.Token, ""); !errors.Is(err, metadata.ErrShareExpired) {
+		t.Errorf("after expiry: want ErrShareExpired, got %v", err)
+	}
+}
+
+// TestSharePassword checks a link embeds an encrypted file's password only
+// once it is verified, and asks for it otherwise
+func TestSharePassword(t *testing.T) {
+	s, _, _ := newTestService(t)
+	data := randomBytes(t, 1000)
+	encrypted := upload(t, s, data, UploadMetadata{Password: "secret"})
+	plain := upload(t, s, randomBytes(t, 1000), UploadMetadata{})
+
+	if _, err := s.ShareFile(encrypted.FileID, ShareOptions{Password: "wrong"}); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("wrong password: want ErrIncorrectPassword, got %v", err)
+	}
+	if _, err := s.ShareFile(plain.FileID, ShareOptions{Password: "secret"}); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("unencrypted file: want ErrNotEncrypted, got %v", err)
+	}
+
+	embedded, err := s.ShareFile(encrypted.FileID, ShareOptions{Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := openShare(t, s, embedded.Token, ""); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("link with the password embedded: %v", err)
+	}
+
+	bare, err := s.ShareFile(encrypted.FileID, ShareOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openShare(t, s, bare.Token, ""); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("link without a password: want ErrPasswordRequired, got %v", err)
+	}
+	if got, err := openShare(t, s, bare.Token, "secret"); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("link without a password, given it: %v", err)
+	}
+}
+
+// TestShareRevoke checks a revoked link, or one never issued, downloads
+// nothing
+func TestShareRevoke(t *testing.T) {
+	s, _, _ := newTestService(t)
+	result := upload(t, s, randomBytes(t, 1000), UploadMetadata{})
+
+	share, err := s.ShareFile(result.FileID, ShareOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeShare(share.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openShare(t, s, share.Token, ""); !errors.Is(err, metadata.ErrShareNotFound) {
+		t.Errorf("revoked link: want ErrShareNotFound, got %v", err)
+	}
+	if _, err := openShare(t, s, "made-up-token", ""); !errors.Is(err, metadata.ErrShareNotFound) {
+		t.Errorf("unknown token: want ErrShareNotFound, got %v", err)
+	}
+
+	for _, opts := range []ShareOptions{{ExpiresIn: -time.Second}, {MaxDownloads: -1}} {
+		if _, err := s.ShareFile(result.FileID, opts); err == nil {
+			t.Errorf("want negative limits %+v rejected", opts)
+		}
+	}
+}
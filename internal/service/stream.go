@@ -0,0 +1,138 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// openChunkFromNodes streams a chunk from the first of its replicas that
+// serves it, least busy first, like retrieveChunkFromNodes reads it. Nodes
+// serving gRPC are read whole, since their chunks already arrive in pieces,
+// and so are nodes too old to stream raw bytes.
+// Replicas that failed to serve it, and one whose stream turns out not to
+// match the chunk's hash, are repaired in the background.
+func (s *FileService) openChunkFromNodes(ctx context.Context, chunkHash string) (io.ReadCloser, int64, error) {
+	targetNodes, err := s.replicaNodes(chunkHash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var missing []string
+	for _, nodeID := range s.orderReplicas(targetNodes) {
+		stream, err := s.openChunkOnNode(ctx, chunkHash, nodeID)
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Failed to stream from node %s: %v", nodeID, err)
+			missing = append(missing, nodeID)
+			continue
+		}
+
+		if len(missing) > 0 {
+			go s.repairReplicas(chunkHash, missing)
+		}
+		return stream, stream.size, nil
+	}
+
+	return nil, 0, fmt.Errorf("chunk not found on any node")
+}
+
+// openChunkOnNode starts streaming a chunk from a single node
+func (s *FileService) openChunkOnNode(ctx context.Context, chunkHash, nodeID string) (*nodeStream, error) {
+	nodeInfo, err := s.registry.GetNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The read stays in flight until the stream is closed
+	inFlight := s.readCounter(nodeID)
+	inFlight.Add(1)
+
+	stream := &nodeStream{
+		s:        s,
+		hash:     chunkHash,
+		nodeID:   nodeID,
+		matcher:  chunking.NewMatcher(),
+		inFlight: inFlight,
+	}
+
+	if nodeInfo.Supports(node.TransportGRPC) || !nodeInfo.Supports(node.TransportBinary) {
+		var chunkData []byte
+		if nodeInfo.Supports(node.TransportGRPC) {
+			chunkData, err = s.grpcRetrieveChunk(ctx, nodeInfo, chunkHash)
+		} else {
+			chunkData, err = s.nodeClient(nodeInfo).Retrieve(ctx, chunkHash)
+		}
+		if err != nil {
+			inFlight.Add(-1)
+			return nil, err
+		}
+		stream.body = io.NopCloser(bytes.NewReader(chunkData))
+		stream.size = int64(len(chunkData))
+		return stream, nil
+	}
+
+	stream.body, stream.size, err = s.nodeClient(nodeInfo).Open(ctx, chunkHash)
+	if err != nil {
+		inFlight.Add(-1)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// nodeStream is a chunk streaming from a node, hashed as it is read. The
+// Read that reaches its end fails if it doesn't match the chunk's hash.
+type nodeStream struct {
+	s        *FileService
+	hash     string
+	nodeID   string
+	body     io.ReadCloser
+	size     int64
+	matcher  *chunking.Matcher
+	inFlight *atomic.Int64
+	checked  bool
+	closed   bool
+}
+
+func (ns *nodeStream) Read(p []byte) (int, error) {
+	n, err := ns.body.Read(p)
+	ns.matcher.Write(p[:n])
+
+	if err == io.EOF && !ns.checked {
+		ns.checked = true
+		if !ns.matcher.Matches(ns.hash) {
+			log.Printf("Node %s streamed data for chunk %s that does not match its hash", ns.nodeID, ns.hash[:8])
+			go ns.s.repairReplicas(ns.hash, []string{ns.nodeID})
+			return n, fmt.Errorf("chunk %s from node %s does not match its hash", ns.hash[:8], ns.nodeID)
+		}
+	}
+	return n, err
+}
+
+func (ns *nodeStream) Close() error {
+	if !ns.closed {
+		ns.closed = true
+		ns.inFlight.Add(-1)
+	}
+	return ns.body.Close()
+}
+
+// repairReplicas re-stores a chunk on nodes found without a good copy while
+// it was streamed. Streams aren't kept in memory, so a good copy is read
+// again to repair them.
+func (s *FileService) repairReplicas(chunkHash string, nodeIDs []string) {
+	chunkData, err := s.backgroundRetrieve(context.Background(), chunkHash)
+	if err != nil {
+		log.Printf("Read-repair of chunk %s failed: no healthy copy available", chunkHash[:8])
+		return
+	}
+	s.readRepair(chunkHash, chunkData, nodeIDs)
+}
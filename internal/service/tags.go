@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// Limits on file tags, matching the file_tags columns
+const (
+	MaxTagsPerFile    = 32
+	MaxTagKeyLength   = 128
+	MaxTagValueLength = 1024
+)
+
+var ErrInvalidTags = errors.New("invalid tags")
+
+// ValidateTags checks tags against the limits. Keys must be non-empty and
+// can't contain ':', which separates key and value in tag filters.
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > MaxTagsPerFile {
+		return fmt.Errorf("%w: %d tags, at most %d allowed", ErrInvalidTags, len(tags), MaxTagsPerFile)
+	}
+
+	for key, value := range tags {
+		switch {
+		case key == "":
+			return fmt.Errorf("%w: empty key", ErrInvalidTags)
+		case strings.Contains(key, ":"):
+			return fmt.Errorf("%w: key %q contains ':'", ErrInvalidTags, key)
+		case utf8.RuneCountInString(key) > MaxTagKeyLength:
+			return fmt.Errorf("%w: key %q longer than %d characters", ErrInvalidTags, key, MaxTagKeyLength)
+		case utf8.RuneCountInString(value) > MaxTagValueLength:
+			return fmt.Errorf("%w: value of %q longer than %d characters", ErrInvalidTags, key, MaxTagValueLength)
+		case !utf8.ValidString(key) || !utf8.ValidString(value):
+			return fmt.Errorf("%w: tags must be valid UTF-8", ErrInvalidTags)
+		}
+	}
+
+	return nil
+}
+
+// SetFileTags replaces all tags of a file
+func (s *FileService) SetFileTags(fileID string, tags map[string]string) error {
+	if err := ValidateTags(tags); err != nil {
+		return err
+	}
+	return s.db.SetFileTags(fileID, tags)
+}
+
+// FileTags returns the tags of a file
+func (s *FileService) FileTags(fileID string) (map[string]string, error) {
+	return s.db.GetFileTags(fileID)
+}
+
+// FindFilesByTags returns the files carrying every one of the given tags,
+// newest first
+func (s *FileService) FindFilesByTags(tags map[string]string) ([]metadata.FileRecord, error) {
+	return s.db.FindFilesByTags(tags)
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Registers GIF decoding for thumbnails
+	"image/jpeg"
+	_ "image/png" // Registers PNG decoding for thumbnails
+	"log"
+	"strings"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+const (
+	// MaxThumbnailSourceSize is the largest upload a thumbnail is made of.
+	// The image is held in memory up to this size while it is uploaded.
+	MaxThumbnailSourceSize = 32 << 20
+
+	// maxThumbnailPixels rejects images that would decode to far more
+	// memory than their file size suggests
+	maxThumbnailPixels = 50_000_000
+
+	thumbnailQuality = 80
+
+	// thumbnailSamples caps the source pixels averaged into each thumbnail
+	// pixel, per axis, so scaling a huge image stays cheap
+	thumbnailSamples = 4
+)
+
+// UseThumbnails makes image uploads get a JPEG thumbnail at most size pixels
+// on its longer side, served without downloading the file. Encrypted
+// uploads never get one. Zero disables thumbnails.
+func (s *FileService) UseThumbnails(size int) error {
+	if size < 0 {
+		return fmt.Errorf("thumbnail size must not be negative, got %d", size)
+	}
+	s.thumbnailSize = size
+	return nil
+}
+
+// Thumbnail returns the JPEG thumbnail of a file. It fails with
+// metadata.ErrThumbnailNotFound if the file has none, and ErrFileExpired
+// once the file has expired.
+func (s *FileService) Thumbnail(fileID string) ([]byte, error) {
+	file, err := s.db.GetFile(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file.Expired(time.Now()) {
+		return nil, ErrFileExpired
+	}
+	return s.db.GetThumbnail(fileID)
+}
+
+// wantsThumbnail reports whether an upload should get a thumbnail. Whether
+// the content really is an image is only known once it is decoded.
+func (s *FileService) wantsThumbnail(meta UploadMetadata) bool {
+	return s.thumbnailSize > 0 &&
+		meta.Password == "" && !meta.ClientEncrypted &&
+		strings.HasPrefix(meta.ContentType, "image/") &&
+		meta.Size <= MaxThumbnailSourceSize
+}
+
+// imageCapture keeps a copy of an upload for its thumbnail, giving up once
+// it grows past MaxThumbnailSourceSize
+type imageCapture struct {
+	data     bytes.Buffer
+	overflow bool
+}
+
+func (c *imageCapture) Write(p []byte) (int, error) {
+	if c.overflow {
+		return len(p), nil
+	}
+	if c.data.Len()+len(p) > MaxThumbnailSourceSize {
+		c.overflow = true
+		c.data = bytes.Buffer{}
+		return len(p), nil
+	}
+	return c.data.Write(p)
+}
+
+// saveThumbnail stores a thumbnail of a captured image. Images that can't
+// be decoded or stored are skipped; the upload has succeeded either way.
+func (s *FileService) saveThumbnail(fileID string, capture *imageCapture) {
+	if capture == nil || capture.overflow || capture.data.Len() == 0 {
+		return
+	}
+
+	thumbnail, err := makeThumbnail(capture.data.Bytes(), s.thumbnailSize)
+	if err != nil {
+		log.Printf("No thumbnail for %s: %v", fileID, err)
+		return
+	}
+	if err := s.db.SetThumbnail(fileID, thumbnail); err != nil {
+		log.Printf("Failed to save thumbnail of %s: %v", fileID, err)
+		return
+	}
+
+	log.Printf("Saved %d byte thumbnail of %s", len(thumbnail), fileID)
+}
+
+// copyThumbnail gives a file uploaded as a duplicate the thumbnail of the
+// file it duplicates, if that has one
+func (s *FileService) copyThumbnail(fromID, toID string) {
+	thumbnail, err := s.db.GetThumbnail(fromID)
+	if errors.Is(err, metadata.ErrThumbnailNotFound) {
+		return
+	}
+	if err == nil {
+		err = s.db.SetThumbnail(toID, thumbnail)
+	}
+	if err != nil {
+		log.Printf("Failed to copy thumbnail of %s to %s: %v", fromID, toID, err)
+	}
+}
+
+// makeThumbnail decodes a JPEG, PNG or GIF image and returns it as a JPEG
+// scaled to fit within size by size pixels. Smaller images keep their size.
+// Transparent areas are flattened onto white.
+func makeThumbnail(data []byte, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("image is %dx%d, too large to scale", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+
+	thumbWidth, thumbHeight := width, height
+	if width >= height && width > size {
+		thumbWidth, thumbHeight = size, max(height*size/width, 1)
+	} else if height > width && height > size {
+		thumbWidth, thumbHeight = max(width*size/height, 1), size
+	}
+
+	// Average a grid of samples from the source area behind each pixel
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		y0 := bounds.Min.Y + y*height/thumbHeight
+		y1 := bounds.Min.Y + (y+1)*height/thumbHeight
+		stepY := max((y1-y0)/thumbnailSamples, 1)
+
+		for x := 0; x < thumbWidth; x++ {
+			x0 := bounds.Min.X + x*width/thumbWidth
+			x1 := bounds.Min.X + (x+1)*width/thumbWidth
+			stepX := max((x1-x0)/thumbnailSamples, 1)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					sr, sg, sb, sa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+sr, g+sg, b+sb, a+sa
+					n++
+				}
+			}
+
+			// Colors are alpha-premultiplied, so adding the uncovered
+			// part of white composites onto a white background
+			white := 0xffff - a/n
+			thumb.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + white) >> 8),
+				G: uint8((g/n + white) >> 8),
+				B: uint8((b/n + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, thumb, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// samplePNG returns a width by height PNG: a gradient, fully transparent in
+// its top-left corner
+func samplePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			alpha := uint8(0xff)
+			if x < width/4 && y < height/4 {
+				alpha = 0
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 0x80, A: alpha})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodeThumbnail decodes a thumbnail, which must be a JPEG
+func decodeThumbnail(t *testing.T, thumbnail []byte) image.Image {
+	t.Helper()
+
+	img, format, err := image.Decode(bytes.NewReader(thumbnail))
+	if err != nil {
+		t.Fatalf("thumbnail doesn't decode: %v", err)
+	}
+	if format != "jpeg" {
+		t.Fatalf("want a JPEG thumbnail, got %s", format)
+	}
+	return img
+}
+
+// TestUploadThumbnail uploads a PNG and checks its thumbnail is a JPEG
+// scaled to fit, with transparency flattened onto white, and that a
+// duplicate upload shares it
+func TestUploadThumbnail(t *testing.T) {
+	s, _, _ := newTestService(t)
+	if err := s.UseThumbnails(64); err != nil {
+		t.Fatal(err)
+	}
+
+	data := samplePNG(t, 300, 150)
+	result := upload(t, s, data, UploadMetadata{FileName: "sample.png", ContentType: "image/png"})
+	if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+		t.Fatal("download differs from the uploaded image")
+	}
+
+	thumbnail, err := s.Thumbnail(result.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := decodeThumbnail(t, thumbnail)
+	if size := img.Bounds().Size(); size != image.Pt(64, 32) {
+		t.Errorf("want a 64x32 thumbnail, got %v", size)
+	}
+	if r, g, b, _ := img.At(2, 2).RGBA(); r>>8 < 0xf0 || g>>8 < 0xf0 || b>>8 < 0xf0 {
+		t.Errorf("want the transparent corner white, got %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+
+	duplicate := upload(t, s, data, UploadMetadata{FileName: "copy.png", ContentType: "image/png"})
+	if copied, err := s.Thumbnail(duplicate.FileID); err != nil || !bytes.Equal(copied, thumbnail) {
+		t.Errorf("want the duplicate to share the thumbnail, got %d bytes (%v)", len(copied), err)
+	}
+}
+
+// TestUploadWithoutThumbnail checks uploads that aren't images, or are
+// encrypted, or arrive while thumbnails are off, still succeed without one
+func TestUploadWithoutThumbnail(t *testing.T) {
+	s, _, _ := newTestService(t)
+	picture := samplePNG(t, 100, 100)
+
+	if err := s.UseThumbnails(0); err != nil {
+		t.Fatal(err)
+	}
+	disabled := upload(t, s, picture, UploadMetadata{ContentType: "image/png"})
+
+	if err := s.UseThumbnails(64); err != nil {
+		t.Fatal(err)
+	}
+	for name, fileID := range map[string]string{
+		"thumbnails off": disabled.FileID,
+		"not an image":   upload(t, s, []byte("plain text"), UploadMetadata{ContentType: "text/plain"}).FileID,
+		"not a real PNG": upload(t, s, []byte("not a png at all"), UploadMetadata{ContentType: "image/png"}).FileID,
+		"encrypted":      upload(t, s, picture, UploadMetadata{ContentType: "image/png", Password: "secret"}).FileID,
+	} {
+		if _, err := s.Thumbnail(fileID); !errors.Is(err, metadata.ErrThumbnailNotFound) {
+			t.Errorf("%s: want ErrThumbnailNotFound, got %v", name, err)
+		}
+	}
+
+	if _, err := s.Thumbnail("missing"); !errors.Is(err, metadata.ErrFileNotFound) {
+		t.Errorf("unknown file: want ErrFileNotFound, got %v", err)
+	}
+	if err := s.UseThumbnails(-1); err == nil {
+		t.Error("want an error for a negative size")
+	}
+}
+
+// TestMakeThumbnailSizes checks tall images fit their height, and images
+// already small enough keep their size
+func TestMakeThumbnailSizes(t *testing.T) {
+	for _, tc := range []struct {
+		width, height int
+		want          image.Point
+	}{
+		{100, 400, image.Pt(16, 64)},
+		{64, 64, image.Pt(64, 64)},
+		{30, 20, image.Pt(30, 20)},
+		{1000, 1, image.Pt(64, 1)},
+	} {
+		thumbnail, err := makeThumbnail(samplePNG(t, tc.width, tc.height), 64)
+		if err != nil {
+			t.Fatalf("%dx%d: %v", tc.width, tc.height, err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(thumbnail))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size != tc.want {
+			t.Errorf("%dx%d: want %v, got %v", tc.width, tc.height, tc.want, size)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// DeleteFile moves a file to the trash. Its chunks are kept until the file
+// is purged so it can still be restored.
+func (s *FileService) DeleteFile(fileID string) error {
+	return s.db.SoftDeleteFile(fileID)
+}
+
+// RestoreFile takes a file back out of the trash
+func (s *FileService) RestoreFile(fileID string) error {
+	return s.db.RestoreFile(fileID)
+}
+
+// ListTrash returns the files currently in the trash
+func (s *FileService) ListTrash() ([]metadata.FileRecord, error) {
+	return s.db.ListDeletedFiles()
+}
+
+// PurgeDeletedFiles permanently removes files that have been in the trash
+// longer than the retention period and returns how many were purged
+func (s *FileService) PurgeDeletedFiles(retention time.Duration) (int, error) {
+	files, err := s.db.ListFilesDeletedBefore(time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, file := range files {
+		if err := s.PurgeFile(file.FileID); err != nil {
+			log.Printf("Failed to purge file %s: %v", file.FileID, err)
+			continue
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// PurgeFile permanently deletes a file and the data of any chunks that no
+// other file references
+func (s *FileService) PurgeFile(fileID string) error {
+	// No upload counts a chunk as stored while it is deleted
+	s.storing.Lock()
+	defer s.storing.Unlock()
+
+	orphaned, err := s.db.PurgeFile(fileID)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range orphaned {
+		s.deleteChunkData(chunk)
+	}
+
+	log.Printf("Purged file %s (%d chunks freed)", fileID, len(orphaned))
+	return nil
+}
+
+// deleteChunkData removes an unreferenced chunk, and the shards of an
+// erasure-coded chunk, from every backend. Failures are only logged; a
+// leftover copy is harmless and the metadata is already gone.
+func (s *FileService) deleteChunkData(chunk metadata.ChunkRecord) {
+	for _, hash := range append([]string{chunk.ChunkHash}, chunk.ShardHashes...) {
+		if err := s.backend.Delete(hash); err != nil {
+			log.Printf("Failed to delete chunk %s: %v", hash[:8], err)
+		}
+	}
+}
+
+// deleteChunkFromNode removes a chunk from a node over gRPC, or its HTTP
+// API. A node that never held the chunk is not an error.
+func (s *FileService) deleteChunkFromNode(chunkHash string, nodeInfo *node.NodeInfo) error {
+	if nodeInfo.Supports(node.TransportGRPC) {
+		return s.grpcDeleteChunk(nodeInfo, chunkHash)
+	}
+
+	return s.nodeClient(nodeInfo).Delete(context.Background(), chunkHash)
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// TestDeleteRestore moves a file to the trash and back, and checks it is
+// hidden and unreadable only while it is there
+func TestDeleteRestore(t *testing.T) {
+	s, db, _ := newTestService(t)
+	data := randomBytes(t, 1000)
+	result := upload(t, s, data, UploadMetadata{})
+
+	if err := s.DeleteFile(result.FileID); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := db.ListFiles(); len(files) != 0 {
+		t.Errorf("want deleted files left out of the listing, got %d", len(files))
+	}
+	if trash, _ := s.ListTrash(); len(trash) != 1 || trash[0].FileID != result.FileID || trash[0].DeletedAt == nil {
+		t.Errorf("want the file in the trash with its deletion time, got %+v", trash)
+	}
+	if _, err := s.DownloadFile(context.Background(), result.FileID, ""); !errors.Is(err, metadata.ErrFileNotFound) {
+		t.Errorf("want a deleted file not found, got %v", err)
+	}
+	if err := s.DeleteFile(result.FileID); !errors.Is(err, metadata.ErrFileNotFound) {
+		t.Errorf("want deleting it again to find nothing, got %v", err)
+	}
+
+	if err := s.RestoreFile(result.FileID); err != nil {
+		t.Fatal(err)
+	}
+	if trash, _ := s.ListTrash(); len(trash) != 0 {
+		t.Errorf("want the trash empty, got %d files", len(trash))
+	}
+	if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+		t.Error("restored file differs from upload")
+	}
+	if err := s.RestoreFile(result.FileID); !errors.Is(err, metadata.ErrFileNotFound) {
+		t.Errorf("want restoring a file not in the trash to fail, got %v", err)
+	}
+}
+
+// TestPurgeDeletedFiles purges files past the retention period, and checks
+// their chunks are freed unless another file still uses them
+func TestPurgeDeletedFiles(t *testing.T) {
+	s, _, chunks := newTestService(t)
+
+	data := randomBytes(t, 1000)
+	deleted := upload(t, s, randomBytes(t, 1000), UploadMetadata{})
+	shared := upload(t, s, data, UploadMetadata{})
+	kept := upload(t, s, data, UploadMetadata{})
+	for _, fileID := range []string{deleted.FileID, shared.FileID} {
+		if err := s.DeleteFile(fileID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing has been in the trash for an hour
+	if purged, err := s.PurgeDeletedFiles(time.Hour); err != nil || purged != 0 {
+		t.Fatalf("want nothing purged yet, got %d: %v", purged, err)
+	}
+	if err := s.RestoreFile(deleted.FileID); err != nil {
+		t.Fatalf("file gone before its retention ran out: %v", err)
+	}
+	if err := s.DeleteFile(deleted.FileID); err != nil {
+		t.Fatal(err)
+	}
+
+	if purged, err := s.PurgeDeletedFiles(0); err != nil || purged != 2 {
+		t.Fatalf("want 2 files purged, got %d: %v", purged, err)
+	}
+	if trash, _ := s.ListTrash(); len(trash) != 0 {
+		t.Errorf("want the trash empty, got %d files", len(trash))
+	}
+	if err := s.RestoreFile(deleted.FileID); !errors.Is(err, metadata.ErrFileNotFound) {
+		t.Errorf("want a purged file gone for good, got %v", err)
+	}
+
+	for _, hash := range deleted.ChunkHashes {
+		if chunks.HasChunk(hash) {
+			t.Errorf("chunk %s of the purged file not freed", hash[:8])
+		}
+	}
+	if got := download(t, s, kept.FileID, ""); !bytes.Equal(got, data) {
+		t.Error("file sharing chunks with a purged one no longer downloads")
+	}
+}
+
+// lookupHookStore calls afterLookup once an upload has looked up which
+// chunks are already stored, before it references them
+type lookupHookStore struct {
+	*metadata.MemoryStore
+	afterLookup func()
+}
+
+func (h *lookupHookStore) GetChunks(hashes []string) (map[string]*metadata.ChunkRecord, error) {
+	chunks, err := h.MemoryStore.GetChunks(hashes)
+	if h.afterLookup != nil {
+		h.afterLookup()
+	}
+	return chunks, err
+}
+
+func (h *lookupHookStore) GetFileWithChunks(fileID string) (*metadata.FileRecord, []metadata.ChunkDescriptor, error) {
+	file, chunks, err := h.MemoryStore.GetFileWithChunks(fileID)
+	if h.afterLookup != nil {
+		h.afterLookup()
+	}
+	return file, chunks, err
+}
+
+// TestPurgeRacingUpload purges a file just as an upload of the same content
+// finds its chunks stored, and checks the new copy keeps them
+func TestPurgeRacingUpload(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		reader func(data []byte) io.Reader
+	}{
+		// Seekable uploads reuse the purged file's chunks whole
+		{"seekable", func(data []byte) io.Reader { return bytes.NewReader(data) }},
+		// Streamed uploads find the chunks stored one by one
+		{"stream", func(data []byte) io.Reader { return struct{ io.Reader }{bytes.NewReader(data)} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &lookupHookStore{MemoryStore: metadata.NewMemoryStore()}
+			s, _ := newTestServiceWith(t, db)
+			s.UseInlineThreshold(1024)
+
+			data := randomBytes(t, 64<<10)
+			first := upload(t, s, data, UploadMetadata{})
+
+			// Purge the first file while the second upload holds the lookup
+			var wg sync.WaitGroup
+			var once sync.Once
+			var purgeErr error
+			db.afterLookup = func() {
+				once.Do(func() {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						purgeErr = s.PurgeFile(first.FileID)
+					}()
+					time.Sleep(50 * time.Millisecond)
+				})
+			}
+
+			second, err := s.UploadFile(context.Background(), tc.reader(data), UploadMetadata{FileName: "test.bin", Size: int64(len(data))})
+			wg.Wait()
+			if err != nil {
+				t.Fatalf("upload failed: %v", err)
+			}
+			if purgeErr != nil {
+				t.Fatalf("purge failed: %v", purgeErr)
+			}
+
+			db.afterLookup = nil
+			if got := download(t, s, second.FileID, ""); !bytes.Equal(got, data) {
+				t.Fatal("downloaded data differs from upload")
+			}
+		})
+	}
+}
@@ -0,0 +1,744 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+)
+
+// UploadMetadata describes a file being uploaded
+type UploadMetadata struct {
+	FileName    string // Logical name; uploads with the same name are versions of one file
+	Size        int64
+	Password    string // Optional; enables encryption when set
+	Algorithm   string // Optional; AEAD to encrypt with, the service default if empty
+	ContentType string // Optional; as sent by the client
+	Replication int    // Optional; nodes holding each chunk, ReplicationCount if zero
+
+	// DisableDedup stores the file's chunks under a salt of its own, so
+	// they are never shared with other files, even identical ones. It has
+	// no effect on password-encrypted uploads, which are never shared.
+	DisableDedup bool
+
+	Tags map[string]string // Optional; stored with the file
+
+	// ExpiresAt is when the file stops being served and becomes due for
+	// purging. Optional; the file never expires if nil.
+	ExpiresAt *time.Time
+
+	// ClientEncrypted marks data the client already encrypted. The server
+	// stores it as-is alongside the opaque ClientEncryption parameters and
+	// must not be given a password.
+	ClientEncrypted  bool
+	ClientEncryption string
+
+	Progress ProgressFunc // Optional; receives progress as chunks are processed
+}
+
+// UploadResult summarizes a completed upload
+type UploadResult struct {
+	FileID       string   `json:"file_id"`
+	FileName     string   `json:"file_name"`
+	Version      int      `json:"version"`
+	Size         int64    `json:"size"`
+	ChunkHashes  []string `json:"chunk_hashes"`
+	ChunksStored int      `json:"chunks_stored"`
+	DedupRatio   float64  `json:"dedup_ratio"`
+	Encrypted    bool     `json:"encrypted"`
+	Replication  int      `json:"replication"`
+
+	// MinReplicas is the fewest storage nodes that acknowledged any chunk
+	// the upload newly replicated; omitted if it replicated none
+	MinReplicas int `json:"min_replicas,omitempty"`
+
+	// FileDeduplicated is set when the whole file matched one already
+	// stored, so its chunks were reused without chunking or storing
+	FileDeduplicated bool `json:"file_deduplicated,omitempty"`
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Set for files that expire
+}
+
+// UploadFile runs the upload pipeline for a single file: chunking, optional
+// encryption, distribution (or local fallback), and metadata persistence.
+// If it fails, or ctx is done before the file is saved, the upload stops,
+// releases the chunks it already stored and returns the error.
+func (s *FileService) UploadFile(ctx context.Context, file io.Reader, meta UploadMetadata) (*UploadResult, error) {
+	if meta.ClientEncrypted && meta.Password != "" {
+		return nil, ErrPasswordNotAllowed
+	}
+	if err := ValidateTags(meta.Tags); err != nil {
+		return nil, err
+	}
+
+	// A factor above the cluster size could never be met. Without storage
+	// nodes chunks are kept locally, where the factor doesn't apply.
+	replication := ReplicationCount
+	if meta.Replication != 0 {
+		healthy := len(s.registry.GetHealthyNodes())
+		if meta.Replication < 1 || (healthy > 0 && meta.Replication > healthy) {
+			return nil, ErrInvalidReplication
+		}
+		replication = meta.Replication
+	}
+
+	// Check for encryption
+	var encryptionKey *crypto.EncryptionKey
+	var encryptionSalt string
+	var noncePrefix []byte
+	var passwordHash string
+	var encryptionAlgorithm string
+
+	if meta.Password != "" {
+		algorithm := s.encryptionAlgorithm
+		if meta.Algorithm != "" {
+			parsed, err := crypto.ParseAlgorithm(meta.Algorithm)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidAlgorithm, err)
+			}
+			algorithm = parsed
+		}
+
+		key, err := crypto.DeriveKey(meta.Password, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+		}
+		defer key.Zero()
+		key.Algorithm = algorithm
+		encryptionKey = key
+		encryptionAlgorithm = algorithm
+		encryptionSalt = fmt.Sprintf("%x", key.Salt)
+		noncePrefix, err = crypto.NewNoncePrefix()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate nonce prefix: %w", err)
+		}
+		passwordHash = crypto.PasswordVerifier(key)
+		log.Printf("Encryption enabled for upload (%s)", algorithm)
+	}
+
+	// Without deduplication every chunk is stored with a per-file salt in
+	// front, so its hash matches no other file's chunk
+	var dedupSalt []byte
+	if meta.DisableDedup && encryptionKey == nil {
+		dedupSalt = make([]byte, DedupSaltSize)
+		if _, err := rand.Read(dedupSalt); err != nil {
+			return nil, fmt.Errorf("failed to generate dedup salt: %w", err)
+		}
+	}
+
+	// Generate file ID
+	fileID := uuid.New().String()
+
+	log.Printf("Uploading: %s (ID: %s, Size: %d bytes, Encrypted: %v)",
+		meta.FileName, fileID, meta.Size, encryptionKey != nil)
+
+	progress := newProgressTracker(meta.Size, meta.Progress)
+
+	record := &metadata.FileRecord{
+		FileID:       fileID,
+		FileName:     meta.FileName,
+		FileSize:     meta.Size,
+		Encrypted:    encryptionKey != nil,
+		ContentType:  meta.ContentType,
+		Replication:  replication,
+		Salt:         encryptionSalt,
+		NoncePrefix:  hex.EncodeToString(noncePrefix),
+		PasswordHash: passwordHash,
+		Tags:         meta.Tags,
+
+		EncryptionAlgorithm: encryptionAlgorithm,
+		DedupSalt:           hex.EncodeToString(dedupSalt),
+		ExpiresAt:           meta.ExpiresAt,
+
+		ClientEncrypted:  meta.ClientEncrypted,
+		ClientEncryption: meta.ClientEncryption,
+	}
+
+	// Hash the whole file so identical uploads can share one chunk set.
+	// Seekable input is hashed before chunking, so a duplicate is never
+	// chunked. A stream is hashed as it is chunked, and can only be matched
+	// if it fits in one batch, since earlier batches are already stored.
+	// Encrypted uploads are never matched, since their chunks depend on the
+	// key, and neither are uploads with deduplication disabled.
+	var fileHasher hash.Hash
+	if encryptionKey == nil {
+		if seeker, ok := file.(io.ReadSeeker); ok {
+			fileHash, err := hashFile(seeker)
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			record.FileHash = fileHash
+
+			if dedupSalt == nil {
+				if result, err := s.uploadDuplicate(record, progress); result != nil || err != nil {
+					return result, err
+				}
+			}
+		} else {
+			fileHasher = sha256.New()
+			file = io.TeeReader(file, fileHasher)
+		}
+	}
+
+	// Keep a copy of images small enough to make a thumbnail of
+	var capture *imageCapture
+	if s.wantsThumbnail(meta) {
+		capture = &imageCapture{}
+		file = io.TeeReader(file, capture)
+	}
+
+	// Chunk, encrypt and store the file in batches of UploadBatchBytes, so
+	// only one batch of chunk data is held in memory however large the file.
+	// Each chunk's nonce is derived from its index, so none repeat in a file.
+	chunkHashes := []string{} // Empty, not null, in the result of an empty file
+	var chunkOffsets []int64
+	var batch []*chunking.Chunk
+	batchBytes := 0
+	batchesStored := 0
+	newChunksStored := 0
+	minReplicas := 0 // Fewest nodes acknowledging a newly replicated chunk
+	referenced := 0  // Leading chunkHashes whose chunks this upload holds a reference to
+
+	// A failure before the file is saved with every chunk linked releases
+	// the references the upload took, and the file if it was saved
+	saved, linked, committed := false, 0, false
+	defer func() {
+		switch {
+		case committed:
+		case saved:
+			s.abandonFile(fileID, chunkHashes[linked:])
+		default:
+			s.abandonUpload(chunkHashes[:referenced])
+		}
+	}()
+
+	// Chunk buffers held from the shared limit, if any: one per chunk in
+	// the batch, plus one for the chunk being read
+	buffers := 0
+	defer func() {
+		if s.chunkBuffers != nil {
+			s.chunkBuffers.release(buffers)
+		}
+	}()
+
+	flushBatch := func() error {
+		stored, replicas, err := s.storeChunks(ctx, batch, replication, progress)
+		if s.chunkBuffers != nil {
+			s.chunkBuffers.release(buffers)
+			buffers = 0
+		}
+		if err != nil {
+			return err
+		}
+		newChunksStored += stored
+		if replicas > 0 && (minReplicas == 0 || replicas < minReplicas) {
+			minReplicas = replicas
+		}
+		referenced = len(chunkHashes)
+		batch, batchBytes = nil, 0
+		batchesStored++
+		return nil
+	}
+
+	// Files below the inline threshold are stored whole as one chunk
+	head, err := io.ReadAll(io.LimitReader(file, s.inlineThreshold))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	var nextChunk func() (*chunking.Chunk, error)
+	if int64(len(head)) < s.inlineThreshold {
+		record.SingleChunk = len(head) > 0
+		nextChunk = wholeChunk(head, s.hashAlgorithm)
+	} else {
+		chunkReader := chunking.NewChunkReader(io.MultiReader(bytes.NewReader(head), file))
+		chunkReader.UseHashAlgorithm(s.hashAlgorithm)
+		chunkReader.UseHashWorkers(s.hashWorkers)
+		defer chunkReader.Close()
+		nextChunk = chunkReader.NextChunk
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Waiting for a buffer while holding some could leave uploads
+		// waiting on each other, so store the batch first
+		if s.chunkBuffers != nil {
+			if !s.chunkBuffers.tryAcquire() {
+				if len(batch) > 0 {
+					if err := flushBatch(); err != nil {
+						return nil, err
+					}
+				}
+				if err := s.chunkBuffers.acquire(ctx); err != nil {
+					return nil, err
+				}
+			}
+			buffers++
+		}
+
+		chunk, err := nextChunk()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to chunk file: %w", err)
+		}
+		progress.chunked(chunk.Size)
+
+		if encryptionKey != nil {
+			index := len(chunkHashes)
+			encrypted, err := crypto.EncryptChunkAt(chunk.Data, encryptionKey, noncePrefix, uint64(index))
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt chunk %d: %w", index, err)
+			}
+			chunk.Data = encrypted
+
+			// Recalculate hash for encrypted data
+			chunk.Hash = chunk.Algorithm.Sum(chunk.Data)
+		}
+		if dedupSalt != nil {
+			chunk.Data = append(append(make([]byte, 0, len(dedupSalt)+len(chunk.Data)), dedupSalt...), chunk.Data...)
+			chunk.Hash = chunk.Algorithm.Sum(chunk.Data)
+		}
+
+		chunkHashes = append(chunkHashes, chunk.Hash)
+		chunkOffsets = append(chunkOffsets, chunk.Offset)
+		batch = append(batch, chunk)
+		batchBytes += len(chunk.Data)
+
+		if batchBytes >= UploadBatchBytes {
+			if err := flushBatch(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if record.SingleChunk {
+		log.Printf("Stored whole as a single chunk (below the %d byte inline threshold)", s.inlineThreshold)
+	} else {
+		log.Printf("Created %d content-defined chunks", len(chunkHashes))
+	}
+
+	if fileHasher != nil {
+		record.FileHash = hex.EncodeToString(fileHasher.Sum(nil))
+		if batchesStored == 0 && dedupSalt == nil {
+			if result, err := s.uploadDuplicate(record, progress); result != nil || err != nil {
+				return result, err
+			}
+		}
+	}
+
+	progress.stage(StageStoring)
+	if err := flushBatch(); err != nil {
+		return nil, err
+	}
+
+	// Saving the file is the point of no return; after it the upload
+	// completes even if ctx is done
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Save file metadata to database; uploads sharing a name become new versions
+	if err := s.db.CreateFile(record); err != nil {
+		return nil, fmt.Errorf("failed to save file metadata: %w", err)
+	}
+	saved = true
+
+	// Link file to chunks in database
+	for i, chunkHash := range chunkHashes {
+		if err := s.db.LinkFileChunk(fileID, chunkHash, i, chunkOffsets[i]); err != nil {
+			return nil, fmt.Errorf("failed to link file chunks: %w", err)
+		}
+		linked++
+	}
+	committed = true
+	s.saveThumbnail(fileID, capture)
+
+	dedupRatio := float64(len(chunkHashes)) / float64(max(newChunksStored, 1))
+	progress.stage(StageComplete)
+
+	log.Printf("Upload complete: %d total chunks, %d stored, %d deduplicated (%.2fx dedup ratio)",
+		len(chunkHashes), newChunksStored, len(chunkHashes)-newChunksStored, dedupRatio)
+
+	return &UploadResult{
+		FileID:       fileID,
+		FileName:     meta.FileName,
+		Version:      record.Version,
+		Size:         meta.Size,
+		ChunkHashes:  chunkHashes,
+		ChunksStored: newChunksStored,
+		MinReplicas:  minReplicas,
+		DedupRatio:   dedupRatio,
+		Encrypted:    encryptionKey != nil,
+		Replication:  replication,
+		ExpiresAt:    meta.ExpiresAt,
+	}, nil
+}
+
+// wholeChunk returns a chunk source yielding data as a single chunk, or no
+// chunks if data is empty
+func wholeChunk(data []byte, algorithm chunking.HashAlgorithm) func() (*chunking.Chunk, error) {
+	done := len(data) == 0
+	return func() (*chunking.Chunk, error) {
+		if done {
+			return nil, io.EOF
+		}
+		done = true
+		return chunking.WholeChunk(data, algorithm), nil
+	}
+}
+
+// hashFile returns the SHA-256 of a file's remaining contents and seeks
+// back to where it started
+func hashFile(file io.ReadSeeker) (string, error) {
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind file: %w", err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// uploadDuplicate saves record as a new file sharing the chunks of a stored
+// file with the same contents, adding one reference to each chunk. It
+// returns nil if no file matches record.FileHash, or if the match is kept
+// on fewer replicas than record asks for; the upload then goes through the
+// normal path, which raises the chunks' replication.
+func (s *FileService) uploadDuplicate(record *metadata.FileRecord, progress *progressTracker) (*UploadResult, error) {
+	// On failure, the references taken are released after the lock below
+	// is given up, since releasing them takes it for writing
+	var abandoned []string
+	savedFile := ""
+	defer func() {
+		if savedFile != "" {
+			s.abandonFile(savedFile, abandoned)
+		} else {
+			s.abandonUpload(abandoned)
+		}
+	}()
+
+	// The matched file's chunks are not freed before they are referenced
+	s.storing.RLock()
+	defer s.storing.RUnlock()
+
+	existing, err := s.db.GetFileByHash(record.FileHash)
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up file by hash: %w", err)
+	}
+	if existing.Replication < record.Replication {
+		return nil, nil
+	}
+
+	// A match that was trashed meanwhile, or has lost chunks, can't be
+	// reused; the upload then stores its chunks the normal way
+	_, chunks, err := s.db.GetFileWithChunks(existing.FileID)
+	var missing *metadata.MissingChunksError
+	if errors.As(err, &missing) {
+		log.Printf("Not reusing chunks of %s: %v", existing.FileID, err)
+		return nil, nil
+	}
+	if errors.Is(err, metadata.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunks of %s: %w", existing.FileID, err)
+	}
+	record.SingleChunk = existing.SingleChunk
+
+	chunkHashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		chunkHashes[i] = chunk.ChunkHash
+	}
+
+	for i, chunk := range chunks {
+		if _, err := s.db.CreateChunk(chunk.ChunkHash, int(chunk.Size), "", record.Replication, string(s.hashAlgorithm)); err != nil {
+			abandoned = chunkHashes[:i]
+			return nil, fmt.Errorf("failed to save metadata for chunk %d: %w", i, err)
+		}
+	}
+	if err := s.db.CreateFile(record); err != nil {
+		abandoned = chunkHashes
+		return nil, fmt.Errorf("failed to save file metadata: %w", err)
+	}
+
+	for i, chunk := range chunks {
+		if err := s.db.LinkFileChunk(record.FileID, chunk.ChunkHash, i, chunk.Offset); err != nil {
+			savedFile, abandoned = record.FileID, chunkHashes[i:]
+			return nil, fmt.Errorf("failed to link file chunks: %w", err)
+		}
+	}
+	if s.thumbnailSize > 0 {
+		s.copyThumbnail(existing.FileID, record.FileID)
+	}
+
+	progress.reused(record.FileSize, len(chunks))
+	progress.stage(StageComplete)
+	log.Printf("Upload complete: identical to %s, reused its %d chunks", existing.FileID, len(chunks))
+
+	return &UploadResult{
+		FileID:           record.FileID,
+		FileName:         record.FileName,
+		Version:          record.Version,
+		Size:             record.FileSize,
+		ChunkHashes:      chunkHashes,
+		DedupRatio:       float64(len(chunks)),
+		Replication:      record.Replication,
+		FileDeduplicated: true,
+		ExpiresAt:        record.ExpiresAt,
+	}, nil
+}
+
+// abandonUpload releases the references a failed or cancelled upload took
+// on the chunks it already stored, and deletes those no other file uses
+func (s *FileService) abandonUpload(hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	s.storing.Lock()
+	defer s.storing.Unlock()
+
+	orphaned, err := s.db.ReleaseChunks(hashes)
+	if err != nil {
+		log.Printf("Failed to release chunks of abandoned upload: %v", err)
+		return
+	}
+	for _, chunk := range orphaned {
+		s.deleteChunkData(chunk)
+	}
+
+	log.Printf("Upload abandoned: released %d chunks (%d freed)", len(hashes), len(orphaned))
+}
+
+// abandonFile purges a file whose upload failed after saving it, which
+// releases the chunks already linked to it, and releases the references
+// taken on the unlinked chunks
+func (s *FileService) abandonFile(fileID string, unlinked []string) {
+	if err := s.PurgeFile(fileID); err != nil {
+		log.Printf("Failed to purge file %s of failed upload: %v", fileID, err)
+		return
+	}
+	s.abandonUpload(unlinked)
+}
+
+// storeChunks stores a file's chunks on replication nodes each, skipping
+// those already stored, and adds one reference to each in the metadata
+// store. Stored chunks with a lower replication gain the missing replicas.
+// It returns how many chunks were newly stored, and the fewest nodes that
+// acknowledged any newly replicated chunk (0 if none went to nodes). Each
+// processed chunk is reported to progress, which may be nil. If it fails,
+// or ctx is done before the references are added, none are kept, and the
+// chunks only it referenced are deleted.
+func (s *FileService) storeChunks(ctx context.Context, chunks []*chunking.Chunk, replication int, progress *progressTracker) (int, int, error) {
+	// On failure, the references added are released after the lock below
+	// is given up, since releasing them takes it for writing
+	var abandoned []string
+	defer func() { s.abandonUpload(abandoned) }()
+
+	// Chunks found stored below are not deleted until they are referenced
+	s.storing.RLock()
+	defer s.storing.RUnlock()
+
+	// Classify chunks in one query so already-stored chunks are not sent again
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = chunk.Hash
+	}
+	existing, err := s.db.GetChunks(hashes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to look up existing chunks: %w", err)
+	}
+
+	var pending []*chunking.Chunk
+	for i, chunk := range chunks {
+		record := existing[chunk.Hash]
+		if record == nil {
+			pending = append(pending, chunk)
+			continue
+		}
+		// Equal hashes from different algorithms say nothing about the data
+		if algorithm := record.Algorithm(); algorithm != string(chunk.Algorithm) {
+			return 0, 0, fmt.Errorf("chunk %d hashes to %s under %s, which is stored as a %s hash", i, chunk.Hash[:8], chunk.Algorithm, algorithm)
+		}
+		if s.verifyDedup {
+			if err := s.verifyDuplicate(ctx, chunk); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	// Store new chunks on the configured backends, batching where possible.
+	// If the upload fails, those not recorded yet are deleted again before
+	// the lock is given up; the recorded ones are released as abandoned.
+	placements := map[string]*Placement{}
+	if len(pending) > 0 {
+		placements = storeBatch(ctx, s.backend, pending, replication)
+	}
+	recorded := false
+	defer func() {
+		if !recorded {
+			s.discardPlacements(placements)
+		}
+	}()
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	// Erasure-coded chunks are only placed once every shard is stored
+	if s.writeQuorum > 0 {
+		quorum := s.writeQuorumFor(replication)
+		for _, chunk := range pending {
+			placement := placements[chunk.Hash]
+			if placement != nil && (placement.Coding != nil || len(placement.Nodes) >= quorum) {
+				continue
+			}
+			acked := 0
+			if placement != nil {
+				acked = len(placement.Nodes)
+			}
+			return 0, 0, fmt.Errorf("%w: chunk %s stored on %d of %d required nodes", ErrWriteQuorum, chunk.Hash[:8], acked, quorum)
+		}
+	}
+
+	// Store chunks with deduplication
+	newChunksStored := 0
+	minReplicas := 0
+	var replicateLater []string // Queued chunks async mode copies right after the upload
+
+	for i, chunk := range chunks {
+		var storagePath string
+		var storedOn []string
+		var coding *metadata.ChunkCoding
+		var isNew bool
+		chunkReplication := replication
+
+		if record := existing[chunk.Hash]; record != nil {
+			// Already stored; only the reference count changes, unless this
+			// file wants more replicas than the chunk has
+			chunkReplication = record.Replication
+			if record.Replication < replication && strings.HasPrefix(record.StoragePath, "distributed:") {
+				added, err := s.addReplicas(ctx, chunk.Hash, chunk.Data, record.Replication, replication)
+				if err != nil {
+					log.Printf("Failed to raise replication of chunk %s: %v", chunk.Hash[:8], err)
+				} else {
+					chunkReplication = replication
+				}
+				storedOn = added
+			}
+		} else if placement := placements[chunk.Hash]; placement != nil {
+			storagePath = placement.StoragePath
+			storedOn = placement.Nodes
+			coding = placement.Coding
+			isNew = true
+			if coding == nil && len(storedOn) > 0 && (minReplicas == 0 || len(storedOn) < minReplicas) {
+				minReplicas = len(storedOn)
+			}
+		} else {
+			abandoned = hashes[:i]
+			return 0, 0, fmt.Errorf("failed to store chunk %d on any backend", i)
+		}
+
+		// Store chunk metadata in database
+		dbIsNew, err := s.db.CreateChunk(chunk.Hash, len(chunk.Data), storagePath, chunkReplication, string(chunk.Algorithm))
+		if err != nil {
+			abandoned = hashes[:i]
+			return 0, 0, fmt.Errorf("failed to save metadata for chunk %d: %w", i, err)
+		}
+		if coding != nil && dbIsNew {
+			if err := s.db.SetChunkCoding(chunk.Hash, coding); err != nil {
+				abandoned = hashes[:i+1]
+				return 0, 0, fmt.Errorf("failed to save coding for chunk %d: %w", i, err)
+			}
+		}
+		if err := s.db.AddChunkLocations(chunk.Hash, storedOn); err != nil {
+			abandoned = hashes[:i+1]
+			return 0, 0, fmt.Errorf("failed to save locations for chunk %d: %w", i, err)
+		}
+
+		// Replicas that couldn't be placed now are retried in the background
+		if isNew && dbIsNew && coding == nil && strings.HasPrefix(storagePath, "distributed:") && len(storedOn) < chunkReplication {
+			s.queueFailedPlacement(chunk.Hash, chunkReplication, storedOn)
+			if s.replicationMode == ReplicationAsync {
+				replicateLater = append(replicateLater, chunk.Hash)
+			}
+		}
+
+		if isNew && dbIsNew {
+			newChunksStored++
+			log.Printf("  Chunk %d: NEW (hash: %s..., size: %d bytes)",
+				i, chunk.Hash[:8], len(chunk.Data))
+		} else {
+			log.Printf("  Chunk %d: DEDUPLICATED (hash: %s...)", i, chunk.Hash[:8])
+		}
+		progress.stored(isNew && dbIsNew)
+	}
+
+	recorded = true
+	if len(replicateLater) > 0 {
+		go s.replicateQueued(replicateLater)
+	}
+
+	return newChunksStored, minReplicas, nil
+}
+
+// discardPlacements deletes chunks that were stored but never recorded in
+// the metadata store. Chunks another upload recorded meanwhile are kept.
+func (s *FileService) discardPlacements(placements map[string]*Placement) {
+	if len(placements) == 0 {
+		return
+	}
+
+	hashes := make([]string, 0, len(placements))
+	for hash := range placements {
+		hashes = append(hashes, hash)
+	}
+	recorded, err := s.db.GetChunks(hashes)
+	if err != nil {
+		log.Printf("Failed to look up chunks to discard: %v", err)
+		return
+	}
+
+	for hash, placement := range placements {
+		if recorded[hash] != nil {
+			continue
+		}
+
+		chunk := metadata.ChunkRecord{ChunkHash: hash}
+		if placement.Coding != nil {
+			for _, shard := range placement.Coding.Shards {
+				chunk.ShardHashes = append(chunk.ShardHashes, shard.ShardHash)
+			}
+		}
+		s.deleteChunkData(chunk)
+	}
+}
@@ -0,0 +1,715 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failingStore fails one kind of metadata write after it has succeeded a
+// given number of times
+type failingStore struct {
+	*metadata.MemoryStore
+
+	mu     sync.Mutex
+	method string // Write to fail: "CreateChunk", "AddChunkLocations", "CreateFile", "LinkFileChunk" or "RekeyFile"
+	after  int    // Calls that succeed before it fails
+	calls  int
+}
+
+func (f *failingStore) fail(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if method != f.method {
+		return nil
+	}
+	f.calls++
+	if f.calls > f.after {
+		return errInjected
+	}
+	return nil
+}
+
+// failOn makes the store fail method once it has succeeded after times
+func (f *failingStore) failOn(method string, after int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.method, f.after, f.calls = method, after, 0
+}
+
+func (f *failingStore) CreateChunk(chunkHash string, chunkSize int, storagePath string, replication int, hashAlgorithm string) (bool, error) {
+	if err := f.fail("CreateChunk"); err != nil {
+		return false, err
+	}
+	return f.MemoryStore.CreateChunk(chunkHash, chunkSize, storagePath, replication, hashAlgorithm)
+}
+
+func (f *failingStore) AddChunkLocations(chunkHash string, nodeIDs []string) error {
+	if err := f.fail("AddChunkLocations"); err != nil {
+		return err
+	}
+	return f.MemoryStore.AddChunkLocations(chunkHash, nodeIDs)
+}
+
+func (f *failingStore) CreateFile(file *metadata.FileRecord) error {
+	if err := f.fail("CreateFile"); err != nil {
+		return err
+	}
+	return f.MemoryStore.CreateFile(file)
+}
+
+func (f *failingStore) LinkFileChunk(fileID, chunkHash string, chunkOrder int, startOffset int64) error {
+	if err := f.fail("LinkFileChunk"); err != nil {
+		return err
+	}
+	return f.MemoryStore.LinkFileChunk(fileID, chunkHash, chunkOrder, startOffset)
+}
+
+func (f *failingStore) RekeyFile(fileID string, rekey *metadata.RekeyedFile) ([]metadata.ChunkRecord, error) {
+	if err := f.fail("RekeyFile"); err != nil {
+		return nil, err
+	}
+	return f.MemoryStore.RekeyFile(fileID, rekey)
+}
+
+// TestFailedUploadReleasesChunks fails each metadata write of an upload and
+// checks no chunk is left with a reference nothing holds, whether the
+// upload shares its chunks with a stored file or not
+func TestFailedUploadReleasesChunks(t *testing.T) {
+	readers := []struct {
+		name   string
+		reader func(data []byte) io.Reader
+	}{
+		{"seekable", func(data []byte) io.Reader { return bytes.NewReader(data) }},
+		{"stream", func(data []byte) io.Reader { return struct{ io.Reader }{bytes.NewReader(data)} }},
+	}
+	failures := []struct {
+		method       string
+		after        int
+		unsharedOnly bool // Uploads reusing a file's chunks don't make this write
+	}{
+		{"CreateChunk", 0, false},
+		{"CreateChunk", 2, false},
+		{"AddChunkLocations", 2, true},
+		{"CreateFile", 0, false},
+		{"LinkFileChunk", 0, false},
+		{"LinkFileChunk", 2, false},
+	}
+
+	// Enough for at least three content-defined chunks
+	stored := randomBytes(t, 3*chunking.MaxChunkSize)
+	fresh := randomBytes(t, 3*chunking.MaxChunkSize)
+
+	for _, r := range readers {
+		for _, f := range failures {
+			for _, shared := range []bool{false, true} {
+				if shared && f.unsharedOnly {
+					continue
+				}
+				name := fmt.Sprintf("%s/%s-after-%d/shared=%v", r.name, f.method, f.after, shared)
+				t.Run(name, func(t *testing.T) {
+					db := &failingStore{MemoryStore: metadata.NewMemoryStore()}
+					s, _ := newTestServiceWith(t, db)
+
+					first := upload(t, s, stored, UploadMetadata{})
+					if len(first.ChunkHashes) < 3 {
+						t.Fatalf("want at least 3 chunks, got %d", len(first.ChunkHashes))
+					}
+
+					data := fresh
+					if shared {
+						data = stored
+					}
+					db.failOn(f.method, f.after)
+					_, err := s.UploadFile(context.Background(), r.reader(data), UploadMetadata{FileName: "other.bin", Size: int64(len(data))})
+					if !errors.Is(err, errInjected) {
+						t.Fatalf("want the injected failure, got %v", err)
+					}
+					db.failOn("", 0)
+
+					files, err := db.ListFiles()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(files) != 1 {
+						t.Errorf("want only the first file left, got %d files", len(files))
+					}
+
+					audits, err := db.AuditChunks()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(audits) != len(first.ChunkHashes) {
+						t.Errorf("want %d chunks left, got %d", len(first.ChunkHashes), len(audits))
+					}
+					for _, audit := range audits {
+						if audit.RefCount != audit.Links {
+							t.Errorf("chunk %s has %d references but %d links", audit.ChunkHash[:8], audit.RefCount, audit.Links)
+						}
+					}
+
+					if got := download(t, s, first.FileID, ""); !bytes.Equal(got, stored) {
+						t.Error("first file no longer downloads intact")
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestFailedUploadLeavesNoChunksOnNodes fails recording a chunk of an
+// upload to three nodes and checks none of the nodes keeps any of its
+// chunks: neither those recorded before the failure nor those stored but
+// not recorded yet
+func TestFailedUploadLeavesNoChunksOnNodes(t *testing.T) {
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	chunks, err := chunking.ChunkFile(bytes.NewReader(data), chunking.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 3 {
+		t.Fatalf("want at least 3 chunks, got %d", len(chunks))
+	}
+
+	for _, f := range []struct {
+		method string
+		after  int
+	}{
+		{"CreateChunk", 0},
+		{"CreateChunk", 2},
+		{"AddChunkLocations", 2},
+	} {
+		t.Run(fmt.Sprintf("%s-after-%d", f.method, f.after), func(t *testing.T) {
+			db := &failingStore{MemoryStore: metadata.NewMemoryStore()}
+			s, _ := newTestServiceWith(t, db)
+			nodes := make([]*node.StorageNode, 3)
+			for i := range nodes {
+				nodes[i] = startTestNode(t, s, fmt.Sprintf("node-%d", i+1), nil)
+			}
+
+			db.failOn(f.method, f.after)
+			_, err := s.UploadFile(context.Background(), bytes.NewReader(data), UploadMetadata{FileName: "failed.bin", Size: int64(len(data))})
+			if !errors.Is(err, errInjected) {
+				t.Fatalf("want the injected failure, got %v", err)
+			}
+
+			for i, chunk := range chunks {
+				for _, sn := range nodes {
+					if nodeHolds(t, sn, chunk.Hash) {
+						t.Errorf("chunk %d (%s) left on %s", i, chunk.Hash[:8], sn.NodeID)
+					}
+				}
+			}
+			if audits, err := db.AuditChunks(); err != nil || len(audits) != 0 {
+				t.Errorf("want no chunks recorded, got %+v (%v)", audits, err)
+			}
+		})
+	}
+}
+
+// TestFailedRekeyReleasesChunks fails switching a file to its new chunks and
+// checks they are released, leaving the file readable with the old password
+func TestFailedRekeyReleasesChunks(t *testing.T) {
+	db := &failingStore{MemoryStore: metadata.NewMemoryStore()}
+	s, _ := newTestServiceWith(t, db)
+
+	data := randomBytes(t, 1000)
+	result := upload(t, s, data, UploadMetadata{Password: "old"})
+
+	db.failOn("RekeyFile", 0)
+	if _, err := s.RekeyFile(context.Background(), result.FileID, "old", "new"); !errors.Is(err, errInjected) {
+		t.Fatalf("want the injected failure, got %v", err)
+	}
+	db.failOn("", 0)
+
+	audits, err := db.AuditChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != len(result.ChunkHashes) {
+		t.Errorf("want %d chunks left, got %d", len(result.ChunkHashes), len(audits))
+	}
+	if got := download(t, s, result.FileID, "old"); !bytes.Equal(got, data) {
+		t.Error("file no longer downloads with the old password")
+	}
+}
+
+// lookupCountingStore counts the queries made to find stored chunks
+type lookupCountingStore struct {
+	*metadata.MemoryStore
+
+	mu        sync.Mutex
+	single    int // GetChunk calls
+	bulk      int // GetChunks and ChunksExist calls
+	bulkSizes []int
+}
+
+func (c *lookupCountingStore) GetChunk(chunkHash string) (*metadata.ChunkRecord, error) {
+	c.mu.Lock()
+	c.single++
+	c.mu.Unlock()
+	return c.MemoryStore.GetChunk(chunkHash)
+}
+
+func (c *lookupCountingStore) GetChunks(hashes []string) (map[string]*metadata.ChunkRecord, error) {
+	c.mu.Lock()
+	c.bulk++
+	c.bulkSizes = append(c.bulkSizes, len(hashes))
+	c.mu.Unlock()
+	return c.MemoryStore.GetChunks(hashes)
+}
+
+func (c *lookupCountingStore) ChunksExist(hashes []string) (map[string]bool, error) {
+	c.mu.Lock()
+	c.bulk++
+	c.bulkSizes = append(c.bulkSizes, len(hashes))
+	c.mu.Unlock()
+	return c.MemoryStore.ChunksExist(hashes)
+}
+
+// TestUploadLooksUpChunksInBulk checks an upload finds which of its chunks
+// are stored in one query for all of them, not one per chunk
+func TestUploadLooksUpChunksInBulk(t *testing.T) {
+	db := &lookupCountingStore{MemoryStore: metadata.NewMemoryStore()}
+	s, _ := newTestServiceWith(t, db)
+
+	stored := randomBytes(t, 3*chunking.MaxChunkSize)
+	upload(t, s, stored, UploadMetadata{})
+
+	// Half the chunks are already stored, half are new
+	data := append(stored[:len(stored)/2:len(stored)/2], randomBytes(t, len(stored)/2)...)
+	db.single, db.bulk, db.bulkSizes = 0, 0, nil
+	result := upload(t, s, data, UploadMetadata{})
+
+	if db.single != 0 {
+		t.Errorf("want no per-chunk lookups, got %d", db.single)
+	}
+	if db.bulk != 1 || db.bulkSizes[0] != len(result.ChunkHashes) {
+		t.Errorf("want 1 lookup of all %d chunks, got %d of %v", len(result.ChunkHashes), db.bulk, db.bulkSizes)
+	}
+	if result.ChunksStored == 0 || result.ChunksStored == len(result.ChunkHashes) {
+		t.Errorf("want some of the %d chunks stored and some reused, got %d stored", len(result.ChunkHashes), result.ChunksStored)
+	}
+}
+
+// TestUploadChunkNonces checks an encrypted file's chunks are sealed with
+// the counter nonces derived from the prefix stored with the file, so no
+// two share one, and that the file decrypts back to its plaintext
+func TestUploadChunkNonces(t *testing.T) {
+	s, db, chunks := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+	result := upload(t, s, data, UploadMetadata{Password: "secret"})
+
+	file, err := db.GetFile(result.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, err := hex.DecodeString(file.NoncePrefix)
+	if err != nil || len(prefix) != crypto.NoncePrefixSize {
+		t.Fatalf("want a %d-byte nonce prefix recorded, got %q", crypto.NoncePrefixSize, file.NoncePrefix)
+	}
+
+	seen := make(map[string]bool)
+	for i, hash := range result.ChunkHashes {
+		ciphertext, err := chunks.GetChunk(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := crypto.ChunkNonce(prefix, uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := ciphertext[:crypto.NonceSize]
+		if !bytes.Equal(nonce, want) {
+			t.Errorf("chunk %d: want nonce %x, got %x", i, want, nonce)
+		}
+		if seen[string(nonce)] {
+			t.Errorf("chunk %d reuses a nonce", i)
+		}
+		seen[string(nonce)] = true
+	}
+
+	if got := download(t, s, result.FileID, "secret"); !bytes.Equal(got, data) {
+		t.Error("decrypted file differs from the upload")
+	}
+}
+
+// TestUploadIdenticalFile uploads the same bytes twice and checks the
+// second upload reuses the first's chunks without storing any
+func TestUploadIdenticalFile(t *testing.T) {
+	s, db, chunks := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+
+	first := upload(t, s, data, UploadMetadata{FileName: "first.bin"})
+	before := chunks.GetStats()
+
+	second := upload(t, s, data, UploadMetadata{FileName: "second.bin"})
+	if !second.FileDeduplicated || second.ChunksStored != 0 {
+		t.Errorf("want the second upload to store no chunks, stored %d (file deduplicated %v)", second.ChunksStored, second.FileDeduplicated)
+	}
+	if fmt.Sprint(second.ChunkHashes) != fmt.Sprint(first.ChunkHashes) {
+		t.Errorf("want the first upload's chunks, got %v", second.ChunkHashes)
+	}
+	if after := chunks.GetStats(); after["unique_chunks"] != before["unique_chunks"] || after["total_references"] != before["total_references"] {
+		t.Errorf("chunk store changed: %v before, %v after", before, after)
+	}
+
+	for _, hash := range first.ChunkHashes {
+		chunk, err := db.GetChunk(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk.RefCount != 2 {
+			t.Errorf("chunk %s: want 2 references, got %d", hash[:8], chunk.RefCount)
+		}
+	}
+	if got := download(t, s, second.FileID, ""); !bytes.Equal(got, data) {
+		t.Error("second upload does not read back")
+	}
+
+	// The copy outlives the original
+	if err := s.PurgeFile(first.FileID); err != nil {
+		t.Fatal(err)
+	}
+	if got := download(t, s, second.FileID, ""); !bytes.Equal(got, data) {
+		t.Error("second upload does not read back once the first is gone")
+	}
+
+	// Different bytes go through chunking
+	data[len(data)-1] ^= 1
+	if third := upload(t, s, data, UploadMetadata{}); third.FileDeduplicated {
+		t.Error("changed file reused another file's chunks")
+	}
+}
+
+// TestUploadDedupDisabled uploads the same bytes several times with
+// deduplication disabled and checks each upload stores a chunk set of its
+// own, sharing nothing with the others or with a deduplicated copy, and
+// still reads back whole and in ranges
+func TestUploadDedupDisabled(t *testing.T) {
+	s, db, chunks := newTestService(t)
+	data := randomBytes(t, 3*chunking.MaxChunkSize)
+
+	shared := upload(t, s, data, UploadMetadata{FileName: "shared.bin"})
+	isolated := []*UploadResult{
+		upload(t, s, data, UploadMetadata{FileName: "isolated.bin", DisableDedup: true}),
+		upload(t, s, data, UploadMetadata{FileName: "isolated.bin", DisableDedup: true}),
+	}
+	// A stream is hashed as it is chunked rather than before
+	streamed, err := s.UploadFile(context.Background(), io.MultiReader(bytes.NewReader(data)), UploadMetadata{FileName: "streamed.bin", Size: int64(len(data)), DisableDedup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	isolated = append(isolated, streamed)
+
+	owner := make(map[string]string)
+	for _, hash := range shared.ChunkHashes {
+		owner[hash] = shared.FileID
+	}
+	salts := make(map[string]bool)
+	for _, result := range isolated {
+		if result.FileDeduplicated || result.ChunksStored != len(result.ChunkHashes) {
+			t.Errorf("%s: want every chunk stored anew, got %+v", result.FileID, result)
+		}
+		for _, hash := range result.ChunkHashes {
+			if other, ok := owner[hash]; ok && other != result.FileID {
+				t.Errorf("%s: chunk %s shared with %s", result.FileID, hash[:8], other)
+			}
+			owner[hash] = result.FileID
+		}
+
+		file, err := db.GetFile(result.FileID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		salt, err := hex.DecodeString(file.DedupSalt)
+		if err != nil || len(salt) != DedupSaltSize || salts[file.DedupSalt] {
+			t.Fatalf("%s: want a salt of its own, got %q", result.FileID, file.DedupSalt)
+		}
+		salts[file.DedupSalt] = true
+		stored, err := chunks.GetChunk(result.ChunkHashes[0])
+		if err != nil || !bytes.HasPrefix(stored, salt) {
+			t.Errorf("%s: want stored chunks to start with the salt (%v)", result.FileID, err)
+		}
+
+		if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+			t.Errorf("%s: download differs from upload", result.FileID)
+		}
+		d, err := s.DownloadFile(context.Background(), result.FileID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, end := int64(chunking.MaxChunkSize-100), int64(chunking.MaxChunkSize+100)
+		var part bytes.Buffer
+		if _, err := d.WriteRange(&part, start, end); err != nil || !bytes.Equal(part.Bytes(), data[start:end]) {
+			t.Errorf("%s: range across a chunk boundary differs (%v)", result.FileID, err)
+		}
+		d.Close()
+	}
+	if file, err := db.GetFile(shared.FileID); err != nil || file.DedupSalt != "" {
+		t.Errorf("deduplicated file: want no salt, got %q (%v)", file.DedupSalt, err)
+	}
+
+	// A deduplicated upload matches the deduplicated copy, never an
+	// isolated one
+	again := upload(t, s, data, UploadMetadata{FileName: "again.bin"})
+	if !again.FileDeduplicated || fmt.Sprint(again.ChunkHashes) != fmt.Sprint(shared.ChunkHashes) {
+		t.Errorf("want the deduplicated copy's chunks reused, got %+v", again)
+	}
+
+	// Removing one isolated copy leaves the others whole
+	if err := s.PurgeFile(isolated[0].FileID); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range isolated[0].ChunkHashes {
+		if chunks.HasChunk(hash) {
+			t.Errorf("chunk %s of the purged file still stored", hash[:8])
+		}
+	}
+	if got := download(t, s, isolated[1].FileID, ""); !bytes.Equal(got, data) {
+		t.Error("isolated copy unreadable once another is purged")
+	}
+
+	// Encrypted chunks are never shared anyway, so they aren't salted
+	encrypted := upload(t, s, data, UploadMetadata{Password: "s3cret", DisableDedup: true})
+	if file, err := db.GetFile(encrypted.FileID); err != nil || file.DedupSalt != "" {
+		t.Errorf("encrypted file: want no salt, got %q (%v)", file.DedupSalt, err)
+	}
+	if got := download(t, s, encrypted.FileID, "s3cret"); !bytes.Equal(got, data) {
+		t.Error("encrypted download differs from upload")
+	}
+}
+
+// discardChunkStore counts stored chunks without keeping their data, so a
+// test can upload more than it could hold in memory
+type discardChunkStore struct {
+	mu   sync.Mutex
+	refs map[string]int
+}
+
+func (d *discardChunkStore) StoreChunk(hash string, data []byte) (string, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.refs[hash]++
+	return "discard:" + hash, d.refs[hash] == 1, nil
+}
+
+func (d *discardChunkStore) GetChunk(hash string) ([]byte, error) {
+	return nil, fmt.Errorf("chunk data discarded: %s", hash)
+}
+
+func (d *discardChunkStore) HasChunk(hash string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.refs[hash] > 0
+}
+
+func (d *discardChunkStore) ReleaseChunk(hash string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.refs[hash]--; d.refs[hash] <= 0 {
+		delete(d.refs, hash)
+	}
+	return nil
+}
+
+func (d *discardChunkStore) DeleteChunk(hash string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.refs, hash)
+	return nil
+}
+
+func (d *discardChunkStore) GetStats() map[string]interface{}        { return nil }
+func (d *discardChunkStore) CheckWritable(ctx context.Context) error { return nil }
+func (d *discardChunkStore) Flush() error                            { return nil }
+
+// syntheticStream yields size pseudo-random bytes without holding them,
+// and can't be seeked, like a multipart part
+type syntheticStream struct {
+	rng       *mathrand.ChaCha8
+	remaining int64
+}
+
+func (r *syntheticStream) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, _ := r.rng.Read(p)
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+// TestUploadMemoryBounded streams a 2GB upload through chunking and
+// storage and checks the heap never grows by more than a few batches of
+// chunk data
+func TestUploadMemoryBounded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("uploads 2GB")
+	}
+
+	const size = 2 << 30
+	store := &discardChunkStore{refs: make(map[string]int)}
+	ring, err := node.NewConsistentHash(node.DefaultVirtualNodesPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewFileService(metadata.NewMemoryStore(), store, node.NewRegistry(testHeartbeatTimeout), ring)
+
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapInuse
+
+	// Sample the heap while the upload runs
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > peak.Load() {
+				peak.Store(stats.HeapInuse)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	stream := &syntheticStream{rng: mathrand.NewChaCha8([32]byte{1}), remaining: size}
+	result, err := s.UploadFile(context.Background(), stream, UploadMetadata{FileName: "large.bin", Size: size})
+	close(done)
+	<-sampled
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Size != size || result.ChunksStored != len(result.ChunkHashes) || len(store.refs) != len(result.ChunkHashes) {
+		t.Errorf("want all %d bytes stored as new chunks, got %d bytes, %d of %d chunks stored", size, result.Size, result.ChunksStored, len(result.ChunkHashes))
+	}
+
+	limit := uint64(4 * UploadBatchBytes)
+	if grown := peak.Load() - min(baseline, peak.Load()); grown > limit {
+		t.Errorf("heap grew by %d MB uploading %d MB, want at most %d MB", grown>>20, size>>20, limit>>20)
+	} else {
+		t.Logf("heap grew by %d MB uploading %d MB", grown>>20, size>>20)
+	}
+}
+
+// readRange downloads bytes [start, end) of a file
+func readRange(t *testing.T, s *FileService, fileID, password string, start, end int64) []byte {
+	t.Helper()
+
+	d, err := s.DownloadFile(context.Background(), fileID, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var buf bytes.Buffer
+	if _, err := d.WriteRange(&buf, start, end); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestInlineThreshold uploads files just under and at the inline threshold,
+// with the default threshold and one above the largest chunk, and checks
+// only those under it are stored whole as a single chunk and all read back
+func TestInlineThreshold(t *testing.T) {
+	for _, threshold := range []int64{DefaultInlineThreshold, 3 * chunking.MaxChunkSize} {
+		for _, size := range []int64{threshold - 1, threshold, threshold + 1} {
+			for _, password := range []string{"", "secret"} {
+				t.Run(fmt.Sprintf("%d/%d/encrypted=%v", threshold, size, password != ""), func(t *testing.T) {
+					s, db, _ := newTestService(t)
+					s.UseInlineThreshold(threshold)
+					data := randomBytes(t, int(size))
+
+					result := upload(t, s, data, UploadMetadata{Password: password})
+					file, err := db.GetFile(result.FileID)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					inline := size < threshold
+					if file.SingleChunk != inline {
+						t.Errorf("want single chunk %v, got %v", inline, file.SingleChunk)
+					}
+					if inline && len(result.ChunkHashes) != 1 {
+						t.Errorf("want the file stored as 1 chunk, got %d", len(result.ChunkHashes))
+					}
+					if !inline && threshold > chunking.MaxChunkSize && len(result.ChunkHashes) < 2 {
+						t.Errorf("want a file over %d bytes chunked, got %d chunk", chunking.MaxChunkSize, len(result.ChunkHashes))
+					}
+					if inline && password == "" && result.ChunkHashes[0] != chunking.SHA256.Sum(data) {
+						t.Error("want the whole file as its chunk")
+					}
+
+					if got := download(t, s, result.FileID, password); !bytes.Equal(got, data) {
+						t.Fatal("file does not read back")
+					}
+					start, end := size/3, size-size/3
+					if got := readRange(t, s, result.FileID, password, start, end); !bytes.Equal(got, data[start:end]) {
+						t.Errorf("range %d-%d does not read back", start, end)
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestInlineThresholdDisabled checks a zero threshold chunks even tiny
+// files, and an empty file is never flagged as a single chunk
+func TestInlineThresholdDisabled(t *testing.T) {
+	s, db, _ := newTestService(t)
+
+	for _, threshold := range []int64{0, DefaultInlineThreshold} {
+		s.UseInlineThreshold(threshold)
+		for _, size := range []int{0, 10} {
+			data := randomBytes(t, size)
+			result := upload(t, s, data, UploadMetadata{})
+			file, err := db.GetFile(result.FileID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := threshold > 0 && size > 0; file.SingleChunk != want {
+				t.Errorf("threshold %d, %d bytes: want single chunk %v, got %v", threshold, size, want, file.SingleChunk)
+			}
+			if got := download(t, s, result.FileID, ""); !bytes.Equal(got, data) {
+				t.Errorf("threshold %d, %d bytes: file does not read back", threshold, size)
+			}
+		}
+	}
+}
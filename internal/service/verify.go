@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/metadata"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+// OrphanChunk is a chunk or shard held by nodes but unknown to the
+// metadata store
+type OrphanChunk struct {
+	Hash  string   `json:"hash"`
+	Nodes []string `json:"nodes"`
+}
+
+// RefCountMismatch is a chunk whose reference count differs from the
+// number of file links to it
+type RefCountMismatch struct {
+	Hash     string `json:"hash"`
+	RefCount int    `json:"ref_count"`
+	Links    int    `json:"links"`
+}
+
+// VerifyReport is the result of cross-checking the metadata store against
+// the chunks the nodes hold
+type VerifyReport struct {
+	NodesChecked       []string           `json:"nodes_checked"`
+	NodesUnreachable   []string           `json:"nodes_unreachable,omitempty"` // Their chunks may be reported lost
+	ChunksChecked      int                `json:"chunks_checked"`
+	Lost               []string           `json:"lost"`    // Recorded, but no copy (or too few shards) found
+	Orphans            []OrphanChunk      `json:"orphans"` // Held by nodes, but not recorded
+	RefCountMismatches []RefCountMismatch `json:"ref_count_mismatches"`
+
+	// Set when repairing
+	Repaired       []string `json:"repaired,omitempty"`      // Lost chunks re-stored from a copy found elsewhere
+	Unrecoverable  []string `json:"unrecoverable,omitempty"` // Lost chunks with no readable copy left
+	OrphansDeleted int      `json:"orphans_deleted,omitempty"`
+}
+
+// VerifyChunks reconciles the chunks table with the chunk listings of the
+// healthy nodes and the local chunk store. It reports chunks that are lost,
+// chunks nodes hold that nothing records, and reference counts that don't
+// match the file links. Chunks of uploads in progress can show up as
+// orphans or mismatches.
+//
+// With repair set, lost chunks are re-stored if a copy can still be read,
+// and orphans are deleted from the nodes holding them. Orphans are checked
+// again before deletion, and uploads wait while they are deleted.
+func (s *FileService) VerifyChunks(ctx context.Context, repair bool) (*VerifyReport, error) {
+	report := &VerifyReport{
+		NodesChecked:       []string{},
+		Lost:               []string{},
+		Orphans:            []OrphanChunk{},
+		RefCountMismatches: []RefCountMismatch{},
+	}
+
+	// List the nodes before reading the metadata, so a chunk stored in
+	// between is recorded by the time it is looked up
+	held := make(map[string][]string) // hash -> node IDs
+	nodes := make(map[string]*node.NodeInfo)
+	for _, nodeInfo := range s.registry.GetHealthyNodes() {
+		hashes, err := s.NodeChunks(ctx, nodeInfo)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Printf("Verify: failed to list chunks on node %s: %v", nodeInfo.NodeID, err)
+			report.NodesUnreachable = append(report.NodesUnreachable, nodeInfo.NodeID)
+			continue
+		}
+		for _, hash := range hashes {
+			held[hash] = append(held[hash], nodeInfo.NodeID)
+		}
+		nodes[nodeInfo.NodeID] = nodeInfo
+		report.NodesChecked = append(report.NodesChecked, nodeInfo.NodeID)
+	}
+
+	sort.Strings(report.NodesChecked)
+
+	audits, err := s.db.AuditChunks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	report.ChunksChecked = len(audits)
+
+	var lost []metadata.ChunkAudit
+	for _, audit := range audits {
+		if audit.RefCount != audit.Links {
+			report.RefCountMismatches = append(report.RefCountMismatches, RefCountMismatch{
+				Hash:     audit.ChunkHash,
+				RefCount: audit.RefCount,
+				Links:    audit.Links,
+			})
+		}
+		if s.chunkLost(audit, held) {
+			report.Lost = append(report.Lost, audit.ChunkHash)
+			lost = append(lost, audit)
+		}
+	}
+
+	known := knownHashes(audits)
+	for hash, nodeIDs := range held {
+		if !known[hash] {
+			sort.Strings(nodeIDs)
+			report.Orphans = append(report.Orphans, OrphanChunk{Hash: hash, Nodes: nodeIDs})
+		}
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool { return report.Orphans[i].Hash < report.Orphans[j].Hash })
+
+	log.Printf("Verify: %d chunks checked on %d nodes: %d lost, %d orphans, %d ref count mismatches",
+		report.ChunksChecked, len(report.NodesChecked), len(report.Lost), len(report.Orphans), len(report.RefCountMismatches))
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, audit := range lost {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := s.restoreLostChunk(ctx, audit); err != nil {
+			log.Printf("Verify: chunk %s is unrecoverable: %v", audit.ChunkHash[:8], err)
+			report.Unrecoverable = append(report.Unrecoverable, audit.ChunkHash)
+			continue
+		}
+		report.Repaired = append(report.Repaired, audit.ChunkHash)
+	}
+
+	deleted, err := s.deleteOrphans(report.Orphans, nodes)
+	if err != nil {
+		return nil, err
+	}
+	report.OrphansDeleted = deleted
+
+	return report, nil
+}
+
+// NodeChunks fetches the hashes of every chunk and shard a node holds, as
+// the node itself reports them
+func (s *FileService) NodeChunks(ctx context.Context, nodeInfo *node.NodeInfo) ([]string, error) {
+	return s.nodeClient(nodeInfo).List(ctx)
+}
+
+// chunkLost reports whether a recorded chunk can no longer be read: no node
+// holds it, an erasure-coded chunk has fewer shards left than it needs, or
+// a locally stored chunk is missing from the local store
+func (s *FileService) chunkLost(audit metadata.ChunkAudit, held map[string][]string) bool {
+	switch {
+	case audit.DataShards > 0:
+		available := 0
+		for _, shardHash := range audit.ShardHashes {
+			if len(held[shardHash]) > 0 {
+				available++
+			}
+		}
+		return available < audit.DataShards
+	case strings.HasPrefix(audit.StoragePath, "distributed:"):
+		return len(held[audit.ChunkHash]) == 0
+	default:
+		return !s.chunks.HasChunk(audit.ChunkHash) && len(held[audit.ChunkHash]) == 0
+	}
+}
+
+// restoreLostChunk re-stores a lost chunk where it belongs, if a good copy
+// can still be read from anywhere (a node that was not listed, or the local
+// store)
+func (s *FileService) restoreLostChunk(ctx context.Context, audit metadata.ChunkAudit) error {
+	if audit.DataShards > 0 {
+		return fmt.Errorf("too few shards left to rebuild it")
+	}
+
+	chunkData, err := s.backgroundRetrieve(ctx, audit.ChunkHash)
+	if err != nil {
+		return fmt.Errorf("no copy left: %w", err)
+	}
+	if _, ok := chunking.MatchHash(chunkData, audit.ChunkHash); !ok {
+		return fmt.Errorf("only copy left is corrupted")
+	}
+
+	if !strings.HasPrefix(audit.StoragePath, "distributed:") {
+		_, _, err := s.chunks.StoreChunk(audit.ChunkHash, chunkData)
+		return err
+	}
+
+	targetNodes, err := s.ring.GetNodes(audit.ChunkHash, max(audit.Replication, 1))
+	if err != nil {
+		return err
+	}
+	var storedOn []string
+	for _, nodeID := range targetNodes {
+		if err := s.backgroundStore(ctx, audit.ChunkHash, chunkData, nodeID); err != nil {
+			log.Printf("Verify: failed to restore chunk %s on node %s: %v", audit.ChunkHash[:8], nodeID, err)
+			continue
+		}
+		storedOn = append(storedOn, nodeID)
+	}
+	if len(storedOn) == 0 {
+		return fmt.Errorf("no node accepted it")
+	}
+	if err := s.db.AddChunkLocations(audit.ChunkHash, storedOn); err != nil {
+		log.Printf("Verify: failed to record locations of chunk %s: %v", audit.ChunkHash[:8], err)
+	}
+
+	log.Printf("Verify: restored lost chunk %s on %d nodes", audit.ChunkHash[:8], len(storedOn))
+	return nil
+}
+
+// deleteOrphans deletes orphans from the nodes that hold them. Uploads are
+// held off while the metadata is read again, so chunks recorded since the
+// report, or stored but not yet recorded, are kept.
+func (s *FileService) deleteOrphans(orphans []OrphanChunk, nodes map[string]*node.NodeInfo) (int, error) {
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+
+	s.storing.Lock()
+	defer s.storing.Unlock()
+
+	audits, err := s.db.AuditChunks()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	known := knownHashes(audits)
+	s.direct.addHashes(known)
+
+	deleted := 0
+	for _, orphan := range orphans {
+		if known[orphan.Hash] {
+			continue
+		}
+
+		failed := false
+		for _, nodeID := range orphan.Nodes {
+			if err := s.deleteChunkFromNode(orphan.Hash, nodes[nodeID]); err != nil {
+				log.Printf("Verify: failed to delete orphan %s from node %s: %v", orphan.Hash[:8], nodeID, err)
+				failed = true
+			}
+		}
+		if !failed {
+			deleted++
+		}
+	}
+
+	log.Printf("Verify: deleted %d orphans", deleted)
+	return deleted, nil
+}
+
+// ErrNoChunkMetadata is returned instead of confirming chunks unreferenced
+// when nothing at all is recorded, which more likely means an empty or wrong
+// metadata store than that every file was deleted
+var ErrNoChunkMetadata = errors.New("no chunk metadata recorded")
+
+// ConfirmUnreferenced returns which of the given chunks and shards no
+// recorded chunk refers to, so a node may delete them. Uploads are held off
+// while the metadata is read, so chunks stored but not yet recorded are
+// never confirmed.
+func (s *FileService) ConfirmUnreferenced(hashes []string) ([]string, error) {
+	s.storing.Lock()
+	defer s.storing.Unlock()
+
+	audits, err := s.db.AuditChunks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	if len(audits) == 0 {
+		return nil, ErrNoChunkMetadata
+	}
+	known := knownHashes(audits)
+	s.direct.addHashes(known)
+
+	unreferenced := []string{}
+	for _, hash := range hashes {
+		if !known[hash] {
+			unreferenced = append(unreferenced, hash)
+		}
+	}
+	return unreferenced, nil
+}
+
+// knownHashes returns the hashes of every recorded chunk and shard
+func knownHashes(audits []metadata.ChunkAudit) map[string]bool {
+	known := make(map[string]bool, len(audits))
+	for _, audit := range audits {
+		known[audit.ChunkHash] = true
+		for _, shardHash := range audit.ShardHashes {
+			known[shardHash] = true
+		}
+	}
+	return known
+}
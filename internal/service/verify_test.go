@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+	"github.com/noorimat/distributed-file-storage/internal/node"
+)
+
+func verifyChunks(t *testing.T, s *FileService, repair bool) *VerifyReport {
+	t.Helper()
+
+	report, err := s.VerifyChunks(context.Background(), repair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// TestVerifyChunks seeds a cluster with a lost chunk, an orphan and a
+// reference count that doesn't match the file links, and checks each is
+// reported, and that repairing deletes the orphan and restores the lost
+// chunk once a copy of it can be read
+func TestVerifyChunks(t *testing.T) {
+	s, db, nodes := newTestCluster(t, 3)
+	ctx := context.Background()
+	clients := make([]*node.NodeClient, len(nodes))
+	for i, sn := range nodes {
+		clients[i] = node.NewNodeClient(http.DefaultClient, "http://"+sn.Address)
+	}
+
+	kept := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{FileName: "kept.bin"})
+	damaged := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{FileName: "damaged.bin"})
+	chunks := len(kept.ChunkHashes) + len(damaged.ChunkHashes)
+
+	// Lost: on no node
+	lost := damaged.ChunkHashes[0]
+	lostData, err := clients[0].Retrieve(ctx, lost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range clients {
+		if err := client.Delete(ctx, lost); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Orphan: on a node, but not recorded
+	orphanData := randomBytes(t, 1000)
+	orphan := chunking.SHA256.Sum(orphanData)
+	if err := clients[0].Store(ctx, orphan, orphanData); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mismatch: a reference without a file link
+	mismatched := kept.ChunkHashes[1]
+	record, err := db.GetChunk(mismatched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChunk(mismatched, record.ChunkSize, record.StoragePath, record.Replication, record.HashAlgorithm); err != nil {
+		t.Fatal(err)
+	}
+
+	report := verifyChunks(t, s, false)
+	if fmt.Sprint(report.NodesChecked) != "[node-1 node-2 node-3]" || len(report.NodesUnreachable) != 0 || report.ChunksChecked != chunks {
+		t.Errorf("want %d chunks checked on all 3 nodes, got %+v", chunks, report)
+	}
+	if len(report.Lost) != 1 || report.Lost[0] != lost {
+		t.Errorf("want chunk %s lost, got %v", lost[:8], report.Lost)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].Hash != orphan || fmt.Sprint(report.Orphans[0].Nodes) != "[node-1]" {
+		t.Errorf("want orphan %s on node-1, got %+v", orphan[:8], report.Orphans)
+	}
+	want := RefCountMismatch{Hash: mismatched, RefCount: 2, Links: 1}
+	if len(report.RefCountMismatches) != 1 || report.RefCountMismatches[0] != want {
+		t.Errorf("want mismatch %+v, got %+v", want, report.RefCountMismatches)
+	}
+	if exists, err := clients[0].Exists(ctx, orphan); err != nil || !exists {
+		t.Errorf("orphan deleted without repair: %v", err)
+	}
+
+	// Repairing deletes the orphan, but has no copy of the lost chunk
+	report = verifyChunks(t, s, true)
+	if len(report.Unrecoverable) != 1 || report.Unrecoverable[0] != lost || len(report.Repaired) != 0 {
+		t.Errorf("want chunk %s unrecoverable, got %+v", lost[:8], report)
+	}
+	if report.OrphansDeleted != 1 {
+		t.Errorf("want 1 orphan deleted, got %d", report.OrphansDeleted)
+	}
+	if exists, err := clients[0].Exists(ctx, orphan); err != nil || exists {
+		t.Errorf("orphan not deleted: %v", err)
+	}
+
+	// With a copy in the local store it is restored to its nodes
+	if _, _, err := s.chunks.StoreChunk(lost, lostData); err != nil {
+		t.Fatal(err)
+	}
+	report = verifyChunks(t, s, true)
+	if len(report.Repaired) != 1 || report.Repaired[0] != lost || len(report.Unrecoverable) != 0 {
+		t.Errorf("want chunk %s repaired, got %+v", lost[:8], report)
+	}
+	for _, sn := range nodes {
+		if !nodeHolds(t, sn, lost) {
+			t.Errorf("restored chunk missing from %s", sn.NodeID)
+		}
+	}
+
+	report = verifyChunks(t, s, false)
+	if len(report.Lost) != 0 || len(report.Orphans) != 0 || len(report.RefCountMismatches) != 1 {
+		t.Errorf("want only the mismatch left, got %+v", report)
+	}
+}
+
+// TestVerifyLocalChunks checks a chunk stored on the coordinator is lost
+// once it is gone from the local store
+func TestVerifyLocalChunks(t *testing.T) {
+	s, _, chunks := newTestService(t)
+	result := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{})
+
+	if report := verifyChunks(t, s, false); len(report.Lost) != 0 || report.ChunksChecked != len(result.ChunkHashes) {
+		t.Errorf("want %d chunks checked and none lost, got %+v", len(result.ChunkHashes), report)
+	}
+
+	lost := result.ChunkHashes[1]
+	if err := chunks.DeleteChunk(lost); err != nil {
+		t.Fatal(err)
+	}
+	if report := verifyChunks(t, s, false); len(report.Lost) != 1 || report.Lost[0] != lost {
+		t.Errorf("want chunk %s lost, got %v", lost[:8], report.Lost)
+	}
+}
+
+// TestConfirmUnreferenced checks only hashes no recorded chunk or shard
+// uses are confirmed, and nothing is while no chunk is recorded at all
+func TestConfirmUnreferenced(t *testing.T) {
+	s, _, _ := newErasureCluster(t)
+
+	unknown := chunking.SHA256.Sum(randomBytes(t, 100))
+	if _, err := s.ConfirmUnreferenced([]string{unknown}); !errors.Is(err, ErrNoChunkMetadata) {
+		t.Fatalf("want ErrNoChunkMetadata with nothing recorded, got %v", err)
+	}
+
+	result := upload(t, s, randomBytes(t, 3*chunking.MaxChunkSize), UploadMetadata{})
+	coding, err := s.db.GetChunkCoding(result.ChunkHashes[0])
+	if err != nil || coding == nil {
+		t.Fatalf("want the chunk erasure-coded: %v", err)
+	}
+
+	asked := []string{result.ChunkHashes[0], coding.Shards[0].ShardHash, unknown}
+	unreferenced, err := s.ConfirmUnreferenced(asked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unreferenced) != 1 || unreferenced[0] != unknown {
+		t.Errorf("want only %s confirmed, got %v", unknown[:8], unreferenced)
+	}
+}
@@ -0,0 +1,92 @@
+// Package shard lays chunk files out in nested directories named after
+// their hash, so no single directory holds too many files
+package shard
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DefaultDepth = 1 // base/ab/abcdef...
+	MaxDepth     = 4
+)
+
+// Path returns where a chunk file lives under base: one directory level per
+// unit of depth, each named by the next two characters of the hash, so depth
+// 2 puts "abcdef..." at base/ab/cd/abcdef.... A hash too short for every
+// level gets as many levels as it has characters for.
+func Path(base, hash string, depth int) string {
+	parts := []string{base}
+	for level := 0; level < depth && len(hash) >= 2*(level+1); level++ {
+		parts = append(parts, hash[2*level:2*(level+1)])
+	}
+	return filepath.Join(append(parts, hash)...)
+}
+
+// ValidateDepth rejects depths outside 1..MaxDepth
+func ValidateDepth(depth int) error {
+	if depth < 1 || depth > MaxDepth {
+		return fmt.Errorf("shard depth must be between 1 and %d, got %d", MaxDepth, depth)
+	}
+	return nil
+}
+
+// Migrate moves chunk files under base that are not where Path puts them for
+// depth, as happens when the depth changes, and removes directories the move
+// left empty. isChunk reports which file names are chunks; directories named
+// in skip are not entered. It returns how many files were moved.
+func Migrate(base string, depth int, isChunk func(name string) bool, skip ...string) (int, error) {
+	moves := make(map[string]string)
+	var dirs []string
+
+	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			for _, name := range skip {
+				if info.Name() == name {
+					return filepath.SkipDir
+				}
+			}
+			if path != base {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+
+		if isChunk(info.Name()) {
+			if want := Path(base, info.Name(), depth); want != path {
+				moves[path] = want
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Moves are collected first so the walk never sees a moved file twice
+	moved := 0
+	for from, to := range moves {
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return moved, err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return moved, fmt.Errorf("failed to move %s: %w", from, err)
+		}
+		moved++
+	}
+
+	// Deepest first; directories that still hold anything stay
+	if moved > 0 {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			os.Remove(dirs[i])
+		}
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,108 @@
+package shard
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	hash := "abcdef0123"
+	for _, tc := range []struct {
+		hash  string
+		depth int
+		want  string
+	}{
+		{hash, 1, "base/ab/abcdef0123"},
+		{hash, 2, "base/ab/cd/abcdef0123"},
+		{hash, 4, "base/ab/cd/ef/01/abcdef0123"},
+
+		// Short hashes get the levels they have characters for
+		{"abc", 2, "base/ab/abc"},
+		{"a", 2, "base/a"},
+		{"", 1, "base"},
+	} {
+		if got := Path("base", tc.hash, tc.depth); got != filepath.FromSlash(tc.want) {
+			t.Errorf("Path(%q, %d) = %s, want %s", tc.hash, tc.depth, got, tc.want)
+		}
+	}
+}
+
+func TestValidateDepth(t *testing.T) {
+	for depth := 1; depth <= MaxDepth; depth++ {
+		if err := ValidateDepth(depth); err != nil {
+			t.Errorf("depth %d: %v", depth, err)
+		}
+	}
+	for _, depth := range []int{-1, 0, MaxDepth + 1} {
+		if err := ValidateDepth(depth); err == nil {
+			t.Errorf("depth %d: want an error", depth)
+		}
+	}
+}
+
+// TestMigrate moves chunk files from depth 1 to 2 and back, and checks
+// other files and skipped directories stay where they are and emptied
+// directories are removed
+func TestMigrate(t *testing.T) {
+	base := t.TempDir()
+	hashes := []string{"aabb0001", "aabb0002", "aacc0003", "ddee0004"}
+	isChunk := func(name string) bool { return len(name) == 8 }
+
+	write := func(path string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(filepath.Base(path)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, hash := range hashes {
+		write(Path(base, hash, 1))
+	}
+	other := filepath.Join(base, "aa", "notes.txt")
+	skipped := filepath.Join(base, "packs", "aaff0005")
+	write(other)
+	write(skipped)
+
+	checkLayout := func(depth int) {
+		t.Helper()
+		for _, hash := range hashes {
+			data, err := os.ReadFile(Path(base, hash, depth))
+			if err != nil || string(data) != hash {
+				t.Errorf("depth %d: chunk %s not in place: %v", depth, hash, err)
+			}
+		}
+		for _, path := range []string{other, skipped} {
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("depth %d: %s moved: %v", depth, path, err)
+			}
+		}
+	}
+
+	moved, err := Migrate(base, 2, isChunk, "packs")
+	if err != nil || moved != len(hashes) {
+		t.Fatalf("want %d chunks moved, got %d: %v", len(hashes), moved, err)
+	}
+	checkLayout(2)
+	if _, err := os.Stat(filepath.Join(base, "dd", "ee")); err != nil {
+		t.Error(err)
+	}
+
+	// Already in place
+	if moved, err := Migrate(base, 2, isChunk, "packs"); err != nil || moved != 0 {
+		t.Errorf("want nothing moved the second time, got %d: %v", moved, err)
+	}
+
+	moved, err = Migrate(base, 1, isChunk, "packs")
+	if err != nil || moved != len(hashes) {
+		t.Fatalf("want %d chunks moved back, got %d: %v", len(hashes), moved, err)
+	}
+	checkLayout(1)
+	for _, dir := range []string{"aa/bb", "aa/cc", "dd/ee"} {
+		if _, err := os.Stat(filepath.Join(base, dir)); !os.IsNotExist(err) {
+			t.Errorf("emptied directory %s left behind", dir)
+		}
+	}
+}
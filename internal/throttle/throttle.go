@@ -0,0 +1,87 @@
+// Package throttle caps the bandwidth of background transfers, so bulk
+// copies such as repairs and scrubs leave room for user traffic
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter lets through at most a set number of bytes per second, shared by
+// every transfer that uses it. Its rate can be changed while transfers are
+// waiting on it.
+type Limiter struct {
+	limiter     *rate.Limiter
+	bytesPerSec atomic.Int64
+}
+
+// NewLimiter returns a Limiter allowing bytesPerSec; zero or less means
+// unlimited
+func NewLimiter(bytesPerSec int64) *Limiter {
+	l := &Limiter{limiter: rate.NewLimiter(rate.Inf, 0)}
+	l.SetRate(bytesPerSec)
+	return l
+}
+
+// SetRate changes the limit to bytesPerSec; zero or less means unlimited.
+// Up to one second's worth of bytes may pass at once.
+func (l *Limiter) SetRate(bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		l.bytesPerSec.Store(0)
+		l.limiter.SetLimit(rate.Inf)
+		return
+	}
+	l.bytesPerSec.Store(bytesPerSec)
+	l.limiter.SetBurst(int(min(bytesPerSec, int64(maxBurst))))
+	l.limiter.SetLimit(rate.Limit(bytesPerSec))
+}
+
+// maxBurst keeps a burst within an int on every platform
+const maxBurst = 1<<31 - 1
+
+// Rate returns the limit in bytes per second, or 0 if unlimited
+func (l *Limiter) Rate() int64 {
+	return l.bytesPerSec.Load()
+}
+
+// WaitN blocks until n more bytes may pass, or ctx is done. Transfers
+// larger than the burst wait for it in pieces.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		if l.limiter.Limit() == rate.Inf {
+			return nil
+		}
+		piece := min(n, max(l.limiter.Burst(), 1))
+		if err := l.limiter.WaitN(ctx, piece); err != nil {
+			if ctx.Err() == nil && piece > l.limiter.Burst() {
+				continue // The rate was lowered meanwhile; wait in smaller pieces
+			}
+			return err
+		}
+		n -= piece
+	}
+	return nil
+}
+
+// Reader returns a reader that passes r's data on no faster than l allows.
+// A read fails with ctx's error once ctx is done.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{r: r, l: l, ctx: ctx}
+}
+
+type reader struct {
+	r   io.Reader
+	l   *Limiter
+	ctx context.Context
+}
+
+func (tr *reader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if waitErr := tr.l.WaitN(tr.ctx, n); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
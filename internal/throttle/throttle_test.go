@@ -0,0 +1,110 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// minDuration is the least time n bytes can take to pass a limiter of
+// bytesPerSec, which lets up to a second's worth through at once
+func minDuration(n, bytesPerSec int64) time.Duration {
+	return time.Duration(n-bytesPerSec) * time.Second / time.Duration(bytesPerSec)
+}
+
+// TestThrottledCopy copies through a limited reader and checks the copy
+// takes at least as long as the rate allows and arrives intact
+func TestThrottledCopy(t *testing.T) {
+	const bytesPerSec = 40 << 10
+	data := make([]byte, bytesPerSec*3/2)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLimiter(bytesPerSec)
+	if l.Rate() != bytesPerSec {
+		t.Errorf("want rate %d, got %d", bytesPerSec, l.Rate())
+	}
+	var copied bytes.Buffer
+	start := time.Now()
+	if _, err := io.Copy(&copied, l.Reader(context.Background(), bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, want := time.Since(start), minDuration(int64(len(data)), bytesPerSec); elapsed < want {
+		t.Errorf("want the copy to take at least %v, took %v", want, elapsed)
+	}
+	if !bytes.Equal(copied.Bytes(), data) {
+		t.Error("copy differs from the original")
+	}
+}
+
+// TestWaitLargerThanBurst checks a wait for more than a second's worth of
+// bytes is let through in pieces, taking as long as the rate allows
+func TestWaitLargerThanBurst(t *testing.T) {
+	const bytesPerSec = 1000
+	l := NewLimiter(bytesPerSec)
+
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 1500); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, want := time.Since(start), minDuration(1500, bytesPerSec); elapsed < want {
+		t.Errorf("want the wait to take at least %v, took %v", want, elapsed)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	for _, bytesPerSec := range []int64{0, -1} {
+		l := NewLimiter(bytesPerSec)
+		if l.Rate() != 0 {
+			t.Errorf("NewLimiter(%d): want rate 0, got %d", bytesPerSec, l.Rate())
+		}
+		start := time.Now()
+		if err := l.WaitN(context.Background(), 1<<40); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("NewLimiter(%d): want no wait, took %v", bytesPerSec, elapsed)
+		}
+	}
+}
+
+// TestSetRateDuringCopy lifts the limit on a copy that would otherwise take
+// many minutes and checks it finishes soon after
+func TestSetRateDuringCopy(t *testing.T) {
+	l := NewLimiter(1000)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, l.Reader(context.Background(), bytes.NewReader(make([]byte, 1<<20))))
+		done <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	l.SetRate(0)
+	if l.Rate() != 0 {
+		t.Errorf("want rate 0 once lifted, got %d", l.Rate())
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("copy still running 5s after the limit was lifted")
+	}
+}
+
+func TestReaderCanceled(t *testing.T) {
+	l := NewLimiter(1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := io.Copy(io.Discard, l.Reader(ctx, bytes.NewReader(make([]byte, 5000))))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+// Package version describes the running build: its version, commit and build
+// date, set at build time with -ldflags, and the cluster protocol it speaks
+package version
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Protocol is the version of the protocol coordinators and storage nodes
+// speak to each other, and MinProtocol the oldest a coordinator still works
+// with:
+//
+//	1: nodes report nothing about the transports they serve
+//	2: nodes report their transports, so the coordinator picks one they serve
+const (
+	Protocol    = 2
+	MinProtocol = 1
+)
+
+// Set at build time, for example:
+//
+//	go build -ldflags "-X github.com/noorimat/distributed-file-storage/internal/version.Version=v1.2.0" ./cmd/api-server
+//
+// A commit or build date left unset is taken from the VCS information the Go
+// toolchain embeds, when there is any.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Protocol  int    `json:"protocol_version"`
+}
+
+// Get returns the running build's Info
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		Protocol:  Protocol,
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = build.GoVersion
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// Compatible reports whether a peer speaking protocol can work with this
+// build. Peers that report no protocol predate it being reported, and speak
+// the first one.
+func Compatible(protocol int) bool {
+	if protocol == 0 {
+		protocol = 1
+	}
+	return protocol >= MinProtocol && protocol <= Protocol
+}
+
+// String formats the build for logs
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, protocol %d)", i.Version, i.Commit, i.BuildDate, i.Protocol)
+}
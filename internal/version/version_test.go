@@ -0,0 +1,48 @@
+package version
+
+import "testing"
+
+// setBuild sets the build values as -ldflags would, until the test ends
+func setBuild(t *testing.T, version, commit, buildDate string) {
+	t.Helper()
+
+	saved := [3]string{Version, Commit, BuildDate}
+	t.Cleanup(func() { Version, Commit, BuildDate = saved[0], saved[1], saved[2] })
+	Version, Commit, BuildDate = version, commit, buildDate
+}
+
+// TestGet checks the injected build values are reported as set, and that
+// unset ones are filled in rather than left empty
+func TestGet(t *testing.T) {
+	setBuild(t, "v1.4.0", "9f2c1e0", "2026-10-16T08:00:00Z")
+	info := Get()
+	if info.Version != "v1.4.0" || info.Commit != "9f2c1e0" || info.BuildDate != "2026-10-16T08:00:00Z" || info.Protocol != Protocol {
+		t.Errorf("want the injected values and protocol %d, got %+v", Protocol, info)
+	}
+	if info.GoVersion == "" {
+		t.Error("want the Go version reported")
+	}
+
+	setBuild(t, "dev", "", "")
+	info = Get()
+	if info.Version != "dev" || info.Commit == "" || info.BuildDate == "" {
+		t.Errorf("unset build values: want them filled in, got %+v", info)
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	for _, tc := range []struct {
+		protocol int
+		want     bool
+	}{
+		{0, true}, // Nodes too old to report one speak protocol 1
+		{-1, false},
+		{MinProtocol, true},
+		{Protocol, true},
+		{Protocol + 1, false},
+	} {
+		if got := Compatible(tc.protocol); got != tc.want {
+			t.Errorf("protocol %d: want compatible %v, got %v", tc.protocol, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// FileInfo is a file as listed by GET /files
+type FileInfo struct {
+	FileID          string    `json:"file_id"`
+	FileName        string    `json:"file_name"`
+	Version         int       `json:"version"`
+	Size            int64     `json:"file_size"`
+	Encrypted       bool      `json:"encrypted"`
+	ClientEncrypted bool      `json:"client_encrypted"`
+	ContentType     string    `json:"content_type"`
+	Replication     int       `json:"replication"`
+	UploadedAt      time.Time `json:"uploaded_at"`
+}
+
+// NodeInfo is a storage node as listed by GET /nodes
+type NodeInfo struct {
+	NodeID      string    `json:"node_id"`
+	Address     string    `json:"address"`
+	Status      string    `json:"status"` // "healthy", "degraded" or "offline"
+	TotalChunks int       `json:"total_chunks"`
+	Used        int64     `json:"used"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// VerifyReport is the result of reconciling the coordinator's chunk
+// metadata with what the nodes hold; see POST /chunks/verify
+type VerifyReport struct {
+	NodesChecked     []string `json:"nodes_checked"`
+	NodesUnreachable []string `json:"nodes_unreachable"`
+	ChunksChecked    int      `json:"chunks_checked"`
+	Lost             []string `json:"lost"`
+	Orphans          []struct {
+		Hash  string   `json:"hash"`
+		Nodes []string `json:"nodes"`
+	} `json:"orphans"`
+	RefCountMismatches []struct {
+		Hash     string `json:"hash"`
+		RefCount int    `json:"ref_count"`
+		Links    int    `json:"links"`
+	} `json:"ref_count_mismatches"`
+
+	// Set when repairing
+	Repaired       []string `json:"repaired"`
+	Unrecoverable  []string `json:"unrecoverable"`
+	OrphansDeleted int      `json:"orphans_deleted"`
+}
+
+// List returns every file the coordinator stores, excluding the trash
+func (c *Client) List() ([]FileInfo, error) {
+	var result struct {
+		Files []FileInfo `json:"files"`
+	}
+	if err := c.getJSON("/files", &result); err != nil {
+		return nil, err
+	}
+	return result.Files, nil
+}
+
+// Delete moves a file to the trash
+func (c *Client) Delete(fileID string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.BaseURL+"/files/"+url.PathEscape(fileID), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Stats returns the coordinator's deduplication and storage statistics,
+// keyed as in GET /stats
+func (c *Client) Stats() (map[string]interface{}, error) {
+	var stats map[string]interface{}
+	if err := c.getJSON("/stats", &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Nodes returns the storage nodes registered with the coordinator
+func (c *Client) Nodes() ([]NodeInfo, error) {
+	var result struct {
+		Nodes []NodeInfo `json:"nodes"`
+	}
+	if err := c.getJSON("/nodes", &result); err != nil {
+		return nil, err
+	}
+	return result.Nodes, nil
+}
+
+// Verify cross-checks the coordinator's chunk metadata against the chunks
+// on the nodes. With repair set, lost chunks are re-stored where a copy
+// exists and orphans are deleted.
+func (c *Client) Verify(repair bool) (*VerifyReport, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/chunks/verify?repair=%t", c.BaseURL, repair), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var report VerifyReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode verify report: %w", err)
+	}
+	return &report, nil
+}
+
+// getJSON GETs path and decodes the response into v
+func (c *Client) getJSON(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+// Package client is a Go client for the coordinator's HTTP API
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to a coordinator. The zero HTTPClient uses http.DefaultClient.
+type Client struct {
+	BaseURL    string       // e.g. "http://localhost:8080"
+	Token      string       // Optional API token, sent as a bearer token
+	HTTPClient *http.Client // Optional
+}
+
+// New returns a client for the coordinator at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Error is returned when the coordinator answers with a non-2xx status
+type Error struct {
+	StatusCode int
+	Code       string // Stable error code such as "FILE_NOT_FOUND"; empty if the body had none
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("server returned %d (%s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// parseError reads a coordinator error body, {"error": {"code", "message"}},
+// falling back to the raw body for anything else (e.g. a proxy's error page)
+func parseError(statusCode int, body []byte) *Error {
+	var parsed struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil && parsed.Error.Code != "" {
+		return &Error{StatusCode: statusCode, Code: parsed.Error.Code, Message: parsed.Error.Message}
+	}
+	return &Error{StatusCode: statusCode, Message: strings.TrimSpace(string(body))}
+}
+
+// UploadOptions are the optional fields of an upload
+type UploadOptions struct {
+	Name        string // Logical name; defaults to the file name
+	Password    string // Enables server-side encryption
+	ContentType string
+
+	// EncryptionAlgorithm picks the cipher for server-side encryption,
+	// "AES-256-GCM" or "ChaCha20-Poly1305"; the server's default if empty
+	EncryptionAlgorithm string
+
+	// DisableDedup keeps the file's chunks from being shared with any
+	// other file, even an identical one, at the cost of storing them again
+	DisableDedup bool
+
+	// TTL makes the file expire that long after the upload; once expired
+	// it is no longer listed or served and the coordinator purges it.
+	// Zero keeps the file until it is deleted.
+	TTL time.Duration
+
+	// IdempotencyKey makes retries safe: an upload repeated with the same
+	// key returns the first upload's result instead of storing a new file
+	IdempotencyKey string
+
+	// Set by UploadEncrypted; the server stores them without interpreting them
+	clientEncryption string
+}
+
+// UploadResult is the coordinator's response to an upload
+type UploadResult struct {
+	FileID       string   `json:"file_id"`
+	FileName     string   `json:"file_name"`
+	Version      int      `json:"version"`
+	Size         int64    `json:"size"`
+	ChunkHashes  []string `json:"chunk_hashes"`
+	ChunksStored int      `json:"chunks_stored"`
+	DedupRatio   float64  `json:"dedup_ratio"`
+	Encrypted    bool     `json:"encrypted"`
+	MinReplicas  int      `json:"min_replicas,omitempty"`
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Set when TTL was given
+}
+
+// FileLayout is the subset of GET /download/{id}/metadata the client uses
+type FileLayout struct {
+	FileID           string `json:"file_id"`
+	FileName         string `json:"file_name"`
+	Version          int    `json:"version"`
+	Size             int64  `json:"size"`
+	Encrypted        bool   `json:"encrypted"`
+	ContentType      string `json:"content_type"`
+	ClientEncrypted  bool   `json:"client_encrypted"`
+	ClientEncryption string `json:"client_encryption"`
+}
+
+// Upload streams r to the coordinator as fileName. The body is written
+// while it is sent, so r is never held in memory.
+func (c *Client) Upload(fileName string, r io.Reader, opts UploadOptions) (*UploadResult, error) {
+	body, form := io.Pipe()
+	writer := multipart.NewWriter(form)
+
+	go func() {
+		form.CloseWithError(writeUploadForm(writer, fileName, r, opts))
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/upload", body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if opts.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", opts.IdempotencyKey)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result UploadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode upload response: %w", err)
+	}
+	return &result, nil
+}
+
+// writeUploadForm writes the multipart fields of an upload, file last
+func writeUploadForm(writer *multipart.Writer, fileName string, r io.Reader, opts UploadOptions) error {
+	fields := map[string]string{
+		"name":                 opts.Name,
+		"password":             opts.Password,
+		"encryption_algorithm": opts.EncryptionAlgorithm,
+	}
+	if opts.clientEncryption != "" {
+		fields["client_encrypted"] = "true"
+		fields["client_encryption"] = opts.clientEncryption
+	}
+	if opts.DisableDedup {
+		fields["dedup"] = "false"
+	}
+	if opts.TTL != 0 {
+		fields["ttl"] = opts.TTL.String()
+	}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := writer.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition",
+		fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(fileName)))
+	header.Set("Content-Type", contentType)
+
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// ErrChecksumMismatch is returned by Download when the received contents
+// don't match the file's SHA-256 as reported by the coordinator
+var ErrChecksumMismatch = errors.New("downloaded contents do not match the file's checksum")
+
+// Download writes a file's contents to w. The password is only needed for
+// files encrypted by the server. Files the coordinator reports a hash for
+// are verified once fully written; w has all the data by then, so discard it
+// on ErrChecksumMismatch.
+func (c *Client) Download(fileID string, w io.Writer, password string) error {
+	resp, err := c.download(fileID, password)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	expected := resp.Header.Get("X-Content-SHA256")
+	if expected == "" {
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hasher), resp.Body); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != expected {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expected, actual)
+	}
+	return nil
+}
+
+func (c *Client) download(fileID, password string) (*http.Response, error) {
+	endpoint := c.BaseURL + "/download/" + url.PathEscape(fileID)
+	if password != "" {
+		endpoint += "?password=" + url.QueryEscape(password)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+// Layout fetches a file's metadata without its contents
+func (c *Client) Layout(fileID string) (*FileLayout, error) {
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL+"/download/"+url.PathEscape(fileID)+"/metadata", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var layout FileLayout
+	if err := json.NewDecoder(resp.Body).Decode(&layout); err != nil {
+		return nil, fmt.Errorf("failed to decode file metadata: %w", err)
+	}
+	return &layout, nil
+}
+
+// do sends a request, turning non-2xx responses into *Error
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, parseError(resp.StatusCode, message)
+	}
+
+	return resp, nil
+}
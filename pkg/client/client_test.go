@@ -0,0 +1,141 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// storedFile is an upload as the fake coordinator received it
+type storedFile struct {
+	name   string
+	data   []byte
+	hash   string // SHA-256 of data as uploaded, sent as X-Content-SHA256
+	fields map[string]string
+}
+
+// fakeCoordinator keeps uploads in memory and serves them back through the
+// endpoints the client uses
+type fakeCoordinator struct {
+	mu    sync.Mutex
+	files map[string]*storedFile
+}
+
+// newFakeCoordinator starts a fake coordinator and returns a client for it
+func newFakeCoordinator(t *testing.T) (*fakeCoordinator, *Client) {
+	t.Helper()
+
+	f := &fakeCoordinator{files: make(map[string]*storedFile)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /upload", f.upload)
+	mux.HandleFunc("GET /download/{id}", f.download)
+	mux.HandleFunc("GET /download/{id}/metadata", f.metadata)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return f, New(server.URL)
+}
+
+func (f *fakeCoordinator) upload(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file := &storedFile{fields: make(map[string]string)}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			file.name, file.data = part.FileName(), data
+		} else {
+			file.fields[part.FormName()] = string(data)
+		}
+	}
+
+	sum := sha256.Sum256(file.data)
+	file.hash = hex.EncodeToString(sum[:])
+
+	f.mu.Lock()
+	fileID := fmt.Sprintf("file-%d", len(f.files)+1)
+	f.files[fileID] = file
+	f.mu.Unlock()
+
+	json.NewEncoder(w).Encode(UploadResult{FileID: fileID, FileName: file.name, Size: int64(len(file.data))})
+}
+
+// file returns an upload by ID, or nil
+func (f *fakeCoordinator) file(fileID string) *storedFile {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.files[fileID]
+}
+
+func (f *fakeCoordinator) download(w http.ResponseWriter, r *http.Request) {
+	file := f.file(r.PathValue("id"))
+	if file == nil {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error": {"code": "FILE_NOT_FOUND", "message": "file not found"}}`)
+		return
+	}
+	w.Header().Set("X-Content-SHA256", file.hash)
+	w.Write(file.data)
+}
+
+func (f *fakeCoordinator) metadata(w http.ResponseWriter, r *http.Request) {
+	file := f.file(r.PathValue("id"))
+	if file == nil {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error": {"code": "FILE_NOT_FOUND", "message": "file not found"}}`)
+		return
+	}
+	json.NewEncoder(w).Encode(FileLayout{
+		FileID:           r.PathValue("id"),
+		FileName:         file.name,
+		Size:             int64(len(file.data)),
+		ClientEncrypted:  file.fields["client_encrypted"] == "true",
+		ClientEncryption: file.fields["client_encryption"],
+	})
+}
+
+// TestDownloadVerifiesChecksum checks Download accepts contents matching the
+// X-Content-SHA256 the coordinator sends, and reports any that don't
+func TestDownloadVerifiesChecksum(t *testing.T) {
+	f, c := newFakeCoordinator(t)
+	data := bytes.Repeat([]byte("checksum "), 1000)
+	result, err := c.Upload("test.txt", bytes.NewReader(data), UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Download(result.FileID, &buf, ""); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("download differs: %v", err)
+	}
+
+	f.file(result.FileID).data[0] ^= 0xff
+	buf.Reset()
+	if err := c.Download(result.FileID, &buf, ""); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("want ErrChecksumMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/noorimat/distributed-file-storage/internal/chunking"
+)
+
+// grantHeader carries a chunk grant to a storage node, as node.GrantHeader
+const grantHeader = "X-Chunk-Grant"
+
+// ErrFileChanged is returned by UploadDirect when the file's contents
+// differ between chunking it and sending its chunks
+var ErrFileChanged = errors.New("file changed during upload")
+
+// directChunk is one chunk of a file, as listed in a direct upload request
+type directChunk struct {
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+}
+
+// directPlan is the subset of the coordinator's direct upload plan the
+// client uses
+type directPlan struct {
+	UploadID string         `json:"upload_id"`
+	Chunks   []plannedChunk `json:"chunks"`
+}
+
+type plannedChunk struct {
+	Hash    string        `json:"hash"`
+	Stored  bool          `json:"stored"`
+	Targets []chunkTarget `json:"targets"`
+}
+
+type chunkTarget struct {
+	NodeID string `json:"node_id"`
+	URL    string `json:"url"`
+	Grant  string `json:"grant"`
+}
+
+// UploadDirect uploads r as fileName without its data passing through the
+// coordinator: the file is chunked here, the coordinator says which chunks
+// it lacks and which storage nodes to send them to, and each is PUT to
+// those nodes straight from this process. r is read twice, once to chunk it
+// and once to send the chunks, so only one chunk is held in memory at a
+// time. The nodes must be reachable from the client, with HTTPClient
+// trusting their certificates if they serve TLS.
+//
+// Server-side encryption and disabling deduplication both change the
+// chunks' bytes on the coordinator, so opts.Password must be empty and
+// opts.DisableDedup unset.
+func (c *Client) UploadDirect(fileName string, r io.ReadSeeker, opts UploadOptions) (*UploadResult, error) {
+	if opts.Password != "" {
+		return nil, fmt.Errorf("direct uploads can't be encrypted by the server; use UploadEncrypted")
+	}
+	if opts.DisableDedup {
+		return nil, fmt.Errorf("direct uploads are always deduplicated; use Upload")
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []directChunk
+	err = eachChunk(r, func(chunk *chunking.Chunk) error {
+		chunks = append(chunks, directChunk{Hash: chunk.Hash, Size: chunk.Size})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to chunk file: %w", err)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = fileName
+	}
+	plan, err := c.planDirect(map[string]interface{}{
+		"file_name":         name,
+		"content_type":      opts.ContentType,
+		"hash_algorithm":    string(chunking.DefaultHashAlgorithm),
+		"client_encrypted":  opts.clientEncryption != "",
+		"client_encryption": opts.clientEncryption,
+		"chunks":            chunks,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	planned := make(map[string]int, len(plan.Chunks))
+	for i, chunk := range plan.Chunks {
+		planned[chunk.Hash] = i
+	}
+	sent := make(map[string]bool)
+	err = eachChunk(r, func(chunk *chunking.Chunk) error {
+		i, ok := planned[chunk.Hash]
+		if !ok {
+			return ErrFileChanged
+		}
+		if plan.Chunks[i].Stored || sent[chunk.Hash] {
+			return nil
+		}
+		sent[chunk.Hash] = true
+		return c.sendChunk(chunk, plan.Chunks[i].Targets)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/upload/direct/"+url.PathEscape(plan.UploadID)+"/complete", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result UploadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode upload response: %w", err)
+	}
+	return &result, nil
+}
+
+// eachChunk splits r into content-defined chunks the way the coordinator
+// does, calling fn with each
+func eachChunk(r io.Reader, fn func(*chunking.Chunk) error) error {
+	reader := chunking.NewChunkReader(r)
+	defer reader.Close()
+
+	for {
+		chunk, err := reader.NextChunk()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// planDirect asks the coordinator where to send a file's chunks
+func (c *Client) planDirect(request interface{}) (*directPlan, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/upload/direct", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var plan directPlan
+	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+		return nil, fmt.Errorf("failed to decode upload plan: %w", err)
+	}
+	return &plan, nil
+}
+
+// sendChunk PUTs a chunk to every target node at once. It only fails if no
+// node took it; the coordinator tops up replicas the others missed.
+func (c *Client) sendChunk(chunk *chunking.Chunk, targets []chunkTarget) error {
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.putChunk(target.URL, target.Grant, chunk.Data); err != nil {
+				errs[i] = fmt.Errorf("node %s: %w", target.NodeID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to store chunk %s on any node: %w", chunk.Hash[:8], errors.Join(errs...))
+}
+
+// putChunk sends a chunk's raw bytes to one node. Nodes are called without
+// the API token, which is only for the coordinator.
+func (c *Client) putChunk(endpoint, grant string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if grant != "" {
+		req.Header.Set(grantHeader, grant)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/noorimat/distributed-file-storage/internal/crypto"
+)
+
+const (
+	// StreamAlgorithm names the scheme in a file's client_encryption
+	// parameters: AES-256-GCM over fixed-size segments of the plaintext
+	StreamAlgorithm = "AES-256-GCM-STREAM"
+
+	// SegmentSize is the plaintext size of every segment but the last
+	SegmentSize = 64 << 10
+)
+
+var (
+	ErrNotClientEncrypted = errors.New("file is not client-encrypted")
+	ErrDecryptionFailed   = errors.New("decryption failed - wrong passphrase or modified data")
+)
+
+// EncryptionParams are stored by the server as the opaque client_encryption
+// field. None of them is secret.
+type EncryptionParams struct {
+	Algorithm   string `json:"algorithm"`
+	Salt        string `json:"salt"`         // Hex-encoded PBKDF2 salt
+	NoncePrefix string `json:"nonce_prefix"` // Hex-encoded; see crypto.ChunkNonce
+	SegmentSize int    `json:"segment_size"`
+}
+
+// UploadEncrypted encrypts r with a key derived from passphrase and uploads
+// the ciphertext. The passphrase and key never leave this process; the
+// server only receives ciphertext and the parameters needed to re-derive the
+// key from the passphrase.
+func (c *Client) UploadEncrypted(fileName string, r io.Reader, passphrase string, opts UploadOptions) (*UploadResult, error) {
+	if opts.Password != "" {
+		return nil, fmt.Errorf("password must be empty for client-encrypted uploads")
+	}
+
+	key, err := crypto.DeriveKey(passphrase, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	defer key.Zero()
+	prefix, err := crypto.NewNoncePrefix()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce prefix: %w", err)
+	}
+
+	params, err := json.Marshal(EncryptionParams{
+		Algorithm:   StreamAlgorithm,
+		Salt:        hex.EncodeToString(key.Salt),
+		NoncePrefix: hex.EncodeToString(prefix),
+		SegmentSize: SegmentSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	opts.clientEncryption = string(params)
+
+	aead, err := newAEAD(key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, plaintext := io.Pipe()
+	go func() {
+		plaintext.CloseWithError(sealStream(plaintext, r, aead, prefix, SegmentSize))
+	}()
+	defer ciphertext.Close()
+
+	return c.Upload(fileName, ciphertext, opts)
+}
+
+// DownloadEncrypted downloads a client-encrypted file and writes its
+// plaintext to w. Segments are verified as they arrive, so if an error is
+// returned, anything already written to w must be discarded.
+func (c *Client) DownloadEncrypted(fileID string, w io.Writer, passphrase string) error {
+	layout, err := c.Layout(fileID)
+	if err != nil {
+		return err
+	}
+	if !layout.ClientEncrypted {
+		return ErrNotClientEncrypted
+	}
+
+	var params EncryptionParams
+	if err := json.Unmarshal([]byte(layout.ClientEncryption), &params); err != nil {
+		return fmt.Errorf("invalid client encryption parameters: %w", err)
+	}
+	if params.Algorithm != StreamAlgorithm {
+		return fmt.Errorf("unsupported client encryption algorithm %q", params.Algorithm)
+	}
+	if params.SegmentSize <= 0 {
+		return fmt.Errorf("invalid segment size %d", params.SegmentSize)
+	}
+
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return fmt.Errorf("invalid salt: %w", err)
+	}
+	prefix, err := hex.DecodeString(params.NoncePrefix)
+	if err != nil {
+		return fmt.Errorf("invalid nonce prefix: %w", err)
+	}
+
+	key, err := crypto.DeriveKey(passphrase, salt)
+	if err != nil {
+		return fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	defer key.Zero()
+	aead, err := newAEAD(key.Key)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.download(fileID, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return openStream(w, resp.Body, aead, prefix, params.SegmentSize)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealStream encrypts src segment by segment. Each segment's nonce comes
+// from its index, and the index and a final-segment flag are authenticated,
+// so segments cannot be reordered, dropped or truncated unnoticed. An empty
+// input still produces one (empty) final segment.
+func sealStream(dst io.Writer, src io.Reader, aead cipher.AEAD, prefix []byte, segmentSize int) error {
+	current := make([]byte, segmentSize)
+	next := make([]byte, segmentSize)
+
+	n, err := readSegment(src, current)
+	if err != nil {
+		return err
+	}
+
+	for index := uint64(0); ; index++ {
+		// Read ahead so the last segment can be marked as final
+		var m int
+		final := n < segmentSize
+		if !final {
+			if m, err = readSegment(src, next); err != nil {
+				return err
+			}
+			final = m == 0
+		}
+
+		nonce, err := crypto.ChunkNonce(prefix, index)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(aead.Seal(nil, nonce, current[:n], segmentAAD(index, final))); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+		current, next, n = next, current, m
+	}
+}
+
+// openStream reverses sealStream
+func openStream(dst io.Writer, src io.Reader, aead cipher.AEAD, prefix []byte, segmentSize int) error {
+	sealedSize := segmentSize + aead.Overhead()
+	current := make([]byte, sealedSize)
+	next := make([]byte, sealedSize)
+
+	n, err := readSegment(src, current)
+	if err != nil {
+		return err
+	}
+
+	for index := uint64(0); ; index++ {
+		var m int
+		final := n < sealedSize
+		if !final {
+			if m, err = readSegment(src, next); err != nil {
+				return err
+			}
+			final = m == 0
+		}
+
+		nonce, err := crypto.ChunkNonce(prefix, index)
+		if err != nil {
+			return err
+		}
+		plaintext, err := aead.Open(nil, nonce, current[:n], segmentAAD(index, final))
+		if err != nil {
+			return ErrDecryptionFailed
+		}
+		if _, err := dst.Write(plaintext); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+		current, next, n = next, current, m
+	}
+}
+
+// readSegment fills buf, returning a short count only at the end of r
+func readSegment(r io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, nil
+	}
+	return n, err
+}
+
+// segmentAAD is the additional data authenticated with a segment: its
+// 8-byte big-endian index and a final-segment flag
+func segmentAAD(index uint64, final bool) []byte {
+	aad := make([]byte, 9)
+	binary.BigEndian.PutUint64(aad, index)
+	if final {
+		aad[8] = 1
+	}
+	return aad
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestEncryptedRoundTrip uploads files encrypted by the client, at sizes
+// around segment boundaries, and checks the server only ever sees
+// ciphertext and parameters, and each file decrypts with its passphrase
+func TestEncryptedRoundTrip(t *testing.T) {
+	coordinator, c := newFakeCoordinator(t)
+
+	for _, size := range []int{0, 1, SegmentSize - 1, SegmentSize, SegmentSize + 1, 3*SegmentSize + 100} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			data := make([]byte, size)
+			if _, err := rand.Read(data); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := c.UploadEncrypted("secret.bin", bytes.NewReader(data), "passphrase", UploadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stored := coordinator.file(result.FileID)
+			if _, sent := stored.fields["password"]; sent {
+				t.Error("a password was sent to the server")
+			}
+			// Plaintext too short might turn up in the ciphertext by chance
+			if size >= 16 && bytes.Contains(stored.data, data) {
+				t.Error("the server received the plaintext")
+			}
+			if bytes.Contains(stored.data, []byte("passphrase")) || bytes.Contains([]byte(stored.fields["client_encryption"]), []byte("passphrase")) {
+				t.Error("the server received the passphrase")
+			}
+
+			var params EncryptionParams
+			if err := json.Unmarshal([]byte(stored.fields["client_encryption"]), &params); err != nil {
+				t.Fatal(err)
+			}
+			if stored.fields["client_encrypted"] != "true" || params.Algorithm != StreamAlgorithm || params.SegmentSize != SegmentSize {
+				t.Errorf("want the upload flagged client-encrypted with %s, got %v", StreamAlgorithm, stored.fields)
+			}
+
+			var got bytes.Buffer
+			if err := c.DownloadEncrypted(result.FileID, &got, "passphrase"); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), data) {
+				t.Error("decrypted file differs from the upload")
+			}
+
+			if err := c.DownloadEncrypted(result.FileID, &bytes.Buffer{}, "wrong"); !errors.Is(err, ErrDecryptionFailed) {
+				t.Errorf("wrong passphrase: want ErrDecryptionFailed, got %v", err)
+			}
+		})
+	}
+}
+
+// TestEncryptedTampering checks modified, truncated or extended ciphertext
+// fails to decrypt
+func TestEncryptedTampering(t *testing.T) {
+	coordinator, c := newFakeCoordinator(t)
+	data := make([]byte, 2*SegmentSize+10)
+	result, err := c.UploadEncrypted("secret.bin", bytes.NewReader(data), "passphrase", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := coordinator.file(result.FileID)
+	original := stored.data
+	sealed := SegmentSize + 16 // A full segment and its GCM tag
+
+	for _, tc := range []struct {
+		name   string
+		tamper func([]byte) []byte
+	}{
+		{"flipped bit", func(b []byte) []byte { b[10] ^= 1; return b }},
+		{"last segment dropped", func(b []byte) []byte { return b[:2*sealed] }},
+		{"segments swapped", func(b []byte) []byte {
+			return append(append(append([]byte(nil), b[sealed:2*sealed]...), b[:sealed]...), b[2*sealed:]...)
+		}},
+		{"data appended", func(b []byte) []byte { return append(b, 0) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			coordinator.mu.Lock()
+			stored.data = tc.tamper(append([]byte(nil), original...))
+			coordinator.mu.Unlock()
+
+			if err := c.DownloadEncrypted(result.FileID, &bytes.Buffer{}, "passphrase"); !errors.Is(err, ErrDecryptionFailed) {
+				t.Errorf("want ErrDecryptionFailed, got %v", err)
+			}
+		})
+	}
+}
+
+// TestEncryptedRejected checks a server-side password can't be combined
+// with client encryption, and a plain file isn't decrypted
+func TestEncryptedRejected(t *testing.T) {
+	_, c := newFakeCoordinator(t)
+
+	if _, err := c.UploadEncrypted("secret.bin", bytes.NewReader(nil), "passphrase", UploadOptions{Password: "server"}); err == nil {
+		t.Error("want a server-side password rejected")
+	}
+
+	result, err := c.Upload("plain.bin", bytes.NewReader([]byte("plain")), UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DownloadEncrypted(result.FileID, &bytes.Buffer{}, "passphrase"); !errors.Is(err, ErrNotClientEncrypted) {
+		t.Errorf("want ErrNotClientEncrypted, got %v", err)
+	}
+}